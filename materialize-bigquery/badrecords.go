@@ -0,0 +1,30 @@
+package main
+
+import (
+	"cloud.google.com/go/bigquery"
+	log "github.com/sirupsen/logrus"
+)
+
+// applyBadRecords configures the external table of a binding's staged documents to skip up to
+// the configured number of malformed rows, rather than failing the query which merges them.
+func (c *config) applyBadRecords(edc *bigquery.ExternalDataConfig) {
+	edc.MaxBadRecords = c.MaxBadRecords
+	edc.IgnoreUnknownValues = c.IgnoreUnknownValues
+}
+
+// logSkippedRecords logs the rows which a successful job skipped, and returns how many there
+// were. BigQuery reports each skipped row as a non-fatal error in the status of the job.
+func logSkippedRecords(status *bigquery.JobStatus) int {
+	if status == nil || len(status.Errors) == 0 {
+		return 0
+	}
+	for _, e := range status.Errors {
+		log.WithFields(log.Fields{
+			"location": e.Location,
+			"reason":   e.Reason,
+			"message":  e.Message,
+		}).Warn("skipped bad record")
+	}
+	log.WithField("skipped", len(status.Errors)).Warn("bad records were skipped while merging staged files")
+	return len(status.Errors)
+}
@@ -42,13 +42,30 @@ func (credential) Name() string {
 
 // Config represents the endpoint configuration for BigQuery.
 type config struct {
-	BillingProjectID string     `json:"billing_project_id,omitempty" jsonschema:"title=Billing Project ID,description=Billing Project ID connected to the BigQuery dataset. It can be the same value as Project ID."`
-	ProjectID        string     `json:"project_id" jsonschema:"title=Project ID,description=Google Cloud Project ID that owns the BigQuery dataset."`
-	Dataset          string     `json:"dataset" jsonschema:"title=Dataset,description=BigQuery dataset that will be used to store the materialization output."`
-	Region           string     `json:"region" jsonschema:"title=Region,description=Region where both the Bucket and the BigQuery dataset is located. They both need to be within the same region."`
-	Bucket           string     `json:"bucket" jsonschema:"title=Bucket,description=Google Cloud Storage bucket that is going to be used to store specfications & temporary data before merging into BigQuery."`
-	BucketPath       string     `json:"bucket_path" jsonschema:"title=Bucket Path,description=A prefix that will be used to store objects to Google Cloud Storage's bucket."`
-	CredentialsJSON  credential `json:"credentials_json" jsonschema:"title=Credentials,description=Google Cloud Service Account JSON credentials in base64 format." jsonschema_extras:"secret=true,multiline=true"`
+	BillingProjectID    string            `json:"billing_project_id,omitempty" jsonschema:"title=Billing Project ID,description=Billing Project ID connected to the BigQuery dataset. It can be the same value as Project ID."`
+	ProjectID           string            `json:"project_id" jsonschema:"title=Project ID,description=Google Cloud Project ID that owns the BigQuery dataset."`
+	Dataset             string            `json:"dataset" jsonschema:"title=Dataset,description=BigQuery dataset that will be used to store the materialization output."`
+	Region              string            `json:"region" jsonschema:"title=Region,description=Region where both the Bucket and the BigQuery dataset is located. They both need to be within the same region."`
+	Bucket              string            `json:"bucket,omitempty" jsonschema:"title=Bucket,description=Google Cloud Storage bucket that is going to be used to store specfications & temporary data before merging into BigQuery. It must be located where the dataset can be loaded from."`
+	StagingBuckets      []string          `json:"staging_buckets,omitempty" jsonschema:"title=Staging Buckets,description=Additional Google Cloud Storage buckets which may be used to stage data. The first bucket located in a location that the dataset can be loaded from is used with Bucket being tried first. Bucket may be left empty when these are given." jsonschema_extras:"advanced=true"`
+	BucketPath          string            `json:"bucket_path" jsonschema:"title=Bucket Path,description=A prefix that will be used to store objects to Google Cloud Storage's bucket."`
+	CredentialsJSON     credential        `json:"credentials_json" jsonschema:"title=Credentials,description=Google Cloud Service Account JSON credentials in base64 format." jsonschema_extras:"secret=true,multiline=true"`
+	StagingCleanup      string            `json:"staging_cleanup,omitempty" jsonschema:"title=Staging Cleanup,description=What to do with staged Cloud Storage objects once they have been successfully loaded into BigQuery. Objects of failed loads are always kept.,enum=delete,enum=keep,enum=archive-to-prefix,default=delete" jsonschema_extras:"advanced=true"`
+	ArchivePrefix       string            `json:"staging_archive_prefix,omitempty" jsonschema:"title=Staging Archive Prefix,description=Prefix within the bucket to move staged objects to when using the 'archive-to-prefix' staging cleanup policy." jsonschema_extras:"advanced=true"`
+	LoadedAtColumn      string            `json:"loaded_at_column,omitempty" jsonschema:"title=Loaded At Column,description=Name of a TIMESTAMP column to add to each table which records when each row was loaded. For example '_loaded_at'. Leave empty to omit the column." jsonschema_extras:"advanced=true"`
+	BatchIDColumn       string            `json:"batch_id_column,omitempty" jsonschema:"title=Batch ID Column,description=Name of a STRING column to add to each table which identifies the transaction that loaded each row. For example '_batch_id'. Leave empty to omit the column." jsonschema_extras:"advanced=true"`
+	SoftDeleteColumn    string            `json:"soft_delete_column,omitempty" jsonschema:"title=Soft Delete Column,description=Name of the BOOL column which marks deleted rows of tables using soft deletes. Defaults to '_deleted'." jsonschema_extras:"advanced=true"`
+	SoftDeletedAtColumn string            `json:"soft_deleted_at_column,omitempty" jsonschema:"title=Soft Deleted At Column,description=Name of the TIMESTAMP column which records when each row was deleted in tables using soft deletes. Defaults to '_deleted_at'." jsonschema_extras:"advanced=true"`
+	NumericStrings      bool              `json:"numeric_strings,omitempty" jsonschema:"title=Numeric Strings,description=Materialize string fields having a format of 'integer' or 'number' as BIGNUMERIC columns so that their values are loaded exactly instead of as STRING columns. Existing tables must be re-created after this is changed." jsonschema_extras:"advanced=true"`
+	NumericOverflow     string            `json:"numeric_overflow,omitempty" jsonschema:"title=Numeric Overflow,description=What to do with numbers having more decimal digits than the scale of their NUMERIC or BIGNUMERIC column allows. They either fail the materialization or are rounded or truncated to the scale of the column.,enum=error,enum=round,enum=truncate,default=error" jsonschema_extras:"advanced=true"`
+	InvalidStrings      string            `json:"invalid_formatted_strings,omitempty" jsonschema:"title=Invalid Formatted Strings,description=What to do with the values of string fields which are materialized as DATE or TIMESTAMP or BIGNUMERIC columns according to their format but can't be parsed as such. They either fail the materialization or are materialized as null.,enum=error,enum=null,default=error" jsonschema_extras:"advanced=true"`
+	TableExpiration     int               `json:"table_expiration_seconds,omitempty" jsonschema:"title=Table Expiration Seconds,description=Number of seconds after their creation at which materialized tables expire and are deleted by BigQuery. Leave empty or zero for tables which never expire." jsonschema_extras:"advanced=true"`
+	DatasetExpiration   int               `json:"dataset_table_expiration_seconds,omitempty" jsonschema:"title=Dataset Table Expiration Seconds,description=Default table expiration in seconds to set on the dataset. It applies to tables created in the dataset without an expiration of their own. The tables used by Flow to store checkpoints never expire. Leave empty or zero to leave the dataset unchanged." jsonschema_extras:"advanced=true"`
+	MaxBadRecords       int64             `json:"max_bad_records,omitempty" jsonschema:"title=Max Bad Records,description=Maximum number of malformed rows of each staged file which BigQuery may skip rather than failing the transaction. Skipped rows are logged. Leave empty or zero to fail on any bad row." jsonschema_extras:"advanced=true"`
+	IgnoreUnknownValues bool              `json:"ignore_unknown_values,omitempty" jsonschema:"title=Ignore Unknown Values,description=Ignore values of staged rows which don't match a column of the table instead of treating the row as bad." jsonschema_extras:"advanced=true"`
+	BadRecordsPrefix    string            `json:"bad_records_prefix,omitempty" jsonschema:"title=Bad Records Prefix,description=Prefix within the bucket to move the staged files of transactions which skipped bad rows to. This quarantines them for inspection regardless of the staging cleanup policy. Requires max_bad_records." jsonschema_extras:"advanced=true"`
+	ValidateStagedRows  int               `json:"validate_staged_rows,omitempty" jsonschema:"title=Validate Staged Rows,description=Number of rows of each table per transaction to check against the schema of the table as they're staged. A row which doesn't fit fails the transaction with an error naming each incompatible column instead of failing the merge job. Checking more rows finds more problems but slows down staging. Leave empty or zero to not check rows." jsonschema_extras:"advanced=true"`
+	JobLabels           map[string]string `json:"job_labels,omitempty" jsonschema:"title=Job Labels,description=Labels to attach to every BigQuery job run by the materialization so that its costs can be attributed in billing exports. Keys and values may only contain lowercase letters and digits and underscores and dashes." jsonschema_extras:"advanced=true"`
 }
 
 func (c *config) Validate() error {
@@ -61,10 +78,61 @@ func (c *config) Validate() error {
 	if c.Region == "" {
 		return fmt.Errorf("expected region")
 	}
-	if c.Bucket == "" {
+	if c.Bucket == "" && len(c.StagingBuckets) == 0 {
 		return fmt.Errorf("expected bucket")
 	}
-	return nil
+	for _, bucket := range c.StagingBuckets {
+		if bucket == "" {
+			return fmt.Errorf("staging_buckets must not contain an empty bucket name")
+		}
+	}
+	switch c.StagingCleanup {
+	case "", stagingCleanupDelete, stagingCleanupKeep:
+		if c.ArchivePrefix != "" {
+			return fmt.Errorf("staging_archive_prefix is only used with the %q staging cleanup policy", stagingCleanupArchive)
+		}
+	case stagingCleanupArchive:
+		if c.ArchivePrefix == "" {
+			return fmt.Errorf("expected staging_archive_prefix for the %q staging cleanup policy", stagingCleanupArchive)
+		}
+	default:
+		return fmt.Errorf("invalid staging_cleanup %q", c.StagingCleanup)
+	}
+	switch c.NumericOverflow {
+	case "", numericOverflowError, numericOverflowRound, numericOverflowTruncate:
+	default:
+		return fmt.Errorf("invalid numeric_overflow %q", c.NumericOverflow)
+	}
+	switch c.InvalidStrings {
+	case "", invalidStringsError, invalidStringsNull:
+	default:
+		return fmt.Errorf("invalid invalid_formatted_strings %q", c.InvalidStrings)
+	}
+	if c.TableExpiration < 0 {
+		return fmt.Errorf("invalid table_expiration_seconds %d: must not be negative", c.TableExpiration)
+	}
+	if c.DatasetExpiration < 0 {
+		return fmt.Errorf("invalid dataset_table_expiration_seconds %d: must not be negative", c.DatasetExpiration)
+	}
+	if c.MaxBadRecords < 0 {
+		return fmt.Errorf("invalid max_bad_records %d: must not be negative", c.MaxBadRecords)
+	}
+	if c.BadRecordsPrefix != "" && c.MaxBadRecords == 0 {
+		return fmt.Errorf("bad_records_prefix requires max_bad_records")
+	}
+	if c.ValidateStagedRows < 0 {
+		return fmt.Errorf("invalid validate_staged_rows %d: must not be negative", c.ValidateStagedRows)
+	}
+	if c.ValidateStagedRows != 0 && c.MaxBadRecords != 0 {
+		return fmt.Errorf("validate_staged_rows cannot be used with max_bad_records, which skips bad rows instead of failing")
+	}
+	if err := validateJobLabels(c.JobLabels); err != nil {
+		return err
+	}
+	if err := c.metadataColumns().validate(); err != nil {
+		return err
+	}
+	return c.softDeleteColumns().validate(c.metadataColumns())
 }
 
 // DatasetPath returns the sqlDriver.ResourcePath including the dataset.
@@ -75,17 +143,70 @@ func (c *config) DatasetPath(path ...string) sqlDriver.ResourcePath {
 type tableConfig struct {
 	base *config
 
-	Table string `json:"table" jsonschema:"title=Table,description=Table in the BigQuery dataset to store materialized result in."`
-	Delta bool   `json:"delta_updates,omitempty" jsonschema:"default=true,title=Delta Update,description=Should updates to this table be done via delta updates. Defaults is false."`
+	Table      string            `json:"table" jsonschema:"title=Table,description=Table in the BigQuery dataset to store materialized result in."`
+	Delta      bool              `json:"delta_updates,omitempty" jsonschema:"default=true,title=Delta Update,description=Should updates to this table be done via delta updates. Defaults is false."`
+	SoftDelete bool              `json:"soft_delete,omitempty" jsonschema:"title=Soft Delete,description=Mark the rows of deleted documents as deleted instead of removing them from the table. Not applicable to delta updates."`
+	NotMatched string            `json:"not_matched_deletes,omitempty" jsonschema:"title=Not Matched Deletes,description=How deletes of keys which have no row in the table are merged. 'insert-tombstone' inserts a row marked as deleted and requires soft deletes. Defaults to 'insert-tombstone' for tables using soft deletes and 'ignore' otherwise.,enum=ignore,enum=insert-tombstone"`
+	JobLabels  map[string]string `json:"job_labels,omitempty" jsonschema:"title=Job Labels,description=Labels to attach to the BigQuery jobs which load and merge the documents of this table in addition to the job labels of the endpoint. They override endpoint labels with the same key."`
+	View       string            `json:"view,omitempty" jsonschema:"title=View,description=Name of a view in the BigQuery dataset to create over the table using the View Query. It's re-created whenever the materialization is applied."`
+	ViewQuery  string            `json:"view_query,omitempty" jsonschema:"title=View Query,description=SELECT statement defining the view. It must select from the table by referencing it as {table} and may only reference the columns of the table." jsonschema_extras:"multiline=true"`
 }
 
+const (
+	// Deletes of keys without a row are dropped.
+	notMatchedDeletesIgnore = "ignore"
+	// Deletes of keys without a row insert a row which is marked as deleted.
+	notMatchedDeletesTombstone = "insert-tombstone"
+)
+
 func (c *tableConfig) Validate() error {
 	if c.Table == "" {
 		return fmt.Errorf("expected table")
 	}
+	if c.SoftDelete && c.Delta {
+		return fmt.Errorf("soft_delete cannot be used with delta_updates")
+	}
+	switch c.NotMatched {
+	case "", notMatchedDeletesIgnore:
+	case notMatchedDeletesTombstone:
+		if !c.SoftDelete {
+			return fmt.Errorf("not_matched_deletes %q requires soft_delete", c.NotMatched)
+		}
+	default:
+		return fmt.Errorf("invalid not_matched_deletes %q: must be %q or %q", c.NotMatched, notMatchedDeletesIgnore, notMatchedDeletesTombstone)
+	}
+	if err := validateJobLabels(c.JobLabels); err != nil {
+		return err
+	}
+	if err := c.validateView(); err != nil {
+		return err
+	}
+	if c.base != nil {
+		var combined = make(map[string]bool)
+		for key := range c.base.JobLabels {
+			combined[key] = true
+		}
+		for key := range c.JobLabels {
+			combined[key] = true
+		}
+		if len(combined) > maxJobLabels {
+			return fmt.Errorf("too many job_labels: the %d labels of the table and endpoint exceed the maximum of %d", len(combined), maxJobLabels)
+		}
+	}
 	return nil
 }
 
+// softDeleteColumns returns the soft-delete columns of the table, which are disabled
+// unless the table uses soft deletes.
+func (c tableConfig) softDeleteColumns() softDeleteColumns {
+	if !c.SoftDelete {
+		return softDeleteColumns{}
+	}
+	var cols = c.base.softDeleteColumns()
+	cols.tombstones = c.NotMatched != notMatchedDeletesIgnore
+	return cols
+}
+
 // Path returns the sqlDriver.ResourcePath for a table.
 func (c tableConfig) Path() sqlDriver.ResourcePath {
 	return c.base.DatasetPath(c.Table)
@@ -103,7 +224,12 @@ func newBigQueryDriver() *sqlDriver.Driver {
 		EndpointSpecType: config{},
 		ResourceSpecType: &tableConfig{},
 		NewResource: func(endpoint sqlDriver.Endpoint) sqlDriver.Resource {
-			return &tableConfig{base: endpoint.(*Endpoint).config}
+			var ep = endpoint.(*Endpoint)
+			var resource = &tableConfig{base: ep.config}
+			// Keep track of the resource so that CreateTableStatement can add the
+			// soft-delete columns to its table, once it has been parsed.
+			ep.resources = append(ep.resources, resource)
+			return resource
 		},
 		NewEndpoint: func(ctx context.Context, raw json.RawMessage) (sqlDriver.Endpoint, error) {
 			var parsed = new(config)
@@ -112,11 +238,16 @@ func newBigQueryDriver() *sqlDriver.Driver {
 			}
 
 			log.WithFields(log.Fields{
-				"project_id":  parsed.ProjectID,
-				"dataset":     parsed.Dataset,
-				"region":      parsed.Region,
-				"bucket":      parsed.Bucket,
-				"bucket_path": parsed.BucketPath,
+				"project_id":      parsed.ProjectID,
+				"dataset":         parsed.Dataset,
+				"region":          parsed.Region,
+				"bucket":          parsed.Bucket,
+				"staging_buckets": parsed.StagingBuckets,
+				"bucket_path":     parsed.BucketPath,
+				"staging_cleanup": parsed.StagingCleanup,
+				"loaded_at_col":   parsed.LoadedAtColumn,
+				"batch_id_col":    parsed.BatchIDColumn,
+				"soft_delete_col": parsed.SoftDeleteColumn,
 			}).Info("opening bigquery")
 
 			var clientOpts []option.ClientOption
@@ -146,7 +277,7 @@ func newBigQueryDriver() *sqlDriver.Driver {
 				config:             parsed,
 				bigQueryClient:     bigQueryClient,
 				cloudStorageClient: cloudStorageClient,
-				generator:          SQLGenerator(),
+				generator:          SQLGenerator(parsed.NumericStrings, parsed.InvalidStrings),
 				flowTables:         sqlDriver.DefaultFlowTables(parsed.ProjectID + "." + parsed.Dataset + "."), // Prefix with project ID and dataset
 			}, nil
 		},
@@ -162,14 +293,30 @@ func newBigQueryDriver() *sqlDriver.Driver {
 				fence:    sdFence.(*fence),
 				bindings: make([]*binding, len(spec.Bindings)),
 			}
+			if err := t.ep.resolveStagingBucket(ctx); err != nil {
+				return nil, err
+			}
 
 			// Create the bindings for this transactor
 			for bindingPos, spec := range spec.Bindings {
 				var target = sqlDriver.ResourcePath(spec.ResourcePath).Join()
-				t.bindings[bindingPos], err = newBinding(t.ep.generator, bindingPos, target, spec)
+				var resource = resources[bindingPos].(*tableConfig)
+				b, err := newBinding(t.ep.generator, t.ep.config.metadataColumns(), resource.softDeleteColumns(), bindingPos, target, spec)
 				if err != nil {
 					return nil, fmt.Errorf("%s: %w", target, err)
 				}
+
+				// The scales of numeric columns are those of the existing table, which may
+				// differ from the BIGNUMERIC columns that the connector creates.
+				schema, err := fetchTableSchema(ctx, t.ep.bigQueryClient, resource)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %w", target, err)
+				}
+				b.store.numerics = newNumericCoercer(t.ep.config.NumericOverflow, numericColumns(b.store.extDataConfig.Schema, schema))
+				b.store.validator = newRowValidator(t.ep.config.ValidateStagedRows, target, b.store.extDataConfig.Schema, schema)
+				b.labels = resource.JobLabels
+				t.ep.config.applyBadRecords(b.store.extDataConfig)
+				t.bindings[bindingPos] = b
 			}
 			return t, nil
 		},
@@ -183,8 +330,11 @@ func identifierSanitizer(text string) string {
 	return identifierSanitizerRegexp.ReplaceAllString(text, "_")
 }
 
-// SQLGenerator returns a SQLGenerator for the BigQuery SQL dialect.
-func SQLGenerator() sqlDriver.Generator {
+// SQLGenerator returns a SQLGenerator for the BigQuery SQL dialect. If numericStrings is set,
+// string fields having a format of "integer" or "number" are mapped to BIGNUMERIC columns. The
+// values of string fields which are mapped to a column of another type according to their format
+// are parsed when they're staged, and invalidStrings is the policy for those which can't be.
+func SQLGenerator(numericStrings bool, invalidStrings string) sqlDriver.Generator {
 	var jsonMapper = sqlDriver.ConstColumnType{
 		SQLType: "STRING",
 		ValueConverter: func(i interface{}) (interface{}, error) {
@@ -201,19 +351,40 @@ func SQLGenerator() sqlDriver.Generator {
 		},
 	}
 
+	// Integer values of numbers are staged as exact decimal literals, rather than as floats.
+	var numberMapper = sqlDriver.ConstColumnType{
+		SQLType:        "BIGNUMERIC",
+		ValueConverter: numberValue,
+	}
+	var stringFormats = map[string]sqlDriver.TypeMapper{
+		"date": sqlDriver.ConstColumnType{
+			SQLType:        "DATE",
+			ValueConverter: formattedStringValue("date", invalidStrings, parseDate),
+		},
+		"date-time": sqlDriver.ConstColumnType{
+			SQLType:        "TIMESTAMP",
+			ValueConverter: formattedStringValue("date-time", invalidStrings, parseDateTime),
+		},
+	}
+	if numericStrings {
+		var numericStringMapper = sqlDriver.ConstColumnType{
+			SQLType:        "BIGNUMERIC",
+			ValueConverter: formattedStringValue("numeric", invalidStrings, parseNumeric),
+		}
+		stringFormats["integer"] = numericStringMapper
+		stringFormats["number"] = numericStringMapper
+	}
+
 	var typeMappings = sqlDriver.ColumnTypeMapper{
 		sqlDriver.ARRAY:   jsonMapper,
 		sqlDriver.BINARY:  sqlDriver.RawConstColumnType("BYTES"),
 		sqlDriver.BOOLEAN: sqlDriver.RawConstColumnType("BOOL"),
 		sqlDriver.INTEGER: sqlDriver.RawConstColumnType("INT64"),
-		sqlDriver.NUMBER:  sqlDriver.RawConstColumnType("BIGNUMERIC"),
+		sqlDriver.NUMBER:  numberMapper,
 		sqlDriver.OBJECT:  jsonMapper,
 		sqlDriver.STRING: sqlDriver.StringTypeMapping{
-			Default: sqlDriver.RawConstColumnType("STRING"),
-			ByFormat: map[string]sqlDriver.TypeMapper{
-				"date":      sqlDriver.RawConstColumnType("DATE"),
-				"date-time": sqlDriver.RawConstColumnType("TIMESTAMP"),
-			},
+			Default:  sqlDriver.RawConstColumnType("STRING"),
+			ByFormat: stringFormats,
 		},
 	}
 
@@ -1,16 +1,27 @@
 package main
 
 import (
+	"bytes"
 	"context"
 	"database/sql"
 	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
 	"testing"
+	"time"
 
+	"cloud.google.com/go/bigquery"
 	"github.com/bradleyjkemp/cupaloy"
 	"github.com/estuary/connectors/testsupport"
 	"github.com/estuary/flow/go/protocols/catalog"
+	"github.com/estuary/flow/go/protocols/fdb/tuple"
 	pf "github.com/estuary/flow/go/protocols/flow"
 	pm "github.com/estuary/flow/go/protocols/materialize"
+	sqlDriver "github.com/estuary/flow/go/protocols/materialize/sql"
+	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/require"
 )
 
@@ -23,8 +34,8 @@ func TestQueryGeneration(t *testing.T) {
 			return err
 		}))
 
-	generator := SQLGenerator()
-	binding, err := newBinding(generator, 123, "test", spec.Bindings[0])
+	generator := SQLGenerator(false, "")
+	binding, err := newBinding(generator, metadataColumns{}, softDeleteColumns{}, 123, "test", spec.Bindings[0])
 	require.Nil(t, err)
 
 	// Note the intentional missing semicolon, as this is a subquery.
@@ -39,20 +50,21 @@ func TestQueryGeneration(t *testing.T) {
 	require.Equal(t, `
 		MERGE INTO `+"`test`"+` AS l
 		USING flow_temp_store_123 AS r
-		`+"ON l.`key1` = r.`key1` AND l.`key2` = r.`key2`"+`
+		`+"ON l.`key1` = r.`key1` AND l.`key2` = r.`key2` AND l.`key1` BETWEEN ? AND ?"+`
 		WHEN MATCHED AND r.`+"`flow_document`"+` IS NULL THEN
 			DELETE
 		WHEN MATCHED THEN
 			`+"UPDATE SET l.`boolean` = r.`boolean`, l.`integer` = r.`integer`, l.`number` = r.`number`, l.`string` = r.`string`, l.`flow_document` = r.`flow_document`"+`
-		WHEN NOT MATCHED THEN
+		WHEN NOT MATCHED AND r.`+"`flow_document`"+` IS NOT NULL THEN
 			`+"INSERT (`key1`, `key2`, `boolean`, `integer`, `number`, `string`, `flow_document`)"+`
 			`+"VALUES (r.`key1`, r.`key2`, r.`boolean`, r.`integer`, r.`number`, r.`string`, r.`flow_document`)"+`
 		;`,
 		binding.store.sql)
+	require.NotNil(t, binding.store.keyRange)
 
 	// Enable delta mode binding and test again.
 	spec.Bindings[0].DeltaUpdates = true
-	binding, err = newBinding(generator, 123, "test", spec.Bindings[0])
+	binding, err = newBinding(generator, metadataColumns{}, softDeleteColumns{}, 123, "test", spec.Bindings[0])
 	require.Nil(t, err)
 
 	require.Equal(t, `
@@ -60,9 +72,193 @@ func TestQueryGeneration(t *testing.T) {
 		`+"SELECT `key1`, `key2`, `boolean`, `integer`, `number`, `string`, `flow_document` FROM flow_temp_store_123"+`
 		;`,
 		binding.store.sql)
+	require.Nil(t, binding.store.keyRange)
 
 }
 
+func TestMetadataColumns(t *testing.T) {
+	var spec *pf.MaterializationSpec
+	require.NoError(t, testsupport.CatalogExtract(t, "testdata/flow.yaml",
+		func(db *sql.DB) error {
+			var err error
+			spec, err = catalog.LoadMaterialization(db, "test/sqlite")
+			return err
+		}))
+
+	var generator = SQLGenerator(false, "")
+	var metadata = metadataColumns{loadedAt: "_loaded_at", batchID: "_batch_id"}
+	binding, err := newBinding(generator, metadata, softDeleteColumns{}, 123, "test", spec.Bindings[0])
+	require.NoError(t, err)
+
+	require.Contains(t, binding.store.sql, "UPDATE SET l.`boolean` = r.`boolean`, l.`integer` = r.`integer`, l.`number` = r.`number`, l.`string` = r.`string`, l.`flow_document` = r.`flow_document`, l.`_loaded_at` = r.`_loaded_at`, l.`_batch_id` = r.`_batch_id`")
+	require.Contains(t, binding.store.sql, "INSERT (`key1`, `key2`, `boolean`, `integer`, `number`, `string`, `flow_document`, `_loaded_at`, `_batch_id`)")
+	require.Contains(t, binding.store.sql, "VALUES (r.`key1`, r.`key2`, r.`boolean`, r.`integer`, r.`number`, r.`string`, r.`flow_document`, r.`_loaded_at`, r.`_batch_id`)")
+
+	// Staged rows are populated with the values of the transaction's batch.
+	var staged bytes.Buffer
+	var file = &ExternalDataConnectionFile{
+		edc:         binding.store.extDataConfig,
+		jsonEncoder: json.NewEncoder(&staged),
+	}
+	var batch = metadataBatch{
+		loadedAt: time.Date(2022, 6, 1, 12, 30, 15, 123456789, time.FixedZone("EST", -5*60*60)),
+		id:       "the-batch",
+	}
+	var row = []interface{}{int64(1), true, false, int64(2), 3.5, "four", json.RawMessage(`{}`)}
+	require.NoError(t, file.WriteRow(append(row, metadata.values(batch)...)))
+
+	var decoded map[string]interface{}
+	require.NoError(t, json.Unmarshal(staged.Bytes(), &decoded))
+	require.Equal(t, "2022-06-01T17:30:15.123456Z", decoded["_loaded_at"])
+	require.Equal(t, "the-batch", decoded["_batch_id"])
+
+	var schema = binding.store.extDataConfig.Schema
+	require.Equal(t, "_loaded_at", schema[len(schema)-2].Name)
+	require.Equal(t, bigquery.TimestampFieldType, schema[len(schema)-2].Type)
+	require.Equal(t, "_batch_id", schema[len(schema)-1].Name)
+	require.Equal(t, bigquery.StringFieldType, schema[len(schema)-1].Type)
+
+	// Delta updates insert the metadata columns as well.
+	spec.Bindings[0].DeltaUpdates = true
+	binding, err = newBinding(generator, metadata, softDeleteColumns{}, 123, "test", spec.Bindings[0])
+	require.NoError(t, err)
+	require.Contains(t, binding.store.sql, "SELECT `key1`, `key2`, `boolean`, `integer`, `number`, `string`, `flow_document`, `_loaded_at`, `_batch_id` FROM flow_temp_store_123")
+
+	// A metadata column may not have the same name as a field.
+	_, err = newBinding(generator, metadataColumns{loadedAt: "STRING"}, softDeleteColumns{}, 123, "test", spec.Bindings[0])
+	require.Error(t, err)
+}
+
+func TestMetadataColumnsValidate(t *testing.T) {
+	require.NoError(t, metadataColumns{}.validate())
+	require.NoError(t, metadataColumns{loadedAt: "_loaded_at", batchID: "_batch_id"}.validate())
+	require.Error(t, metadataColumns{loadedAt: "loaded at"}.validate())
+	require.Error(t, metadataColumns{batchID: "1batch"}.validate())
+	require.Error(t, metadataColumns{loadedAt: "_meta", batchID: "_META"}.validate())
+}
+
+func TestSoftDelete(t *testing.T) {
+	var spec *pf.MaterializationSpec
+	require.NoError(t, testsupport.CatalogExtract(t, "testdata/flow.yaml",
+		func(db *sql.DB) error {
+			var err error
+			spec, err = catalog.LoadMaterialization(db, "test/sqlite")
+			return err
+		}))
+
+	var generator = SQLGenerator(false, "")
+	var softDelete = (&config{}).softDeleteColumns()
+	binding, err := newBinding(generator, metadataColumns{}, softDelete, 123, "test", spec.Bindings[0])
+	require.NoError(t, err)
+
+	// A deleted document marks its row as deleted rather than removing it, and storing the
+	// document again unmarks it.
+	require.Equal(t, `
+		MERGE INTO `+"`test`"+` AS l
+		USING flow_temp_store_123 AS r
+		`+"ON l.`key1` = r.`key1` AND l.`key2` = r.`key2` AND l.`key1` BETWEEN ? AND ?"+`
+		WHEN MATCHED AND r.`+"`flow_document`"+` IS NULL THEN
+			`+"UPDATE SET l.`_deleted` = TRUE, l.`_deleted_at` = CURRENT_TIMESTAMP()"+`
+		WHEN MATCHED THEN
+			`+"UPDATE SET l.`boolean` = r.`boolean`, l.`integer` = r.`integer`, l.`number` = r.`number`, l.`string` = r.`string`, l.`flow_document` = r.`flow_document`, l.`_deleted` = FALSE, l.`_deleted_at` = NULL"+`
+		WHEN NOT MATCHED THEN
+			`+"INSERT (`key1`, `key2`, `boolean`, `integer`, `number`, `string`, `flow_document`, `_deleted`, `_deleted_at`)"+`
+			`+"VALUES (r.`key1`, r.`key2`, r.`boolean`, r.`integer`, r.`number`, r.`string`, r.`flow_document`, r.`flow_document` IS NULL, IF(r.`flow_document` IS NULL, CURRENT_TIMESTAMP(), NULL))"+`
+		;`,
+		binding.store.sql)
+	require.NotContains(t, binding.store.sql, "DELETE")
+
+	// Soft-deleted rows aren't loaded.
+	require.Contains(t, binding.load.sql, "ON l.`key1` = r.`key1` AND l.`key2` = r.`key2` AND l.`_deleted` IS NOT TRUE")
+
+	// The columns are added to the tables of bindings using soft deletes.
+	var cfg = &config{ProjectID: "project", Dataset: "dataset", SoftDeleteColumn: "is_deleted"}
+	var ep = &Endpoint{config: cfg, generator: generator}
+	var softTable = &tableConfig{base: cfg, Table: "soft", SoftDelete: true}
+	var hardTable = &tableConfig{base: cfg, Table: "hard"}
+	ep.resources = []*tableConfig{softTable, hardTable}
+
+	for _, tc := range []struct {
+		resource *tableConfig
+		expected bool
+	}{
+		{softTable, true},
+		{hardTable, false},
+	} {
+		var table = sqlDriver.TableForMaterialization(tc.resource.Path().Join(), "", generator.IdentifierRenderer, spec.Bindings[0])
+		statement, err := ep.CreateTableStatement(table)
+		require.NoError(t, err)
+		require.Equal(t, tc.expected, strings.Contains(statement, "`is_deleted` BOOL"), statement)
+		require.Equal(t, tc.expected, strings.Contains(statement, "`_deleted_at` TIMESTAMP"), statement)
+	}
+
+	// Soft deletes don't apply to delta updates.
+	require.Error(t, (&tableConfig{Table: "soft", SoftDelete: true, Delta: true}).Validate())
+
+	// The column names must be valid and distinct.
+	require.Error(t, (&config{SoftDeleteColumn: "is deleted"}).softDeleteColumns().validate(metadataColumns{}))
+	require.Error(t, (&config{SoftDeleteColumn: "_deleted_AT"}).softDeleteColumns().validate(metadataColumns{}))
+	require.Error(t, (&config{}).softDeleteColumns().validate(metadataColumns{loadedAt: "_deleted"}))
+	require.NoError(t, (&config{}).softDeleteColumns().validate(metadataColumns{loadedAt: "_loaded_at"}))
+}
+
+func TestNotMatchedDeletes(t *testing.T) {
+	var spec *pf.MaterializationSpec
+	require.NoError(t, testsupport.CatalogExtract(t, "testdata/flow.yaml",
+		func(db *sql.DB) error {
+			var err error
+			spec, err = catalog.LoadMaterialization(db, "test/sqlite")
+			return err
+		}))
+
+	var generator = SQLGenerator(false, "")
+	var cfg = &config{}
+	var ignored = "WHEN NOT MATCHED AND r.`flow_document` IS NOT NULL THEN"
+
+	// A delete which arrives before the insert of its key doesn't match a row. It's dropped
+	// from tables using hard deletes, since a row can't be inserted without a document, and
+	// the later insert is then merged as usual.
+	for _, tc := range []struct {
+		resource   tableConfig
+		tombstones bool
+	}{
+		{tableConfig{base: cfg, Table: "hard"}, false},
+		{tableConfig{base: cfg, Table: "hard", NotMatched: notMatchedDeletesIgnore}, false},
+		{tableConfig{base: cfg, Table: "soft", SoftDelete: true}, true},
+		{tableConfig{base: cfg, Table: "soft", SoftDelete: true, NotMatched: notMatchedDeletesTombstone}, true},
+		{tableConfig{base: cfg, Table: "soft", SoftDelete: true, NotMatched: notMatchedDeletesIgnore}, false},
+	} {
+		require.NoError(t, tc.resource.Validate())
+
+		binding, err := newBinding(generator, metadataColumns{}, tc.resource.softDeleteColumns(), 123, "test", spec.Bindings[0])
+		require.NoError(t, err)
+		require.Equal(t, !tc.tombstones, strings.Contains(binding.store.sql, ignored), binding.store.sql)
+		// Otherwise a tombstone is inserted as a row which is already marked as deleted, and
+		// the later insert of the key matches it and unmarks it.
+		require.Equal(t, tc.tombstones, strings.Contains(binding.store.sql, "WHEN NOT MATCHED THEN"), binding.store.sql)
+	}
+
+	// Tombstones are soft-deleted rows, and so require soft deletes.
+	require.Error(t, (&tableConfig{Table: "hard", NotMatched: notMatchedDeletesTombstone}).Validate())
+	require.Error(t, (&tableConfig{Table: "soft", SoftDelete: true, NotMatched: "insert"}).Validate())
+}
+
+func TestKeyRange(t *testing.T) {
+	var r keyRange
+	for _, key := range []int64{5, -3, 12, 7, 0} {
+		r.update(key, key)
+	}
+	require.Equal(t, int64(-3), r.min)
+	require.Equal(t, int64(12), r.max)
+
+	r.reset()
+	for _, key := range []string{"b", "ab", "ba", "c"} {
+		r.update(key, key)
+	}
+	require.Equal(t, "ab", r.min)
+	require.Equal(t, "c", r.max)
+}
+
 func TestSpecification(t *testing.T) {
 	var resp, err = newBigQueryDriver().
 		Spec(context.Background(), &pm.SpecRequest{EndpointType: pf.EndpointType_AIRBYTE_SOURCE})
@@ -73,3 +269,756 @@ func TestSpecification(t *testing.T) {
 
 	cupaloy.SnapshotT(t, formatted)
 }
+
+type fakeStagedFile struct {
+	released bool
+	deleted  bool
+	archived string
+}
+
+func (f *fakeStagedFile) Release() { f.released = true }
+
+func (f *fakeStagedFile) Delete(context.Context) error {
+	f.deleted = true
+	return nil
+}
+
+func (f *fakeStagedFile) Archive(_ context.Context, prefix string) error {
+	f.archived = prefix
+	return nil
+}
+
+func TestStagingCleanup(t *testing.T) {
+	var ctx = context.Background()
+
+	for _, tc := range []struct {
+		policy   string
+		loaded   bool
+		expected fakeStagedFile
+	}{
+		{"", true, fakeStagedFile{released: true, deleted: true}},
+		{stagingCleanupDelete, true, fakeStagedFile{released: true, deleted: true}},
+		{stagingCleanupKeep, true, fakeStagedFile{released: true}},
+		{stagingCleanupArchive, true, fakeStagedFile{released: true, archived: "archive/"}},
+		// Files of failed loads are kept regardless of the policy.
+		{stagingCleanupDelete, false, fakeStagedFile{released: true}},
+		{stagingCleanupKeep, false, fakeStagedFile{released: true}},
+		{stagingCleanupArchive, false, fakeStagedFile{released: true}},
+	} {
+		var cfg = &config{StagingCleanup: tc.policy}
+		if tc.policy == stagingCleanupArchive {
+			cfg.ArchivePrefix = "archive/"
+		}
+		var f fakeStagedFile
+		require.NoError(t, cleanupStagedFile(ctx, cfg, &f, tc.loaded))
+		require.Equal(t, tc.expected, f, "policy %q, loaded %t", tc.policy, tc.loaded)
+	}
+}
+
+func TestConfigValidateStagingCleanup(t *testing.T) {
+	var cfg = config{
+		ProjectID: "project",
+		Dataset:   "dataset",
+		Region:    "us-central1",
+		Bucket:    "bucket",
+	}
+	require.NoError(t, cfg.Validate())
+
+	cfg.StagingCleanup = stagingCleanupKeep
+	require.NoError(t, cfg.Validate())
+	cfg.ArchivePrefix = "archive/"
+	require.Error(t, cfg.Validate())
+
+	cfg.StagingCleanup = stagingCleanupArchive
+	require.NoError(t, cfg.Validate())
+	cfg.ArchivePrefix = ""
+	require.Error(t, cfg.Validate())
+
+	cfg.StagingCleanup = "shred"
+	require.Error(t, cfg.Validate())
+}
+
+func TestNumericColumns(t *testing.T) {
+	var staged = []*bigquery.FieldSchema{
+		{Name: "key", Type: bigquery.IntegerFieldType},
+		{Name: "number", Type: bigquery.BigNumericFieldType},
+		{Name: "price", Type: bigquery.BigNumericFieldType},
+		{Name: "ratio", Type: bigquery.BigNumericFieldType},
+		{Name: "flow_document", Type: bigquery.StringFieldType},
+	}
+	var table = bigquery.Schema{
+		{Name: "key", Type: bigquery.IntegerFieldType},
+		{Name: "Number", Type: bigquery.BigNumericFieldType},
+		{Name: "price", Type: bigquery.NumericFieldType},
+		{Name: "ratio", Type: bigquery.NumericFieldType, Precision: 10, Scale: 4},
+		{Name: "flow_document", Type: bigquery.StringFieldType},
+	}
+	require.Equal(t, []numericColumn{
+		{index: 1, name: "number", scale: 38},
+		{index: 2, name: "price", scale: 9},
+		{index: 3, name: "ratio", scale: 4},
+	}, numericColumns(staged, table))
+
+	require.Nil(t, newNumericCoercer(numericOverflowRound, nil))
+}
+
+func TestNumericOverflow(t *testing.T) {
+	var columns = []numericColumn{{index: 1, name: "price", scale: 4}}
+	var row = func(value interface{}) []interface{} {
+		return []interface{}{"key", value, "doc"}
+	}
+
+	for _, tc := range []struct {
+		value     interface{}
+		round     interface{}
+		truncated interface{}
+	}{
+		// Values within the scale are unchanged.
+		{1.5, 1.5, 1.5},
+		{int64(12), int64(12), int64(12)},
+		{nil, nil, nil},
+		{json.Number("0.1234"), json.Number("0.1234"), json.Number("0.1234")},
+		// Values exceeding the scale are coerced.
+		{1.23456, json.Number("1.2346"), json.Number("1.2345")},
+		{-1.23456, json.Number("-1.2346"), json.Number("-1.2345")},
+		{0.00005, json.Number("0.0001"), json.Number("0")},
+		{-0.00001, json.Number("0"), json.Number("0")},
+		{9.99999, json.Number("10"), json.Number("9.9999")},
+		{json.Number("1e-7"), json.Number("0"), json.Number("0")},
+		{"123.456789", json.Number("123.4568"), json.Number("123.4567")},
+	} {
+		var rounded = row(tc.value)
+		var c = newNumericCoercer(numericOverflowRound, columns)
+		require.NoError(t, c.coerce(rounded))
+		require.Equal(t, row(tc.round), rounded, "rounding %v", tc.value)
+
+		var truncated = row(tc.value)
+		c = newNumericCoercer(numericOverflowTruncate, columns)
+		require.NoError(t, c.coerce(truncated))
+		require.Equal(t, row(tc.truncated), truncated, "truncating %v", tc.value)
+
+		var unchanged = tc.value == tc.round
+		var errored = row(tc.value)
+		for _, policy := range []string{"", numericOverflowError} {
+			c = newNumericCoercer(policy, columns)
+			if err := c.coerce(errored); unchanged {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err, "value %v", tc.value)
+			}
+		}
+	}
+
+	// The number of coerced values is counted until reset.
+	var c = newNumericCoercer(numericOverflowTruncate, columns)
+	require.NoError(t, c.coerce(row(1.23456)))
+	require.NoError(t, c.coerce(row(1.5)))
+	require.NoError(t, c.coerce(row(0.00001)))
+	require.Equal(t, 2, c.reset())
+	require.Equal(t, 0, c.reset())
+
+	require.EqualError(t, newNumericCoercer(numericOverflowError, columns).coerce(row(1.23456)),
+		`value 1.23456 of column "price" exceeds the column's scale of 4 (set numeric_overflow to round or truncate such values)`)
+}
+
+func TestNumberPrecision(t *testing.T) {
+	var spec *pf.MaterializationSpec
+	require.NoError(t, testsupport.CatalogExtract(t, "testdata/flow.yaml",
+		func(db *sql.DB) error {
+			var err error
+			spec, err = catalog.LoadMaterialization(db, "test/numeric")
+			return err
+		}))
+
+	// Stages a row of the binding, returning the staged JSON.
+	var stage = func(numericStrings bool, row tuple.Tuple) string {
+		binding, err := newBinding(SQLGenerator(numericStrings, ""), metadataColumns{}, softDeleteColumns{}, 123, "test", spec.Bindings[0])
+		require.NoError(t, err)
+		converted, err := binding.store.paramsConverter.Convert(append(row, json.RawMessage(`{}`)))
+		require.NoError(t, err)
+
+		var staged bytes.Buffer
+		var file = &ExternalDataConnectionFile{
+			edc:         binding.store.extDataConfig,
+			jsonEncoder: json.NewEncoder(&staged),
+		}
+		require.NoError(t, file.WriteRow(converted))
+		return staged.String()
+	}
+	var decimal = "1234567890.12345678901234567890123456789012345678"
+	var bigInt, _ = new(big.Int).SetString("1180591620717411303424", 10)
+
+	// Integers are staged exactly, whatever their type. Floats are staged as they are.
+	for _, tc := range []struct {
+		number interface{}
+		expect string
+	}{
+		{int64(math.MaxInt64), "9223372036854775807"},
+		{uint64(math.MaxUint64), "18446744073709551615"},
+		{bigInt, "1180591620717411303424"},
+		{0.1, "0.1"},
+		{1e-40, "1e-40"},
+	} {
+		require.Equal(t,
+			`{"decimal":"`+decimal+`","flow_document":"{}","key":9223372036854775807,"number":`+tc.expect+"}\n",
+			stage(false, tuple.Tuple{int64(math.MaxInt64), decimal, tc.number}))
+	}
+
+	// Strings formatted as numbers are staged as numbers having exactly the same digits
+	// when they're materialized as numeric columns.
+	var staged = stage(true, tuple.Tuple{int64(math.MaxInt64), decimal, 2.5})
+	require.Equal(t,
+		`{"decimal":`+decimal+`,"flow_document":"{}","key":9223372036854775807,"number":2.5}`+"\n",
+		staged)
+
+	// The values which are loaded from the staged row are exactly those of the document, for
+	// both the 64-bit integer and the decimal with the full scale of a BIGNUMERIC column.
+	var loaded map[string]interface{}
+	var dec = json.NewDecoder(strings.NewReader(staged))
+	dec.UseNumber()
+	require.NoError(t, dec.Decode(&loaded))
+	require.Equal(t, json.Number("9223372036854775807"), loaded["key"])
+	require.Equal(t, json.Number(decimal), loaded["decimal"])
+
+	binding, err := newBinding(SQLGenerator(true, ""), metadataColumns{}, softDeleteColumns{}, 123, "test", spec.Bindings[0])
+	require.NoError(t, err)
+	require.Equal(t, bigquery.BigNumericFieldType, binding.store.extDataConfig.Schema[1].Type)
+
+	_, err = binding.store.paramsConverter.Convert(tuple.Tuple{int64(1), "NaN", 2.5, json.RawMessage(`{}`)})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `invalid number "NaN" for a numeric column`)
+}
+
+func TestFormattedStrings(t *testing.T) {
+	var spec *pf.MaterializationSpec
+	require.NoError(t, testsupport.CatalogExtract(t, "testdata/flow.yaml",
+		func(db *sql.DB) error {
+			var err error
+			spec, err = catalog.LoadMaterialization(db, "test/formatted")
+			return err
+		}))
+
+	// Converts a row of the binding, returning its converted date, datetime, and decimal values.
+	var convert = func(policy string, date, datetime, decimal interface{}) ([]interface{}, error) {
+		binding, err := newBinding(SQLGenerator(true, policy), metadataColumns{}, softDeleteColumns{}, 123, "test", spec.Bindings[0])
+		require.NoError(t, err)
+		require.Equal(t, bigquery.DateFieldType, binding.store.extDataConfig.Schema[1].Type)
+		require.Equal(t, bigquery.TimestampFieldType, binding.store.extDataConfig.Schema[2].Type)
+		require.Equal(t, bigquery.BigNumericFieldType, binding.store.extDataConfig.Schema[3].Type)
+
+		converted, err := binding.store.paramsConverter.Convert(tuple.Tuple{int64(1), date, datetime, decimal, json.RawMessage(`{}`)})
+		if err != nil {
+			return nil, err
+		}
+		return converted[1:4], nil
+	}
+
+	// Valid values are staged in a form which BigQuery loads into the native type, whatever the
+	// policy. Date-times are staged in UTC at microsecond precision.
+	for _, policy := range []string{"", invalidStringsError, invalidStringsNull} {
+		var converted, err = convert(policy, "2022-03-04", "2022-03-04T05:06:07.123456789+01:00", "-12.50")
+		require.NoError(t, err)
+		require.Equal(t, []interface{}{"2022-03-04", "2022-03-04T04:06:07.123456Z", json.Number("-12.50")}, converted)
+
+		converted, err = convert(policy, nil, nil, nil)
+		require.NoError(t, err)
+		require.Equal(t, []interface{}{nil, nil, nil}, converted)
+	}
+
+	// Invalid values of each format fail the conversion by default, or are staged as null.
+	for _, tc := range []struct {
+		date, datetime, decimal interface{}
+		expect                  string
+	}{
+		{"2022-02-30", "2022-03-04T05:06:07Z", "1", `invalid date string "2022-02-30"`},
+		{"03/04/2022", "2022-03-04T05:06:07Z", "1", `invalid date string "03/04/2022"`},
+		{"2022-03-04", "2022-03-04 05:06:07", "1", `invalid date-time string "2022-03-04 05:06:07"`},
+		{"2022-03-04", "2022-03-04T05:06:07", "1", `invalid date-time string "2022-03-04T05:06:07"`},
+		{"2022-03-04", "2022-03-04T05:06:07Z", "1,000", `invalid numeric string "1,000"`},
+		{"2022-03-04", "2022-03-04T05:06:07Z", "NaN", `invalid numeric string "NaN"`},
+	} {
+		for _, policy := range []string{"", invalidStringsError} {
+			var _, err = convert(policy, tc.date, tc.datetime, tc.decimal)
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.expect)
+		}
+		var converted, err = convert(invalidStringsNull, tc.date, tc.datetime, tc.decimal)
+		require.NoError(t, err)
+		require.Contains(t, converted, nil)
+	}
+}
+
+func TestConfigValidateInvalidStrings(t *testing.T) {
+	var cfg = config{
+		ProjectID: "project",
+		Dataset:   "dataset",
+		Region:    "us-central1",
+		Bucket:    "bucket",
+	}
+	for _, policy := range []string{"", invalidStringsError, invalidStringsNull} {
+		cfg.InvalidStrings = policy
+		require.NoError(t, cfg.Validate())
+	}
+	cfg.InvalidStrings = "ignore"
+	require.Error(t, cfg.Validate())
+}
+
+func TestConfigValidateNumericOverflow(t *testing.T) {
+	var cfg = config{
+		ProjectID: "project",
+		Dataset:   "dataset",
+		Region:    "us-central1",
+		Bucket:    "bucket",
+	}
+	for _, policy := range []string{"", numericOverflowError, numericOverflowRound, numericOverflowTruncate} {
+		cfg.NumericOverflow = policy
+		require.NoError(t, cfg.Validate())
+	}
+	cfg.NumericOverflow = "ignore"
+	require.Error(t, cfg.Validate())
+}
+
+func TestTableExpiration(t *testing.T) {
+	var spec *pf.MaterializationSpec
+	require.NoError(t, testsupport.CatalogExtract(t, "testdata/flow.yaml",
+		func(db *sql.DB) error {
+			var err error
+			spec, err = catalog.LoadMaterialization(db, "test/sqlite")
+			return err
+		}))
+
+	var generator = SQLGenerator(false, "")
+	var cfg = &config{ProjectID: "project", Dataset: "dataset", TableExpiration: 3600, DatasetExpiration: 43200}
+	var ep = &Endpoint{
+		config:     cfg,
+		generator:  generator,
+		flowTables: sqlDriver.DefaultFlowTables("project.dataset."),
+	}
+	var resource = &tableConfig{base: cfg, Table: "expiring"}
+	ep.resources = []*tableConfig{resource}
+
+	// Materialized tables expire after the configured period.
+	var table = sqlDriver.TableForMaterialization(resource.Path().Join(), "", generator.IdentifierRenderer, spec.Bindings[0])
+	statement, err := ep.CreateTableStatement(table)
+	require.NoError(t, err)
+	require.True(t, strings.HasSuffix(statement,
+		"\nOPTIONS(expiration_timestamp = TIMESTAMP_ADD(CURRENT_TIMESTAMP(), INTERVAL 3600 SECOND));"), statement)
+	require.NotContains(t, statement, "ALTER")
+
+	// Flow tables set the default expiration of the dataset, but never expire themselves.
+	statement, err = ep.CreateTableStatement(&ep.flowTables.Checkpoints)
+	require.NoError(t, err)
+	require.Contains(t, statement, "ALTER SCHEMA `project.dataset` SET OPTIONS(default_table_expiration_days = 0.5);\n")
+	require.NotContains(t, statement, "expiration_timestamp = TIMESTAMP_ADD")
+	require.True(t, strings.HasSuffix(statement,
+		"\nALTER TABLE "+ep.flowTables.Checkpoints.Identifier+" SET OPTIONS(expiration_timestamp = NULL);"), statement)
+
+	// Tables don't expire by default.
+	cfg.TableExpiration, cfg.DatasetExpiration = 0, 0
+	statement, err = ep.CreateTableStatement(table)
+	require.NoError(t, err)
+	require.NotContains(t, statement, "OPTIONS")
+	statement, err = ep.CreateTableStatement(&ep.flowTables.Checkpoints)
+	require.NoError(t, err)
+	require.NotContains(t, statement, "OPTIONS")
+}
+
+func TestView(t *testing.T) {
+	var spec *pf.MaterializationSpec
+	require.NoError(t, testsupport.CatalogExtract(t, "testdata/flow.yaml",
+		func(db *sql.DB) error {
+			var err error
+			spec, err = catalog.LoadMaterialization(db, "test/sqlite")
+			return err
+		}))
+
+	var generator = SQLGenerator(false, "")
+	var cfg = &config{ProjectID: "project", Dataset: "dataset", LoadedAtColumn: "_loaded_at"}
+	var ep = &Endpoint{
+		config:     cfg,
+		generator:  generator,
+		flowTables: sqlDriver.DefaultFlowTables("project.dataset."),
+	}
+	var resource = &tableConfig{
+		base:       cfg,
+		Table:      "key_value",
+		SoftDelete: true,
+		View:       "curated",
+		ViewQuery: `SELECT key1 AS id, UPPER(` + "`string`" + `) AS label, t._loaded_at
+			FROM {table} AS t
+			-- Exclude deleted rows and those before 'the beginning'.
+			WHERE _deleted IS NOT TRUE AND _loaded_at > TIMESTAMP '2022-01-01' AND NOT boolean
+			ORDER BY id;`,
+	}
+	require.NoError(t, resource.Validate())
+	ep.resources = []*tableConfig{resource}
+
+	// The view is created over the table along with it, and refers to it by its identifier.
+	var table = sqlDriver.TableForMaterialization(resource.Path().Join(), "", generator.IdentifierRenderer, spec.Bindings[0])
+	statement, err := ep.CreateTableStatement(table)
+	require.NoError(t, err)
+	require.True(t, strings.HasSuffix(statement, "\nCREATE OR REPLACE VIEW `project.dataset.curated` AS\n"+
+		`SELECT key1 AS id, UPPER(`+"`string`"+`) AS label, t._loaded_at
+			FROM `+"`project.dataset.key_value`"+` AS t
+			-- Exclude deleted rows and those before 'the beginning'.
+			WHERE _deleted IS NOT TRUE AND _loaded_at > TIMESTAMP '2022-01-01' AND NOT boolean
+			ORDER BY id
+;`), statement)
+
+	// A view referencing a column which the table doesn't have fails to be applied, until the
+	// schema of the table evolves to include it.
+	resource.ViewQuery = "SELECT key1, region FROM {table}"
+	_, err = ep.CreateTableStatement(table)
+	require.EqualError(t, err, `view "curated" references column "region" which table "key_value" doesn't have`)
+
+	table.Columns = append(table.Columns, sqlDriver.Column{
+		Name:       "region",
+		Identifier: generator.IdentifierRenderer.Render("region"),
+		Type:       sqlDriver.STRING,
+	})
+	statement, err = ep.CreateTableStatement(table)
+	require.NoError(t, err)
+	require.Contains(t, statement, "`region` STRING")
+	require.True(t, strings.HasSuffix(statement,
+		"\nCREATE OR REPLACE VIEW `project.dataset.curated` AS\nSELECT key1, region FROM `project.dataset.key_value`\n;"), statement)
+
+	// Names defined by the query itself aren't columns of the table.
+	resource.ViewQuery = `WITH recent AS (SELECT * FROM {table} WHERE integer > @min)
+		SELECT r.key1, CAST(r.number AS STRING) AS number_string FROM recent AS r ORDER BY number_string`
+	_, err = ep.CreateTableStatement(table)
+	require.NoError(t, err)
+
+	// Tables of bindings without a view don't have one.
+	resource.View, resource.ViewQuery = "", ""
+	statement, err = ep.CreateTableStatement(table)
+	require.NoError(t, err)
+	require.NotContains(t, statement, "VIEW")
+
+	for _, invalid := range []tableConfig{
+		{Table: "key_value", View: "curated"},
+		{Table: "key_value", ViewQuery: "SELECT * FROM {table}"},
+		{Table: "key_value", View: "curated view", ViewQuery: "SELECT * FROM {table}"},
+		{Table: "key_value", View: "Key_Value", ViewQuery: "SELECT * FROM {table}"},
+		{Table: "key_value", View: "curated", ViewQuery: "DROP TABLE {table}"},
+		{Table: "key_value", View: "curated", ViewQuery: "SELECT * FROM `project.dataset.key_value`"},
+	} {
+		require.Error(t, invalid.Validate(), "%#v", invalid)
+	}
+}
+
+func TestConfigValidateTableExpiration(t *testing.T) {
+	var cfg = config{
+		ProjectID:         "project",
+		Dataset:           "dataset",
+		Region:            "us-central1",
+		Bucket:            "bucket",
+		TableExpiration:   86400,
+		DatasetExpiration: 86400,
+	}
+	require.NoError(t, cfg.Validate())
+
+	cfg.TableExpiration = -1
+	require.Error(t, cfg.Validate())
+	cfg.TableExpiration, cfg.DatasetExpiration = 0, -1
+	require.Error(t, cfg.Validate())
+}
+
+func TestBadRecords(t *testing.T) {
+	var spec *pf.MaterializationSpec
+	require.NoError(t, testsupport.CatalogExtract(t, "testdata/flow.yaml",
+		func(db *sql.DB) error {
+			var err error
+			spec, err = catalog.LoadMaterialization(db, "test/sqlite")
+			return err
+		}))
+
+	var cfg = &config{MaxBadRecords: 5, IgnoreUnknownValues: true, BadRecordsPrefix: "quarantine/"}
+	binding, err := newBinding(SQLGenerator(false, ""), metadataColumns{}, softDeleteColumns{}, 123, "test", spec.Bindings[0])
+	require.NoError(t, err)
+	cfg.applyBadRecords(binding.store.extDataConfig)
+	require.Equal(t, int64(5), binding.store.extDataConfig.MaxBadRecords)
+	require.True(t, binding.store.extDataConfig.IgnoreUnknownValues)
+	// Keys are staged by the connector itself, so their loads remain strict.
+	require.Zero(t, binding.load.extDataConfig.MaxBadRecords)
+
+	// A merge of a batch having a few bad rows under the threshold succeeds, and BigQuery
+	// reports each of the skipped rows in the status of the job.
+	var hook = logtest.NewGlobal()
+	defer hook.Reset()
+	require.Equal(t, 3, logSkippedRecords(&bigquery.JobStatus{
+		State: bigquery.Done,
+		Errors: []*bigquery.Error{
+			{Location: "gs://bucket/staged", Reason: "invalid", Message: "Error while reading data: row 2"},
+			{Location: "gs://bucket/staged", Reason: "invalid", Message: "Error while reading data: row 5"},
+			{Location: "gs://bucket/staged", Reason: "invalid", Message: "Error while reading data: row 8"},
+		},
+	}))
+	var skipped []string
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "skipped bad record" {
+			require.Equal(t, logrus.WarnLevel, entry.Level)
+			skipped = append(skipped, entry.Data["message"].(string))
+		}
+	}
+	require.Equal(t, []string{
+		"Error while reading data: row 2",
+		"Error while reading data: row 5",
+		"Error while reading data: row 8",
+	}, skipped)
+
+	// A batch without bad rows logs nothing.
+	hook.Reset()
+	require.Zero(t, logSkippedRecords(&bigquery.JobStatus{State: bigquery.Done}))
+	require.Empty(t, hook.AllEntries())
+
+	// The staged files of the batch are quarantined.
+	var f fakeStagedFile
+	require.NoError(t, quarantineStagedFile(context.Background(), cfg, &f))
+	require.Equal(t, fakeStagedFile{released: true, archived: "quarantine/"}, f)
+}
+
+func TestConfigValidateBadRecords(t *testing.T) {
+	var cfg = config{
+		ProjectID:     "project",
+		Dataset:       "dataset",
+		Region:        "us-central1",
+		Bucket:        "bucket",
+		MaxBadRecords: 10,
+	}
+	require.NoError(t, cfg.Validate())
+	cfg.BadRecordsPrefix = "quarantine/"
+	require.NoError(t, cfg.Validate())
+
+	cfg.MaxBadRecords = 0
+	require.Error(t, cfg.Validate())
+	cfg.MaxBadRecords = -1
+	require.Error(t, cfg.Validate())
+}
+
+func TestRowValidator(t *testing.T) {
+	var staged = []*bigquery.FieldSchema{
+		{Name: "key", Type: bigquery.IntegerFieldType},
+		{Name: "count", Type: bigquery.IntegerFieldType},
+		{Name: "price", Type: bigquery.BigNumericFieldType},
+		{Name: "created", Type: bigquery.TimestampFieldType},
+		{Name: "extra", Type: bigquery.StringFieldType},
+		{Name: "flow_document", Type: bigquery.StringFieldType},
+	}
+	var table = bigquery.Schema{
+		{Name: "key", Type: bigquery.IntegerFieldType, Required: true},
+		{Name: "Count", Type: bigquery.IntegerFieldType},
+		{Name: "price", Type: bigquery.NumericFieldType},
+		{Name: "created", Type: bigquery.DateFieldType},
+		{Name: "flow_document", Type: bigquery.StringFieldType, Required: true},
+	}
+	require.Nil(t, newRowValidator(0, "test", staged, table))
+
+	var v = newRowValidator(2, "test", staged, table)
+	var good = []interface{}{int64(1), uint64(2), json.Number("1.5"), "2022-06-01", nil, `{"key":1}`}
+	require.NoError(t, v.validate(good))
+
+	// A row which doesn't fit is rejected with an error naming each incompatible column.
+	var bad = []interface{}{nil, "three", json.Number("1.5"), "2022-06-01T12:00:00Z", "unknown", json.RawMessage(nil)}
+	var err = v.validate(bad)
+	require.Error(t, err)
+	require.Equal(t, `staged row 2 doesn't fit the schema of table "test": `+
+		`column "key": null value for REQUIRED column; `+
+		`column "count": value three of type string is incompatible with column type INTEGER; `+
+		`column "created": value "2022-06-01T12:00:00Z" is not a DATE; `+
+		`column "extra": the table has no such column; `+
+		`column "flow_document": null value for REQUIRED column`, err.Error())
+
+	// Only the first rows of each transaction are checked.
+	require.NoError(t, v.validate(bad))
+	v.reset()
+	require.Error(t, v.validate(bad))
+
+	for _, tc := range []struct {
+		field *bigquery.FieldSchema
+		value interface{}
+		ok    bool
+	}{
+		{&bigquery.FieldSchema{Type: bigquery.IntegerFieldType}, uint64(math.MaxUint64), false},
+		{&bigquery.FieldSchema{Type: bigquery.IntegerFieldType}, 1.5, false},
+		{&bigquery.FieldSchema{Type: bigquery.FloatFieldType}, json.Number("1.5"), true},
+		{&bigquery.FieldSchema{Type: bigquery.BigNumericFieldType}, json.Number("1e400"), true},
+		{&bigquery.FieldSchema{Type: bigquery.BigNumericFieldType}, "1.5", false},
+		{&bigquery.FieldSchema{Type: bigquery.BooleanFieldType}, true, true},
+		{&bigquery.FieldSchema{Type: bigquery.BooleanFieldType}, int64(1), false},
+		{&bigquery.FieldSchema{Type: bigquery.BytesFieldType}, []byte{1, 2}, true},
+		{&bigquery.FieldSchema{Type: bigquery.DateFieldType}, "2022-06-01", true},
+		{&bigquery.FieldSchema{Type: bigquery.TimestampFieldType}, "2022-06-01T12:00:00.123456Z", true},
+		{&bigquery.FieldSchema{Type: bigquery.TimestampFieldType}, "2022-06-01", false},
+		{&bigquery.FieldSchema{Type: bigquery.StringFieldType}, int64(1), false},
+		{&bigquery.FieldSchema{Type: bigquery.GeographyFieldType}, "POINT(1 2)", true},
+	} {
+		if tc.ok {
+			require.NoError(t, validateValue(tc.field, tc.value), "%s %#v", tc.field.Type, tc.value)
+		} else {
+			require.Error(t, validateValue(tc.field, tc.value), "%s %#v", tc.field.Type, tc.value)
+		}
+	}
+}
+
+func TestConfigValidateStagedRows(t *testing.T) {
+	var cfg = config{
+		ProjectID:          "project",
+		Dataset:            "dataset",
+		Region:             "us-central1",
+		Bucket:             "bucket",
+		ValidateStagedRows: 100,
+	}
+	require.NoError(t, cfg.Validate())
+
+	cfg.MaxBadRecords = 10
+	require.Error(t, cfg.Validate())
+	cfg.MaxBadRecords, cfg.ValidateStagedRows = 0, -1
+	require.Error(t, cfg.Validate())
+}
+
+func TestLocationsCompatible(t *testing.T) {
+	for _, tc := range []struct {
+		dataset  string
+		bucket   bucketLocation
+		expected bool
+	}{
+		{"us-central1", bucketLocation{"US-CENTRAL1", bucketLocationRegion}, true},
+		{"us-central1", bucketLocation{"NAM4", bucketLocationDualRegion}, true},
+		{"us-central1", bucketLocation{"US-EAST4", bucketLocationRegion}, false},
+		{"us-central1", bucketLocation{"US", bucketLocationMultiRegion}, false},
+		{"europe-west1", bucketLocation{"EUR4", bucketLocationDualRegion}, false},
+		{"europe-west4", bucketLocation{"EUR4", bucketLocationDualRegion}, true},
+		{"US", bucketLocation{"US", bucketLocationMultiRegion}, true},
+		{"US", bucketLocation{"EUROPE-WEST1", bucketLocationRegion}, true},
+		{"EU", bucketLocation{"EU", bucketLocationMultiRegion}, true},
+		{"EU", bucketLocation{"EUROPE-WEST1", bucketLocationRegion}, true},
+		{"EU", bucketLocation{"EUR4", bucketLocationDualRegion}, true},
+		{"EU", bucketLocation{"US-CENTRAL1", bucketLocationRegion}, false},
+		{"EU", bucketLocation{"US", bucketLocationMultiRegion}, false},
+		{"asia-northeast1", bucketLocation{"ASIA1", bucketLocationDualRegion}, true},
+		{"asia-northeast1", bucketLocation{"ASIA", bucketLocationMultiRegion}, false},
+	} {
+		require.Equal(t, tc.expected, locationsCompatible(tc.dataset, tc.bucket), "dataset %s, bucket %s", tc.dataset, tc.bucket)
+	}
+}
+
+func TestSelectStagingBucket(t *testing.T) {
+	var ctx = context.Background()
+	var locations = map[string]bucketLocation{
+		"east":    {"US-EAST1", bucketLocationRegion},
+		"central": {"US-CENTRAL1", bucketLocationRegion},
+		"nam4":    {"NAM4", bucketLocationDualRegion},
+	}
+	var locate = func(_ context.Context, bucket string) (bucketLocation, error) {
+		if location, ok := locations[bucket]; ok {
+			return location, nil
+		}
+		return bucketLocation{}, fmt.Errorf("bucket doesn't exist")
+	}
+
+	// The first compatible bucket is selected.
+	var cfg = config{Bucket: "east", StagingBuckets: []string{"central", "nam4"}}
+	bucket, err := selectStagingBucket(ctx, "dataset", "us-central1", cfg.stagingBuckets(), locate)
+	require.NoError(t, err)
+	require.Equal(t, "central", bucket)
+
+	// Incompatible locations fail before anything is staged.
+	cfg = config{Bucket: "east"}
+	_, err = selectStagingBucket(ctx, "dataset", "europe-west1", cfg.stagingBuckets(), locate)
+	require.EqualError(t, err, `no staging bucket can be loaded into dataset "dataset", which is located in europe-west1: `+
+		`bucket "east" is located in US-EAST1 (region). The bucket must be located in the same region as the dataset `+
+		`or in a multi-region or dual-region which includes it`)
+
+	cfg = config{StagingBuckets: []string{"central", "nam4"}}
+	_, err = selectStagingBucket(ctx, "dataset", "us-east4", cfg.stagingBuckets(), locate)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `bucket "central" is located in US-CENTRAL1 (region), bucket "nam4" is located in NAM4 (dual-region)`)
+
+	// Buckets which can't be located fail as well.
+	_, err = selectStagingBucket(ctx, "dataset", "us-central1", []string{"missing"}, locate)
+	require.EqualError(t, err, `fetching the location of bucket "missing": bucket doesn't exist`)
+}
+
+func TestConfigValidateStagingBuckets(t *testing.T) {
+	var cfg = config{
+		ProjectID: "project",
+		Dataset:   "dataset",
+		Region:    "us-central1",
+	}
+	require.Error(t, cfg.Validate())
+
+	cfg.StagingBuckets = []string{"central", "nam4"}
+	require.NoError(t, cfg.Validate())
+	cfg.Bucket = "central"
+	require.NoError(t, cfg.Validate())
+	require.Equal(t, []string{"central", "nam4"}, cfg.stagingBuckets())
+
+	cfg.StagingBuckets = append(cfg.StagingBuckets, "")
+	require.Error(t, cfg.Validate())
+}
+
+func TestJobLabels(t *testing.T) {
+	var cfg = &config{JobLabels: map[string]string{"team": "data", "env": "prod"}}
+	var ep = &Endpoint{config: cfg, bigQueryClient: &bigquery.Client{}}
+
+	// Every job has the labels of the endpoint.
+	var query = ep.newQuery("SELECT 1;")
+	require.Equal(t, map[string]string{"team": "data", "env": "prod"}, query.Labels)
+
+	// Jobs loading or merging documents also have the labels of the bindings they include,
+	// which override those of the endpoint.
+	var orders = &binding{name: "orders", labels: map[string]string{"table": "orders", "team": "sales"}}
+	var refunds = &binding{name: "refunds", labels: map[string]string{"table": "refunds", "cost_center": "cc-12"}}
+	var plain = &binding{name: "plain"}
+	require.Equal(t, map[string]string{"team": "sales", "env": "prod", "table": "orders"}, ep.jobLabels(orders))
+	require.Equal(t, map[string]string{"team": "data", "env": "prod"}, ep.jobLabels(plain))
+
+	// Labels which the included bindings give different values are left off.
+	require.Equal(t, map[string]string{"team": "sales", "env": "prod", "cost_center": "cc-12"}, ep.jobLabels(orders, refunds, plain))
+
+	// Jobs have no labels if none are configured.
+	ep.config = &config{}
+	require.Nil(t, ep.newQuery("SELECT 1;").Labels)
+	require.Nil(t, ep.jobLabels(plain))
+}
+
+func TestConfigValidateJobLabels(t *testing.T) {
+	var cfg = config{
+		ProjectID: "project",
+		Dataset:   "dataset",
+		Region:    "us-central1",
+		Bucket:    "bucket",
+		JobLabels: map[string]string{"team": "data", "cost-center": "", "région": "île_de_france-2"},
+	}
+	require.NoError(t, cfg.Validate())
+
+	for _, labels := range []map[string]string{
+		{"Team": "data"},
+		{"team": "Data"},
+		{"1team": "data"},
+		{"": "data"},
+		{"team.name": "data"},
+		{"team": "data science"},
+		{strings.Repeat("k", 64): "data"},
+		{"team": strings.Repeat("v", 64)},
+	} {
+		cfg.JobLabels = labels
+		require.Error(t, cfg.Validate(), "%v", labels)
+	}
+	cfg.JobLabels = map[string]string{strings.Repeat("k", 63): strings.Repeat("v", 63)}
+	require.NoError(t, cfg.Validate())
+
+	// The labels of a table are also limited in number when combined with those of the endpoint.
+	cfg.JobLabels = make(map[string]string)
+	var table = tableConfig{base: &cfg, Table: "table", JobLabels: make(map[string]string)}
+	for i := 0; i < maxJobLabels; i++ {
+		cfg.JobLabels[fmt.Sprintf("endpoint_%d", i)] = "x"
+		table.JobLabels[fmt.Sprintf("table_%d", i)] = "x"
+	}
+	require.NoError(t, cfg.Validate())
+	require.Error(t, table.Validate())
+	table.JobLabels = map[string]string{"endpoint_0": "y"}
+	require.NoError(t, table.Validate())
+	table.JobLabels = map[string]string{"Table": "orders"}
+	require.Error(t, table.Validate())
+}
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"strings"
 
 	"cloud.google.com/go/bigquery"
+	"github.com/estuary/flow/go/protocols/fdb/tuple"
 	pf "github.com/estuary/flow/go/protocols/flow"
 	sqlDriver "github.com/estuary/flow/go/protocols/materialize/sql"
 )
@@ -14,6 +16,9 @@ const tempTableNamePrefix = "flow_temp"
 
 type binding struct {
 	name string
+	// Labels of the jobs which load and merge documents of the binding, in addition to those of
+	// the endpoint.
+	labels map[string]string
 
 	// Variables exclusively used by Load.
 	load struct {
@@ -31,9 +36,51 @@ type binding struct {
 		sql             string
 		tempTableName   string // The name the external table we be referenced by
 		hasRootDocument bool   // Whether the root document is included in this binding
+		metadata        metadataColumns
+		// The range of leading key values stored in the current transaction, or nil if
+		// the MERGE isn't restricted by key range.
+		keyRange *keyRange
+		// Applies the numeric overflow policy to staged values, or nil if the table
+		// has no numeric columns.
+		numerics *numericCoercer
+		// Checks staged rows against the schema of the table, or nil if they aren't checked.
+		validator *rowValidator
 	}
 }
 
+// keyRange tracks the minimum and maximum values of the leading key column across the
+// documents stored in a transaction. Target tables are clustered on their keys, so adding
+// a constant range predicate on the leading key to the MERGE lets BigQuery prune clusters
+// which can't contain any of the stored documents, rather than scanning the whole table.
+type keyRange struct {
+	min, max             interface{} // Converted values, as they're passed to BigQuery.
+	minPacked, maxPacked []byte      // Packed tuples of the original key values, used for comparison.
+}
+
+// update extends the range to include the leading key element and its converted value.
+func (r *keyRange) update(key tuple.TupleElement, converted interface{}) {
+	var packed = tuple.Tuple{key}.Pack()
+	if r.minPacked == nil || bytes.Compare(packed, r.minPacked) < 0 {
+		r.min, r.minPacked = converted, packed
+	}
+	if r.maxPacked == nil || bytes.Compare(packed, r.maxPacked) > 0 {
+		r.max, r.maxPacked = converted, packed
+	}
+}
+
+// reset clears the range in preparation for the next transaction.
+func (r *keyRange) reset() {
+	*r = keyRange{}
+}
+
+// clusterPrunableTypes are the BigQuery column types for which the ordering of packed
+// tuples agrees with BigQuery's own ordering, so a key range computed over packed tuples
+// is safe to use as a MERGE predicate.
+var clusterPrunableTypes = map[string]bool{
+	"INT64":  true,
+	"STRING": true,
+}
+
 // bindingDocument is used by the load operation to fetch binding flow_document values
 type bindingDocument struct {
 	Binding  int
@@ -63,7 +110,7 @@ func (bd *bindingDocument) Load(v []bigquery.Value, s bigquery.Schema) error {
 }
 
 // newBinding generates the bindings for the spec to the BigQuery table.
-func newBinding(generator sqlDriver.Generator, bindingPos int, targetName string, spec *pf.MaterializationSpec_Binding) (*binding, error) {
+func newBinding(generator sqlDriver.Generator, metadata metadataColumns, softDelete softDeleteColumns, bindingPos int, targetName string, spec *pf.MaterializationSpec_Binding) (*binding, error) {
 
 	var err error
 	var b = &binding{
@@ -72,6 +119,11 @@ func newBinding(generator sqlDriver.Generator, bindingPos int, targetName string
 
 	// Generate the table definition for this materialization.
 	var tableDef = sqlDriver.TableForMaterialization(targetName, "", generator.IdentifierRenderer, spec)
+	if err = metadata.checkCollisions(tableDef.Columns); err != nil {
+		return nil, err
+	} else if err = softDelete.checkCollisions(tableDef.Columns); err != nil {
+		return nil, err
+	}
 
 	// BINDING LOADS: Load is done via query against an external table with primary keys.
 
@@ -88,7 +140,8 @@ func newBinding(generator sqlDriver.Generator, bindingPos int, targetName string
 		Schema:       make([]*bigquery.FieldSchema, 0),
 	}
 
-	var pkJoins []string // How to join the main table to the external table based on keys.
+	var pkJoins []string    // How to join the main table to the external table based on keys.
+	var keyRangeJoin string // Restricts the MERGE to the range of stored leading keys, if possible.
 
 	// We use the sqlDriver.NullableTypeMapping to handle null fields for defining schemas
 	// but when using bigquery it does not allow the NOT NULL suffix for external table
@@ -113,6 +166,10 @@ func newBinding(generator sqlDriver.Generator, bindingPos int, targetName string
 		})
 
 		pkJoins = append(pkJoins, fmt.Sprintf("l.%s = r.%s", col.Identifier, col.Identifier))
+
+		if len(pkJoins) == 1 && clusterPrunableTypes[colType.SQLType] {
+			keyRangeJoin = fmt.Sprintf("l.%s BETWEEN %s AND %s", col.Identifier, generator.Placeholder(0), generator.Placeholder(1))
+		}
 	}
 
 	b.load.tempTableName = fmt.Sprintf("%s_load_%d", tempTableNamePrefix, bindingPos)
@@ -122,6 +179,12 @@ func newBinding(generator sqlDriver.Generator, bindingPos int, targetName string
 		// that happens we'll hopefully produce a reasonable error message with this.
 		b.load.sql = `ASSERT false AS 'Load queries should never be executed in Delta Updates mode.'`
 	} else {
+		// Rows which have been soft-deleted still hold the last document, which must not be loaded.
+		var loadJoins = pkJoins
+		if softDelete.enabled() {
+			loadJoins = append(append([]string(nil), pkJoins...), fmt.Sprintf("l.%s IS NOT TRUE", generator.IdentifierRenderer.Render(softDelete.deleted)))
+		}
+
 		// SELECT documents joined with the external table of keys to load
 		b.load.sql = fmt.Sprintf(`
 		SELECT %d, l.%s
@@ -133,7 +196,7 @@ func newBinding(generator sqlDriver.Generator, bindingPos int, targetName string
 			tableDef.GetColumn(spec.FieldSelection.Document).Identifier,
 			tableDef.Identifier,
 			b.load.tempTableName,
-			strings.Join(pkJoins, " AND "),
+			strings.Join(loadJoins, " AND "),
 		)
 	}
 
@@ -173,6 +236,27 @@ func newBinding(generator sqlDriver.Generator, bindingPos int, targetName string
 		rColIdentifiers = append(rColIdentifiers, fmt.Sprintf("r.%s", col.Identifier))
 	}
 
+	// Metadata columns follow the fields, and are populated by Store() with the values of the
+	// current transaction.
+	var metaCols = metadata.columns(generator)
+	for _, col := range metaCols {
+		colType, err := baseTypeMapper.GetColumnType(&col)
+		if err != nil {
+			return nil, err
+		}
+
+		b.store.extDataConfig.Schema = append(b.store.extDataConfig.Schema, &bigquery.FieldSchema{
+			Name:     col.Name,
+			Repeated: false,
+			Required: false,
+			Type:     bigquery.FieldType(colType.SQLType),
+		})
+
+		colIdentifiers = append(colIdentifiers, col.Identifier)
+		rColIdentifiers = append(rColIdentifiers, fmt.Sprintf("r.%s", col.Identifier))
+	}
+	b.store.metadata = metadata
+
 	// If a field is selected whose document pointer is the root document, then Store()
 	// will need to include the root document in the set of values being written.
 	b.store.hasRootDocument = spec.FieldSelection.Document != ""
@@ -197,6 +281,41 @@ func newBinding(generator sqlDriver.Generator, bindingPos int, targetName string
 			var col = tableDef.GetColumn(colName)
 			lrUpdates = append(lrUpdates, fmt.Sprintf("l.%s = r.%s", col.Identifier, col.Identifier))
 		}
+		for _, col := range metaCols {
+			lrUpdates = append(lrUpdates, fmt.Sprintf("l.%s = r.%s", col.Identifier, col.Identifier))
+		}
+
+		// The key range predicate requires two parameters (the minimum and maximum stored
+		// key) which are provided by the transactor when the MERGE is committed.
+		var mergeJoins = pkJoins
+		if keyRangeJoin != "" {
+			mergeJoins = append(append([]string(nil), pkJoins...), keyRangeJoin)
+			b.store.keyRange = new(keyRange)
+		}
+
+		// Deleted documents have a null document, and their rows are removed unless the binding
+		// uses soft deletes. In that case the rows are instead marked as deleted, keeping the rest
+		// of their values, and are unmarked if the document is later stored again. A delete of a key
+		// which has no row, such as one which arrives before the key's insert, is dropped unless
+		// the binding inserts tombstones, which are rows that are already marked as deleted.
+		var docIdentifier = tableDef.GetColumn(spec.FieldSelection.Document).Identifier
+		var deleteAction = "DELETE"
+		var notMatched = fmt.Sprintf(" AND r.%s IS NOT NULL", docIdentifier)
+		if softDelete.enabled() && softDelete.tombstones {
+			notMatched = ""
+		}
+		if softDelete.enabled() {
+			var deleted = generator.IdentifierRenderer.Render(softDelete.deleted)
+			var deletedAt = generator.IdentifierRenderer.Render(softDelete.deletedAt)
+
+			deleteAction = fmt.Sprintf("UPDATE SET l.%s = TRUE, l.%s = CURRENT_TIMESTAMP()", deleted, deletedAt)
+			lrUpdates = append(lrUpdates, fmt.Sprintf("l.%s = FALSE", deleted), fmt.Sprintf("l.%s = NULL", deletedAt))
+			colIdentifiers = append(colIdentifiers, deleted, deletedAt)
+			rColIdentifiers = append(rColIdentifiers,
+				fmt.Sprintf("r.%s IS NULL", docIdentifier),
+				fmt.Sprintf("IF(r.%s IS NULL, CURRENT_TIMESTAMP(), NULL)", docIdentifier),
+			)
+		}
 
 		// Perform merge query to update existing values.
 		b.store.sql = fmt.Sprintf(`
@@ -204,18 +323,20 @@ func newBinding(generator sqlDriver.Generator, bindingPos int, targetName string
 		USING %s AS r
 		ON %s
 		WHEN MATCHED AND r.%s IS NULL THEN
-			DELETE
+			%s
 		WHEN MATCHED THEN
 			UPDATE SET %s
-		WHEN NOT MATCHED THEN
+		WHEN NOT MATCHED%s THEN
 			INSERT (%s)
 			VALUES (%s)
 		;`,
 			tableDef.Identifier,
 			b.store.tempTableName,
-			strings.Join(pkJoins, " AND "),
-			tableDef.GetColumn(spec.FieldSelection.Document).Identifier,
+			strings.Join(mergeJoins, " AND "),
+			docIdentifier,
+			deleteAction,
 			strings.Join(lrUpdates, ", "),
+			notMatched,
 			strings.Join(colIdentifiers, ", "),
 			strings.Join(rColIdentifiers, ", "),
 		)
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Location types of Cloud Storage buckets, as reported by their attributes.
+const (
+	bucketLocationRegion      = "region"
+	bucketLocationDualRegion  = "dual-region"
+	bucketLocationMultiRegion = "multi-region"
+)
+
+// predefinedDualRegions are the regions of each predefined Cloud Storage dual-region.
+var predefinedDualRegions = map[string][]string{
+	"asia1": {"asia-northeast1", "asia-northeast2"},
+	"eur4":  {"europe-north1", "europe-west4"},
+	"nam4":  {"us-central1", "us-east1"},
+}
+
+// multiRegionPrefixes are the prefixes of the names of regions and dual-regions within each
+// multi-region.
+var multiRegionPrefixes = map[string][]string{
+	"asia": {"asia"},
+	"eu":   {"eur"},
+	"us":   {"us-", "nam"},
+}
+
+// bucketLocation is the location of a Cloud Storage bucket.
+type bucketLocation struct {
+	location     string // Such as "US", "US-CENTRAL1", or "NAM4".
+	locationType string // One of "region", "dual-region", or "multi-region".
+}
+
+func (l bucketLocation) String() string {
+	if l.locationType == "" {
+		return l.location
+	}
+	return fmt.Sprintf("%s (%s)", l.location, l.locationType)
+}
+
+// withinMultiRegion returns whether a region or dual-region is located within a multi-region.
+func withinMultiRegion(location, multiRegion string) bool {
+	for _, prefix := range multiRegionPrefixes[multiRegion] {
+		if strings.HasPrefix(location, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// locationsCompatible returns whether BigQuery can load files staged in a bucket into a dataset.
+// A dataset in the US multi-region can load from a bucket in any location. Otherwise, a dataset in
+// a multi-region can load from a bucket in the same multi-region or a location within it, and a
+// dataset in a region can load from a bucket in the same region or a dual-region which includes it.
+// The regions of custom dual-regions aren't known, so these are only matched to multi-regions.
+func locationsCompatible(datasetLocation string, bucket bucketLocation) bool {
+	var dataset = strings.ToLower(datasetLocation)
+	var location = strings.ToLower(bucket.location)
+
+	if dataset == location || dataset == "us" {
+		return true
+	} else if _, ok := multiRegionPrefixes[dataset]; ok {
+		return bucket.locationType != bucketLocationMultiRegion && withinMultiRegion(location, dataset)
+	} else if bucket.locationType == bucketLocationDualRegion {
+		for _, region := range predefinedDualRegions[location] {
+			if region == dataset {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// stagingBuckets returns the names of the buckets which may be used to stage files, in order of
+// preference.
+func (c *config) stagingBuckets() []string {
+	var buckets []string
+	if c.Bucket != "" {
+		buckets = append(buckets, c.Bucket)
+	}
+	for _, bucket := range c.StagingBuckets {
+		if bucket != c.Bucket {
+			buckets = append(buckets, bucket)
+		}
+	}
+	return buckets
+}
+
+// selectStagingBucket returns the first of the buckets whose location is compatible with the
+// location of the dataset. Its error lists the location of every bucket otherwise, so that the
+// configuration can be corrected before anything is staged.
+func selectStagingBucket(
+	ctx context.Context,
+	dataset, datasetLocation string,
+	buckets []string,
+	locate func(ctx context.Context, bucket string) (bucketLocation, error),
+) (string, error) {
+	var mismatched []string
+	for _, bucket := range buckets {
+		var location, err = locate(ctx, bucket)
+		if err != nil {
+			return "", fmt.Errorf("fetching the location of bucket %q: %w", bucket, err)
+		} else if locationsCompatible(datasetLocation, location) {
+			return bucket, nil
+		}
+		mismatched = append(mismatched, fmt.Sprintf("bucket %q is located in %s", bucket, location))
+	}
+	if len(mismatched) == 0 {
+		return "", fmt.Errorf("no staging bucket is configured")
+	}
+	return "", fmt.Errorf(
+		"no staging bucket can be loaded into dataset %q, which is located in %s: %s. "+
+			"The bucket must be located in the same region as the dataset or in a multi-region or dual-region which includes it",
+		dataset, datasetLocation, strings.Join(mismatched, ", "))
+}
+
+// resolveStagingBucket checks that the dataset is located in the configured region, and selects
+// the bucket in which files are staged. It's called when the materialization is applied, before
+// any tables are created, so that incompatible locations fail early rather than at the first load
+// of a transaction. The selected bucket is remembered for subsequent calls.
+func (ep *Endpoint) resolveStagingBucket(ctx context.Context) error {
+	if ep.stagingBucket != "" {
+		return nil
+	}
+
+	var meta, err = ep.bigQueryClient.DatasetInProject(ep.config.ProjectID, ep.config.Dataset).Metadata(ctx)
+	if err != nil {
+		return fmt.Errorf("fetching the metadata of dataset %q: %w", ep.config.Dataset, err)
+	} else if !strings.EqualFold(meta.Location, ep.config.Region) {
+		return fmt.Errorf("dataset %q is located in %s, but the configured region is %s", ep.config.Dataset, meta.Location, ep.config.Region)
+	}
+
+	bucket, err := selectStagingBucket(ctx, ep.config.Dataset, meta.Location, ep.config.stagingBuckets(),
+		func(ctx context.Context, bucket string) (bucketLocation, error) {
+			var attrs, err = ep.cloudStorageClient.Bucket(bucket).Attrs(ctx)
+			if err != nil {
+				return bucketLocation{}, err
+			}
+			return bucketLocation{location: attrs.Location, locationType: attrs.LocationType}, nil
+		})
+	if err != nil {
+		return err
+	}
+	ep.stagingBucket = bucket
+	return nil
+}
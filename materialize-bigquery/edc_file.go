@@ -18,6 +18,7 @@ import (
 type ExternalDataConnectionFile struct {
 	URI         string
 	edc         *bigquery.ExternalDataConfig
+	gcsBucket   *storage.BucketHandle
 	gcsObject   *storage.ObjectHandle
 	gcsWriter   *storage.Writer
 	bufWriter   *bufio.Writer
@@ -33,10 +34,12 @@ func (ep *Endpoint) NewExternalDataConnectionFile(ctx context.Context, file stri
 
 	filePath := path.Join(ep.config.BucketPath, file)
 
+	bucket := ep.cloudStorageClient.Bucket(ep.stagingBucket)
 	f := &ExternalDataConnectionFile{
-		URI:       "gs:/" + fmt.Sprintf("/%s/%s", ep.config.Bucket, filePath),
+		URI:       "gs:/" + fmt.Sprintf("/%s/%s", ep.stagingBucket, filePath),
 		edc:       edc,
-		gcsObject: ep.cloudStorageClient.Bucket(ep.config.Bucket).Object(filePath),
+		gcsBucket: bucket,
+		gcsObject: bucket.Object(filePath),
 	}
 
 	// Make sure this ExternalDataConfig has no configured file already.
@@ -99,9 +102,22 @@ func (f *ExternalDataConnectionFile) Close() error {
 	return f.gcsWriter.Close()
 }
 
+// Release detaches the file from its ExternalDataConfig, without modifying the file itself.
+func (f *ExternalDataConnectionFile) Release() {
+	f.edc.SourceURIs = nil // Clear the SourceURIs so the next process can use it.
+}
+
 // Delete removes the file.
 func (f *ExternalDataConnectionFile) Delete(ctx context.Context) error {
-	f.edc.SourceURIs = nil // Clear the SourceURIs so the next process can use it.
+	return f.gcsObject.Delete(ctx)
+}
+
+// Archive moves the file to the given prefix within the same bucket.
+func (f *ExternalDataConnectionFile) Archive(ctx context.Context, prefix string) error {
+	archived := f.gcsBucket.Object(path.Join(prefix, path.Base(f.gcsObject.ObjectName())))
+	if _, err := archived.CopierFrom(f.gcsObject).Run(ctx); err != nil {
+		return fmt.Errorf("copying to archive: %w", err)
+	}
 	return f.gcsObject.Delete(ctx)
 }
 
@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/base64"
 	"fmt"
+	"strconv"
 	"strings"
 
 	"cloud.google.com/go/bigquery"
@@ -30,6 +31,10 @@ type Endpoint struct {
 	generator sqlDriver.Generator
 	// FlowTables
 	flowTables sqlDriver.FlowTables
+	// Resources of the bindings, as parsed by the driver.
+	resources []*tableConfig
+	// Bucket in which files are staged, once it has been resolved.
+	stagingBucket string
 }
 
 // Generator returns the Generator.
@@ -92,6 +97,12 @@ func (e *Endpoint) LoadSpec(ctx context.Context, materialization pf.Materializat
 // automatically excluded from transactions.
 func (e *Endpoint) ExecuteStatements(ctx context.Context, statements []string) error {
 
+	// Statements are executed when the materialization is applied, which fails before any are
+	// executed if files can't be staged in a bucket which is compatible with the dataset.
+	if err := e.resolveStagingBucket(ctx); err != nil {
+		return err
+	}
+
 	// Build a queue of statements than can be run in a transaction.
 	var statementQueue []string
 	var runStatementQueue = func() error {
@@ -159,6 +170,14 @@ func (e *Endpoint) CreateTableStatement(table *sqlDriver.Table) (string, error)
 	// a transaction.
 	builder.WriteString(bigQueryOmitFromTransactionComment)
 
+	// The default table expiration of the dataset is set along with the Flow tables, which
+	// are created whenever the materialization is applied.
+	if e.isFlowTable(table) && e.config.DatasetExpiration > 0 {
+		fmt.Fprintf(&builder, "ALTER SCHEMA %s SET OPTIONS(default_table_expiration_days = %s);\n",
+			e.generator.IdentifierRenderer.Render(e.config.DatasetPath().Join()),
+			strconv.FormatFloat(float64(e.config.DatasetExpiration)/secondsPerDay, 'f', -1, 64))
+	}
+
 	if len(table.Comment) > 0 {
 		_, _ = e.generator.CommentRenderer.Write(&builder, table.Comment, "")
 	}
@@ -174,7 +193,24 @@ func (e *Endpoint) CreateTableStatement(table *sqlDriver.Table) (string, error)
 	builder.WriteString(table.Identifier)
 	builder.WriteString(" (\n\t")
 
-	for i, column := range table.Columns {
+	// Materialized tables are given any configured metadata columns, following their fields.
+	var columns = table.Columns
+	if !e.isFlowTable(table) {
+		var metadata = e.config.metadataColumns()
+		if err := metadata.checkCollisions(columns); err != nil {
+			return "", err
+		}
+		columns = append(append([]sqlDriver.Column(nil), columns...), metadata.columns(e.generator)...)
+
+		// Tables using soft deletes are given the soft-delete columns as well.
+		var softDelete = e.softDeleteColumns(table)
+		if err := softDelete.checkCollisions(table.Columns); err != nil {
+			return "", err
+		}
+		columns = append(columns, softDelete.columns(e.generator)...)
+	}
+
+	for i, column := range columns {
 		if i > 0 {
 			builder.WriteString(",\n\t")
 		}
@@ -212,10 +248,62 @@ func (e *Endpoint) CreateTableStatement(table *sqlDriver.Table) (string, error)
 		builder.WriteString(strings.Join(pkIdentifiers, ","))
 	}
 
+	if !e.isFlowTable(table) && e.config.TableExpiration > 0 {
+		fmt.Fprintf(&builder, "\nOPTIONS(expiration_timestamp = TIMESTAMP_ADD(CURRENT_TIMESTAMP(), INTERVAL %d SECOND))",
+			e.config.TableExpiration)
+	}
 	builder.WriteRune(';')
+
+	// The view over a materialized table is replaced along with it, so that it reflects the
+	// current columns of the table whenever the materialization is applied.
+	if resource := e.resource(table); resource != nil && resource.View != "" {
+		var statement, err = resource.viewStatement(e.generator, table.Identifier, columns)
+		if err != nil {
+			return "", err
+		}
+		builder.WriteRune('\n')
+		builder.WriteString(statement)
+	}
+
+	// Flow tables must never expire, as the checkpoints of the materialization would be lost,
+	// so they're exempted from any default expiration of the dataset.
+	if e.isFlowTable(table) && e.config.DatasetExpiration > 0 {
+		fmt.Fprintf(&builder, "\nALTER TABLE %s SET OPTIONS(expiration_timestamp = NULL);", table.Identifier)
+	}
 	return builder.String(), nil
 }
 
+// secondsPerDay converts the dataset table expiration into the days of its
+// `default_table_expiration_days` option.
+const secondsPerDay = 24 * 60 * 60
+
+// isFlowTable returns whether the table is one of the Flow tables used to store
+// checkpoints and specs, rather than a materialized table.
+func (e *Endpoint) isFlowTable(table *sqlDriver.Table) bool {
+	return table.Identifier == e.flowTables.Checkpoints.Identifier ||
+		table.Identifier == e.flowTables.Specs.Identifier
+}
+
+// resource returns the resource of the binding which materializes into the table, or nil if
+// the table isn't that of a binding.
+func (e *Endpoint) resource(table *sqlDriver.Table) *tableConfig {
+	for _, resource := range e.resources {
+		if e.generator.IdentifierRenderer.Render(resource.Path().Join()) == table.Identifier {
+			return resource
+		}
+	}
+	return nil
+}
+
+// softDeleteColumns returns the soft-delete columns of the table, which are disabled unless
+// the resource of the table's binding uses soft deletes.
+func (e *Endpoint) softDeleteColumns(table *sqlDriver.Table) softDeleteColumns {
+	if resource := e.resource(table); resource != nil {
+		return resource.softDeleteColumns()
+	}
+	return softDeleteColumns{}
+}
+
 // NewFence installs and returns a new *Fence. On return, all older fences of
 // this |shardFqn| have been fenced off from committing further transactions.
 func (ep *Endpoint) NewFence(ctx context.Context, materialization pf.Materialization, keyBegin, keyEnd uint32) (sqlDriver.Fence, error) {
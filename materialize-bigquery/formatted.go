@@ -0,0 +1,55 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// Policies for string values which don't match the format of their field.
+const (
+	invalidStringsError = "error"
+	invalidStringsNull  = "null"
+)
+
+// formattedStringValue returns a value converter for string fields having a format, which parses
+// each string value and stages the parsed value as returned by parse. A string which can't be
+// parsed fails the conversion, or is staged as null if the policy is invalidStringsNull.
+func formattedStringValue(format, policy string, parse func(string) (interface{}, error)) func(interface{}) (interface{}, error) {
+	return func(i interface{}) (interface{}, error) {
+		var str, ok = i.(string)
+		if !ok {
+			return i, nil
+		}
+		var parsed, err = parse(str)
+		if err == nil {
+			return parsed, nil
+		} else if policy == invalidStringsNull {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("invalid %s string %q (set invalid_formatted_strings to null to load such values as null): %w", format, str, err)
+	}
+}
+
+// parseDate checks that a string is a full-date of RFC 3339, which is loaded into a DATE as is.
+func parseDate(str string) (interface{}, error) {
+	if _, err := time.Parse("2006-01-02", str); err != nil {
+		return nil, err
+	}
+	return str, nil
+}
+
+// parseDateTime parses a date-time of RFC 3339, which is staged in UTC at the microsecond
+// precision of a TIMESTAMP. Any further fractional digits are truncated.
+func parseDateTime(str string) (interface{}, error) {
+	var t, err = time.Parse(time.RFC3339Nano, str)
+	if err != nil {
+		return nil, err
+	}
+	return t.UTC().Format(bigQueryTimestampLayout), nil
+}
+
+// parseNumeric parses a string which holds a number into a json.Number having exactly the
+// same digits.
+func parseNumeric(str string) (interface{}, error) {
+	return numericStringValue(str)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// maxJobLabels is the maximum number of labels which BigQuery allows on a job.
+const maxJobLabels = 64
+
+// BigQuery label keys and values may only contain lowercase letters, digits, underscores, and
+// dashes, and are at most 63 characters long. Keys must also begin with a lowercase letter.
+// International characters are allowed.
+var (
+	labelKeyRegexp   = regexp.MustCompile(`^[\p{Ll}\p{Lo}][\p{Ll}\p{Lo}\p{N}_-]{0,62}$`)
+	labelValueRegexp = regexp.MustCompile(`^[\p{Ll}\p{Lo}\p{N}_-]{0,63}$`)
+)
+
+// validateJobLabels checks that labels satisfy BigQuery's constraints on job labels.
+func validateJobLabels(labels map[string]string) error {
+	if len(labels) > maxJobLabels {
+		return fmt.Errorf("too many job_labels: %d labels exceeds the maximum of %d", len(labels), maxJobLabels)
+	}
+	for key, value := range labels {
+		if !labelKeyRegexp.MatchString(key) {
+			return fmt.Errorf("invalid job_labels key %q: keys must begin with a lowercase letter, contain only lowercase letters, digits, underscores, and dashes, and be at most 63 characters long", key)
+		}
+		if !labelValueRegexp.MatchString(value) {
+			return fmt.Errorf("invalid job_labels value %q of key %q: values must contain only lowercase letters, digits, underscores, and dashes, and be at most 63 characters long", value, key)
+		}
+	}
+	return nil
+}
+
+// jobLabels returns the labels of a job which loads or merges the documents of the given
+// bindings. They're the configured labels of the endpoint, overridden by those of the bindings.
+// The documents of every binding are handled by a single job in each transaction, so a label
+// which bindings give different values is left off of the job rather than being attributed to
+// just one of them.
+func (ep *Endpoint) jobLabels(bindings ...*binding) map[string]string {
+	var labels = make(map[string]string)
+	for key, value := range ep.config.JobLabels {
+		labels[key] = value
+	}
+	var fromBinding = make(map[string]string)
+	var conflicting = make(map[string]bool)
+	for _, b := range bindings {
+		for key, value := range b.labels {
+			if prev, ok := fromBinding[key]; ok && prev != value {
+				conflicting[key] = true
+			}
+			fromBinding[key] = value
+		}
+	}
+	for key, value := range fromBinding {
+		if conflicting[key] {
+			delete(labels, key)
+		} else {
+			labels[key] = value
+		}
+	}
+
+	if len(labels) == 0 {
+		return nil
+	}
+	return labels
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	sqlDriver "github.com/estuary/flow/go/protocols/materialize/sql"
+	"github.com/google/uuid"
+)
+
+// metadataColumnRegexp matches the column names which BigQuery accepts without quoting.
+var metadataColumnRegexp = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// metadataColumns are the optional columns added to each materialized table, which record
+// when and in which batch each row was loaded.
+type metadataColumns struct {
+	loadedAt string // Name of the load timestamp column, or empty if it's not included.
+	batchID  string // Name of the batch identifier column, or empty if it's not included.
+}
+
+// metadataColumns returns the metadata columns selected by the config.
+func (c *config) metadataColumns() metadataColumns {
+	return metadataColumns{loadedAt: c.LoadedAtColumn, batchID: c.BatchIDColumn}
+}
+
+// validate checks that the configured column names are usable and distinct.
+func (m metadataColumns) validate() error {
+	for _, name := range []string{m.loadedAt, m.batchID} {
+		if name != "" && !metadataColumnRegexp.MatchString(name) {
+			return fmt.Errorf("invalid metadata column name %q: must contain only letters, numbers, and underscores", name)
+		}
+	}
+	if m.loadedAt != "" && strings.EqualFold(m.loadedAt, m.batchID) {
+		return fmt.Errorf("loaded_at_column and batch_id_column must be different")
+	}
+	return nil
+}
+
+// columns returns the table columns of the included metadata columns. Both are nullable,
+// so that they can be added to tables which already hold rows.
+func (m metadataColumns) columns(generator sqlDriver.Generator) []sqlDriver.Column {
+	var columns []sqlDriver.Column
+	if m.loadedAt != "" {
+		columns = append(columns, sqlDriver.Column{
+			Name:       m.loadedAt,
+			Identifier: generator.IdentifierRenderer.Render(m.loadedAt),
+			Comment:    "Time at which this row was loaded by Flow.",
+			Type:       sqlDriver.STRING,
+			StringType: &sqlDriver.StringTypeInfo{Format: "date-time"},
+		})
+	}
+	if m.batchID != "" {
+		columns = append(columns, sqlDriver.Column{
+			Name:       m.batchID,
+			Identifier: generator.IdentifierRenderer.Render(m.batchID),
+			Comment:    "Identifier of the Flow transaction which loaded this row.",
+			Type:       sqlDriver.STRING,
+		})
+	}
+	return columns
+}
+
+// checkCollisions returns an error if any of the included metadata columns has the same name
+// as a column of the table. BigQuery column names are case-insensitive.
+func (m metadataColumns) checkCollisions(columns []sqlDriver.Column) error {
+	for _, col := range columns {
+		for _, name := range []string{m.loadedAt, m.batchID} {
+			if name != "" && strings.EqualFold(identifierSanitizer(col.Name), name) {
+				return fmt.Errorf("metadata column %q collides with field %q", name, col.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// values returns the staged values of the included metadata columns, in column order.
+func (m metadataColumns) values(batch metadataBatch) []interface{} {
+	var values []interface{}
+	if m.loadedAt != "" {
+		values = append(values, batch.loadedAt.UTC().Format(bigQueryTimestampLayout))
+	}
+	if m.batchID != "" {
+		values = append(values, batch.id)
+	}
+	return values
+}
+
+// bigQueryTimestampLayout formats a time at the microsecond precision of a BigQuery TIMESTAMP.
+const bigQueryTimestampLayout = "2006-01-02T15:04:05.999999Z07:00"
+
+// metadataBatch identifies the transaction in which rows are loaded.
+type metadataBatch struct {
+	loadedAt time.Time
+	id       string
+}
+
+// newMetadataBatch returns a metadataBatch for a new transaction.
+func newMetadataBatch() metadataBatch {
+	return metadataBatch{loadedAt: time.Now(), id: uuid.NewString()}
+}
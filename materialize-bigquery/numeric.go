@@ -0,0 +1,218 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+	log "github.com/sirupsen/logrus"
+)
+
+// Policies for numeric values having more decimal digits than the scale of their column allows.
+const (
+	numericOverflowError    = "error"
+	numericOverflowRound    = "round"
+	numericOverflowTruncate = "truncate"
+)
+
+// The scales of unparameterized NUMERIC and BIGNUMERIC columns.
+const (
+	numericDefaultScale    = 9
+	bigNumericDefaultScale = 38
+)
+
+// numericColumn is a staged column whose target column is a NUMERIC or BIGNUMERIC.
+type numericColumn struct {
+	index int // Index of the column within a staged row.
+	name  string
+	scale int
+}
+
+// columnScale returns the scale of a NUMERIC or BIGNUMERIC column, and false for other types.
+func columnScale(field *bigquery.FieldSchema) (int, bool) {
+	switch field.Type {
+	case bigquery.NumericFieldType:
+		if field.Precision == 0 {
+			return numericDefaultScale, true
+		}
+	case bigquery.BigNumericFieldType:
+		if field.Precision == 0 {
+			return bigNumericDefaultScale, true
+		}
+	default:
+		return 0, false
+	}
+	return int(field.Scale), true
+}
+
+// numericColumns returns the staged columns whose columns of the target table are NUMERIC or
+// BIGNUMERIC. Column names are matched case-insensitively, as they are by BigQuery.
+func numericColumns(staged []*bigquery.FieldSchema, table bigquery.Schema) []numericColumn {
+	var scales = make(map[string]int)
+	for _, field := range table {
+		if scale, ok := columnScale(field); ok {
+			scales[strings.ToLower(field.Name)] = scale
+		}
+	}
+
+	var columns []numericColumn
+	for index, field := range staged {
+		if scale, ok := scales[strings.ToLower(field.Name)]; ok {
+			columns = append(columns, numericColumn{index: index, name: field.Name, scale: scale})
+		}
+	}
+	return columns
+}
+
+// fetchTableSchema returns the schema of the target table of a binding.
+func fetchTableSchema(ctx context.Context, client *bigquery.Client, resource *tableConfig) (bigquery.Schema, error) {
+	var meta, err = client.DatasetInProject(resource.base.ProjectID, resource.base.Dataset).Table(resource.Table).Metadata(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("fetching table metadata: %w", err)
+	}
+	return meta.Schema, nil
+}
+
+// numericCoercer applies the numeric overflow policy to the numeric columns of staged rows.
+type numericCoercer struct {
+	policy  string
+	columns []numericColumn
+	// Number of values coerced since the last call to reset.
+	coerced int
+}
+
+// newNumericCoercer returns a numericCoercer for the columns, or nil if there are none.
+func newNumericCoercer(policy string, columns []numericColumn) *numericCoercer {
+	if len(columns) == 0 {
+		return nil
+	}
+	if policy == "" {
+		policy = numericOverflowError
+	}
+	return &numericCoercer{policy: policy, columns: columns}
+}
+
+// coerce applies the policy to the values of a staged row which exceed the scale of their column.
+// Coerced values are replaced by a json.Number, so that they're staged exactly.
+func (c *numericCoercer) coerce(row []interface{}) error {
+	if c == nil {
+		return nil
+	}
+	for _, col := range c.columns {
+		var value = row[col.index]
+
+		var str string
+		switch v := value.(type) {
+		case float64:
+			str = strconv.FormatFloat(v, 'f', -1, 64)
+		case json.Number:
+			str = v.String()
+		case string:
+			str = v
+		default:
+			continue
+		}
+
+		var r, ok = new(big.Rat).SetString(str)
+		if !ok {
+			continue // Not a number, which is left for BigQuery to reject.
+		}
+		var pow = new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(col.scale)), nil)
+		var scaled = new(big.Rat).Mul(r, new(big.Rat).SetInt(pow))
+		if scaled.IsInt() {
+			continue
+		}
+
+		var coerced string
+		switch c.policy {
+		case numericOverflowRound:
+			// Halves are rounded away from zero, as they are by BigQuery's ROUND.
+			coerced = r.FloatString(col.scale)
+		case numericOverflowTruncate:
+			var truncated = new(big.Int).Quo(scaled.Num(), scaled.Denom())
+			coerced = new(big.Rat).SetFrac(truncated, pow).FloatString(col.scale)
+		default:
+			return fmt.Errorf("value %s of column %q exceeds the column's scale of %d (set numeric_overflow to round or truncate such values)", str, col.name, col.scale)
+		}
+		coerced = trimDecimal(coerced)
+
+		log.WithFields(log.Fields{
+			"column":  col.name,
+			"scale":   col.scale,
+			"value":   str,
+			"coerced": coerced,
+		}).Debug("coerced numeric value")
+
+		row[col.index] = json.Number(coerced)
+		c.coerced++
+	}
+	return nil
+}
+
+// reset returns the number of values coerced since the last reset.
+func (c *numericCoercer) reset() int {
+	if c == nil {
+		return 0
+	}
+	var n = c.coerced
+	c.coerced = 0
+	return n
+}
+
+// trimDecimal removes trailing fractional zeros from a decimal string.
+func trimDecimal(s string) string {
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	if s == "-0" {
+		s = "0"
+	}
+	return s
+}
+
+// numberValue converts an integer value of a NUMBER column into a json.Number, so that it's staged
+// with all of its digits. Big integers which can't be represented by an int64 or uint64 wouldn't be
+// staged as numbers at all otherwise. Floats have already lost any digits beyond their precision
+// when they were decoded, so they're staged as they are.
+func numberValue(i interface{}) (interface{}, error) {
+	switch v := i.(type) {
+	case float64:
+		if math.IsNaN(v) || math.IsInf(v, 0) {
+			return nil, fmt.Errorf("invalid number %v for a numeric column", v)
+		}
+		return v, nil
+	case int64:
+		return json.Number(strconv.FormatInt(v, 10)), nil
+	case uint64:
+		return json.Number(strconv.FormatUint(v, 10)), nil
+	case big.Int:
+		return json.Number(v.String()), nil
+	case *big.Int:
+		return json.Number(v.String()), nil
+	case string:
+		return numericStringValue(v)
+	default:
+		return i, nil
+	}
+}
+
+// decimalLiteral matches the strings which are valid JSON numbers.
+var decimalLiteral = regexp.MustCompile(`^-?(0|[1-9][0-9]*)(\.[0-9]+)?([eE][+-]?[0-9]+)?$`)
+
+// numericStringValue converts a string which holds a number, such as the value of a string field
+// with a format of "integer" or "number", into a json.Number having exactly the same digits.
+func numericStringValue(i interface{}) (interface{}, error) {
+	var str, ok = i.(string)
+	if !ok {
+		return i, nil
+	} else if !decimalLiteral.MatchString(str) {
+		return nil, fmt.Errorf("invalid number %q for a numeric column", str)
+	}
+	return json.Number(str), nil
+}
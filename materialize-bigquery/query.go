@@ -27,6 +27,7 @@ func (ep *Endpoint) newQuery(queryString string, parameters ...interface{}) *big
 	// Create the query
 	query := ep.bigQueryClient.Query(queryString)
 	query.Location = ep.config.Region
+	query.Labels = ep.jobLabels()
 	// Add parameters
 	for _, p := range parameters {
 		query.Parameters = append(query.Parameters, bigquery.QueryParameter{Value: p})
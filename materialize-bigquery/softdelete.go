@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	sqlDriver "github.com/estuary/flow/go/protocols/materialize/sql"
+)
+
+// Default names of the soft-delete columns.
+const (
+	defaultSoftDeleteColumn    = "_deleted"
+	defaultSoftDeletedAtColumn = "_deleted_at"
+)
+
+// softDeleteColumns are the columns added to the tables of bindings which use soft deletes.
+// Rather than removing the row of a deleted document, the MERGE marks it as deleted and
+// records when that happened, leaving the rest of the row as it was.
+type softDeleteColumns struct {
+	deleted   string // Name of the BOOL column marking deleted rows, or empty if soft deletes are disabled.
+	deletedAt string // Name of the TIMESTAMP column recording when each row was deleted.
+	// Whether the delete of a key which has no row inserts a row that's marked as deleted,
+	// recording that the key was deleted.
+	tombstones bool
+}
+
+// softDeleteColumns returns the soft-delete columns named by the config, with defaults applied.
+func (c *config) softDeleteColumns() softDeleteColumns {
+	var cols = softDeleteColumns{deleted: c.SoftDeleteColumn, deletedAt: c.SoftDeletedAtColumn, tombstones: true}
+	if cols.deleted == "" {
+		cols.deleted = defaultSoftDeleteColumn
+	}
+	if cols.deletedAt == "" {
+		cols.deletedAt = defaultSoftDeletedAtColumn
+	}
+	return cols
+}
+
+// enabled returns whether soft deletes are used.
+func (s softDeleteColumns) enabled() bool {
+	return s.deleted != ""
+}
+
+// validate checks that the column names are usable and distinct from each other and from the
+// metadata columns.
+func (s softDeleteColumns) validate(metadata metadataColumns) error {
+	for _, name := range []string{s.deleted, s.deletedAt} {
+		if !metadataColumnRegexp.MatchString(name) {
+			return fmt.Errorf("invalid soft-delete column name %q: must contain only letters, numbers, and underscores", name)
+		}
+		for _, other := range []string{metadata.loadedAt, metadata.batchID} {
+			if strings.EqualFold(name, other) {
+				return fmt.Errorf("soft-delete column %q collides with a metadata column", name)
+			}
+		}
+	}
+	if strings.EqualFold(s.deleted, s.deletedAt) {
+		return fmt.Errorf("soft_delete_column and soft_deleted_at_column must be different")
+	}
+	return nil
+}
+
+// columns returns the table columns of the soft-delete columns, if enabled. Both are nullable,
+// so that they can be added to tables which already hold rows.
+func (s softDeleteColumns) columns(generator sqlDriver.Generator) []sqlDriver.Column {
+	if !s.enabled() {
+		return nil
+	}
+	return []sqlDriver.Column{
+		{
+			Name:       s.deleted,
+			Identifier: generator.IdentifierRenderer.Render(s.deleted),
+			Comment:    "Whether the document of this row has been deleted.",
+			Type:       sqlDriver.BOOLEAN,
+		},
+		{
+			Name:       s.deletedAt,
+			Identifier: generator.IdentifierRenderer.Render(s.deletedAt),
+			Comment:    "Time at which the document of this row was deleted.",
+			Type:       sqlDriver.STRING,
+			StringType: &sqlDriver.StringTypeInfo{Format: "date-time"},
+		},
+	}
+}
+
+// checkCollisions returns an error if either soft-delete column has the same name as a column
+// of the table. BigQuery column names are case-insensitive.
+func (s softDeleteColumns) checkCollisions(columns []sqlDriver.Column) error {
+	if !s.enabled() {
+		return nil
+	}
+	for _, col := range columns {
+		for _, name := range []string{s.deleted, s.deletedAt} {
+			if strings.EqualFold(identifierSanitizer(col.Name), name) {
+				return fmt.Errorf("soft-delete column %q collides with field %q", name, col.Name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import "context"
+
+// Policies for cleaning up staged Cloud Storage objects after they've been loaded into BigQuery.
+const (
+	stagingCleanupDelete  = "delete"
+	stagingCleanupKeep    = "keep"
+	stagingCleanupArchive = "archive-to-prefix"
+)
+
+// stagedFile is a staged object which is cleaned up once its transaction completes.
+type stagedFile interface {
+	Release()
+	Delete(ctx context.Context) error
+	Archive(ctx context.Context, prefix string) error
+}
+
+// cleanupStagedFile releases a staged file and applies the configured staging cleanup policy to it.
+// A file that was not successfully loaded is always kept, so that it remains available for
+// inspection or reprocessing.
+func cleanupStagedFile(ctx context.Context, cfg *config, f stagedFile, loaded bool) error {
+	f.Release()
+	if !loaded {
+		return nil
+	}
+
+	switch cfg.StagingCleanup {
+	case stagingCleanupKeep:
+		return nil
+	case stagingCleanupArchive:
+		return f.Archive(ctx, cfg.ArchivePrefix)
+	default:
+		return f.Delete(ctx)
+	}
+}
+
+// quarantineStagedFile releases a staged file from which bad rows were skipped, and moves it
+// under the configured bad records prefix so that the skipped rows can be inspected.
+func quarantineStagedFile(ctx context.Context, cfg *config, f stagedFile) error {
+	f.Release()
+	return f.Archive(ctx, cfg.BadRecordsPrefix)
+}
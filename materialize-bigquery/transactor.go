@@ -19,10 +19,10 @@ type transactor struct {
 	ep       *Endpoint
 	fence    *fence
 	bindings []*binding
+	batch    metadataBatch // Values of the metadata columns for the current transaction.
 }
 
-func (t *transactor) Load(it *pm.LoadIterator, _, _ <-chan struct{}, loaded func(int, json.RawMessage) error) error {
-	var err error
+func (t *transactor) Load(it *pm.LoadIterator, _, _ <-chan struct{}, loaded func(int, json.RawMessage) error) (err error) {
 	var ctx = it.Context()
 
 	// Iterate through all of the Load requests being made.
@@ -42,12 +42,10 @@ func (t *transactor) Load(it *pm.LoadIterator, _, _ <-chan struct{}, loaded func
 			}
 
 			// Clean up the temporary files when load complete (or we error out).
-			defer func() {
-				if err := b.load.keysFile.Delete(ctx); err != nil {
-					log.Errorf("could not delete load keyfile: %v", err)
-				}
-				b.load.keysFile = nil // blank it
-			}()
+			defer func(defb *binding) {
+				t.cleanupStagedFile(ctx, defb.load.keysFile, err == nil)
+				defb.load.keysFile = nil // blank it
+			}(b)
 		}
 
 		// Convert our key tuple into a slice of values appropriate for the database.
@@ -70,9 +68,11 @@ func (t *transactor) Load(it *pm.LoadIterator, _, _ <-chan struct{}, loaded func
 	var subqueries []string
 	// This is the map of external table references we will populate.
 	var edcTableDefs = make(map[string]bigquery.ExternalData)
+	var loading []*binding
 	for _, b := range t.bindings {
 		// If we have a data file for this round.
 		if b.load.keysFile != nil {
+			loading = append(loading, b)
 
 			// Flush and close the keyfile.
 			if err := b.load.keysFile.Close(); err != nil {
@@ -94,6 +94,7 @@ func (t *transactor) Load(it *pm.LoadIterator, _, _ <-chan struct{}, loaded func
 	// Build the query across all tables.
 	query := t.ep.newQuery(strings.Join(subqueries, "\nUNION ALL\n") + ";")
 	query.TableDefinitions = edcTableDefs // Tell bigquery where to get the external references in gcs.
+	query.Labels = t.ep.jobLabels(loading...)
 	job, err := t.ep.runQuery(ctx, query)
 	if err != nil {
 		return fmt.Errorf("load query: %w", err)
@@ -129,6 +130,7 @@ func (t *transactor) Prepare(_ context.Context, prepare pm.TransactionRequest_Pr
 	// This is triggered to let you know that the loads have completed.
 	// It also tells us what checkpoint we are about to store.
 	t.fence.checkpoint = prepare.FlowCheckpoint
+	t.batch = newMetadataBatch()
 	return pf.DriverCheckpoint{}, nil
 }
 
@@ -158,21 +160,42 @@ func (t *transactor) Store(it *pm.StoreIterator) error {
 		if b.store.hasRootDocument {
 			vals = append(vals, it.RawJSON)
 		}
-		if converted, err := b.store.paramsConverter.Convert(vals); err != nil {
+		var converted []interface{}
+		if converted, err = b.store.paramsConverter.Convert(vals); err != nil {
 			return fmt.Errorf("converting Store: %w", err)
-		} else if err = b.store.mergeFile.WriteRow(converted); err != nil {
+		} else if err = b.store.numerics.coerce(converted); err != nil {
+			return fmt.Errorf("converting Store: %w", err)
+		}
+		var row = append(converted, b.store.metadata.values(t.batch)...)
+		if err = b.store.validator.validate(row); err != nil {
+			return fmt.Errorf("validating Store: %w", err)
+		} else if err = b.store.mergeFile.WriteRow(row); err != nil {
 			return fmt.Errorf("encoding Store to scratch file: %w", err)
+		} else if b.store.keyRange != nil {
+			b.store.keyRange.update(it.Key[0], converted[0])
+		}
+	}
+
+	for _, b := range t.bindings {
+		b.store.validator.reset()
+		if n := b.store.numerics.reset(); n != 0 {
+			log.WithFields(log.Fields{
+				"table":   b.name,
+				"policy":  b.store.numerics.policy,
+				"coerced": n,
+			}).Info("coerced numeric values exceeding the scale of their columns")
 		}
 	}
 
 	return nil
 }
 
-func (t *transactor) Commit(ctx context.Context) error {
+func (t *transactor) Commit(ctx context.Context) (err error) {
 
 	// Build the slice of transactions required for a commit.
 	var subqueries []string
 	var args []interface{}
+	var skipped int // The number of bad rows skipped by the commit.
 
 	// First we must validate the fence has not been modified.
 	subqueries = append(subqueries, fmt.Sprintf(`
@@ -218,9 +241,11 @@ func (t *transactor) Commit(ctx context.Context) error {
 	// This is the map of external table references we will populate. Loop through the bindings and
 	// append the SQL for that table.
 	var edcTableDefs = make(map[string]bigquery.ExternalData)
+	var storing []*binding
 	for _, b := range t.bindings {
 
 		if b.store.mergeFile != nil {
+			storing = append(storing, b)
 			if err := b.store.mergeFile.Close(); err != nil {
 				return fmt.Errorf("mergefile close: %w", err)
 			}
@@ -229,13 +254,21 @@ func (t *transactor) Commit(ctx context.Context) error {
 			edcTableDefs[b.store.tempTableName] = b.store.extDataConfig
 
 			subqueries = append(subqueries, b.store.sql)
+			if b.store.keyRange != nil {
+				args = append(args, b.store.keyRange.min, b.store.keyRange.max)
+			}
 
 			// Clean up the temporary files when store complete (or we error out).
 			defer func(defb *binding) {
-				if err := defb.store.mergeFile.Delete(ctx); err != nil {
-					log.Errorf("could not delete store mergefile: %v", err)
+				if err == nil && skipped != 0 && t.ep.config.BadRecordsPrefix != "" {
+					t.quarantineStagedFile(ctx, defb.store.mergeFile)
+				} else {
+					t.cleanupStagedFile(ctx, defb.store.mergeFile, err == nil)
 				}
 				defb.store.mergeFile = nil
+				if defb.store.keyRange != nil {
+					defb.store.keyRange.reset()
+				}
 			}(b) // b is part of for loop, make sure you're referencing the right b.
 		}
 	}
@@ -255,6 +288,7 @@ func (t *transactor) Commit(ctx context.Context) error {
 	// Build the bigquery query of the combined subqueries.
 	query := t.ep.newQuery(strings.Join(subqueries, "\n"), args...)
 	query.TableDefinitions = edcTableDefs // Tell the query where to get the external references in gcs.
+	query.Labels = t.ep.jobLabels(storing...)
 
 	// This returns a single row with the error status of the query.
 	job, err := t.ep.runQuery(ctx, query)
@@ -274,10 +308,34 @@ func (t *transactor) Commit(ctx context.Context) error {
 	if queryStatus.Error != "" {
 		return fmt.Errorf("merge error: %s", queryStatus.Error)
 	}
+	skipped = logSkippedRecords(job.LastStatus())
 
 	return nil
 }
 
+// cleanupStagedFile applies the staging cleanup policy to a file, logging rather than failing the
+// transaction if it can't be cleaned up.
+func (t *transactor) cleanupStagedFile(ctx context.Context, f *ExternalDataConnectionFile, loaded bool) {
+	if !loaded {
+		log.WithField("uri", f.URI).Warn("keeping staged file of failed load")
+	}
+	if err := cleanupStagedFile(ctx, t.ep.config, f, loaded); err != nil {
+		log.WithField("uri", f.URI).Errorf("could not clean up staged file: %v", err)
+	}
+}
+
+// quarantineStagedFile moves a file from which bad rows were skipped under the bad records prefix,
+// logging rather than failing the transaction if it can't be moved.
+func (t *transactor) quarantineStagedFile(ctx context.Context, f *ExternalDataConnectionFile) {
+	log.WithFields(log.Fields{
+		"uri":    f.URI,
+		"prefix": t.ep.config.BadRecordsPrefix,
+	}).Warn("quarantining staged file with skipped bad records")
+	if err := quarantineStagedFile(ctx, t.ep.config, f); err != nil {
+		log.WithField("uri", f.URI).Errorf("could not quarantine staged file: %v", err)
+	}
+}
+
 func (t *transactor) Acknowledge(context.Context) error {
 	return nil
 }
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+// validatedColumn is a staged column and the column of the target table it's merged into, or
+// nil if the table has no such column.
+type validatedColumn struct {
+	name  string
+	field *bigquery.FieldSchema
+}
+
+// rowValidator checks staged rows against the schema of their target table before they're
+// merged, so that a row which doesn't fit the table fails the transaction with an error naming
+// each incompatible column, rather than failing the merge job with a less specific one. Only the
+// first rows staged for a table in each transaction are checked, since checking every row would
+// slow down large transactions.
+type rowValidator struct {
+	table   string
+	limit   int
+	columns []validatedColumn
+	// Number of rows checked since the last call to reset.
+	checked int
+}
+
+// newRowValidator returns a rowValidator of the staged columns of a table which checks up to
+// limit rows of each transaction, or nil if limit is zero. Column names are matched
+// case-insensitively, as they are by BigQuery.
+func newRowValidator(limit int, table string, staged []*bigquery.FieldSchema, schema bigquery.Schema) *rowValidator {
+	if limit <= 0 {
+		return nil
+	}
+	var fields = make(map[string]*bigquery.FieldSchema)
+	for _, field := range schema {
+		fields[strings.ToLower(field.Name)] = field
+	}
+	var v = &rowValidator{table: table, limit: limit}
+	for _, field := range staged {
+		v.columns = append(v.columns, validatedColumn{
+			name:  field.Name,
+			field: fields[strings.ToLower(field.Name)],
+		})
+	}
+	return v
+}
+
+// validate checks a staged row, unless the limit of rows has already been checked in this
+// transaction. An error describes every column of the row whose value doesn't fit.
+func (v *rowValidator) validate(row []interface{}) error {
+	if v == nil || v.checked >= v.limit {
+		return nil
+	}
+	v.checked++
+
+	var problems []string
+	for idx, col := range v.columns {
+		if err := validateValue(col.field, row[idx]); err != nil {
+			problems = append(problems, fmt.Sprintf("column %q: %v", col.name, err))
+		}
+	}
+	if len(problems) != 0 {
+		return fmt.Errorf("staged row %d doesn't fit the schema of table %q: %s", v.checked, v.table, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// reset prepares the validator to check the rows of the next transaction.
+func (v *rowValidator) reset() {
+	if v != nil {
+		v.checked = 0
+	}
+}
+
+// validateValue checks that a staged value can be loaded into a column. Values of column types
+// which the connector never stages are assumed to fit.
+func validateValue(field *bigquery.FieldSchema, value interface{}) error {
+	if field == nil {
+		return fmt.Errorf("the table has no such column")
+	}
+	if raw, ok := value.(json.RawMessage); ok && raw == nil {
+		value = nil
+	}
+	if value == nil {
+		if field.Required {
+			return fmt.Errorf("null value for REQUIRED column")
+		}
+		return nil
+	}
+
+	var incompatible = func() error {
+		return fmt.Errorf("value %v of type %T is incompatible with column type %s", value, value, field.Type)
+	}
+	switch field.Type {
+	case bigquery.StringFieldType:
+		switch value.(type) {
+		case string, json.RawMessage:
+			return nil
+		}
+	case bigquery.IntegerFieldType:
+		switch v := value.(type) {
+		case int, int32, int64:
+			return nil
+		case uint64:
+			if v > math.MaxInt64 {
+				return fmt.Errorf("value %d is out of the range of an INT64", v)
+			}
+			return nil
+		case json.Number:
+			if _, err := v.Int64(); err != nil {
+				return incompatible()
+			}
+			return nil
+		}
+	case bigquery.FloatFieldType:
+		switch value.(type) {
+		case int, int32, int64, uint64, float32, float64, json.Number:
+			return nil
+		}
+	case bigquery.NumericFieldType, bigquery.BigNumericFieldType:
+		switch v := value.(type) {
+		case int, int32, int64, uint64:
+			return nil
+		case float64:
+			if math.IsNaN(v) || math.IsInf(v, 0) {
+				return incompatible()
+			}
+			return nil
+		case json.Number:
+			if _, ok := new(big.Rat).SetString(v.String()); !ok {
+				return incompatible()
+			}
+			return nil
+		}
+	case bigquery.BooleanFieldType:
+		if _, ok := value.(bool); ok {
+			return nil
+		}
+	case bigquery.BytesFieldType:
+		switch value.(type) {
+		case []byte, string:
+			return nil
+		}
+	case bigquery.DateFieldType:
+		if str, ok := value.(string); ok {
+			if _, err := time.Parse("2006-01-02", str); err != nil {
+				return fmt.Errorf("value %q is not a DATE", str)
+			}
+			return nil
+		}
+	case bigquery.TimestampFieldType:
+		if str, ok := value.(string); ok {
+			if _, err := time.Parse(time.RFC3339Nano, str); err != nil {
+				return fmt.Errorf("value %q is not a TIMESTAMP", str)
+			}
+			return nil
+		}
+	default:
+		return nil
+	}
+	return incompatible()
+}
@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	sqlDriver "github.com/estuary/flow/go/protocols/materialize/sql"
+)
+
+// viewTablePlaceholder is replaced in the query of a view by the identifier of the
+// materialized table which the view is created over.
+const viewTablePlaceholder = "{table}"
+
+// viewKeywords are the words of a view query which are never taken to be column references:
+// the reserved keywords of BigQuery, along with the names of types and date parts which may
+// appear unquoted within expressions.
+var viewKeywords = map[string]bool{}
+
+func init() {
+	for _, word := range strings.Fields(`
+		ALL AND ANY ARRAY AS ASC ASSERT_ROWS_MODIFIED AT BETWEEN BY CASE CAST COLLATE CONTAINS
+		CREATE CROSS CUBE CURRENT DEFAULT DEFINE DESC DISTINCT ELSE END ENUM ESCAPE EXCEPT EXCLUDE
+		EXISTS EXTRACT FALSE FETCH FOLLOWING FOR FROM FULL GROUP GROUPING GROUPS HASH HAVING IF
+		IGNORE IN INNER INTERSECT INTERVAL INTO IS JOIN LATERAL LEFT LIKE LIMIT LOOKUP MERGE
+		NATURAL NEW NO NOT NULL NULLS OF ON OR ORDER OUTER OVER PARTITION PRECEDING PROTO QUALIFY
+		RANGE RECURSIVE RESPECT RIGHT ROLLUP ROWS SELECT SET SOME STRUCT TABLESAMPLE THEN TO TREAT
+		TRUE UNBOUNDED UNION UNNEST USING WHEN WHERE WINDOW WITH WITHIN
+		REPLACE OFFSET ORDINAL SAFE_OFFSET SAFE_ORDINAL
+		BOOL BYTES DATE DATETIME FLOAT64 GEOGRAPHY INT64 JSON NUMERIC BIGNUMERIC STRING TIME TIMESTAMP
+		MICROSECOND MILLISECOND SECOND MINUTE HOUR DAY DAYOFWEEK DAYOFYEAR WEEK ISOWEEK MONTH
+		QUARTER YEAR ISOYEAR
+	`) {
+		viewKeywords[word] = true
+	}
+}
+
+// validateView checks that a view is configured along with its query, and that the query
+// selects from the table.
+func (c *tableConfig) validateView() error {
+	if c.View == "" && c.ViewQuery == "" {
+		return nil
+	} else if c.View == "" {
+		return fmt.Errorf("view_query requires view")
+	} else if strings.TrimSpace(c.ViewQuery) == "" {
+		return fmt.Errorf("view requires view_query")
+	} else if !metadataColumnRegexp.MatchString(c.View) {
+		return fmt.Errorf("invalid view name %q: must contain only letters, numbers, and underscores", c.View)
+	} else if strings.EqualFold(c.View, c.Table) {
+		return fmt.Errorf("view %q must have a different name than its table", c.View)
+	}
+
+	var words = strings.Fields(c.ViewQuery)
+	if first := strings.ToUpper(words[0]); first != "SELECT" && first != "WITH" {
+		return fmt.Errorf("invalid view_query of view %q: must be a SELECT statement", c.View)
+	} else if !strings.Contains(c.ViewQuery, viewTablePlaceholder) {
+		return fmt.Errorf("invalid view_query of view %q: must select from the table as %s", c.View, viewTablePlaceholder)
+	}
+	return nil
+}
+
+// viewStatement returns the statement which creates or replaces the resource's view over
+// the table having the given identifier and columns.
+func (c tableConfig) viewStatement(generator sqlDriver.Generator, tableIdentifier string, columns []sqlDriver.Column) (string, error) {
+	var query, err = c.viewQuery(tableIdentifier, columns)
+	if err != nil {
+		return "", err
+	}
+	// The query is terminated on a line of its own, in case its last line ends with a comment.
+	return fmt.Sprintf("CREATE OR REPLACE VIEW %s AS\n%s\n;",
+		generator.IdentifierRenderer.Render(c.base.DatasetPath(c.View).Join()), query), nil
+}
+
+// viewQuery returns the query of the resource's view over the table having the given
+// identifier, or an error if the query references a column which the table doesn't have.
+// Column names are those of BigQuery, which compares them case-insensitively.
+func (c tableConfig) viewQuery(tableIdentifier string, columns []sqlDriver.Column) (string, error) {
+	var known = make(map[string]bool)
+	for _, col := range columns {
+		known[strings.ToLower(identifierSanitizer(col.Name))] = true
+	}
+	var query = strings.TrimRight(strings.TrimSpace(c.ViewQuery), "; \t\n")
+
+	var tokens = scanViewQuery(query)
+	// Names defined by the query, such as the aliases of columns, tables, and common table
+	// expressions, may be referenced anywhere in it.
+	for i, token := range tokens {
+		if token.alias {
+			known[strings.ToLower(token.name)] = true
+		} else if i > 0 && strings.EqualFold(tokens[i-1].name, "AS") && !tokens[i-1].quoted {
+			known[strings.ToLower(token.name)] = true
+		}
+	}
+	for _, token := range tokens {
+		if token.function || (!token.quoted && viewKeywords[strings.ToUpper(token.name)]) {
+			continue
+		} else if !known[strings.ToLower(token.name)] {
+			return "", fmt.Errorf("view %q references column %q which table %q doesn't have", c.View, token.name, c.Table)
+		}
+	}
+	return strings.ReplaceAll(query, viewTablePlaceholder, tableIdentifier), nil
+}
+
+// viewToken is a name within the query of a view.
+type viewToken struct {
+	name     string
+	quoted   bool // Whether the name is quoted by backticks.
+	function bool // Whether the name is that of a function, being followed by a parenthesis.
+	alias    bool // Whether the name is that of a common table expression, being followed by `AS (`.
+}
+
+// scanViewQuery returns the names within a query, skipping over its literals, comments, and
+// parameters, along with the placeholder of the materialized table.
+func scanViewQuery(query string) []viewToken {
+	var tokens []viewToken
+
+	for i := 0; i < len(query); {
+		var c = query[i]
+		switch {
+		case strings.HasPrefix(query[i:], viewTablePlaceholder):
+			i += len(viewTablePlaceholder)
+		case strings.HasPrefix(query[i:], "--"), c == '#':
+			if end := strings.IndexByte(query[i:], '\n'); end < 0 {
+				i = len(query)
+			} else {
+				i += end
+			}
+		case strings.HasPrefix(query[i:], "/*"):
+			if end := strings.Index(query[i+2:], "*/"); end < 0 {
+				i = len(query)
+			} else {
+				i += 2 + end + 2
+			}
+		case c == '\'' || c == '"':
+			// Skip the string literal, including any escaped quotes.
+			for i++; i < len(query) && query[i] != c; i++ {
+				if query[i] == '\\' {
+					i++
+				}
+			}
+			i++
+		case c == '@':
+			// Query parameters and system variables aren't columns.
+			for i++; i < len(query) && (query[i] == '@' || isNameByte(query[i])); i++ {
+			}
+		case c >= '0' && c <= '9':
+			for ; i < len(query) && (isNameByte(query[i]) || query[i] == '.'); i++ {
+			}
+		case c == '`':
+			var end = strings.IndexByte(query[i+1:], '`')
+			if end < 0 {
+				end = len(query) - i - 1
+			}
+			var name = query[i+1 : i+1+end]
+			if i += end + 2; i > len(query) {
+				i = len(query)
+			}
+			tokens = append(tokens, newViewToken(name, true, query[i:]))
+		case isNameByte(c):
+			var start = i
+			for ; i < len(query) && isNameByte(query[i]); i++ {
+			}
+			var name, rest = query[start:i], strings.TrimLeftFunc(query[i:], unicode.IsSpace)
+			// A name followed by a quote is the prefix of a literal, such as a raw string
+			// or a DATE literal, rather than a column.
+			if i < len(query) && (query[i] == '\'' || query[i] == '"') {
+				continue
+			} else if viewKeywords[strings.ToUpper(name)] && (strings.HasPrefix(rest, "'") || strings.HasPrefix(rest, `"`)) {
+				continue
+			}
+			tokens = append(tokens, newViewToken(name, false, query[i:]))
+		default:
+			i++
+		}
+	}
+	return tokens
+}
+
+// newViewToken returns the token of a name, given the remainder of the query following it.
+func newViewToken(name string, quoted bool, rest string) viewToken {
+	var token = viewToken{name: name, quoted: quoted}
+	rest = strings.TrimLeftFunc(rest, unicode.IsSpace)
+	if strings.HasPrefix(rest, "(") {
+		token.function = true
+	} else if len(rest) > 2 && strings.EqualFold(rest[:2], "AS") && !isNameByte(rest[2]) {
+		token.alias = strings.HasPrefix(strings.TrimLeftFunc(rest[2:], unicode.IsSpace), "(")
+	}
+	return token
+}
+
+// isNameByte returns whether the byte may be part of an unquoted name. Bytes of multi-byte
+// characters are taken to be letters.
+func isNameByte(b byte) bool {
+	return b == '_' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || b >= 0x80
+}
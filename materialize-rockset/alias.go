@@ -0,0 +1,124 @@
+package materialize_rockset
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	rockset "github.com/rockset/rockset-go-client"
+	rtypes "github.com/rockset/rockset-go-client/openapi"
+	log "github.com/sirupsen/logrus"
+)
+
+// aliasTarget returns the qualified name of a collection, as it's listed in the collections of an
+// alias.
+func aliasTarget(workspace, collection string) string {
+	return workspace + "." + collection
+}
+
+func getAlias(ctx context.Context, client *rockset.RockClient, workspace string, alias string) (*rtypes.Alias, error) {
+	res, err := client.GetAlias(ctx, workspace, alias)
+	if se, ok := err.(rockset.Error); ok && se.IsNotFoundError() {
+		// Everything worked, but this alias does not exist.
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to fetch alias `%s`: %w", alias, err)
+	} else {
+		return &res, nil
+	}
+}
+
+// swapAlias completes a full refresh of the resource's collection by pointing its alias at the
+// collection, and then deleting the collections which the alias previously pointed at. It must only
+// be called once the collection has finished bulk loading its backfill, so that queries of the
+// alias never see a partially loaded collection. The alias is created if it doesn't exist yet, and
+// nothing is done if it already points at the collection, so it's safe to call repeatedly.
+//
+// The previous collections are only deleted once the alias is confirmed to point at the new one.
+// If the alias can't be updated or confirmed, it's restored to the previous collections and an
+// error is returned, so that queries continue to see the previous collections and the swap is
+// attempted again when the connector restarts. The new collection is kept, since the connector
+// continues to write to it.
+func swapAlias(ctx context.Context, client *rockset.RockClient, res *resource) error {
+	var target = aliasTarget(res.Workspace, res.Collection)
+	var logEntry = log.WithFields(log.Fields{
+		"rocksetWorkspace":  res.Workspace,
+		"rocksetAlias":      res.Alias,
+		"rocksetCollection": res.Collection,
+	})
+
+	existing, err := getAlias(ctx, client, res.Workspace, res.Alias)
+	if err != nil {
+		return err
+	} else if existing == nil {
+		if _, err := client.CreateAlias(ctx, res.Workspace, res.Alias, []string{target}); err != nil {
+			return fmt.Errorf("failed to create alias `%s`: %w", res.Alias, err)
+		}
+		logEntry.Info("Created Rockset alias")
+		return nil
+	}
+	var previous = existing.Collections
+	if len(previous) == 1 && previous[0] == target {
+		return nil
+	}
+
+	logEntry = logEntry.WithField("previousCollections", previous)
+	logEntry.Info("Pointing Rockset alias at the refreshed collection")
+	if err := client.UpdateAlias(ctx, res.Workspace, res.Alias, []string{target}); err != nil {
+		return fmt.Errorf("failed to point alias `%s` at collection `%s`: %w", res.Alias, res.Collection, err)
+	}
+	if updated, err := getAlias(ctx, client, res.Workspace, res.Alias); err != nil || updated == nil ||
+		len(updated.Collections) != 1 || updated.Collections[0] != target {
+		if err == nil {
+			err = fmt.Errorf("alias `%s` does not point at collection `%s` after being updated", res.Alias, res.Collection)
+		}
+		if restoreErr := client.UpdateAlias(ctx, res.Workspace, res.Alias, previous); restoreErr != nil {
+			logEntry.WithField("error", restoreErr).Error("Failed to restore the previous collections of the Rockset alias")
+		}
+		return fmt.Errorf("confirming the update of alias `%s`: %w", res.Alias, err)
+	}
+
+	for _, name := range previous {
+		if name == target {
+			continue
+		}
+		var parts = strings.SplitN(name, ".", 2)
+		if len(parts) != 2 {
+			logEntry.WithField("previousCollection", name).Warn("Not deleting the previous collection of the Rockset alias because its name is not qualified by a workspace")
+			continue
+		}
+		var delErr = client.DeleteCollection(ctx, parts[0], parts[1])
+		if se, ok := delErr.(rockset.Error); ok && se.IsNotFoundError() {
+			continue
+		} else if delErr != nil {
+			// The alias has already been swapped, so the refresh has succeeded regardless. The
+			// previous collection is merely left behind for someone to delete.
+			logEntry.WithFields(log.Fields{
+				"previousCollection": name,
+				"error":              delErr,
+			}).Warn("Failed to delete the previous collection of the Rockset alias, which must be deleted manually")
+			continue
+		}
+		logEntry.WithField("previousCollection", name).Info("Deleted the previous collection of the Rockset alias")
+	}
+	return nil
+}
+
+// deleteAlias deletes the resource's alias if it points at the resource's collection. Rockset
+// doesn't allow collections to be deleted while an alias refers to them.
+func deleteAlias(ctx context.Context, client *rockset.RockClient, res *resource) error {
+	existing, err := getAlias(ctx, client, res.Workspace, res.Alias)
+	if err != nil || existing == nil {
+		return err
+	}
+	var target = aliasTarget(res.Workspace, res.Collection)
+	for _, name := range existing.Collections {
+		if name == target {
+			if err := client.DeleteAlias(ctx, res.Workspace, res.Alias); err != nil {
+				return fmt.Errorf("failed to delete alias `%s`: %w", res.Alias, err)
+			}
+			return nil
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package materialize_rockset
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	rockset "github.com/rockset/rockset-go-client"
+	rtypes "github.com/rockset/rockset-go-client/openapi"
+	log "github.com/sirupsen/logrus"
+)
+
+// The types of change which a document may represent, according to the change indicator field of
+// its binding.
+const (
+	changeInsert = "Insert"
+	changeUpdate = "Update"
+	changeDelete = "Delete"
+)
+
+// changeTypes maps the recognized values of change indicator fields to their change type. Values
+// are matched case-insensitively, and include the operations of captured change events.
+var changeTypes = map[string]string{
+	"insert": changeInsert,
+	"update": changeUpdate,
+	"delete": changeDelete,
+	"c":      changeInsert,
+	"u":      changeUpdate,
+	"d":      changeDelete,
+}
+
+const (
+	// missingChangeInsert writes documents without a recognized change indicator as though they
+	// were inserts. This is the default.
+	missingChangeInsert = "insert"
+	// missingChangeSkip leaves documents without a recognized change indicator out of the collection.
+	missingChangeSkip = "skip"
+	// missingChangeError fails the materialization when a document doesn't have a recognized
+	// change indicator.
+	missingChangeError = "error"
+)
+
+// changeType returns the type of change which the document represents according to the binding's
+// change indicator field, which is always an insert for bindings without one. The `missingChangeIndicator`
+// policy is applied to documents whose field is absent or holds an unrecognized value, and an empty
+// change type is returned for those which should be skipped.
+func (b *binding) changeType(doc map[string]interface{}) (string, error) {
+	if b.res.ChangeIndicator == "" {
+		return changeInsert, nil
+	}
+	var value, ok = doc[b.res.ChangeIndicator].(string)
+	if change, recognized := changeTypes[strings.ToLower(value)]; ok && recognized {
+		return change, nil
+	}
+
+	b.missingChanges++
+	switch b.res.MissingChangeIndicator {
+	case missingChangeError:
+		return "", fmt.Errorf("document of Rockset collection '%s' has the change indicator `%s` value %v, which isn't a recognized change type",
+			b.rocksetCollection(), b.res.ChangeIndicator, doc[b.res.ChangeIndicator])
+	case missingChangeSkip:
+		return "", nil
+	default:
+		return changeInsert, nil
+	}
+}
+
+// logMissingChanges logs the number of documents which didn't have a recognized change indicator
+// during the transaction, if there were any.
+func (b *binding) logMissingChanges() {
+	if b.missingChanges == 0 {
+		return
+	}
+	var policy = b.res.MissingChangeIndicator
+	if policy == "" {
+		policy = missingChangeInsert
+	}
+	log.WithFields(log.Fields{
+		"rocksetCollection": b.rocksetCollection(),
+		"rocksetWorkspace":  b.rocksetWorkspace(),
+		"changeIndicator":   b.res.ChangeIndicator,
+		"policy":            policy,
+		"nDocuments":        b.missingChanges,
+	}).Warn("documents did not have a recognized change indicator")
+	b.missingChanges = 0
+}
+
+// sendDeletes deletes the documents of the binding which were stored as deletes during the
+// transaction from its Rockset collection. Flow reduces the documents of a transaction by key, so
+// a deleted document can't also be written by the same transaction.
+func (t *transactor) sendDeletes(ctx context.Context, b *binding) error {
+	if err := t.deleteDocuments(ctx, b, b.deletes); err != nil {
+		return err
+	}
+	b.deletes = nil
+	return nil
+}
+
+// deleteDocuments deletes the documents with the given `_id`s from the binding's Rockset
+// collection, in batches of up to storeBatchSize documents.
+func (t *transactor) deleteDocuments(ctx context.Context, b *binding, ids []string) error {
+	for len(ids) > 0 {
+		var n = len(ids)
+		if n > storeBatchSize {
+			n = storeBatchSize
+		}
+		docStatuses, err := t.client.DeleteDocuments(ctx, b.rocksetWorkspace(), b.rocksetCollection(), ids[:n])
+		if err != nil {
+			return err
+		}
+		// A document which doesn't exist is already deleted.
+		var rejected []rtypes.DocumentStatus
+		for _, docStatus := range docStatuses {
+			if docStatus.Error == nil || !(rockset.Error{ErrorModel: docStatus.Error}).IsNotFoundError() {
+				rejected = append(rejected, docStatus)
+			}
+		}
+		if err := checkDocumentStatuses(b, rejected); err != nil {
+			return err
+		}
+		ids = ids[n:]
+	}
+	return nil
+}
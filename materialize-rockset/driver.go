@@ -7,6 +7,7 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"fmt"
+	"net/http"
 	"regexp"
 	"strings"
 	"time"
@@ -26,6 +27,53 @@ import (
 type config struct {
 	// Credentials used to authenticate with the Rockset API.
 	ApiKey string `json:"api_key" jsonschema:"title=Rockset API Key,description=The key used to authenticate to the Rockset API" jsonschema_extras:"secret=true"`
+	// HttpLogging enables logging of each request made to the Rockset API, for troubleshooting.
+	HttpLogging         bool `json:"http_logging,omitempty" jsonschema:"title=HTTP Logging,description=Log each request made to the Rockset API. The API key is always redacted from the logs." jsonschema_extras:"advanced=true"`
+	HttpLogMaxBodyBytes int  `json:"http_log_max_body_bytes,omitempty" jsonschema:"title=HTTP Log Body Limit,description=Request and response bodies are truncated to this many bytes when HTTP logging is enabled.,default=1024" jsonschema_extras:"advanced=true"`
+	// BackfillStaging configures the cloud storage to which the backfills of bindings with a
+	// `stageBackfill` are staged.
+	BackfillStaging *stagingConfig `json:"backfill_staging,omitempty" jsonschema:"title=Backfill Staging,description=Cloud storage to which the backfills of bindings are staged for bulk ingestion by Rockset." jsonschema_extras:"advanced=true"`
+	// AckMode determines whether transactions are acknowledged as soon as Rockset accepts their
+	// documents, or only once the documents can be queried.
+	AckMode           string `json:"ack_mode,omitempty" jsonschema:"title=Acknowledgment Mode,description=Whether transactions are acknowledged as soon as Rockset accepts their documents ('immediate') or only once the documents can be queried ('verified'). Verification adds the ingestion latency of Rockset to every transaction.,enum=immediate,enum=verified,default=immediate" jsonschema_extras:"advanced=true"`
+	VerifyTimeoutSecs int    `json:"verify_timeout_secs,omitempty" jsonschema:"title=Verification Timeout,description=How long in seconds to wait for the documents of a transaction to be queryable when the acknowledgment mode is 'verified'. The materialization fails if they aren't.,default=300" jsonschema_extras:"advanced=true"`
+	// PartialCommitRetries is how many times the documents which Rockset rejected from a request
+	// are resent, without resending the documents that it accepted.
+	PartialCommitRetries int `json:"partial_commit_retries,omitempty" jsonschema:"title=Partial Commit Retries,description=How many times to resend the documents of a request which were rejected by Rockset. The documents which Rockset accepted aren't resent. The materialization fails if documents are still rejected once the retries are exhausted." jsonschema_extras:"advanced=true"`
+	// AutoCreateWorkspace and AutoCreateCollection determine whether the workspaces and
+	// collections of bindings which don't exist yet are created when the materialization is
+	// applied, or fail it instead. Both default to true. See autoCreateWorkspace.
+	AutoCreateWorkspace  *bool `json:"auto_create_workspace,omitempty" jsonschema:"title=Create Workspaces,description=Create the workspaces of bindings which don't exist yet. When disabled the materialization fails to apply if a workspace doesn't exist.,default=true" jsonschema_extras:"advanced=true"`
+	AutoCreateCollection *bool `json:"auto_create_collection,omitempty" jsonschema:"title=Create Collections,description=Create the collections of bindings which don't exist yet. When disabled the materialization fails to apply if a collection doesn't exist.,default=true" jsonschema_extras:"advanced=true"`
+}
+
+// verifyTimeout returns how long to wait for documents to be queryable.
+func (c *config) verifyTimeout() time.Duration {
+	if c.VerifyTimeoutSecs == 0 {
+		return 5 * time.Minute
+	}
+	return time.Duration(c.VerifyTimeoutSecs) * time.Second
+}
+
+// partialCommitRetries returns how many times rejected documents are resent. It's zero for a nil
+// config.
+func (c *config) partialCommitRetries() int {
+	if c == nil {
+		return 0
+	}
+	return c.PartialCommitRetries
+}
+
+// autoCreateWorkspace returns whether workspaces which don't exist are created, which they are
+// unless it's disabled.
+func (c *config) autoCreateWorkspace() bool {
+	return c.AutoCreateWorkspace == nil || *c.AutoCreateWorkspace
+}
+
+// autoCreateCollection returns whether collections which don't exist are created, which they are
+// unless it's disabled.
+func (c *config) autoCreateCollection() bool {
+	return c.AutoCreateCollection == nil || *c.AutoCreateCollection
 }
 
 func (c *config) Validate() error {
@@ -37,6 +85,25 @@ func (c *config) Validate() error {
 			return fmt.Errorf("missing '%s'", req[0])
 		}
 	}
+	if c.HttpLogMaxBodyBytes < 0 {
+		return fmt.Errorf("http_log_max_body_bytes must not be negative")
+	}
+	switch c.AckMode {
+	case "", ackModeImmediate, ackModeVerified:
+	default:
+		return fmt.Errorf("invalid ack_mode %q: must be %q or %q", c.AckMode, ackModeImmediate, ackModeVerified)
+	}
+	if c.VerifyTimeoutSecs < 0 {
+		return fmt.Errorf("verify_timeout_secs must not be negative")
+	}
+	if c.PartialCommitRetries < 0 {
+		return fmt.Errorf("partial_commit_retries must not be negative")
+	}
+	if c.BackfillStaging != nil {
+		if err := c.BackfillStaging.Validate(); err != nil {
+			return fmt.Errorf("invalid 'backfill_staging' value: %w", err)
+		}
+	}
 	return nil
 }
 
@@ -89,12 +156,61 @@ func (fp *fieldPartition) ToRocksetFieldPartition() rtypes.FieldPartition {
 	}
 }
 
-// collectionSettings exposes a subset of the "advanced" options on rtypes.CreateCollectionRequest
+// fieldSchema configures how Rockset indexes and stores a field, and is converted to an
+// rtypes.FieldSchema. Each mode is left to Rockset's default if it's unset.
+type fieldSchema struct {
+	FieldName       string `json:"field_name" jsonschema:"title=Field Name,description=The name of a field\u002C parsed as a SQL qualified name"`
+	IndexMode       string `json:"index_mode,omitempty" jsonschema:"title=Index Mode,description=Whether the field is indexed for search queries,enum=index,enum=no_index"`
+	RangeIndexMode  string `json:"range_index_mode,omitempty" jsonschema:"title=Range Index Mode,description=Whether the field is indexed for range queries,enum=v1_index,enum=no_index"`
+	TypeIndexMode   string `json:"type_index_mode,omitempty" jsonschema:"title=Type Index Mode,description=Whether the type of the field is indexed,enum=index,enum=no_index"`
+	ColumnIndexMode string `json:"column_index_mode,omitempty" jsonschema:"title=Column Index Mode,description=Whether the field is stored in the column store for analytical queries,enum=store,enum=no_store"`
+}
+
+func (f *fieldSchema) Validate() error {
+	if f.FieldName == "" {
+		return fmt.Errorf("Field Schemas: Field Name is empty")
+	}
+	var modes = []struct {
+		name, value string
+		valid       []string
+	}{
+		{"Index Mode", f.IndexMode, []string{"index", "no_index"}},
+		{"Range Index Mode", f.RangeIndexMode, []string{"v1_index", "no_index"}},
+		{"Type Index Mode", f.TypeIndexMode, []string{"index", "no_index"}},
+		{"Column Index Mode", f.ColumnIndexMode, []string{"store", "no_store"}},
+	}
+	for _, mode := range modes {
+		if mode.value != "" && mode.value != mode.valid[0] && mode.value != mode.valid[1] {
+			return fmt.Errorf("Field Schemas: invalid %s %q for field `%s`: must be either %q or %q", mode.name, mode.value, f.FieldName, mode.valid[0], mode.valid[1])
+		}
+	}
+	return nil
+}
+
+func (f *fieldSchema) ToRocksetFieldSchema() rtypes.FieldSchema {
+	var name = f.FieldName
+	return rtypes.FieldSchema{
+		FieldName: &name,
+		FieldOptions: &rtypes.FieldOptions{
+			IndexMode:       trimToNill(f.IndexMode),
+			RangeIndexMode:  trimToNill(f.RangeIndexMode),
+			TypeIndexMode:   trimToNill(f.TypeIndexMode),
+			ColumnIndexMode: trimToNill(f.ColumnIndexMode),
+		},
+	}
+}
+
+// collectionSettings exposes a subset of the "advanced" options on rtypes.CreateCollectionRequest.
+// Rockset collections are immutable, so these are only applied when a collection is created.
 type collectionSettings struct {
 	RetentionSecs *int64           `json:"retention_secs,omitempty" jsonschema:"title=Retention Period,description=Number of seconds after which data is purged based on event time"`
 	EventTimeInfo *eventTimeInfo   `json:"event_time_info,omitempty" jsonschema:"title=Event Time Info"`
 	ClusteringKey []fieldPartition `json:"clustering_key,omitempty" jsonschema:"title=Clustering Key,description=List of clustering fields"`
 	InsertOnly    *bool            `json:"insert_only,omitempty" jsonschema:"title=Insert Only,description=If true disallows updates and deletes. The materialization will fail if there are documents with duplicate keys.,default=false"`
+	// DropFields are removed from documents by field mappings as they're ingested, so that large
+	// fields which are never queried don't take up space in Rockset's indexes.
+	DropFields   []string      `json:"drop_fields,omitempty" jsonschema:"title=Drop Fields,description=Fields which are dropped from documents as they are ingested so that they are neither stored nor indexed by Rockset."`
+	FieldSchemas []fieldSchema `json:"field_schemas,omitempty" jsonschema:"title=Field Schemas,description=How individual fields are indexed and stored by Rockset. Fields which are rarely filtered on may skip the search and range indexes while fields used by analytical queries may be kept in the column store."`
 }
 
 func (s *collectionSettings) Validate() error {
@@ -102,16 +218,73 @@ func (s *collectionSettings) Validate() error {
 		return fmt.Errorf("Retention Period cannot be negative")
 	}
 	if s.EventTimeInfo != nil {
-		return s.EventTimeInfo.Validate()
+		if err := s.EventTimeInfo.Validate(); err != nil {
+			return err
+		}
 	}
 	// nothing to validate on ClusteringKey
+
+	var dropped = make(map[string]bool)
+	for _, field := range s.DropFields {
+		switch {
+		case field == "":
+			return fmt.Errorf("Drop Fields: field name is empty")
+		case field == "_id":
+			return fmt.Errorf("Drop Fields: `_id` is required by Rockset and can't be dropped")
+		case dropped[field]:
+			return fmt.Errorf("Drop Fields: field `%s` is listed more than once", field)
+		case s.EventTimeInfo != nil && s.EventTimeInfo.Field == field:
+			return fmt.Errorf("Drop Fields: field `%s` is the event time field and can't be dropped", field)
+		}
+		dropped[field] = true
+	}
+	for _, key := range s.ClusteringKey {
+		if key.FieldName != nil && dropped[*key.FieldName] {
+			return fmt.Errorf("Drop Fields: field `%s` is part of the clustering key and can't be dropped", *key.FieldName)
+		}
+	}
+
+	var configured = make(map[string]bool)
+	for i := range s.FieldSchemas {
+		var schema = &s.FieldSchemas[i]
+		if err := schema.Validate(); err != nil {
+			return err
+		} else if configured[schema.FieldName] {
+			return fmt.Errorf("Field Schemas: field `%s` is listed more than once", schema.FieldName)
+		} else if dropped[schema.FieldName] {
+			return fmt.Errorf("Field Schemas: field `%s` is also listed in Drop Fields", schema.FieldName)
+		}
+		configured[schema.FieldName] = true
+	}
 	return nil
 }
 
+// fields returns the names of the fields which are referenced by the settings.
+func (s *collectionSettings) fields() []string {
+	var fields []string
+	if s.EventTimeInfo != nil {
+		fields = append(fields, s.EventTimeInfo.Field)
+	}
+	for _, key := range s.ClusteringKey {
+		if key.FieldName != nil {
+			fields = append(fields, *key.FieldName)
+		}
+	}
+	fields = append(fields, s.DropFields...)
+	for _, schema := range s.FieldSchemas {
+		fields = append(fields, schema.FieldName)
+	}
+	return fields
+}
+
 type resource struct {
 	Workspace string `json:"workspace,omitempty" jsonschema:"title=Workspace,description=The name of the Rockset workspace (will be created if it does not exist)"`
 	// The name of the Rockset collection (will be created if it does not exist)
 	Collection string `json:"collection,omitempty" jsonschema:"title=Rockset Collection,description=The name of the Rockset collection (will be created if it does not exist)"`
+	// Names a Rockset alias which is pointed at the collection once it has finished bulk loading its
+	// backfill. Changing the collection of a binding with an alias performs a full refresh. See
+	// swapAlias.
+	Alias string `json:"alias,omitempty" jsonschema:"title=Alias,description=The name of a Rockset alias which is pointed at the collection once its backfill has been bulk loaded. Changing the collection while keeping the alias fully refreshes it: the new collection is loaded and then the alias is swapped to it and the previous collection is deleted. Requires either 'stageBackfill' or 'initializeFromS3'." jsonschema_extras:"advanced=true"`
 	// Configures the rockset collection to bulk load an initial data set from an S3 bucket, before
 	// transitioning to using the write API for ongoing data. If a previous version of this
 	// materialization wrote files into S3 in order to more quickly backfill historical data, then
@@ -119,10 +292,43 @@ type resource struct {
 	// https://go.estuary.dev/rock-bulk If a bulk loading integration is not being used, then this
 	// should be undefined.
 	InitializeFromS3 *cloudStorageIntegration `json:"initializeFromS3,omitempty" jsonschema:"title=Backfill from S3" jsonschema_extras:"advanced=true"`
+	// Configures the connector to stage the documents of the initial backfill to a cloud storage
+	// bucket, from which the rockset collection bulk loads them through an integration, before
+	// transitioning to using the write API. Requires the endpoint's `backfill_staging` config.
+	StageBackfill *stagingTarget `json:"stageBackfill,omitempty" jsonschema:"title=Stage Backfill" jsonschema_extras:"advanced=true"`
 	// Additional settings for creating the Rockset collection, which are likely to be rarely used.
 	AdvancedCollectionSettings *collectionSettings `json:"advancedCollectionSettings,omitempty" jsonschema:"title=Advanced Collection Settings" jsonschema_extras:"advanced=true"`
+	// Controls whether each document is written to Rockset in full, or as a patch of just the
+	// materialized fields, or in full after deleting the existing document. See updateModeUpsert,
+	// updateModePatch, and updateModeReplaceOnKey.
+	UpdateMode string `json:"updateMode,omitempty" jsonschema:"title=Update Mode,description=Whether to replace entire documents ('upsert') or to patch only the materialized fields of existing documents ('patch') or to delete each existing document before adding its replacement ('replaceOnKey'). Patching preserves any other fields of the Rockset documents.,enum=upsert,enum=patch,enum=replaceOnKey,default=upsert" jsonschema_extras:"advanced=true"`
+	// Names a field whose value orders the versions of each document. See latestByID.
+	SequenceField string `json:"sequenceField,omitempty" jsonschema:"title=Sequence Field,description=Name of a field which orders the versions of each document. If it's a materialized field then its value is used. Otherwise it's added to each document with a value that increases with each write. Queries may use it to resolve the latest version of each key." jsonschema_extras:"advanced=true"`
+	// Bounds the approximate size of the documents buffered for each write request of the binding,
+	// in addition to the storeBatchSize bound on their number. See sendAllDocuments.
+	MaxBufferedBytes int `json:"maxBufferedBytes,omitempty" jsonschema:"title=Max Buffered Bytes,description=The approximate maximum size in bytes of the documents which are buffered for each write request to the collection. Bindings with large documents are written in smaller requests so that they use less memory. Zero means that only the number of documents in each request is limited." jsonschema_extras:"advanced=true"`
+	// Names a materialized field holding the type of change that each document represents, and
+	// how documents are handled when it doesn't hold one. See binding.changeType.
+	ChangeIndicator        string `json:"changeIndicator,omitempty" jsonschema:"title=Change Indicator,description=Name of a materialized field holding the type of change which each document represents: 'Insert' or 'Update' documents are written and 'Delete' documents are deleted from the Rockset collection. The single-letter operations 'c' and 'u' and 'd' of captured change events are also recognized." jsonschema_extras:"advanced=true"`
+	MissingChangeIndicator string `json:"missingChangeIndicator,omitempty" jsonschema:"title=Missing Change Indicator,description=How documents whose change indicator field is absent or holds an unrecognized value are handled. They're either written as inserts or skipped or fail the materialization.,enum=insert,enum=skip,enum=error,default=insert" jsonschema_extras:"advanced=true"`
+	// Flattens nested objects into fields with joined names before documents are written, or the
+	// reverse. See binding.reshapeDocument.
+	Flattening *flattenSettings `json:"flattening,omitempty" jsonschema:"title=Flattening,description=Reshapes documents before they're written to Rockset by flattening nested objects into fields with joined names or by nesting fields with joined names into objects. Arrays are left as they are." jsonschema_extras:"advanced=true"`
 }
 
+const (
+	// updateModeUpsert writes each document in full, replacing any existing document with the same
+	// `_id`. This is the default.
+	updateModeUpsert = "upsert"
+	// updateModePatch writes each document as a set of patch operations which only replace the
+	// fields that are materialized, so that fields added to the Rockset document by other means
+	// are left alone.
+	updateModePatch = "patch"
+	// updateModeReplaceOnKey deletes the existing document with the same `_id` before adding each
+	// document, so that fields which were removed from a document don't remain in Rockset.
+	updateModeReplaceOnKey = "replaceOnKey"
+)
+
 // Configuration for bulk loading data into the new Rockset collection from a cloud storage bucket.
 type cloudStorageIntegration struct {
 	Integration string `json:"integration" jsonschema:"title=Integration Name,description=The name of the integration that was previously created in the Rockset UI"`
@@ -166,12 +372,99 @@ func (r *resource) Validate() error {
 		return err
 	}
 
+	if r.Alias != "" {
+		if err := validateRocksetName("alias", r.Alias); err != nil {
+			return err
+		}
+		if r.Alias == r.Collection {
+			return fmt.Errorf("'alias' and 'collection' must have different names")
+		}
+		if r.StageBackfill == nil && r.InitializeFromS3 == nil {
+			return fmt.Errorf("'alias' requires either 'stageBackfill' or 'initializeFromS3', so that the collection is bulk loaded before the alias is pointed at it")
+		}
+	}
+
 	if r.InitializeFromS3 != nil {
 		if err := r.InitializeFromS3.Validate(); err != nil {
 			return fmt.Errorf("invalid 'initializeFromS3' value: %w", err)
 		}
 	}
+	if r.StageBackfill != nil {
+		if r.InitializeFromS3 != nil {
+			return fmt.Errorf("'stageBackfill' and 'initializeFromS3' can't both be used together")
+		}
+		if err := r.StageBackfill.Validate(); err != nil {
+			return fmt.Errorf("invalid 'stageBackfill' value: %w", err)
+		}
+	}
 
+	switch r.UpdateMode {
+	case "", updateModeUpsert, updateModePatch, updateModeReplaceOnKey:
+	default:
+		return fmt.Errorf("invalid 'updateMode' value %q: must be %q, %q, or %q", r.UpdateMode, updateModeUpsert, updateModePatch, updateModeReplaceOnKey)
+	}
+	if r.SequenceField == "_id" {
+		return fmt.Errorf("invalid 'sequenceField' value: `_id` is reserved by Rockset")
+	}
+	if r.MaxBufferedBytes < 0 {
+		return fmt.Errorf("invalid 'maxBufferedBytes' value: must not be negative")
+	}
+	if r.ChangeIndicator == "_id" {
+		return fmt.Errorf("invalid 'changeIndicator' value: `_id` is reserved by Rockset")
+	}
+	switch r.MissingChangeIndicator {
+	case "", missingChangeInsert, missingChangeSkip, missingChangeError:
+		if r.MissingChangeIndicator != "" && r.ChangeIndicator == "" {
+			return fmt.Errorf("'missingChangeIndicator' requires a 'changeIndicator'")
+		}
+	default:
+		return fmt.Errorf("invalid 'missingChangeIndicator' value %q: must be %q, %q, or %q", r.MissingChangeIndicator, missingChangeInsert, missingChangeSkip, missingChangeError)
+	}
+	if r.Flattening != nil {
+		if err := r.Flattening.Validate(); err != nil {
+			return fmt.Errorf("invalid 'flattening' value: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// validatePatchable checks that documents of the collection can be patched. Patches are addressed
+// by the `_id` that's derived from the collection key, so there must be a key to derive it from,
+// and no projection may be named `_id`, since that would conflict with the derived value.
+func validatePatchable(collection *pf.CollectionSpec) error {
+	if len(collection.KeyPtrs) == 0 {
+		return fmt.Errorf("collection '%s' has no key from which to derive the Rockset `_id`, which is required by 'updateMode: patch'", collection.Collection)
+	}
+	for _, projection := range collection.Projections {
+		if projection.Field == "_id" {
+			return fmt.Errorf("collection '%s' has a projection named `_id`, which conflicts with the `_id` derived from the collection key that is required by 'updateMode: patch'", collection.Collection)
+		}
+	}
+	return nil
+}
+
+// validateSettingsFields checks that the fields referenced by the advanced collection settings of
+// a resource will exist in the documents that are materialized. A field may be a projection of the
+// collection, a nested field within one, or the sequence field.
+func validateSettingsFields(collection *pf.CollectionSpec, res *resource) error {
+	if res.AdvancedCollectionSettings == nil {
+		return nil
+	}
+	var known = map[string]bool{"_id": true}
+	if res.SequenceField != "" {
+		known[res.SequenceField] = true
+	}
+	for _, projection := range collection.Projections {
+		known[projection.Field] = true
+	}
+
+	for _, field := range res.AdvancedCollectionSettings.fields() {
+		if known[field] || known[strings.SplitN(field, ".", 2)[0]] {
+			continue
+		}
+		return fmt.Errorf("the advanced collection settings of Rockset collection '%s' reference the field `%s`, which is not a projection of the collection '%s'", res.Collection, field, collection.Collection)
+	}
 	return nil
 }
 
@@ -186,12 +479,32 @@ func validateRocksetName(field string, value string) error {
 
 }
 
-type rocksetDriver struct{}
+type rocksetDriver struct {
+	// httpClient is used for all requests to the Rockset API. If nil, the Rockset client's
+	// default is used. This exists so that tests can substitute a mock transport.
+	httpClient *http.Client
+	// objectStore is used for staging backfills. If nil, one is created from the endpoint's
+	// `backfill_staging` config. This exists so that tests can substitute a mock.
+	objectStore objectStore
+}
 
 func NewRocksetDriver() pm.DriverServer {
 	return new(rocksetDriver)
 }
 
+// newClient returns a Rockset API client authenticated with the configured API key.
+func (d *rocksetDriver) newClient(cfg *config) (*rockset.RockClient, error) {
+	var opts = []rockset.RockOption{rockset.WithAPIKey(cfg.ApiKey)}
+	var httpClient = d.httpClient
+	if cfg.HttpLogging {
+		httpClient = newLoggingHTTPClient(httpClient, cfg.ApiKey, cfg.HttpLogMaxBodyBytes)
+	}
+	if httpClient != nil {
+		opts = append(opts, rockset.WithHTTPClient(httpClient))
+	}
+	return rockset.NewClient(opts...)
+}
+
 // pm.DriverServer interface.
 func (d *rocksetDriver) Spec(ctx context.Context, req *pm.SpecRequest) (*pm.SpecResponse, error) {
 	if err := req.Validate(); err != nil {
@@ -223,17 +536,40 @@ func (d *rocksetDriver) Validate(ctx context.Context, req *pm.ValidateRequest) (
 	if err != nil {
 		return nil, err
 	}
-	client, err := rockset.NewClient(rockset.WithAPIKey(cfg.ApiKey))
+	client, err := d.newClient(&cfg)
 	if err != nil {
 		return nil, fmt.Errorf("creating Rockset client: %w", err)
 	}
 
-	var bindings = []*pm.ValidateResponse_Binding{}
+	var resources = make([]resource, 0, len(req.Bindings))
+	var workspaces []string
 	for i, binding := range req.Bindings {
 		var res resource
 		if res, err = ResolveResourceConfig(binding.ResourceSpecJson); err != nil {
 			return nil, fmt.Errorf("building resource for binding %v: %w", i, err)
 		}
+		resources = append(resources, res)
+		workspaces = append(workspaces, res.Workspace)
+	}
+
+	// Check that the API key works and can access each workspace before looking at any
+	// collections, so that credential problems are reported clearly rather than showing
+	// up as some other failure later on.
+	if err := checkAccess(ctx, client, workspaces); err != nil {
+		return nil, err
+	}
+
+	var bindings = []*pm.ValidateResponse_Binding{}
+	var aliases = make(map[string]bool)
+	for i, binding := range req.Bindings {
+		var res = resources[i]
+		if res.Alias != "" {
+			var key = res.Workspace + "/" + res.Alias
+			if aliases[key] {
+				return nil, fmt.Errorf("the alias '%s' of workspace '%s' is used by more than one binding", res.Alias, res.Workspace)
+			}
+			aliases[key] = true
+		}
 		rocksetCollection, err := getCollection(ctx, client, res.Workspace, res.Collection)
 		if err != nil {
 			return nil, fmt.Errorf("requesting rockset collection: %w", err)
@@ -246,6 +582,29 @@ func (d *rocksetDriver) Validate(ctx context.Context, req *pm.ValidateRequest) (
 			return nil, fmt.Errorf("Rockset collection '%s' does not have an integration named '%s', which is required by the binding '%s'",
 				res.Collection, res.InitializeFromS3.Integration, binding.Collection.Collection.String())
 		}
+		if res.StageBackfill != nil {
+			if cfg.BackfillStaging == nil {
+				return nil, fmt.Errorf("the binding '%s' has a 'stageBackfill', which requires the endpoint's 'backfill_staging' config", binding.Collection.Collection.String())
+			}
+			if rocksetCollection != nil && !hasIntegrationSource(rocksetCollection, res.StageBackfill.Integration) {
+				return nil, fmt.Errorf("Rockset collection '%s' does not have an integration named '%s', which is required by the binding '%s'",
+					res.Collection, res.StageBackfill.Integration, binding.Collection.Collection.String())
+			}
+		}
+		if res.UpdateMode == updateModePatch {
+			if err := validatePatchable(&binding.Collection); err != nil {
+				return nil, err
+			}
+		}
+		if err := validateSettingsFields(&binding.Collection, &res); err != nil {
+			return nil, err
+		}
+		if err := validateFlattenFields(&binding.Collection, &res); err != nil {
+			return nil, err
+		}
+		if res.ChangeIndicator != "" && binding.Collection.GetProjection(res.ChangeIndicator) == nil {
+			return nil, fmt.Errorf("the 'changeIndicator' of Rockset collection '%s' is the field `%s`, which is not a projection of the collection '%s'", res.Collection, res.ChangeIndicator, binding.Collection.Collection)
+		}
 
 		var constraints = make(map[string]*pm.Constraint)
 		for _, projection := range binding.Collection.Projections {
@@ -257,6 +616,10 @@ func (d *rocksetDriver) Validate(ctx context.Context, req *pm.ValidateRequest) (
 				constraint.Type = pm.Constraint_FIELD_OPTIONAL
 				constraint.Reason = "The projection may materialize this field."
 			}
+			if projection.Field == res.ChangeIndicator {
+				constraint.Type = pm.Constraint_FIELD_REQUIRED
+				constraint.Reason = "The field is the change indicator of the binding."
+			}
 			constraints[projection.Field] = constraint
 		}
 
@@ -278,7 +641,7 @@ func (d *rocksetDriver) ApplyUpsert(ctx context.Context, req *pm.ApplyRequest) (
 		return nil, err
 	}
 
-	client, err := rockset.NewClient(rockset.WithAPIKey(cfg.ApiKey))
+	client, err := d.newClient(&cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -290,13 +653,13 @@ func (d *rocksetDriver) ApplyUpsert(ctx context.Context, req *pm.ApplyRequest) (
 			return nil, fmt.Errorf("building resource for binding %v: %w", i, err)
 		}
 
-		if createdWorkspace, err := ensureWorkspaceExists(ctx, client, res.Workspace); err != nil {
+		if createdWorkspace, err := ensureWorkspaceExists(ctx, client, res.Workspace, cfg.autoCreateWorkspace()); err != nil {
 			return nil, err
 		} else if createdWorkspace != nil {
 			actionLog = append(actionLog, fmt.Sprintf("created %s workspace", *createdWorkspace.Name))
 		}
 
-		if createdCollection, err := ensureCollectionExists(ctx, client, &res); err != nil {
+		if createdCollection, err := ensureCollectionExists(ctx, client, &res, cfg.BackfillStaging, cfg.autoCreateCollection()); err != nil {
 			return nil, err
 		} else if createdCollection {
 			actionLog = append(actionLog, fmt.Sprintf("created %s collection", res.Collection))
@@ -319,7 +682,7 @@ func (d *rocksetDriver) ApplyDelete(ctx context.Context, req *pm.ApplyRequest) (
 	if err != nil {
 		return nil, err
 	}
-	client, err := rockset.NewClient(rockset.WithAPIKey(cfg.ApiKey))
+	client, err := d.newClient(&cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -352,7 +715,13 @@ func (d *rocksetDriver) ApplyDelete(ctx context.Context, req *pm.ApplyRequest) (
 			"rocksetCollection": res.Collection,
 			"rocksetWorkspace":  res.Workspace,
 		})
-		var delErr = client.DeleteCollection(ctx, res.Workspace, res.Collection)
+		var delErr error
+		if res.Alias != "" {
+			delErr = deleteAlias(ctx, client, &res)
+		}
+		if delErr == nil {
+			delErr = client.DeleteCollection(ctx, res.Workspace, res.Collection)
+		}
 		if delErr != nil {
 			if typedErr, ok := delErr.(rockset.Error); ok && typedErr.IsNotFoundError() {
 				logEntry.Info("Did not delete the collection because it does not exist")
@@ -390,7 +759,7 @@ func (d *rocksetDriver) Transactions(stream pm.Driver_TransactionsServer) error
 		return err
 	}
 
-	client, err := rockset.NewClient(rockset.WithAPIKey(cfg.ApiKey))
+	client, err := d.newClient(&cfg)
 	if err != nil {
 		return err
 	}
@@ -414,19 +783,45 @@ func (d *rocksetDriver) Transactions(stream pm.Driver_TransactionsServer) error
 		}
 	}
 
+	// Bindings which have finished staging their backfills are recorded in our own driver checkpoint.
+	var driverCP driverCheckpoint
+	if len(open.Open.DriverCheckpointJson) > 0 {
+		if err = json.Unmarshal(open.Open.DriverCheckpointJson, &driverCP); err != nil {
+			return fmt.Errorf("unmarshaling driver checkpoint: %w", err)
+		}
+	}
+	var stagedBackfills = make(map[string]bool)
+	for _, key := range driverCP.StagedBackfills {
+		stagedBackfills[key] = true
+	}
+
 	var bindings = make([]*binding, 0, len(open.Open.Materialization.Bindings))
+	var store = d.objectStore
 	for i, spec := range open.Open.Materialization.Bindings {
-		if res, err := ResolveResourceConfig(spec.ResourceSpecJson); err != nil {
+		var res, err = ResolveResourceConfig(spec.ResourceSpecJson)
+		if err != nil {
 			return fmt.Errorf("building resource for binding %v: %w", i, err)
-		} else {
-			bindings = append(bindings, NewBinding(spec, &res))
 		}
+		var b = NewBinding(spec, &res)
+		if res.StageBackfill != nil && !stagedBackfills[stagingKey(&res)] {
+			if cfg.BackfillStaging == nil {
+				return fmt.Errorf("binding %v has a 'stageBackfill', which requires the endpoint's 'backfill_staging' config", i)
+			}
+			if store == nil {
+				if store, err = newObjectStore(stream.Context(), cfg.BackfillStaging); err != nil {
+					return err
+				}
+			}
+			b.stager = newBackfillStager(store, res.StageBackfill)
+		}
+		bindings = append(bindings, b)
 	}
 
 	transactor := transactor{
-		config:   &cfg,
-		client:   client,
-		bindings: bindings,
+		config:    &cfg,
+		client:    client,
+		bindings:  bindings,
+		sequencer: newSequencer(),
 	}
 	// Ensure that all the collections are ready to accept writes before returning the opened
 	// response. It's important that we await _all_ bindings before continuing, since the flow
@@ -435,7 +830,9 @@ func (d *rocksetDriver) Transactions(stream pm.Driver_TransactionsServer) error
 	// still take a while to become ready after they've been created, so this also ensures that
 	// those are ready before we proceed (the error that's returned when attempting to write to a
 	// non-ready collection is not considered retryable by the client library).
-	transactor.awaitAllRocksetCollectionsReady(stream.Context())
+	if err = transactor.awaitAllRocksetCollectionsReady(stream.Context()); err != nil {
+		return err
+	}
 
 	if err = stream.Send(&pm.TransactionResponse{
 		Opened: &pm.TransactionResponse_Opened{FlowCheckpoint: flowCheckpoint},
@@ -3,18 +3,27 @@ package materialize_rockset
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"log"
+	"net/http"
 	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/bradleyjkemp/cupaloy"
+	"github.com/estuary/flow/go/protocols/fdb/tuple"
 	pf "github.com/estuary/flow/go/protocols/flow"
 	pm "github.com/estuary/flow/go/protocols/materialize"
 	rockset "github.com/rockset/rockset-go-client"
+	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
 	pb "go.gazette.dev/core/broker/protocol"
+	"golang.org/x/sync/errgroup"
 )
 
 func TestRocksetConfig(t *testing.T) {
@@ -23,6 +32,13 @@ func TestRocksetConfig(t *testing.T) {
 
 	var valid = config{ApiKey: fetchApiKey()}
 	require.Nil(t, valid.Validate())
+
+	var staging = config{ApiKey: "key", BackfillStaging: &stagingConfig{Provider: stagingProviderGCS, GCPCredentials: "{}"}}
+	require.Nil(t, staging.Validate())
+	staging.BackfillStaging.Provider = stagingProviderS3
+	require.Error(t, staging.Validate())
+	staging.BackfillStaging.Provider = "azure"
+	require.Error(t, staging.Validate())
 }
 
 func TestRocksetResource(t *testing.T) {
@@ -34,6 +50,843 @@ func TestRocksetResource(t *testing.T) {
 
 	var valid = resource{Workspace: "testing-33", Collection: "widgets_1"}
 	require.Nil(t, valid.Validate())
+
+	var patch = resource{Workspace: "testing-33", Collection: "widgets_1", UpdateMode: updateModePatch}
+	require.Nil(t, patch.Validate())
+
+	var replace = resource{Workspace: "testing-33", Collection: "widgets_1", UpdateMode: updateModeReplaceOnKey}
+	require.Nil(t, replace.Validate())
+
+	var badMode = resource{Workspace: "testing-33", Collection: "widgets_1", UpdateMode: "merge"}
+	require.Error(t, badMode.Validate())
+
+	var badSequence = resource{Workspace: "testing-33", Collection: "widgets_1", SequenceField: "_id"}
+	require.Error(t, badSequence.Validate())
+
+	var staged = resource{Workspace: "testing-33", Collection: "widgets_1", StageBackfill: &stagingTarget{Integration: "staging", Bucket: "bucket", Prefix: "widgets/"}}
+	require.Nil(t, staged.Validate())
+	staged.StageBackfill.Prefix = ""
+	require.Error(t, staged.Validate())
+	staged.StageBackfill.Prefix = "widgets/"
+	staged.InitializeFromS3 = &cloudStorageIntegration{Integration: "staging", Bucket: "bucket"}
+	require.Error(t, staged.Validate())
+
+	var settings = resource{Workspace: "testing-33", Collection: "widgets_1", AdvancedCollectionSettings: &collectionSettings{
+		DropFields:   []string{"payload"},
+		FieldSchemas: []fieldSchema{{FieldName: "name", IndexMode: "no_index", ColumnIndexMode: "store"}},
+	}}
+	require.Nil(t, settings.Validate())
+	settings.AdvancedCollectionSettings.FieldSchemas[0].ColumnIndexMode = "columnar"
+	require.Error(t, settings.Validate())
+	settings.AdvancedCollectionSettings.FieldSchemas[0].ColumnIndexMode = "no_store"
+	settings.AdvancedCollectionSettings.FieldSchemas = append(settings.AdvancedCollectionSettings.FieldSchemas, fieldSchema{FieldName: "payload"})
+	require.Error(t, settings.Validate())
+	settings.AdvancedCollectionSettings.FieldSchemas = nil
+	settings.AdvancedCollectionSettings.DropFields = []string{"_id"}
+	require.Error(t, settings.Validate())
+	settings.AdvancedCollectionSettings.DropFields = []string{"ts"}
+	settings.AdvancedCollectionSettings.EventTimeInfo = &eventTimeInfo{Field: "ts"}
+	require.Error(t, settings.Validate())
+}
+
+func TestValidateSettingsFields(t *testing.T) {
+	var collection = pf.CollectionSpec{
+		Collection: "widgets",
+		Projections: []pf.Projection{
+			{Ptr: "/id", Field: "id", IsPrimaryKey: true},
+			{Ptr: "/payload", Field: "payload"},
+		},
+	}
+	var res = resource{Workspace: "testing", Collection: "widgets", SequenceField: "seq", AdvancedCollectionSettings: &collectionSettings{
+		DropFields:   []string{"payload.blob"},
+		FieldSchemas: []fieldSchema{{FieldName: "id", RangeIndexMode: "no_index"}, {FieldName: "seq", ColumnIndexMode: "store"}},
+	}}
+	require.NoError(t, validateSettingsFields(&collection, &res))
+
+	res.AdvancedCollectionSettings.DropFields = []string{"missing"}
+	require.Error(t, validateSettingsFields(&collection, &res))
+}
+
+func TestRocksetCreateCollectionSettings(t *testing.T) {
+	var created map[string]interface{}
+	var driver = &rocksetDriver{httpClient: mockHTTPClient(func(req *http.Request) (int, string) {
+		require.Equal(t, http.MethodPost, req.Method)
+		require.True(t, strings.HasSuffix(req.URL.Path, "/ws/testing/collections"), req.URL.Path)
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&created))
+		return http.StatusOK, `{"data":{"name":"widgets"}}`
+	})}
+	client, err := driver.newClient(&config{ApiKey: "not-a-real-key"})
+	require.NoError(t, err)
+
+	var res = resource{Workspace: "testing", Collection: "widgets", AdvancedCollectionSettings: &collectionSettings{
+		DropFields: []string{"payload"},
+		FieldSchemas: []fieldSchema{
+			{FieldName: "name", IndexMode: "no_index", RangeIndexMode: "no_index"},
+			{FieldName: "total", ColumnIndexMode: "store"},
+		},
+	}}
+	require.NoError(t, createCollection(context.Background(), client, &res, nil))
+
+	// The hints are sent as the field mappings and field schemas of the new collection.
+	require.Equal(t, []interface{}{map[string]interface{}{
+		"name":         "drop_payload",
+		"input_fields": []interface{}{map[string]interface{}{"field_name": "payload", "is_drop": true}},
+	}}, created["field_mappings"])
+	require.Equal(t, []interface{}{
+		map[string]interface{}{
+			"field_name":    "name",
+			"field_options": map[string]interface{}{"index_mode": "no_index", "range_index_mode": "no_index"},
+		},
+		map[string]interface{}{
+			"field_name":    "total",
+			"field_options": map[string]interface{}{"column_index_mode": "store"},
+		},
+	}, created["field_schemas"])
+}
+
+func TestValidatePatchable(t *testing.T) {
+	var collection = pf.CollectionSpec{
+		Collection: "widgets",
+		KeyPtrs:    []string{"/id"},
+		Projections: []pf.Projection{
+			{Ptr: "/id", Field: "id", IsPrimaryKey: true},
+			{Ptr: "/name", Field: "name"},
+		},
+	}
+	require.NoError(t, validatePatchable(&collection))
+
+	var withID = collection
+	withID.Projections = append(withID.Projections, pf.Projection{Ptr: "/other", Field: "_id"})
+	require.Error(t, validatePatchable(&withID))
+
+	var keyless = collection
+	keyless.KeyPtrs = nil
+	require.Error(t, validatePatchable(&keyless))
+}
+
+func TestRocksetPatchDocuments(t *testing.T) {
+	var ctx = context.Background()
+	var requests = make(map[string][]byte)
+	var missingID string
+
+	var driver = &rocksetDriver{httpClient: mockHTTPClient(func(req *http.Request) (int, string) {
+		var body, err = ioutil.ReadAll(req.Body)
+		require.NoError(t, err)
+		requests[req.Method] = body
+
+		var parsed struct {
+			Data []map[string]interface{} `json:"data"`
+		}
+		require.NoError(t, json.Unmarshal(body, &parsed))
+		var statuses []string
+		for _, doc := range parsed.Data {
+			var id = doc["_id"].(string)
+			if req.Method == http.MethodPatch && id == missingID {
+				statuses = append(statuses, fmt.Sprintf(`{"_id":%q,"status":"ERROR","error":{"type":"NotFound","message":"document not found"}}`, id))
+			} else {
+				statuses = append(statuses, fmt.Sprintf(`{"_id":%q,"status":"OK"}`, id))
+			}
+		}
+		return http.StatusOK, fmt.Sprintf(`{"data":[%s]}`, strings.Join(statuses, ","))
+	})}
+	client, err := driver.newClient(&config{ApiKey: "not-a-real-key"})
+	require.NoError(t, err)
+
+	var b = NewBinding(&pf.MaterializationSpec_Binding{
+		FieldSelection: pf.FieldSelection{
+			Keys:   []string{"id"},
+			Values: []string{"name", "a/b"},
+		},
+	}, &resource{Workspace: "testing", Collection: "widgets", UpdateMode: updateModePatch})
+	var txn = transactor{client: client, bindings: []*binding{b}}
+
+	var existing = buildDocument(b, tuple.Tuple{"one"}, tuple.Tuple{"first", int64(1)})
+	var missing = buildDocument(b, tuple.Tuple{"two"}, tuple.Tuple{"second", int64(2)})
+	missingID = missing["_id"].(string)
+	require.NoError(t, txn.sendReq(ctx, b, []interface{}{existing, missing}))
+
+	// Both documents are sent as patches of just the materialized fields.
+	var patchReq struct {
+		Data []struct {
+			ID    string `json:"_id"`
+			Patch []struct {
+				Op    string
+				Path  string
+				Value interface{}
+			} `json:"patch"`
+		} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(requests[http.MethodPatch], &patchReq))
+	require.Len(t, patchReq.Data, 2)
+	require.Equal(t, existing["_id"], patchReq.Data[0].ID)
+	require.Len(t, patchReq.Data[0].Patch, 3)
+	require.Equal(t, "add", patchReq.Data[0].Patch[0].Op)
+	require.Equal(t, "/a~1b", patchReq.Data[0].Patch[0].Path)
+	require.Equal(t, float64(1), patchReq.Data[0].Patch[0].Value)
+	require.Equal(t, "/id", patchReq.Data[0].Patch[1].Path)
+	require.Equal(t, "one", patchReq.Data[0].Patch[1].Value)
+	require.Equal(t, "/name", patchReq.Data[0].Patch[2].Path)
+	require.Equal(t, "first", patchReq.Data[0].Patch[2].Value)
+
+	// The document which didn't exist yet is then added in full.
+	var addReq struct {
+		Data []map[string]interface{} `json:"data"`
+	}
+	require.NoError(t, json.Unmarshal(requests[http.MethodPost], &addReq))
+	require.Equal(t, []map[string]interface{}{{
+		"_id":  missingID,
+		"id":   "two",
+		"name": "second",
+		"a/b":  float64(2),
+	}}, addReq.Data)
+}
+
+func TestRocksetPartialCommitRetry(t *testing.T) {
+	var ctx = context.Background()
+	var prevBackoff = partialCommitBackoff
+	partialCommitBackoff = 0
+	defer func() { partialCommitBackoff = prevBackoff }()
+
+	// The first request accepts only every other document, and later requests accept them all.
+	var requests int
+	var sent [][]string
+	var accepted = make(map[string]int)
+	var driver = &rocksetDriver{httpClient: mockHTTPClient(func(req *http.Request) (int, string) {
+		var parsed struct {
+			Data []map[string]interface{} `json:"data"`
+		}
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&parsed))
+		requests++
+		var ids []string
+		var statuses []string
+		for i, doc := range parsed.Data {
+			var id = doc["_id"].(string)
+			ids = append(ids, id)
+			if requests == 1 && i%2 == 1 {
+				statuses = append(statuses, fmt.Sprintf(`{"_id":%q,"status":"ERROR","error":{"type":"InternalError","message":"try again"}}`, id))
+			} else {
+				accepted[id]++
+				statuses = append(statuses, fmt.Sprintf(`{"_id":%q,"status":"OK"}`, id))
+			}
+		}
+		sent = append(sent, ids)
+		return http.StatusOK, fmt.Sprintf(`{"data":[%s]}`, strings.Join(statuses, ","))
+	})}
+	client, err := driver.newClient(&config{ApiKey: "not-a-real-key"})
+	require.NoError(t, err)
+
+	var b = NewBinding(&pf.MaterializationSpec_Binding{
+		FieldSelection: pf.FieldSelection{
+			Keys:   []string{"id"},
+			Values: []string{"name"},
+		},
+	}, &resource{Workspace: "testing", Collection: "widgets"})
+	var cfg = config{ApiKey: "not-a-real-key", PartialCommitRetries: 2}
+	var txn = transactor{config: &cfg, client: client, bindings: []*binding{b}}
+
+	var docs []interface{}
+	var ids []string
+	for i := 0; i < 10; i++ {
+		var doc = buildDocument(b, tuple.Tuple{fmt.Sprintf("k%d", i)}, tuple.Tuple{fmt.Sprintf("name%d", i)})
+		docs = append(docs, doc)
+		ids = append(ids, doc["_id"].(string))
+	}
+	require.NoError(t, txn.sendReq(ctx, b, docs))
+
+	// Only the rejected half of the documents is resent, and every document is accepted once.
+	require.Len(t, sent, 2)
+	require.Equal(t, ids, sent[0])
+	require.Equal(t, []string{ids[1], ids[3], ids[5], ids[7], ids[9]}, sent[1])
+	require.Len(t, accepted, len(ids))
+	for _, id := range ids {
+		require.Equal(t, 1, accepted[id])
+	}
+
+	// Without retries, the rejected documents fail the request.
+	requests, sent, accepted = 0, nil, make(map[string]int)
+	cfg.PartialCommitRetries = 0
+	require.Error(t, txn.sendReq(ctx, b, docs))
+	require.Len(t, sent, 1)
+
+	cfg.PartialCommitRetries = -1
+	require.Error(t, cfg.Validate())
+}
+
+func TestRocksetWriteOrdering(t *testing.T) {
+	var ctx = context.Background()
+	var sent [][]map[string]interface{}
+	var driver = &rocksetDriver{httpClient: mockHTTPClient(func(req *http.Request) (int, string) {
+		var parsed struct {
+			Data []map[string]interface{} `json:"data"`
+		}
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&parsed))
+		sent = append(sent, parsed.Data)
+		var statuses []string
+		for _, doc := range parsed.Data {
+			statuses = append(statuses, fmt.Sprintf(`{"_id":%q,"status":"OK"}`, doc["_id"]))
+		}
+		return http.StatusOK, fmt.Sprintf(`{"data":[%s]}`, strings.Join(statuses, ","))
+	})}
+	client, err := driver.newClient(&config{ApiKey: "not-a-real-key"})
+	require.NoError(t, err)
+
+	var spec = &pf.MaterializationSpec_Binding{
+		FieldSelection: pf.FieldSelection{
+			Keys:   []string{"id"},
+			Values: []string{"name", "seq"},
+		},
+	}
+	var res = resource{Workspace: "testing", Collection: "widgets", SequenceField: "seq"}
+	var b = NewBinding(spec, &res)
+	require.False(t, b.injectSequence)
+	var txn = transactor{client: client, bindings: []*binding{b}}
+
+	// Updates of the same key which were stored out of order are resolved by the sequence field,
+	// and only the latest version of each key is sent.
+	var docs = []interface{}{
+		buildDocument(b, tuple.Tuple{"one"}, tuple.Tuple{"third", int64(3)}),
+		buildDocument(b, tuple.Tuple{"two"}, tuple.Tuple{"first", int64(1)}),
+		buildDocument(b, tuple.Tuple{"one"}, tuple.Tuple{"second", int64(2)}),
+		buildDocument(b, tuple.Tuple{"two"}, tuple.Tuple{"second", int64(2)}),
+	}
+	require.NoError(t, txn.sendReq(ctx, b, docs))
+	require.Len(t, sent, 1)
+	require.Len(t, sent[0], 2)
+	require.Equal(t, "one", sent[0][0]["id"])
+	require.Equal(t, "third", sent[0][0]["name"])
+	require.Equal(t, "two", sent[0][1]["id"])
+	require.Equal(t, "second", sent[0][1]["name"])
+
+	// Without a sequence field, the last version of each key that was stored wins.
+	res.SequenceField = ""
+	sent = nil
+	require.NoError(t, txn.sendReq(ctx, b, docs))
+	require.Len(t, sent[0], 2)
+	require.Equal(t, "second", sent[0][0]["name"])
+	require.Equal(t, "second", sent[0][1]["name"])
+
+	// A sequence field that isn't materialized is injected, and keeps increasing even if the
+	// clock doesn't.
+	var injected = NewBinding(spec, &resource{Workspace: "testing", Collection: "widgets", SequenceField: "_seq"})
+	require.True(t, injected.injectSequence)
+	var now = time.Unix(1000, 0)
+	var seq = &sequencer{now: func() time.Time { return now }}
+	require.Equal(t, now.UnixNano(), seq.next())
+	require.Equal(t, now.UnixNano()+1, seq.next())
+	now = now.Add(-time.Second)
+	require.Equal(t, time.Unix(1000, 0).UnixNano()+2, seq.next())
+	now = now.Add(time.Hour)
+	require.Equal(t, now.UnixNano(), seq.next())
+}
+
+func TestRocksetBufferedBytes(t *testing.T) {
+	var mu sync.Mutex
+	var sent = make(map[string][]int)
+	var driver = &rocksetDriver{httpClient: mockHTTPClient(func(req *http.Request) (int, string) {
+		var parsed struct {
+			Data []map[string]interface{} `json:"data"`
+		}
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&parsed))
+		var collection = strings.Split(strings.TrimSuffix(req.URL.Path, "/docs"), "/collections/")[1]
+		mu.Lock()
+		sent[collection] = append(sent[collection], len(parsed.Data))
+		mu.Unlock()
+		var statuses []string
+		for _, doc := range parsed.Data {
+			statuses = append(statuses, fmt.Sprintf(`{"_id":%q,"status":"OK"}`, doc["_id"]))
+		}
+		return http.StatusOK, fmt.Sprintf(`{"data":[%s]}`, strings.Join(statuses, ","))
+	})}
+	client, err := driver.newClient(&config{ApiKey: "not-a-real-key"})
+	require.NoError(t, err)
+
+	var spec = &pf.MaterializationSpec_Binding{
+		FieldSelection: pf.FieldSelection{
+			Keys:   []string{"id"},
+			Values: []string{"payload"},
+		},
+	}
+	// Both bindings have the same budget, but the documents of one are much larger than the other.
+	var small = NewBinding(spec, &resource{Workspace: "testing", Collection: "small", MaxBufferedBytes: 1000})
+	var large = NewBinding(spec, &resource{Workspace: "testing", Collection: "large", MaxBufferedBytes: 1000})
+	var txn = transactor{client: client, bindings: []*binding{small, large}}
+
+	var group, ctx = errgroup.WithContext(context.Background())
+	var smallCh = make(chan map[string]interface{})
+	var largeCh = make(chan map[string]interface{})
+	group.Go(func() error { return txn.sendAllDocuments(ctx, small, smallCh) })
+	group.Go(func() error { return txn.sendAllDocuments(ctx, large, largeCh) })
+	for i := 0; i < 10; i++ {
+		var key = tuple.Tuple{fmt.Sprintf("k%d", i)}
+		var smallDoc = buildDocument(small, key, tuple.Tuple{strings.Repeat("s", 10)})
+		var largeDoc = buildDocument(large, key, tuple.Tuple{strings.Repeat("l", 300)})
+		require.Equal(t, 50, documentSize(smallDoc))
+		require.Equal(t, 340, documentSize(largeDoc))
+		smallCh <- smallDoc
+		largeCh <- largeDoc
+	}
+	close(smallCh)
+	close(largeCh)
+	require.NoError(t, group.Wait())
+
+	// The large documents are flushed whenever another wouldn't fit within the budget, while all of
+	// the small documents fit in a single request.
+	require.Equal(t, []int{10}, sent["small"])
+	require.Equal(t, []int{2, 2, 2, 2, 2}, sent["large"])
+
+	var invalid = resource{Workspace: "testing", Collection: "widgets", MaxBufferedBytes: -1}
+	require.Error(t, invalid.Validate())
+}
+
+func TestRocksetChangeIndicator(t *testing.T) {
+	var spec = &pf.MaterializationSpec_Binding{
+		FieldSelection: pf.FieldSelection{
+			Keys:   []string{"id"},
+			Values: []string{"op", "name"},
+		},
+	}
+	var docs = []map[string]interface{}{
+		{"_id": "a", "id": "a", "op": "Insert"},
+		{"_id": "b", "id": "b", "op": "update"},
+		{"_id": "c", "id": "c", "op": "Delete"},
+		{"_id": "d", "id": "d", "op": "c"},
+		{"_id": "e", "id": "e", "op": "d"},
+		// The indicator is absent or holds an unrecognized value.
+		{"_id": "f", "id": "f"},
+		{"_id": "g", "id": "g", "op": nil},
+		{"_id": "h", "id": "h", "op": "upsert"},
+		{"_id": "i", "id": "i", "op": int64(1)},
+	}
+	var recognized = []string{changeInsert, changeUpdate, changeDelete, changeInsert, changeDelete}
+
+	for _, tc := range []struct {
+		policy  string
+		missing string
+		err     bool
+	}{
+		{"", changeInsert, false},
+		{missingChangeInsert, changeInsert, false},
+		{missingChangeSkip, "", false},
+		{missingChangeError, "", true},
+	} {
+		var res = &resource{Workspace: "testing", Collection: "widgets", ChangeIndicator: "op", MissingChangeIndicator: tc.policy}
+		require.NoError(t, res.Validate())
+		var b = NewBinding(spec, res)
+
+		for i, doc := range docs {
+			var change, err = b.changeType(doc)
+			if i < len(recognized) {
+				require.NoError(t, err)
+				require.Equal(t, recognized[i], change, "policy %q document %v", tc.policy, doc)
+			} else if tc.err {
+				require.Error(t, err, "policy %q document %v", tc.policy, doc)
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, tc.missing, change, "policy %q document %v", tc.policy, doc)
+			}
+		}
+		// The documents without a recognized indicator are counted so they can be logged.
+		require.Equal(t, 4, b.missingChanges)
+		b.logMissingChanges()
+		require.Equal(t, 0, b.missingChanges)
+	}
+
+	// Bindings without a change indicator only write documents.
+	var plain = NewBinding(spec, &resource{Workspace: "testing", Collection: "widgets"})
+	for _, doc := range docs {
+		var change, err = plain.changeType(doc)
+		require.NoError(t, err)
+		require.Equal(t, changeInsert, change)
+	}
+	require.Equal(t, 0, plain.missingChanges)
+
+	var invalid = resource{Workspace: "testing", Collection: "widgets", ChangeIndicator: "op", MissingChangeIndicator: "ignore"}
+	require.Error(t, invalid.Validate())
+	invalid = resource{Workspace: "testing", Collection: "widgets", MissingChangeIndicator: missingChangeSkip}
+	require.Error(t, invalid.Validate())
+	invalid = resource{Workspace: "testing", Collection: "widgets", ChangeIndicator: "_id"}
+	require.Error(t, invalid.Validate())
+}
+
+func TestRocksetFlattening(t *testing.T) {
+	var doc = map[string]interface{}{
+		"_id":  "a",
+		"id":   "a",
+		"seq":  int64(3),
+		"user": json.RawMessage(`{"name": "alice", "address": {"city": "Paris", "zip": "75001"}, "tags": [{"a": 1}], "prefs": {}}`),
+		"n":    json.RawMessage(`1.50`),
+	}
+	var reshape = func(res *resource, doc map[string]interface{}) (string, error) {
+		require.NoError(t, res.Validate())
+		var reshaped, err = NewBinding(&pf.MaterializationSpec_Binding{}, res).reshapeDocument(doc)
+		if err != nil {
+			return "", err
+		}
+		encoded, err := json.Marshal(reshaped)
+		require.NoError(t, err)
+		return string(encoded), nil
+	}
+
+	// Nested objects are flattened, while arrays and empty objects are kept as they are.
+	var res = &resource{Workspace: "testing", Collection: "widgets", SequenceField: "seq", Flattening: &flattenSettings{Mode: flattenModeFlatten}}
+	var flattened, err = reshape(res, doc)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"_id": "a", "id": "a", "seq": 3, "n": 1.50,
+		"user.name": "alice", "user.address.city": "Paris", "user.address.zip": "75001",
+		"user.tags": [{"a": 1}], "user.prefs": {}
+	}`, flattened)
+
+	// Only the configured depth is flattened, using the configured separator.
+	res.Flattening = &flattenSettings{Mode: flattenModeFlatten, Separator: "__", MaxDepth: 1}
+	flattened, err = reshape(res, doc)
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"_id": "a", "id": "a", "seq": 3, "n": 1.50,
+		"user__name": "alice", "user__address": {"city": "Paris", "zip": "75001"},
+		"user__tags": [{"a": 1}], "user__prefs": {}
+	}`, flattened)
+
+	// Nesting reverses flattening, and merges into fields which are already objects.
+	res.Flattening = &flattenSettings{Mode: flattenModeNest}
+	nested, err := reshape(res, map[string]interface{}{
+		"_id":               "a",
+		"user":              json.RawMessage(`{"name": "alice"}`),
+		"user.address.city": "Paris",
+		"user.address.zip":  "75001",
+		"user.tags":         json.RawMessage(`[{"a.b": 1}]`),
+		"meta.version":      int64(1),
+		".hidden":           true,
+	})
+	require.NoError(t, err)
+	require.JSONEq(t, `{
+		"_id": "a", "meta": {"version": 1}, ".hidden": true,
+		"user": {"name": "alice", "address": {"city": "Paris", "zip": "75001"}, "tags": [{"a.b": 1}]}
+	}`, nested)
+
+	res.Flattening.MaxDepth = 1
+	nested, err = reshape(res, map[string]interface{}{"_id": "a", "user.address.city": "Paris"})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"_id": "a", "user": {"address.city": "Paris"}}`, nested)
+
+	// Fields which would collide are errors.
+	res.Flattening = &flattenSettings{Mode: flattenModeFlatten}
+	_, err = reshape(res, map[string]interface{}{"_id": "a", "user": json.RawMessage(`{"name": "alice"}`), "user.name": "bob"})
+	require.Error(t, err)
+	res.Flattening = &flattenSettings{Mode: flattenModeNest}
+	_, err = reshape(res, map[string]interface{}{"_id": "a", "user": "alice", "user.name": "bob"})
+	require.Error(t, err)
+	_, err = reshape(res, map[string]interface{}{"_id": "a", "user": json.RawMessage(`{"name": "alice"}`), "user.name": "bob"})
+	require.Error(t, err)
+
+	// The separator must not be part of the name of any projection of a flattened collection.
+	var collection = pf.CollectionSpec{
+		Collection: "widgets",
+		Projections: []pf.Projection{
+			{Ptr: "/id", Field: "id", IsPrimaryKey: true},
+			{Ptr: "/user", Field: "user"},
+			{Ptr: "/user.name", Field: "user.name"},
+		},
+	}
+	res.Flattening = &flattenSettings{Mode: flattenModeFlatten}
+	require.Error(t, validateFlattenFields(&collection, res))
+	res.Flattening.Separator = "__"
+	require.NoError(t, validateFlattenFields(&collection, res))
+	res.Flattening = &flattenSettings{Mode: flattenModeNest}
+	require.NoError(t, validateFlattenFields(&collection, res))
+
+	var invalid = resource{Workspace: "testing", Collection: "widgets", Flattening: &flattenSettings{Mode: "unnest"}}
+	require.Error(t, invalid.Validate())
+	invalid.Flattening = &flattenSettings{Mode: flattenModeFlatten, MaxDepth: -1}
+	require.Error(t, invalid.Validate())
+}
+
+func TestRocksetDeleteDocuments(t *testing.T) {
+	var ctx = context.Background()
+	var deleted [][]string
+	var driver = &rocksetDriver{httpClient: mockHTTPClient(func(req *http.Request) (int, string) {
+		require.Equal(t, http.MethodDelete, req.Method)
+		var parsed struct {
+			Data []map[string]interface{} `json:"data"`
+		}
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&parsed))
+		var ids []string
+		var statuses []string
+		for _, doc := range parsed.Data {
+			var id = doc["_id"].(string)
+			ids = append(ids, id)
+			if id == "missing" {
+				statuses = append(statuses, fmt.Sprintf(`{"_id":%q,"status":"ERROR","error":{"type":"NotFound","message":"document not found"}}`, id))
+			} else {
+				statuses = append(statuses, fmt.Sprintf(`{"_id":%q,"status":"DELETED"}`, id))
+			}
+		}
+		deleted = append(deleted, ids)
+		return http.StatusOK, fmt.Sprintf(`{"data":[%s]}`, strings.Join(statuses, ","))
+	})}
+	client, err := driver.newClient(&config{ApiKey: "not-a-real-key"})
+	require.NoError(t, err)
+
+	var b = NewBinding(&pf.MaterializationSpec_Binding{}, &resource{Workspace: "testing", Collection: "widgets", ChangeIndicator: "op"})
+	var txn = transactor{client: client, bindings: []*binding{b}}
+
+	// Deletes are sent in batches, and documents which don't exist are already deleted.
+	var ids = []string{"missing"}
+	for i := 1; i < storeBatchSize+10; i++ {
+		ids = append(ids, fmt.Sprintf("doc-%d", i))
+	}
+	b.deletes = append([]string(nil), ids...)
+	require.NoError(t, txn.sendDeletes(ctx, b))
+	require.Equal(t, [][]string{ids[:storeBatchSize], ids[storeBatchSize:]}, deleted)
+	require.Empty(t, b.deletes)
+
+	// There's nothing to send if the transaction didn't delete any documents.
+	deleted = nil
+	require.NoError(t, txn.sendDeletes(ctx, b))
+	require.Nil(t, deleted)
+}
+
+func TestRocksetReplaceOnKey(t *testing.T) {
+	var ctx = context.Background()
+	// The mock collection merges the fields of added documents into any existing document with
+	// the same `_id`, so that only deleting it first removes the fields it no longer has.
+	var stored = make(map[string]map[string]interface{})
+	var methods []string
+	var driver = &rocksetDriver{httpClient: mockHTTPClient(func(req *http.Request) (int, string) {
+		var parsed struct {
+			Data []map[string]interface{} `json:"data"`
+		}
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&parsed))
+		methods = append(methods, req.Method)
+		var statuses []string
+		for _, doc := range parsed.Data {
+			var id = doc["_id"].(string)
+			switch req.Method {
+			case http.MethodDelete:
+				if _, ok := stored[id]; !ok {
+					statuses = append(statuses, fmt.Sprintf(`{"_id":%q,"status":"ERROR","error":{"type":"NotFound","message":"document not found"}}`, id))
+					continue
+				}
+				delete(stored, id)
+			case http.MethodPost:
+				if stored[id] == nil {
+					stored[id] = make(map[string]interface{})
+				}
+				for field, value := range doc {
+					stored[id][field] = value
+				}
+			}
+			statuses = append(statuses, fmt.Sprintf(`{"_id":%q,"status":"OK"}`, id))
+		}
+		return http.StatusOK, fmt.Sprintf(`{"data":[%s]}`, strings.Join(statuses, ","))
+	})}
+	client, err := driver.newClient(&config{ApiKey: "not-a-real-key"})
+	require.NoError(t, err)
+
+	var b = NewBinding(&pf.MaterializationSpec_Binding{
+		FieldSelection: pf.FieldSelection{Keys: []string{"id"}},
+	}, &resource{Workspace: "testing", Collection: "widgets", UpdateMode: updateModeReplaceOnKey})
+	var txn = transactor{client: client, bindings: []*binding{b}}
+
+	var first = buildDocument(b, tuple.Tuple{"one"}, nil)
+	first["name"], first["color"] = "first", "red"
+	require.NoError(t, txn.sendReq(ctx, b, []interface{}{first}))
+	require.Equal(t, "red", stored[first["_id"].(string)]["color"])
+
+	// The updated document doesn't have a color, and so the stored document no longer does.
+	var updated = buildDocument(b, tuple.Tuple{"one"}, nil)
+	updated["name"] = "updated"
+	var other = buildDocument(b, tuple.Tuple{"two"}, nil)
+	other["name"] = "second"
+	require.NoError(t, txn.sendReq(ctx, b, []interface{}{updated, other}))
+	require.Equal(t, map[string]interface{}{"_id": updated["_id"], "id": "one", "name": "updated"}, stored[updated["_id"].(string)])
+	require.Equal(t, map[string]interface{}{"_id": other["_id"], "id": "two", "name": "second"}, stored[other["_id"].(string)])
+
+	// Each batch deletes its documents in a single request before adding them.
+	require.Equal(t, []string{http.MethodDelete, http.MethodPost, http.MethodDelete, http.MethodPost}, methods)
+}
+
+func TestRocksetVerifiedAck(t *testing.T) {
+	var ctx = context.Background()
+	var queries []map[string]interface{}
+	var queryable = 2
+	var driver = &rocksetDriver{httpClient: mockHTTPClient(func(req *http.Request) (int, string) {
+		if strings.HasSuffix(req.URL.Path, "/queries") {
+			var query struct {
+				SQL map[string]interface{} `json:"sql"`
+			}
+			require.NoError(t, json.NewDecoder(req.Body).Decode(&query))
+			queries = append(queries, query.SQL)
+			var count = 0
+			if len(queries) >= queryable {
+				count = 1
+			}
+			return http.StatusOK, fmt.Sprintf(`{"results":[{"n":%d}]}`, count)
+		}
+		var parsed struct {
+			Data []map[string]interface{} `json:"data"`
+		}
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&parsed))
+		var statuses []string
+		for _, doc := range parsed.Data {
+			statuses = append(statuses, fmt.Sprintf(`{"_id":%q,"status":"OK"}`, doc["_id"]))
+		}
+		return http.StatusOK, fmt.Sprintf(`{"data":[%s]}`, strings.Join(statuses, ","))
+	})}
+	client, err := driver.newClient(&config{ApiKey: "not-a-real-key"})
+	require.NoError(t, err)
+
+	var b = NewBinding(&pf.MaterializationSpec_Binding{
+		FieldSelection: pf.FieldSelection{Keys: []string{"id"}, Values: []string{"seq"}},
+	}, &resource{Workspace: "testing", Collection: "widgets", SequenceField: "seq"})
+	var cfg = config{ApiKey: "not-a-real-key", AckMode: ackModeVerified, VerifyTimeoutSecs: 10}
+	var txn = transactor{config: &cfg, client: client, bindings: []*binding{b}}
+
+	var runTxn = func(docs ...map[string]interface{}) error {
+		var ch = make(chan map[string]interface{}, len(docs))
+		for _, doc := range docs {
+			ch <- doc
+		}
+		close(ch)
+		txn.errGroup = new(errgroup.Group)
+		txn.errGroup.Go(func() error { return txn.sendAllDocuments(ctx, b, ch) })
+		if err := txn.Commit(ctx); err != nil {
+			return err
+		}
+		return txn.Acknowledge(ctx)
+	}
+
+	// The transaction isn't acknowledged until the final document it wrote can be queried with
+	// at least the sequence value that was written.
+	var last = buildDocument(b, tuple.Tuple{"two"}, tuple.Tuple{int64(5)})
+	require.NoError(t, runTxn(buildDocument(b, tuple.Tuple{"one"}, tuple.Tuple{int64(4)}), last))
+	require.Len(t, queries, 2)
+	require.Equal(t, `SELECT COUNT(*) AS n FROM "testing"."widgets" WHERE _id = :id AND "seq" >= :sequence`, queries[0]["query"])
+	require.Equal(t, []interface{}{
+		map[string]interface{}{"name": "id", "type": "string", "value": last["_id"]},
+		map[string]interface{}{"name": "sequence", "type": "int", "value": "5"},
+	}, queries[0]["parameters"])
+
+	// A transaction which writes nothing needs no verification.
+	queries = nil
+	require.NoError(t, txn.Commit(ctx))
+	require.NoError(t, txn.Acknowledge(ctx))
+	require.Empty(t, queries)
+
+	// The acknowledgment fails if the documents aren't queryable before the timeout.
+	queryable = 100
+	cfg.VerifyTimeoutSecs = 1
+	err = runTxn(buildDocument(b, tuple.Tuple{"one"}, tuple.Tuple{int64(6)}))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "were not queryable after 1s")
+
+	// Documents are acknowledged immediately unless verification is configured.
+	queries = nil
+	cfg.AckMode = ackModeImmediate
+	require.NoError(t, runTxn(buildDocument(b, tuple.Tuple{"one"}, tuple.Tuple{int64(7)})))
+	require.Empty(t, queries)
+}
+
+// mockObjectStore records the objects which are put to it.
+type mockObjectStore struct {
+	objects map[string]string
+}
+
+func (m *mockObjectStore) putObject(ctx context.Context, bucket, key string, body io.Reader) error {
+	var data, err = ioutil.ReadAll(body)
+	if err != nil {
+		return err
+	}
+	m.objects[bucket+"/"+key] = string(data)
+	return nil
+}
+
+func TestRocksetBackfillStaging(t *testing.T) {
+	var ctx = context.Background()
+	var added [][]map[string]interface{}
+	var collectionRequests int
+	var driver = &rocksetDriver{httpClient: mockHTTPClient(func(req *http.Request) (int, string) {
+		if req.Method == http.MethodGet {
+			// The collection has ingested all of the staged objects.
+			collectionRequests++
+			return http.StatusOK, `{"data":{"name":"widgets","status":"READY","created_at":"2022-06-01T00:00:00Z",` +
+				`"sources":[{"integration_name":"staging","s3":{"bucket":"bucket","prefixes":[],"object_count_total":2,"object_count_downloaded":2}}]}}`
+		}
+		var parsed struct {
+			Data []map[string]interface{} `json:"data"`
+		}
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&parsed))
+		added = append(added, parsed.Data)
+		var statuses []string
+		for _, doc := range parsed.Data {
+			statuses = append(statuses, fmt.Sprintf(`{"_id":%q,"status":"OK"}`, doc["_id"]))
+		}
+		return http.StatusOK, fmt.Sprintf(`{"data":[%s]}`, strings.Join(statuses, ","))
+	})}
+	var cfg = config{
+		ApiKey:          "not-a-real-key",
+		BackfillStaging: &stagingConfig{Provider: stagingProviderS3, Region: "us-east-1", MinDocuments: 2},
+	}
+	client, err := driver.newClient(&cfg)
+	require.NoError(t, err)
+
+	var store = &mockObjectStore{objects: make(map[string]string)}
+	var res = resource{
+		Workspace:     "testing",
+		Collection:    "widgets",
+		StageBackfill: &stagingTarget{Integration: "staging", Bucket: "bucket", Prefix: "backfill"},
+	}
+	var b = NewBinding(&pf.MaterializationSpec_Binding{
+		FieldSelection: pf.FieldSelection{
+			Keys:   []string{"id"},
+			Values: []string{"name"},
+		},
+	}, &res)
+	b.stager = newBackfillStager(store, res.StageBackfill)
+	var txn = transactor{config: &cfg, client: client, bindings: []*binding{b}}
+
+	var checkpoint = func() string {
+		var cp, err = txn.Prepare(ctx, pm.TransactionRequest_Prepare{})
+		require.NoError(t, err)
+		return string(cp.DriverCheckpointJson)
+	}
+	require.Equal(t, `{}`, checkpoint())
+
+	// A transaction with at least the minimum number of documents is staged as a single object,
+	// and the binding continues staging.
+	require.NoError(t, b.stager.add(buildDocument(b, tuple.Tuple{"one"}, tuple.Tuple{"first"})))
+	require.NoError(t, b.stager.add(buildDocument(b, tuple.Tuple{"two"}, tuple.Tuple{"second"})))
+	require.NoError(t, txn.commitStaged(ctx, b))
+	require.NotNil(t, b.stager)
+	require.Equal(t, 0, collectionRequests)
+	require.Len(t, store.objects, 1)
+	var firstKey = "bucket/backfill/" + b.stager.runID + "-000000.json"
+	var lines = strings.Split(strings.TrimSuffix(store.objects[firstKey], "\n"), "\n")
+	require.Len(t, lines, 2)
+	var staged map[string]interface{}
+	require.NoError(t, json.Unmarshal([]byte(lines[1]), &staged))
+	require.Equal(t, map[string]interface{}{"_id": staged["_id"], "id": "two", "name": "second"}, staged)
+
+	// Transactions without documents for the binding don't end its backfill.
+	require.NoError(t, txn.commitStaged(ctx, b))
+	require.NotNil(t, b.stager)
+	require.Len(t, store.objects, 1)
+
+	// A smaller transaction is still staged, but then the connector waits for Rockset to ingest
+	// everything that was staged before switching to the write API.
+	var stager = b.stager
+	require.NoError(t, b.stager.add(buildDocument(b, tuple.Tuple{"three"}, tuple.Tuple{"third"})))
+	require.NoError(t, txn.commitStaged(ctx, b))
+	require.Nil(t, b.stager)
+	require.Equal(t, 1, collectionRequests)
+	require.Len(t, store.objects, 2)
+	require.Contains(t, store.objects["bucket/backfill/"+stager.runID+"-000001.json"], `"id":"three"`)
+	require.Empty(t, added)
+	require.Equal(t, `{"stagedBackfills":["testing/widgets"]}`, checkpoint())
+
+	require.NoError(t, txn.sendReq(ctx, b, []interface{}{buildDocument(b, tuple.Tuple{"four"}, tuple.Tuple{"fourth"})}))
+	require.Len(t, added, 1)
+	require.Equal(t, "four", added[0][0]["id"])
 }
 
 func TestRocksetDriverSpec(t *testing.T) {
@@ -53,19 +906,121 @@ func TestRocksetDriverSpec(t *testing.T) {
 func TestRocksetDriverValidate(t *testing.T) {
 	driver := new(rocksetDriver)
 	config := config{ApiKey: fetchApiKey()}
-	var endpointSpecJson []byte
+	resource := resource{Workspace: "testing", Collection: "widgets"}
+
+	var validateReq = buildValidateRequest(t, config, resource)
+	response, err := driver.Validate(context.Background(), &validateReq)
+
+	require.NoError(t, err)
+	require.NotNil(t, response.Bindings)
+	require.Len(t, response.Bindings, 1)
+
+	var binding = response.Bindings[0]
+	require.Len(t, binding.Constraints, 2)
+	require.Equal(t, binding.ResourcePath, []string{"testing", "widgets"})
+	require.True(t, binding.DeltaUpdates)
+}
+
+func TestRocksetDriverValidateAccess(t *testing.T) {
+	var config = config{ApiKey: "not-a-real-key"}
+	var resource = resource{Workspace: "testing", Collection: "widgets"}
+	var validateReq = buildValidateRequest(t, config, resource)
+
+	t.Run("Unauthorized", func(t *testing.T) {
+		var driver = &rocksetDriver{httpClient: mockHTTPClient(func(req *http.Request) (int, string) {
+			return http.StatusUnauthorized, `{"message":"invalid API key","type":"Unauthorized"}`
+		})}
+		var _, err = driver.Validate(context.Background(), &validateReq)
+
+		var accessErr *accessError
+		require.True(t, errors.As(err, &accessErr), "expected an accessError, got: %v", err)
+		require.Equal(t, http.StatusUnauthorized, accessErr.StatusCode)
+		require.Equal(t, "", accessErr.Workspace)
+	})
+
+	t.Run("Forbidden", func(t *testing.T) {
+		var driver = &rocksetDriver{httpClient: mockHTTPClient(func(req *http.Request) (int, string) {
+			if strings.HasSuffix(req.URL.Path, "/ws") {
+				return http.StatusOK, `{"data":[]}`
+			}
+			return http.StatusForbidden, `{"message":"access denied","type":"Forbidden"}`
+		})}
+		var _, err = driver.Validate(context.Background(), &validateReq)
+
+		var accessErr *accessError
+		require.True(t, errors.As(err, &accessErr), "expected an accessError, got: %v", err)
+		require.Equal(t, http.StatusForbidden, accessErr.StatusCode)
+		require.Equal(t, "testing", accessErr.Workspace)
+		require.NotContains(t, err.Error(), config.ApiKey)
+	})
+}
+
+func TestHttpLoggingRedaction(t *testing.T) {
+	const apiKey = "super-secret-api-key"
+
+	var logged strings.Builder
+	var prevOut, prevLevel = logrus.StandardLogger().Out, logrus.GetLevel()
+	logrus.SetOutput(&logged)
+	logrus.SetLevel(logrus.DebugLevel)
+	defer func() {
+		logrus.SetOutput(prevOut)
+		logrus.SetLevel(prevLevel)
+	}()
+
+	var driver = &rocksetDriver{httpClient: mockHTTPClient(func(req *http.Request) (int, string) {
+		// The request itself must still be authenticated with the real key.
+		require.Equal(t, "apikey "+apiKey, req.Header.Get("Authorization"))
+		return http.StatusOK, fmt.Sprintf(`{"data":[{"_id":"1","status":"OK"},{"_id":"2","status":"OK"}],"echo":%q}`, apiKey)
+	})}
+	client, err := driver.newClient(&config{ApiKey: apiKey, HttpLogging: true, HttpLogMaxBodyBytes: 64})
+	require.NoError(t, err)
+
+	_, err = client.AddDocuments(context.Background(), "testing", "widgets", []interface{}{
+		map[string]interface{}{"_id": "1", "key": apiKey},
+		map[string]interface{}{"_id": "2", "padding": strings.Repeat("x", 500)},
+	})
+	require.NoError(t, err)
+
+	var out = logged.String()
+	require.NotEmpty(t, out)
+	require.NotContains(t, out, apiKey)
+	require.Contains(t, out, redacted)
+	require.Contains(t, out, "more bytes")
+	require.NotContains(t, out, strings.Repeat("x", 500))
+	require.Contains(t, out, "documents=2")
+	require.Contains(t, out, "status=200")
+}
+
+// mockTransport is an http.RoundTripper which responds to every request with the
+// status code and JSON body returned by its function.
+type mockTransport func(req *http.Request) (int, string)
+
+func (m mockTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var status, body = m(req)
+	return &http.Response{
+		StatusCode: status,
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Header:     http.Header{"Content-Type": []string{"application/json"}},
+		Body:       ioutil.NopCloser(strings.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+func mockHTTPClient(fn func(req *http.Request) (int, string)) *http.Client {
+	return &http.Client{Transport: mockTransport(fn)}
+}
+
+func buildValidateRequest(t *testing.T, config config, resource resource) pm.ValidateRequest {
+	t.Helper()
 
 	endpointSpecJson, err := json.Marshal(config)
 	if err != nil {
 		t.Fatalf("failed to marshal config: %v", err)
-		return
 	}
 
-	resource := resource{Workspace: "testing", Collection: "widgets"}
 	resourceSpecJson, err := json.Marshal(resource)
 	if err != nil {
 		t.Fatalf("failed to marshal resource: %v", err)
-		return
 	}
 
 	projections := []pf.Projection{
@@ -111,21 +1066,11 @@ func TestRocksetDriverValidate(t *testing.T) {
 		},
 	}
 
-	var validateReq = pm.ValidateRequest{
+	return pm.ValidateRequest{
 		Materialization:  "just-a-test",
 		EndpointSpecJson: endpointSpecJson,
 		Bindings:         bindings,
 	}
-	response, err := driver.Validate(context.Background(), &validateReq)
-
-	require.NoError(t, err)
-	require.NotNil(t, response.Bindings)
-	require.Len(t, response.Bindings, 1)
-
-	var binding = response.Bindings[0]
-	require.Len(t, binding.Constraints, 2)
-	require.Equal(t, binding.ResourcePath, []string{"testing", "widgets"})
-	require.True(t, binding.DeltaUpdates)
 }
 
 func TestRocksetDriverApply(t *testing.T) {
@@ -196,6 +1141,332 @@ func TestRocksetDriverApply(t *testing.T) {
 	log.Printf("Applied: %s", response.ActionDescription)
 }
 
+// fakeRocksetAPI simulates the workspace and collection endpoints of the Rockset API.
+type fakeRocksetAPI struct {
+	workspaces  map[string]bool
+	collections map[string]bool // Keyed by "<workspace>/<collection>".
+	// failCreate is a collection whose creation fails.
+	failCreate string
+	// hidden are workspaces and collections which aren't found by the next request to get
+	// them, as though they were created concurrently after being fetched.
+	hidden map[string]bool
+	// aliases hold the collections which each alias points at, keyed by "<workspace>/<alias>".
+	aliases map[string][]string
+	// integration is the name of a cloud storage source which each collection has, all of whose
+	// objects have been downloaded.
+	integration string
+	// failAliasUpdate fails the next update of an alias.
+	failAliasUpdate bool
+	// failAliasConfirm fails the next request to get an alias once one has been updated, which
+	// is tracked by updatedAlias.
+	failAliasConfirm bool
+	updatedAlias     bool
+}
+
+func (api *fakeRocksetAPI) handle(req *http.Request) (int, string) {
+	const notFound = `{"message":"not found","type":"NotFound"}`
+	const alreadyExists = `{"message":"already exists","type":"AlreadyExists"}`
+
+	var path = strings.Split(strings.TrimPrefix(req.URL.Path, "/v1/orgs/self/ws"), "/")
+	var name string
+	var body struct {
+		Name        string   `json:"name"`
+		Collections []string `json:"collections"`
+	}
+	if req.Method == http.MethodPost {
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			return http.StatusBadRequest, `{"message":"invalid body","type":"InvalidInput"}`
+		}
+		name = body.Name
+	}
+	var aliasResponse = func(workspace, alias string) (int, string) {
+		var data, _ = json.Marshal(map[string]interface{}{
+			"name":        alias,
+			"workspace":   workspace,
+			"collections": api.aliases[workspace+"/"+alias],
+		})
+		return http.StatusOK, fmt.Sprintf(`{"data":%s}`, data)
+	}
+
+	switch {
+	case len(path) == 3 && path[2] == "aliases" && req.Method == http.MethodPost:
+		var key = path[1] + "/" + name
+		if _, ok := api.aliases[key]; ok {
+			return http.StatusConflict, alreadyExists
+		}
+		api.aliases[key] = body.Collections
+		return aliasResponse(path[1], name)
+	case len(path) == 4 && path[2] == "aliases":
+		var key = path[1] + "/" + path[3]
+		if _, ok := api.aliases[key]; !ok {
+			return http.StatusNotFound, notFound
+		}
+		switch req.Method {
+		case http.MethodGet:
+			if api.failAliasConfirm && api.updatedAlias {
+				api.failAliasConfirm = false
+				return http.StatusBadRequest, `{"message":"get failed","type":"InvalidInput"}`
+			}
+		case http.MethodPost:
+			if api.failAliasUpdate {
+				api.failAliasUpdate = false
+				return http.StatusBadRequest, `{"message":"update failed","type":"InvalidInput"}`
+			}
+			api.aliases[key] = body.Collections
+			api.updatedAlias = true
+		case http.MethodDelete:
+			delete(api.aliases, key)
+		}
+		return aliasResponse(path[1], path[3])
+	case len(path) == 1 && req.Method == http.MethodPost:
+		if api.workspaces[name] {
+			return http.StatusConflict, alreadyExists
+		}
+		api.workspaces[name] = true
+		return http.StatusOK, fmt.Sprintf(`{"data":{"name":%q}}`, name)
+	case len(path) == 2 && req.Method == http.MethodGet:
+		if !api.workspaces[path[1]] || api.hidden[path[1]] {
+			delete(api.hidden, path[1])
+			return http.StatusNotFound, notFound
+		}
+		return http.StatusOK, fmt.Sprintf(`{"data":{"name":%q}}`, path[1])
+	case len(path) == 3 && req.Method == http.MethodPost:
+		var key = path[1] + "/" + name
+		if name == api.failCreate {
+			return http.StatusBadRequest, `{"message":"creation failed","type":"InvalidInput"}`
+		} else if api.collections[key] {
+			return http.StatusConflict, alreadyExists
+		}
+		api.collections[key] = true
+		return http.StatusOK, fmt.Sprintf(`{"data":{"name":%q,"workspace":%q,"status":"CREATED"}}`, name, path[1])
+	case len(path) == 4 && (req.Method == http.MethodGet || req.Method == http.MethodDelete):
+		var key = path[1] + "/" + path[3]
+		if !api.collections[key] || api.hidden[key] {
+			delete(api.hidden, key)
+			return http.StatusNotFound, notFound
+		}
+		var sources string
+		if api.integration != "" {
+			sources = fmt.Sprintf(`,"sources":[{"integration_name":%q,"s3":{"bucket":"bucket","prefixes":[],"object_count_total":1,"object_count_downloaded":1}}]`, api.integration)
+		}
+		if req.Method == http.MethodDelete {
+			delete(api.collections, key)
+		}
+		return http.StatusOK, fmt.Sprintf(`{"data":{"name":%q,"workspace":%q,"status":"READY","created_at":"2022-06-01T00:00:00Z"%s}}`, path[3], path[1], sources)
+	}
+	return http.StatusNotFound, notFound
+}
+
+func TestRocksetDriverApplyIdempotent(t *testing.T) {
+	var api = &fakeRocksetAPI{
+		workspaces:  make(map[string]bool),
+		collections: make(map[string]bool),
+		hidden:      make(map[string]bool),
+	}
+	var driver = &rocksetDriver{httpClient: mockHTTPClient(api.handle)}
+
+	endpointSpecJson, err := json.Marshal(config{ApiKey: "test-key"})
+	require.NoError(t, err)
+	var bindings []*pf.MaterializationSpec_Binding
+	for _, collectionName := range []string{"first", "second"} {
+		resourceSpecJson, err := json.Marshal(resource{Workspace: "testing", Collection: collectionName})
+		require.NoError(t, err)
+		bindings = append(bindings, &pf.MaterializationSpec_Binding{
+			ResourceSpecJson: resourceSpecJson,
+			ResourcePath:     []string{"testing", collectionName},
+			DeltaUpdates:     true,
+		})
+	}
+	var applyReq = pm.ApplyRequest{
+		Materialization: &pf.MaterializationSpec{
+			Materialization:  "test/idempotent",
+			EndpointSpecJson: endpointSpecJson,
+			Bindings:         bindings,
+		},
+		Version: "1",
+	}
+
+	// The first attempt creates the workspace and the first collection, and then fails to create
+	// the second collection.
+	api.failCreate = "second"
+	_, err = driver.ApplyUpsert(context.Background(), &applyReq)
+	require.Error(t, err)
+	require.Equal(t, map[string]bool{"testing": true}, api.workspaces)
+	require.Equal(t, map[string]bool{"testing/first": true}, api.collections)
+
+	// A retry creates only the missing collection.
+	api.failCreate = ""
+	response, err := driver.ApplyUpsert(context.Background(), &applyReq)
+	require.NoError(t, err)
+	require.Equal(t, "created second collection", response.ActionDescription)
+	require.Equal(t, map[string]bool{"testing/first": true, "testing/second": true}, api.collections)
+
+	// Resources which are created by someone else after they're found not to exist are treated
+	// as already existing, rather than as errors.
+	api.hidden = map[string]bool{"testing": true, "testing/second": true}
+	response, err = driver.ApplyUpsert(context.Background(), &applyReq)
+	require.NoError(t, err)
+	require.Equal(t, "", response.ActionDescription)
+	require.Empty(t, api.hidden)
+}
+
+func TestRocksetDriverApplyWithoutCreation(t *testing.T) {
+	var api = &fakeRocksetAPI{
+		workspaces:  make(map[string]bool),
+		collections: make(map[string]bool),
+		hidden:      make(map[string]bool),
+	}
+	var driver = &rocksetDriver{httpClient: mockHTTPClient(api.handle)}
+
+	var applyRequest = func(cfg config) *pm.ApplyRequest {
+		endpointSpecJson, err := json.Marshal(cfg)
+		require.NoError(t, err)
+		resourceSpecJson, err := json.Marshal(resource{Workspace: "testing", Collection: "widgets"})
+		require.NoError(t, err)
+		return &pm.ApplyRequest{
+			Materialization: &pf.MaterializationSpec{
+				Materialization:  "test/without-creation",
+				EndpointSpecJson: endpointSpecJson,
+				Bindings: []*pf.MaterializationSpec_Binding{{
+					ResourceSpecJson: resourceSpecJson,
+					ResourcePath:     []string{"testing", "widgets"},
+					DeltaUpdates:     true,
+				}},
+			},
+			Version: "1",
+		}
+	}
+	var disabled = false
+	var noWorkspaces = applyRequest(config{ApiKey: "test-key", AutoCreateWorkspace: &disabled})
+	var noCollections = applyRequest(config{ApiKey: "test-key", AutoCreateCollection: &disabled})
+
+	// A missing workspace isn't created when workspace creation is disabled.
+	var _, err = driver.ApplyUpsert(context.Background(), noWorkspaces)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "workspace `testing` does not exist and 'auto_create_workspace' is disabled")
+	require.Empty(t, api.workspaces)
+
+	// Nor is a missing collection when collection creation is disabled, although its workspace is.
+	_, err = driver.ApplyUpsert(context.Background(), noCollections)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "collection `widgets` does not exist in workspace `testing` and 'auto_create_collection' is disabled")
+	require.Equal(t, map[string]bool{"testing": true}, api.workspaces)
+	require.Empty(t, api.collections)
+
+	// Resources which already exist are used either way.
+	api.collections["testing/widgets"] = true
+	for _, req := range []*pm.ApplyRequest{noWorkspaces, noCollections} {
+		response, err := driver.ApplyUpsert(context.Background(), req)
+		require.NoError(t, err)
+		require.Equal(t, "", response.ActionDescription)
+	}
+
+	// Both are created by default.
+	require.True(t, (&config{}).autoCreateWorkspace())
+	require.True(t, (&config{}).autoCreateCollection())
+}
+
+func TestRocksetAliasRefresh(t *testing.T) {
+	var ctx = context.Background()
+	var api = &fakeRocksetAPI{
+		workspaces:  map[string]bool{"testing": true},
+		collections: map[string]bool{"testing/widgets_v1": true},
+		hidden:      make(map[string]bool),
+		aliases:     map[string][]string{"testing/widgets": {"testing.widgets_v1"}},
+		integration: "staging",
+	}
+	var driver = &rocksetDriver{httpClient: mockHTTPClient(api.handle)}
+	var cfg = config{
+		ApiKey:          "test-key",
+		BackfillStaging: &stagingConfig{Provider: stagingProviderS3, Region: "us-east-1", MinDocuments: 2},
+	}
+	client, err := driver.newClient(&cfg)
+	require.NoError(t, err)
+
+	// The collection of the binding is changed, while its alias is kept.
+	var res = resource{
+		Workspace:     "testing",
+		Collection:    "widgets_v2",
+		Alias:         "widgets",
+		StageBackfill: &stagingTarget{Integration: "staging", Bucket: "bucket", Prefix: "backfill"},
+	}
+	require.NoError(t, res.Validate())
+	created, err := ensureCollectionExists(ctx, client, &res, cfg.BackfillStaging, true)
+	require.NoError(t, err)
+	require.True(t, created)
+
+	var store = &mockObjectStore{objects: make(map[string]string)}
+	var b = NewBinding(&pf.MaterializationSpec_Binding{
+		FieldSelection: pf.FieldSelection{
+			Keys:   []string{"id"},
+			Values: []string{"name"},
+		},
+	}, &res)
+	b.stager = newBackfillStager(store, res.StageBackfill)
+	var txn = transactor{config: &cfg, client: client, bindings: []*binding{b}}
+
+	// The alias isn't swapped while the backfill is being staged to the new collection.
+	require.NoError(t, txn.awaitAllRocksetCollectionsReady(ctx))
+	require.NoError(t, b.stager.add(buildDocument(b, tuple.Tuple{"one"}, tuple.Tuple{"first"})))
+	require.NoError(t, b.stager.add(buildDocument(b, tuple.Tuple{"two"}, tuple.Tuple{"second"})))
+	require.NoError(t, txn.commitStaged(ctx, b))
+	require.Equal(t, []string{"testing.widgets_v1"}, api.aliases["testing/widgets"])
+
+	// A failure to update the alias once the backfill is ingested fails the transaction, and the
+	// alias continues to point at the previous collection, which is kept.
+	api.failAliasUpdate = true
+	require.NoError(t, b.stager.add(buildDocument(b, tuple.Tuple{"three"}, tuple.Tuple{"third"})))
+	require.Error(t, txn.commitStaged(ctx, b))
+	require.NotNil(t, b.stager)
+	require.Equal(t, []string{"testing.widgets_v1"}, api.aliases["testing/widgets"])
+	require.True(t, api.collections["testing/widgets_v1"])
+
+	// A failure to confirm that the alias was updated rolls it back to the previous collection,
+	// which is again kept.
+	api.failAliasConfirm = true
+	require.NoError(t, b.stager.add(buildDocument(b, tuple.Tuple{"four"}, tuple.Tuple{"fourth"})))
+	var confirmErr = txn.commitStaged(ctx, b)
+	require.Error(t, confirmErr)
+	require.Contains(t, confirmErr.Error(), "confirming the update of alias `widgets`")
+	require.NotNil(t, b.stager)
+	require.Equal(t, []string{"testing.widgets_v1"}, api.aliases["testing/widgets"])
+	require.True(t, api.collections["testing/widgets_v1"])
+
+	// Once the alias is swapped to the new collection the previous one is deleted, and the binding
+	// switches to the write API.
+	require.NoError(t, b.stager.add(buildDocument(b, tuple.Tuple{"five"}, tuple.Tuple{"fifth"})))
+	require.NoError(t, txn.commitStaged(ctx, b))
+	require.Nil(t, b.stager)
+	require.Equal(t, []string{"testing.widgets_v2"}, api.aliases["testing/widgets"])
+	require.Equal(t, map[string]bool{"testing/widgets_v2": true}, api.collections)
+
+	// Swapping again is a no-op, as it is when the connector restarts.
+	require.NoError(t, txn.awaitAllRocksetCollectionsReady(ctx))
+	require.Equal(t, []string{"testing.widgets_v2"}, api.aliases["testing/widgets"])
+
+	// An alias which doesn't exist yet is created once the collection is bulk loaded.
+	delete(api.aliases, "testing/widgets")
+	require.NoError(t, txn.awaitAllRocksetCollectionsReady(ctx))
+	require.Equal(t, []string{"testing.widgets_v2"}, api.aliases["testing/widgets"])
+
+	// Deleting the binding deletes its alias before its collection.
+	resourceSpecJson, err := json.Marshal(res)
+	require.NoError(t, err)
+	endpointSpecJson, err := json.Marshal(cfg)
+	require.NoError(t, err)
+	_, err = driver.ApplyDelete(ctx, &pm.ApplyRequest{
+		Materialization: &pf.MaterializationSpec{
+			Materialization:  "test/alias",
+			EndpointSpecJson: endpointSpecJson,
+			Bindings:         []*pf.MaterializationSpec_Binding{{ResourceSpecJson: resourceSpecJson}},
+		},
+		Version: "1",
+	})
+	require.NoError(t, err)
+	require.Empty(t, api.aliases)
+	require.Empty(t, api.collections)
+}
+
 func cleanup(config config, workspaceName string, collectionName string) {
 	ctx := context.Background()
 	client, err := rockset.NewClient(rockset.WithAPIKey(config.ApiKey))
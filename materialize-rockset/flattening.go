@@ -0,0 +1,187 @@
+package materialize_rockset
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	pf "github.com/estuary/flow/go/protocols/flow"
+)
+
+const (
+	// flattenModeFlatten replaces each field holding an object with a field for each of the
+	// object's properties, named by joining the names with the separator.
+	flattenModeFlatten = "flatten"
+	// flattenModeNest is the reverse of flattenModeFlatten, and replaces fields whose names contain
+	// the separator with nested objects.
+	flattenModeNest = "nest"
+)
+
+// defaultFlattenSeparator joins the names of flattened fields. Rockset parses dotted field names as
+// qualified names of nested fields, so queries of flattened fields look the same as nested ones.
+const defaultFlattenSeparator = "."
+
+// flattenSettings reshapes documents before they're written to Rockset, since some queries are
+// more efficient when documents are flattened. Arrays are values like any other, and are neither
+// flattened into indexed fields nor are the objects within them reshaped.
+type flattenSettings struct {
+	Mode      string `json:"mode" jsonschema:"title=Mode,description=Whether nested objects are flattened into fields with joined names ('flatten') or fields with joined names are nested into objects ('nest').,enum=flatten,enum=nest"`
+	Separator string `json:"separator,omitempty" jsonschema:"title=Separator,description=The separator between the names of nested fields.,default=."`
+	MaxDepth  int    `json:"maxDepth,omitempty" jsonschema:"title=Max Depth,description=The number of levels of nesting which are flattened or nested. Deeper objects or names are left as they are. Zero means that there's no limit."`
+}
+
+func (f *flattenSettings) Validate() error {
+	switch f.Mode {
+	case flattenModeFlatten, flattenModeNest:
+	default:
+		return fmt.Errorf("invalid 'mode' value %q: must be either %q or %q", f.Mode, flattenModeFlatten, flattenModeNest)
+	}
+	if f.MaxDepth < 0 {
+		return fmt.Errorf("invalid 'maxDepth' value: must not be negative")
+	}
+	return nil
+}
+
+func (f *flattenSettings) separator() string {
+	if f.Separator == "" {
+		return defaultFlattenSeparator
+	}
+	return f.Separator
+}
+
+// validateFlattenFields checks that flattening the documents of the collection can't produce a
+// field with the same name as one of its projections, which would happen if a projection's name
+// contained the separator.
+func validateFlattenFields(collection *pf.CollectionSpec, res *resource) error {
+	if res.Flattening == nil || res.Flattening.Mode != flattenModeFlatten {
+		return nil
+	}
+	var sep = res.Flattening.separator()
+	for _, projection := range collection.Projections {
+		if strings.Contains(projection.Field, sep) {
+			return fmt.Errorf("the field `%s` of collection '%s' contains the flattening separator %q of Rockset collection '%s', so it could collide with the flattened fields of its documents. Use a separator which isn't part of any field name",
+				projection.Field, collection.Collection, sep, res.Collection)
+		}
+	}
+	return nil
+}
+
+// reshapeDocument returns the document flattened or nested according to the binding's flattening
+// settings, or the document itself if it has none. The `_id` and sequence fields are always left
+// at the top level of the document, since they're used by Rockset and the connector.
+func (b *binding) reshapeDocument(doc map[string]interface{}) (map[string]interface{}, error) {
+	var f = b.res.Flattening
+	if f == nil {
+		return doc, nil
+	}
+	var reshaped = make(map[string]interface{}, len(doc))
+	var fields = make([]string, 0, len(doc))
+	for field, value := range doc {
+		if field == "_id" || field == b.res.SequenceField {
+			reshaped[field] = value
+		} else {
+			fields = append(fields, field)
+		}
+	}
+	// Fields are reshaped in order so that collisions are reported consistently.
+	sort.Strings(fields)
+
+	for _, field := range fields {
+		var err error
+		if f.Mode == flattenModeFlatten {
+			err = f.flattenInto(reshaped, field, doc[field], 0)
+		} else {
+			err = f.nestInto(reshaped, field, doc[field])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reshaping document of Rockset collection '%s': %w", b.rocksetCollection(), err)
+		}
+	}
+	return reshaped, nil
+}
+
+// flattenInto adds the field to the document if its value isn't an object, or if it's nested
+// deeper than the maximum depth. Otherwise each property of the object is flattened in turn.
+// Empty objects are kept as they are, since they'd otherwise disappear.
+func (f *flattenSettings) flattenInto(doc map[string]interface{}, field string, value interface{}, depth int) error {
+	if f.MaxDepth == 0 || depth < f.MaxDepth {
+		if obj, ok := asObject(value); ok && len(obj) > 0 {
+			for name, nested := range obj {
+				if err := f.flattenInto(doc, field+f.separator()+name, nested, depth+1); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	if _, ok := doc[field]; ok {
+		return fmt.Errorf("the flattened field `%s` collides with another field", field)
+	}
+	doc[field] = value
+	return nil
+}
+
+// nestInto adds the field to the document within nested objects, whose names are the parts of the
+// field's name between separators. Names with empty parts are left as they are. A field which is
+// already an object has the nested fields added to it.
+func (f *flattenSettings) nestInto(doc map[string]interface{}, field string, value interface{}) error {
+	var names []string
+	if f.MaxDepth == 0 {
+		names = strings.Split(field, f.separator())
+	} else {
+		names = strings.SplitN(field, f.separator(), f.MaxDepth+1)
+	}
+	for _, name := range names {
+		if name == "" {
+			names = []string{field}
+			break
+		}
+	}
+
+	var parent = doc
+	for i, name := range names[:len(names)-1] {
+		var existing, ok = parent[name]
+		if !ok {
+			var obj = make(map[string]interface{})
+			parent[name] = obj
+			parent = obj
+			continue
+		}
+		if obj, isMap := existing.(map[string]interface{}); isMap {
+			parent = obj
+		} else if raw, isObject := asObject(existing); isObject {
+			var obj = make(map[string]interface{}, len(raw))
+			for k, v := range raw {
+				obj[k] = v
+			}
+			parent[name] = obj
+			parent = obj
+		} else {
+			return fmt.Errorf("the field `%s` can't be nested within `%s`, which isn't an object",
+				field, strings.Join(names[:i+1], f.separator()))
+		}
+	}
+
+	var leaf = names[len(names)-1]
+	if _, ok := parent[leaf]; ok {
+		return fmt.Errorf("the nested field `%s` collides with another field", field)
+	}
+	parent[leaf] = value
+	return nil
+}
+
+// asObject returns the properties of a value which is an encoded JSON object. The values of the
+// properties are left encoded, so that they're written exactly as they were materialized.
+func asObject(value interface{}) (map[string]json.RawMessage, bool) {
+	var raw, ok = value.(json.RawMessage)
+	if !ok || !bytes.HasPrefix(bytes.TrimLeft(raw, " \t\r\n"), []byte("{")) {
+		return nil, false
+	}
+	var obj map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &obj); err != nil {
+		return nil, false
+	}
+	return obj, true
+}
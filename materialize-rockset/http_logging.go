@@ -0,0 +1,139 @@
+package materialize_rockset
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// defaultHttpLogMaxBodyBytes is the number of bytes of each body that's logged, if not configured.
+const defaultHttpLogMaxBodyBytes = 1024
+
+const redacted = "<redacted>"
+
+// loggingTransport is an http.RoundTripper which logs requests to the Rockset API and their
+// responses. The API key is redacted from everything that's logged, and bodies are truncated so
+// that large batches of documents don't flood the logs.
+type loggingTransport struct {
+	inner        http.RoundTripper
+	apiKey       string
+	maxBodyBytes int
+}
+
+// newLoggingHTTPClient returns a copy of the client (or of the default client, if nil) which logs
+// each request that's made with it.
+func newLoggingHTTPClient(client *http.Client, apiKey string, maxBodyBytes int) *http.Client {
+	var logging http.Client
+	if client != nil {
+		logging = *client
+	}
+	var inner = logging.Transport
+	if inner == nil {
+		inner = http.DefaultTransport
+	}
+	if maxBodyBytes == 0 {
+		maxBodyBytes = defaultHttpLogMaxBodyBytes
+	}
+	logging.Transport = &loggingTransport{inner: inner, apiKey: apiKey, maxBodyBytes: maxBodyBytes}
+	return &logging
+}
+
+func (t *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	var reqBody []byte
+	if req.Body != nil {
+		var err error
+		if reqBody, err = ioutil.ReadAll(req.Body); err != nil {
+			return nil, err
+		}
+		req.Body.Close()
+		req.Body = ioutil.NopCloser(bytes.NewReader(reqBody))
+	}
+
+	var start = time.Now()
+	var resp, err = t.inner.RoundTrip(req)
+	var logEntry = log.WithFields(log.Fields{
+		"method":       req.Method,
+		"url":          t.redact(req.URL.String()),
+		"requestBytes": len(reqBody),
+		"duration":     time.Since(start).String(),
+	})
+	if n, ok := countDocuments(reqBody); ok {
+		logEntry = logEntry.WithField("documents", n)
+	}
+	logEntry.WithFields(log.Fields{
+		"requestHeaders": t.redactHeaders(req.Header),
+		"requestBody":    t.truncate(reqBody),
+	}).Debug("rockset http request")
+
+	if err != nil {
+		logEntry.WithField("error", t.redact(err.Error())).Info("rockset http request failed")
+		return resp, err
+	}
+
+	var respBody []byte
+	if resp.Body != nil {
+		if respBody, err = ioutil.ReadAll(resp.Body); err != nil {
+			return nil, err
+		}
+		resp.Body.Close()
+		resp.Body = ioutil.NopCloser(bytes.NewReader(respBody))
+	}
+	logEntry = logEntry.WithFields(log.Fields{
+		"status":        resp.StatusCode,
+		"responseBytes": len(respBody),
+	})
+	logEntry.WithFields(log.Fields{
+		"responseHeaders": t.redactHeaders(resp.Header),
+		"responseBody":    t.truncate(respBody),
+	}).Debug("rockset http response")
+	logEntry.Info("rockset http request completed")
+
+	return resp, nil
+}
+
+// redact replaces any occurrence of the API key in the string.
+func (t *loggingTransport) redact(s string) string {
+	if t.apiKey == "" {
+		return s
+	}
+	return strings.ReplaceAll(s, t.apiKey, redacted)
+}
+
+func (t *loggingTransport) redactHeaders(headers http.Header) map[string]string {
+	var out = make(map[string]string, len(headers))
+	for name, values := range headers {
+		if strings.EqualFold(name, "Authorization") {
+			out[name] = redacted
+		} else {
+			out[name] = t.redact(strings.Join(values, ", "))
+		}
+	}
+	return out
+}
+
+// truncate returns the redacted body, truncated to the maximum logged size.
+func (t *loggingTransport) truncate(body []byte) string {
+	var s = t.redact(string(body))
+	if len(s) > t.maxBodyBytes {
+		return fmt.Sprintf("%s... (%d more bytes)", s[:t.maxBodyBytes], len(s)-t.maxBodyBytes)
+	}
+	return s
+}
+
+// countDocuments returns the number of documents in the body of a request to add or patch
+// documents, or false if it isn't such a request.
+func countDocuments(body []byte) (int, bool) {
+	var parsed struct {
+		Data []json.RawMessage `json:"data"`
+	}
+	if len(body) == 0 || json.Unmarshal(body, &parsed) != nil || parsed.Data == nil {
+		return 0, false
+	}
+	return len(parsed.Data), true
+}
@@ -0,0 +1,127 @@
+package materialize_rockset
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// sequencer generates the sequence numbers which are injected into documents when a binding's
+// `sequenceField` isn't a materialized field. Sequence numbers are derived from the wall clock so
+// that they keep increasing across restarts of the connector, and are strictly increasing within
+// a single run even if the clock doesn't advance or steps backwards.
+type sequencer struct {
+	mu   sync.Mutex
+	last int64
+	now  func() time.Time
+}
+
+func newSequencer() *sequencer {
+	return &sequencer{now: time.Now}
+}
+
+// next returns the next sequence number.
+func (s *sequencer) next() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var seq = s.now().UnixNano()
+	if seq <= s.last {
+		seq = s.last + 1
+	}
+	s.last = seq
+	return seq
+}
+
+// latestByID returns the documents with only the latest version of each `_id` retained, in the
+// order in which each `_id` first appeared. Rockset doesn't guarantee which version of a document
+// is kept when a single request holds several with the same `_id`, so this ensures that the last
+// one wins. If `sequenceField` is non-empty, then the version with the greatest sequence value is
+// the latest instead, and versions whose sequence values can't be compared fall back to the order
+// in which they were stored.
+func latestByID(docs []interface{}, sequenceField string) []interface{} {
+	var indices = make(map[string]int, len(docs))
+	var result = make([]interface{}, 0, len(docs))
+	for _, doc := range docs {
+		var id, _ = doc.(map[string]interface{})["_id"].(string)
+		var idx, seen = indices[id]
+		if id == "" {
+			// Documents of keyless collections all share an empty `_id`, and aren't versions of one another.
+			result = append(result, doc)
+			continue
+		} else if !seen {
+			indices[id] = len(result)
+			result = append(result, doc)
+			continue
+		}
+		if sequenceField != "" {
+			var prev = result[idx].(map[string]interface{})[sequenceField]
+			var next = doc.(map[string]interface{})[sequenceField]
+			if cmp, ok := compareSequence(prev, next); ok && cmp > 0 {
+				continue // The stored document is older than the one we've already seen.
+			}
+		}
+		result[idx] = doc
+	}
+	return result
+}
+
+// compareSequence compares two sequence values, returning -1, 0, or 1 if a is less than, equal
+// to, or greater than b. Numbers are compared with numbers and strings with strings, and the
+// returned bool is false if the values aren't comparable.
+func compareSequence(a, b interface{}) (int, bool) {
+	if as, ok := a.(string); ok {
+		if bs, ok := b.(string); ok {
+			switch {
+			case as < bs:
+				return -1, true
+			case as > bs:
+				return 1, true
+			}
+			return 0, true
+		}
+		return 0, false
+	}
+	if ai, ok := a.(int64); ok {
+		if bi, ok := b.(int64); ok {
+			switch {
+			case ai < bi:
+				return -1, true
+			case ai > bi:
+				return 1, true
+			}
+			return 0, true
+		}
+	}
+	var af, aok = sequenceNumber(a)
+	var bf, bok = sequenceNumber(b)
+	if !aok || !bok {
+		return 0, false
+	}
+	switch {
+	case af < bf:
+		return -1, true
+	case af > bf:
+		return 1, true
+	}
+	return 0, true
+}
+
+// sequenceNumber converts a numeric sequence value to a float64 for comparison. Sequences of
+// mixed numeric types should be rare, so the loss of precision for large integers is acceptable.
+func sequenceNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float64:
+		return n, true
+	case json.RawMessage:
+		var f float64
+		if err := json.Unmarshal(n, &f); err != nil {
+			return 0, false
+		}
+		return f, true
+	}
+	return 0, false
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"math/rand"
+	"net/http"
 	"time"
 
 	"github.com/relvacode/iso8601"
@@ -12,17 +13,90 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
-// Only creates the named collection if it does not already exist.
-func ensureWorkspaceExists(ctx context.Context, client *rockset.RockClient, workspace string) (*rtypes.Workspace, error) {
+// accessError is returned by checkAccess when the Rockset API rejects the API key, either
+// because it isn't valid or because it lacks permission to access a workspace.
+type accessError struct {
+	StatusCode int
+	// Workspace is the workspace being accessed, or empty if the API key was rejected outright.
+	Workspace string
+	Err       error
+}
+
+func (e *accessError) Error() string {
+	switch {
+	case e.StatusCode == http.StatusUnauthorized:
+		return fmt.Sprintf("the Rockset API key was rejected as invalid (HTTP %d): %v", e.StatusCode, e.Err)
+	case e.Workspace != "":
+		return fmt.Sprintf("the Rockset API key is not permitted to access workspace `%s` (HTTP %d): %v", e.Workspace, e.StatusCode, e.Err)
+	default:
+		return fmt.Sprintf("the Rockset API key is not permitted to list workspaces (HTTP %d): %v", e.StatusCode, e.Err)
+	}
+}
+
+func (e *accessError) Unwrap() error {
+	return e.Err
+}
+
+// checkAccess verifies that the API key is accepted by Rockset and that it is permitted to
+// access each of the named workspaces, without writing anything. A workspace which doesn't
+// exist yet is not an error, since it will be created when the materialization is applied.
+func checkAccess(ctx context.Context, client *rockset.RockClient, workspaces []string) error {
+	if _, resp, err := client.WorkspacesApi.ListWorkspaces(ctx).Execute(); err != nil {
+		return newAccessError(resp, "", err)
+	}
+
+	var checked = make(map[string]bool)
+	for _, workspace := range workspaces {
+		if checked[workspace] {
+			continue
+		}
+		checked[workspace] = true
+
+		var _, resp, err = client.WorkspacesApi.GetWorkspace(ctx, workspace).Execute()
+		if resp != nil && resp.StatusCode == http.StatusNotFound {
+			continue
+		} else if err != nil {
+			return newAccessError(resp, workspace, err)
+		}
+	}
+	return nil
+}
+
+func newAccessError(resp *http.Response, workspace string, err error) error {
+	if resp != nil && (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) {
+		return &accessError{StatusCode: resp.StatusCode, Workspace: workspace, Err: rockset.NewError(err)}
+	}
+	if workspace != "" {
+		return fmt.Errorf("failed to fetch workspace `%s`: %w", workspace, rockset.NewError(err))
+	}
+	return fmt.Errorf("failed to list workspaces: %w", rockset.NewError(err))
+}
+
+// Only creates the named workspace if it does not already exist. The returned workspace is nil
+// unless it was actually created. Creation fails if the workspace was created by someone else since
+// it was fetched, such as by a concurrent apply or by a prior attempt whose response was lost, which
+// isn't an error as long as the workspace now exists. If `create` is false then a workspace which
+// doesn't exist is an error instead.
+func ensureWorkspaceExists(ctx context.Context, client *rockset.RockClient, workspace string, create bool) (*rtypes.Workspace, error) {
 	if res, err := getWorkspace(ctx, client, workspace); err != nil {
 		return nil, err
 	} else if res != nil {
 		// This workspace exists within Rockset already.
 		return nil, nil
-	} else {
-		// This collection does not exist within Rockset yet, so we should create it.
-		return createWorkspace(ctx, client, workspace)
+	} else if !create {
+		return nil, fmt.Errorf("workspace `%s` does not exist and 'auto_create_workspace' is disabled", workspace)
 	}
+
+	// This workspace does not exist within Rockset yet, so we should create it.
+	created, err := createWorkspace(ctx, client, workspace)
+	if err != nil {
+		if res, getErr := getWorkspace(ctx, client, workspace); getErr == nil && res != nil {
+			log.WithField("workspace", workspace).Info("Rockset workspace already exists")
+			return nil, nil
+		}
+		return nil, err
+	}
+	return created, nil
 }
 
 func getWorkspace(ctx context.Context, client *rockset.RockClient, workspace string) (*rtypes.Workspace, error) {
@@ -46,25 +120,43 @@ func createWorkspace(ctx context.Context, client *rockset.RockClient, workspaceN
 }
 
 // Only creates the named collection if it does not already exist. The returned boolean indicates whether it was
-// actually created. It will be false if the collection already exists or if an error is returned.
-func ensureCollectionExists(ctx context.Context, client *rockset.RockClient, resource *resource) (bool, error) {
+// actually created. It will be false if the collection already exists or if an error is returned. As with
+// workspaces, a collection which was created by someone else since it was fetched is treated as already existing.
+// The `staging` config is required if the resource has a `stageBackfill`. If `create` is false then
+// a collection which doesn't exist is an error instead.
+func ensureCollectionExists(ctx context.Context, client *rockset.RockClient, resource *resource, staging *stagingConfig, create bool) (bool, error) {
 	if existingCollection, err := getCollection(ctx, client, resource.Workspace, resource.Collection); err != nil {
 		return false, err
 	} else if existingCollection != nil {
-		// This collection exists within Rockset already, so just validate that
-		// it has the required integration.  Collection definitions in Rockset
-		// are immutable, so there's no way to add an integration to an existing
-		// collection.  Thus, if the integration named in the resource
-		// configuration does not exist, it must be returned as an error.
-		if resource.InitializeFromS3 != nil && GetS3IntegrationSource(existingCollection, resource.InitializeFromS3.Integration) == nil {
-			return false, fmt.Errorf("expected collection '%s' to have a source with an integration named '%s', but no such integration source exists", resource.Collection, resource.InitializeFromS3.Integration)
-		}
-		return false, nil
-	} else {
-		// This collection does not exist within Rockset yet, so we should create it.
-		var err = createCollection(ctx, client, resource)
-		return err == nil, err
+		return false, validateExistingCollection(existingCollection, resource)
+	} else if !create {
+		return false, fmt.Errorf("collection `%s` does not exist in workspace `%s` and 'auto_create_collection' is disabled", resource.Collection, resource.Workspace)
+	}
+
+	// This collection does not exist within Rockset yet, so we should create it.
+	var err = createCollection(ctx, client, resource, staging)
+	if err != nil {
+		if existingCollection, getErr := getCollection(ctx, client, resource.Workspace, resource.Collection); getErr == nil && existingCollection != nil {
+			log.WithField("collection", resource.Collection).Info("Rockset collection already exists")
+			return false, validateExistingCollection(existingCollection, resource)
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// validateExistingCollection checks that a collection which exists within Rockset already has the
+// required integration. Collection definitions in Rockset are immutable, so there's no way to add
+// an integration to an existing collection. Thus, if the integration named in the resource
+// configuration does not exist, it must be returned as an error.
+func validateExistingCollection(existingCollection *rtypes.Collection, resource *resource) error {
+	if resource.InitializeFromS3 != nil && GetS3IntegrationSource(existingCollection, resource.InitializeFromS3.Integration) == nil {
+		return fmt.Errorf("expected collection '%s' to have a source with an integration named '%s', but no such integration source exists", resource.Collection, resource.InitializeFromS3.Integration)
 	}
+	if resource.StageBackfill != nil && !hasIntegrationSource(existingCollection, resource.StageBackfill.Integration) {
+		return fmt.Errorf("expected collection '%s' to have a source with an integration named '%s', but no such integration source exists", resource.Collection, resource.StageBackfill.Integration)
+	}
+	return nil
 }
 
 func GetS3IntegrationSource(collection *rtypes.Collection, integrationName string) *rtypes.SourceS3 {
@@ -76,6 +168,39 @@ func GetS3IntegrationSource(collection *rtypes.Collection, integrationName strin
 	return nil
 }
 
+// hasIntegrationSource returns whether the collection has an S3 or GCS source with the given integration.
+func hasIntegrationSource(collection *rtypes.Collection, integrationName string) bool {
+	var _, _, ok = getIntegrationObjectCounts(collection, integrationName)
+	return ok
+}
+
+// getIntegrationObjectCounts returns the total number of objects in the bucket of the collection's S3 or GCS source
+// with the given integration, and the number of them which have been downloaded. The returned boolean is false if
+// there's no such source.
+func getIntegrationObjectCounts(collection *rtypes.Collection, integrationName string) (total int64, downloaded int64, ok bool) {
+	for _, source := range collection.Sources {
+		if source.IntegrationName != integrationName {
+			continue
+		}
+		var totalPtr, downloadedPtr *int64
+		if source.S3 != nil {
+			totalPtr, downloadedPtr = source.S3.ObjectCountTotal, source.S3.ObjectCountDownloaded
+		} else if source.Gcs != nil {
+			totalPtr, downloadedPtr = source.Gcs.ObjectCountTotal, source.Gcs.ObjectCountDownloaded
+		} else {
+			continue
+		}
+		if totalPtr != nil {
+			total = *totalPtr
+		}
+		if downloadedPtr != nil {
+			downloaded = *downloadedPtr
+		}
+		return total, downloaded, true
+	}
+	return 0, 0, false
+}
+
 func getCollection(ctx context.Context, client *rockset.RockClient, workspace string, collection string) (*rtypes.Collection, error) {
 	res, err := client.GetCollection(ctx, workspace, collection)
 	if se, ok := err.(rockset.Error); ok && se.IsNotFoundError() {
@@ -88,7 +213,7 @@ func getCollection(ctx context.Context, client *rockset.RockClient, workspace st
 	}
 }
 
-func createCollection(ctx context.Context, client *rockset.RockClient, resource *resource) error {
+func createCollection(ctx context.Context, client *rockset.RockClient, resource *resource, staging *stagingConfig) error {
 	collection := rtypes.CreateCollectionRequest{Name: resource.Collection}
 	if resource.InitializeFromS3 != nil {
 		var integration = resource.InitializeFromS3
@@ -102,6 +227,12 @@ func createCollection(ctx context.Context, client *rockset.RockClient, resource
 			},
 		}}
 	}
+	if resource.StageBackfill != nil {
+		if staging == nil {
+			return fmt.Errorf("collection `%s` has a 'stageBackfill', which requires the endpoint's 'backfill_staging' config", resource.Collection)
+		}
+		collection.Sources = []rtypes.Source{resource.StageBackfill.source(staging)}
+	}
 	if resource.AdvancedCollectionSettings != nil {
 		var settings = resource.AdvancedCollectionSettings
 		// These are all nullable on both the AdvancedCollectionSettings and the request
@@ -119,6 +250,13 @@ func createCollection(ctx context.Context, client *rockset.RockClient, resource
 				TimeZone: settings.EventTimeInfo.TimeZone,
 			}
 		}
+
+		for _, field := range settings.DropFields {
+			collection.FieldMappings = append(collection.FieldMappings, dropFieldMapping(field))
+		}
+		for _, schema := range settings.FieldSchemas {
+			collection.FieldSchemas = append(collection.FieldSchemas, schema.ToRocksetFieldSchema())
+		}
 	}
 	log.WithField("request", collection).Info("Will create a new Rockset collection")
 	_, err := client.CreateCollection(ctx, resource.Workspace, resource.Collection, &collection)
@@ -128,6 +266,19 @@ func createCollection(ctx context.Context, client *rockset.RockClient, resource
 	return nil
 }
 
+// dropFieldMapping returns a field mapping which drops the named field from each document as it's
+// ingested.
+func dropFieldMapping(field string) rtypes.FieldMappingV2 {
+	var name, isDrop = "drop_" + field, true
+	return rtypes.FieldMappingV2{
+		Name: &name,
+		InputFields: []rtypes.InputField{{
+			FieldName: &field,
+			IsDrop:    &isDrop,
+		}},
+	}
+}
+
 func trimToNill(s string) *string {
 	if s == "" {
 		return nil
@@ -174,12 +325,12 @@ func awaitCollectionReady(ctx context.Context, client *rockset.RockClient, works
 		// status may be set to READY before all of the source files have been processed. This condition is a guard
 		// against ingesting data out of order in that specific case.
 		if integration != "" {
-			var source = GetS3IntegrationSource(collection, integration)
-			if source == nil {
+			var total, downloaded, ok = getIntegrationObjectCounts(collection, integration)
+			if !ok {
 				return fmt.Errorf("expected collection '%s' to have a compatible cloud-storage integration named '%s', but no such source exists", collectionName, integration)
 			}
-			ready = ready && *source.ObjectCountTotal == *source.ObjectCountDownloaded
-			bulkIngestStalled = *source.ObjectCountTotal > 0 && *source.ObjectCountDownloaded == 0 && collectionAge > time.Minute*5
+			ready = ready && total == downloaded
+			bulkIngestStalled = total > 0 && downloaded == 0 && collectionAge > time.Minute*5
 		}
 
 		var logEntry = log.WithFields(log.Fields{
@@ -0,0 +1,283 @@
+package materialize_rockset
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"cloud.google.com/go/storage"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	rtypes "github.com/rockset/rockset-go-client/openapi"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/option"
+)
+
+const (
+	// stagingProviderS3 stages documents to an S3 bucket, which Rockset ingests using an S3
+	// integration.
+	stagingProviderS3 = "s3"
+	// stagingProviderGCS stages documents to a Google Cloud Storage bucket, which Rockset ingests
+	// using a GCS integration.
+	stagingProviderGCS = "gcs"
+)
+
+// defaultStagingMinDocuments is the default number of documents that a transaction must store for
+// a binding in order for it to continue staging its backfill.
+const defaultStagingMinDocuments = 10000
+
+// stagingConfig configures the cloud storage to which backfilled documents are staged. It's part of
+// the endpoint config, since the same credentials are used by each binding which stages its
+// backfill.
+type stagingConfig struct {
+	Provider           string `json:"provider" jsonschema:"title=Provider,description=The cloud storage provider to which documents are staged.,enum=s3,enum=gcs"`
+	AWSAccessKeyID     string `json:"aws_access_key_id,omitempty" jsonschema:"title=AWS Access Key ID,description=AWS credential used to write to the S3 bucket. Required for the 's3' provider."`
+	AWSSecretAccessKey string `json:"aws_secret_access_key,omitempty" jsonschema:"title=AWS Secret Access Key,description=AWS credential used to write to the S3 bucket. Required for the 's3' provider." jsonschema_extras:"secret=true"`
+	Region             string `json:"region,omitempty" jsonschema:"title=AWS Region,description=The AWS region in which the S3 bucket resides. Required for the 's3' provider."`
+	GCPCredentials     string `json:"gcp_credentials,omitempty" jsonschema:"title=GCP Service Account JSON,description=Google Cloud service account JSON used to write to the GCS bucket. Required for the 'gcs' provider." jsonschema_extras:"secret=true,multiline=true"`
+	// MinDocuments is how the end of a backfill is detected. See transactor.commitStaged.
+	MinDocuments int `json:"min_documents,omitempty" jsonschema:"title=Minimum Documents,description=Bindings stop staging after the first transaction which stores fewer than this many of their documents.,default=10000"`
+}
+
+func (c *stagingConfig) Validate() error {
+	var requiredProperties [][]string
+	switch c.Provider {
+	case stagingProviderS3:
+		requiredProperties = [][]string{
+			{"aws_access_key_id", c.AWSAccessKeyID},
+			{"aws_secret_access_key", c.AWSSecretAccessKey},
+			{"region", c.Region},
+		}
+	case stagingProviderGCS:
+		requiredProperties = [][]string{
+			{"gcp_credentials", c.GCPCredentials},
+		}
+	default:
+		return fmt.Errorf("invalid 'provider' value %q: must be either %q or %q", c.Provider, stagingProviderS3, stagingProviderGCS)
+	}
+	for _, req := range requiredProperties {
+		if req[1] == "" {
+			return fmt.Errorf("missing '%s'", req[0])
+		}
+	}
+	if c.MinDocuments < 0 {
+		return fmt.Errorf("min_documents must not be negative")
+	}
+	return nil
+}
+
+func (c *stagingConfig) minDocuments() int {
+	if c.MinDocuments == 0 {
+		return defaultStagingMinDocuments
+	}
+	return c.MinDocuments
+}
+
+// stagingTarget is the part of a binding's resource which configures where its backfill is staged.
+type stagingTarget struct {
+	Integration string `json:"integration" jsonschema:"title=Integration Name,description=The name of the S3 or GCS integration that was previously created in the Rockset UI"`
+	Bucket      string `json:"bucket" jsonschema:"title=Bucket,description=The name of the bucket to which documents are staged."`
+	Prefix      string `json:"prefix" jsonschema:"title=Prefix,description=Prefix of the staged documents within the bucket. It must not be used by anything else since Rockset ingests every object under it."`
+}
+
+func (t *stagingTarget) Validate() error {
+	var requiredProperties = [][]string{
+		{"integration", t.Integration},
+		{"bucket", t.Bucket},
+		{"prefix", t.Prefix},
+	}
+	for _, req := range requiredProperties {
+		if req[1] == "" {
+			return fmt.Errorf("missing '%s'", req[0])
+		}
+	}
+	return nil
+}
+
+// source returns the Rockset collection source which ingests the staged documents.
+func (t *stagingTarget) source(cfg *stagingConfig) rtypes.Source {
+	var source = rtypes.Source{IntegrationName: t.Integration}
+	if cfg.Provider == stagingProviderGCS {
+		source.Gcs = &rtypes.SourceGcs{
+			Bucket: &t.Bucket,
+			Prefix: &t.Prefix,
+		}
+	} else {
+		source.S3 = &rtypes.SourceS3{
+			Bucket: t.Bucket,
+			Region: trimToNill(cfg.Region),
+			Prefix: &t.Prefix,
+		}
+	}
+	return source
+}
+
+// objectStore is the interface of the cloud storage to which documents are staged.
+type objectStore interface {
+	putObject(ctx context.Context, bucket, key string, body io.Reader) error
+}
+
+// newObjectStore returns an objectStore for the configured provider.
+func newObjectStore(ctx context.Context, cfg *stagingConfig) (objectStore, error) {
+	if cfg.Provider == stagingProviderGCS {
+		var client, err = storage.NewClient(ctx, option.WithCredentialsJSON([]byte(cfg.GCPCredentials)))
+		if err != nil {
+			return nil, fmt.Errorf("creating cloud storage client: %w", err)
+		}
+		return &gcsObjectStore{client: client}, nil
+	}
+
+	var creds = credentials.NewStaticCredentials(cfg.AWSAccessKeyID, cfg.AWSSecretAccessKey, "")
+	awsSession, err := session.NewSession(aws.NewConfig().WithCredentials(creds).WithRegion(cfg.Region))
+	if err != nil {
+		return nil, fmt.Errorf("creating aws session: %w", err)
+	}
+	return &s3ObjectStore{uploader: s3manager.NewUploader(awsSession)}, nil
+}
+
+type s3ObjectStore struct {
+	uploader *s3manager.Uploader
+}
+
+func (s *s3ObjectStore) putObject(ctx context.Context, bucket, key string, body io.Reader) error {
+	_, err := s.uploader.UploadWithContext(ctx, &s3manager.UploadInput{
+		Bucket:      &bucket,
+		Key:         &key,
+		ContentType: aws.String("application/json"),
+		Body:        body,
+	})
+	return err
+}
+
+type gcsObjectStore struct {
+	client *storage.Client
+}
+
+func (s *gcsObjectStore) putObject(ctx context.Context, bucket, key string, body io.Reader) error {
+	var w = s.client.Bucket(bucket).Object(key).NewWriter(ctx)
+	w.ContentType = "application/json"
+	if _, err := io.Copy(w, body); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// backfillStager stages the documents of a binding to cloud storage while its backfill is in
+// progress. Documents are buffered in a local file as newline-delimited JSON, which is uploaded
+// as a new object under the target prefix on each commit, and Rockset then ingests it through the
+// collection's integration.
+type backfillStager struct {
+	store  objectStore
+	target *stagingTarget
+	// runID distinguishes the objects uploaded by this instance of the connector from those of
+	// previous instances, and objects is the number it has uploaded.
+	runID   string
+	objects int
+
+	file  *os.File
+	buf   *bufio.Writer
+	count int
+}
+
+func newBackfillStager(store objectStore, target *stagingTarget) *backfillStager {
+	return &backfillStager{
+		store:  store,
+		target: target,
+		runID:  RandString(8),
+	}
+}
+
+// add stages a document.
+func (s *backfillStager) add(doc map[string]interface{}) error {
+	if s.file == nil {
+		var file, err = ioutil.TempFile("", "rockset-staging-*.json")
+		if err != nil {
+			return fmt.Errorf("creating staging file: %w", err)
+		}
+		s.file = file
+		s.buf = bufio.NewWriter(file)
+	}
+	var encoded, err = json.Marshal(doc)
+	if err != nil {
+		return fmt.Errorf("encoding staged document: %w", err)
+	}
+	if _, err := s.buf.Write(append(encoded, '\n')); err != nil {
+		return fmt.Errorf("writing staging file: %w", err)
+	}
+	s.count++
+	return nil
+}
+
+// upload uploads the documents which have been staged since the last upload, if there are any,
+// and returns the number of them.
+func (s *backfillStager) upload(ctx context.Context) (int, error) {
+	if s.count == 0 {
+		return 0, nil
+	}
+	defer s.reset()
+
+	if err := s.buf.Flush(); err != nil {
+		return 0, fmt.Errorf("writing staging file: %w", err)
+	} else if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("seeking staging file: %w", err)
+	}
+
+	var key = s.target.Prefix
+	if !strings.HasSuffix(key, "/") {
+		key += "/"
+	}
+	key += fmt.Sprintf("%s-%06d.json", s.runID, s.objects)
+	if err := s.store.putObject(ctx, s.target.Bucket, key, s.file); err != nil {
+		return 0, fmt.Errorf("uploading staged documents to '%s/%s': %w", s.target.Bucket, key, err)
+	}
+	s.objects++
+
+	log.WithFields(log.Fields{
+		"bucket":     s.target.Bucket,
+		"key":        key,
+		"nDocuments": s.count,
+	}).Debug("uploaded staged documents")
+	return s.count, nil
+}
+
+// reset discards the local staging file.
+func (s *backfillStager) reset() {
+	if s.file != nil {
+		s.file.Close()
+		os.Remove(s.file.Name())
+	}
+	s.file, s.buf, s.count = nil, nil, 0
+}
+
+// driverCheckpoint is the driver checkpoint of the connector, which records the bindings that have
+// finished staging their backfills.
+type driverCheckpoint struct {
+	// StagedBackfills holds the "workspace/collection" of each binding whose staged backfill has
+	// been completely ingested by Rockset, and which now writes using the API.
+	StagedBackfills []string `json:"stagedBackfills,omitempty"`
+}
+
+func stagingKey(res *resource) string {
+	return res.Workspace + "/" + res.Collection
+}
+
+// buildDriverCheckpoint returns the driver checkpoint for the current state of the bindings.
+func buildDriverCheckpoint(bindings []*binding) (json.RawMessage, error) {
+	var cp driverCheckpoint
+	for _, b := range bindings {
+		if b.res.StageBackfill != nil && b.stager == nil {
+			cp.StagedBackfills = append(cp.StagedBackfills, stagingKey(b.res))
+		}
+	}
+	sort.Strings(cp.StagedBackfills)
+	return json.Marshal(cp)
+}
@@ -5,12 +5,15 @@ import (
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/estuary/flow/go/protocols/fdb/tuple"
 	pf "github.com/estuary/flow/go/protocols/flow"
 	pm "github.com/estuary/flow/go/protocols/materialize"
 	rockset "github.com/rockset/rockset-go-client"
+	rtypes "github.com/rockset/rockset-go-client/openapi"
 	"github.com/sirupsen/logrus"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/sync/errgroup"
@@ -27,13 +30,36 @@ type binding struct {
 	// User-facing configuration settings for this binding.
 	res       *resource
 	addDocsCh chan<- map[string]interface{}
+	// injectSequence is true if the resource's `sequenceField` isn't a materialized field, and so
+	// must be added to each document.
+	injectSequence bool
+	// stager is non-nil while the binding's backfill is being staged to cloud storage, rather than
+	// written using the API.
+	stager *backfillStager
+	// lastDoc is the final document written using the API during the current transaction.
+	lastDoc map[string]interface{}
+	// deletes are the `_id`s of the documents which are deleted by the current transaction,
+	// according to the binding's change indicator.
+	deletes []string
+	// missingChanges counts the documents of the current transaction which didn't have a
+	// recognized change indicator.
+	missingChanges int
 }
 
 func NewBinding(spec *pf.MaterializationSpec_Binding, res *resource) *binding {
-	return &binding{
+	var b = &binding{
 		spec: spec,
 		res:  res,
 	}
+	if res.SequenceField != "" {
+		b.injectSequence = true
+		for _, field := range spec.FieldSelection.AllFields() {
+			if field == res.SequenceField {
+				b.injectSequence = false
+			}
+		}
+	}
+	return b
 }
 
 func (b *binding) rocksetWorkspace() string {
@@ -49,12 +75,18 @@ type transactor struct {
 	client   *rockset.RockClient
 	bindings []*binding
 	errGroup *errgroup.Group
+	// sequencer generates the values of sequence fields which are injected into documents.
+	sequencer *sequencer
+	// unverified holds a marker for each collection written by the committed transaction, which
+	// must be queryable before the transaction is acknowledged if its documents are verified.
+	unverified []*ingestMarker
 }
 
 // awaitAllRocksetCollectionsReady will block until all the Rockset collections named in the bindings
 // are in `READY` status and have completed any pending bulk ingestions. Specifically, this waits until the
 // number of objects in the bucket (as reported by rockset) and the number of successfully imported objects
-// is the same.
+// is the same. The aliases of bindings which aren't staging a backfill are then swapped to their collections,
+// which have finished bulk loading.
 func (t *transactor) awaitAllRocksetCollectionsReady(ctx context.Context) error {
 	group, ctx := errgroup.WithContext(ctx)
 	for _, b := range t.bindings {
@@ -64,6 +96,8 @@ func (t *transactor) awaitAllRocksetCollectionsReady(ctx context.Context) error
 			var integration = ""
 			if binding.res.InitializeFromS3 != nil {
 				integration = binding.res.InitializeFromS3.Integration
+			} else if binding.res.StageBackfill != nil {
+				integration = binding.res.StageBackfill.Integration
 			}
 			var err = awaitCollectionReady(
 				ctx,
@@ -75,6 +109,9 @@ func (t *transactor) awaitAllRocksetCollectionsReady(ctx context.Context) error
 			if err != nil {
 				return fmt.Errorf("awaiting readiness of rockset collection '%s': %w", binding.res.Collection, err)
 			}
+			if binding.res.Alias != "" && binding.stager == nil {
+				return swapAlias(ctx, t.client, binding.res)
+			}
 			return nil
 		})
 	}
@@ -90,7 +127,17 @@ func (t *transactor) Load(it *pm.LoadIterator, priorCommittedCh <-chan struct{},
 func (t *transactor) Prepare(ctx context.Context, msg pm.TransactionRequest_Prepare) (pf.DriverCheckpoint, error) {
 	// There's nothing in particular to be done here, but what we're _not_ doing is notable.  We return an empty driver
 	// checkpoint here, which may clear out a previous driver checkpoint from the materialize-s3-parquet connector, if
-	// the user had used that to backfill data.
+	// the user had used that to backfill data. Unless there are bindings which stage their backfills, in which case
+	// the checkpoint records the ones that have finished.
+	for _, b := range t.bindings {
+		if b.res.StageBackfill != nil {
+			var cp, err = buildDriverCheckpoint(t.bindings)
+			if err != nil {
+				return pf.DriverCheckpoint{}, fmt.Errorf("creating checkpoint json: %w", err)
+			}
+			return pf.DriverCheckpoint{DriverCheckpointJson: cp}, nil
+		}
+	}
 	return pf.DriverCheckpoint{}, nil
 }
 
@@ -105,6 +152,32 @@ func (t *transactor) Store(it *pm.StoreIterator) error {
 
 	for it.Next() {
 		var b *binding = t.bindings[it.Binding]
+		var doc = buildDocument(b, it.Key, it.Values)
+		var change, err = b.changeType(doc)
+		if err != nil {
+			return err
+		} else if change == "" {
+			continue
+		} else if change == changeDelete {
+			// Deleted documents aren't staged, since staged backfills only add documents.
+			if b.stager == nil {
+				b.deletes = append(b.deletes, doc["_id"].(string))
+			}
+			continue
+		}
+		if doc, err = b.reshapeDocument(doc); err != nil {
+			return err
+		}
+
+		if b.stager != nil {
+			if b.injectSequence {
+				doc[b.res.SequenceField] = t.sequencer.next()
+			}
+			if err := b.stager.add(doc); err != nil {
+				return err
+			}
+			continue
+		}
 		// Lazily initialize the goroutine that sends the documents to rockset.
 		if b.addDocsCh == nil {
 			var addDocsCh = make(chan map[string]interface{}, storeBatchSize*2)
@@ -118,7 +191,9 @@ func (t *transactor) Store(it *pm.StoreIterator) error {
 			}).Debug("Started AddDocuments background worker")
 		}
 
-		var doc = buildDocument(b, it.Key, it.Values)
+		if b.injectSequence {
+			doc[b.res.SequenceField] = t.sequencer.next()
+		}
 		select {
 		case b.addDocsCh <- doc:
 			continue
@@ -144,19 +219,86 @@ func (t *transactor) Commit(ctx context.Context) error {
 	if err := t.errGroup.Wait(); err != nil {
 		return fmt.Errorf("commit: %w", err)
 	}
+	for _, b := range t.bindings {
+		b.logMissingChanges()
+		if err := t.sendDeletes(ctx, b); err != nil {
+			return fmt.Errorf("commit: %w", err)
+		}
+		if b.lastDoc != nil && t.config.AckMode == ackModeVerified {
+			t.unverified = append(t.unverified, newIngestMarker(b, b.lastDoc))
+		}
+		b.lastDoc = nil
+	}
+
+	var group, groupCtx = errgroup.WithContext(ctx)
+	for _, b := range t.bindings {
+		if b.stager != nil {
+			var binding = b
+			group.Go(func() error {
+				return t.commitStaged(groupCtx, binding)
+			})
+		}
+	}
+	if err := group.Wait(); err != nil {
+		return fmt.Errorf("commit: %w", err)
+	}
 	log.Debug("Commit successful")
 	return nil
 }
 
+// commitStaged uploads the documents that were staged for the binding during the transaction. There's
+// no direct indication of when a backfill is done, so it's assumed to be done when a transaction
+// stores fewer than the configured minimum number of documents. Then all of the staged documents
+// must be ingested by Rockset before the binding starts using the write API, so that they can't
+// overwrite any later documents.
+func (t *transactor) commitStaged(ctx context.Context, b *binding) error {
+	var count, err = b.stager.upload(ctx)
+	if err != nil {
+		return err
+	} else if count == 0 || count >= t.config.BackfillStaging.minDocuments() {
+		return nil
+	}
+
+	var logEntry = log.WithFields(log.Fields{
+		"rocksetCollection": b.rocksetCollection(),
+		"rocksetWorkspace":  b.rocksetWorkspace(),
+		"nDocuments":        count,
+	})
+	logEntry.Info("Transaction stored fewer documents than the staging minimum, so waiting for Rockset to ingest the staged backfill")
+	if err := awaitCollectionReady(ctx, t.client, b.rocksetWorkspace(), b.rocksetCollection(), b.res.StageBackfill.Integration); err != nil {
+		return fmt.Errorf("awaiting ingestion of the staged backfill of rockset collection '%s': %w", b.rocksetCollection(), err)
+	}
+	// If the alias can't be swapped then the transaction fails, and the binding resumes staging when
+	// the connector restarts, so that the swap is attempted again once the staged backfill drains.
+	if b.res.Alias != "" {
+		if err := swapAlias(ctx, t.client, b.res); err != nil {
+			return err
+		}
+	}
+	b.stager = nil
+	logEntry.Info("Staged backfill was ingested, and the write API will be used from now on")
+	return nil
+}
+
 // pm.Transactor
 func (t *transactor) Acknowledge(ctx context.Context) error {
-	// ack is a no-op since we ensure writes are durable in Commit
-	return nil
+	// Writes are already durable once they're committed, so ack is a no-op unless the documents
+	// must also be verified to be queryable.
+	if len(t.unverified) == 0 {
+		return nil
+	}
+	var markers = t.unverified
+	t.unverified = nil
+	return awaitQueryable(ctx, t.client, markers, t.config.verifyTimeout())
 }
 
 // pm.Transactor
 func (t *transactor) Destroy() {
-	// Nothing to clean up
+	for _, b := range t.bindings {
+		if b.stager != nil {
+			b.stager.reset()
+		}
+	}
 }
 
 func buildDocument(b *binding, keys, values tuple.Tuple) map[string]interface{} {
@@ -184,18 +326,56 @@ func buildDocument(b *binding, keys, values tuple.Tuple) map[string]interface{}
 	return document
 }
 
+// documentSize returns the approximate size of the document once it's encoded as JSON. It's only
+// an estimate, since encoding each document just to measure it would be wasteful.
+func documentSize(doc map[string]interface{}) int {
+	var size = 2
+	for field, value := range doc {
+		// Allow for the quotes, colon, and comma around each field.
+		size += len(field) + 4
+		switch value := value.(type) {
+		case json.RawMessage:
+			size += len(value)
+		case string:
+			size += len(value) + 2
+		default:
+			size += 8
+		}
+	}
+	return size
+}
+
+// sendAllDocuments writes the documents received from the channel to the binding's Rockset
+// collection. Documents are buffered into requests of up to storeBatchSize documents, and if the
+// binding has a `maxBufferedBytes`, then a request is also sent before its documents would exceed
+// that size. Each binding is buffered independently, so that one with large documents is written
+// in smaller requests without affecting the others.
 func (t *transactor) sendAllDocuments(ctx context.Context, b *binding, addDocsCh <-chan map[string]interface{}) error {
 	var docs = make([]interface{}, 0, storeBatchSize)
+	var docsBytes = 0
 
 	var docCount = 0
+	var lastDoc map[string]interface{}
 	for addDocsCh != nil {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		case doc, ok := <-addDocsCh:
 			if ok {
+				if b.res.MaxBufferedBytes > 0 {
+					var size = documentSize(doc)
+					if len(docs) > 0 && docsBytes+size > b.res.MaxBufferedBytes {
+						if err := t.sendReq(ctx, b, docs); err != nil {
+							return err
+						}
+						docs = docs[:0]
+						docsBytes = 0
+					}
+					docsBytes += size
+				}
 				docCount++
 				docs = append(docs, doc)
+				lastDoc = doc
 			} else {
 				logrus.WithFields(logrus.Fields{
 					"rocksetCollection": b.rocksetCollection(),
@@ -209,6 +389,7 @@ func (t *transactor) sendAllDocuments(ctx context.Context, b *binding, addDocsCh
 				return err
 			}
 			docs = docs[:0]
+			docsBytes = 0
 		}
 	}
 	if len(docs) > 0 {
@@ -220,14 +401,176 @@ func (t *transactor) sendAllDocuments(ctx context.Context, b *binding, addDocsCh
 		"rocksetCollection": b.rocksetCollection(),
 		"nDocuments":        docCount,
 	}).Debug("successfully persisted documents to Rockset")
+	b.lastDoc = lastDoc
 	return nil
 }
 
 func (t *transactor) sendReq(ctx context.Context, b *binding, docs []interface{}) error {
-	docStatuses, err := t.client.AddDocuments(ctx, b.rocksetWorkspace(), b.rocksetCollection(), docs)
+	docs = latestByID(docs, b.res.SequenceField)
+	switch b.res.UpdateMode {
+	case updateModePatch:
+		return t.sendPatchReq(ctx, b, docs)
+	case updateModeReplaceOnKey:
+		return t.sendReplaceReq(ctx, b, docs)
+	}
+	return t.addDocuments(ctx, b, docs)
+}
+
+// sendReplaceReq deletes the existing documents having the same `_id`s as the documents before
+// adding them, so that the stored documents hold exactly the fields of the latest ones. The
+// deletes of each request's documents are sent as a single request ahead of it, and so a query
+// may briefly find a document missing in between the two.
+func (t *transactor) sendReplaceReq(ctx context.Context, b *binding, docs []interface{}) error {
+	var ids = make([]string, len(docs))
+	for i, doc := range docs {
+		ids[i] = doc.(map[string]interface{})["_id"].(string)
+	}
+	if err := t.deleteDocuments(ctx, b, ids); err != nil {
+		return err
+	}
+	return t.addDocuments(ctx, b, docs)
+}
+
+// partialCommitBackoff is how long to wait before the first time that rejected documents are
+// resent. It increases linearly with each retry.
+var partialCommitBackoff = time.Second
+
+// addDocuments adds the documents to the binding's Rockset collection. Rockset accepts or rejects
+// each document of a request individually, so a request may be partially committed. The rejected
+// documents are then resent, up to the configured number of partial commit retries. Documents
+// which were accepted are never resent, since they'd otherwise be written again by each retry.
+func (t *transactor) addDocuments(ctx context.Context, b *binding, docs []interface{}) error {
+	for attempt := 0; ; attempt++ {
+		docStatuses, err := t.client.AddDocuments(ctx, b.rocksetWorkspace(), b.rocksetCollection(), docs)
+		if err != nil {
+			return err
+		}
+		var rejected, ok = rejectedDocuments(docs, docStatuses)
+		if len(rejected) == 0 || !ok || attempt >= t.config.partialCommitRetries() {
+			return checkDocumentStatuses(b, docStatuses)
+		}
+
+		log.WithFields(log.Fields{
+			"rocksetCollection": b.rocksetCollection(),
+			"nAccepted":         len(docs) - len(rejected),
+			"nRejected":         len(rejected),
+			"attempt":           attempt + 1,
+		}).Warn("Rockset rejected some documents of a request, so they will be resent")
+		docs = rejected
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(partialCommitBackoff * time.Duration(attempt+1)):
+		}
+	}
+}
+
+// rejectedDocuments returns the documents which Rockset rejected, according to their statuses.
+// Statuses are matched to documents by `_id`, which is unique within a request. It returns false
+// if a rejection can't be matched to a document, in which case it's unknown which to resend.
+func rejectedDocuments(docs []interface{}, docStatuses []rtypes.DocumentStatus) ([]interface{}, bool) {
+	var docsByID = make(map[string]interface{}, len(docs))
+	for _, doc := range docs {
+		docsByID[doc.(map[string]interface{})["_id"].(string)] = doc
+	}
+	var rejected []interface{}
+	var rejectedIDs = make(map[string]bool)
+	for _, docStatus := range docStatuses {
+		if docStatus.Error == nil {
+			continue
+		} else if docStatus.Id == nil {
+			return nil, false
+		}
+		var doc, ok = docsByID[*docStatus.Id]
+		if !ok {
+			return nil, false
+		} else if !rejectedIDs[*docStatus.Id] {
+			rejectedIDs[*docStatus.Id] = true
+			rejected = append(rejected, doc)
+		}
+	}
+	return rejected, true
+}
+
+// sendPatchReq writes each document as a patch of its materialized fields. Rockset can only patch
+// documents that already exist, so any that are rejected because they weren't found are then added
+// as whole documents instead.
+func (t *transactor) sendPatchReq(ctx context.Context, b *binding, docs []interface{}) error {
+	var patches = make([]rockset.PatchDocument, len(docs))
+	var docsByID = make(map[string]interface{}, len(docs))
+	for i, doc := range docs {
+		patches[i] = buildPatch(doc.(map[string]interface{}))
+		// If the same key appears more than once in a batch, the last document is the one that
+		// reflects all of the patches to it.
+		docsByID[patches[i].ID] = doc
+	}
+
+	docStatuses, err := t.client.PatchDocuments(ctx, b.rocksetWorkspace(), b.rocksetCollection(), patches)
 	if err != nil {
 		return err
 	}
+
+	var missing = make(map[string]bool)
+	var missingDocs []interface{}
+	var rejected []rtypes.DocumentStatus
+	for _, docStatus := range docStatuses {
+		if docStatus.Error != nil && docStatus.Id != nil && (rockset.Error{ErrorModel: docStatus.Error}).IsNotFoundError() {
+			if doc, ok := docsByID[*docStatus.Id]; ok {
+				if !missing[*docStatus.Id] {
+					missing[*docStatus.Id] = true
+					missingDocs = append(missingDocs, doc)
+				}
+				continue
+			}
+		}
+		rejected = append(rejected, docStatus)
+	}
+	if err := checkDocumentStatuses(b, rejected); err != nil {
+		return err
+	}
+	if len(missingDocs) == 0 {
+		return nil
+	}
+
+	log.WithFields(log.Fields{
+		"rocksetCollection": b.rocksetCollection(),
+		"nDocuments":        len(missingDocs),
+	}).Debug("adding documents which could not be patched because they don't exist yet")
+	return t.addDocuments(ctx, b, missingDocs)
+}
+
+// buildPatch converts a document into a patch which sets each of its fields (other than `_id`) to
+// the given value. JSON patch `add` operations replace the value of a member if it already exists.
+func buildPatch(doc map[string]interface{}) rockset.PatchDocument {
+	var fields = make([]string, 0, len(doc))
+	for field := range doc {
+		if field != "_id" {
+			fields = append(fields, field)
+		}
+	}
+	sort.Strings(fields)
+
+	var ops = make([]rockset.PatchOperation, 0, len(fields))
+	for _, field := range fields {
+		ops = append(ops, rockset.PatchOperation{
+			Op:    "add",
+			Path:  "/" + jsonPointerEscaper.Replace(field),
+			Value: doc[field],
+		})
+	}
+	return rockset.PatchDocument{
+		ID:      doc["_id"].(string),
+		Patches: ops,
+	}
+}
+
+// jsonPointerEscaper escapes a field name for use as a JSON pointer token, per RFC 6901.
+var jsonPointerEscaper = strings.NewReplacer("~", "~0", "/", "~1")
+
+// checkDocumentStatuses returns an error if any of the documents were rejected.
+func checkDocumentStatuses(b *binding, docStatuses []rtypes.DocumentStatus) error {
+	var err error
 	// Rockset's API doesn't fail the whole request due to an error with a single document,
 	// so we need to iterate over each of the returned statuses and check them individually.
 	// We'll log _all_ the errors, since it's unclear whether they'll all be the same or if the
@@ -0,0 +1,125 @@
+package materialize_rockset
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	rockset "github.com/rockset/rockset-go-client"
+	rtypes "github.com/rockset/rockset-go-client/openapi"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// Transactions are acknowledged as soon as Rockset has accepted their documents.
+	ackModeImmediate = "immediate"
+	// Transactions are acknowledged once their documents can be queried.
+	ackModeVerified = "verified"
+)
+
+// verifyPollInterval is how often Rockset is queried while verifying that documents are queryable.
+const verifyPollInterval = time.Second
+
+// ingestMarker is the final document written to a Rockset collection during a transaction. Once it
+// can be queried, so can all of the documents written before it.
+type ingestMarker struct {
+	workspace  string
+	collection string
+	id         string
+	// sequenceField and sequence are set if the binding has a sequence field with a value that can
+	// be compared, in which case the document must have at least that sequence value. Otherwise an
+	// update of an existing document can't be told apart from the prior version of the document.
+	sequenceField string
+	sequence      *rtypes.QueryParameter
+}
+
+func newIngestMarker(b *binding, doc map[string]interface{}) *ingestMarker {
+	var marker = &ingestMarker{
+		workspace:  b.rocksetWorkspace(),
+		collection: b.rocksetCollection(),
+		id:         doc["_id"].(string),
+	}
+	if b.res.SequenceField == "" {
+		return marker
+	}
+	var param *rtypes.QueryParameter
+	switch v := doc[b.res.SequenceField].(type) {
+	case int64:
+		param = rtypes.NewQueryParameter("sequence", "int", fmt.Sprint(v))
+	case uint64:
+		param = rtypes.NewQueryParameter("sequence", "int", fmt.Sprint(v))
+	case float64:
+		param = rtypes.NewQueryParameter("sequence", "float", fmt.Sprint(v))
+	case string:
+		param = rtypes.NewQueryParameter("sequence", "string", v)
+	}
+	if param != nil {
+		marker.sequenceField = b.res.SequenceField
+		marker.sequence = param
+	}
+	return marker
+}
+
+// query returns the SQL query which counts the documents matching the marker, with its parameters.
+func (m *ingestMarker) query() (string, []rtypes.QueryParameter) {
+	var sql = fmt.Sprintf("SELECT COUNT(*) AS n FROM %s.%s WHERE _id = :id", quoteIdentifier(m.workspace), quoteIdentifier(m.collection))
+	var params = []rtypes.QueryParameter{*rtypes.NewQueryParameter("id", "string", m.id)}
+	if m.sequence != nil {
+		sql += fmt.Sprintf(" AND %s >= :sequence", quoteIdentifier(m.sequenceField))
+		params = append(params, *m.sequence)
+	}
+	return sql, params
+}
+
+// awaitQueryable polls Rockset until each of the markers can be queried, or the timeout elapses.
+func awaitQueryable(ctx context.Context, client *rockset.RockClient, markers []*ingestMarker, timeout time.Duration) error {
+	var start = time.Now()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for _, marker := range markers {
+		var sql, params = marker.query()
+		for {
+			var found, err = queryCount(ctx, client, sql, params)
+			if err != nil && ctx.Err() == nil {
+				return fmt.Errorf("verifying ingestion of rockset collection '%s': %w", marker.collection, err)
+			} else if found > 0 {
+				break
+			}
+
+			select {
+			case <-ctx.Done():
+				return fmt.Errorf("documents written to rockset collection '%s' were not queryable after %s", marker.collection, timeout)
+			case <-time.After(verifyPollInterval):
+			}
+		}
+	}
+	log.WithFields(log.Fields{
+		"nCollections": len(markers),
+		"elapsed":      time.Since(start).String(),
+	}).Debug("verified that documents are queryable")
+	return nil
+}
+
+func queryCount(ctx context.Context, client *rockset.RockClient, sql string, params []rtypes.QueryParameter) (int64, error) {
+	var querySQL = rtypes.NewQueryRequestSql(sql)
+	querySQL.Parameters = params
+	var resp, _, err = client.QueriesApi.Query(ctx).Body(*rtypes.NewQueryRequest(*querySQL)).Execute()
+	if err != nil {
+		return 0, err
+	} else if len(resp.Results) != 1 {
+		return 0, fmt.Errorf("expected 1 result but got %d", len(resp.Results))
+	}
+	switch n := resp.Results[0]["n"].(type) {
+	case float64:
+		return int64(n), nil
+	default:
+		return 0, fmt.Errorf("unexpected count %#v", resp.Results[0]["n"])
+	}
+}
+
+// quoteIdentifier quotes a workspace, collection, or field name for use in a Rockset SQL query.
+func quoteIdentifier(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
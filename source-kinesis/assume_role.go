@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+// defaultRoleSessionName identifies the sessions of assumed roles, such as in CloudTrail, when the
+// config doesn't provide a sessionName.
+const defaultRoleSessionName = "estuary-source-kinesis"
+
+// validateAuthentication checks the combination of credentials, shared-config profile, and role
+// of the config. Either static credentials or a profile provides the base credentials, which may
+// then be used to assume a role.
+func validateAuthentication(c *Config) error {
+	switch {
+	case c.Profile != "" && (c.AWSAccessKeyID != "" || c.AWSSecretAccessKey != ""):
+		return fmt.Errorf("awsAccessKeyId and awsSecretAccessKey must not be set when a profile is used")
+	case c.Profile == "" && c.AWSAccessKeyID == "":
+		return fmt.Errorf("missing awsAccessKeyId")
+	case c.Profile == "" && c.AWSSecretAccessKey == "":
+		return fmt.Errorf("missing awsSecretAccessKey")
+	}
+	if c.RoleArn == "" {
+		if c.ExternalID != "" {
+			return fmt.Errorf("externalId requires a roleArn")
+		} else if c.SessionName != "" {
+			return fmt.Errorf("sessionName requires a roleArn")
+		}
+	} else if !strings.HasPrefix(c.RoleArn, "arn:") {
+		return fmt.Errorf("invalid roleArn %q: must be the ARN of an IAM role", c.RoleArn)
+	}
+	return nil
+}
+
+// assumeRoleCredentials returns credentials of the config's role, which are assumed using the
+// base credentials of the AWS config and are cached until shortly before they expire.
+func assumeRoleCredentials(base aws.Config, config *Config) *aws.CredentialsCache {
+	var provider = stscreds.NewAssumeRoleProvider(sts.NewFromConfig(base), config.RoleArn, func(o *stscreds.AssumeRoleOptions) {
+		o.RoleSessionName = config.SessionName
+		if o.RoleSessionName == "" {
+			o.RoleSessionName = defaultRoleSessionName
+		}
+		if config.ExternalID != "" {
+			o.ExternalID = aws.String(config.ExternalID)
+		}
+	})
+	return aws.NewCredentialsCache(provider)
+}
+
+// authenticationError is returned by checkCredentials when the credentials of the config can't be
+// obtained, so that permission problems can be told apart from errors in the names of streams.
+type authenticationError struct {
+	config *Config
+	err    error
+}
+
+func (e *authenticationError) Error() string {
+	switch {
+	case e.config.RoleArn != "":
+		return fmt.Sprintf("unable to assume role %q, check that its trust policy allows the base credentials (and externalId, if any) to assume it: %v", e.config.RoleArn, e.err)
+	case e.config.Profile != "":
+		return fmt.Sprintf("unable to load credentials of profile %q: %v", e.config.Profile, e.err)
+	default:
+		return fmt.Sprintf("unable to load credentials: %v", e.err)
+	}
+}
+
+func (e *authenticationError) Unwrap() error {
+	return e.err
+}
+
+// checkCredentials obtains the credentials of the config, which assumes its role if it has one.
+// Credentials are otherwise only obtained by the first request which needs them, and a failure
+// would then look like a failure of that request.
+func checkCredentials(ctx context.Context, creds aws.CredentialsProvider, config *Config) error {
+	if _, err := creds.Retrieve(ctx); err != nil {
+		return &authenticationError{config: config, err: err}
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateAuthentication(t *testing.T) {
+	for _, tc := range []struct {
+		config Config
+		err    string
+	}{
+		{Config{AWSAccessKeyID: "id", AWSSecretAccessKey: "secret"}, ""},
+		{Config{Profile: "other"}, ""},
+		{Config{AWSAccessKeyID: "id", AWSSecretAccessKey: "secret", RoleArn: "arn:aws:iam::123456789012:role/capture", ExternalID: "ext", SessionName: "s"}, ""},
+		{Config{Profile: "other", RoleArn: "arn:aws:iam::123456789012:role/capture"}, ""},
+		{Config{}, "missing awsAccessKeyId"},
+		{Config{AWSAccessKeyID: "id"}, "missing awsSecretAccessKey"},
+		{Config{Profile: "other", AWSAccessKeyID: "id"}, "must not be set when a profile is used"},
+		{Config{AWSAccessKeyID: "id", AWSSecretAccessKey: "secret", ExternalID: "ext"}, "externalId requires a roleArn"},
+		{Config{AWSAccessKeyID: "id", AWSSecretAccessKey: "secret", SessionName: "s"}, "sessionName requires a roleArn"},
+		{Config{AWSAccessKeyID: "id", AWSSecretAccessKey: "secret", RoleArn: "capture"}, "invalid roleArn"},
+	} {
+		var err = validateAuthentication(&tc.config)
+		if tc.err == "" {
+			require.NoError(t, err)
+		} else {
+			require.Error(t, err)
+			require.Contains(t, err.Error(), tc.err)
+		}
+	}
+}
+
+const assumeRoleResponse = `<AssumeRoleResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <AssumeRoleResult>
+    <Credentials>
+      <AccessKeyId>assumed-id</AccessKeyId>
+      <SecretAccessKey>assumed-secret</SecretAccessKey>
+      <SessionToken>assumed-token</SessionToken>
+      <Expiration>2100-01-01T00:00:00Z</Expiration>
+    </Credentials>
+  </AssumeRoleResult>
+</AssumeRoleResponse>`
+
+const assumeRoleDenied = `<ErrorResponse xmlns="https://sts.amazonaws.com/doc/2011-06-15/">
+  <Error>
+    <Type>Sender</Type>
+    <Code>AccessDenied</Code>
+    <Message>not authorized to perform sts:AssumeRole</Message>
+  </Error>
+  <RequestId>request-id</RequestId>
+</ErrorResponse>`
+
+func TestAssumeRoleCredentials(t *testing.T) {
+	var requests []map[string]string
+	var deny bool
+	var server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		require.NoError(t, req.ParseForm())
+		requests = append(requests, map[string]string{
+			"RoleArn":         req.Form.Get("RoleArn"),
+			"ExternalId":      req.Form.Get("ExternalId"),
+			"RoleSessionName": req.Form.Get("RoleSessionName"),
+		})
+		w.Header().Set("Content-Type", "text/xml")
+		if deny {
+			w.WriteHeader(http.StatusForbidden)
+			w.Write([]byte(assumeRoleDenied))
+			return
+		}
+		w.Write([]byte(assumeRoleResponse))
+	}))
+	defer server.Close()
+
+	// The base config sends its requests to the fake STS endpoint.
+	var base = testAWSConfig(server.URL)
+	var ctx = context.Background()
+
+	var config = Config{RoleArn: "arn:aws:iam::123456789012:role/capture", ExternalID: "ext"}
+	var creds = assumeRoleCredentials(base, &config)
+	require.NoError(t, checkCredentials(ctx, creds, &config))
+	value, err := creds.Retrieve(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "assumed-id", value.AccessKeyID)
+	require.Equal(t, "assumed-token", value.SessionToken)
+	require.Equal(t, []map[string]string{{
+		"RoleArn":         "arn:aws:iam::123456789012:role/capture",
+		"ExternalId":      "ext",
+		"RoleSessionName": defaultRoleSessionName,
+	}}, requests)
+
+	// A role which can't be assumed is reported as an authentication error.
+	deny = true
+	config = Config{RoleArn: "arn:aws:iam::123456789012:role/other", SessionName: "custom"}
+	err = checkCredentials(ctx, assumeRoleCredentials(base, &config), &config)
+	var authErr *authenticationError
+	require.True(t, errors.As(err, &authErr))
+	require.Contains(t, err.Error(), `unable to assume role "arn:aws:iam::123456789012:role/other"`)
+	require.Contains(t, err.Error(), "AccessDenied")
+	require.Equal(t, "custom", requests[1]["RoleSessionName"])
+	require.Empty(t, requests[1]["ExternalId"])
+}
+
+func TestProfileCredentials(t *testing.T) {
+	var dir, err = ioutil.TempDir("", "kinesis-profile")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	var path = filepath.Join(dir, "credentials")
+	require.NoError(t, ioutil.WriteFile(path, []byte("[other]\naws_access_key_id = profile-id\naws_secret_access_key = profile-secret\n"), 0600))
+	os.Setenv("AWS_SHARED_CREDENTIALS_FILE", path)
+	defer os.Unsetenv("AWS_SHARED_CREDENTIALS_FILE")
+
+	var ctx = context.Background()
+	var config = Config{Region: "us-east-1", Profile: "other"}
+	awsConfig, err := loadAWSConfig(ctx, &config)
+	require.NoError(t, err)
+	value, err := awsConfig.Credentials.Retrieve(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "profile-id", value.AccessKeyID)
+	require.Equal(t, "profile-secret", value.SecretAccessKey)
+
+	// A profile which doesn't exist is reported as an authentication error.
+	config.Profile = "missing"
+	awsConfig, err = loadAWSConfig(ctx, &config)
+	if err == nil {
+		err = checkCredentials(ctx, awsConfig.Credentials, &config)
+	}
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `"missing"`)
+}
+
+// testAWSConfig returns an AWS config whose clients send their requests to the given URL of a fake
+// service, with static credentials and without retries.
+func testAWSConfig(url string) aws.Config {
+	return aws.Config{
+		Region:      "us-east-1",
+		Credentials: credentials.NewStaticCredentialsProvider("id", "secret", ""),
+		EndpointResolverWithOptions: aws.EndpointResolverWithOptionsFunc(func(service, region string, options ...interface{}) (aws.Endpoint, error) {
+			return aws.Endpoint{URL: url}, nil
+		}),
+		Retryer: func() aws.Retryer { return aws.NopRetryer{} },
+	}
+}
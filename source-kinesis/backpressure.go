@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+
+	"golang.org/x/sync/semaphore"
+)
+
+// defaultMaxInFlightRecords is used when the config doesn't specify `maxInFlightRecords`. At the
+// targeted ~1MiB per GetRecords response this allows for a handful of responses to be buffered in
+// memory at once, which is plenty to keep stdout busy while shard reads are waiting on kinesis.
+const defaultMaxInFlightRecords = 20000
+
+// inFlightLimiter bounds the total number of records that have been read from kinesis, but not yet
+// written to the output, across all shards of all streams. Shard readers reserve capacity before
+// each GetRecords request, and the main loop releases it once the records have been written along
+// with the state update that covers them. When the output is slow, readers will block waiting for
+// capacity instead of piling up an unbounded amount of work in memory.
+type inFlightLimiter struct {
+	sem *semaphore.Weighted
+	max int64
+	// The number of records currently reserved. This is only used for logging and tests, since the
+	// semaphore is what actually enforces the limit.
+	current int64
+}
+
+func newInFlightLimiter(max int64) *inFlightLimiter {
+	return &inFlightLimiter{
+		sem: semaphore.NewWeighted(max),
+		max: max,
+	}
+}
+
+// acquire blocks until capacity for up to `n` records is available, and returns the number of
+// records that were actually reserved. This may be less than `n` if `n` exceeds the total capacity
+// of the limiter, since such a request could otherwise never be satisfied.
+func (l *inFlightLimiter) acquire(ctx context.Context, n int64) (int64, error) {
+	if n > l.max {
+		n = l.max
+	}
+	if err := l.sem.Acquire(ctx, n); err != nil {
+		return 0, err
+	}
+	atomic.AddInt64(&l.current, n)
+	return n, nil
+}
+
+// release returns capacity for `n` records, which must have been reserved by a prior `acquire`.
+func (l *inFlightLimiter) release(n int64) {
+	if n <= 0 {
+		return
+	}
+	atomic.AddInt64(&l.current, -n)
+	l.sem.Release(n)
+}
+
+// inFlight returns the number of records that are currently reserved.
+func (l *inFlightLimiter) inFlight() int64 {
+	return atomic.LoadInt64(&l.current)
+}
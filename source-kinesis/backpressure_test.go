@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestInFlightLimiterWithSlowConsumer(t *testing.T) {
+	const maxInFlight = 50
+	const producers = 4
+	const batchesPerProducer = 20
+
+	var ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+	var limiter = newInFlightLimiter(maxInFlight)
+	var dataCh = make(chan int64, 8)
+
+	// Each producer mimics a shard reader, reserving a full request's worth of records and then
+	// handing off a batch of them to the consumer.
+	var wg sync.WaitGroup
+	for i := 0; i < producers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < batchesPerProducer; j++ {
+				var reserved, err = limiter.acquire(ctx, 20)
+				require.NoError(t, err)
+				var batch = reserved / 2
+				limiter.release(reserved - batch)
+				dataCh <- batch
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(dataCh)
+	}()
+
+	// The consumer is slow, and checks that the readers never get too far ahead of it.
+	var peak, total int64
+	for batch := range dataCh {
+		var current = limiter.inFlight()
+		require.LessOrEqual(t, current, int64(maxInFlight))
+		if current > peak {
+			peak = current
+		}
+		time.Sleep(time.Millisecond)
+		total += batch
+		limiter.release(batch)
+	}
+	require.Equal(t, int64(producers*batchesPerProducer*10), total)
+	require.Greater(t, peak, int64(0))
+	require.Equal(t, int64(0), limiter.inFlight())
+}
+
+func TestInFlightLimiterCapsRequests(t *testing.T) {
+	var limiter = newInFlightLimiter(10)
+
+	// Requests larger than the total capacity are reduced rather than blocking forever.
+	var reserved, err = limiter.acquire(context.Background(), 2000)
+	require.NoError(t, err)
+	require.Equal(t, int64(10), reserved)
+
+	// Further reservations block until capacity is released, or the context is cancelled.
+	var ctx, cancel = context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = limiter.acquire(ctx, 1)
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	var acquired int32
+	go func() {
+		if n, err := limiter.acquire(context.Background(), 5); err == nil && n == 5 {
+			atomic.StoreInt32(&acquired, 1)
+		}
+	}()
+	time.Sleep(20 * time.Millisecond)
+	require.Equal(t, int32(0), atomic.LoadInt32(&acquired))
+	limiter.release(reserved)
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&acquired) == 1 }, time.Second, time.Millisecond)
+	require.Equal(t, int64(5), limiter.inFlight())
+}
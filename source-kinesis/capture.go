@@ -3,32 +3,102 @@ package main
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws/awserr"
-	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
 	"github.com/estuary/flow/go/protocols/airbyte"
 	log "github.com/sirupsen/logrus"
 	"golang.org/x/time/rate"
 )
 
+// readerOptions configure how the records of every stream are read and processed. They're built
+// once from the Config, and each option which is a pointer is nil if it isn't configured.
+type readerOptions struct {
+	// Decompresses the payload of each record before anything else is done with it.
+	decompressor *recordDecompressor
+	// Whether records which aren't JSON objects are captured in an envelope object.
+	parseJSON bool
+	// Drops records which don't match it.
+	filter *recordFilter
+	// Drops records which duplicate one within its window.
+	dedup *dedupWindow
+	// Extracts the key of each record and adds it to the record.
+	keys *keyExtractor
+	// Determines the timestamp with which each record is emitted.
+	timestamps *recordTimestamper
+	// Applies its policy to records which exceed it.
+	sizeLimit *recordSizeLimit
+	// Monitors how far behind the tips of the kinesis shards the read is.
+	lag *lagMonitor
+	// Applied when kinesis rejects the stored sequence number of a shard.
+	expiredPolicy string
+	// If non-zero, the position of each shard which is idle is sent at this interval. See
+	// shardReader.maybeHeartbeat.
+	checkpointInterval time.Duration
+	// Where kinesis shards which have no stored sequence number are read from.
+	start *startPosition
+}
+
+// newReaderOptions builds the readerOptions of a config, returning an error if any of its options
+// are invalid. The `now` is the time at which a capture with the `latest` starting position started.
+func newReaderOptions(config *Config, now time.Time) (readerOptions, error) {
+	var opts = readerOptions{
+		parseJSON:          config.ParseJSON,
+		expiredPolicy:      config.ExpiredSequencePolicy,
+		checkpointInterval: time.Duration(config.CheckpointIntervalSeconds) * time.Second,
+	}
+	var err error
+	if opts.keys, err = newKeyExtractor(config.KeyField); err != nil {
+		return readerOptions{}, fmt.Errorf("invalid keyField: %w", err)
+	} else if opts.sizeLimit, err = newRecordSizeLimit(config.MaxRecordBytes, config.OversizedRecordPolicy); err != nil {
+		return readerOptions{}, err
+	} else if opts.decompressor, err = newRecordDecompressor(config.Compression, config.CorruptRecordPolicy); err != nil {
+		return readerOptions{}, err
+	} else if opts.filter, err = newRecordFilter(config.Filter); err != nil {
+		return readerOptions{}, err
+	} else if opts.dedup, err = newDedupWindow(config.DedupField, config.DedupWindowSize, config.DedupWindowSeconds); err != nil {
+		return readerOptions{}, err
+	} else if opts.lag, err = newLagMonitor(config.MaxLagSeconds, config.MaxLagDurationSeconds, config.LagAction); err != nil {
+		return readerOptions{}, err
+	} else if err = validateExpiredSequencePolicy(config.ExpiredSequencePolicy); err != nil {
+		return readerOptions{}, err
+	} else if config.CheckpointIntervalSeconds < 0 {
+		return readerOptions{}, fmt.Errorf("checkpointIntervalSeconds must not be negative")
+	} else if opts.start, err = newStartPosition(config.StartingPosition, config.StartingTimestamp, now); err != nil {
+		return readerOptions{}, err
+	} else if opts.timestamps, err = newRecordTimestamper(config.TimestampSource, config.TimestampField, config.TimestampFormat); err != nil {
+		return readerOptions{}, err
+	}
+	return opts, nil
+}
+
 // readStream starts reading the given kinesis stream, delivering both records and errors from all
 // kinsis shards over the given `resultsCh`. If `stopAt` is non-nil, then the this will only read
 // records up through _approximately_ that time, or until millisBehindLatest indicates we're caught
 // up to the tip of the stream. Otherwise, this will continue to read indefinitely.
+// Reads will block whenever `inFlight` has no remaining capacity, which is how backpressure from a
+// slow consumer of `resultsCh` gets propagated to each of the shard reads.
+// If `leases` is non-nil, then kinesis shards are read only while this worker holds their leases,
+// rather than according to the `shardRange`. Records are read and processed according to `opts`.
 // The `wg` is expected to have been incremented once _prior_ to calling this function. It will be
 // further incremented and decremented behind the scenes, but will be decremented back down to the
 // prior value when the read is finished.
-func readStream(ctx context.Context, shardRange airbyte.Range, client *kinesis.Kinesis, stream string, state map[string]string, resultsCh chan<- readResult, stopAt *time.Time, wg *sync.WaitGroup) {
+func readStream(ctx context.Context, shardRange airbyte.Range, client kinesisAPI, stream string, state map[string]string, resultsCh chan<- readResult, inFlight *inFlightLimiter, leases *leaseCoordinator, opts readerOptions, stopAt *time.Time, wg *sync.WaitGroup) {
 
 	var kc = &streamReader{
+		readerOptions:  opts,
 		client:         client,
 		ctx:            ctx,
 		stream:         stream,
 		shardRange:     shardRange,
 		dataCh:         resultsCh,
+		inFlight:       inFlight,
+		leases:         leases,
+		leasedReads:    make(map[string]*leasedRead),
 		readingShards:  make(map[string]bool),
 		shardSequences: state,
 		stopAt:         stopAt,
@@ -56,25 +126,40 @@ func readStream(ctx context.Context, shardRange airbyte.Range, client *kinesis.K
 
 // Represents an ongoing read of a kinesis stream.
 type streamReader struct {
-	client             *kinesis.Kinesis
+	readerOptions
+	client             kinesisAPI
 	ctx                context.Context
 	stream             string
 	shardRange         airbyte.Range
 	dataCh             chan<- readResult
+	inFlight           *inFlightLimiter
+	leases             *leaseCoordinator
 	stopAt             *time.Time
 	waitGroup          *sync.WaitGroup
 	readingShards      map[string]bool
 	readingShardsMutex sync.Mutex
+	// leasedReads tracks the ongoing reads of leased kinesis shards, so that they can be stopped if
+	// their leases are lost. It's guarded by readingShardsMutex.
+	leasedReads map[string]*leasedRead
 	// shardSequences is a copy of the capture state, which just tracks the sequenceID for each
 	// kinesis shard. We keep this as a struct field so that we can ensure that all reads will use
 	// the same state, regardless of whether they're triggered by the initial shard listing or
 	// returned as a child shard id when reaching the end of an existing shard.
 	shardSequences map[string]string
+	// finishedShards are the shards which have already been read completely, but whose entries
+	// were removed from the state. They're determined when the shards are initially listed, and
+	// aren't used when leasing since the leases track which shards have been read.
+	finishedShards map[string]bool
+	// drainedShards are the shards whose ends have been reached during this read, and whose final
+	// records have been sent. It's guarded by readingShardsMutex.
+	drainedShards map[string]bool
 }
 
 type recordSource struct {
 	stream  string
 	shardID string
+	// The ids of the parents of the kinesis shard, which are used to compact the state.
+	parentShardIDs []string
 }
 
 // readResult is the message that's sent on the channel to the main thread. It will either contain
@@ -85,12 +170,26 @@ type readResult struct {
 	err    error
 	// A batch of records from kinesis.
 	records []json.RawMessage
-	// The highest sequence number in the batch, which should be added to the state.
+	// The timestamps with which each record is emitted, if records aren't all emitted with the
+	// current time. The records whose timestamps are zero are emitted with the current time.
+	timestamps []time.Time
+	// The highest sequence number in the batch, which should be added to the state. It also has the
+	// sub-sequence number of the last record if the batch ends partway through an aggregated record.
 	sequenceNumber string
+	// The ids of the child shards of the kinesis shard, which are only set on the final result of
+	// a shard once its end has been reached. That result may not have any records.
+	childShardIDs []string
+	// heartbeat is set if the result has no records, and its sequence number is a heartbeat
+	// position which only advances the state of an idle shard.
+	heartbeat bool
 }
 
 // startReadingStream synchronously lists kinesis shards and begins background reads of the ones that overlap this capture shard range.
 func (kc *streamReader) startReadingStream() error {
+	if kc.leases != nil {
+		return kc.startLeasedReads()
+	}
+
 	initialShards, err := kc.listInitialShards()
 	if err != nil {
 		return fmt.Errorf("listing kinesis shards: %w", err)
@@ -103,12 +202,13 @@ func (kc *streamReader) startReadingStream() error {
 	}).Infof("Will start reading from %d kinesis shards", len(initialShards))
 
 	for _, kinesisShard := range initialShards {
-		var reader, err = kc.newShardReader(*kinesisShard.ShardId, func(_ string) (*kinesis.Shard, error) {
+		var reader, err = kc.newShardReader(*kinesisShard.ShardId, func(_ string) (*types.Shard, error) {
 			return kinesisShard, nil
 		})
 		if err != nil {
 			return err
 		} else if reader != nil {
+			reader.initial = true
 			kc.waitGroup.Add(1)
 			go func() {
 				reader.readShard()
@@ -117,6 +217,12 @@ func (kc *streamReader) startReadingStream() error {
 		}
 		// If reader == nil, then we should not read this shard
 	}
+	// While tailing, the stream is listed periodically in order to find any shards that aren't
+	// reached through the children of the shards being read.
+	if kc.stopAt == nil {
+		kc.waitGroup.Add(1)
+		go kc.watchShards()
+	}
 	return nil
 }
 
@@ -134,40 +240,28 @@ func (kc *streamReader) startReadingStream() error {
 // and we need to also start reading shards that are siblings of those that are included in the
 // state. We also don't do any filtering based on shard ranges here, in order to keep a single code
 // path for doing that.
-func (kc *streamReader) listInitialShards() ([]*kinesis.Shard, error) {
-	var shardListing = make(map[string]*kinesis.Shard)
-	var nextToken = ""
-	for {
-		var listShardsReq = kinesis.ListShardsInput{}
-		if nextToken != "" {
-			listShardsReq.NextToken = &nextToken
-		} else {
-			listShardsReq.StreamName = &kc.stream
-		}
-		listShardsResp, err := kc.client.ListShardsWithContext(kc.ctx, &listShardsReq)
-		if err != nil {
-			return nil, fmt.Errorf("listing shards: %w", err)
-		}
-		for _, shard := range listShardsResp.Shards {
-			shardListing[*shard.ShardId] = shard
-		}
-
-		if listShardsResp.NextToken != nil && (*listShardsResp.NextToken) != "" {
-			nextToken = *listShardsResp.NextToken
-		} else {
-			break
-		}
+func (kc *streamReader) listInitialShards() ([]*types.Shard, error) {
+	var shardListing, err = kc.listAllShards()
+	if err != nil {
+		return nil, err
 	}
 
+	// Shards which have been read completely may have had their entries removed from the state, and
+	// must neither be read again nor hold up the reads of their children.
+	kc.finishedShards = finishedShards(shardListing, kc.shardSequences)
+
 	// Now iterate the map and return all shards in the oldest generation.
 	// Reading those shards will yield the child shards once we reach the end of each parent.
-	var shards []*kinesis.Shard
+	var shards []*types.Shard
 	for shardID, shard := range shardListing {
+		if kc.finishedShards[shardID] {
+			continue
+		}
 		// Does the shard have a parent
 		if shard.ParentShardId != nil {
 			// Was the parent included in the ListShards output, meaning it still contains data that
-			// falls inside the retention period.
-			if _, parentIsListed := shardListing[*shard.ParentShardId]; parentIsListed {
+			// falls inside the retention period, and has it not been read completely already.
+			if _, parentIsListed := shardListing[*shard.ParentShardId]; parentIsListed && !kc.finishedShards[*shard.ParentShardId] {
 				// And finally, have we already started reading from this shard? If so, then we'll
 				// want to continue reading from it, even if it might otherwise be excluded.
 				if _, ok := kc.shardSequences[shardID]; !ok {
@@ -185,22 +279,51 @@ func (kc *streamReader) listInitialShards() ([]*kinesis.Shard, error) {
 	return shards, nil
 }
 
+// listAllShards returns all of the shards of the stream, keyed by their ids.
+func (kc *streamReader) listAllShards() (map[string]*types.Shard, error) {
+	var shardListing = make(map[string]*types.Shard)
+	var nextToken = ""
+	for {
+		var listShardsReq = kinesis.ListShardsInput{}
+		if nextToken != "" {
+			listShardsReq.NextToken = &nextToken
+		} else {
+			listShardsReq.StreamName = &kc.stream
+		}
+		listShardsResp, err := kc.client.ListShards(kc.ctx, &listShardsReq)
+		if err != nil {
+			return nil, fmt.Errorf("listing shards: %w", err)
+		}
+		for i := range listShardsResp.Shards {
+			var shard = &listShardsResp.Shards[i]
+			shardListing[*shard.ShardId] = shard
+		}
+
+		if listShardsResp.NextToken != nil && (*listShardsResp.NextToken) != "" {
+			nextToken = *listShardsResp.NextToken
+		} else {
+			break
+		}
+	}
+	return shardListing, nil
+}
+
 // getShard returns the kinesis shard with the given id.
-func (kc *streamReader) getShard(id string) (*kinesis.Shard, error) {
+func (kc *streamReader) getShard(id string) (*types.Shard, error) {
 	var input = kinesis.ListShardsInput{
 		StreamName: &kc.stream,
-		ShardFilter: &kinesis.ShardFilter{
+		ShardFilter: &types.ShardFilter{
 			ShardId: &id,
 		},
 	}
-	var out, err = kc.client.ListShardsWithContext(kc.ctx, &input)
+	var out, err = kc.client.ListShards(kc.ctx, &input)
 	if err != nil {
 		return nil, err
 	}
 	if len(out.Shards) != 1 {
 		return nil, fmt.Errorf("expected one shard with id: '%s' to be returned, got %d", id, len(out.Shards))
 	}
-	return out.Shards[0], nil
+	return &out.Shards[0], nil
 }
 
 func (kc *streamReader) startReadingShardByID(shardID string) {
@@ -227,7 +350,157 @@ func (kc *streamReader) startReadingShardByID(shardID string) {
 	}()
 }
 
-func (kc *streamReader) newShardReader(shardID string, getShard func(string) (*kinesis.Shard, error)) (*shardReader, error) {
+// startReadingChildShards starts reads of the given child shards, which are returned upon reaching
+// the end of a shard. When leasing, this only creates the leases of the child shards, which may be
+// taken by any worker once all of their parents have been read.
+func (kc *streamReader) startReadingChildShards(children []types.ChildShard) error {
+	if kc.leases != nil {
+		return kc.leases.ensureLeases(kc.ctx, childShardLeases(children))
+	}
+	for i := range children {
+		var childShard = &children[i]
+		if parentID, ok := kc.pendingParent(childShard); ok {
+			log.WithFields(log.Fields{
+				"kinesisStream":        kc.stream,
+				"kinesisShardId":       *childShard.ShardId,
+				"kinesisParentShardId": parentID,
+			}).Debug("Not reading child shard yet since another of its parents is still being read")
+			continue
+		}
+		kc.startReadingShardByID(*childShard.ShardId)
+	}
+	return nil
+}
+
+// leasedRead is an ongoing read of a kinesis shard whose lease is held by this worker.
+type leasedRead struct {
+	cancel context.CancelFunc
+}
+
+// startLeasedReads creates leases for all of the shards of the stream and then begins coordinating
+// with other workers in the background, reading the shards whose leases are acquired.
+func (kc *streamReader) startLeasedReads() error {
+	var shards, err = kc.listAllShards()
+	if err != nil {
+		return fmt.Errorf("listing kinesis shards: %w", err)
+	} else if len(shards) == 0 {
+		return fmt.Errorf("no kinesis shards found for the given stream")
+	}
+	var listed []*types.Shard
+	for _, shard := range shards {
+		listed = append(listed, shard)
+	}
+	if err := kc.leases.ensureLeases(kc.ctx, shardLeases(listed)); err != nil {
+		return fmt.Errorf("creating shard leases: %w", err)
+	}
+	log.WithFields(log.Fields{
+		"kinesisStream": kc.stream,
+		"workerId":      kc.leases.workerID,
+	}).Infof("Will read kinesis shards according to leases in DynamoDB table %q", kc.leases.table.name)
+
+	kc.waitGroup.Add(1)
+	go kc.coordinateLeases()
+	return nil
+}
+
+// coordinateLeases periodically rebalances shard leases, starting and stopping reads of kinesis
+// shards as their leases are acquired and lost. It runs until the context is cancelled, at which
+// point all held leases are released.
+func (kc *streamReader) coordinateLeases() {
+	defer kc.waitGroup.Done()
+	defer func() {
+		var ctx, cancel = context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		kc.leases.releaseAll(ctx)
+	}()
+
+	var ticker = time.NewTicker(kc.leases.duration / 3)
+	defer ticker.Stop()
+	for {
+		var acquired, lost, err = kc.leases.rebalance(kc.ctx)
+		for _, shardID := range lost {
+			kc.stopLeasedRead(shardID)
+		}
+		if err != nil {
+			if kc.ctx.Err() == nil {
+				select {
+				case kc.dataCh <- readResult{
+					source: &recordSource{stream: kc.stream},
+					err:    fmt.Errorf("coordinating shard leases: %w", err),
+				}:
+				case <-kc.ctx.Done():
+				}
+			}
+			return
+		}
+		for _, lease := range acquired {
+			kc.startLeasedRead(lease)
+		}
+
+		select {
+		case <-kc.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// startLeasedRead begins reading a kinesis shard whose lease was just acquired, starting after
+// whichever is later of the state's sequence number and the checkpoint of the lease.
+func (kc *streamReader) startLeasedRead(lease shardLease) {
+	var ctx, cancel = context.WithCancel(kc.ctx)
+	var read = &leasedRead{cancel: cancel}
+	kc.readingShardsMutex.Lock()
+	kc.leasedReads[lease.shardID] = read
+	kc.readingShardsMutex.Unlock()
+
+	kc.waitGroup.Add(1)
+	go func() {
+		defer kc.waitGroup.Done()
+		defer cancel()
+
+		var reader, err = kc.newShardReader(lease.shardID, kc.getShard)
+		if err != nil {
+			select {
+			case <-kc.ctx.Done():
+			case kc.dataCh <- readResult{
+				source: &recordSource{stream: kc.stream, shardID: lease.shardID},
+				err:    err,
+			}:
+			}
+			return
+		} else if reader == nil {
+			return
+		}
+		reader.ctx = ctx
+		reader.lastSequenceID = laterSequenceNumber(reader.lastSequenceID, lease.checkpoint)
+		reader.readShard()
+		if reader.finished {
+			kc.leases.finish(lease.shardID)
+		}
+
+		// Allow the shard to be read again if its lease is re-acquired later.
+		kc.readingShardsMutex.Lock()
+		if kc.leasedReads[lease.shardID] == read {
+			delete(kc.leasedReads, lease.shardID)
+			delete(kc.readingShards, lease.shardID)
+		}
+		kc.readingShardsMutex.Unlock()
+	}()
+}
+
+// stopLeasedRead stops the read of a kinesis shard whose lease has been lost.
+func (kc *streamReader) stopLeasedRead(shardID string) {
+	kc.readingShardsMutex.Lock()
+	defer kc.readingShardsMutex.Unlock()
+	if read, ok := kc.leasedReads[shardID]; ok {
+		read.cancel()
+		delete(kc.leasedReads, shardID)
+		delete(kc.readingShards, shardID)
+	}
+}
+
+func (kc *streamReader) newShardReader(shardID string, getShard func(string) (*types.Shard, error)) (*shardReader, error) {
 	var shard, err = getShard(shardID)
 	if err != nil {
 		return nil, fmt.Errorf("getting shard: %w", err)
@@ -239,8 +512,9 @@ func (kc *streamReader) newShardReader(shardID string, getShard func(string) (*k
 		"captureRangeEnd":   kc.shardRange.End,
 	})
 	var source = &recordSource{
-		stream:  kc.stream,
-		shardID: *shard.ShardId,
+		stream:         kc.stream,
+		shardID:        *shard.ShardId,
+		parentShardIDs: shardParents(shard),
 	}
 	kinesisRange, err := parseKinesisShardRange(*shard.HashKeyRange.StartingHashKey, *shard.HashKeyRange.EndingHashKey)
 	if err != nil {
@@ -250,6 +524,9 @@ func (kc *streamReader) newShardReader(shardID string, getShard func(string) (*k
 	if rangeResult == airbyte.NoRangeOverlap {
 		logEntry.Info("Will not read kinesis shard because it falls outside of our hash range")
 		return nil, nil
+	} else if kc.leases == nil && kc.finishedShards[*shard.ShardId] {
+		logEntry.Info("Will not read kinesis shard because it has already been read completely")
+		return nil, nil
 	}
 
 	// Kinesis shards can merge or split, forming new child shards. We need to guard against reading
@@ -270,6 +547,7 @@ func (kc *streamReader) newShardReader(shardID string, getShard func(string) (*k
 	}
 
 	return &shardReader{
+		ctx:               kc.ctx,
 		rangeOverlap:      rangeResult,
 		kinesisShardRange: kinesisRange,
 		parent:            kc,
@@ -290,16 +568,14 @@ func (kc *streamReader) newShardReader(shardID string, getShard func(string) (*k
 }
 
 func isMissingResource(err error) bool {
-	switch err.(type) {
-	case *kinesis.ResourceNotFoundException:
-		return true
-	default:
-		return false
-	}
+	var notFound *types.ResourceNotFoundException
+	return errors.As(err, &notFound)
 }
 
 // A reader of an individual kinesis shard.
 type shardReader struct {
+	// ctx is the context of the read, which may be cancelled independently of the stream's context.
+	ctx          context.Context
 	rangeOverlap airbyte.RangeOverlap
 	// The key hash range of the kinesis shard, which has been translated from 128bit to the 32bit
 	// space.
@@ -307,14 +583,57 @@ type shardReader struct {
 	parent            *streamReader
 	source            *recordSource
 	lastSequenceID    string
-	noDataBackoff     noDataBackoff
-	limitPerReq       int64
-	logEntry          *log.Entry
+	// lastArrivalAt is the approximate arrival time of the last record that was read, and
+	// lastCheckpointAt is when the position of the shard was last sent.
+	lastArrivalAt    time.Time
+	lastCheckpointAt time.Time
+	noDataBackoff    noDataBackoff
+	limitPerReq      int32
+	logEntry         *log.Entry
+	// corrupt is the number of records which have been skipped because they couldn't be
+	// decompressed.
+	corrupt int64
+	// unparsed is the number of records which have been captured in an envelope because they
+	// aren't JSON objects.
+	unparsed int64
+	// filtered is the number of records which have been dropped by the filter.
+	filtered int64
+	// duplicates is the number of records which have been dropped by the dedup window.
+	duplicates int64
+	// untimestamped is the number of records which have been emitted with the current time
+	// because their configured timestamp wasn't available.
+	untimestamped int64
+	// finished is set once the end of the shard has been reached, or it no longer exists.
+	finished bool
+	// resuming is set until the first shard iterator has been obtained, if the read is resumed
+	// after a stored sequence number.
+	resuming bool
+	// startingPosition is the iterator type used to read the shard after its stored sequence number
+	// was rejected. Otherwise a shard without a sequence number is read from the configured start.
+	startingPosition types.ShardIteratorType
+	// initial is set if the shard was listed when the capture started, rather than being a child
+	// shard which is read after its parents.
+	initial bool
+	// partialSequenceNumber is the sequence number of an aggregated record whose user records were
+	// emitted up to partialSubSequenceNumber before the read was resumed, and which are skipped.
+	partialSequenceNumber    string
+	partialSubSequenceNumber int
 }
 
 func (r *shardReader) readShard() {
 	r.logEntry.WithField("RangeOverlap", r.rangeOverlap).Info("Starting read")
-	defer r.logEntry.Info("Finished reading kinesis shard")
+	r.resuming = r.lastSequenceID != ""
+	r.lastCheckpointAt = time.Now()
+	defer func() {
+		r.logEntry.WithFields(log.Fields{
+			"corruptRecords":       r.corrupt,
+			"unparsedRecords":      r.unparsed,
+			"filteredRecords":      r.filtered,
+			"duplicateRecords":     r.duplicates,
+			"untimestampedRecords": r.untimestamped,
+		}).Info("Finished reading kinesis shard")
+		r.parent.lag.forget(r.source)
+	}()
 
 	for {
 		var shardIter, err = r.getShardIterator()
@@ -326,11 +645,21 @@ func (r *shardReader) readShard() {
 			// re-sharding.
 			if err = r.readShardIterator(shardIter); err == nil || isContextCanceled(err) {
 				return
+			} else if errors.Is(err, errIteratorTooOld) {
+				// We spent long enough waiting for in-flight capacity that the iterator may have
+				// expired, so just get a new one starting after the last record we sent.
+				r.logEntry.Debug("refreshing kinesis shardIterator after waiting on backpressure")
 			} else {
 				// Don't wait before retrying, since the previous failure was from GetRecords and
 				// the next call will be to GetShardIterator, which have separate rate limits.
 				r.logEntry.WithField("error", err).Warn("reading kinesis shardIterator returned error (will retry)")
 			}
+		} else if errors.Is(err, errInvalidSequence) {
+			select {
+			case r.parent.dataCh <- readResult{source: r.source, err: err}:
+			case <-r.ctx.Done():
+			}
+			return
 		} else if isMissingResource(err) {
 			// This means that the shard fell off the end of the kinesis retention period sometime
 			// after we started trying to read it. This is probably not indicative of any problem,
@@ -338,6 +667,8 @@ func (r *shardReader) readShard() {
 			// last record in the shard expires. Log it just because it's expected to be exceedingly
 			// rare, and I want to know if it happens with any frequency.
 			r.logEntry.Info("Stopping read of kinesis shard because it has been deleted")
+			r.finished = true
+			r.parent.markDrained(r.source.shardID)
 			return
 		} else {
 			// oh well, we tried. Time to call it a day
@@ -356,56 +687,144 @@ func (r *shardReader) readShardIterator(iteratorID string) error {
 	// data available. The rate limiter helps prevent sending requests that would likely result in a
 	// rate limit error anyway. But we still expect and handle such errors.
 	var limiter = rate.NewLimiter(rate.Every(time.Second), 5)
+	var iteratorObtainedAt = time.Now()
 	for shardIter != nil && (*shardIter) != "" {
-		if err := limiter.Wait(r.parent.ctx); err != nil {
+		if err := limiter.Wait(r.ctx); err != nil {
+			return err
+		}
+		// Reserve room for as many records as we're about to request. This blocks while the
+		// consumer is behind, so we check afterwards that the iterator is still usable.
+		var reserved, err = r.parent.inFlight.acquire(r.ctx, int64(r.limitPerReq))
+		if err != nil {
 			return err
 		}
+		if time.Since(iteratorObtainedAt) > maxShardIteratorAge {
+			r.parent.inFlight.release(reserved)
+			return errIteratorTooOld
+		}
+		var limit = int32(reserved)
 		var getRecordsReq = kinesis.GetRecordsInput{
 			ShardIterator: shardIter,
-			Limit:         &r.limitPerReq,
+			Limit:         &limit,
 		}
-		var getRecordsResp, err = r.parent.client.GetRecordsWithContext(r.parent.ctx, &getRecordsReq)
+		var requestedAt = time.Now()
+		getRecordsResp, err := r.parent.client.GetRecords(r.ctx, &getRecordsReq)
 		if err != nil {
+			r.parent.inFlight.release(reserved)
 			r.logEntry.WithField("error", err).Warn("reading kinesis shard iterator failed")
 			return err
 		}
+		iteratorObtainedAt = time.Now()
+
+		if err := r.parent.lag.observe(r.source, getRecordsResp.MillisBehindLatest); err != nil {
+			r.parent.inFlight.release(reserved)
+			select {
+			case r.parent.dataCh <- readResult{source: r.source, err: err}:
+			case <-r.ctx.Done():
+			}
+			return nil
+		}
 
 		// If the response includes ChildShards, then this means that we've reached the end of the
-		// shard because it has been either split or merged, so we need to start new reads of the
-		// child shards.
-		for _, childShard := range getRecordsResp.ChildShards {
-			r.parent.startReadingShardByID(*childShard.ShardId)
+		// shard because it has been either split or merged. New reads of the child shards are
+		// started below, once the final records of this shard have been sent.
+		var childShardIDs []string
+		for _, child := range getRecordsResp.ChildShards {
+			childShardIDs = append(childShardIDs, *child.ShardId)
 		}
 
 		if len(getRecordsResp.Records) > 0 {
 			r.noDataBackoff.reset()
 			r.updateRecordLimit(getRecordsResp)
 
-			var lastSequenceID = *getRecordsResp.Records[len(getRecordsResp.Records)-1].SequenceNumber
-			var msg = readResult{
-				source:         r.source,
-				records:        r.extractRecords(getRecordsResp),
-				err:            err,
-				sequenceNumber: lastSequenceID,
+			var lastRecord = getRecordsResp.Records[len(getRecordsResp.Records)-1]
+			var lastSequenceID = *lastRecord.SequenceNumber
+			if lastRecord.ApproximateArrivalTimestamp != nil {
+				r.lastArrivalAt = *lastRecord.ApproximateArrivalTimestamp
+			}
+			records, positions, timestamps, err := r.extractRecords(getRecordsResp)
+			if err != nil {
+				// Retrying won't help with a record that can't be processed, so fail the capture.
+				r.parent.inFlight.release(reserved)
+				select {
+				case r.parent.dataCh <- readResult{source: r.source, err: err}:
+				case <-r.ctx.Done():
+				}
+				return nil
+			}
+			// Aggregated kinesis records may hold many more user records than were reserved, in
+			// which case they're sent in batches of no more than the reservation, and more is
+			// reserved for each following batch. Each batch but the final one is covered by the
+			// position of its last record, which may be partway through an aggregated record.
+			for {
+				var n = len(records)
+				var msg = readResult{
+					source:         r.source,
+					sequenceNumber: lastSequenceID,
+					childShardIDs:  childShardIDs,
+				}
+				if int64(n) > reserved {
+					n = int(reserved)
+					msg.sequenceNumber, msg.childShardIDs = positions[n-1], nil
+				}
+				msg.records = records[:n]
+				if timestamps != nil {
+					msg.timestamps = timestamps[:n]
+				}
+				// The remaining reservation is released by the consumer once the records are written.
+				r.parent.inFlight.release(reserved - int64(n))
+				select {
+				case r.parent.dataCh <- msg:
+					r.lastSequenceID = msg.sequenceNumber
+					r.lastCheckpointAt = time.Now()
+				case <-r.ctx.Done():
+					r.parent.inFlight.release(int64(n))
+					return nil
+				}
+
+				records, positions = records[n:], positions[n:]
+				if timestamps != nil {
+					timestamps = timestamps[n:]
+				}
+				if len(records) == 0 {
+					break
+				} else if reserved, err = r.parent.inFlight.acquire(r.ctx, int64(len(records))); err != nil {
+					return err
+				}
 			}
+		} else if len(childShardIDs) != 0 {
+			// The end of the shard was reached without any further records, which still needs to
+			// be reported so that the state of the shard can be compacted.
+			r.parent.inFlight.release(reserved)
 			select {
-			case r.parent.dataCh <- msg:
-				r.lastSequenceID = lastSequenceID
-			case <-r.parent.ctx.Done():
+			case r.parent.dataCh <- readResult{source: r.source, childShardIDs: childShardIDs}:
+			case <-r.ctx.Done():
 				return nil
 			}
 		} else {
+			r.parent.inFlight.release(reserved)
 			// Are we behind the tip of the shard? If so, then we'll make another request as soon as
 			// we can. If we're caught up with the tip of the shard and there's still no data, then
 			// we'll start applying a backoff so that we can releive some pressure on the network
 			// when a kinesis shard has a lower data volume.
 			if getRecordsResp.MillisBehindLatest != nil && *getRecordsResp.MillisBehindLatest > 0 {
 				r.noDataBackoff.reset()
+			} else if !r.maybeHeartbeat(getRecordsResp, requestedAt) {
+				return nil
 			} else {
 				<-r.noDataBackoff.nextBackoff()
 			}
 		}
 
+		// The child shards are only read after every record of their parents has been sent, so
+		// that records aren't emitted out of order across the split or merge.
+		if len(childShardIDs) != 0 {
+			r.parent.markDrained(r.source.shardID)
+			if err := r.parent.startReadingChildShards(getRecordsResp.ChildShards); err != nil {
+				return err
+			}
+		}
+
 		// If the connector is not in tailing mode, then we'll check to see if we've read all the
 		// records up through the timestamp of the desired stop point.
 		if r.parent.stopAt != nil {
@@ -432,28 +851,102 @@ func (r *shardReader) readShardIterator(iteratorID string) error {
 		// NextShardIterator will be empty, causing us to exit this loop and finish the read.
 		shardIter = getRecordsResp.NextShardIterator
 	}
+	r.finished = true
 	return nil
 }
 
-// Extracts the records from a response, filtering the records if necessary due to claiming partial
-// ownership over the kinesis shard.
-func (r *shardReader) extractRecords(resp *kinesis.GetRecordsOutput) []json.RawMessage {
-	if r.rangeOverlap == airbyte.PartialRangeOverlap {
-		var result []json.RawMessage
-		for _, rec := range resp.Records {
-			var keyHash = hashPartitionKey(*rec.PartitionKey)
-			if isRecordWithinRange(r.parent.shardRange, r.kinesisShardRange, keyHash) {
-				result = append(result, json.RawMessage(rec.Data))
-			}
+// Extracts the user records from a response, deaggregating records which were put by the Kinesis
+// Producer Library, decompressing their payloads if they're compressed, wrapping payloads which
+// aren't JSON objects in an envelope if JSON parsing is enabled, filtering the records if necessary
+// due to claiming partial ownership over the kinesis shard, not matching the record filter, or
+// duplicating a record within the dedup window, adding their keys if key extraction is enabled, and
+// applying the size limit policy to oversized records. The position of each record is returned
+// along with it, as is its timestamp if records have timestamps other than the current time.
+func (r *shardReader) extractRecords(resp *kinesis.GetRecordsOutput) ([]json.RawMessage, []string, []time.Time, error) {
+	var result = make([]json.RawMessage, 0, len(resp.Records))
+	var positions = make([]string, 0, len(resp.Records))
+	var timestamps []time.Time
+	if r.parent.timestamps != nil {
+		timestamps = make([]time.Time, 0, len(resp.Records))
+	}
+	for i := range resp.Records {
+		var userRecords, err = deaggregate(&resp.Records[i])
+		if err != nil {
+			return nil, nil, nil, err
 		}
-		return result
-	} else {
-		var result = make([]json.RawMessage, len(resp.Records))
-		for i, rec := range resp.Records {
-			result[i] = json.RawMessage(rec.Data)
+		for _, rec := range userRecords {
+			if rec.sequenceNumber == r.partialSequenceNumber && rec.subSequenceNumber <= r.partialSubSequenceNumber {
+				continue // The record was emitted before the read was resumed.
+			}
+			if r.rangeOverlap == airbyte.PartialRangeOverlap {
+				var keyHash = hashPartitionKey(rec.partitionKey)
+				if !isRecordWithinRange(r.parent.shardRange, r.kinesisShardRange, keyHash) {
+					continue
+				}
+			}
+			if rec.data, err = r.parent.decompressor.decompress(rec.data); err != nil {
+				if !r.parent.decompressor.skipCorrupt() {
+					return nil, nil, nil, fmt.Errorf("record %s: %w", rec.position(), err)
+				}
+				r.logEntry.WithFields(log.Fields{
+					"sequenceNumber": rec.position(),
+					"error":          err,
+				}).Warn("skipping record which could not be decompressed")
+				r.corrupt++
+				continue
+			}
+			if r.parent.parseJSON {
+				var parsed bool
+				if rec.data, parsed, err = parseJSONRecord(rec.data); err != nil {
+					return nil, nil, nil, fmt.Errorf("record %s: %w", rec.position(), err)
+				} else if !parsed {
+					r.unparsed++
+				}
+			}
+			if ok, err := r.parent.filter.matches(rec.data); err != nil {
+				return nil, nil, nil, fmt.Errorf("record %s: %w", rec.position(), err)
+			} else if !ok {
+				r.filtered++
+				continue
+			}
+			if dup, err := r.parent.dedup.isDuplicate(r.parent.stream, rec.data); err != nil {
+				return nil, nil, nil, fmt.Errorf("record %s: %w", rec.position(), err)
+			} else if dup {
+				r.duplicates++
+				continue
+			}
+			var data, err = r.parent.keys.addKey(rec.data, rec.partitionKey)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("record %s: %w", rec.position(), err)
+			}
+			limited, err := r.parent.sizeLimit.apply(data)
+			if err != nil {
+				return nil, nil, nil, fmt.Errorf("record %s: %w", rec.position(), err)
+			} else if limited == nil {
+				r.logEntry.WithFields(log.Fields{
+					"sequenceNumber": rec.position(),
+					"size":           len(data),
+				}).Warn("skipping record which exceeds maxRecordBytes")
+				continue
+			} else if len(limited) != len(data) {
+				r.logEntry.WithFields(log.Fields{
+					"sequenceNumber": rec.position(),
+					"size":           len(data),
+					"truncatedSize":  len(limited),
+				}).Warn("truncated record which exceeds maxRecordBytes")
+			}
+			if timestamps != nil {
+				var ts, ok = r.parent.timestamps.timestamp(rec.data, resp.Records[i].ApproximateArrivalTimestamp)
+				if !ok {
+					r.untimestamped++
+				}
+				timestamps = append(timestamps, ts)
+			}
+			result = append(result, limited)
+			positions = append(positions, rec.position())
 		}
-		return result
 	}
+	return result, positions, timestamps, nil
 }
 
 // Updates the Limit used for GetRecords requests. The goal is to always set the limit such that we
@@ -470,7 +963,7 @@ func (r *shardReader) updateRecordLimit(resp *kinesis.GetRecordsOutput) {
 	}
 	var avg int = totalBytes / len(resp.Records)
 	var desiredLimit = (1024 * 1024) / avg
-	var diff = int64(desiredLimit) - r.limitPerReq
+	var diff = int32(desiredLimit) - r.limitPerReq
 
 	// Only move a fraction of the distance toward the desiredLimit. This helps even out big jumps
 	// in average data size from request to request. If the average is fairly consistent, then we'll
@@ -490,35 +983,69 @@ func (r *shardReader) getShardIterator() (string, error) {
 		StreamName: &r.parent.stream,
 		ShardId:    &r.source.shardID,
 	}
-	if r.lastSequenceID != "" {
-		shardIterReq.StartingSequenceNumber = &r.lastSequenceID
-		shardIterReq.ShardIteratorType = &START_AFTER_SEQ
+	r.partialSequenceNumber = ""
+	var position, readThrough, err = parseHeartbeatPosition(r.lastSequenceID)
+	if err != nil {
+		return "", fmt.Errorf("kinesis shard %q: %w", r.source.shardID, err)
+	}
+	if !readThrough.IsZero() {
+		// The shard was idle when its position was last stored, and every record which arrived
+		// before the time of the heartbeat has been read.
+		r.logEntry.WithFields(log.Fields{
+			"sequenceNumber": position,
+			"timestamp":      readThrough,
+		}).Debug("resuming read of kinesis shard from heartbeat position")
+		shardIterReq.ShardIteratorType = types.ShardIteratorTypeAtTimestamp
+		shardIterReq.Timestamp = &readThrough
+	} else if position != "" {
+		var sequenceNumber, subSequenceNumber, err = parsePosition(position)
+		if err != nil {
+			return "", fmt.Errorf("kinesis shard %q: %w", r.source.shardID, err)
+		}
+		shardIterReq.StartingSequenceNumber = &sequenceNumber
+		if subSequenceNumber == -1 {
+			shardIterReq.ShardIteratorType = types.ShardIteratorTypeAfterSequenceNumber
+		} else {
+			// The read stopped partway through the user records of an aggregated record, which is
+			// read again while skipping those that were already emitted.
+			shardIterReq.ShardIteratorType = types.ShardIteratorTypeAtSequenceNumber
+			r.partialSequenceNumber, r.partialSubSequenceNumber = sequenceNumber, subSequenceNumber
+		}
+	} else if r.startingPosition != "" {
+		shardIterReq.ShardIteratorType = r.startingPosition
 	} else {
-		shardIterReq.ShardIteratorType = &START_AT_BEGINNING
+		r.parent.start.apply(&shardIterReq, r.initial)
 	}
 
-	shardIterResp, err := r.parent.client.GetShardIteratorWithContext(r.parent.ctx, &shardIterReq)
-	if err != nil {
+	shardIterResp, err := r.parent.client.GetShardIterator(r.ctx, &shardIterReq)
+	if err != nil && r.resuming && isInvalidSequence(err) {
+		// The stored sequence number may have fallen off the end of the stream's retention period
+		// while the capture wasn't running.
+		if r.startingPosition, err = r.recoverInvalidSequence(err); err != nil {
+			return "", err
+		}
+		r.resuming = false
+		return r.getShardIterator()
+	} else if err != nil {
 		return "", err
 	}
+	r.resuming = false
 	return *shardIterResp.ShardIterator, nil
 }
 
-var (
-	START_AFTER_SEQ    = "AFTER_SEQUENCE_NUMBER"
-	START_AT_BEGINNING = "TRIM_HORIZON"
-)
+// maxShardIteratorAge is how long we'll hold onto a shard iterator before getting a new one. Kinesis
+// expires iterators 5 minutes after they're returned, and a read that is blocked on backpressure
+// could otherwise sit on one until it's no longer valid.
+const maxShardIteratorAge = 4 * time.Minute
+
+// errIteratorTooOld is returned from readShardIterator when the current iterator should be replaced.
+var errIteratorTooOld = errors.New("shard iterator is too old to be used")
 
 // isContextCanceled returns true if the error is due to a context cancelation.
 // The AWS SDK will wrap context.Canceled errors, sometimes in multiple layers,
 // which is what this function is meant to deal with.
 func isContextCanceled(err error) bool {
-	switch typed := err.(type) {
-	case awserr.Error:
-		return typed.Code() == "RequestCanceled" || isContextCanceled(typed.OrigErr())
-	default:
-		return err == context.Canceled
-	}
+	return errors.Is(err, context.Canceled)
 }
 
 // noDataBackoff is a simple exponential noDataBackoff implementation for use exclusively when the kinesis
@@ -18,13 +18,14 @@ import (
 	"io/ioutil"
 	"math"
 	"math/rand"
+	"os"
 	"path"
 	"sync"
 	"testing"
 	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
 	"github.com/estuary/flow/go/protocols/airbyte"
 	"github.com/stretchr/testify/require"
 )
@@ -63,23 +64,23 @@ func TestKinesisCaptureWithShardOverlap(t *testing.T) {
 	var conf = Config{}
 	var err = configFile.Parse(&conf)
 	require.NoError(t, err)
-	client, err := connect(&conf)
+	client, err := connect(context.Background(), &conf)
 	require.NoError(t, err)
 
 	var stream = "test-" + randAlpha(6)
-	var testShards int64 = 3
+	var testShards int32 = 3
 	var createStreamReq = &kinesis.CreateStreamInput{
 		StreamName: &stream,
 		ShardCount: &testShards,
 	}
-	_, err = client.CreateStream(createStreamReq)
+	_, err = client.CreateStream(context.Background(), createStreamReq)
 	require.NoError(t, err, "failed to create stream")
 
 	defer func() {
 		var deleteStreamReq = kinesis.DeleteStreamInput{
 			StreamName: &stream,
 		}
-		var _, err = client.DeleteStream(&deleteStreamReq)
+		var _, err = client.DeleteStream(context.Background(), &deleteStreamReq)
 		require.NoError(t, err, "failed to delete stream")
 	}()
 	awaitStreamActive(t, client, stream)
@@ -94,14 +95,14 @@ func TestKinesisCaptureWithShardOverlap(t *testing.T) {
 		End:   math.MaxUint32 / 2,
 	}
 	waitGroup.Add(1)
-	go readStream(ctx, shard1Range, client, stream, nil, dataCh, nil, waitGroup)
+	go readStream(ctx, shard1Range, client, stream, nil, dataCh, newInFlightLimiter(defaultMaxInFlightRecords), nil, readerOptions{}, nil, waitGroup)
 
 	var shard2Range = airbyte.Range{
 		Begin: math.MaxUint32 / 2,
 		End:   math.MaxUint32,
 	}
 	waitGroup.Add(1)
-	go readStream(ctx, shard2Range, client, stream, nil, dataCh, nil, waitGroup)
+	go readStream(ctx, shard2Range, client, stream, nil, dataCh, newInFlightLimiter(defaultMaxInFlightRecords), nil, readerOptions{}, nil, waitGroup)
 
 	var partitionKeys = []string{"furst", "sekund", "thuurd", "phorth"}
 	var sequencNumbers = make(map[string]string)
@@ -117,7 +118,7 @@ func TestKinesisCaptureWithShardOverlap(t *testing.T) {
 		}
 
 		var doPut = func() bool {
-			var resp, putErr = client.PutRecord(input)
+			var resp, putErr = client.PutRecord(context.Background(), input)
 			if putErr == nil {
 				sequencNumbers[partitionKey] = *resp.SequenceNumber
 			}
@@ -160,23 +161,23 @@ func TestKinesisCapture(t *testing.T) {
 	var conf = Config{}
 	var err = configFile.ConfigFile.Parse(&conf)
 	require.NoError(t, err)
-	client, err := connect(&conf)
+	client, err := connect(context.Background(), &conf)
 	require.NoError(t, err)
 
 	var stream = "test-" + randAlpha(6)
-	var testShards int64 = 1
+	var testShards int32 = 1
 	var createStreamReq = &kinesis.CreateStreamInput{
 		StreamName: &stream,
 		ShardCount: &testShards,
 	}
-	_, err = client.CreateStream(createStreamReq)
+	_, err = client.CreateStream(context.Background(), createStreamReq)
 	require.NoError(t, err, "failed to create stream")
 
 	defer func() {
 		var deleteStreamReq = kinesis.DeleteStreamInput{
 			StreamName: &stream,
 		}
-		var _, err = client.DeleteStream(&deleteStreamReq)
+		var _, err = client.DeleteStream(context.Background(), &deleteStreamReq)
 		require.NoError(t, err, "failed to delete stream")
 	}()
 	awaitStreamActive(t, client, stream)
@@ -206,6 +207,15 @@ func TestKinesisCapture(t *testing.T) {
 		}
 	}
 	require.NotNil(t, discoveredStream, "missing expected stream")
+
+	// The discovered schema is annotated with the metadata of the stream.
+	var discoveredSchema struct {
+		Metadata *streamMetadata `json:"x-kinesis-stream"`
+	}
+	require.NoError(t, json.Unmarshal(discoveredStream.JSONSchema, &discoveredSchema))
+	require.NotNil(t, discoveredSchema.Metadata, "missing stream metadata")
+	require.Contains(t, discoveredSchema.Metadata.ARN, stream)
+	require.Equal(t, int64(testShards), discoveredSchema.Metadata.OpenShardCount)
 	var configuredCatalog = airbyte.ConfiguredCatalog{
 		Streams: []airbyte.ConfiguredStream{{
 			Stream:   *discoveredStream,
@@ -231,7 +241,7 @@ func TestKinesisCapture(t *testing.T) {
 	var reader, writer = io.Pipe()
 
 	go func() {
-		var failure = readStreamsTo(ctx, readArgs, writer)
+		var failure = readStreamsTo(ctx, readArgs, json.NewEncoder(writer))
 		writer.Close()
 		if failure != nil {
 			fmt.Printf("readStreamsTo failed with error: %v\n", failure)
@@ -247,7 +257,7 @@ func TestKinesisCapture(t *testing.T) {
 			PartitionKey: aws.String("wat"),
 		}
 		require.Eventually(t, func() bool {
-			out, err := client.PutRecord(&input)
+			out, err := client.PutRecord(context.Background(), &input)
 			if err == nil {
 				lastSeq = *out.SequenceNumber
 				shardId = *out.ShardId
@@ -288,13 +298,179 @@ func TestKinesisCapture(t *testing.T) {
 	require.Equal(t, lastSeq, streamResult[shardId])
 }
 
+func TestKinesisCaptureShardNamespace(t *testing.T) {
+	var conf = Config{}
+	require.NoError(t, airbyte.JSONFile("testdata/kinesis-config.json").Parse(&conf))
+	client, err := connect(context.Background(), &conf)
+	require.NoError(t, err)
+
+	var stream = "test-" + randAlpha(6)
+	var testShards int32 = 2
+	_, err = client.CreateStream(context.Background(), &kinesis.CreateStreamInput{
+		StreamName: &stream,
+		ShardCount: &testShards,
+	})
+	require.NoError(t, err, "failed to create stream")
+	defer func() {
+		var _, err = client.DeleteStream(context.Background(), &kinesis.DeleteStreamInput{StreamName: &stream})
+		require.NoError(t, err, "failed to delete stream")
+	}()
+	awaitStreamActive(t, client, stream)
+
+	tmpDir, err := ioutil.TempDir("", "kinesis-capture-test-")
+	require.NoError(t, err)
+
+	conf.ShardNamespace = true
+	configJson, err := json.Marshal(&conf)
+	require.NoError(t, err)
+	var configFile = path.Join(tmpDir, "config.json")
+	require.NoError(t, ioutil.WriteFile(configFile, configJson, 0644))
+
+	catalogJson, err := json.Marshal(&airbyte.ConfiguredCatalog{
+		Streams: []airbyte.ConfiguredStream{{
+			Stream:   airbyte.Stream{Name: stream, JSONSchema: json.RawMessage(`{"type":"object"}`)},
+			SyncMode: airbyte.SyncModeIncremental,
+		}},
+		Tail: true,
+	})
+	require.NoError(t, err)
+	var catalogFile = path.Join(tmpDir, "catalog.json")
+	require.NoError(t, ioutil.WriteFile(catalogFile, catalogJson, 0644))
+
+	var ctx, cancelFunc = context.WithCancel(context.Background())
+	defer cancelFunc()
+	var reader, writer = io.Pipe()
+	go func() {
+		var failure = readStreamsTo(ctx, airbyte.ReadCmd{
+			ConfigFile:  airbyte.ConfigFile{ConfigFile: airbyte.JSONFile(configFile)},
+			CatalogFile: airbyte.JSONFile(catalogFile),
+		}, json.NewEncoder(writer))
+		writer.Close()
+		if failure != nil {
+			fmt.Printf("readStreamsTo failed with error: %v\n", failure)
+		}
+	}()
+	var decoder = json.NewDecoder(reader)
+
+	// putRecords puts records with distinct partition keys, and returns the id of the shard of
+	// each record, keyed by its counter.
+	var putRecords = func(from, to int) map[int]string {
+		var shardIDs = make(map[int]string)
+		for i := from; i < to; i++ {
+			var input = kinesis.PutRecordInput{
+				StreamName:   &stream,
+				Data:         []byte(fmt.Sprintf(`{"counter":%d}`, i)),
+				PartitionKey: aws.String(fmt.Sprintf("key-%d", i)),
+			}
+			require.Eventually(t, func() bool {
+				out, err := client.PutRecord(context.Background(), &input)
+				if err == nil {
+					shardIDs[i] = *out.ShardId
+				}
+				return err == nil
+			}, time.Second, time.Millisecond*20, "failed to put record")
+		}
+		return shardIDs
+	}
+	// expectNamespaces reads records until all of the expected ones have been read, and asserts
+	// that the namespace of each is the id of its shard.
+	var expectNamespaces = func(expected map[int]string) {
+		for found := 0; found < len(expected); {
+			var msg = airbyte.Message{}
+			require.NoError(t, decoder.Decode(&msg))
+			if msg.Record == nil {
+				continue
+			}
+			var doc struct{ Counter int }
+			require.NoError(t, json.Unmarshal(msg.Record.Data, &doc))
+			require.Equal(t, stream, msg.Record.Stream)
+			require.Equal(t, expected[doc.Counter], msg.Record.Namespace, "record %d", doc.Counter)
+			found++
+		}
+	}
+
+	var parentShards = putRecords(0, 12)
+	expectNamespaces(parentShards)
+	var parentIDs = make(map[string]bool)
+	for _, id := range parentShards {
+		parentIDs[id] = true
+	}
+	require.Len(t, parentIDs, 2, "expected records to be put to both shards")
+
+	// Once the shards are merged, records are put to the child shard, whose records have a new
+	// namespace.
+	shards, err := client.ListShards(context.Background(), &kinesis.ListShardsInput{StreamName: &stream})
+	require.NoError(t, err)
+	require.Len(t, shards.Shards, 2)
+	_, err = client.MergeShards(context.Background(), &kinesis.MergeShardsInput{
+		StreamName:           &stream,
+		ShardToMerge:         shards.Shards[0].ShardId,
+		AdjacentShardToMerge: shards.Shards[1].ShardId,
+	})
+	require.NoError(t, err)
+	awaitStreamActive(t, client, stream)
+
+	var childShards = putRecords(12, 18)
+	for _, id := range childShards {
+		require.False(t, parentIDs[id], "expected records to be put to the child shard")
+	}
+	expectNamespaces(childShards)
+}
+
+// TestCheckAndDiscoverWithEndpoint runs the check and discover paths against the endpoint of the
+// localstack container, which may be overridden by the LOCALSTACK_URL environment variable. The
+// config omits the region, which is optional when an endpoint is given.
+func TestCheckAndDiscoverWithEndpoint(t *testing.T) {
+	var conf = Config{}
+	require.NoError(t, airbyte.JSONFile("testdata/kinesis-config.json").Parse(&conf))
+	if url := os.Getenv("LOCALSTACK_URL"); url != "" {
+		conf.Endpoint = url
+	}
+	conf.Region = ""
+	client, err := connect(context.Background(), &conf)
+	require.NoError(t, err)
+
+	var stream = "test-" + randAlpha(6)
+	var testShards int32 = 1
+	_, err = client.CreateStream(context.Background(), &kinesis.CreateStreamInput{
+		StreamName: &stream,
+		ShardCount: &testShards,
+	})
+	require.NoError(t, err, "failed to create stream")
+	defer func() {
+		var _, err = client.DeleteStream(context.Background(), &kinesis.DeleteStreamInput{StreamName: &stream})
+		require.NoError(t, err, "failed to delete stream")
+	}()
+	awaitStreamActive(t, client, stream)
+
+	tmpDir, err := ioutil.TempDir("", "kinesis-endpoint-test-")
+	require.NoError(t, err)
+	defer os.RemoveAll(tmpDir)
+	configJson, err := json.Marshal(&conf)
+	require.NoError(t, err)
+	var configFile = path.Join(tmpDir, "config.json")
+	require.NoError(t, ioutil.WriteFile(configFile, configJson, 0644))
+
+	streams, err := tryListingStreams(airbyte.ConfigFile{ConfigFile: airbyte.JSONFile(configFile)})
+	require.NoError(t, err)
+	require.Contains(t, streams, stream)
+
+	catalog, err := discoverCatalog(airbyte.ConfigFile{ConfigFile: airbyte.JSONFile(configFile)})
+	require.NoError(t, err)
+	var discovered bool
+	for _, s := range catalog.Streams {
+		discovered = discovered || s.Name == stream
+	}
+	require.True(t, discovered, "expected stream %q to be discovered", stream)
+}
+
 // The kinesis stream could take a while before it becomes active, so this just polls until the
 // status indicates that it's active.
-func awaitStreamActive(t *testing.T, client *kinesis.Kinesis, stream string) {
+func awaitStreamActive(t *testing.T, client *kinesis.Client, stream string) {
 	var input = kinesis.DescribeStreamInput{
 		StreamName: &stream,
 	}
-	var err = client.WaitUntilStreamExists(&input)
+	var err = kinesis.NewStreamExistsWaiter(client).Wait(context.Background(), &input, 5*time.Minute)
 	require.NoError(t, err, "error waiting for kinesis stream to become active")
 }
 
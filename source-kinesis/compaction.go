@@ -0,0 +1,96 @@
+package main
+
+import (
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// shardParents returns the ids of the parents of a kinesis shard, of which there are two if the
+// shard was created by merging two others.
+func shardParents(shard *types.Shard) []string {
+	var parents []string
+	for _, parent := range []*string{shard.ParentShardId, shard.AdjacentParentShardId} {
+		if parent != nil {
+			parents = append(parents, *parent)
+		}
+	}
+	return parents
+}
+
+// stateCompactor removes the state entries of kinesis shards which have been read completely. A
+// shard's entry is removed once its final records have been written and one of its child shards
+// has an entry of its own. Every removed shard therefore has a descendant in the state, which is
+// how finishedShards identifies it as having been read when the capture is restarted.
+type stateCompactor struct {
+	// finished holds the child shards of each shard whose final records have been written but
+	// whose entry hasn't been removed yet, keyed by stream and then by shard.
+	finished map[string]map[string][]string
+}
+
+func newStateCompactor() *stateCompactor {
+	return &stateCompactor{finished: make(map[string]map[string][]string)}
+}
+
+// update is called with each readResult once the state has been updated from it, and removes the
+// entries of any shards which are no longer needed.
+func (c *stateCompactor) update(state stateMap, result readResult) {
+	var stream, shardID = result.source.stream, result.source.shardID
+	var streamFinished, ok = c.finished[stream]
+	if !ok {
+		streamFinished = make(map[string][]string)
+		c.finished[stream] = streamFinished
+	}
+	if len(result.childShardIDs) != 0 {
+		streamFinished[shardID] = result.childShardIDs
+	}
+
+	// Either this shard has just finished, or it may be the child of shards which had.
+	for _, id := range append([]string{shardID}, result.source.parentShardIDs...) {
+		var children, ok = streamFinished[id]
+		if !ok {
+			continue
+		}
+		for _, child := range children {
+			if _, ok := state[stream][child]; ok {
+				log.WithFields(log.Fields{
+					"kinesisStream":  stream,
+					"kinesisShardId": id,
+					"childShardId":   child,
+				}).Debug("removing state of finished kinesis shard")
+				delete(state[stream], id)
+				delete(streamFinished, id)
+				break
+			}
+		}
+	}
+}
+
+// finishedShards returns the listed shards which have been read completely, given the state of
+// the stream. These are the ancestors of shards in the state which aren't in the state themselves,
+// since a child shard is only read once its parents have been, and the entry of a finished shard
+// is only removed once one of its children has been added.
+func finishedShards(listing map[string]*types.Shard, state map[string]string) map[string]bool {
+	var finished = make(map[string]bool)
+	var visited = make(map[string]bool)
+	var visit func(shard *types.Shard)
+	visit = func(shard *types.Shard) {
+		for _, parentID := range shardParents(shard) {
+			if visited[parentID] {
+				continue
+			}
+			visited[parentID] = true
+			if _, ok := state[parentID]; !ok {
+				finished[parentID] = true
+			}
+			if parent, ok := listing[parentID]; ok {
+				visit(parent)
+			}
+		}
+	}
+	for shardID := range state {
+		if shard, ok := listing[shardID]; ok {
+			visit(shard)
+		}
+	}
+	return finished
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStateCompactionWithResharding(t *testing.T) {
+	const stream = "test-stream"
+	var state = make(stateMap)
+	var compactor = newStateCompactor()
+	var listing = make(map[string]*types.Shard)
+	// done holds the shards whose records have all been written.
+	var done = make(map[string]bool)
+
+	var newShard = func(id string, parents ...string) {
+		var shard = &types.Shard{ShardId: aws.String(id)}
+		if len(parents) > 0 {
+			shard.ParentShardId = aws.String(parents[0])
+		}
+		if len(parents) > 1 {
+			shard.AdjacentParentShardId = aws.String(parents[1])
+		}
+		listing[id] = shard
+	}
+	var seq int
+	var write = func(id string, children ...string) {
+		var result = readResult{
+			source:        &recordSource{stream: stream, shardID: id, parentShardIDs: shardParents(listing[id])},
+			childShardIDs: children,
+		}
+		// Shards are finished both with and without records in their final results.
+		if len(children) == 0 || seq%3 != 0 {
+			seq++
+			result.sequenceNumber = fmt.Sprint(seq)
+			updateState(state, result.source, result.sequenceNumber)
+		}
+		compactor.update(state, result)
+		if len(children) != 0 {
+			done[id] = true
+		}
+	}
+
+	newShard("shard-0")
+	write("shard-0")
+	var open = []string{"shard-0"}
+
+	// The stream alternates between splitting its single shard in two and merging them back.
+	for gen := 1; gen <= 100; gen++ {
+		var children []string
+		if len(open) == 1 {
+			children = []string{fmt.Sprintf("shard-%d-a", gen), fmt.Sprintf("shard-%d-b", gen)}
+			newShard(children[0], open[0])
+			newShard(children[1], open[0])
+		} else {
+			children = []string{fmt.Sprintf("shard-%d", gen)}
+			newShard(children[0], open...)
+		}
+
+		for _, id := range open {
+			write(id)
+		}
+		// Children are read as soon as their parents end, and so they may write their first records
+		// either before or after the final results of their parents.
+		if gen%2 == 0 {
+			for _, id := range children {
+				write(id)
+			}
+			for _, id := range open {
+				write(id, children...)
+			}
+		} else {
+			for _, id := range open {
+				write(id, children...)
+			}
+			for _, id := range children {
+				write(id)
+			}
+		}
+		open = children
+
+		// The state holds no more than the open shards and the parents they were split or merged
+		// from, rather than every shard that has ever existed.
+		require.LessOrEqual(t, len(state[stream]), 4, "generation %d: %v", gen, state[stream])
+		for _, id := range open {
+			require.Contains(t, state[stream], id)
+		}
+
+		// Upon a restart, every shard which isn't in the state is known to have been read
+		// completely if and only if it actually was, so that none are read twice or skipped.
+		var finished = finishedShards(listing, state[stream])
+		for id := range listing {
+			if _, ok := state[stream][id]; !ok {
+				require.Equal(t, done[id], finished[id], "generation %d: shard %s", gen, id)
+			}
+		}
+	}
+	require.Greater(t, len(listing), 100)
+}
+
+func TestFinishedShards(t *testing.T) {
+	var listing = map[string]*types.Shard{
+		"a": {ShardId: aws.String("a")},
+		"b": {ShardId: aws.String("b"), ParentShardId: aws.String("a")},
+		"c": {ShardId: aws.String("c"), ParentShardId: aws.String("a")},
+		"d": {ShardId: aws.String("d"), ParentShardId: aws.String("b"), AdjacentParentShardId: aws.String("c")},
+		"e": {ShardId: aws.String("e")},
+	}
+
+	// Ancestors of shards in the state have been read, unless they're in the state themselves.
+	require.Equal(t, map[string]bool{"a": true}, finishedShards(listing, map[string]string{"b": "1"}))
+	require.Equal(t, map[string]bool{"a": true, "b": true, "c": true}, finishedShards(listing, map[string]string{"d": "1"}))
+	require.Equal(t, map[string]bool{"a": true, "c": true}, finishedShards(listing, map[string]string{"b": "1", "d": "2"}))
+	require.Empty(t, finishedShards(listing, map[string]string{"a": "1", "e": "2", "gone": "3"}))
+}
@@ -3,11 +3,13 @@ package main
 import (
 	"context"
 	"fmt"
+	"time"
 
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -18,17 +20,93 @@ type Config struct {
 	Region             string `json:"region"`
 	AWSAccessKeyID     string `json:"awsAccessKeyId"`
 	AWSSecretAccessKey string `json:"awsSecretAccessKey"`
+	// A named profile of the shared AWS config and credentials files, which provides the base
+	// credentials instead of the static keys.
+	Profile string `json:"profile,omitempty"`
+	// An IAM role which is assumed using the base credentials, such as for cross-account captures,
+	// and the external id and session name used to assume it.
+	RoleArn            string `json:"roleArn,omitempty"`
+	ExternalID         string `json:"externalId,omitempty"`
+	SessionName        string `json:"sessionName,omitempty"`
+	MaxInFlightRecords int    `json:"maxInFlightRecords,omitempty"`
+	Coordination       string `json:"coordination,omitempty"`
+	LeaseTable         string `json:"leaseTable,omitempty"`
+	KeyField           string `json:"keyField,omitempty"`
+	// The maximum size of a record in bytes, or zero for no limit, and the policy for records
+	// which exceed it.
+	MaxRecordBytes        int    `json:"maxRecordBytes,omitempty"`
+	OversizedRecordPolicy string `json:"oversizedRecordPolicy,omitempty"`
+	// The compression format of record payloads, and the policy for payloads which can't be
+	// decompressed.
+	Compression         string `json:"compression,omitempty"`
+	CorruptRecordPolicy string `json:"corruptRecordPolicy,omitempty"`
+	// Whether records which aren't JSON objects are captured in an envelope object, rather than
+	// being emitted as they are.
+	ParseJSON bool `json:"parseJson,omitempty"`
+	// An expression which records must satisfy in order to be captured.
+	Filter string `json:"filter,omitempty"`
+	// A JSON pointer to a field of records whose recently captured values are remembered, and the
+	// maximum number of values and seconds for which they're remembered. Records with the same
+	// value as one that's remembered are dropped.
+	DedupField         string `json:"dedupField,omitempty"`
+	DedupWindowSize    int    `json:"dedupWindowSize,omitempty"`
+	DedupWindowSeconds int    `json:"dedupWindowSeconds,omitempty"`
+	// The maximum lag of the capture in seconds, or zero for no maximum, how long it may be
+	// exceeded before the lagAction is taken, and the action.
+	MaxLagSeconds         int    `json:"maxLagSeconds,omitempty"`
+	MaxLagDurationSeconds int    `json:"maxLagDurationSeconds,omitempty"`
+	LagAction             string `json:"lagAction,omitempty"`
+	// How a kinesis shard is read when its stored sequence number is rejected on resume.
+	ExpiredSequencePolicy string `json:"expiredSequencePolicy,omitempty"`
+	// How often the position of an idle kinesis shard is stored, or zero to only store positions
+	// when records are read.
+	CheckpointIntervalSeconds int `json:"checkpointIntervalSeconds,omitempty"`
+	// Where shards are read from when there's no sequence number for them in the state, and the
+	// RFC3339 timestamp of the `at_timestamp` position.
+	StartingPosition  string `json:"startingPosition,omitempty"`
+	StartingTimestamp string `json:"startingTimestamp,omitempty"`
+	// Whether records are emitted with the id of their kinesis shard as their namespace.
+	ShardNamespace bool `json:"shardNamespace,omitempty"`
+	// The number of records of each stream which are sampled during discovery to infer the
+	// properties of its schema, or zero to not sample records.
+	SampleForSchema int `json:"sampleForSchema,omitempty"`
+	// The source of the timestamp with which each record is emitted, and the JSON pointer to the
+	// field of records holding it and the format of its values when the source is a field.
+	TimestampSource string `json:"timestampSource,omitempty"`
+	TimestampField  string `json:"timestampField,omitempty"`
+	TimestampFormat string `json:"timestampFormat,omitempty"`
 }
 
 func (c *Config) Validate() error {
-	if c.Region == "" {
+	if c.Endpoint != "" {
+		if err := validateEndpoint(c.Endpoint); err != nil {
+			return err
+		}
+	} else if c.Region == "" {
 		return fmt.Errorf("missing region")
 	}
-	if c.AWSAccessKeyID == "" {
-		return fmt.Errorf("missing awsAccessKeyId")
+	if err := validateAuthentication(c); err != nil {
+		return err
+	}
+	if c.MaxInFlightRecords < 0 {
+		return fmt.Errorf("maxInFlightRecords must not be negative")
+	}
+	switch c.Coordination {
+	case "", coordinationRange:
+	case coordinationDynamoDB:
+		if c.LeaseTable == "" {
+			return fmt.Errorf("leaseTable is required when coordination is %q", coordinationDynamoDB)
+		}
+	default:
+		return fmt.Errorf("invalid coordination %q", c.Coordination)
+	}
+	// The options of reads are built from the config in the same way as they are when reading,
+	// so that checks and discovery reject any which are invalid.
+	if _, err := newReaderOptions(c, time.Time{}); err != nil {
+		return err
 	}
-	if c.AWSSecretAccessKey == "" {
-		return fmt.Errorf("missing awsSecretAccessKey")
+	if c.SampleForSchema < 0 {
+		return fmt.Errorf("sampleForSchema must not be negative")
 	}
 	return nil
 }
@@ -38,9 +116,7 @@ var configJSONSchema = `{
 	"title":   "Kinesis Source Spec",
 	"type":    "object",
 	"required": [
-		"region",
-		"awsAccessKeyId",
-		"awsSecretAccessKey"
+		"region"
 	],
 	"properties": {
 		"region": {
@@ -52,7 +128,7 @@ var configJSONSchema = `{
 		"endpoint": {
 			"type":        "string",
 			"title":       "AWS Endpoint",
-			"description": "The AWS endpoint URI to connect to, useful if you're capturing from a kinesis-compatible API that isn't provided by AWS"
+			"description": "The AWS endpoint URI to connect to, useful if you're capturing from a kinesis-compatible API that isn't provided by AWS, such as LocalStack. Plain 'http://' endpoints are supported, and the region is optional when an endpoint is given."
 		},
 		"awsAccessKeyId": {
 			"type":        "string",
@@ -67,36 +143,263 @@ var configJSONSchema = `{
 			"description": "Part of the AWS credentials that will be used to connect to Kinesis",
 			"default":     "example-aws-secret-access-key",
 			"secret": true
+		},
+		"profile": {
+			"type":        "string",
+			"title":       "AWS Profile",
+			"description": "The name of a profile in the shared AWS config and credentials files, which provides the credentials instead of awsAccessKeyId and awsSecretAccessKey. Either a profile or both keys are required."
+		},
+		"roleArn": {
+			"type":        "string",
+			"title":       "IAM Role ARN",
+			"description": "The ARN of an IAM role to assume using the credentials, such as a role of another AWS account which owns the Kinesis streams. The role is assumed through STS and its credentials are refreshed before they expire.",
+			"pattern":     "^arn:"
+		},
+		"externalId": {
+			"type":        "string",
+			"title":       "External ID",
+			"description": "The external ID which is required by the trust policy of the roleArn, if any.",
+			"secret": true
+		},
+		"sessionName": {
+			"type":        "string",
+			"title":       "Role Session Name",
+			"description": "The session name used when assuming the roleArn, which identifies the capture in CloudTrail logs.",
+			"default":     "estuary-source-kinesis"
+		},
+		"maxInFlightRecords": {
+			"type":        "integer",
+			"title":       "Max In-Flight Records",
+			"description": "The maximum number of records that may be read from Kinesis but not yet emitted, across all shards. Reads are paused while this limit is reached.",
+			"default":     20000,
+			"minimum":     0
+		},
+		"coordination": {
+			"type":        "string",
+			"title":       "Coordination",
+			"description": "How kinesis shards are divided between readers. With 'range', shards are divided according to the hash key range of each capture shard. With 'dynamodb', readers of the stream take leases of kinesis shards in a DynamoDB table, which allows any number of connector instances to cooperatively read a stream.",
+			"enum":        ["range", "dynamodb"],
+			"default":     "range"
+		},
+		"leaseTable": {
+			"type":        "string",
+			"title":       "DynamoDB Lease Table",
+			"description": "Name of the DynamoDB table used for shard leases when coordination is 'dynamodb'. The table must have a string hash key named 'leaseKey'."
+		},
+		"keyField": {
+			"type":        "string",
+			"title":       "Record Key Field",
+			"description": "JSON pointer to a field of each record, such as '/user/id', whose value is added to the record as '/_meta/key' and used as the key of discovered collections. The kinesis partition key is used instead for records which don't have the field.",
+			"pattern":     "^/.+"
+		},
+		"maxRecordBytes": {
+			"type":        "integer",
+			"title":       "Max Record Bytes",
+			"description": "The maximum size of a record in bytes, after its key is added. Records which are larger are handled according to the oversizedRecordPolicy. Zero means that there's no limit.",
+			"default":     0,
+			"minimum":     0
+		},
+		"oversizedRecordPolicy": {
+			"type":        "string",
+			"title":       "Oversized Record Policy",
+			"description": "How records larger than maxRecordBytes are handled. With 'error', the capture fails. With 'skip', the record is logged and not captured. With 'truncate', the largest top-level properties of the record are removed until it fits, and their names are listed in '/_meta/truncated'.",
+			"enum":        ["error", "skip", "truncate"],
+			"default":     "error"
+		},
+		"compression": {
+			"type":        "string",
+			"title":       "Record Compression",
+			"description": "The compression format of record payloads, which are decompressed before anything else is done with them. With 'none', payloads are captured as they are.",
+			"enum":        ["none", "gzip", "zlib"],
+			"default":     "none"
+		},
+		"corruptRecordPolicy": {
+			"type":        "string",
+			"title":       "Corrupt Record Policy",
+			"description": "How records whose payloads can't be decompressed are handled. With 'skip', the record is logged and not captured. With 'error', the capture fails.",
+			"enum":        ["skip", "error"],
+			"default":     "skip"
+		},
+		"parseJson": {
+			"type":        "boolean",
+			"title":       "Parse JSON",
+			"description": "Parse each record as a JSON object. Records which aren't JSON objects are captured as an object with the record's payload in its '_raw' property, or in its '_rawBase64' property as base64 if the payload isn't valid UTF-8. Without it, records must already be JSON.",
+			"default":     false
+		},
+		"filter": {
+			"type":        "string",
+			"title":       "Record Filter",
+			"description": "An expression which records must satisfy in order to be captured, such as '/eventType == \"order\"'. Comparisons of a JSON pointer to a JSON value with '==' or '!=' can be combined with '&&' and '||'. Records which don't satisfy it are dropped. All records are captured if it's empty."
+		},
+		"dedupField": {
+			"type":        "string",
+			"title":       "Deduplication Field",
+			"description": "JSON pointer to a field of each record, such as '/idempotencyKey', which identifies records that may be put more than once. Records whose field has the same value as that of a recently captured record of the same stream are dropped. Deduplication is best-effort: it's done in memory by each connector process and is forgotten when the connector restarts. Records which don't have the field are always captured.",
+			"pattern":     "^/.+"
+		},
+		"dedupWindowSize": {
+			"type":        "integer",
+			"title":       "Deduplication Window Size",
+			"description": "The maximum number of recently captured values of the dedupField which are remembered. The oldest values are forgotten first.",
+			"default":     100000,
+			"minimum":     0
+		},
+		"dedupWindowSeconds": {
+			"type":        "integer",
+			"title":       "Deduplication Window Seconds",
+			"description": "How long in seconds a captured value of the dedupField is remembered. Zero means that values are remembered until the window is full.",
+			"default":     0,
+			"minimum":     0
+		},
+		"maxLagSeconds": {
+			"type":        "integer",
+			"title":       "Max Lag Seconds",
+			"description": "The maximum lag of the capture in seconds, which is the greatest millisBehindLatest reported by any kinesis shard that's being read. When the lag stays above it for maxLagDurationSeconds, the lagAction is taken. Zero means that there's no maximum.",
+			"default":     0,
+			"minimum":     0
+		},
+		"maxLagDurationSeconds": {
+			"type":        "integer",
+			"title":       "Max Lag Duration Seconds",
+			"description": "How long in seconds the lag of the capture must stay above maxLagSeconds before the lagAction is taken.",
+			"default":     300,
+			"minimum":     0
+		},
+		"lagAction": {
+			"type":        "string",
+			"title":       "Lag Action",
+			"description": "What to do when the lag of the capture has stayed above maxLagSeconds for maxLagDurationSeconds. With 'warn', a warning is logged. With 'exit', the warning is logged and the capture fails, so that it can be restarted or scaled out.",
+			"enum":        ["warn", "exit"],
+			"default":     "warn"
+		},
+		"expiredSequencePolicy": {
+			"type":        "string",
+			"title":       "Expired Sequence Policy",
+			"description": "How a kinesis shard is read when the capture resumes after a sequence number that kinesis rejects, which happens when its record has fallen off the end of the stream's retention period. With 'trim-horizon', the shard is read from the oldest retained record. With 'latest', the shard is read from its tip, skipping any retained records. With 'error', the capture fails.",
+			"enum":        ["trim-horizon", "latest", "error"],
+			"default":     "trim-horizon"
+		},
+		"checkpointIntervalSeconds": {
+			"type":        "integer",
+			"title":       "Checkpoint Interval Seconds",
+			"description": "How often in seconds the position of a kinesis shard which has no new records is stored, so that a restarted capture resumes from about that time instead of scanning forward from the last record it read, which may be long ago. Zero means that positions are only stored when records are read.",
+			"default":     0,
+			"minimum":     0
+		},
+		"startingPosition": {
+			"type":        "string",
+			"title":       "Starting Position",
+			"description": "Where kinesis shards are read from when the capture has no state for them, such as when it's first started. With 'trim_horizon', shards are read from their oldest retained record. With 'latest', only records added after the capture starts are captured. With 'at_timestamp', shards are read from the first record added at or after the startingTimestamp.",
+			"enum":        ["trim_horizon", "latest", "at_timestamp"],
+			"default":     "trim_horizon"
+		},
+		"startingTimestamp": {
+			"type":        "string",
+			"title":       "Starting Timestamp",
+			"description": "The RFC3339 timestamp from which shards are read when startingPosition is 'at_timestamp', such as '2022-01-02T15:04:05Z'.",
+			"format":      "date-time"
+		},
+		"shardNamespace": {
+			"type":        "boolean",
+			"title":       "Shard Namespace",
+			"description": "Emit each record with the id of the kinesis shard it was read from as its namespace, so that records can be partitioned by shard downstream. When a stream is resharded, the records of its child shards have the ids of the child shards as their namespaces. This is off by default, since each shard that's ever read adds a namespace.",
+			"default":     false
+		},
+		"sampleForSchema": {
+			"type":        "integer",
+			"title":       "Sample For Schema",
+			"description": "The number of records of each stream to read from its oldest retained records during discovery, to infer the top-level properties of its schema. While capturing, a notice is logged when records have fields which aren't in the discovered schema of their stream, so that it can be re-discovered. Zero means that records aren't sampled.",
+			"default":     0,
+			"minimum":     0
+		},
+		"timestampSource": {
+			"type":        "string",
+			"title":       "Timestamp Source",
+			"description": "The source of the timestamp with which each record is emitted, for event-time processing downstream. With 'now', records have the time at which they're captured. With 'arrival', records have the approximate time at which kinesis received them. With 'field', records have the time held by their timestampField. Records whose timestamp isn't available have the time at which they're captured.",
+			"enum":        ["now", "arrival", "field"],
+			"default":     "now"
+		},
+		"timestampField": {
+			"type":        "string",
+			"title":       "Timestamp Field",
+			"description": "JSON pointer to a field of each record, such as '/event/time', which holds its timestamp when timestampSource is 'field'.",
+			"pattern":     "^/.+"
+		},
+		"timestampFormat": {
+			"type":        "string",
+			"title":       "Timestamp Format",
+			"description": "The format of the values of the timestampField. With 'rfc3339', values are strings such as '2022-01-02T15:04:05.123Z'. With 'unix_seconds' and 'unix_millis', values are numbers of seconds or milliseconds since the unix epoch, which may also be strings.",
+			"enum":        ["rfc3339", "unix_seconds", "unix_millis"],
+			"default":     "rfc3339"
 		}
 	}
 }`
 
-func connect(config *Config) (*kinesis.Kinesis, error) {
+// kinesisAPI is the subset of the kinesis client that's used by the capture. Every request takes
+// the context of the read, so that requests in flight are abandoned when the read is cancelled.
+type kinesisAPI interface {
+	ListStreams(context.Context, *kinesis.ListStreamsInput, ...func(*kinesis.Options)) (*kinesis.ListStreamsOutput, error)
+	ListShards(context.Context, *kinesis.ListShardsInput, ...func(*kinesis.Options)) (*kinesis.ListShardsOutput, error)
+	GetShardIterator(context.Context, *kinesis.GetShardIteratorInput, ...func(*kinesis.Options)) (*kinesis.GetShardIteratorOutput, error)
+	GetRecords(context.Context, *kinesis.GetRecordsInput, ...func(*kinesis.Options)) (*kinesis.GetRecordsOutput, error)
+	DescribeStreamSummary(context.Context, *kinesis.DescribeStreamSummaryInput, ...func(*kinesis.Options)) (*kinesis.DescribeStreamSummaryOutput, error)
+}
+
+func connect(ctx context.Context, config *Config) (*kinesis.Client, error) {
+	var awsConfig, err = loadAWSConfig(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	return kinesis.NewFromConfig(awsConfig, kinesisEndpointOptions(config)), nil
+}
+
+// connectLeaseTable returns the DynamoDB lease table used for coordination between readers.
+func connectLeaseTable(ctx context.Context, config *Config) (*leaseTable, error) {
+	var awsConfig, err = loadAWSConfig(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	return &leaseTable{client: dynamodb.NewFromConfig(awsConfig), name: config.LeaseTable}, nil
+}
+
+// loadAWSConfig returns the AWS config of the clients, whose credentials are either the static
+// keys or those of the shared-config profile, and which assume the config's role if it has one.
+func loadAWSConfig(ctx context.Context, config *Config) (aws.Config, error) {
 	var err = config.Validate()
 	if err != nil {
-		return nil, fmt.Errorf("invalid config: %w", err)
+		return aws.Config{}, fmt.Errorf("invalid config: %w", err)
 	}
 
-	var creds = credentials.NewStaticCredentials(config.AWSAccessKeyID, config.AWSSecretAccessKey, "")
-	var c = aws.NewConfig().WithCredentials(creds)
-	if config.Region != "" {
-		c = c.WithRegion(config.Region)
+	var opts []func(*awsconfig.LoadOptions) error
+	if region := sessionRegion(config); region != "" {
+		opts = append(opts, awsconfig.WithRegion(region))
 	}
-	if config.Endpoint != "" {
-		c = c.WithEndpoint(config.Endpoint)
+	if config.Profile != "" {
+		opts = append(opts, awsconfig.WithSharedConfigProfile(config.Profile))
+	} else {
+		opts = append(opts, awsconfig.WithCredentialsProvider(
+			credentials.NewStaticCredentialsProvider(config.AWSAccessKeyID, config.AWSSecretAccessKey, ""),
+		))
 	}
 
-	awsSession, err := session.NewSession(c)
-	if err != nil {
-		return nil, fmt.Errorf("creating aws config: %w", err)
+	awsConfig, err := awsconfig.LoadDefaultConfig(ctx, opts...)
+	if err != nil && config.Profile != "" {
+		// The profile is loaded along with the config, rather than when its credentials are first
+		// needed.
+		return aws.Config{}, &authenticationError{config: config, err: err}
+	} else if err != nil {
+		return aws.Config{}, fmt.Errorf("creating aws config: %w", err)
 	}
-	return kinesis.New(awsSession), nil
+	if config.RoleArn != "" {
+		awsConfig.Credentials = assumeRoleCredentials(awsConfig, config)
+	}
+	return awsConfig, nil
 }
 
-func listAllStreams(ctx context.Context, client *kinesis.Kinesis) ([]string, error) {
+func listAllStreams(ctx context.Context, client kinesisAPI) ([]string, error) {
 	var streams []string
 	var lastStream *string = nil
-	var limit = int64(100)
+	var limit = int32(100)
 	var reqNum int
 	for {
 		reqNum++
@@ -105,16 +408,14 @@ func listAllStreams(ctx context.Context, client *kinesis.Kinesis) ([]string, err
 			Limit:                    &limit,
 			ExclusiveStartStreamName: lastStream,
 		}
-		resp, err := client.ListStreamsWithContext(ctx, &req)
+		resp, err := client.ListStreams(ctx, &req)
 		if err != nil {
 			return nil, err
 		}
 		log.WithField("responseStreamCount", len(resp.StreamNames)).Debug("got ListStreams response")
-		for _, name := range resp.StreamNames {
-			streams = append(streams, *name)
-		}
+		streams = append(streams, resp.StreamNames...)
 		if resp.HasMoreStreams != nil && *resp.HasMoreStreams {
-			lastStream = resp.StreamNames[len(resp.StreamNames)-1]
+			lastStream = &resp.StreamNames[len(resp.StreamNames)-1]
 		} else {
 			break
 		}
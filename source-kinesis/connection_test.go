@@ -0,0 +1,204 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/aws/smithy-go"
+	"github.com/estuary/flow/go/protocols/airbyte"
+	"github.com/stretchr/testify/require"
+)
+
+// mockKinesis lists its streams a page at a time, and serves a single shard whose records are
+// returned by the first GetRecords request. Later GetRecords requests block until their context is
+// cancelled, as a request which is in flight when the capture is stopped would.
+type mockKinesis struct {
+	streams []string
+	records []string
+
+	mu           sync.Mutex
+	listRequests int
+	getRecords   int
+}
+
+func (m *mockKinesis) ListStreams(_ context.Context, input *kinesis.ListStreamsInput, _ ...func(*kinesis.Options)) (*kinesis.ListStreamsOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.listRequests++
+
+	var start int
+	if input.ExclusiveStartStreamName != nil {
+		for i, name := range m.streams {
+			if name == *input.ExclusiveStartStreamName {
+				start = i + 1
+			}
+		}
+	}
+	var end = start + int(aws.ToInt32(input.Limit))
+	if end > len(m.streams) {
+		end = len(m.streams)
+	}
+	return &kinesis.ListStreamsOutput{
+		StreamNames:    m.streams[start:end],
+		HasMoreStreams: aws.Bool(end < len(m.streams)),
+	}, nil
+}
+
+func (m *mockKinesis) ListShards(_ context.Context, input *kinesis.ListShardsInput, _ ...func(*kinesis.Options)) (*kinesis.ListShardsOutput, error) {
+	return &kinesis.ListShardsOutput{Shards: []types.Shard{{
+		ShardId: aws.String("shardId-000000000000"),
+		HashKeyRange: &types.HashKeyRange{
+			StartingHashKey: aws.String("0"),
+			EndingHashKey:   aws.String("340282366920938463463374607431768211455"),
+		},
+	}}}, nil
+}
+
+func (m *mockKinesis) GetShardIterator(_ context.Context, input *kinesis.GetShardIteratorInput, _ ...func(*kinesis.Options)) (*kinesis.GetShardIteratorOutput, error) {
+	return &kinesis.GetShardIteratorOutput{ShardIterator: aws.String("iterator")}, nil
+}
+
+func (m *mockKinesis) GetRecords(ctx context.Context, input *kinesis.GetRecordsInput, _ ...func(*kinesis.Options)) (*kinesis.GetRecordsOutput, error) {
+	m.mu.Lock()
+	m.getRecords++
+	var first = m.getRecords == 1
+	m.mu.Unlock()
+
+	if !first {
+		<-ctx.Done()
+		return nil, &smithy.OperationError{ServiceID: "Kinesis", OperationName: "GetRecords", Err: ctx.Err()}
+	}
+	var resp = &kinesis.GetRecordsOutput{
+		MillisBehindLatest: aws.Int64(0),
+		NextShardIterator:  aws.String("iterator"),
+	}
+	for i, rec := range m.records {
+		resp.Records = append(resp.Records, types.Record{
+			Data:           []byte(rec),
+			PartitionKey:   aws.String("pk"),
+			SequenceNumber: aws.String(fmt.Sprint(i)),
+		})
+	}
+	return resp, nil
+}
+
+func (m *mockKinesis) DescribeStreamSummary(_ context.Context, input *kinesis.DescribeStreamSummaryInput, _ ...func(*kinesis.Options)) (*kinesis.DescribeStreamSummaryOutput, error) {
+	return nil, fmt.Errorf("not implemented")
+}
+
+func TestListAllStreams(t *testing.T) {
+	var client = &mockKinesis{}
+	for i := 0; i < 250; i++ {
+		client.streams = append(client.streams, fmt.Sprintf("stream-%03d", i))
+	}
+
+	// Streams are listed in pages of 100.
+	var streams, err = listAllStreams(context.Background(), client)
+	require.NoError(t, err)
+	require.Equal(t, client.streams, streams)
+	require.Equal(t, 3, client.listRequests)
+
+	client = &mockKinesis{}
+	streams, err = listAllStreams(context.Background(), client)
+	require.NoError(t, err)
+	require.Empty(t, streams)
+	require.Equal(t, 1, client.listRequests)
+}
+
+func TestReadStreamCancel(t *testing.T) {
+	var client = &mockKinesis{records: []string{`{"a":1}`, `{"a":2}`}}
+	var dataCh = make(chan readResult)
+	var inFlight = newInFlightLimiter(100)
+	var ctx, cancel = context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg = new(sync.WaitGroup)
+	wg.Add(1)
+	go readStream(ctx, airbyte.NewFullRange(), client, "test-stream", nil, dataCh, inFlight, nil, readerOptions{}, nil, wg)
+
+	var result = <-dataCh
+	require.NoError(t, result.err)
+	require.Len(t, result.records, 2)
+	require.Equal(t, "1", result.sequenceNumber)
+	inFlight.release(int64(len(result.records)))
+
+	// Cancelling the read abandons the GetRecords request which is in flight, and every read of
+	// the stream returns without reporting an error.
+	cancel()
+	var done = make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case result = <-dataCh:
+		t.Fatalf("unexpected result after the read was cancelled: %+v", result)
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the read to stop")
+	}
+	require.Equal(t, int64(0), inFlight.inFlight())
+}
+
+func TestReaderOptions(t *testing.T) {
+	var base = Config{Region: "us-east-1", AWSAccessKeyID: "x", AWSSecretAccessKey: "x"}
+
+	// Every option of reads is built from a valid config.
+	var conf = base
+	conf.ParseJSON = true
+	conf.Filter = `/type == "order"`
+	conf.DedupField = "/id"
+	conf.KeyField = "/user/id"
+	conf.TimestampSource = timestampSourceArrival
+	conf.MaxRecordBytes = 1024
+	conf.ExpiredSequencePolicy = expiredSequenceLatest
+	conf.CheckpointIntervalSeconds = 30
+	conf.StartingPosition = startingPositionLatest
+	require.NoError(t, conf.Validate())
+
+	var now = time.Date(2022, 1, 2, 15, 4, 5, 0, time.UTC)
+	var opts, err = newReaderOptions(&conf, now)
+	require.NoError(t, err)
+	require.True(t, opts.parseJSON)
+	require.NotNil(t, opts.filter)
+	require.NotNil(t, opts.dedup)
+	require.NotNil(t, opts.keys)
+	require.NotNil(t, opts.timestamps)
+	require.NotNil(t, opts.sizeLimit)
+	require.Nil(t, opts.decompressor)
+	require.Nil(t, opts.lag)
+	require.Equal(t, expiredSequenceLatest, opts.expiredPolicy)
+	require.Equal(t, 30*time.Second, opts.checkpointInterval)
+	require.Equal(t, &startPosition{position: startingPositionLatest, timestamp: now}, opts.start)
+
+	// Invalid options are rejected when the config is validated, so that checks and discovery
+	// fail rather than only reads.
+	for _, tc := range []struct {
+		update      func(*Config)
+		expectError string
+	}{
+		{func(c *Config) { c.Filter = "/type ==" }, "invalid filter"},
+		{func(c *Config) { c.KeyField = "user" }, "invalid keyField"},
+		{func(c *Config) { c.DedupField = "id" }, "invalid dedupField"},
+		{func(c *Config) { c.TimestampSource = "event" }, "invalid timestampSource"},
+		{func(c *Config) { c.TimestampField = "/ts" }, "timestampSource"},
+		{func(c *Config) { c.Compression = "lz4" }, "invalid compression"},
+		{func(c *Config) { c.MaxRecordBytes, c.OversizedRecordPolicy = 1024, "drop" }, "invalid oversizedRecordPolicy"},
+		{func(c *Config) { c.MaxLagSeconds, c.LagAction = 60, "page" }, "invalid lagAction"},
+		{func(c *Config) { c.ExpiredSequencePolicy = "oldest" }, "invalid expiredSequencePolicy"},
+		{func(c *Config) { c.CheckpointIntervalSeconds = -1 }, "checkpointIntervalSeconds must not be negative"},
+		{func(c *Config) { c.StartingPosition = startingPositionAtTimestamp }, "startingTimestamp is required"},
+	} {
+		var conf = base
+		tc.update(&conf)
+		var err = conf.Validate()
+		require.Error(t, err, tc.expectError)
+		require.Contains(t, err.Error(), tc.expectError)
+	}
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+)
+
+// kplMagic is the prefix of kinesis records which aggregate many user records, as written by the
+// Kinesis Producer Library. It's followed by an `AggregatedRecord` protobuf message and then the
+// MD5 digest of that message.
+var kplMagic = []byte{0xF3, 0x89, 0x9A, 0xC2}
+
+// userRecord is a record as it was written by a producer. It's either a whole kinesis record, or
+// one of the user records which were aggregated into a kinesis record.
+type userRecord struct {
+	partitionKey string
+	data         []byte
+	// The sequence number of the kinesis record, and the index of the user record within the
+	// aggregate, which is -1 for records that weren't aggregated.
+	sequenceNumber    string
+	subSequenceNumber int
+	// Whether this is the final user record of its kinesis record.
+	last bool
+}
+
+// position returns the position of the record within the shard, which is stored in the state once
+// the record has been emitted. That's the sequence number of the kinesis record once all of its
+// user records have been emitted, and otherwise also includes the sub-sequence number of the user
+// record, separated by a colon.
+func (u *userRecord) position() string {
+	if u.last {
+		return u.sequenceNumber
+	}
+	return u.sequenceNumber + ":" + strconv.Itoa(u.subSequenceNumber)
+}
+
+// parsePosition parses a position stored in the state into its sequence number and sub-sequence
+// number. The sub-sequence number is -1 if all the user records of the kinesis record have been
+// emitted.
+func parsePosition(position string) (string, int, error) {
+	var idx = strings.IndexByte(position, ':')
+	if idx == -1 {
+		return position, -1, nil
+	}
+	var sub, err = strconv.Atoi(position[idx+1:])
+	if err != nil || sub < 0 {
+		return "", 0, fmt.Errorf("invalid sub-sequence number in position %q", position)
+	}
+	return position[:idx], sub, nil
+}
+
+// comparePositions returns -1, 0, or 1 as position a is before, the same as, or after position b.
+// Positions which can't be parsed compare as being the same. A heartbeat position is after the
+// position of the record it follows, and an empty record position is before any other.
+func comparePositions(a, b string) int {
+	var recordA, heartbeatA, errA = parseHeartbeatPosition(a)
+	var recordB, heartbeatB, errB = parseHeartbeatPosition(b)
+	if errA != nil || errB != nil {
+		return 0
+	} else if c := compareRecordPositions(recordA, recordB); c != 0 {
+		return c
+	} else if heartbeatA.Before(heartbeatB) {
+		return -1
+	} else if heartbeatA.After(heartbeatB) {
+		return 1
+	}
+	return 0
+}
+
+// compareRecordPositions compares the positions of records, which may be empty.
+func compareRecordPositions(a, b string) int {
+	switch {
+	case a == "" && b == "":
+		return 0
+	case a == "":
+		return -1
+	case b == "":
+		return 1
+	}
+	var seqA, subA, errA = parsePosition(a)
+	var seqB, subB, errB = parsePosition(b)
+	var x, okA = new(big.Int).SetString(seqA, 10)
+	var y, okB = new(big.Int).SetString(seqB, 10)
+	if errA != nil || errB != nil || !okA || !okB {
+		return 0
+	} else if c := x.Cmp(y); c != 0 {
+		return c
+	}
+	// A position without a sub-sequence number covers every user record of the kinesis record.
+	if subA == -1 {
+		subA = int(^uint(0) >> 1)
+	}
+	if subB == -1 {
+		subB = int(^uint(0) >> 1)
+	}
+	if subA < subB {
+		return -1
+	} else if subA > subB {
+		return 1
+	}
+	return 0
+}
+
+// deaggregate returns the user records of a kinesis record. Records which don't have the KPL magic
+// prefix are returned as-is, as are those whose digest doesn't match, since those could just be
+// records which happen to start with the same bytes.
+func deaggregate(rec *types.Record) ([]userRecord, error) {
+	var whole = []userRecord{{
+		partitionKey:      *rec.PartitionKey,
+		data:              rec.Data,
+		sequenceNumber:    *rec.SequenceNumber,
+		subSequenceNumber: -1,
+		last:              true,
+	}}
+	if len(rec.Data) < len(kplMagic)+md5.Size || !bytes.HasPrefix(rec.Data, kplMagic) {
+		return whole, nil
+	}
+	var message = rec.Data[len(kplMagic) : len(rec.Data)-md5.Size]
+	var digest = md5.Sum(message)
+	if !bytes.Equal(digest[:], rec.Data[len(rec.Data)-md5.Size:]) {
+		return whole, nil
+	}
+
+	var aggregate, err = parseAggregatedRecord(message)
+	if err != nil {
+		return nil, fmt.Errorf("record %s: parsing aggregated record: %w", *rec.SequenceNumber, err)
+	}
+	var records = make([]userRecord, 0, len(aggregate.records))
+	for i, sub := range aggregate.records {
+		if sub.partitionKeyIndex >= uint64(len(aggregate.partitionKeys)) {
+			return nil, fmt.Errorf("record %s: user record %d has invalid partition key index %d", *rec.SequenceNumber, i, sub.partitionKeyIndex)
+		}
+		records = append(records, userRecord{
+			partitionKey:      aggregate.partitionKeys[sub.partitionKeyIndex],
+			data:              sub.data,
+			sequenceNumber:    *rec.SequenceNumber,
+			subSequenceNumber: i,
+			last:              i == len(aggregate.records)-1,
+		})
+	}
+	return records, nil
+}
+
+// aggregatedRecord is the `AggregatedRecord` protobuf message of the Kinesis Producer Library.
+// Only the fields which are needed to deaggregate user records are kept.
+type aggregatedRecord struct {
+	partitionKeys []string
+	records       []aggregatedSubRecord
+}
+
+// aggregatedSubRecord is the `Record` protobuf message of the Kinesis Producer Library.
+type aggregatedSubRecord struct {
+	partitionKeyIndex uint64
+	data              []byte
+}
+
+// Field numbers of the `AggregatedRecord` and `Record` messages.
+const (
+	aggregatePartitionKeyTableField = 1
+	aggregateRecordsField           = 3
+	recordPartitionKeyIndexField    = 1
+	recordDataField                 = 3
+)
+
+func parseAggregatedRecord(message []byte) (*aggregatedRecord, error) {
+	var aggregate = new(aggregatedRecord)
+	var err = parseProtobufFields(message, func(field uint64, value uint64, data []byte) error {
+		switch field {
+		case aggregatePartitionKeyTableField:
+			aggregate.partitionKeys = append(aggregate.partitionKeys, string(data))
+		case aggregateRecordsField:
+			var sub aggregatedSubRecord
+			if err := parseProtobufFields(data, func(field uint64, value uint64, data []byte) error {
+				switch field {
+				case recordPartitionKeyIndexField:
+					sub.partitionKeyIndex = value
+				case recordDataField:
+					sub.data = data
+				}
+				return nil
+			}); err != nil {
+				return fmt.Errorf("parsing user record %d: %w", len(aggregate.records), err)
+			}
+			aggregate.records = append(aggregate.records, sub)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return aggregate, nil
+}
+
+// Protobuf wire types.
+const (
+	wireVarint          = 0
+	wireFixed64         = 1
+	wireLengthDelimited = 2
+	wireFixed32         = 5
+)
+
+// parseProtobufFields calls `fn` with each field of a protobuf message, passing the value of
+// varint fields or the bytes of length-delimited fields. Fields of other wire types are skipped.
+func parseProtobufFields(message []byte, fn func(field uint64, value uint64, data []byte) error) error {
+	for len(message) > 0 {
+		var key, n = binary.Uvarint(message)
+		if n <= 0 {
+			return fmt.Errorf("invalid field key")
+		}
+		message = message[n:]
+
+		var field, wireType = key >> 3, key & 0x7
+		var value uint64
+		var data []byte
+		switch wireType {
+		case wireVarint:
+			if value, n = binary.Uvarint(message); n <= 0 {
+				return fmt.Errorf("invalid varint of field %d", field)
+			}
+			message = message[n:]
+		case wireFixed64, wireFixed32:
+			var size = 8
+			if wireType == wireFixed32 {
+				size = 4
+			}
+			if len(message) < size {
+				return fmt.Errorf("truncated field %d", field)
+			}
+			message = message[size:]
+			continue
+		case wireLengthDelimited:
+			var length uint64
+			if length, n = binary.Uvarint(message); n <= 0 || length > uint64(len(message)-n) {
+				return fmt.Errorf("invalid length of field %d", field)
+			}
+			data = message[n : n+int(length)]
+			message = message[n+int(length):]
+		default:
+			return fmt.Errorf("unsupported wire type %d of field %d", wireType, field)
+		}
+		if err := fn(field, value, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/estuary/flow/go/protocols/airbyte"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+// protobufField appends a protobuf field to the buffer, which is either a varint or bytes.
+func protobufField(buf *bytes.Buffer, field uint64, value interface{}) {
+	var varint = func(v uint64) {
+		var tmp [binary.MaxVarintLen64]byte
+		buf.Write(tmp[:binary.PutUvarint(tmp[:], v)])
+	}
+	switch v := value.(type) {
+	case uint64:
+		varint(field<<3 | wireVarint)
+		varint(v)
+	case []byte:
+		varint(field<<3 | wireLengthDelimited)
+		varint(uint64(len(v)))
+		buf.Write(v)
+	}
+}
+
+// aggregateRecords encodes user records in the format of the Kinesis Producer Library. Each record
+// is keyed by the partition key at the given index.
+func aggregateRecords(partitionKeys []string, keyIndexes []uint64, data []string) []byte {
+	var message bytes.Buffer
+	for _, key := range partitionKeys {
+		protobufField(&message, aggregatePartitionKeyTableField, []byte(key))
+	}
+	for i := range data {
+		var record bytes.Buffer
+		protobufField(&record, recordPartitionKeyIndexField, keyIndexes[i])
+		protobufField(&record, recordDataField, []byte(data[i]))
+		// Tags are a repeated message field, which is skipped.
+		protobufField(&record, 4, []byte{0x0a, 0x01, 'k'})
+		protobufField(&message, aggregateRecordsField, record.Bytes())
+	}
+	var digest = md5.Sum(message.Bytes())
+
+	var out = append([]byte{}, kplMagic...)
+	out = append(out, message.Bytes()...)
+	return append(out, digest[:]...)
+}
+
+func TestDeaggregate(t *testing.T) {
+	var record = func(data []byte) *types.Record {
+		return &types.Record{
+			Data:           data,
+			PartitionKey:   aws.String("pk"),
+			SequenceNumber: aws.String("100"),
+		}
+	}
+
+	// Records which weren't aggregated are returned as-is.
+	var users, err = deaggregate(record([]byte(`{"a":1}`)))
+	require.NoError(t, err)
+	require.Equal(t, []userRecord{{
+		partitionKey:      "pk",
+		data:              []byte(`{"a":1}`),
+		sequenceNumber:    "100",
+		subSequenceNumber: -1,
+		last:              true,
+	}}, users)
+	require.Equal(t, "100", users[0].position())
+
+	// Aggregated records are unpacked, with the partition key of each user record.
+	var aggregated = aggregateRecords([]string{"one", "two"}, []uint64{0, 1, 0}, []string{`{"a":1}`, `{"a":2}`, `{"a":3}`})
+	users, err = deaggregate(record(aggregated))
+	require.NoError(t, err)
+	require.Len(t, users, 3)
+	for i, expected := range []struct {
+		key, data, position string
+	}{
+		{"one", `{"a":1}`, "100:0"},
+		{"two", `{"a":2}`, "100:1"},
+		{"one", `{"a":3}`, "100"},
+	} {
+		require.Equal(t, expected.key, users[i].partitionKey)
+		require.Equal(t, expected.data, string(users[i].data))
+		require.Equal(t, expected.position, users[i].position())
+	}
+
+	// A record with the magic prefix but a mismatched digest isn't an aggregate.
+	var corrupt = append([]byte{}, aggregated...)
+	corrupt[len(corrupt)-1] ^= 0xff
+	users, err = deaggregate(record(corrupt))
+	require.NoError(t, err)
+	require.Len(t, users, 1)
+	require.Equal(t, corrupt, users[0].data)
+
+	// An aggregate which refers to a partition key that it doesn't have is an error.
+	_, err = deaggregate(record(aggregateRecords([]string{"one"}, []uint64{1}, []string{`{}`})))
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "invalid partition key index 1")
+}
+
+func TestPositions(t *testing.T) {
+	for _, tc := range []struct {
+		position string
+		seq      string
+		sub      int
+	}{
+		{"100", "100", -1},
+		{"100:0", "100", 0},
+		{"100:12", "100", 12},
+	} {
+		var seq, sub, err = parsePosition(tc.position)
+		require.NoError(t, err)
+		require.Equal(t, tc.seq, seq)
+		require.Equal(t, tc.sub, sub)
+	}
+	for _, invalid := range []string{"100:", "100:x", "100:-1"} {
+		var _, _, err = parsePosition(invalid)
+		require.Error(t, err, invalid)
+	}
+
+	require.Equal(t, -1, comparePositions("99", "100:0"))
+	require.Equal(t, -1, comparePositions("100:0", "100:1"))
+	require.Equal(t, -1, comparePositions("100:5", "100"))
+	require.Equal(t, 0, comparePositions("100:5", "100:5"))
+	require.Equal(t, 1, comparePositions("101:0", "100"))
+	require.Equal(t, "100", laterSequenceNumber("100:5", "100"))
+	require.Equal(t, "100:2", laterSequenceNumber("100:2", "99"))
+}
+
+// aggregateServer is a kinesis endpoint which returns an aggregated record from every shard
+// iterator, which is named after the iterator type and starting sequence number.
+type aggregateServer struct {
+	record []byte
+
+	mu        sync.Mutex
+	iterators []string
+}
+
+func (s *aggregateServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	var input struct {
+		ShardIteratorType      *string
+		StartingSequenceNumber *string
+	}
+	if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+
+	if strings.HasSuffix(req.Header.Get("X-Amz-Target"), ".GetShardIterator") {
+		var iterator = fmt.Sprintf("%s/%s", *input.ShardIteratorType, aws.ToString(input.StartingSequenceNumber))
+		s.mu.Lock()
+		s.iterators = append(s.iterators, iterator)
+		s.mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]string{"ShardIterator": iterator})
+		return
+	}
+	// The shard is closed after the aggregated record, so the read ends once it has been returned.
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"Records": []map[string]interface{}{{
+			"Data":           s.record,
+			"PartitionKey":   "pk",
+			"SequenceNumber": "100",
+		}},
+		"MillisBehindLatest": 0,
+	})
+}
+
+func TestReadAggregatedRecords(t *testing.T) {
+	var server = &aggregateServer{
+		record: aggregateRecords([]string{"pk"}, []uint64{0, 0, 0, 0, 0}, []string{`{"n":0}`, `{"n":1}`, `{"n":2}`, `{"n":3}`, `{"n":4}`}),
+	}
+	var httpServer = httptest.NewServer(server)
+	defer httpServer.Close()
+	var client = kinesis.NewFromConfig(testAWSConfig(httpServer.URL))
+
+	// read reads the shard from the position, with room for only two records to be in-flight at a
+	// time, and returns the batches of records and the position of each.
+	var read = func(position string) ([]string, []string) {
+		var dataCh = make(chan readResult)
+		var inFlight = newInFlightLimiter(2)
+		var source = &recordSource{stream: "test-stream", shardID: "shardId-000000000000"}
+		var reader = &shardReader{
+			ctx:          context.Background(),
+			rangeOverlap: airbyte.FullRangeOverlap,
+			parent: &streamReader{
+				client:   client,
+				ctx:      context.Background(),
+				stream:   source.stream,
+				dataCh:   dataCh,
+				inFlight: inFlight,
+			},
+			source:         source,
+			lastSequenceID: position,
+			limitPerReq:    2,
+			logEntry:       log.WithField("kinesisShardId", source.shardID),
+		}
+		go func() {
+			reader.readShard()
+			close(dataCh)
+		}()
+
+		var batches, positions []string
+		for result := range dataCh {
+			require.NoError(t, result.err)
+			var batch []string
+			for _, record := range result.records {
+				batch = append(batch, string(record))
+			}
+			batches = append(batches, strings.Join(batch, ","))
+			positions = append(positions, result.sequenceNumber)
+			inFlight.release(int64(len(result.records)))
+		}
+		return batches, positions
+	}
+
+	// The aggregated record holds more user records than can be in-flight, so they're emitted in
+	// batches, each of which is covered by the position of its last record.
+	var batches, positions = read("")
+	require.Equal(t, []string{`{"n":0},{"n":1}`, `{"n":2},{"n":3}`, `{"n":4}`}, batches)
+	require.Equal(t, []string{"100:1", "100:3", "100"}, positions)
+
+	// Resuming partway through the aggregated record reads it again, skipping the user records
+	// which were already emitted.
+	batches, positions = read("100:1")
+	require.Equal(t, []string{`{"n":2},{"n":3}`, `{"n":4}`}, batches)
+	require.Equal(t, []string{"100:3", "100"}, positions)
+
+	// Resuming after a whole record reads from after it.
+	read("100")
+	require.Equal(t, []string{"TRIM_HORIZON/", "AT_SEQUENCE_NUMBER/100", "AFTER_SEQUENCE_NUMBER/100"}, server.iterators)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"container/list"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// defaultDedupWindowSize is used when the config has a `dedupField` but doesn't specify
+// `dedupWindowSize`.
+const defaultDedupWindowSize = 100000
+
+// dedupWindow drops records whose dedup field has the same value as that of a record of the same
+// stream which was captured recently. The window remembers the values of up to `maxKeys` of the
+// most recently captured records, and forgets each value once `ttl` has passed since it was
+// captured, if the ttl is non-zero. It's shared by the readers of every kinesis shard, so that
+// duplicates are dropped regardless of which shard they're read from. Deduplication is only
+// best-effort, since the window is held in memory and starts out empty whenever the connector
+// starts, and it isn't shared between connector processes.
+type dedupWindow struct {
+	// The unescaped tokens of the JSON pointer to the dedup field.
+	tokens  []string
+	maxKeys int
+	ttl     time.Duration
+	now     func() time.Time
+
+	mu sync.Mutex
+	// Entries are ordered from the least to the most recently captured, and indexed by key.
+	entries *list.List
+	index   map[dedupKey]*list.Element
+}
+
+type dedupKey struct {
+	stream string
+	value  string
+}
+
+type dedupEntry struct {
+	key        dedupKey
+	capturedAt time.Time
+}
+
+// newDedupWindow returns a dedupWindow for the given JSON pointer, or nil if the pointer is empty.
+// A maxKeys of zero means the default size.
+func newDedupWindow(pointer string, maxKeys int, ttlSeconds int) (*dedupWindow, error) {
+	if pointer == "" {
+		if maxKeys != 0 || ttlSeconds != 0 {
+			return nil, fmt.Errorf("dedupWindowSize and dedupWindowSeconds require a dedupField")
+		}
+		return nil, nil
+	}
+	var tokens, err = parseJSONPointer(pointer)
+	if err != nil {
+		return nil, fmt.Errorf("invalid dedupField: %w", err)
+	}
+	if maxKeys < 0 {
+		return nil, fmt.Errorf("dedupWindowSize must not be negative")
+	} else if maxKeys == 0 {
+		maxKeys = defaultDedupWindowSize
+	}
+	if ttlSeconds < 0 {
+		return nil, fmt.Errorf("dedupWindowSeconds must not be negative")
+	}
+	return &dedupWindow{
+		tokens:  tokens,
+		maxKeys: maxKeys,
+		ttl:     time.Duration(ttlSeconds) * time.Second,
+		now:     time.Now,
+		entries: list.New(),
+		index:   make(map[dedupKey]*list.Element),
+	}, nil
+}
+
+// isDuplicate returns whether the record duplicates one which is within the window, and otherwise
+// adds it to the window. Records which don't have the dedup field as a scalar value are never
+// duplicates. The values of the field are compared as they are for the keyField, so a string and
+// a number with the same digits are considered equal.
+func (w *dedupWindow) isDuplicate(stream string, data []byte) (bool, error) {
+	if w == nil {
+		return false, nil
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil || doc == nil {
+		return false, fmt.Errorf("deduplicating record: record is not a JSON object")
+	}
+	var value, ok = (&keyExtractor{tokens: w.tokens}).extract(doc)
+	if !ok {
+		return false, nil
+	}
+	var key = dedupKey{stream: stream, value: value}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	var now = w.now()
+	w.expire(now)
+	if _, ok := w.index[key]; ok {
+		return true, nil
+	}
+	w.index[key] = w.entries.PushBack(&dedupEntry{key: key, capturedAt: now})
+	for w.entries.Len() > w.maxKeys {
+		w.remove(w.entries.Front())
+	}
+	return false, nil
+}
+
+// expire removes the entries which were captured longer than the ttl ago.
+func (w *dedupWindow) expire(now time.Time) {
+	if w.ttl == 0 {
+		return
+	}
+	for front := w.entries.Front(); front != nil; front = w.entries.Front() {
+		if now.Sub(front.Value.(*dedupEntry).capturedAt) < w.ttl {
+			return
+		}
+		w.remove(front)
+	}
+}
+
+func (w *dedupWindow) remove(elem *list.Element) {
+	delete(w.index, elem.Value.(*dedupEntry).key)
+	w.entries.Remove(elem)
+}
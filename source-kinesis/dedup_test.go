@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/estuary/flow/go/protocols/airbyte"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDedupWindow(t *testing.T) {
+	var window, err = newDedupWindow("/meta/id", 3, 0)
+	require.NoError(t, err)
+
+	var expectDuplicates = func(stream string, expect []bool, ids ...string) {
+		t.Helper()
+		for i, id := range ids {
+			var dup, err = window.isDuplicate(stream, []byte(fmt.Sprintf(`{"meta":{"id":%s}}`, id)))
+			require.NoError(t, err)
+			require.Equal(t, expect[i], dup, "id %s", id)
+		}
+	}
+
+	// Duplicates within the window are dropped, including those with equal numbers and strings.
+	expectDuplicates("a", []bool{false, false, true, false, true}, `"x"`, `1`, `"x"`, `true`, `"1"`)
+	// The same ids in another stream aren't duplicates.
+	expectDuplicates("b", []bool{false, true}, `"x"`, `"x"`)
+	// The window is full, so "x" of stream "a" was forgotten when "x" of stream "b" was added.
+	expectDuplicates("a", []bool{false, true}, `"x"`, `"x"`)
+
+	// Records without the field, or with a field that isn't a scalar, are never duplicates.
+	for _, record := range []string{`{}`, `{"meta":{}}`, `{"meta":{"id":null}}`, `{"meta":{"id":[1]}}`, `{"meta":"id"}`} {
+		for i := 0; i < 2; i++ {
+			dup, err := window.isDuplicate("a", []byte(record))
+			require.NoError(t, err)
+			require.False(t, dup, record)
+		}
+	}
+	// Records must be JSON objects.
+	_, err = window.isDuplicate("a", []byte(`"not an object"`))
+	require.Error(t, err)
+
+	// A nil window never drops records.
+	window, err = newDedupWindow("", 0, 0)
+	require.NoError(t, err)
+	require.Nil(t, window)
+	dup, err := window.isDuplicate("a", []byte(`not even JSON`))
+	require.NoError(t, err)
+	require.False(t, dup)
+}
+
+func TestDedupWindowExpiry(t *testing.T) {
+	var window, err = newDedupWindow("/id", 0, 60)
+	require.NoError(t, err)
+	require.Equal(t, defaultDedupWindowSize, window.maxKeys)
+
+	var now = time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	window.now = func() time.Time { return now }
+	var isDuplicate = func(id string) bool {
+		t.Helper()
+		var dup, err = window.isDuplicate("stream", []byte(fmt.Sprintf(`{"id":%q}`, id)))
+		require.NoError(t, err)
+		return dup
+	}
+
+	require.False(t, isDuplicate("a"))
+	now = now.Add(30 * time.Second)
+	require.False(t, isDuplicate("b"))
+	// Duplicates don't extend how long the original is remembered.
+	now = now.Add(29 * time.Second)
+	require.True(t, isDuplicate("a"))
+	require.True(t, isDuplicate("b"))
+	// "a" was captured 60 seconds ago, so it's been forgotten, while "b" is still remembered.
+	now = now.Add(time.Second)
+	require.False(t, isDuplicate("a"))
+	require.True(t, isDuplicate("b"))
+	now = now.Add(30 * time.Second)
+	require.False(t, isDuplicate("b"))
+	require.Equal(t, 2, window.entries.Len())
+	require.Len(t, window.index, 2)
+}
+
+func TestDedupWindowValidation(t *testing.T) {
+	for _, tc := range []struct {
+		pointer     string
+		size, secs  int
+		expectError string
+	}{
+		{"", 0, 0, ""},
+		{"/id", 10, 60, ""},
+		{"/a~1b/c", 0, 0, ""},
+		{"id", 0, 0, "invalid dedupField"},
+		{"/a//b", 0, 0, "invalid dedupField"},
+		{"/id", -1, 0, "dedupWindowSize must not be negative"},
+		{"/id", 0, -1, "dedupWindowSeconds must not be negative"},
+		{"", 10, 0, "require a dedupField"},
+		{"", 0, 60, "require a dedupField"},
+	} {
+		var _, err = newDedupWindow(tc.pointer, tc.size, tc.secs)
+		if tc.expectError == "" {
+			require.NoError(t, err, tc.pointer)
+		} else {
+			require.Error(t, err, tc.pointer)
+			require.Contains(t, err.Error(), tc.expectError)
+		}
+	}
+}
+
+func TestExtractRecordsWithDedup(t *testing.T) {
+	var dedup, err = newDedupWindow("/id", 10, 0)
+	require.NoError(t, err)
+	var reader = &shardReader{
+		rangeOverlap: airbyte.FullRangeOverlap,
+		parent:       &streamReader{stream: "stream", readerOptions: readerOptions{dedup: dedup}},
+	}
+
+	var resp = &kinesis.GetRecordsOutput{}
+	for i, data := range []string{
+		`{"id":"a","n":1}`,
+		`{"id":"b","n":2}`,
+		`{"id":"a","n":3}`,
+		`{"n":4}`,
+		`{"n":5}`,
+	} {
+		resp.Records = append(resp.Records, types.Record{
+			Data:           []byte(data),
+			PartitionKey:   aws.String("pk"),
+			SequenceNumber: aws.String(string(rune('a' + i))),
+		})
+	}
+	extracted, _, _, err := reader.extractRecords(resp)
+	require.NoError(t, err)
+	require.Len(t, extracted, 4)
+	require.JSONEq(t, `{"id":"b","n":2}`, string(extracted[1]))
+	require.JSONEq(t, `{"n":4}`, string(extracted[2]))
+	require.Equal(t, int64(1), reader.duplicates)
+
+	// Duplicates of records from earlier responses are dropped as well.
+	extracted, _, _, err = reader.extractRecords(resp)
+	require.NoError(t, err)
+	require.Len(t, extracted, 2)
+	require.Equal(t, int64(4), reader.duplicates)
+}
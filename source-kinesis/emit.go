@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/estuary/flow/go/protocols/airbyte"
+)
+
+// messageOutput represents "the thing to which the capture writes records and state checkpoints".
+// A json.Encoder writing to stdout satisfies this interface in normal usage, but the capture may
+// also be embedded with another output, and during tests a custom messageOutput is used which
+// collects output in memory.
+type messageOutput interface {
+	Encode(v interface{}) error
+}
+
+// resultEmitter writes the results read from kinesis to an output, along with the state updates
+// that cover them.
+type resultEmitter struct {
+	config *Config
+	output messageOutput
+	state  stateMap
+	// inFlight is released of the records of each result once they've been written.
+	inFlight *inFlightLimiter
+	// leaseCoordinators holds the coordinator of each stream when leases are used, and is
+	// otherwise empty.
+	leaseCoordinators map[string]*leaseCoordinator
+	tracker           *schemaTracker
+	compactor         *stateCompactor
+}
+
+func newResultEmitter(config *Config, output messageOutput, state stateMap, inFlight *inFlightLimiter) *resultEmitter {
+	return &resultEmitter{
+		config:            config,
+		output:            output,
+		state:             state,
+		inFlight:          inFlight,
+		leaseCoordinators: make(map[string]*leaseCoordinator),
+		compactor:         newStateCompactor(),
+	}
+}
+
+// emitAll writes each result until the channel is closed, returning the first error of a result
+// or of the output.
+func (e *resultEmitter) emitAll(dataCh <-chan readResult) error {
+	for next := range dataCh {
+		if next.err != nil {
+			// time to bail
+			var errMessage = airbyte.NewLogMessage(airbyte.LogLevelFatal, "read failed due to error: %v", next.err)
+			// Printing the error may fail, but we'll ignore that error and return the original
+			_ = e.output.Encode(errMessage)
+			return next.err
+		}
+		if err := e.emit(next); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// emit writes the records of a result followed by the updated state.
+func (e *resultEmitter) emit(next readResult) error {
+	// We'll re-use this same message instance for all records of the result
+	var recordMessage = airbyte.Message{
+		Type: airbyte.MessageTypeRecord,
+		Record: &airbyte.Record{
+			Stream:    next.source.stream,
+			Namespace: recordNamespace(e.config, next.source),
+		},
+	}
+	for i, record := range next.records {
+		e.tracker.observe(next.source.stream, record)
+		recordMessage.Record.Data = record
+		var ts time.Time
+		if next.timestamps != nil {
+			ts = next.timestamps[i]
+		}
+		recordMessage.Record.EmittedAt = emittedAt(ts)
+		if err := e.output.Encode(recordMessage); err != nil {
+			return err
+		}
+	}
+	if next.heartbeat && e.state[next.source.stream][next.source.shardID] == next.sequenceNumber {
+		// The heartbeat of an idle shard doesn't need to be emitted if the shard's state hasn't
+		// changed since the last one.
+		return nil
+	}
+	if next.sequenceNumber != "" {
+		updateState(e.state, next.source, next.sequenceNumber)
+	}
+	e.compactor.update(e.state, next)
+
+	var stateRaw, err = json.Marshal(e.state)
+	if err != nil {
+		return err
+	}
+	if err = e.output.Encode(airbyte.Message{
+		Type:  airbyte.MessageTypeState,
+		State: &airbyte.State{Data: json.RawMessage(stateRaw)},
+	}); err != nil {
+		return err
+	}
+	// Now that the records and the state that covers them have been written, the readers
+	// can go fetch more, and the lease checkpoint can be advanced.
+	e.inFlight.release(int64(len(next.records)))
+	if coordinator, ok := e.leaseCoordinators[next.source.stream]; ok && next.sequenceNumber != "" {
+		coordinator.checkpoint(next.source.shardID, next.sequenceNumber)
+	}
+	return nil
+}
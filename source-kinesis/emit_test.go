@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/estuary/flow/go/protocols/airbyte"
+	"github.com/stretchr/testify/require"
+)
+
+// memoryOutput collects the messages written by the capture in memory, and fails once a given
+// number of messages have been written if failAfter is set.
+type memoryOutput struct {
+	records   []airbyte.Record
+	states    []stateMap
+	logs      []airbyte.Log
+	failAfter int
+}
+
+func (o *memoryOutput) Encode(v interface{}) error {
+	var msg, ok = v.(airbyte.Message)
+	if !ok {
+		return fmt.Errorf("output message is not an airbyte.Message: %#v", v)
+	} else if o.failAfter > 0 && len(o.records)+len(o.states)+len(o.logs) == o.failAfter {
+		return fmt.Errorf("output is broken")
+	}
+	switch msg.Type {
+	case airbyte.MessageTypeRecord:
+		o.records = append(o.records, *msg.Record)
+	case airbyte.MessageTypeState:
+		var state stateMap
+		if err := json.Unmarshal(msg.State.Data, &state); err != nil {
+			return err
+		}
+		o.states = append(o.states, state)
+	case airbyte.MessageTypeLog:
+		o.logs = append(o.logs, *msg.Log)
+	default:
+		return fmt.Errorf("unhandled message type: %#v", msg.Type)
+	}
+	return nil
+}
+
+// emitResults runs an emitter over the given results, returning its error.
+func emitResults(config *Config, output *memoryOutput, inFlight *inFlightLimiter, results ...readResult) error {
+	var dataCh = make(chan readResult, len(results))
+	for _, result := range results {
+		dataCh <- result
+	}
+	close(dataCh)
+	return newResultEmitter(config, output, make(stateMap), inFlight).emitAll(dataCh)
+}
+
+func TestResultEmitter(t *testing.T) {
+	var shard0 = &recordSource{stream: "test-stream", shardID: "shard-0"}
+	var shard1 = &recordSource{stream: "test-stream", shardID: "shard-1"}
+	var other = &recordSource{stream: "other-stream", shardID: "shard-0"}
+	var records = func(docs ...string) []json.RawMessage {
+		var out []json.RawMessage
+		for _, doc := range docs {
+			out = append(out, json.RawMessage(doc))
+		}
+		return out
+	}
+
+	var inFlight = newInFlightLimiter(10)
+	var _, err = inFlight.acquire(context.Background(), 3)
+	require.NoError(t, err)
+
+	var output = new(memoryOutput)
+	require.NoError(t, emitResults(&Config{ShardNamespace: true}, output, inFlight,
+		readResult{source: shard0, records: records(`{"n":1}`, `{"n":2}`), sequenceNumber: "2"},
+		readResult{source: other, records: records(`{"n":3}`), sequenceNumber: "5"},
+		// The heartbeat of an idle shard is only emitted if it advances the shard's state.
+		readResult{source: shard1, sequenceNumber: "7", heartbeat: true},
+		readResult{source: shard1, sequenceNumber: "7", heartbeat: true},
+	))
+
+	require.Len(t, output.records, 3)
+	for i, expect := range []struct {
+		stream, namespace, data string
+	}{
+		{"test-stream", "shard-0", `{"n":1}`},
+		{"test-stream", "shard-0", `{"n":2}`},
+		{"other-stream", "shard-0", `{"n":3}`},
+	} {
+		require.Equal(t, expect.stream, output.records[i].Stream)
+		require.Equal(t, expect.namespace, output.records[i].Namespace)
+		require.JSONEq(t, expect.data, string(output.records[i].Data))
+		require.NotZero(t, output.records[i].EmittedAt)
+	}
+	require.Equal(t, []stateMap{
+		{"test-stream": {"shard-0": "2"}},
+		{"test-stream": {"shard-0": "2"}, "other-stream": {"shard-0": "5"}},
+		{"test-stream": {"shard-0": "2", "shard-1": "7"}, "other-stream": {"shard-0": "5"}},
+	}, output.states)
+	require.Empty(t, output.logs)
+	// The records are released once they've been written along with their state.
+	require.Equal(t, int64(0), inFlight.inFlight())
+}
+
+func TestResultEmitterErrors(t *testing.T) {
+	var source = &recordSource{stream: "test-stream", shardID: "shard-0"}
+	var result = readResult{source: source, records: []json.RawMessage{json.RawMessage(`{}`)}, sequenceNumber: "1"}
+
+	// The error of a read is logged as fatal, and ends the capture without emitting anything
+	// which follows it.
+	var output = new(memoryOutput)
+	var err = emitResults(&Config{}, output, newInFlightLimiter(10),
+		result,
+		readResult{source: source, err: fmt.Errorf("kinesis is down")},
+		result,
+	)
+	require.EqualError(t, err, "kinesis is down")
+	require.Len(t, output.records, 1)
+	require.Len(t, output.states, 1)
+	require.Len(t, output.logs, 1)
+	require.Equal(t, airbyte.LogLevelFatal, output.logs[0].Level)
+	require.Contains(t, output.logs[0].Message, "kinesis is down")
+
+	// An error of the output ends the capture before the state covering the unwritten records
+	// is emitted.
+	output = &memoryOutput{failAfter: 3}
+	err = emitResults(&Config{}, output, newInFlightLimiter(10), result, result, result)
+	require.EqualError(t, err, "output is broken")
+	require.Len(t, output.records, 2)
+	require.Equal(t, []stateMap{{"test-stream": {"shard-0": "1"}}}, output.states)
+}
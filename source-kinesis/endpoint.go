@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+)
+
+// defaultEndpointRegion is the region used to sign requests to a custom endpoint when the config
+// doesn't provide one. Kinesis-compatible services such as LocalStack accept any region.
+const defaultEndpointRegion = "us-east-1"
+
+// validateEndpoint checks that a custom endpoint is an absolute http or https URL.
+func validateEndpoint(endpoint string) error {
+	var u, err = url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid endpoint %q: %w", endpoint, err)
+	} else if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("invalid endpoint %q: must begin with 'http://' or 'https://'", endpoint)
+	} else if u.Host == "" {
+		return fmt.Errorf("invalid endpoint %q: missing host", endpoint)
+	}
+	return nil
+}
+
+// sessionRegion returns the region of the AWS session, which defaults to defaultEndpointRegion if
+// the config has a custom endpoint.
+func sessionRegion(config *Config) string {
+	if config.Region == "" && config.Endpoint != "" {
+		return defaultEndpointRegion
+	}
+	return config.Region
+}
+
+// kinesisEndpointOptions returns options of the kinesis client, which send requests to the
+// config's endpoint instead of that of AWS if it has one. Plain http is used if the endpoint says
+// so, which is typical of local emulators.
+func kinesisEndpointOptions(config *Config) func(*kinesis.Options) {
+	return func(o *kinesis.Options) {
+		if config.Endpoint != "" {
+			o.EndpointResolver = kinesis.EndpointResolverFromURL(config.Endpoint)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEndpointConfig(t *testing.T) {
+	var base = Config{AWSAccessKeyID: "x", AWSSecretAccessKey: "x"}
+
+	// The region is only optional when there's an endpoint.
+	var conf = base
+	require.EqualError(t, conf.Validate(), "missing region")
+	conf.Endpoint = "http://localhost:4566"
+	require.NoError(t, conf.Validate())
+	require.Equal(t, defaultEndpointRegion, sessionRegion(&conf))
+	conf.Region = "local"
+	require.Equal(t, "local", sessionRegion(&conf))
+
+	for _, invalid := range []string{"localhost:4566", "ftp://localhost", "http://", "http://local host"} {
+		conf.Endpoint = invalid
+		require.Error(t, conf.Validate(), invalid)
+	}
+
+	// Requests are sent to the endpoint as it's given, including plain http endpoints.
+	var resolve = func(endpoint string) (string, bool) {
+		conf.Endpoint = endpoint
+		var opts kinesis.Options
+		kinesisEndpointOptions(&conf)(&opts)
+		if opts.EndpointResolver == nil {
+			return "", false
+		}
+		var resolved, err = opts.EndpointResolver.ResolveEndpoint("local", kinesis.EndpointResolverOptions{})
+		require.NoError(t, err)
+		return resolved.URL, true
+	}
+	var url, ok = resolve("http://localhost:4566")
+	require.True(t, ok)
+	require.Equal(t, "http://localhost:4566", url)
+
+	url, ok = resolve("https://kinesis.example.com")
+	require.True(t, ok)
+	require.Equal(t, "https://kinesis.example.com", url)
+
+	_, ok = resolve("")
+	require.False(t, ok)
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+)
+
+// heartbeatClockSkew is subtracted from the time of the request which found an idle shard to be
+// caught up, when that time is stored in a heartbeat position. It allows for the clock of the
+// connector being ahead of that of kinesis, and for records which had arrived just before the
+// request but weren't yet readable.
+const heartbeatClockSkew = 5 * time.Second
+
+// A heartbeat position is stored in the state of a kinesis shard which has been idle, so that a
+// restarted read doesn't have to scan from the last record that was emitted, which may be long
+// ago. It's the position of that record, if any, followed by an `@` and the time in milliseconds
+// since the epoch before which every record of the shard has been read. A read of the shard
+// resumes at that time.
+func formatHeartbeatPosition(position string, readThrough time.Time) string {
+	return position + "@" + strconv.FormatInt(readThrough.UnixNano()/int64(time.Millisecond), 10)
+}
+
+// parseHeartbeatPosition splits a position into the position of the last record which was emitted
+// and the time through which the shard has been read, if it's a heartbeat position. Otherwise the
+// position is returned as it is, along with a zero time.
+func parseHeartbeatPosition(position string) (string, time.Time, error) {
+	var idx = strings.IndexByte(position, '@')
+	if idx == -1 {
+		return position, time.Time{}, nil
+	}
+	var millis, err = strconv.ParseInt(position[idx+1:], 10, 64)
+	if err != nil || millis < 0 {
+		return "", time.Time{}, fmt.Errorf("invalid heartbeat time in position %q", position)
+	}
+	return position[:idx], time.Unix(0, millis*int64(time.Millisecond)).UTC(), nil
+}
+
+// maybeHeartbeat sends a heartbeat position for the shard if the response shows that it's caught
+// up with the tip of the shard, and the shard's position hasn't been sent for at least the
+// checkpoint interval. The `requestedAt` time is when the GetRecords request was sent. It returns
+// false if the context was cancelled while sending.
+func (r *shardReader) maybeHeartbeat(resp *kinesis.GetRecordsOutput, requestedAt time.Time) bool {
+	var interval = r.parent.checkpointInterval
+	if interval == 0 || resp.MillisBehindLatest == nil || *resp.MillisBehindLatest > 0 {
+		return true
+	} else if time.Since(r.lastCheckpointAt) < interval {
+		return true
+	}
+
+	var position, _, err = parseHeartbeatPosition(r.lastSequenceID)
+	if err != nil {
+		return true
+	} else if _, sub, err := parsePosition(position); err != nil || sub != -1 {
+		// The read stopped partway through an aggregated record, which must be read again.
+		return true
+	}
+	// Every record which arrived before the request has been read. Those which were emitted arrived
+	// before the time which is stored, so that they aren't read again.
+	var readThrough = requestedAt.Add(-heartbeatClockSkew)
+	if !r.lastArrivalAt.IsZero() && !readThrough.After(r.lastArrivalAt) {
+		readThrough = r.lastArrivalAt.Add(time.Millisecond)
+	}
+	var heartbeat = formatHeartbeatPosition(position, readThrough)
+
+	select {
+	case r.parent.dataCh <- readResult{source: r.source, sequenceNumber: heartbeat, heartbeat: true}:
+		r.logEntry.WithField("position", heartbeat).Debug("sent heartbeat position of idle kinesis shard")
+		r.lastSequenceID = heartbeat
+		r.lastCheckpointAt = time.Now()
+		return true
+	case <-r.ctx.Done():
+		return false
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeartbeatPositions(t *testing.T) {
+	var at = time.Date(2022, 3, 4, 5, 6, 7, 8000000, time.UTC)
+	var position = formatHeartbeatPosition("49590338271490256608559692538361571095921575989136588898", at)
+	require.Equal(t, "49590338271490256608559692538361571095921575989136588898@1646370367008", position)
+
+	record, readThrough, err := parseHeartbeatPosition(position)
+	require.NoError(t, err)
+	require.Equal(t, "49590338271490256608559692538361571095921575989136588898", record)
+	require.Equal(t, at, readThrough)
+
+	// Shards from which no record has been read have an empty record position.
+	record, readThrough, err = parseHeartbeatPosition(formatHeartbeatPosition("", at))
+	require.NoError(t, err)
+	require.Equal(t, "", record)
+	require.Equal(t, at, readThrough)
+
+	// Positions of records are returned as they are.
+	record, readThrough, err = parseHeartbeatPosition("123:4")
+	require.NoError(t, err)
+	require.Equal(t, "123:4", record)
+	require.True(t, readThrough.IsZero())
+
+	for _, invalid := range []string{"123@", "123@abc", "123@-1"} {
+		_, _, err = parseHeartbeatPosition(invalid)
+		require.Error(t, err, invalid)
+	}
+
+	// Heartbeats are after the record they follow, and later heartbeats are after earlier ones.
+	for _, tc := range []struct {
+		a, b   string
+		expect int
+	}{
+		{"123", "123@1000", -1},
+		{"123@1000", "123@2000", -1},
+		{"123@2000", "124", -1},
+		{"123:4", "123@1000", -1},
+		{"@1000", "123", -1},
+		{"@1000", "@2000", -1},
+		{"123@1000", "123@1000", 0},
+	} {
+		require.Equal(t, tc.expect, comparePositions(tc.a, tc.b), "%s vs %s", tc.a, tc.b)
+		require.Equal(t, -tc.expect, comparePositions(tc.b, tc.a), "%s vs %s", tc.b, tc.a)
+	}
+	require.Equal(t, "123@1000", laterSequenceNumber("123", "123@1000"))
+}
+
+func TestMaybeHeartbeat(t *testing.T) {
+	var dataCh = make(chan readResult, 1)
+	var source = &recordSource{stream: "test-stream", shardID: "shardId-000000000000"}
+	var reader = &shardReader{
+		ctx:      context.Background(),
+		parent:   &streamReader{dataCh: dataCh, readerOptions: readerOptions{checkpointInterval: time.Minute}},
+		source:   source,
+		logEntry: log.WithField("kinesisShardId", source.shardID),
+	}
+	var caughtUp = &kinesis.GetRecordsOutput{MillisBehindLatest: aws.Int64(0)}
+	var requestedAt = time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	// Nothing is sent until the interval has passed since the position was last sent.
+	reader.lastCheckpointAt = time.Now()
+	require.True(t, reader.maybeHeartbeat(caughtUp, requestedAt))
+	require.Len(t, dataCh, 0)
+
+	// Nor if the shard isn't caught up with its tip.
+	reader.lastCheckpointAt = time.Now().Add(-2 * time.Minute)
+	require.True(t, reader.maybeHeartbeat(&kinesis.GetRecordsOutput{MillisBehindLatest: aws.Int64(5)}, requestedAt))
+	require.Len(t, dataCh, 0)
+
+	// A shard from which nothing has been read is read through a little before the request.
+	require.True(t, reader.maybeHeartbeat(caughtUp, requestedAt))
+	var result = <-dataCh
+	require.True(t, result.heartbeat)
+	require.Empty(t, result.records)
+	require.Equal(t, formatHeartbeatPosition("", requestedAt.Add(-heartbeatClockSkew)), result.sequenceNumber)
+	require.Equal(t, result.sequenceNumber, reader.lastSequenceID)
+	require.WithinDuration(t, time.Now(), reader.lastCheckpointAt, time.Second)
+
+	// Records which were read aren't read again, even if they arrived within the clock skew.
+	reader.lastSequenceID = "123"
+	reader.lastArrivalAt = requestedAt.Add(-time.Second)
+	reader.lastCheckpointAt = time.Now().Add(-2 * time.Minute)
+	require.True(t, reader.maybeHeartbeat(caughtUp, requestedAt))
+	result = <-dataCh
+	require.Equal(t, formatHeartbeatPosition("123", requestedAt.Add(-time.Second+time.Millisecond)), result.sequenceNumber)
+
+	// Later heartbeats replace earlier ones.
+	reader.lastCheckpointAt = time.Now().Add(-2 * time.Minute)
+	require.True(t, reader.maybeHeartbeat(caughtUp, requestedAt.Add(time.Minute)))
+	result = <-dataCh
+	require.Equal(t, formatHeartbeatPosition("123", requestedAt.Add(time.Minute-heartbeatClockSkew)), result.sequenceNumber)
+
+	// Reads which stopped partway through an aggregated record don't send heartbeats.
+	reader.lastSequenceID = "124:3"
+	reader.lastCheckpointAt = time.Now().Add(-2 * time.Minute)
+	require.True(t, reader.maybeHeartbeat(caughtUp, requestedAt))
+	require.Len(t, dataCh, 0)
+
+	// Heartbeats are disabled without an interval.
+	reader.lastSequenceID = "124"
+	reader.parent.checkpointInterval = 0
+	require.True(t, reader.maybeHeartbeat(caughtUp, requestedAt))
+	require.Len(t, dataCh, 0)
+}
+
+func TestResumeFromHeartbeat(t *testing.T) {
+	var requests []map[string]interface{}
+	var httpServer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		var input map[string]interface{}
+		require.NoError(t, json.NewDecoder(req.Body).Decode(&input))
+		requests = append(requests, input)
+		w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+		json.NewEncoder(w).Encode(map[string]string{"ShardIterator": "iterator"})
+	}))
+	defer httpServer.Close()
+
+	var client = kinesis.NewFromConfig(testAWSConfig(httpServer.URL))
+
+	var source = &recordSource{stream: "test-stream", shardID: "shardId-000000000000"}
+	var reader = &shardReader{
+		ctx:            context.Background(),
+		parent:         &streamReader{client: client, stream: source.stream},
+		source:         source,
+		lastSequenceID: "123@1646370367008",
+		resuming:       true,
+		logEntry:       log.WithField("kinesisShardId", source.shardID),
+	}
+	var _, err = reader.getShardIterator()
+	require.NoError(t, err)
+
+	// The shard is read from the time of the heartbeat, rather than after its last record.
+	require.Len(t, requests, 1)
+	require.Equal(t, string(types.ShardIteratorTypeAtTimestamp), requests[0]["ShardIteratorType"])
+	require.Equal(t, 1646370367.008, requests[0]["Timestamp"])
+	require.NotContains(t, requests[0], "StartingSequenceNumber")
+
+	reader.lastSequenceID = "123@abc"
+	_, err = reader.getShardIterator()
+	require.Error(t, err)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Actions which are taken when the lag of the capture stays above `maxLagSeconds`.
+const (
+	lagActionWarn = "warn"
+	lagActionExit = "exit"
+)
+
+// defaultMaxLagDuration is used when the config doesn't specify `maxLagDurationSeconds`. It's long
+// enough that the lag of a capture which is catching up after a restart won't trigger the action.
+const defaultMaxLagDuration = 5 * time.Minute
+
+// lagMonitor tracks the `MillisBehindLatest` of each kinesis shard that's being read, and takes an
+// action when the lag of the capture, which is that of the shard that's furthest behind, has been
+// above a threshold for a sustained period. This allows an orchestrator to notice a capture that
+// can't keep up with its streams, and scale it out.
+type lagMonitor struct {
+	maxLag    time.Duration
+	sustained time.Duration
+	action    string
+	// now returns the current time, and is replaced by tests.
+	now func() time.Time
+
+	mu sync.Mutex
+	// shardLags is the most recently reported lag of each shard, keyed by stream and shard id.
+	shardLags map[string]time.Duration
+	// exceededSince is the time at which the lag first exceeded maxLag, or zero if it's within it.
+	exceededSince time.Time
+	// warned is set once the warning has been logged for the current period of excessive lag.
+	warned bool
+}
+
+// newLagMonitor returns a lagMonitor for the given configuration, or nil if the maximum lag is
+// zero. The sustained duration defaults to defaultMaxLagDuration, and the action to `warn`.
+func newLagMonitor(maxLagSeconds, maxLagDurationSeconds int, action string) (*lagMonitor, error) {
+	if maxLagSeconds == 0 {
+		return nil, nil
+	} else if maxLagSeconds < 0 {
+		return nil, fmt.Errorf("maxLagSeconds must not be negative")
+	}
+	var sustained = time.Duration(maxLagDurationSeconds) * time.Second
+	if maxLagDurationSeconds == 0 {
+		sustained = defaultMaxLagDuration
+	} else if maxLagDurationSeconds < 0 {
+		return nil, fmt.Errorf("maxLagDurationSeconds must not be negative")
+	}
+	switch action {
+	case "":
+		action = lagActionWarn
+	case lagActionWarn, lagActionExit:
+	default:
+		return nil, fmt.Errorf("invalid lagAction %q", action)
+	}
+	return &lagMonitor{
+		maxLag:    time.Duration(maxLagSeconds) * time.Second,
+		sustained: sustained,
+		action:    action,
+		now:       time.Now,
+		shardLags: make(map[string]time.Duration),
+	}, nil
+}
+
+// observe records the `MillisBehindLatest` of a GetRecords response from the shard, which may be
+// nil if kinesis didn't report it. An error is returned if the lag of the capture has been
+// excessive for long enough, and the action is to exit.
+func (m *lagMonitor) observe(source *recordSource, millisBehindLatest *int64) error {
+	if m == nil || millisBehindLatest == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.shardLags[lagKey(source)] = time.Duration(*millisBehindLatest) * time.Millisecond
+	return m.check()
+}
+
+// forget stops tracking the lag of the shard, which is no longer being read.
+func (m *lagMonitor) forget(source *recordSource) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.shardLags, lagKey(source))
+	// The action is only ever taken in response to a shard's lag, so any error is ignored here.
+	_ = m.check()
+}
+
+// lag returns the lag of the capture, which is the greatest lag of any shard.
+func (m *lagMonitor) lag() time.Duration {
+	var max time.Duration
+	for _, lag := range m.shardLags {
+		if lag > max {
+			max = lag
+		}
+	}
+	return max
+}
+
+func (m *lagMonitor) check() error {
+	var lag = m.lag()
+	if lag <= m.maxLag {
+		if m.warned {
+			log.WithFields(log.Fields{
+				"event": "lag_recovered",
+				"lag":   lag.String(),
+			}).Info("capture lag is back within maxLagSeconds")
+		}
+		m.exceededSince = time.Time{}
+		m.warned = false
+		return nil
+	}
+
+	var now = m.now()
+	if m.exceededSince.IsZero() {
+		m.exceededSince = now
+	}
+	var elapsed = now.Sub(m.exceededSince)
+	if elapsed < m.sustained {
+		return nil
+	}
+	if !m.warned {
+		log.WithFields(log.Fields{
+			"event":    "lag_exceeded",
+			"lag":      lag.String(),
+			"maxLag":   m.maxLag.String(),
+			"duration": elapsed.String(),
+			"action":   m.action,
+		}).Warn("capture lag has exceeded maxLagSeconds")
+		m.warned = true
+	}
+	if m.action == lagActionExit {
+		return fmt.Errorf("capture lag of %s has exceeded the maxLagSeconds of %s for %s", lag, m.maxLag, elapsed)
+	}
+	return nil
+}
+
+func lagKey(source *recordSource) string {
+	return source.stream + "/" + source.shardID
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLagMonitor(t *testing.T) {
+	var hook = logtest.NewGlobal()
+	defer hook.Reset()
+
+	var monitor, err = newLagMonitor(60, 120, lagActionWarn)
+	require.NoError(t, err)
+	var now = time.Unix(1600000000, 0)
+	monitor.now = func() time.Time { return now }
+
+	var shard1 = &recordSource{stream: "stream", shardID: "shard1"}
+	var shard2 = &recordSource{stream: "stream", shardID: "shard2"}
+	var millis = func(d time.Duration) *int64 {
+		var m = d.Milliseconds()
+		return &m
+	}
+	var lagEvents = func() []string {
+		var events []string
+		for _, entry := range hook.AllEntries() {
+			if event, ok := entry.Data["event"].(string); ok {
+				events = append(events, event)
+			}
+		}
+		return events
+	}
+
+	// Responses which don't report a lag are ignored.
+	require.NoError(t, monitor.observe(shard1, nil))
+	require.Equal(t, time.Duration(0), monitor.lag())
+
+	// The lag of the capture is that of the shard which is furthest behind.
+	require.NoError(t, monitor.observe(shard1, millis(90*time.Second)))
+	require.NoError(t, monitor.observe(shard2, millis(10*time.Second)))
+	require.Equal(t, 90*time.Second, monitor.lag())
+
+	// Nothing happens until the lag has been excessive for the sustained duration.
+	now = now.Add(time.Minute)
+	require.NoError(t, monitor.observe(shard2, millis(5*time.Second)))
+	require.Empty(t, lagEvents())
+
+	// The lag briefly dropping below the maximum restarts the sustained duration.
+	require.NoError(t, monitor.observe(shard1, millis(30*time.Second)))
+	now = now.Add(time.Minute)
+	require.NoError(t, monitor.observe(shard1, millis(90*time.Second)))
+	now = now.Add(90 * time.Second)
+	require.NoError(t, monitor.observe(shard1, millis(100*time.Second)))
+	require.Empty(t, lagEvents())
+
+	// Once the duration has elapsed a warning is logged, but only once for each period of
+	// excessive lag.
+	now = now.Add(time.Minute)
+	require.NoError(t, monitor.observe(shard1, millis(120*time.Second)))
+	now = now.Add(time.Minute)
+	require.NoError(t, monitor.observe(shard1, millis(120*time.Second)))
+	require.Equal(t, []string{"lag_exceeded"}, lagEvents())
+	require.Equal(t, log.WarnLevel, hook.LastEntry().Level)
+	require.Equal(t, "2m30s", hook.LastEntry().Data["duration"])
+
+	// Shards which are no longer being read don't count towards the lag.
+	monitor.forget(shard1)
+	require.Equal(t, 5*time.Second, monitor.lag())
+	require.Equal(t, []string{"lag_exceeded", "lag_recovered"}, lagEvents())
+}
+
+func TestLagMonitorExit(t *testing.T) {
+	var monitor, err = newLagMonitor(60, 0, lagActionExit)
+	require.NoError(t, err)
+	require.Equal(t, defaultMaxLagDuration, monitor.sustained)
+	var now = time.Unix(1600000000, 0)
+	monitor.now = func() time.Time { return now }
+
+	var shard = &recordSource{stream: "stream", shardID: "shard"}
+	var lag = int64(61000)
+	for i := 0; i < 5; i++ {
+		require.NoError(t, monitor.observe(shard, &lag))
+		now = now.Add(time.Minute)
+	}
+	require.EqualError(t, monitor.observe(shard, &lag), "capture lag of 1m1s has exceeded the maxLagSeconds of 1m0s for 5m0s")
+}
+
+func TestNewLagMonitor(t *testing.T) {
+	var monitor, err = newLagMonitor(0, 30, lagActionExit)
+	require.NoError(t, err)
+	require.Nil(t, monitor)
+	// A nil monitor never takes any action.
+	var lag = int64(1000000)
+	require.NoError(t, monitor.observe(&recordSource{}, &lag))
+	monitor.forget(&recordSource{})
+
+	monitor, err = newLagMonitor(60, 0, "")
+	require.NoError(t, err)
+	require.Equal(t, lagActionWarn, monitor.action)
+
+	_, err = newLagMonitor(-1, 0, "")
+	require.EqualError(t, err, "maxLagSeconds must not be negative")
+	_, err = newLagMonitor(60, -1, "")
+	require.EqualError(t, err, "maxLagDurationSeconds must not be negative")
+	_, err = newLagMonitor(60, 0, "scale")
+	require.EqualError(t, err, `invalid lagAction "scale"`)
+}
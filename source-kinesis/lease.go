@@ -0,0 +1,469 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	dynamodbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/google/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	coordinationRange    = "range"
+	coordinationDynamoDB = "dynamodb"
+)
+
+// defaultLeaseDuration is how long a lease may go without being renewed before other workers
+// consider it to be expired. Leases are renewed several times within this duration.
+const defaultLeaseDuration = 30 * time.Second
+
+// shardEndCheckpoint is the checkpoint of a lease for a kinesis shard that has been read completely.
+// Such leases are never taken again, and they allow leases of child shards to be taken.
+const shardEndCheckpoint = "SHARD_END"
+
+// errLeaseConflict is returned when a lease was modified by another worker.
+var errLeaseConflict = errors.New("lease was modified by another worker")
+
+// shardLease is a lease of a single kinesis shard, which is held by at most one worker at a time.
+// This follows the model of the Kinesis Client Library, where workers take leases that are
+// unowned or expired, and renew the leases they hold by incrementing the lease counter. A lease
+// expires if its counter isn't observed to change for the lease duration, which avoids any need for
+// workers to have synchronized clocks.
+type shardLease struct {
+	stream     string
+	shardID    string
+	owner      string
+	counter    int64
+	checkpoint string   // The sequence number of the last record that was emitted, if any.
+	parents    []string // Parent shard ids, which must be read completely before this shard.
+}
+
+func (l *shardLease) key() string {
+	return leaseKey(l.stream, l.shardID)
+}
+
+func leaseKey(stream, shardID string) string {
+	return stream + "/" + shardID
+}
+
+// dynamoDBAPI is the subset of the DynamoDB client that's used for leasing.
+type dynamoDBAPI interface {
+	Scan(context.Context, *dynamodb.ScanInput, ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error)
+	PutItem(context.Context, *dynamodb.PutItemInput, ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+	UpdateItem(context.Context, *dynamodb.UpdateItemInput, ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error)
+}
+
+// leaseTable stores shard leases in a DynamoDB table, which must have a string hash key named
+// `leaseKey`. A single table may be shared by the captures of many kinesis streams.
+type leaseTable struct {
+	client dynamoDBAPI
+	name   string
+}
+
+// list returns all leases of the given stream.
+func (t *leaseTable) list(ctx context.Context, stream string) ([]shardLease, error) {
+	var leases []shardLease
+	var input = dynamodb.ScanInput{
+		TableName:      &t.name,
+		ConsistentRead: aws.Bool(true),
+	}
+	for {
+		var out, err = t.client.Scan(ctx, &input)
+		if err != nil {
+			return nil, fmt.Errorf("scanning lease table: %w", err)
+		}
+		for _, item := range out.Items {
+			var lease, err = leaseFromItem(item)
+			if err != nil {
+				return nil, err
+			} else if lease.stream == stream {
+				leases = append(leases, lease)
+			}
+		}
+		if len(out.LastEvaluatedKey) == 0 {
+			break
+		}
+		input.ExclusiveStartKey = out.LastEvaluatedKey
+	}
+	return leases, nil
+}
+
+// create adds a new, unowned lease to the table, unless there's already a lease for the shard.
+func (t *leaseTable) create(ctx context.Context, lease shardLease) error {
+	var item = map[string]dynamodbtypes.AttributeValue{
+		"leaseKey":     &dynamodbtypes.AttributeValueMemberS{Value: lease.key()},
+		"streamName":   &dynamodbtypes.AttributeValueMemberS{Value: lease.stream},
+		"shardId":      &dynamodbtypes.AttributeValueMemberS{Value: lease.shardID},
+		"leaseOwner":   &dynamodbtypes.AttributeValueMemberS{Value: ""},
+		"leaseCounter": &dynamodbtypes.AttributeValueMemberN{Value: "0"},
+		"checkpoint":   &dynamodbtypes.AttributeValueMemberS{Value: lease.checkpoint},
+	}
+	if len(lease.parents) > 0 {
+		item["parentShardIds"] = &dynamodbtypes.AttributeValueMemberSS{Value: lease.parents}
+	}
+	var _, err = t.client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           &t.name,
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(leaseKey)"),
+	})
+	if isConditionalCheckFailed(err) {
+		return nil // Another worker has already created it.
+	} else if err != nil {
+		return fmt.Errorf("creating lease for %q: %w", lease.key(), err)
+	}
+	return nil
+}
+
+// update sets the owner and checkpoint of a lease and increments its counter, provided that the
+// lease hasn't been modified since it was last observed. It returns errLeaseConflict otherwise.
+func (t *leaseTable) update(ctx context.Context, lease shardLease, owner, checkpoint string) (shardLease, error) {
+	var _, err = t.client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: &t.name,
+		Key: map[string]dynamodbtypes.AttributeValue{
+			"leaseKey": &dynamodbtypes.AttributeValueMemberS{Value: lease.key()},
+		},
+		UpdateExpression:    aws.String("SET #owner = :owner, #counter = :newCounter, #checkpoint = :checkpoint"),
+		ConditionExpression: aws.String("#counter = :counter"),
+		ExpressionAttributeNames: map[string]string{
+			"#owner":      "leaseOwner",
+			"#counter":    "leaseCounter",
+			"#checkpoint": "checkpoint",
+		},
+		ExpressionAttributeValues: map[string]dynamodbtypes.AttributeValue{
+			":owner":      &dynamodbtypes.AttributeValueMemberS{Value: owner},
+			":counter":    &dynamodbtypes.AttributeValueMemberN{Value: strconv.FormatInt(lease.counter, 10)},
+			":newCounter": &dynamodbtypes.AttributeValueMemberN{Value: strconv.FormatInt(lease.counter+1, 10)},
+			":checkpoint": &dynamodbtypes.AttributeValueMemberS{Value: checkpoint},
+		},
+	})
+	if isConditionalCheckFailed(err) {
+		return lease, errLeaseConflict
+	} else if err != nil {
+		return lease, fmt.Errorf("updating lease for %q: %w", lease.key(), err)
+	}
+	lease.owner = owner
+	lease.counter++
+	lease.checkpoint = checkpoint
+	return lease, nil
+}
+
+func leaseFromItem(item map[string]dynamodbtypes.AttributeValue) (shardLease, error) {
+	var lease shardLease
+	var str = func(name string) string {
+		if v, ok := item[name].(*dynamodbtypes.AttributeValueMemberS); ok {
+			return v.Value
+		}
+		return ""
+	}
+	lease.stream = str("streamName")
+	lease.shardID = str("shardId")
+	lease.owner = str("leaseOwner")
+	lease.checkpoint = str("checkpoint")
+	if v, ok := item["leaseCounter"].(*dynamodbtypes.AttributeValueMemberN); ok {
+		var counter, err = strconv.ParseInt(v.Value, 10, 64)
+		if err != nil {
+			return lease, fmt.Errorf("invalid leaseCounter for %q: %w", str("leaseKey"), err)
+		}
+		lease.counter = counter
+	}
+	if v, ok := item["parentShardIds"].(*dynamodbtypes.AttributeValueMemberSS); ok {
+		lease.parents = v.Value
+	}
+	return lease, nil
+}
+
+func isConditionalCheckFailed(err error) bool {
+	var failed *dynamodbtypes.ConditionalCheckFailedException
+	return errors.As(err, &failed)
+}
+
+// newWorkerID returns a unique identifier for this instance of the connector.
+func newWorkerID() string {
+	var hostname, _ = os.Hostname()
+	return fmt.Sprintf("%s-%s", hostname, uuid.New().String())
+}
+
+// leaseCoordinator divides the shards of a kinesis stream between all of the workers that are
+// reading it, by taking and renewing leases in a leaseTable.
+type leaseCoordinator struct {
+	table    *leaseTable
+	stream   string
+	workerID string
+	duration time.Duration
+	now      func() time.Time
+
+	mu   sync.Mutex
+	held map[string]*heldLease // Leases held by this worker, by shard id.
+	// observed tracks when each lease was last seen to change, which is used to determine expiry.
+	observed map[string]observedLease
+}
+
+type heldLease struct {
+	lease      shardLease
+	checkpoint string
+	finished   bool
+}
+
+type observedLease struct {
+	owner   string
+	counter int64
+	at      time.Time
+}
+
+func newLeaseCoordinator(table *leaseTable, stream, workerID string) *leaseCoordinator {
+	return &leaseCoordinator{
+		table:    table,
+		stream:   stream,
+		workerID: workerID,
+		duration: defaultLeaseDuration,
+		now:      time.Now,
+		held:     make(map[string]*heldLease),
+		observed: make(map[string]observedLease),
+	}
+}
+
+// ensureLeases creates leases for any of the given shards which don't have one already.
+func (c *leaseCoordinator) ensureLeases(ctx context.Context, shards []shardLease) error {
+	for _, lease := range shards {
+		lease.stream = c.stream
+		if err := c.table.create(ctx, lease); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// checkpoint records the sequence number of the last record of a shard which has been emitted. It
+// is written to the lease table the next time the lease is renewed.
+func (c *leaseCoordinator) checkpoint(shardID, sequenceNumber string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if held, ok := c.held[shardID]; ok {
+		held.checkpoint = sequenceNumber
+	}
+}
+
+// finish records that a shard has been read completely. Its lease is released the next time leases
+// are renewed, after which leases of its child shards may be taken.
+func (c *leaseCoordinator) finish(shardID string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if held, ok := c.held[shardID]; ok {
+		held.finished = true
+	}
+}
+
+// rebalance renews the leases held by this worker and then takes additional leases, if this worker
+// holds fewer than its fair share of them. It returns the newly acquired leases, and the ids of
+// shards whose leases have been lost to other workers. Reads of lost shards must be stopped.
+func (c *leaseCoordinator) rebalance(ctx context.Context) (acquired []shardLease, lost []string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Renew the leases that we hold, releasing any of finished shards.
+	for shardID, held := range c.held {
+		var owner, checkpoint = c.workerID, held.checkpoint
+		if held.finished {
+			owner, checkpoint = "", shardEndCheckpoint
+		}
+		var renewed, err = c.table.update(ctx, held.lease, owner, checkpoint)
+		if err == errLeaseConflict {
+			log.WithFields(log.Fields{"kinesisStream": c.stream, "kinesisShardId": shardID}).Info("lost lease of kinesis shard to another worker")
+			delete(c.held, shardID)
+			lost = append(lost, shardID)
+			continue
+		} else if err != nil {
+			return nil, lost, err
+		}
+		if held.finished {
+			delete(c.held, shardID)
+		} else {
+			held.lease = renewed
+		}
+	}
+
+	leases, err := c.table.list(ctx, c.stream)
+	if err != nil {
+		return nil, lost, err
+	}
+	var now = c.now()
+	var finished = make(map[string]bool)
+	for _, lease := range leases {
+		var prev, ok = c.observed[lease.shardID]
+		if !ok || prev.owner != lease.owner || prev.counter != lease.counter {
+			c.observed[lease.shardID] = observedLease{owner: lease.owner, counter: lease.counter, at: now}
+		}
+		if lease.checkpoint == shardEndCheckpoint {
+			finished[lease.shardID] = true
+		}
+	}
+
+	// Count the live leases held by each worker, and collect the leases which are available to be
+	// taken. A lease is available if it's unowned or expired, and all of its parents are finished.
+	var total int
+	var ownerCounts = map[string]int{c.workerID: len(c.held)}
+	var ownerLeases = make(map[string][]shardLease)
+	var available []shardLease
+	for _, lease := range leases {
+		if finished[lease.shardID] {
+			continue
+		}
+		total++
+		if _, isHeld := c.held[lease.shardID]; isHeld {
+			continue
+		}
+		var expired = now.Sub(c.observed[lease.shardID].at) > c.duration
+		if lease.owner != "" && !expired {
+			ownerCounts[lease.owner]++
+			ownerLeases[lease.owner] = append(ownerLeases[lease.owner], lease)
+			continue
+		}
+		var parentsFinished = true
+		for _, parent := range lease.parents {
+			if !finished[parent] && c.hasLease(leases, parent) {
+				parentsFinished = false
+			}
+		}
+		if parentsFinished {
+			available = append(available, lease)
+		}
+	}
+	sort.Slice(available, func(i, j int) bool { return available[i].shardID < available[j].shardID })
+
+	// Each worker should hold an equal share of the leases, rounded up.
+	var target = (total + len(ownerCounts) - 1) / len(ownerCounts)
+	var needed = target - len(c.held)
+
+	for _, lease := range available {
+		if needed <= 0 {
+			break
+		}
+		if c.take(ctx, lease, &acquired) {
+			needed--
+		}
+	}
+
+	// If there weren't enough available leases, then steal a single lease from the worker holding
+	// the most of them, provided it holds more than its share. Only one lease is stolen at a time,
+	// so that workers converge on a balanced assignment without thrashing.
+	if needed > 0 {
+		var victim string
+		for owner, count := range ownerCounts {
+			if owner == c.workerID || count <= target {
+				continue
+			} else if victim == "" || count > ownerCounts[victim] || (count == ownerCounts[victim] && owner < victim) {
+				victim = owner
+			}
+		}
+		if victim != "" {
+			var candidates = ownerLeases[victim]
+			sort.Slice(candidates, func(i, j int) bool { return candidates[i].shardID < candidates[j].shardID })
+			c.take(ctx, candidates[0], &acquired)
+		}
+	}
+	return acquired, lost, nil
+}
+
+// take attempts to take a lease, appending it to `acquired` if successful.
+func (c *leaseCoordinator) take(ctx context.Context, lease shardLease, acquired *[]shardLease) bool {
+	var taken, err = c.table.update(ctx, lease, c.workerID, lease.checkpoint)
+	if err != nil {
+		// Most likely another worker took it first, which is fine.
+		log.WithFields(log.Fields{
+			"kinesisStream":  c.stream,
+			"kinesisShardId": lease.shardID,
+			"error":          err,
+		}).Debug("failed to take lease")
+		return false
+	}
+	log.WithFields(log.Fields{
+		"kinesisStream":  c.stream,
+		"kinesisShardId": lease.shardID,
+		"previousOwner":  lease.owner,
+	}).Info("took lease of kinesis shard")
+	c.held[lease.shardID] = &heldLease{lease: taken, checkpoint: taken.checkpoint}
+	*acquired = append(*acquired, taken)
+	return true
+}
+
+// hasLease returns whether there's a lease for the given shard. A parent shard without a lease has
+// fallen out of the retention period, and doesn't need to be read before its children.
+func (c *leaseCoordinator) hasLease(leases []shardLease, shardID string) bool {
+	for _, lease := range leases {
+		if lease.shardID == shardID {
+			return true
+		}
+	}
+	return false
+}
+
+// releaseAll gives up all leases held by this worker, so that other workers may take them over
+// without waiting for them to expire.
+func (c *leaseCoordinator) releaseAll(ctx context.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for shardID, held := range c.held {
+		var checkpoint = held.checkpoint
+		if held.finished {
+			checkpoint = shardEndCheckpoint
+		}
+		if _, err := c.table.update(ctx, held.lease, "", checkpoint); err != nil {
+			log.WithFields(log.Fields{
+				"kinesisStream":  c.stream,
+				"kinesisShardId": shardID,
+				"error":          err,
+			}).Warn("failed to release lease")
+		}
+		delete(c.held, shardID)
+	}
+}
+
+// shardLeases returns unowned leases for the given kinesis shards.
+func shardLeases(shards []*types.Shard) []shardLease {
+	var leases []shardLease
+	for _, shard := range shards {
+		var lease = shardLease{shardID: *shard.ShardId}
+		for _, parent := range []*string{shard.ParentShardId, shard.AdjacentParentShardId} {
+			if parent != nil {
+				lease.parents = append(lease.parents, *parent)
+			}
+		}
+		leases = append(leases, lease)
+	}
+	return leases
+}
+
+// childShardLeases returns unowned leases for the child shards returned by GetRecords.
+func childShardLeases(children []types.ChildShard) []shardLease {
+	var leases []shardLease
+	for _, child := range children {
+		leases = append(leases, shardLease{
+			shardID: *child.ShardId,
+			parents: child.ParentShards,
+		})
+	}
+	return leases
+}
+
+// laterSequenceNumber returns whichever of two positions within a kinesis shard is later. Either
+// may be empty, indicating the absence of a position.
+func laterSequenceNumber(a, b string) string {
+	if a == "" {
+		return b
+	} else if b == "" {
+		return a
+	} else if comparePositions(a, b) >= 0 {
+		return a
+	}
+	return b
+}
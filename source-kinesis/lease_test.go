@@ -0,0 +1,269 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	dynamodbtypes "github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/stretchr/testify/require"
+)
+
+// mockDynamoDB is an in-memory lease table, which understands just the conditions used by leaseTable.
+type mockDynamoDB struct {
+	mu    sync.Mutex
+	items map[string]map[string]dynamodbtypes.AttributeValue
+}
+
+func newMockDynamoDB() *mockDynamoDB {
+	return &mockDynamoDB{items: make(map[string]map[string]dynamodbtypes.AttributeValue)}
+}
+
+// attrValue returns the value of a string or number attribute.
+func attrValue(v dynamodbtypes.AttributeValue) string {
+	switch typed := v.(type) {
+	case *dynamodbtypes.AttributeValueMemberS:
+		return typed.Value
+	case *dynamodbtypes.AttributeValueMemberN:
+		return typed.Value
+	default:
+		return ""
+	}
+}
+
+func (m *mockDynamoDB) Scan(_ context.Context, _ *dynamodb.ScanInput, _ ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var out = &dynamodb.ScanOutput{}
+	for _, item := range m.items {
+		var copied = make(map[string]dynamodbtypes.AttributeValue)
+		for k, v := range item {
+			copied[k] = v
+		}
+		out.Items = append(out.Items, copied)
+	}
+	return out, nil
+}
+
+func (m *mockDynamoDB) PutItem(_ context.Context, input *dynamodb.PutItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var key = attrValue(input.Item["leaseKey"])
+	if _, exists := m.items[key]; exists && input.ConditionExpression != nil {
+		return nil, &dynamodbtypes.ConditionalCheckFailedException{Message: aws.String("item exists")}
+	}
+	m.items[key] = input.Item
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func (m *mockDynamoDB) UpdateItem(_ context.Context, input *dynamodb.UpdateItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var key = attrValue(input.Key["leaseKey"])
+	var item, ok = m.items[key]
+	if !ok || attrValue(item["leaseCounter"]) != attrValue(input.ExpressionAttributeValues[":counter"]) {
+		return nil, &dynamodbtypes.ConditionalCheckFailedException{Message: aws.String("counter mismatch")}
+	}
+	var updated = make(map[string]dynamodbtypes.AttributeValue)
+	for k, v := range item {
+		updated[k] = v
+	}
+	updated["leaseOwner"] = input.ExpressionAttributeValues[":owner"]
+	updated["leaseCounter"] = input.ExpressionAttributeValues[":newCounter"]
+	updated["checkpoint"] = input.ExpressionAttributeValues[":checkpoint"]
+	m.items[key] = updated
+	return &dynamodb.UpdateItemOutput{}, nil
+}
+
+func (m *mockDynamoDB) attr(stream, shardID, name string) string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return attrValue(m.items[leaseKey(stream, shardID)][name])
+}
+
+type fakeClock struct{ t time.Time }
+
+func (c *fakeClock) now() time.Time { return c.t }
+
+func newTestCoordinator(db *mockDynamoDB, clock *fakeClock, workerID string) *leaseCoordinator {
+	var c = newLeaseCoordinator(&leaseTable{client: db, name: "leases"}, "test-stream", workerID)
+	c.now = clock.now
+	return c
+}
+
+func heldShards(c *leaseCoordinator) []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var ids []string
+	for id := range c.held {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func testShardLeases(n int) []shardLease {
+	var leases []shardLease
+	for i := 0; i < n; i++ {
+		leases = append(leases, shardLease{shardID: fmt.Sprintf("shard-%d", i)})
+	}
+	return leases
+}
+
+func TestLeaseAcquisitionAndBalancing(t *testing.T) {
+	var ctx = context.Background()
+	var db = newMockDynamoDB()
+	var clock = &fakeClock{t: time.Unix(1000, 0)}
+	var a = newTestCoordinator(db, clock, "worker-a")
+	var b = newTestCoordinator(db, clock, "worker-b")
+
+	require.NoError(t, a.ensureLeases(ctx, testShardLeases(4)))
+	// Creating leases which already exist is a no-op.
+	require.NoError(t, b.ensureLeases(ctx, testShardLeases(4)))
+
+	// The first worker takes all of the unowned leases.
+	acquired, lost, err := a.rebalance(ctx)
+	require.NoError(t, err)
+	require.Len(t, acquired, 4)
+	require.Empty(t, lost)
+
+	// The second worker steals one lease at a time until it holds its share.
+	for i := 1; i <= 2; i++ {
+		acquired, lost, err = b.rebalance(ctx)
+		require.NoError(t, err)
+		require.Len(t, acquired, 1)
+		require.Empty(t, lost)
+		require.Len(t, heldShards(b), i)
+	}
+	acquired, _, err = b.rebalance(ctx)
+	require.NoError(t, err)
+	require.Empty(t, acquired)
+
+	// The first worker finds out about the stolen leases when it next renews them.
+	acquired, lost, err = a.rebalance(ctx)
+	require.NoError(t, err)
+	require.Empty(t, acquired)
+	require.ElementsMatch(t, heldShards(b), lost)
+
+	// Every shard is now held by exactly one worker.
+	require.Len(t, heldShards(a), 2)
+	require.Len(t, heldShards(b), 2)
+	require.ElementsMatch(t, []string{"shard-0", "shard-1", "shard-2", "shard-3"}, append(heldShards(a), heldShards(b)...))
+	for _, id := range heldShards(a) {
+		require.Equal(t, "worker-a", db.attr("test-stream", id, "leaseOwner"))
+	}
+	for _, id := range heldShards(b) {
+		require.Equal(t, "worker-b", db.attr("test-stream", id, "leaseOwner"))
+	}
+
+	// Further rounds leave the assignment alone.
+	for i := 0; i < 3; i++ {
+		for _, c := range []*leaseCoordinator{a, b} {
+			acquired, lost, err = c.rebalance(ctx)
+			require.NoError(t, err)
+			require.Empty(t, acquired)
+			require.Empty(t, lost)
+		}
+	}
+}
+
+func TestLeaseRebalancingOnFailure(t *testing.T) {
+	var ctx = context.Background()
+	var db = newMockDynamoDB()
+	var clock = &fakeClock{t: time.Unix(1000, 0)}
+	var a = newTestCoordinator(db, clock, "worker-a")
+	var b = newTestCoordinator(db, clock, "worker-b")
+
+	require.NoError(t, a.ensureLeases(ctx, testShardLeases(4)))
+	for i := 0; i < 3; i++ {
+		for _, c := range []*leaseCoordinator{a, b} {
+			var _, _, err = c.rebalance(ctx)
+			require.NoError(t, err)
+		}
+	}
+	require.Len(t, heldShards(a), 2)
+	require.Len(t, heldShards(b), 2)
+
+	// The first worker records a checkpoint and then fails, so it stops renewing its leases.
+	var checkpointed = heldShards(a)[0]
+	a.checkpoint(checkpointed, "12345")
+	var _, _, err = a.rebalance(ctx)
+	require.NoError(t, err)
+	require.Equal(t, "12345", db.attr("test-stream", checkpointed, "checkpoint"))
+
+	// The leases of the failed worker aren't taken until they've gone unrenewed for the lease duration.
+	clock.t = clock.t.Add(a.duration / 2)
+	acquired, _, err := b.rebalance(ctx)
+	require.NoError(t, err)
+	require.Empty(t, acquired)
+
+	clock.t = clock.t.Add(a.duration)
+	acquired, _, err = b.rebalance(ctx)
+	require.NoError(t, err)
+	require.Empty(t, acquired)
+
+	clock.t = clock.t.Add(a.duration / 2)
+	acquired, _, err = b.rebalance(ctx)
+	require.NoError(t, err)
+	require.Len(t, acquired, 2)
+	require.Equal(t, []string{"shard-0", "shard-1", "shard-2", "shard-3"}, heldShards(b))
+
+	// The taken lease carries the checkpoint of the failed worker, so that reading resumes from it.
+	for _, lease := range acquired {
+		if lease.shardID == checkpointed {
+			require.Equal(t, "12345", lease.checkpoint)
+		}
+	}
+}
+
+func TestLeaseOfChildShard(t *testing.T) {
+	var ctx = context.Background()
+	var db = newMockDynamoDB()
+	var clock = &fakeClock{t: time.Unix(1000, 0)}
+	var a = newTestCoordinator(db, clock, "worker-a")
+
+	require.NoError(t, a.ensureLeases(ctx, []shardLease{
+		{shardID: "parent"},
+		{shardID: "child", parents: []string{"parent", "expired-parent"}},
+	}))
+
+	// The child isn't taken while its parent is still being read.
+	acquired, _, err := a.rebalance(ctx)
+	require.NoError(t, err)
+	require.Len(t, acquired, 1)
+	require.Equal(t, "parent", acquired[0].shardID)
+
+	// Once the parent is finished, its lease is released and the child can be taken. The other
+	// parent has no lease, meaning it's past the retention period, and so doesn't need to be read.
+	a.finish("parent")
+	acquired, _, err = a.rebalance(ctx)
+	require.NoError(t, err)
+	require.Len(t, acquired, 1)
+	require.Equal(t, "child", acquired[0].shardID)
+	require.Equal(t, shardEndCheckpoint, db.attr("test-stream", "parent", "checkpoint"))
+	require.Equal(t, "", db.attr("test-stream", "parent", "leaseOwner"))
+	require.Equal(t, []string{"child"}, heldShards(a))
+
+	// Releasing leases allows another worker to take them over right away.
+	a.releaseAll(ctx)
+	var b = newTestCoordinator(db, clock, "worker-b")
+	acquired, _, err = b.rebalance(ctx)
+	require.NoError(t, err)
+	require.Len(t, acquired, 1)
+	require.Equal(t, "child", acquired[0].shardID)
+}
+
+func TestLaterSequenceNumber(t *testing.T) {
+	require.Equal(t, "", laterSequenceNumber("", ""))
+	require.Equal(t, "5", laterSequenceNumber("", "5"))
+	require.Equal(t, "5", laterSequenceNumber("5", ""))
+	require.Equal(t, "49590338271490256608559692540925702759324208523137515618",
+		laterSequenceNumber("49590338271490256608559692540925702759324208523137515618", "49590338271490256608559692538361252383686425845113307138"))
+	require.Equal(t, "49590338271490256608559692540925702759324208523137515618",
+		laterSequenceNumber("49590338271490256608559692538361252383686425845113307138", "49590338271490256608559692540925702759324208523137515618"))
+}
@@ -4,12 +4,12 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"os"
 	"sync"
 	"time"
 
-	"github.com/aws/aws-sdk-go/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
 	"github.com/estuary/flow/go/protocols/airbyte"
 	log "github.com/sirupsen/logrus"
 )
@@ -42,11 +42,16 @@ func doCheck(args airbyte.CheckCmd) error {
 }
 
 func tryListingStreams(configFile airbyte.ConfigFile) ([]string, error) {
-	var _, client, err = parseConfigAndConnect(configFile)
+	var ctx = context.Background()
+	var config, awsConfig, client, err = parseConfigAndConnect(ctx, configFile)
 	if err != nil {
 		return nil, err
 	}
-	var ctx = context.Background()
+	// Credentials are obtained before listing streams, so that a role which can't be assumed is
+	// reported as such.
+	if err = checkCredentials(ctx, awsConfig.Credentials, &config); err != nil {
+		return nil, err
+	}
 	return listAllStreams(ctx, client)
 }
 
@@ -63,16 +68,17 @@ func doDiscover(args airbyte.DiscoverCmd) error {
 	})
 }
 
-func discoverCatalog(config airbyte.ConfigFile) (*airbyte.Catalog, error) {
-	var _, client, err = parseConfigAndConnect(config)
+func discoverCatalog(configFile airbyte.ConfigFile) (*airbyte.Catalog, error) {
+	var ctx = context.Background()
+	var config, _, client, err = parseConfigAndConnect(ctx, configFile)
 	if err != nil {
 		return nil, err
 	}
-	var ctx = context.Background()
 	streamNames, err := listAllStreams(ctx, client)
 	if err != nil {
 		return nil, err
 	}
+	var metadata = describeStreams(ctx, client, streamNames)
 
 	var catalog = &airbyte.Catalog{
 		Streams: make([]airbyte.Stream, len(streamNames)),
@@ -84,10 +90,42 @@ func discoverCatalog(config airbyte.ConfigFile) (*airbyte.Catalog, error) {
 			SupportedSyncModes:  []airbyte.SyncMode{airbyte.SyncModeIncremental},
 			SourceDefinedCursor: true,
 		}
+		if config.KeyField != "" {
+			catalog.Streams[i].JSONSchema = json.RawMessage(keyedDocumentSchema)
+			catalog.Streams[i].SourceDefinedPrimaryKey = [][]string{{"_meta", metaKeyProperty}}
+		}
+		if config.SampleForSchema > 0 {
+			if catalog.Streams[i].JSONSchema, err = sampledSchema(ctx, client, &config, name, catalog.Streams[i].JSONSchema); err != nil {
+				return nil, fmt.Errorf("inferring schema of stream %q: %w", name, err)
+			}
+		}
+		if md, ok := metadata[name]; ok {
+			if catalog.Streams[i].JSONSchema, err = withStreamMetadata(catalog.Streams[i].JSONSchema, md); err != nil {
+				return nil, fmt.Errorf("adding metadata of stream %q: %w", name, err)
+			}
+		}
 	}
 	return catalog, nil
 }
 
+// keyedDocumentSchema is the discovered schema of records when key extraction is enabled.
+const keyedDocumentSchema = `{
+	"type": "object",
+	"properties": {
+		"_meta": {
+			"type": "object",
+			"properties": {
+				"key": {
+					"type": "string",
+					"description": "Key of the record, extracted from the configured keyField or else the kinesis partition key"
+				}
+			},
+			"required": ["key"]
+		}
+	},
+	"required": ["_meta"]
+}`
+
 func updateState(state map[string]map[string]string, source *recordSource, sequenceNumber string) {
 	var streamMap, ok = state[source.stream]
 	if !ok {
@@ -109,13 +147,17 @@ func copyStreamState(state map[string]map[string]string, stream string) (map[str
 }
 
 func doRead(args airbyte.ReadCmd) error {
-	return readStreamsTo(context.Background(), args, os.Stdout)
+	return readStreamsTo(context.Background(), args, json.NewEncoder(os.Stdout))
 }
 
-func readStreamsTo(ctx context.Context, args airbyte.ReadCmd, output io.Writer) error {
-	var _, client, err = parseConfigAndConnect(args.ConfigFile)
+// readStreamsTo reads the streams of the catalog, writing their records and state checkpoints
+// to the output until reading has completed or fails.
+func readStreamsTo(ctx context.Context, args airbyte.ReadCmd, output messageOutput) error {
+	var config, awsConfig, client, err = parseConfigAndConnect(ctx, args.ConfigFile)
 	if err != nil {
 		return err
+	} else if err = checkCredentials(ctx, awsConfig.Credentials, &config); err != nil {
+		return err
 	}
 	var catalog airbyte.ConfiguredCatalog
 	if err = args.CatalogFile.Parse(&catalog); err != nil {
@@ -130,7 +172,13 @@ func readStreamsTo(ctx context.Context, args airbyte.ReadCmd, output io.Writer)
 	}
 
 	var dataCh = make(chan readResult, 8)
+	var maxInFlight = int64(config.MaxInFlightRecords)
+	if maxInFlight == 0 {
+		maxInFlight = defaultMaxInFlightRecords
+	}
+	var inFlight = newInFlightLimiter(maxInFlight)
 	ctx, cancelFunc := context.WithCancel(ctx)
+	defer cancelFunc()
 
 	log.WithField("streamCount", len(catalog.Streams)).Info("Starting to read stream(s)")
 
@@ -148,62 +196,51 @@ func readStreamsTo(ctx context.Context, args airbyte.ReadCmd, output io.Writer)
 	} else {
 		log.Info("reading indefinitely because tail==true")
 	}
+
+	var emitter = newResultEmitter(&config, output, stateMap, inFlight)
+
+	// When coordinating through a DynamoDB lease table, kinesis shards are divided between workers
+	// by their leases, and so each worker reads the full range of each shard it holds.
+	var leases *leaseTable
+	var workerID string
+	if config.Coordination == coordinationDynamoDB {
+		if !catalog.Tail {
+			log.Warn("not using lease coordination because tail==false, and reading according to the shard range instead")
+		} else if leases, err = connectLeaseTable(ctx, &config); err != nil {
+			return fmt.Errorf("connecting to lease table: %w", err)
+		} else {
+			workerID = newWorkerID()
+			shardRange = airbyte.NewFullRange()
+		}
+	}
+	// The config was validated when it was parsed, so its options can't be invalid here.
+	opts, err := newReaderOptions(&config, time.Now().UTC())
+	if err != nil {
+		return err
+	}
+	if config.SampleForSchema > 0 {
+		if emitter.tracker, err = newSchemaTracker(catalog.Streams); err != nil {
+			return err
+		}
+	}
 	var waitGroup = new(sync.WaitGroup)
 	for _, stream := range catalog.Streams {
 		streamState, err := copyStreamState(stateMap, stream.Stream.Name)
 		if err != nil {
-			cancelFunc()
 			return fmt.Errorf("invalid state for stream %s: %w", stream.Stream.Name, err)
 		}
+		var coordinator *leaseCoordinator
+		if leases != nil {
+			coordinator = newLeaseCoordinator(leases, stream.Stream.Name, workerID)
+			emitter.leaseCoordinators[stream.Stream.Name] = coordinator
+		}
 		waitGroup.Add(1)
-		go readStream(ctx, shardRange, client, stream.Stream.Name, streamState, dataCh, stopAt, waitGroup)
+		go readStream(ctx, shardRange, client, stream.Stream.Name, streamState, dataCh, inFlight, coordinator, opts, stopAt, waitGroup)
 	}
 
 	go closeChannelWhenDone(dataCh, waitGroup)
 
-	// We'll re-use this same message instance for all records we print
-	var recordMessage = airbyte.Message{
-		Type:   airbyte.MessageTypeRecord,
-		Record: &airbyte.Record{},
-	}
-	// We're all set to start printing data to stdout
-	var encoder = json.NewEncoder(output)
-	for next := range dataCh {
-		if next.err != nil {
-			// time to bail
-			var errMessage = airbyte.NewLogMessage(airbyte.LogLevelFatal, "read failed due to error: %v", next.err)
-			// Printing the error may fail, but we'll ignore that error and return the original
-			_ = encoder.Encode(errMessage)
-			err = next.err
-			break
-		}
-		recordMessage.Record.Stream = next.source.stream
-		for _, record := range next.records {
-			recordMessage.Record.Data = record
-			recordMessage.Record.EmittedAt = time.Now().UTC().UnixNano() / int64(time.Millisecond)
-			if err = encoder.Encode(recordMessage); err != nil {
-				break
-			}
-		}
-		updateState(stateMap, next.source, next.sequenceNumber)
-
-		stateRaw, err := json.Marshal(stateMap)
-		if err != nil {
-			break
-		}
-		if err = encoder.Encode(airbyte.Message{
-			Type:  airbyte.MessageTypeState,
-			State: &airbyte.State{Data: json.RawMessage(stateRaw)},
-		}); err != nil {
-			break
-		}
-
-		if err != nil {
-			break
-		}
-	}
-	cancelFunc()
-	return err
+	return emitter.emitAll(dataCh)
 }
 
 func closeChannelWhenDone(dataCh chan readResult, waitGroup *sync.WaitGroup) {
@@ -212,14 +249,16 @@ func closeChannelWhenDone(dataCh chan readResult, waitGroup *sync.WaitGroup) {
 	close(dataCh)
 }
 
-func parseConfigAndConnect(configFile airbyte.ConfigFile) (config Config, client *kinesis.Kinesis, err error) {
+func parseConfigAndConnect(ctx context.Context, configFile airbyte.ConfigFile) (config Config, awsConfig aws.Config, client *kinesis.Client, err error) {
 	if err = configFile.ConfigFile.Parse(&config); err != nil {
 		err = fmt.Errorf("parsing config file: %w", err)
 		return
 	}
-	if client, err = connect(&config); err != nil {
+	if awsConfig, err = loadAWSConfig(ctx, &config); err != nil {
 		err = fmt.Errorf("failed to connect: %w", err)
+		return
 	}
+	client = kinesis.NewFromConfig(awsConfig, kinesisEndpointOptions(&config))
 	return
 }
 
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// Compression formats of record payloads.
+const (
+	compressionNone = "none"
+	compressionGzip = "gzip"
+	compressionZlib = "zlib"
+)
+
+// Policies for records whose payloads can't be decompressed.
+const (
+	corruptRecordSkip  = "skip"
+	corruptRecordError = "error"
+)
+
+// maxDecompressedBytes is the largest size to which a record payload may be decompressed. Kinesis
+// records are at most 1 MiB, so anything larger than this is treated as corrupt rather than being
+// read entirely into memory.
+const maxDecompressedBytes = 64 * 1024 * 1024
+
+// recordDecompressor decompresses the payloads of records which were compressed by their producer.
+type recordDecompressor struct {
+	compression string
+	policy      string
+}
+
+// newRecordDecompressor returns a recordDecompressor for the given compression format and policy,
+// or nil if records aren't compressed. The policy defaults to `skip`.
+func newRecordDecompressor(compression string, policy string) (*recordDecompressor, error) {
+	switch compression {
+	case "", compressionNone:
+		if policy != "" {
+			return nil, fmt.Errorf("corruptRecordPolicy requires a compression")
+		}
+		return nil, nil
+	case compressionGzip, compressionZlib:
+	default:
+		return nil, fmt.Errorf("invalid compression %q", compression)
+	}
+	switch policy {
+	case "":
+		policy = corruptRecordSkip
+	case corruptRecordSkip, corruptRecordError:
+	default:
+		return nil, fmt.Errorf("invalid corruptRecordPolicy %q", policy)
+	}
+	return &recordDecompressor{compression: compression, policy: policy}, nil
+}
+
+// skipCorrupt returns whether records which can't be decompressed should be skipped, rather than
+// failing the capture.
+func (d *recordDecompressor) skipCorrupt() bool {
+	return d != nil && d.policy == corruptRecordSkip
+}
+
+// decompress returns the decompressed payload of a record, or an error if the payload isn't
+// validly compressed.
+func (d *recordDecompressor) decompress(data []byte) ([]byte, error) {
+	if d == nil {
+		return data, nil
+	}
+	var reader io.ReadCloser
+	var err error
+	switch d.compression {
+	case compressionGzip:
+		reader, err = gzip.NewReader(bytes.NewReader(data))
+	case compressionZlib:
+		reader, err = zlib.NewReader(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("decompressing %s record: %w", d.compression, err)
+	}
+	defer reader.Close()
+
+	decompressed, err := ioutil.ReadAll(io.LimitReader(reader, maxDecompressedBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("decompressing %s record: %w", d.compression, err)
+	} else if len(decompressed) > maxDecompressedBytes {
+		return nil, fmt.Errorf("decompressing %s record: decompressed size exceeds %d bytes", d.compression, maxDecompressedBytes)
+	}
+	return decompressed, nil
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/estuary/flow/go/protocols/airbyte"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func compress(t *testing.T, compression string, data string) []byte {
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch compression {
+	case compressionGzip:
+		w = gzip.NewWriter(&buf)
+	case compressionZlib:
+		w = zlib.NewWriter(&buf)
+	}
+	_, err := w.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestRecordDecompressor(t *testing.T) {
+	for _, compression := range []string{compressionGzip, compressionZlib} {
+		var d, err = newRecordDecompressor(compression, "")
+		require.NoError(t, err)
+		require.Equal(t, corruptRecordSkip, d.policy)
+
+		decompressed, err := d.decompress(compress(t, compression, `{"a":1}`))
+		require.NoError(t, err)
+		require.Equal(t, `{"a":1}`, string(decompressed))
+
+		// Payloads which aren't compressed, or whose compressed data is corrupt, are errors.
+		_, err = d.decompress([]byte(`{"a":1}`))
+		require.Error(t, err)
+		var corrupt = compress(t, compression, `{"a":1}`)
+		corrupt[len(corrupt)-1] ^= 0xff
+		_, err = d.decompress(corrupt)
+		require.Error(t, err)
+		_, err = d.decompress(compress(t, compression, `{"a":1}`)[:8])
+		require.Error(t, err)
+	}
+
+	// Payloads are passed through when they aren't compressed.
+	for _, compression := range []string{"", compressionNone} {
+		var d, err = newRecordDecompressor(compression, "")
+		require.NoError(t, err)
+		require.Nil(t, d)
+		data, err := d.decompress([]byte(`not even JSON`))
+		require.NoError(t, err)
+		require.Equal(t, `not even JSON`, string(data))
+		require.False(t, d.skipCorrupt())
+	}
+
+	for _, invalid := range []struct{ compression, policy string }{
+		{"snappy", ""},
+		{compressionGzip, "ignore"},
+		{"", corruptRecordSkip},
+		{compressionNone, corruptRecordError},
+	} {
+		var _, err = newRecordDecompressor(invalid.compression, invalid.policy)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestExtractRecordsWithCompression(t *testing.T) {
+	var decompressor, err = newRecordDecompressor(compressionGzip, "")
+	require.NoError(t, err)
+	var reader = &shardReader{
+		rangeOverlap: airbyte.FullRangeOverlap,
+		parent:       &streamReader{readerOptions: readerOptions{decompressor: decompressor}},
+		logEntry:     log.NewEntry(log.StandardLogger()),
+	}
+
+	var resp = &kinesis.GetRecordsOutput{}
+	for i, data := range [][]byte{
+		compress(t, compressionGzip, `{"n":1}`),
+		[]byte(`{"n":2}`),
+		compress(t, compressionGzip, `{"n":3}`),
+	} {
+		resp.Records = append(resp.Records, types.Record{
+			Data:           data,
+			PartitionKey:   aws.String("pk"),
+			SequenceNumber: aws.String(string(rune('a' + i))),
+		})
+	}
+
+	// Records which can't be decompressed are skipped.
+	extracted, positions, _, err := reader.extractRecords(resp)
+	require.NoError(t, err)
+	require.Len(t, extracted, 2)
+	require.Equal(t, `{"n":1}`, string(extracted[0]))
+	require.Equal(t, `{"n":3}`, string(extracted[1]))
+	require.Equal(t, []string{"a", "c"}, positions)
+	require.Equal(t, int64(1), reader.corrupt)
+
+	// Or they fail the capture.
+	reader.parent.decompressor, err = newRecordDecompressor(compressionGzip, corruptRecordError)
+	require.NoError(t, err)
+	_, _, _, err = reader.extractRecords(resp)
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "record b: decompressing gzip record")
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// recordFilter is a predicate over the fields of parsed JSON records, which is used to drop
+// unwanted records before they're captured. A filter expression is made up of comparisons of the
+// form `<pointer> <op> <value>`, where the pointer is a JSON pointer to a field of the record, the
+// op is either `==` or `!=`, and the value is a JSON literal. Comparisons may be combined with
+// `&&` and `||`, where `&&` binds more tightly. For example:
+//
+//	/eventType == "order" && /test != true
+//
+// A field which the record doesn't have is compared as if it were null.
+type recordFilter struct {
+	// The disjunction of conjunctions of comparisons which the filter evaluates.
+	anyOf [][]filterComparison
+}
+
+type filterComparison struct {
+	// The unescaped tokens of the JSON pointer to the compared field.
+	tokens []string
+	// Whether the comparison is `!=` rather than `==`.
+	negate bool
+	// The value which the field is compared with.
+	value interface{}
+}
+
+// newRecordFilter parses a filter expression, returning nil if the expression is empty.
+func newRecordFilter(expr string) (*recordFilter, error) {
+	if strings.TrimSpace(expr) == "" {
+		return nil, nil
+	}
+	var filter = new(recordFilter)
+	for _, disjunct := range splitExpression(expr, "||") {
+		var allOf []filterComparison
+		for _, conjunct := range splitExpression(disjunct, "&&") {
+			var cmp, err = parseFilterComparison(conjunct)
+			if err != nil {
+				return nil, fmt.Errorf("invalid filter %q: %w", expr, err)
+			}
+			allOf = append(allOf, cmp)
+		}
+		filter.anyOf = append(filter.anyOf, allOf)
+	}
+	return filter, nil
+}
+
+// splitExpression splits the expression around each occurrence of the operator which isn't
+// within a string literal.
+func splitExpression(expr, op string) []string {
+	var parts []string
+	var start int
+	var inString, escaped bool
+	for i := 0; i < len(expr); i++ {
+		switch {
+		case escaped:
+			escaped = false
+		case inString && expr[i] == '\\':
+			escaped = true
+		case expr[i] == '"':
+			inString = !inString
+		case !inString && strings.HasPrefix(expr[i:], op):
+			parts = append(parts, expr[start:i])
+			start = i + len(op)
+			i += len(op) - 1
+		}
+	}
+	return append(parts, expr[start:])
+}
+
+func parseFilterComparison(s string) (filterComparison, error) {
+	var cmp filterComparison
+	var fields = strings.Fields(s)
+	if len(fields) < 3 {
+		return cmp, fmt.Errorf("expected a comparison of the form '<pointer> <op> <value>' but got %q", strings.TrimSpace(s))
+	}
+
+	var err error
+	if cmp.tokens, err = parseJSONPointer(fields[0]); err != nil {
+		return cmp, err
+	}
+	switch fields[1] {
+	case "==":
+	case "!=":
+		cmp.negate = true
+	default:
+		return cmp, fmt.Errorf("invalid operator %q: must be '==' or '!='", fields[1])
+	}
+
+	// The value is the remainder of the comparison after the operator, which may contain spaces.
+	var rest = strings.TrimLeftFunc(s, unicode.IsSpace)
+	rest = strings.TrimLeftFunc(rest[len(fields[0]):], unicode.IsSpace)
+	rest = strings.TrimSpace(rest[len(fields[1]):])
+	if err = json.Unmarshal([]byte(rest), &cmp.value); err != nil {
+		return cmp, fmt.Errorf("invalid value %s: must be a JSON literal", rest)
+	}
+	return cmp, nil
+}
+
+// matches returns whether the record satisfies the filter. Every record matches a nil filter.
+func (f *recordFilter) matches(data []byte) (bool, error) {
+	if f == nil {
+		return true, nil
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return false, fmt.Errorf("filtering record: record is not valid JSON")
+	}
+	for _, allOf := range f.anyOf {
+		var ok = true
+		for _, cmp := range allOf {
+			if reflect.DeepEqual(lookupField(doc, cmp.tokens), cmp.value) == cmp.negate {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// lookupField returns the value of the field of the document identified by the tokens, or nil
+// if the document doesn't have the field.
+func lookupField(doc interface{}, tokens []string) interface{} {
+	for _, token := range tokens {
+		var obj, ok = doc.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		doc = obj[token]
+	}
+	return doc
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/estuary/flow/go/protocols/airbyte"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordFilter(t *testing.T) {
+	var records = []string{
+		`{"eventType":"order","test":false,"amount":10}`,
+		`{"eventType":"order","test":true,"amount":10.0}`,
+		`{"eventType":"refund","amount":2}`,
+		`{"eventType":"a && b || c","user":{"tier":"gold"}}`,
+		`{"user":{"tier":null}}`,
+		`"not an object"`,
+	}
+
+	for _, tc := range []struct {
+		expr   string
+		expect []bool
+	}{
+		// Include only the records with a matching field.
+		{`/eventType == "order"`, []bool{true, true, false, false, false, false}},
+		// Exclude the records with a matching field, including those that don't have it.
+		{`/eventType != "order"`, []bool{false, false, true, true, true, true}},
+		{`/test != true`, []bool{true, false, true, true, true, true}},
+		// Numbers are compared by their values.
+		{`/amount == 10`, []bool{true, true, false, false, false, false}},
+		// Missing fields are compared as null, as are fields of values which aren't objects.
+		{`/user/tier == null`, []bool{true, true, true, false, true, true}},
+		{`/user/tier == "gold" || /eventType == "refund"`, []bool{false, false, true, true, false, false}},
+		// Conjunctions bind more tightly than disjunctions.
+		{`/eventType == "order" && /test == false || /amount == 2`, []bool{true, false, true, false, false, false}},
+		// Operators within string literals are part of the value.
+		{`/eventType == "a && b || c"`, []bool{false, false, false, true, false, false}},
+		{`/user == {"tier": "gold"}`, []bool{false, false, false, true, false, false}},
+	} {
+		var filter, err = newRecordFilter(tc.expr)
+		require.NoError(t, err, tc.expr)
+		for i, record := range records {
+			matches, err := filter.matches([]byte(record))
+			require.NoError(t, err)
+			require.Equal(t, tc.expect[i], matches, "%s: %s", tc.expr, record)
+		}
+	}
+
+	// Every record matches an empty filter.
+	filter, err := newRecordFilter("")
+	require.NoError(t, err)
+	require.Nil(t, filter)
+	matches, err := filter.matches([]byte(`not even JSON`))
+	require.NoError(t, err)
+	require.True(t, matches)
+
+	for _, invalid := range []string{
+		`/eventType`,
+		`eventType == "order"`,
+		`/eventType = "order"`,
+		`/eventType == order`,
+		`/eventType == "order" &&`,
+		`|| /eventType == "order"`,
+	} {
+		_, err = newRecordFilter(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestExtractRecordsWithFilter(t *testing.T) {
+	var filter, err = newRecordFilter(`/eventType == "order"`)
+	require.NoError(t, err)
+	keys, err := newKeyExtractor("/id")
+	require.NoError(t, err)
+	var reader = &shardReader{
+		rangeOverlap: airbyte.FullRangeOverlap,
+		parent:       &streamReader{readerOptions: readerOptions{filter: filter, keys: keys}},
+	}
+
+	var resp = &kinesis.GetRecordsOutput{}
+	for i, data := range []string{
+		`{"id":"a","eventType":"order"}`,
+		`{"id":"b","eventType":"refund"}`,
+		`{"id":"c","eventType":"order"}`,
+		`{"id":"d"}`,
+	} {
+		resp.Records = append(resp.Records, types.Record{
+			Data:           []byte(data),
+			PartitionKey:   aws.String("pk"),
+			SequenceNumber: aws.String(string(rune('a' + i))),
+		})
+	}
+	extracted, _, _, err := reader.extractRecords(resp)
+	require.NoError(t, err)
+	require.Len(t, extracted, 2)
+	require.JSONEq(t, `{"_meta":{"key":"a"},"id":"a","eventType":"order"}`, string(extracted[0]))
+	require.JSONEq(t, `{"_meta":{"key":"c"},"id":"c","eventType":"order"}`, string(extracted[1]))
+	require.Equal(t, int64(2), reader.filtered)
+
+	// Records must be JSON in order to be filtered.
+	resp.Records = []types.Record{{
+		Data:           []byte(`not JSON`),
+		PartitionKey:   aws.String("pk"),
+		SequenceNumber: aws.String("z"),
+	}}
+	_, _, _, err = reader.extractRecords(resp)
+	require.EqualError(t, err, "record z: filtering record: record is not valid JSON")
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"encoding/json"
+	"unicode/utf8"
+)
+
+// The properties of the envelope of a record which isn't a JSON object, when JSON parsing is
+// enabled. Payloads which are valid UTF-8 are captured as a string, and others as base64.
+const (
+	rawRecordProperty       = "_raw"
+	rawBase64RecordProperty = "_rawBase64"
+)
+
+// parseJSONRecord returns the record as it is if it's a JSON object. Otherwise it returns an
+// envelope object holding the record's payload, so that every captured document is an object. The
+// returned bool is false if the record was wrapped in an envelope.
+func parseJSONRecord(data []byte) (json.RawMessage, bool, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err == nil && doc != nil {
+		return data, true, nil
+	}
+
+	var envelope = make(map[string]interface{}, 1)
+	if utf8.Valid(data) {
+		envelope[rawRecordProperty] = string(data)
+	} else {
+		envelope[rawBase64RecordProperty] = data
+	}
+	var wrapped, err = json.Marshal(envelope)
+	if err != nil {
+		return nil, false, err
+	}
+	return wrapped, false, nil
+}
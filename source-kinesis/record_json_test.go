@@ -0,0 +1,69 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/estuary/flow/go/protocols/airbyte"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseJSONRecord(t *testing.T) {
+	for _, tc := range []struct {
+		data   string
+		expect string
+		parsed bool
+	}{
+		{`{"n": 1, "big": 12345678901234567890}`, `{"n": 1, "big": 12345678901234567890}`, true},
+		{` {} `, ` {} `, true},
+		{`[1, 2]`, `{"_raw":"[1, 2]"}`, false},
+		{`"text"`, `{"_raw":"\"text\""}`, false},
+		{`null`, `{"_raw":"null"}`, false},
+		{`{"n": 1`, `{"_raw":"{\"n\": 1"}`, false},
+		{`GET /index.html 200`, `{"_raw":"GET /index.html 200"}`, false},
+		{"", `{"_raw":""}`, false},
+		{"\xff\x00\x01", `{"_rawBase64":"/wAB"}`, false},
+	} {
+		var result, parsed, err = parseJSONRecord([]byte(tc.data))
+		require.NoError(t, err)
+		require.Equal(t, tc.expect, string(result), tc.data)
+		require.Equal(t, tc.parsed, parsed, tc.data)
+	}
+}
+
+func TestExtractRecordsWithJSONParsing(t *testing.T) {
+	var keys, err = newKeyExtractor("/id")
+	require.NoError(t, err)
+	var reader = &shardReader{
+		rangeOverlap: airbyte.FullRangeOverlap,
+		parent:       &streamReader{readerOptions: readerOptions{keys: keys}},
+		logEntry:     log.NewEntry(log.StandardLogger()),
+	}
+
+	var resp = &kinesis.GetRecordsOutput{}
+	for i, data := range []string{`{"id":"a"}`, `not json`, `{"id":"c"}`} {
+		resp.Records = append(resp.Records, types.Record{
+			Data:           []byte(data),
+			PartitionKey:   aws.String("pk"),
+			SequenceNumber: aws.String(string(rune('a' + i))),
+		})
+	}
+
+	// Without parsing, keys can't be added to records which aren't JSON objects.
+	_, _, _, err = reader.extractRecords(resp)
+	require.Error(t, err)
+
+	// With parsing they're captured in an envelope, which is keyed by the partition key.
+	reader.parent.parseJSON = true
+	extracted, positions, _, err := reader.extractRecords(resp)
+	require.NoError(t, err)
+	require.Len(t, extracted, 3)
+	require.Equal(t, `{"_meta":{"key":"a"},"id":"a"}`, string(extracted[0]))
+	require.Equal(t, `{"_meta":{"key":"pk"},"_raw":"not json"}`, string(extracted[1]))
+	require.Equal(t, `{"_meta":{"key":"c"},"id":"c"}`, string(extracted[2]))
+	require.Equal(t, []string{"a", "b", "c"}, positions)
+	require.Equal(t, int64(1), reader.unparsed)
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// metaKeyProperty is the property of the `_meta` object of each record which holds its key, when
+// key extraction is enabled.
+const metaKeyProperty = "key"
+
+// keyExtractor extracts the logical key of each record from a field of the parsed JSON record,
+// and adds it to the record as `/_meta/key`. This allows downstream systems to key and partition
+// records by something other than the kinesis partition key.
+type keyExtractor struct {
+	// The unescaped tokens of the JSON pointer to the key field.
+	tokens []string
+}
+
+// newKeyExtractor returns a keyExtractor for the given JSON pointer, or nil if the pointer is empty.
+func newKeyExtractor(pointer string) (*keyExtractor, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	var tokens, err = parseJSONPointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	return &keyExtractor{tokens: tokens}, nil
+}
+
+// parseJSONPointer parses a JSON pointer which identifies a field within a document, returning
+// its unescaped tokens.
+func parseJSONPointer(pointer string) ([]string, error) {
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q: must begin with '/'", pointer)
+	}
+	var tokens = strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		if token == "" {
+			return nil, fmt.Errorf("invalid JSON pointer %q: must not contain empty tokens", pointer)
+		}
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(token)
+	}
+	return tokens, nil
+}
+
+// addKey returns the record with its key added as `/_meta/key`. The key is the extracted field
+// if it's a string, or the JSON encoding of other scalar values, so that keys are always strings.
+// If the field is absent, null, or not a scalar, then the kinesis partition key is used instead.
+func (e *keyExtractor) addKey(data []byte, partitionKey string) (json.RawMessage, error) {
+	if e == nil {
+		return data, nil
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil || doc == nil {
+		return nil, fmt.Errorf("extracting record key: record is not a JSON object")
+	}
+	var key, ok = e.extract(doc)
+	if !ok {
+		key = partitionKey
+	}
+
+	var meta = make(map[string]interface{})
+	if rawMeta, exists := doc["_meta"]; exists {
+		if err := json.Unmarshal(rawMeta, &meta); err != nil || meta == nil {
+			return nil, fmt.Errorf("extracting record key: record has a '_meta' property which is not an object")
+		}
+	}
+	meta[metaKeyProperty] = key
+
+	var err error
+	if doc["_meta"], err = json.Marshal(meta); err != nil {
+		return nil, fmt.Errorf("extracting record key: %w", err)
+	}
+	return json.Marshal(doc)
+}
+
+// extract returns the key field of the document, and whether it was present as a scalar value.
+func (e *keyExtractor) extract(doc map[string]json.RawMessage) (string, bool) {
+	var raw json.RawMessage
+	for i, token := range e.tokens {
+		var ok bool
+		if raw, ok = doc[token]; !ok {
+			return "", false
+		} else if i < len(e.tokens)-1 {
+			doc = nil
+			if err := json.Unmarshal(raw, &doc); err != nil || doc == nil {
+				return "", false
+			}
+		}
+	}
+
+	var value interface{}
+	var decoder = json.NewDecoder(bytes.NewReader(raw))
+	decoder.UseNumber()
+	if err := decoder.Decode(&value); err != nil {
+		return "", false
+	}
+	switch v := value.(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return fmt.Sprint(v), true
+	default:
+		return "", false
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/estuary/flow/go/protocols/airbyte"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseJSONPointer(t *testing.T) {
+	var tokens, err = parseJSONPointer("/user/id")
+	require.NoError(t, err)
+	require.Equal(t, []string{"user", "id"}, tokens)
+
+	tokens, err = parseJSONPointer("/a~1b/c~0d")
+	require.NoError(t, err)
+	require.Equal(t, []string{"a/b", "c~d"}, tokens)
+
+	for _, invalid := range []string{"/", "user/id", "/user//id", "/user/"} {
+		_, err = parseJSONPointer(invalid)
+		require.Error(t, err, invalid)
+	}
+}
+
+func TestExtractRecordsWithKeys(t *testing.T) {
+	var keys, err = newKeyExtractor("/user/id")
+	require.NoError(t, err)
+	var reader = &shardReader{
+		rangeOverlap: airbyte.FullRangeOverlap,
+		parent:       &streamReader{readerOptions: readerOptions{keys: keys}},
+	}
+
+	var records = []struct {
+		data         string
+		partitionKey string
+		expect       string
+	}{
+		{`{"user":{"id":"abc"},"n":1}`, "pk1", `{"_meta":{"key":"abc"},"n":1,"user":{"id":"abc"}}`},
+		{`{"user":{"id":42}}`, "pk2", `{"_meta":{"key":"42"},"user":{"id":42}}`},
+		{`{"user":{"id":12345678901234567890}}`, "pk3", `{"_meta":{"key":"12345678901234567890"},"user":{"id":12345678901234567890}}`},
+		// Records which are missing the field, or where it isn't a scalar, fall back to the partition key.
+		{`{"user":{}}`, "pk4", `{"_meta":{"key":"pk4"},"user":{}}`},
+		{`{"user":"abc"}`, "pk5", `{"_meta":{"key":"pk5"},"user":"abc"}`},
+		{`{"user":{"id":null}}`, "pk6", `{"_meta":{"key":"pk6"},"user":{"id":null}}`},
+		{`{"user":{"id":{"nested":true}}}`, "pk7", `{"_meta":{"key":"pk7"},"user":{"id":{"nested":true}}}`},
+		// Existing `_meta` properties are preserved.
+		{`{"_meta":{"source":"x"},"user":{"id":true}}`, "pk8", `{"_meta":{"key":"true","source":"x"},"user":{"id":true}}`},
+	}
+	var resp = &kinesis.GetRecordsOutput{}
+	for i, rec := range records {
+		resp.Records = append(resp.Records, types.Record{
+			Data:           []byte(rec.data),
+			PartitionKey:   aws.String(rec.partitionKey),
+			SequenceNumber: aws.String(string(rune('a' + i))),
+		})
+	}
+	extracted, _, _, err := reader.extractRecords(resp)
+	require.NoError(t, err)
+	require.Len(t, extracted, len(records))
+	for i, rec := range records {
+		require.JSONEq(t, rec.expect, string(extracted[i]))
+	}
+
+	// Records which aren't JSON objects can't be keyed.
+	resp.Records = []types.Record{{
+		Data:           []byte(`[1, 2, 3]`),
+		PartitionKey:   aws.String("pk"),
+		SequenceNumber: aws.String("z"),
+	}}
+	_, _, _, err = reader.extractRecords(resp)
+	require.EqualError(t, err, "record z: extracting record key: record is not a JSON object")
+
+	// Without a key extractor, records are passed through unmodified.
+	reader.parent.keys = nil
+	extracted, _, _, err = reader.extractRecords(resp)
+	require.NoError(t, err)
+	require.Equal(t, `[1, 2, 3]`, string(extracted[0]))
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+)
+
+// Policies for records which are larger than `maxRecordBytes`.
+const (
+	oversizedRecordError    = "error"
+	oversizedRecordSkip     = "skip"
+	oversizedRecordTruncate = "truncate"
+)
+
+// metaTruncatedProperty is the property of the `_meta` object of a truncated record which lists
+// the properties that were removed from it.
+const metaTruncatedProperty = "truncated"
+
+// recordSizeLimit applies a policy to records which are larger than a maximum size.
+type recordSizeLimit struct {
+	maxBytes int
+	policy   string
+}
+
+// newRecordSizeLimit returns a recordSizeLimit for the given maximum size and policy, or nil if
+// the maximum is zero. The policy defaults to `error`.
+func newRecordSizeLimit(maxBytes int, policy string) (*recordSizeLimit, error) {
+	if maxBytes == 0 {
+		return nil, nil
+	} else if maxBytes < 0 {
+		return nil, fmt.Errorf("maxRecordBytes must not be negative")
+	}
+	switch policy {
+	case "":
+		policy = oversizedRecordError
+	case oversizedRecordError, oversizedRecordSkip, oversizedRecordTruncate:
+	default:
+		return nil, fmt.Errorf("invalid oversizedRecordPolicy %q", policy)
+	}
+	return &recordSizeLimit{maxBytes: maxBytes, policy: policy}, nil
+}
+
+// apply returns the record if it's within the size limit, and otherwise applies the policy to it.
+// A nil record is returned if it should be skipped. Truncated records have their largest
+// top-level properties removed until they fit, and the names of the removed properties are listed
+// in `/_meta/truncated`.
+func (l *recordSizeLimit) apply(data json.RawMessage) (json.RawMessage, error) {
+	if l == nil || len(data) <= l.maxBytes {
+		return data, nil
+	}
+	switch l.policy {
+	case oversizedRecordSkip:
+		return nil, nil
+	case oversizedRecordTruncate:
+		return l.truncate(data)
+	default:
+		return nil, fmt.Errorf("record size of %d bytes exceeds maxRecordBytes of %d", len(data), l.maxBytes)
+	}
+}
+
+func (l *recordSizeLimit) truncate(data json.RawMessage) (json.RawMessage, error) {
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil || doc == nil {
+		return nil, fmt.Errorf("truncating record: record is not a JSON object")
+	}
+	var meta = make(map[string]interface{})
+	if rawMeta, exists := doc["_meta"]; exists {
+		if err := json.Unmarshal(rawMeta, &meta); err != nil || meta == nil {
+			return nil, fmt.Errorf("truncating record: record has a '_meta' property which is not an object")
+		}
+	}
+
+	// Properties are removed largest first, with ties broken by name so that the result is
+	// deterministic. The `_meta` property is never removed.
+	var names []string
+	for name := range doc {
+		if name != "_meta" {
+			names = append(names, name)
+		}
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if len(doc[names[i]]) != len(doc[names[j]]) {
+			return len(doc[names[i]]) > len(doc[names[j]])
+		}
+		return names[i] < names[j]
+	})
+
+	var removed = []string{}
+	for _, name := range names {
+		delete(doc, name)
+		removed = append(removed, name)
+		sort.Strings(removed)
+		meta[metaTruncatedProperty] = removed
+
+		var err error
+		if doc["_meta"], err = json.Marshal(meta); err != nil {
+			return nil, fmt.Errorf("truncating record: %w", err)
+		}
+		truncated, err := json.Marshal(doc)
+		if err != nil {
+			return nil, fmt.Errorf("truncating record: %w", err)
+		} else if len(truncated) <= l.maxBytes {
+			return truncated, nil
+		}
+	}
+	return nil, fmt.Errorf("record size of %d bytes exceeds maxRecordBytes of %d even after removing all properties", len(data), l.maxBytes)
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/estuary/flow/go/protocols/airbyte"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRecordSizeLimit(t *testing.T) {
+	var limit, err = newRecordSizeLimit(0, oversizedRecordSkip)
+	require.NoError(t, err)
+	require.Nil(t, limit)
+
+	limit, err = newRecordSizeLimit(100, "")
+	require.NoError(t, err)
+	require.Equal(t, &recordSizeLimit{maxBytes: 100, policy: oversizedRecordError}, limit)
+
+	_, err = newRecordSizeLimit(-1, "")
+	require.Error(t, err)
+	_, err = newRecordSizeLimit(100, "drop")
+	require.EqualError(t, err, `invalid oversizedRecordPolicy "drop"`)
+}
+
+func TestExtractRecordsWithSizeLimit(t *testing.T) {
+	var big = strings.Repeat("x", 100)
+	var records = []string{
+		`{"id":1,"small":"a"}`,
+		`{"id":2,"big":"` + big + `","small":"b"}`,
+		`{"id":3,"small":"c"}`,
+	}
+	var resp = &kinesis.GetRecordsOutput{}
+	for i, data := range records {
+		resp.Records = append(resp.Records, types.Record{
+			Data:           []byte(data),
+			PartitionKey:   aws.String("pk"),
+			SequenceNumber: aws.String(string(rune('a' + i))),
+		})
+	}
+
+	var extract = func(policy string) ([]string, error) {
+		var limit, err = newRecordSizeLimit(64, policy)
+		require.NoError(t, err)
+		var reader = &shardReader{
+			rangeOverlap: airbyte.FullRangeOverlap,
+			parent:       &streamReader{readerOptions: readerOptions{sizeLimit: limit}},
+			logEntry:     log.NewEntry(log.StandardLogger()),
+		}
+		extracted, _, _, err := reader.extractRecords(resp)
+		var result []string
+		for _, rec := range extracted {
+			result = append(result, string(rec))
+		}
+		return result, err
+	}
+
+	// The oversized record fails the capture by default.
+	var _, err = extract("")
+	require.EqualError(t, err, "record b: record size of 129 bytes exceeds maxRecordBytes of 64")
+	_, err = extract(oversizedRecordError)
+	require.EqualError(t, err, "record b: record size of 129 bytes exceeds maxRecordBytes of 64")
+
+	extracted, err := extract(oversizedRecordSkip)
+	require.NoError(t, err)
+	require.Equal(t, []string{records[0], records[2]}, extracted)
+
+	extracted, err = extract(oversizedRecordTruncate)
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		records[0],
+		`{"_meta":{"truncated":["big"]},"id":2,"small":"b"}`,
+		records[2],
+	}, extracted)
+}
+
+func TestTruncateRecord(t *testing.T) {
+	var limit = &recordSizeLimit{maxBytes: 80, policy: oversizedRecordTruncate}
+
+	// Properties are removed largest first until the record fits, and existing `_meta`
+	// properties are preserved.
+	var truncated, err = limit.apply([]byte(`{"_meta":{"key":"k"},"a":"` + strings.Repeat("a", 40) + `","b":"` + strings.Repeat("b", 30) + `","c":[1,2,3]}`))
+	require.NoError(t, err)
+	require.Equal(t, `{"_meta":{"key":"k","truncated":["a","b"]},"c":[1,2,3]}`, string(truncated))
+
+	// Records which fit aren't modified.
+	truncated, err = limit.apply([]byte(`{"b": "b", "a": "a"}`))
+	require.NoError(t, err)
+	require.Equal(t, `{"b": "b", "a": "a"}`, string(truncated))
+
+	// Records which can't be truncated enough, or which aren't objects, are errors.
+	_, err = limit.apply([]byte(`{"_meta":{"other":"` + strings.Repeat("m", 80) + `"},"a":1}`))
+	require.EqualError(t, err, "record size of 108 bytes exceeds maxRecordBytes of 80 even after removing all properties")
+	_, err = limit.apply([]byte(`"` + strings.Repeat("s", 80) + `"`))
+	require.EqualError(t, err, "truncating record: record is not a JSON object")
+}
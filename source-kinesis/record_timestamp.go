@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+)
+
+// Sources of the timestamp with which each record is emitted.
+const (
+	// Records are emitted with the time at which they're emitted.
+	timestampSourceNow = "now"
+	// Records are emitted with the approximate time at which kinesis received them.
+	timestampSourceArrival = "arrival"
+	// Records are emitted with the time held by a field of the record.
+	timestampSourceField = "field"
+)
+
+// Formats of the values of a timestamp field.
+const (
+	// An RFC3339 timestamp string, such as "2022-01-02T15:04:05.123Z".
+	timestampFormatRFC3339 = "rfc3339"
+	// A number of seconds since the unix epoch, which may have a fractional part.
+	timestampFormatUnixSeconds = "unix_seconds"
+	// A number of milliseconds since the unix epoch.
+	timestampFormatUnixMillis = "unix_millis"
+)
+
+// recordTimestamper determines the timestamp with which each record is emitted, so that
+// downstream event-time processing can use when a record was received by kinesis or when its
+// event occurred rather than when it happened to be captured.
+type recordTimestamper struct {
+	source string
+	format string
+	// The field of records holding their timestamps, when the source is a field.
+	field *keyExtractor
+}
+
+// newRecordTimestamper returns a recordTimestamper for the given configuration, or nil if records
+// are emitted with the current time. The `timestampField` is a JSON pointer which is required by
+// the `field` source and not allowed otherwise, and its format defaults to `rfc3339`.
+func newRecordTimestamper(source, field, format string) (*recordTimestamper, error) {
+	switch source {
+	case "", timestampSourceNow, timestampSourceArrival:
+		if field != "" || format != "" {
+			return nil, fmt.Errorf("timestampField and timestampFormat require a timestampSource of %q", timestampSourceField)
+		} else if source == timestampSourceArrival {
+			return &recordTimestamper{source: source}, nil
+		}
+		return nil, nil
+	case timestampSourceField:
+		if field == "" {
+			return nil, fmt.Errorf("timestampField is required when timestampSource is %q", timestampSourceField)
+		}
+		var extractor, err = newKeyExtractor(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestampField: %w", err)
+		}
+		switch format {
+		case "":
+			format = timestampFormatRFC3339
+		case timestampFormatRFC3339, timestampFormatUnixSeconds, timestampFormatUnixMillis:
+		default:
+			return nil, fmt.Errorf("invalid timestampFormat %q", format)
+		}
+		return &recordTimestamper{source: source, format: format, field: extractor}, nil
+	default:
+		return nil, fmt.Errorf("invalid timestampSource %q", source)
+	}
+}
+
+// timestamp returns the timestamp of a record, given its data and the approximate time at which
+// kinesis received it, if known. It returns false if the record has no such timestamp, or if the
+// timestamper is nil, in which case the record is emitted with the current time.
+func (t *recordTimestamper) timestamp(data []byte, arrival *time.Time) (time.Time, bool) {
+	if t == nil {
+		return time.Time{}, false
+	} else if t.source == timestampSourceArrival {
+		if arrival == nil {
+			return time.Time{}, false
+		}
+		return *arrival, true
+	}
+
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil || doc == nil {
+		return time.Time{}, false
+	}
+	var value, ok = t.field.extract(doc)
+	if !ok {
+		return time.Time{}, false
+	}
+	return parseTimestamp(value, t.format)
+}
+
+// maxTimestampMillis is the magnitude of the largest timestamp in milliseconds since the unix
+// epoch which can be parsed, beyond which its count of nanoseconds would overflow.
+const maxTimestampMillis = float64(math.MaxInt64 / int64(time.Millisecond))
+
+// parseTimestamp parses the value of a timestamp field in the given format, returning false if
+// it's not a valid timestamp of that format.
+func parseTimestamp(value, format string) (time.Time, bool) {
+	switch format {
+	case timestampFormatRFC3339:
+		var ts, err = time.Parse(time.RFC3339Nano, value)
+		return ts, err == nil
+	case timestampFormatUnixSeconds, timestampFormatUnixMillis:
+		var n, err = strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(n) || math.IsInf(n, 0) {
+			return time.Time{}, false
+		}
+		if format == timestampFormatUnixSeconds {
+			n *= 1000
+		}
+		var millis = math.Round(n)
+		if math.Abs(millis) > maxTimestampMillis {
+			return time.Time{}, false
+		}
+		return time.Unix(0, int64(millis)*int64(time.Millisecond)), true
+	}
+	return time.Time{}, false
+}
+
+// emittedAt returns the `EmittedAt` of a record with the given timestamp, which is the current
+// time if the timestamp is zero.
+func emittedAt(ts time.Time) int64 {
+	if ts.IsZero() {
+		ts = time.Now()
+	}
+	return ts.Unix()*1000 + int64(ts.Nanosecond())/int64(time.Millisecond)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/estuary/flow/go/protocols/airbyte"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordTimestamperValidation(t *testing.T) {
+	for _, tc := range []struct {
+		source, field, format string
+		expectError           string
+	}{
+		{"", "", "", ""},
+		{"now", "", "", ""},
+		{"arrival", "", "", ""},
+		{"field", "/ts", "", ""},
+		{"field", "/event/ts", "unix_seconds", ""},
+		{"field", "/ts", "unix_millis", ""},
+		{"field", "", "", "timestampField is required"},
+		{"field", "ts", "", "invalid timestampField"},
+		{"field", "/a//b", "", "invalid timestampField"},
+		{"field", "/ts", "iso", "invalid timestampFormat"},
+		{"", "/ts", "", "require a timestampSource of \"field\""},
+		{"arrival", "", "rfc3339", "require a timestampSource of \"field\""},
+		{"event", "", "", "invalid timestampSource"},
+	} {
+		var _, err = newRecordTimestamper(tc.source, tc.field, tc.format)
+		if tc.expectError == "" {
+			require.NoError(t, err, tc)
+		} else {
+			require.Error(t, err, tc)
+			require.Contains(t, err.Error(), tc.expectError)
+		}
+	}
+}
+
+func TestRecordTimestamp(t *testing.T) {
+	var arrival = time.Date(2022, 1, 2, 15, 4, 5, 123000000, time.UTC)
+	var event = time.Date(2021, 6, 7, 8, 9, 10, 456000000, time.UTC)
+
+	// Records are emitted with the current time unless a timestamp source is configured.
+	for _, source := range []string{"", "now"} {
+		var timestamps, err = newRecordTimestamper(source, "", "")
+		require.NoError(t, err)
+		var _, ok = timestamps.timestamp([]byte(`{}`), &arrival)
+		require.False(t, ok)
+	}
+
+	arrivalTimestamps, err := newRecordTimestamper("arrival", "", "")
+	require.NoError(t, err)
+	ts, ok := arrivalTimestamps.timestamp([]byte(`not json`), &arrival)
+	require.True(t, ok)
+	require.Equal(t, arrival, ts)
+	_, ok = arrivalTimestamps.timestamp([]byte(`{}`), nil)
+	require.False(t, ok)
+
+	for _, tc := range []struct {
+		format string
+		data   string
+		expect time.Time // Zero if the record has no timestamp.
+	}{
+		{"rfc3339", `{"event":{"ts":"2021-06-07T08:09:10.456Z"}}`, event},
+		{"rfc3339", `{"event":{"ts":"2021-06-07T10:09:10.456+02:00"}}`, event},
+		{"unix_seconds", `{"event":{"ts":1623053350.456}}`, event},
+		{"unix_seconds", `{"event":{"ts":"1623053350.456"}}`, event},
+		{"unix_millis", `{"event":{"ts":1623053350456}}`, event},
+		// Records whose field is missing or isn't a valid timestamp of the format have none.
+		{"rfc3339", `{"event":{}}`, time.Time{}},
+		{"rfc3339", `{"event":{"ts":null}}`, time.Time{}},
+		{"rfc3339", `{"event":{"ts":"yesterday"}}`, time.Time{}},
+		{"rfc3339", `{"event":{"ts":1623053350}}`, time.Time{}},
+		{"unix_seconds", `{"event":{"ts":"2021-06-07T08:09:10Z"}}`, time.Time{}},
+		{"unix_millis", `{"event":{"ts":1e300}}`, time.Time{}},
+		{"unix_millis", `[1, 2, 3]`, time.Time{}},
+	} {
+		var timestamps, err = newRecordTimestamper("field", "/event/ts", tc.format)
+		require.NoError(t, err)
+		var ts, ok = timestamps.timestamp([]byte(tc.data), &arrival)
+		require.Equal(t, !tc.expect.IsZero(), ok, tc.data)
+		if ok {
+			require.True(t, tc.expect.Equal(ts), "%s: %v", tc.data, ts)
+		}
+	}
+}
+
+func TestExtractRecordsWithTimestamps(t *testing.T) {
+	var timestamps, err = newRecordTimestamper("field", "/ts", "unix_millis")
+	require.NoError(t, err)
+	var reader = &shardReader{
+		rangeOverlap: airbyte.FullRangeOverlap,
+		parent:       &streamReader{stream: "stream", readerOptions: readerOptions{timestamps: timestamps}},
+	}
+
+	var arrival = time.Date(2022, 1, 2, 15, 4, 5, 0, time.UTC)
+	var resp = &kinesis.GetRecordsOutput{}
+	for i, data := range []string{`{"ts":1000}`, `{"n":2}`, `{"ts":3000}`} {
+		resp.Records = append(resp.Records, types.Record{
+			Data:                        []byte(data),
+			PartitionKey:                aws.String("pk"),
+			SequenceNumber:              aws.String(string(rune('a' + i))),
+			ApproximateArrivalTimestamp: aws.Time(arrival),
+		})
+	}
+	extracted, _, extractedTimestamps, err := reader.extractRecords(resp)
+	require.NoError(t, err)
+	require.Len(t, extracted, 3)
+	require.Equal(t, int64(1000), emittedAt(extractedTimestamps[0]))
+	require.True(t, extractedTimestamps[1].IsZero())
+	require.Equal(t, int64(3000), emittedAt(extractedTimestamps[2]))
+	require.Equal(t, int64(1), reader.untimestamped)
+
+	// With the arrival source, each record has the arrival time of its kinesis record.
+	reader.parent.timestamps, err = newRecordTimestamper("arrival", "", "")
+	require.NoError(t, err)
+	_, _, extractedTimestamps, err = reader.extractRecords(resp)
+	require.NoError(t, err)
+	for _, ts := range extractedTimestamps {
+		require.Equal(t, arrival, ts)
+	}
+
+	// Without a timestamp source, no timestamps are returned.
+	reader.parent.timestamps = nil
+	_, _, extractedTimestamps, err = reader.extractRecords(resp)
+	require.NoError(t, err)
+	require.Nil(t, extractedTimestamps)
+}
+
+func TestEmitRecordTimestamps(t *testing.T) {
+	var source = &recordSource{stream: "test-stream", shardID: "shard-0"}
+	var inFlight = newInFlightLimiter(10)
+	var _, err = inFlight.acquire(context.Background(), 3)
+	require.NoError(t, err)
+
+	var output = new(memoryOutput)
+	var before = emittedAt(time.Time{})
+	require.NoError(t, emitResults(&Config{}, output, inFlight,
+		readResult{
+			source:         source,
+			records:        []json.RawMessage{json.RawMessage(`{"n":1}`), json.RawMessage(`{"n":2}`)},
+			timestamps:     []time.Time{time.Unix(1623053350, 456000000), {}},
+			sequenceNumber: "2",
+		},
+		readResult{source: source, records: []json.RawMessage{json.RawMessage(`{"n":3}`)}, sequenceNumber: "3"},
+	))
+	require.Len(t, output.records, 3)
+	require.Equal(t, int64(1623053350456), output.records[0].EmittedAt)
+	// Records without timestamps are emitted with the current time.
+	require.GreaterOrEqual(t, output.records[1].EmittedAt, before)
+	require.GreaterOrEqual(t, output.records[2].EmittedAt, before)
+}
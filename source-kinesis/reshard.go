@@ -0,0 +1,116 @@
+package main
+
+import (
+	"errors"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/estuary/flow/go/protocols/airbyte"
+	log "github.com/sirupsen/logrus"
+)
+
+// shardListingInterval is how often the shards of a stream are listed while tailing it, in order to
+// start reading any new shards which weren't returned as the children of a shard that was read.
+const shardListingInterval = time.Minute
+
+// markDrained records that the shard has been read to its end and all of its records have been
+// sent, or that it no longer exists, so that the reads of its children may begin.
+func (kc *streamReader) markDrained(shardID string) {
+	kc.readingShardsMutex.Lock()
+	defer kc.readingShardsMutex.Unlock()
+	if kc.drainedShards == nil {
+		kc.drainedShards = make(map[string]bool)
+	}
+	kc.drainedShards[shardID] = true
+}
+
+// pendingParent returns the id of a parent of a child shard which is still being read, and whose
+// child therefore mustn't be read yet. Parents which aren't being read by this capture shard,
+// because they're outside of its range or have expired, don't hold up their children. Each parent
+// starts the reads of its children once it's drained, so a child which is merged from two shards
+// is read once the last of them is drained.
+func (kc *streamReader) pendingParent(child *types.ChildShard) (string, bool) {
+	kc.readingShardsMutex.Lock()
+	defer kc.readingShardsMutex.Unlock()
+	for _, parentID := range child.ParentShards {
+		if kc.readingShards[parentID] && !kc.drainedShards[parentID] {
+			return parentID, true
+		}
+	}
+	return "", false
+}
+
+// watchShards periodically lists the shards of the stream and starts reads of those which are
+// found by unreadShards. Child shards are usually read upon reaching the end of their parents, but
+// a parent may expire or be deleted before its end is reached, in which case its children are only
+// found here. The `waitGroup` is expected to have been incremented for the watch, which runs until
+// the context is cancelled.
+func (kc *streamReader) watchShards() {
+	defer kc.waitGroup.Done()
+	var ticker = time.NewTicker(shardListingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-kc.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		var listing, err = kc.listAllShards()
+		var notFound *types.ResourceNotFoundException
+		if isContextCanceled(err) {
+			return
+		} else if errors.As(err, &notFound) {
+			// The stream has been deleted, so there will never be any more shards.
+			return
+		} else if err != nil {
+			log.WithFields(log.Fields{
+				"kinesisStream": kc.stream,
+				"error":         err,
+			}).Warn("listing kinesis shards failed (will retry)")
+			continue
+		}
+		for _, shardID := range kc.unreadShards(listing) {
+			log.WithFields(log.Fields{
+				"kinesisStream":  kc.stream,
+				"kinesisShardId": shardID,
+			}).Info("Found kinesis shard which isn't being read")
+			kc.startReadingShardByID(shardID)
+		}
+	}
+}
+
+// unreadShards returns the ids of listed shards within the capture shard range which aren't being
+// read and haven't been read completely, and none of whose parents are still to be read. As with
+// the initial listing, a shard whose parent is listed and hasn't been drained waits for the parent,
+// which is itself returned if it isn't being read yet.
+func (kc *streamReader) unreadShards(listing map[string]*types.Shard) []string {
+	kc.readingShardsMutex.Lock()
+	defer kc.readingShardsMutex.Unlock()
+
+	var inRange = func(shard *types.Shard) bool {
+		var kinesisRange, err = parseKinesisShardRange(*shard.HashKeyRange.StartingHashKey, *shard.HashKeyRange.EndingHashKey)
+		// A shard whose range can't be parsed is returned, so that reading it reports the error.
+		return err != nil || kc.shardRange.Overlaps(kinesisRange) != airbyte.NoRangeOverlap
+	}
+	var done = func(shardID string) bool {
+		return kc.finishedShards[shardID] || kc.drainedShards[shardID]
+	}
+
+	var shardIDs []string
+	for shardID, shard := range listing {
+		if kc.readingShards[shardID] || done(shardID) || !inRange(shard) {
+			continue
+		}
+		var waiting bool
+		for _, parentID := range shardParents(shard) {
+			if parent, ok := listing[parentID]; ok && !done(parentID) && (kc.readingShards[parentID] || inRange(parent)) {
+				waiting = true
+			}
+		}
+		if !waiting {
+			shardIDs = append(shardIDs, shardID)
+		}
+	}
+	return shardIDs
+}
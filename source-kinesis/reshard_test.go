@@ -0,0 +1,106 @@
+package main
+
+import (
+	"sort"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/estuary/flow/go/protocols/airbyte"
+	"github.com/stretchr/testify/require"
+)
+
+func testShard(id, start, end string, parents ...string) *types.Shard {
+	var shard = &types.Shard{
+		ShardId: aws.String(id),
+		HashKeyRange: &types.HashKeyRange{
+			StartingHashKey: aws.String(start),
+			EndingHashKey:   aws.String(end),
+		},
+	}
+	if len(parents) > 0 {
+		shard.ParentShardId = aws.String(parents[0])
+	}
+	if len(parents) > 1 {
+		shard.AdjacentParentShardId = aws.String(parents[1])
+	}
+	return shard
+}
+
+func TestPendingParent(t *testing.T) {
+	var kc = &streamReader{readingShards: map[string]bool{"a": true, "b": true}}
+	var merged = &types.ChildShard{
+		ShardId:      aws.String("c"),
+		ParentShards: []string{"a", "b"},
+	}
+
+	// The child of a merge waits for both of its parents to be drained.
+	kc.markDrained("a")
+	var parentID, ok = kc.pendingParent(merged)
+	require.True(t, ok)
+	require.Equal(t, "b", parentID)
+
+	kc.markDrained("b")
+	_, ok = kc.pendingParent(merged)
+	require.False(t, ok)
+
+	// Parents which aren't being read don't hold up their children.
+	_, ok = kc.pendingParent(&types.ChildShard{
+		ShardId:      aws.String("e"),
+		ParentShards: []string{"d"},
+	})
+	require.False(t, ok)
+}
+
+func TestUnreadShards(t *testing.T) {
+	const midHash = "170141183460469231731687303715884105727"
+	const midHashPlusOne = "170141183460469231731687303715884105728"
+
+	// Shard "a" was split into "b" and "c", and "c" was later split into "d" and "e".
+	var listing = map[string]*types.Shard{
+		"a": testShard("a", "0", maxKinesisHash),
+		"b": testShard("b", "0", midHash, "a"),
+		"c": testShard("c", midHashPlusOne, maxKinesisHash, "a"),
+		"d": testShard("d", midHashPlusOne, "255211775190703847597530955573826158591", "c"),
+		"e": testShard("e", "255211775190703847597530955573826158592", maxKinesisHash, "c"),
+	}
+	var unread = func(kc *streamReader) []string {
+		var ids = kc.unreadShards(listing)
+		sort.Strings(ids)
+		return ids
+	}
+
+	// While the parent is being read, none of its descendants are.
+	var kc = &streamReader{
+		shardRange:    airbyte.NewFullRange(),
+		readingShards: map[string]bool{"a": true},
+	}
+	require.Empty(t, unread(kc))
+
+	// Once it's drained, its children are found, but not their own children.
+	kc.markDrained("a")
+	require.Equal(t, []string{"b", "c"}, unread(kc))
+
+	// Once the shards are being read they aren't found again.
+	kc.readingShards["b"] = true
+	kc.readingShards["c"] = true
+	require.Empty(t, unread(kc))
+	kc.markDrained("c")
+	require.Equal(t, []string{"d", "e"}, unread(kc))
+
+	// Shards which were read completely before the capture started aren't read again.
+	kc = &streamReader{
+		shardRange:     airbyte.NewFullRange(),
+		readingShards:  map[string]bool{"b": true, "d": true},
+		finishedShards: map[string]bool{"a": true, "c": true},
+	}
+	require.Equal(t, []string{"e"}, unread(kc))
+
+	// Shards which are outside of the capture shard range are neither read nor waited for.
+	kc = &streamReader{
+		shardRange:     airbyte.Range{Begin: 0, End: 0x7fffffff},
+		readingShards:  map[string]bool{"b": true},
+		finishedShards: map[string]bool{"a": true},
+	}
+	require.Empty(t, unread(kc))
+}
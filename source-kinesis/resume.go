@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	log "github.com/sirupsen/logrus"
+)
+
+// Policies for resuming the read of a kinesis shard whose stored sequence number is rejected by
+// kinesis, because the record has fallen off the end of the stream's retention period or the
+// sequence number doesn't belong to the shard.
+const (
+	// The shard is read from the oldest record that's still retained.
+	expiredSequenceTrimHorizon = "trim-horizon"
+	// The shard is read from the tip, skipping any records that are still retained.
+	expiredSequenceLatest = "latest"
+	// The capture fails.
+	expiredSequenceError = "error"
+)
+
+// errInvalidSequence is returned from getShardIterator when the stored sequence number of the
+// shard is rejected and the policy is `error`.
+var errInvalidSequence = errors.New("stored sequence number is invalid or has expired")
+
+func validateExpiredSequencePolicy(policy string) error {
+	switch policy {
+	case "", expiredSequenceTrimHorizon, expiredSequenceLatest, expiredSequenceError:
+		return nil
+	default:
+		return fmt.Errorf("invalid expiredSequencePolicy %q", policy)
+	}
+}
+
+// isInvalidSequence returns true if the error is kinesis rejecting the starting sequence number
+// of a GetShardIterator request.
+func isInvalidSequence(err error) bool {
+	var invalid *types.InvalidArgumentException
+	return errors.As(err, &invalid)
+}
+
+// recoverInvalidSequence applies the expired sequence policy after the stored sequence number of
+// the shard was rejected when resuming its read. It returns the position to read the shard from
+// instead, or an error wrapping errInvalidSequence if the capture should fail.
+func (r *shardReader) recoverInvalidSequence(cause error) (types.ShardIteratorType, error) {
+	var policy = r.parent.expiredPolicy
+	if policy == "" {
+		policy = expiredSequenceTrimHorizon
+	}
+	var logEntry = r.logEntry.WithFields(log.Fields{
+		"sequenceNumber": r.lastSequenceID,
+		"policy":         policy,
+		"error":          cause,
+	})
+
+	var position types.ShardIteratorType
+	switch policy {
+	case expiredSequenceError:
+		return "", fmt.Errorf("kinesis shard %q: %w: %s: %v", r.source.shardID, errInvalidSequence, r.lastSequenceID, cause)
+	case expiredSequenceLatest:
+		logEntry.Warn("stored sequence number of kinesis shard is invalid or has expired, resuming from the latest record")
+		position = types.ShardIteratorTypeLatest
+	default:
+		logEntry.Warn("stored sequence number of kinesis shard is invalid or has expired, resuming from the oldest retained record")
+		position = types.ShardIteratorTypeTrimHorizon
+	}
+	r.lastSequenceID = ""
+	return position, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	log "github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/require"
+)
+
+// expiredSequenceServer is a kinesis endpoint which rejects every GetShardIterator request
+// starting after a sequence number, as kinesis does once the record has expired, and otherwise
+// returns an iterator named after the requested iterator type.
+type expiredSequenceServer struct {
+	mu            sync.Mutex
+	iteratorTypes []string
+}
+
+func (s *expiredSequenceServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// Kinesis timestamps are sent as epoch seconds, which GetShardIteratorInput can't decode.
+	var input struct {
+		ShardId                *string
+		ShardIteratorType      *string
+		StartingSequenceNumber *string
+	}
+	if err := json.NewDecoder(req.Body).Decode(&input); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.iteratorTypes = append(s.iteratorTypes, *input.ShardIteratorType)
+	s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/x-amz-json-1.1")
+	if input.StartingSequenceNumber != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{
+			"__type":  "InvalidArgumentException",
+			"message": "StartingSequenceNumber " + *input.StartingSequenceNumber + " used in GetShardIterator on shard " + *input.ShardId + " is invalid",
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"ShardIterator": "iterator-" + *input.ShardIteratorType})
+}
+
+func newExpiredSequenceReader(t *testing.T, server *expiredSequenceServer, policy string) (*shardReader, chan readResult) {
+	var httpServer = httptest.NewServer(server)
+	t.Cleanup(httpServer.Close)
+
+	var client = kinesis.NewFromConfig(testAWSConfig(httpServer.URL))
+
+	var dataCh = make(chan readResult, 1)
+	var source = &recordSource{stream: "test-stream", shardID: "shardId-000000000000"}
+	return &shardReader{
+		ctx: context.Background(),
+		parent: &streamReader{
+			readerOptions: readerOptions{expiredPolicy: policy},
+			client:        client,
+			ctx:           context.Background(),
+			stream:        source.stream,
+			dataCh:        dataCh,
+		},
+		source:         source,
+		lastSequenceID: "49590338271490256608559692538361571095921575989136588898",
+		resuming:       true,
+		logEntry:       log.WithField("kinesisShardId", source.shardID),
+	}, dataCh
+}
+
+func TestExpiredSequencePolicy(t *testing.T) {
+	for _, tc := range []struct {
+		policy   string
+		iterator string
+	}{
+		{"", "iterator-TRIM_HORIZON"},
+		{expiredSequenceTrimHorizon, "iterator-TRIM_HORIZON"},
+		{expiredSequenceLatest, "iterator-LATEST"},
+	} {
+		var server = new(expiredSequenceServer)
+		var reader, _ = newExpiredSequenceReader(t, server, tc.policy)
+
+		// The stored sequence number is rejected, and the shard is read from the policy's position
+		// instead.
+		var iterator, err = reader.getShardIterator()
+		require.NoError(t, err, "policy %q", tc.policy)
+		require.Equal(t, tc.iterator, iterator, "policy %q", tc.policy)
+		require.Equal(t, "", reader.lastSequenceID)
+
+		// Later iterators, such as those which replace an iterator that's too old, start from the
+		// same position until a record has been read.
+		iterator, err = reader.getShardIterator()
+		require.NoError(t, err)
+		require.Equal(t, tc.iterator, iterator, "policy %q", tc.policy)
+		require.Equal(t, []string{string(types.ShardIteratorTypeAfterSequenceNumber), tc.iterator[len("iterator-"):], tc.iterator[len("iterator-"):]}, server.iteratorTypes)
+	}
+
+	// With the 'error' policy, the read of the shard fails the capture.
+	var server = new(expiredSequenceServer)
+	var reader, dataCh = newExpiredSequenceReader(t, server, expiredSequenceError)
+	var _, err = reader.getShardIterator()
+	require.True(t, errors.Is(err, errInvalidSequence), err)
+
+	reader.lastSequenceID = "49590338271490256608559692538361571095921575989136588898"
+	reader.readShard()
+	var result = <-dataCh
+	require.True(t, errors.Is(result.err, errInvalidSequence), result.err)
+	require.Contains(t, result.err.Error(), "shardId-000000000000")
+	var afterSeq = string(types.ShardIteratorTypeAfterSequenceNumber)
+	require.Equal(t, []string{afterSeq, afterSeq}, server.iteratorTypes)
+
+	// A sequence number which is rejected after the read has already started isn't stored, and so
+	// the policy doesn't apply to it.
+	server = new(expiredSequenceServer)
+	reader, _ = newExpiredSequenceReader(t, server, expiredSequenceLatest)
+	reader.resuming = false
+	_, err = reader.getShardIterator()
+	require.True(t, isInvalidSequence(err), err)
+	require.False(t, errors.Is(err, errInvalidSequence))
+}
+
+func TestValidateExpiredSequencePolicy(t *testing.T) {
+	for _, policy := range []string{"", expiredSequenceTrimHorizon, expiredSequenceLatest, expiredSequenceError} {
+		require.NoError(t, validateExpiredSequencePolicy(policy))
+	}
+	require.Error(t, validateExpiredSequencePolicy("skip"))
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/estuary/flow/go/protocols/airbyte"
+	log "github.com/sirupsen/logrus"
+)
+
+// sampleRequestsPerShard bounds the number of GetRecords requests made to sample each kinesis
+// shard, since reading from the oldest retained records of a shard can return several empty
+// responses before reaching any records.
+const sampleRequestsPerShard = 5
+
+// maxTrackedFields bounds the number of fields of each stream which are remembered in order to
+// notice fields that aren't in its discovered schema. Streams whose records have more distinct
+// fields than this, such as those with arbitrary keys, are no longer tracked once it's reached.
+const maxTrackedFields = 1000
+
+type recordSampler interface {
+	ListShards(context.Context, *kinesis.ListShardsInput, ...func(*kinesis.Options)) (*kinesis.ListShardsOutput, error)
+	GetShardIterator(context.Context, *kinesis.GetShardIteratorInput, ...func(*kinesis.Options)) (*kinesis.GetShardIteratorOutput, error)
+	GetRecords(context.Context, *kinesis.GetRecordsInput, ...func(*kinesis.Options)) (*kinesis.GetRecordsOutput, error)
+}
+
+// sampleStream returns up to limit records of a stream, read from the oldest retained records of
+// each of its shards in turn. Records are decompressed and parsed as they would be when captured,
+// and those which aren't JSON objects are left out.
+func sampleStream(ctx context.Context, client recordSampler, stream string, limit int, decompressor *recordDecompressor, parseJSON bool) ([]json.RawMessage, error) {
+	var shards []types.Shard
+	var input = &kinesis.ListShardsInput{StreamName: aws.String(stream)}
+	for {
+		var resp, err = client.ListShards(ctx, input)
+		if err != nil {
+			return nil, fmt.Errorf("listing shards: %w", err)
+		}
+		shards = append(shards, resp.Shards...)
+		if aws.ToString(resp.NextToken) == "" {
+			break
+		}
+		input = &kinesis.ListShardsInput{NextToken: resp.NextToken}
+	}
+
+	var samples []json.RawMessage
+	for _, shard := range shards {
+		var iterator, err = client.GetShardIterator(ctx, &kinesis.GetShardIteratorInput{
+			StreamName:        aws.String(stream),
+			ShardId:           shard.ShardId,
+			ShardIteratorType: types.ShardIteratorTypeTrimHorizon,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("getting iterator of shard %s: %w", aws.ToString(shard.ShardId), err)
+		}
+		var next = iterator.ShardIterator
+		for i := 0; i < sampleRequestsPerShard && next != nil && len(samples) < limit; i++ {
+			var resp, err = client.GetRecords(ctx, &kinesis.GetRecordsInput{
+				ShardIterator: next,
+				Limit:         aws.Int32(int32(limit - len(samples))),
+			})
+			if err != nil {
+				return nil, fmt.Errorf("reading shard %s: %w", aws.ToString(shard.ShardId), err)
+			}
+			for i := range resp.Records {
+				var userRecords, err = deaggregate(&resp.Records[i])
+				if err != nil {
+					return nil, err
+				}
+				for _, user := range userRecords {
+					if sample, ok := sampleRecord(user.data, decompressor, parseJSON); ok && len(samples) < limit {
+						samples = append(samples, sample)
+					}
+				}
+			}
+			if len(resp.Records) == 0 && aws.ToInt64(resp.MillisBehindLatest) == 0 {
+				break // The shard has no more records.
+			}
+			next = resp.NextShardIterator
+		}
+		if len(samples) >= limit {
+			break
+		}
+	}
+	return samples, nil
+}
+
+// sampledSchema returns the base schema of a stream with the properties inferred from a sample of
+// its records. Sampling is best-effort, so the base schema is returned if the stream can't be read.
+func sampledSchema(ctx context.Context, client recordSampler, config *Config, stream string, base json.RawMessage) (json.RawMessage, error) {
+	var decompressor, err = newRecordDecompressor(config.Compression, config.CorruptRecordPolicy)
+	if err != nil {
+		return nil, err
+	}
+	samples, err := sampleStream(ctx, client, stream, config.SampleForSchema, decompressor, config.ParseJSON)
+	if err != nil {
+		log.WithFields(log.Fields{"stream": stream, "error": err}).Warn("failed to sample records of kinesis stream, so its schema won't include their properties")
+		return base, nil
+	}
+	log.WithFields(log.Fields{"stream": stream, "samples": len(samples)}).Debug("sampled records of kinesis stream")
+	return inferSchema(base, samples)
+}
+
+// sampleRecord returns the payload of a sampled record, or false if it isn't a JSON object.
+func sampleRecord(data []byte, decompressor *recordDecompressor, parseJSON bool) (json.RawMessage, bool) {
+	var err error
+	if data, err = decompressor.decompress(data); err != nil {
+		return nil, false
+	}
+	if parseJSON {
+		if data, _, err = parseJSONRecord(data); err != nil {
+			return nil, false
+		}
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(data, &doc); err != nil || doc == nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// inferSchema returns the base schema of a stream with a property for each top-level field of
+// the sampled records which it doesn't already describe. Each property allows the JSON types of
+// the field's values in the samples. Other fields are still allowed, since samples are unlikely
+// to include every field that records may have.
+func inferSchema(base json.RawMessage, samples []json.RawMessage) (json.RawMessage, error) {
+	var schema map[string]interface{}
+	if err := json.Unmarshal(base, &schema); err != nil {
+		return nil, fmt.Errorf("parsing base schema: %w", err)
+	}
+	var properties, _ = schema["properties"].(map[string]interface{})
+	if properties == nil {
+		properties = make(map[string]interface{})
+	}
+
+	var fieldTypes = make(map[string]map[string]bool)
+	for _, sample := range samples {
+		var doc map[string]json.RawMessage
+		if err := json.Unmarshal(sample, &doc); err != nil {
+			return nil, fmt.Errorf("parsing sampled record: %w", err)
+		}
+		for field, value := range doc {
+			if _, ok := properties[field]; ok {
+				continue
+			}
+			if fieldTypes[field] == nil {
+				fieldTypes[field] = make(map[string]bool)
+			}
+			fieldTypes[field][jsonType(value)] = true
+		}
+	}
+	if len(fieldTypes) == 0 {
+		return base, nil
+	}
+
+	for field, types := range fieldTypes {
+		if types["number"] {
+			delete(types, "integer")
+		}
+		var names []string
+		for name := range types {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if len(names) == 1 {
+			properties[field] = map[string]interface{}{"type": names[0]}
+		} else {
+			properties[field] = map[string]interface{}{"type": names}
+		}
+	}
+	schema["properties"] = properties
+	return json.Marshal(schema)
+}
+
+// jsonType returns the JSON schema type of a JSON value.
+func jsonType(value json.RawMessage) string {
+	var trimmed = bytes.TrimSpace(value)
+	if len(trimmed) == 0 {
+		return "null"
+	}
+	switch trimmed[0] {
+	case '{':
+		return "object"
+	case '[':
+		return "array"
+	case '"':
+		return "string"
+	case 't', 'f':
+		return "boolean"
+	case 'n':
+		return "null"
+	}
+	if bytes.ContainsAny(trimmed, ".eE") {
+		return "number"
+	}
+	return "integer"
+}
+
+// schemaTracker notices when captured records have top-level fields which aren't properties of
+// the discovered schema of their stream, and logs a notice so that the stream can be re-discovered.
+// Each field is noticed once for as long as the connector runs.
+type schemaTracker struct {
+	// known are the fields of each tracked stream which are in its schema or were already noticed.
+	known map[string]map[string]bool
+}
+
+// newSchemaTracker returns a schemaTracker for the given streams.
+func newSchemaTracker(streams []airbyte.ConfiguredStream) (*schemaTracker, error) {
+	var tracker = &schemaTracker{known: make(map[string]map[string]bool)}
+	for _, stream := range streams {
+		var schema struct {
+			Properties map[string]json.RawMessage `json:"properties"`
+		}
+		if err := json.Unmarshal(stream.Stream.JSONSchema, &schema); err != nil {
+			return nil, fmt.Errorf("parsing schema of stream %q: %w", stream.Stream.Name, err)
+		}
+		var known = make(map[string]bool, len(schema.Properties))
+		for field := range schema.Properties {
+			known[field] = true
+		}
+		tracker.known[stream.Stream.Name] = known
+	}
+	return tracker, nil
+}
+
+// observe checks the fields of a captured record of the stream. It does nothing if the tracker is
+// nil, or once the number of fields of the stream has exceeded maxTrackedFields.
+func (t *schemaTracker) observe(stream string, record json.RawMessage) {
+	if t == nil {
+		return
+	}
+	var known, ok = t.known[stream]
+	if !ok {
+		return
+	}
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(record, &doc); err != nil {
+		return
+	}
+	var novel []string
+	for field := range doc {
+		if !known[field] {
+			novel = append(novel, field)
+		}
+	}
+	if len(novel) == 0 {
+		return
+	}
+	sort.Strings(novel)
+	log.WithFields(log.Fields{
+		"event":  "schema_change",
+		"stream": stream,
+		"fields": novel,
+	}).Warn("records of stream have fields which aren't in its discovered schema, so it should be re-discovered")
+
+	if len(known)+len(novel) > maxTrackedFields {
+		log.WithFields(log.Fields{
+			"stream": stream,
+			"limit":  maxTrackedFields,
+		}).Warn("records of stream have too many distinct fields, so no more will be noticed")
+		delete(t.known, stream)
+		return
+	}
+	for _, field := range novel {
+		known[field] = true
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/estuary/flow/go/protocols/airbyte"
+	logtest "github.com/sirupsen/logrus/hooks/test"
+	"github.com/stretchr/testify/require"
+)
+
+// mockSampler serves the records of each shard from its oldest one, returning an empty response
+// before them as kinesis may, and counts the GetRecords requests.
+type mockSampler struct {
+	shards   map[string][]string
+	requests int
+}
+
+func (m *mockSampler) ListShards(_ context.Context, input *kinesis.ListShardsInput, _ ...func(*kinesis.Options)) (*kinesis.ListShardsOutput, error) {
+	var resp = &kinesis.ListShardsOutput{}
+	for _, id := range []string{"shardId-000000000000", "shardId-000000000001"} {
+		if _, ok := m.shards[id]; ok {
+			resp.Shards = append(resp.Shards, types.Shard{ShardId: aws.String(id)})
+		}
+	}
+	return resp, nil
+}
+
+func (m *mockSampler) GetShardIterator(_ context.Context, input *kinesis.GetShardIteratorInput, _ ...func(*kinesis.Options)) (*kinesis.GetShardIteratorOutput, error) {
+	return &kinesis.GetShardIteratorOutput{ShardIterator: aws.String(aws.ToString(input.ShardId) + "/-1")}, nil
+}
+
+func (m *mockSampler) GetRecords(_ context.Context, input *kinesis.GetRecordsInput, _ ...func(*kinesis.Options)) (*kinesis.GetRecordsOutput, error) {
+	m.requests++
+	var shardID string
+	var offset int
+	if _, err := fmt.Sscanf(aws.ToString(input.ShardIterator), "shardId-%12s/%d", &shardID, &offset); err != nil {
+		return nil, err
+	}
+	shardID = "shardId-" + shardID
+	var records = m.shards[shardID]
+	var resp = &kinesis.GetRecordsOutput{MillisBehindLatest: aws.Int64(0)}
+	if offset < 0 {
+		resp.MillisBehindLatest = aws.Int64(1000)
+		resp.NextShardIterator = aws.String(fmt.Sprintf("%s/0", shardID))
+		return resp, nil
+	}
+	for i := offset; i < len(records) && int32(i-offset) < aws.ToInt32(input.Limit); i++ {
+		resp.Records = append(resp.Records, types.Record{
+			Data:           []byte(records[i]),
+			PartitionKey:   aws.String("pk"),
+			SequenceNumber: aws.String(fmt.Sprint(i)),
+		})
+	}
+	resp.NextShardIterator = aws.String(fmt.Sprintf("%s/%d", shardID, offset+len(resp.Records)))
+	return resp, nil
+}
+
+func TestSampleStream(t *testing.T) {
+	var ctx = context.Background()
+	var sampler = &mockSampler{shards: map[string][]string{
+		"shardId-000000000000": {`{"a":1}`, `not json`, `{"b":"x"}`},
+		"shardId-000000000001": {`{"c":true}`, `{"d":null}`},
+	}}
+
+	// Records which aren't JSON objects are left out.
+	var samples, err = sampleStream(ctx, sampler, "test-stream", 10, nil, false)
+	require.NoError(t, err)
+	require.Equal(t, []json.RawMessage{
+		json.RawMessage(`{"a":1}`), json.RawMessage(`{"b":"x"}`), json.RawMessage(`{"c":true}`), json.RawMessage(`{"d":null}`),
+	}, samples)
+
+	// Unless they're parsed into an envelope.
+	samples, err = sampleStream(ctx, sampler, "test-stream", 2, nil, true)
+	require.NoError(t, err)
+	require.Equal(t, []json.RawMessage{json.RawMessage(`{"a":1}`), json.RawMessage(`{"_raw":"not json"}`)}, samples)
+
+	// Reading stops once enough records are sampled.
+	sampler.requests = 0
+	samples, err = sampleStream(ctx, sampler, "test-stream", 1, nil, false)
+	require.NoError(t, err)
+	require.Len(t, samples, 1)
+	require.Equal(t, 2, sampler.requests)
+}
+
+func TestInferSchema(t *testing.T) {
+	var samples = []json.RawMessage{
+		json.RawMessage(`{"id": 1, "name": "a", "tags": ["x"], "_meta": {"key": "a"}}`),
+		json.RawMessage(`{"id": 2.5, "name": null, "nested": {"n": 1}, "ok": true}`),
+	}
+	var schema, err = inferSchema(json.RawMessage(keyedDocumentSchema), samples)
+	require.NoError(t, err)
+
+	var parsed struct {
+		Properties map[string]json.RawMessage `json:"properties"`
+		Required   []string                   `json:"required"`
+	}
+	require.NoError(t, json.Unmarshal(schema, &parsed))
+	require.Equal(t, []string{"_meta"}, parsed.Required)
+	require.Contains(t, string(parsed.Properties["_meta"]), `"description"`)
+	require.JSONEq(t, `{"type":"number"}`, string(parsed.Properties["id"]))
+	require.JSONEq(t, `{"type":["null","string"]}`, string(parsed.Properties["name"]))
+	require.JSONEq(t, `{"type":"array"}`, string(parsed.Properties["tags"]))
+	require.JSONEq(t, `{"type":"object"}`, string(parsed.Properties["nested"]))
+	require.JSONEq(t, `{"type":"boolean"}`, string(parsed.Properties["ok"]))
+
+	// Without samples the base schema is left as it is.
+	schema, err = inferSchema(json.RawMessage(`{"type":"object"}`), nil)
+	require.NoError(t, err)
+	require.Equal(t, `{"type":"object"}`, string(schema))
+}
+
+func TestSchemaTracker(t *testing.T) {
+	var hook = logtest.NewGlobal()
+	defer hook.Reset()
+
+	var tracker, err = newSchemaTracker([]airbyte.ConfiguredStream{{
+		Stream: airbyte.Stream{Name: "test-stream", JSONSchema: json.RawMessage(`{"type":"object","properties":{"a":{"type":"integer"}}}`)},
+	}})
+	require.NoError(t, err)
+
+	// Records with only the fields of the schema aren't noticed, nor are other streams.
+	tracker.observe("test-stream", json.RawMessage(`{"a":1}`))
+	tracker.observe("other-stream", json.RawMessage(`{"z":1}`))
+	require.Empty(t, hook.AllEntries())
+
+	// Novel fields are noticed once.
+	tracker.observe("test-stream", json.RawMessage(`{"a":1,"c":2,"b":3}`))
+	tracker.observe("test-stream", json.RawMessage(`{"a":1,"b":4}`))
+	require.Len(t, hook.AllEntries(), 1)
+	var entry = hook.LastEntry()
+	require.Equal(t, "schema_change", entry.Data["event"])
+	require.Equal(t, "test-stream", entry.Data["stream"])
+	require.Equal(t, []string{"b", "c"}, entry.Data["fields"])
+
+	// Tracking stops once a stream has too many distinct fields.
+	hook.Reset()
+	var many = make(map[string]int)
+	for i := 0; i < maxTrackedFields; i++ {
+		many[fmt.Sprintf("f%d", i)] = i
+	}
+	var record, _ = json.Marshal(many)
+	tracker.observe("test-stream", record)
+	require.Len(t, hook.AllEntries(), 2)
+	tracker.observe("test-stream", json.RawMessage(`{"new":1}`))
+	require.Len(t, hook.AllEntries(), 2)
+
+	// A nil tracker does nothing.
+	var disabled *schemaTracker
+	disabled.observe("test-stream", json.RawMessage(`{"new":1}`))
+}
@@ -0,0 +1,12 @@
+package main
+
+// recordNamespace returns the namespace of records read from the source, which is the id of their
+// kinesis shard when `shardNamespace` is enabled, and otherwise empty. Shard ids are never reused,
+// so the records of the child shards of a resharded stream have namespaces of their own, distinct
+// from those of their parents.
+func recordNamespace(config *Config, source *recordSource) string {
+	if !config.ShardNamespace {
+		return ""
+	}
+	return source.shardID
+}
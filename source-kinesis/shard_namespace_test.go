@@ -0,0 +1,26 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordNamespace(t *testing.T) {
+	var parent = &recordSource{stream: "test-stream", shardID: "shardId-000000000000"}
+	var child = &recordSource{
+		stream:         "test-stream",
+		shardID:        "shardId-000000000002",
+		parentShardIDs: []string{"shardId-000000000000", "shardId-000000000001"},
+	}
+
+	// Records aren't namespaced unless the option is enabled.
+	var config = Config{}
+	require.Equal(t, "", recordNamespace(&config, parent))
+	require.Equal(t, "", recordNamespace(&config, child))
+
+	// Each shard is its own namespace, including the child shards of a resharded stream.
+	config.ShardNamespace = true
+	require.Equal(t, "shardId-000000000000", recordNamespace(&config, parent))
+	require.Equal(t, "shardId-000000000002", recordNamespace(&config, child))
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+)
+
+// Positions from which kinesis shards are read when the capture has no sequence number for them.
+const (
+	// Shards are read from their oldest retained record, which backfills the whole stream.
+	startingPositionTrimHorizon = "trim_horizon"
+	// Shards are read from their tip, capturing only records added after the capture starts.
+	startingPositionLatest = "latest"
+	// Shards are read from the first record added at or after `startingTimestamp`.
+	startingPositionAtTimestamp = "at_timestamp"
+)
+
+// startPosition is the configured starting position of a capture.
+type startPosition struct {
+	position string
+	// timestamp is the `startingTimestamp` of the `at_timestamp` position, or the time at which the
+	// capture started for the `latest` position.
+	timestamp time.Time
+}
+
+// newStartPosition returns the starting position for the given configuration, or nil if the position
+// is empty, in which case shards are read from their oldest retained record. The `startingTimestamp`
+// is an RFC3339 timestamp, which is required by the `at_timestamp` position and not allowed otherwise.
+func newStartPosition(position, timestamp string, now time.Time) (*startPosition, error) {
+	switch position {
+	case "":
+		if timestamp != "" {
+			return nil, fmt.Errorf("startingTimestamp requires a startingPosition of %q", startingPositionAtTimestamp)
+		}
+		return nil, nil
+	case startingPositionTrimHorizon, startingPositionLatest:
+		if timestamp != "" {
+			return nil, fmt.Errorf("startingTimestamp requires a startingPosition of %q", startingPositionAtTimestamp)
+		}
+		return &startPosition{position: position, timestamp: now}, nil
+	case startingPositionAtTimestamp:
+		if timestamp == "" {
+			return nil, fmt.Errorf("startingTimestamp is required when startingPosition is %q", startingPositionAtTimestamp)
+		}
+		var ts, err = time.Parse(time.RFC3339, timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("invalid startingTimestamp %q: must be an RFC3339 timestamp: %w", timestamp, err)
+		}
+		return &startPosition{position: position, timestamp: ts}, nil
+	default:
+		return nil, fmt.Errorf("invalid startingPosition %q", position)
+	}
+}
+
+// apply sets the iterator type of a request for a shard which has no sequence number. Shards which
+// are listed when the capture starts are read from the position itself. Others are child shards,
+// which are reached by reading their parents to the end or whose leases are taken later, and those
+// are read from the time at which the capture started with the `latest` position, so that the records
+// added since then aren't skipped.
+func (p *startPosition) apply(input *kinesis.GetShardIteratorInput, initial bool) {
+	var position = startingPositionTrimHorizon
+	if p != nil {
+		position = p.position
+	}
+	switch {
+	case position == startingPositionLatest && initial:
+		input.ShardIteratorType = types.ShardIteratorTypeLatest
+	case position == startingPositionLatest, position == startingPositionAtTimestamp:
+		var ts = p.timestamp
+		input.ShardIteratorType = types.ShardIteratorTypeAtTimestamp
+		input.Timestamp = &ts
+	default:
+		input.ShardIteratorType = types.ShardIteratorTypeTrimHorizon
+	}
+}
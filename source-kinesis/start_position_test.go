@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewStartPosition(t *testing.T) {
+	var now = time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC)
+
+	var start, err = newStartPosition("", "", now)
+	require.NoError(t, err)
+	require.Nil(t, start)
+
+	start, err = newStartPosition(startingPositionLatest, "", now)
+	require.NoError(t, err)
+	require.Equal(t, &startPosition{position: startingPositionLatest, timestamp: now}, start)
+
+	start, err = newStartPosition(startingPositionAtTimestamp, "2022-01-02T15:04:05Z", now)
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2022, 1, 2, 15, 4, 5, 0, time.UTC), start.timestamp)
+
+	for _, tc := range []struct {
+		position, timestamp string
+		err                 string
+	}{
+		{startingPositionAtTimestamp, "", "startingTimestamp is required"},
+		{startingPositionAtTimestamp, "yesterday", "must be an RFC3339 timestamp"},
+		{startingPositionAtTimestamp, "2022-01-02 15:04:05", "must be an RFC3339 timestamp"},
+		{startingPositionLatest, "2022-01-02T15:04:05Z", "startingTimestamp requires"},
+		{"", "2022-01-02T15:04:05Z", "startingTimestamp requires"},
+		{"earliest", "", "invalid startingPosition"},
+	} {
+		_, err = newStartPosition(tc.position, tc.timestamp, now)
+		require.Error(t, err, "position %q, timestamp %q", tc.position, tc.timestamp)
+		require.Contains(t, err.Error(), tc.err)
+	}
+}
+
+func TestStartPositionApply(t *testing.T) {
+	var now = time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC)
+	var ts = time.Date(2022, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	for _, tc := range []struct {
+		start     *startPosition
+		initial   bool
+		iterType  types.ShardIteratorType
+		timestamp *time.Time
+	}{
+		{nil, true, types.ShardIteratorTypeTrimHorizon, nil},
+		{nil, false, types.ShardIteratorTypeTrimHorizon, nil},
+		{&startPosition{position: startingPositionTrimHorizon, timestamp: now}, true, types.ShardIteratorTypeTrimHorizon, nil},
+		{&startPosition{position: startingPositionTrimHorizon, timestamp: now}, false, types.ShardIteratorTypeTrimHorizon, nil},
+		// Child shards are read from when the capture started, rather than their tip.
+		{&startPosition{position: startingPositionLatest, timestamp: now}, true, types.ShardIteratorTypeLatest, nil},
+		{&startPosition{position: startingPositionLatest, timestamp: now}, false, types.ShardIteratorTypeAtTimestamp, &now},
+		{&startPosition{position: startingPositionAtTimestamp, timestamp: ts}, true, types.ShardIteratorTypeAtTimestamp, &ts},
+		{&startPosition{position: startingPositionAtTimestamp, timestamp: ts}, false, types.ShardIteratorTypeAtTimestamp, &ts},
+	} {
+		var input kinesis.GetShardIteratorInput
+		tc.start.apply(&input, tc.initial)
+		require.Equal(t, tc.iterType, input.ShardIteratorType)
+		require.Equal(t, tc.timestamp, input.Timestamp)
+	}
+}
+
+func TestStartPositionShardIterator(t *testing.T) {
+	var server = new(expiredSequenceServer)
+	var reader, _ = newExpiredSequenceReader(t, server, "")
+	reader.lastSequenceID, reader.resuming = "", false
+	reader.parent.start = &startPosition{position: startingPositionLatest, timestamp: time.Now()}
+
+	// A shard without a sequence number is read from the starting position.
+	reader.initial = true
+	var iterator, err = reader.getShardIterator()
+	require.NoError(t, err)
+	require.Equal(t, "iterator-LATEST", iterator)
+
+	reader.initial = false
+	iterator, err = reader.getShardIterator()
+	require.NoError(t, err)
+	require.Equal(t, "iterator-AT_TIMESTAMP", iterator)
+
+	// The stored sequence number takes precedence.
+	reader.lastSequenceID = "49590338271490256608559692538361571095921575989136588898"
+	reader.resuming = true
+	iterator, err = reader.getShardIterator()
+	require.NoError(t, err)
+	require.Equal(t, "iterator-TRIM_HORIZON", iterator)
+	require.Equal(t, []string{"LATEST", "AT_TIMESTAMP", "AFTER_SEQUENCE_NUMBER", "TRIM_HORIZON"}, server.iteratorTypes)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/smithy-go"
+	log "github.com/sirupsen/logrus"
+)
+
+// describeStreamConcurrency bounds the number of concurrent DescribeStreamSummary requests made
+// during discovery. The API is limited to 20 requests per second for each account.
+const describeStreamConcurrency = 4
+
+// streamMetadataKeyword is the extension keyword of the discovered schema of each kinesis stream
+// which holds its metadata. It's an annotation which has no effect on validation.
+const streamMetadataKeyword = "x-kinesis-stream"
+
+type streamDescriber interface {
+	DescribeStreamSummary(context.Context, *kinesis.DescribeStreamSummaryInput, ...func(*kinesis.Options)) (*kinesis.DescribeStreamSummaryOutput, error)
+}
+
+// streamMetadata describes a kinesis stream, for the selection and auditing of discovered streams.
+type streamMetadata struct {
+	ARN                  string `json:"arn"`
+	EncryptionType       string `json:"encryptionType"`
+	KeyID                string `json:"keyId,omitempty"`
+	RetentionPeriodHours int64  `json:"retentionPeriodHours"`
+	OpenShardCount       int64  `json:"openShardCount"`
+}
+
+// describeStreams returns the metadata of each of the named streams. Metadata is optional, so
+// streams which can't be described are logged and left out rather than failing discovery. Once
+// a request is denied no more are made, since the credentials evidently lack permission for them.
+func describeStreams(ctx context.Context, client streamDescriber, names []string) map[string]*streamMetadata {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var mu sync.Mutex
+	var metadata = make(map[string]*streamMetadata)
+	var denied bool
+	var wg sync.WaitGroup
+	var sem = make(chan struct{}, describeStreamConcurrency)
+	for _, name := range names {
+		sem <- struct{}{}
+		mu.Lock()
+		var stop = denied
+		mu.Unlock()
+		if stop {
+			<-sem
+			break
+		}
+
+		wg.Add(1)
+		go func(name string) {
+			defer func() { <-sem; wg.Done() }()
+			var md, err = describeStream(ctx, client, name)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if isAccessDenied(err) {
+				if !denied {
+					log.WithField("error", err).Warn("not permitted to describe kinesis streams, so discovered streams won't include their metadata")
+					denied = true
+					cancel()
+				}
+			} else if err != nil && !denied {
+				log.WithFields(log.Fields{"stream": name, "error": err}).Warn("failed to describe kinesis stream, so its metadata won't be included")
+			} else if err == nil {
+				metadata[name] = md
+			}
+		}(name)
+	}
+	wg.Wait()
+	return metadata
+}
+
+func describeStream(ctx context.Context, client streamDescriber, name string) (*streamMetadata, error) {
+	var resp, err = client.DescribeStreamSummary(ctx, &kinesis.DescribeStreamSummaryInput{
+		StreamName: aws.String(name),
+	})
+	if err != nil {
+		return nil, err
+	}
+	var desc = resp.StreamDescriptionSummary
+	if desc == nil {
+		return nil, fmt.Errorf("missing StreamDescriptionSummary in response")
+	}
+	return &streamMetadata{
+		ARN:                  aws.ToString(desc.StreamARN),
+		EncryptionType:       string(desc.EncryptionType),
+		KeyID:                aws.ToString(desc.KeyId),
+		RetentionPeriodHours: int64(aws.ToInt32(desc.RetentionPeriodHours)),
+		OpenShardCount:       int64(aws.ToInt32(desc.OpenShardCount)),
+	}, nil
+}
+
+func isAccessDenied(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "AccessDeniedException"
+}
+
+// withStreamMetadata returns the JSON schema with the stream metadata added as an annotation.
+func withStreamMetadata(schema json.RawMessage, md *streamMetadata) (json.RawMessage, error) {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(schema, &doc); err != nil {
+		return nil, fmt.Errorf("parsing schema: %w", err)
+	}
+	doc[streamMetadataKeyword] = md
+	return json.Marshal(doc)
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+	"github.com/aws/smithy-go"
+	"github.com/stretchr/testify/require"
+)
+
+// mockDescriber describes any stream, except for those with errors, and tracks the number of calls
+// and the maximum number of concurrent calls made.
+type mockDescriber struct {
+	mu        sync.Mutex
+	calls     int
+	active    int
+	maxActive int
+	errs      map[string]error
+}
+
+func (m *mockDescriber) DescribeStreamSummary(_ context.Context, input *kinesis.DescribeStreamSummaryInput, _ ...func(*kinesis.Options)) (*kinesis.DescribeStreamSummaryOutput, error) {
+	m.mu.Lock()
+	m.calls++
+	m.active++
+	if m.active > m.maxActive {
+		m.maxActive = m.active
+	}
+	m.mu.Unlock()
+	defer func() {
+		m.mu.Lock()
+		m.active--
+		m.mu.Unlock()
+	}()
+
+	time.Sleep(time.Millisecond)
+	var name = aws.ToString(input.StreamName)
+	if err, ok := m.errs[name]; ok {
+		return nil, err
+	}
+	return &kinesis.DescribeStreamSummaryOutput{
+		StreamDescriptionSummary: &types.StreamDescriptionSummary{
+			StreamName:           input.StreamName,
+			StreamARN:            aws.String("arn:aws:kinesis:us-east-1:123456789012:stream/" + name),
+			EncryptionType:       types.EncryptionTypeKms,
+			KeyId:                aws.String("alias/aws/kinesis"),
+			RetentionPeriodHours: aws.Int32(24),
+			OpenShardCount:       aws.Int32(2),
+		},
+	}, nil
+}
+
+func TestDescribeStreams(t *testing.T) {
+	var ctx = context.Background()
+	var names []string
+	for i := 0; i < 20; i++ {
+		names = append(names, fmt.Sprintf("stream-%d", i))
+	}
+
+	// Streams which can't be described are left out.
+	var client = &mockDescriber{
+		errs: map[string]error{"stream-3": &types.ResourceNotFoundException{Message: aws.String("deleted")}},
+	}
+	var metadata = describeStreams(ctx, client, names)
+	require.Len(t, metadata, 19)
+	require.NotContains(t, metadata, "stream-3")
+	require.Equal(t, &streamMetadata{
+		ARN:                  "arn:aws:kinesis:us-east-1:123456789012:stream/stream-7",
+		EncryptionType:       "KMS",
+		KeyID:                "alias/aws/kinesis",
+		RetentionPeriodHours: 24,
+		OpenShardCount:       2,
+	}, metadata["stream-7"])
+	require.LessOrEqual(t, client.maxActive, describeStreamConcurrency)
+
+	// Once a request is denied, the remaining streams aren't described at all.
+	client = &mockDescriber{errs: make(map[string]error)}
+	for _, name := range names {
+		client.errs[name] = &smithy.GenericAPIError{Code: "AccessDeniedException", Message: "not authorized"}
+	}
+	require.Empty(t, describeStreams(ctx, client, names))
+	require.LessOrEqual(t, client.calls, describeStreamConcurrency)
+}
+
+func TestWithStreamMetadata(t *testing.T) {
+	var md = &streamMetadata{
+		ARN:                  "arn:aws:kinesis:us-east-1:123456789012:stream/foo",
+		EncryptionType:       "NONE",
+		RetentionPeriodHours: 168,
+		OpenShardCount:       4,
+	}
+	var schema, err = withStreamMetadata(json.RawMessage(keyedDocumentSchema), md)
+	require.NoError(t, err)
+
+	var doc map[string]interface{}
+	require.NoError(t, json.Unmarshal(schema, &doc))
+	require.Equal(t, "object", doc["type"])
+	require.Equal(t, []interface{}{"_meta"}, doc["required"])
+	require.Equal(t, map[string]interface{}{
+		"arn":                  "arn:aws:kinesis:us-east-1:123456789012:stream/foo",
+		"encryptionType":       "NONE",
+		"retentionPeriodHours": float64(168),
+		"openShardCount":       float64(4),
+	}, doc[streamMetadataKeyword])
+}
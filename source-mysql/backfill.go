@@ -6,6 +6,7 @@ import (
 	"strings"
 
 	"github.com/estuary/connectors/sqlcapture"
+	"github.com/go-mysql-org/go-mysql/mysql"
 	"github.com/sirupsen/logrus"
 )
 
@@ -39,8 +40,22 @@ func (db *mysqlDatabase) ScanTableChunk(ctx context.Context, info sqlcapture.Tab
 		columnTypes[name] = column.DataType
 	}
 
+	// Rows carry the binlog position from before they're read, which their values are at
+	// least as recent as.
+	var binlogPos mysql.Position
+	if db.config.Advanced.BinlogMetadata {
+		var pos, err = db.queryBinlogPosition()
+		if err != nil {
+			return nil, fmt.Errorf("error backfilling table %q: %w", table, err)
+		}
+		binlogPos = pos
+	}
+
 	// Build and execute a query to fetch the next `backfillChunkSize` rows from the database
-	var query = buildScanQuery(resumeKey == nil, keyColumns, schema, table)
+	var limit = newValueSizeLimit(&db.config.Advanced)
+	var columns = limit.selectList(info.ColumnNames, columnTypes, keyColumns)
+	var keyExprs = scanKeyExpressions(keyColumns, columnTypes, db.config.Advanced.StringKeyOrdering)
+	var query = buildScanQuery(resumeKey == nil, columns, keyExprs, schema, table)
 	logrus.WithFields(logrus.Fields{"query": query, "args": resumeKey}).Debug("executing query")
 	results, err := db.conn.Execute(query, resumeKey...)
 	if err != nil {
@@ -55,7 +70,11 @@ func (db *mysqlDatabase) ScanTableChunk(ctx context.Context, info sqlcapture.Tab
 		for idx, val := range row {
 			fields[string(results.Fields[idx].Name)] = val.Value()
 		}
-		if err := translateRecordFields(columnTypes, fields); err != nil {
+		if err := translateRecordFields(columnTypes, newValueFormats(&db.config.Advanced), fields); err != nil {
+			return nil, fmt.Errorf("error backfilling table %q: %w", table, err)
+		}
+		truncated, err := limit.apply(columnTypes, keyColumns, fields)
+		if err != nil {
 			return nil, fmt.Errorf("error backfilling table %q: %w", table, err)
 		}
 
@@ -69,6 +88,9 @@ func (db *mysqlDatabase) ScanTableChunk(ctx context.Context, info sqlcapture.Tab
 					Snapshot: true,
 					Table:    table,
 				},
+				Truncated:  truncated,
+				BinlogFile: binlogPos.Name,
+				BinlogPos:  binlogPos.Pos,
 			},
 			Before: nil,
 			After:  fields,
@@ -82,21 +104,58 @@ func (db *mysqlDatabase) ScanTableChunk(ctx context.Context, info sqlcapture.Tab
 // so that it can be lowered in tests to exercise chunking behavior more easily.
 var backfillChunkSize = 4096
 
-func buildScanQuery(start bool, keyColumns []string, schemaName, tableName string) string {
+// Orderings of the string key columns of backfilled tables.
+const (
+	// Rows are ordered by the UTF-8 bytes of their string keys, which is how the keys of
+	// captured rows are compared to find the replicated changes of rows already backfilled.
+	stringKeyOrderingBinary = "binary"
+	// Rows are ordered by the collations of their string key columns, which lets queries use
+	// the index of the key but is only correct if the keys sort the same way by their bytes.
+	stringKeyOrderingCollation = "collation"
+)
+
+// characterTypes are the data types of columns whose values are compared according to
+// the collation of the column.
+var characterTypes = map[string]bool{
+	"char":       true,
+	"varchar":    true,
+	"tinytext":   true,
+	"text":       true,
+	"mediumtext": true,
+	"longtext":   true,
+}
+
+// scanKeyExpressions returns the expressions by which backfill queries order and page through
+// a table with the given key columns. With the binary ordering, the values of string columns
+// are compared as their UTF-8 bytes, so that a collation which ignores case or accents can't
+// order rows differently than their keys are compared by the capture.
+func scanKeyExpressions(keyColumns []string, columnTypes map[string]string, ordering string) []string {
+	var exprs = make([]string, len(keyColumns))
+	for idx, colName := range keyColumns {
+		if ordering != stringKeyOrderingCollation && characterTypes[columnTypes[colName]] {
+			exprs[idx] = fmt.Sprintf("CAST(CONVERT(%s USING utf8mb4) AS BINARY)", colName)
+		} else {
+			exprs[idx] = colName
+		}
+	}
+	return exprs
+}
+
+func buildScanQuery(start bool, columns string, keyExprs []string, schemaName, tableName string) string {
 	// Construct strings like `(foo, bar, baz)` and `(?, ?, ?)` for use in the query
 	var pkey, args string
-	for idx, colName := range keyColumns {
+	for idx, expr := range keyExprs {
 		if idx > 0 {
 			pkey += ", "
 			args += ", "
 		}
-		pkey += colName
+		pkey += expr
 		args += "?"
 	}
 
 	// Construct the query itself
 	var query = new(strings.Builder)
-	fmt.Fprintf(query, "SELECT * FROM %s.%s", schemaName, tableName)
+	fmt.Fprintf(query, "SELECT %s FROM %s.%s", columns, schemaName, tableName)
 	if !start {
 		fmt.Fprintf(query, " WHERE (%s) > (%s)", pkey, args)
 	}
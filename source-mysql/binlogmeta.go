@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/go-mysql-org/go-mysql/client"
+	"github.com/go-mysql-org/go-mysql/mysql"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+)
+
+// formatGTID returns the GTID of a transaction in the usual `<source_id>:<transaction_id>`
+// form, or the empty string if the transaction is anonymous because the server isn't in
+// GTID mode.
+func formatGTID(sid []byte, gno int64) string {
+	var u, err = uuid.FromBytes(sid)
+	if err != nil || u == uuid.Nil {
+		return ""
+	}
+	return fmt.Sprintf("%s:%d", u, gno)
+}
+
+// checkGTIDMode verifies that the server is in GTID mode, so that every transaction in
+// the binlog has a GTID.
+func checkGTIDMode(conn *client.Conn) error {
+	var results, err = conn.Execute("SELECT @@GLOBAL.gtid_mode;")
+	if err != nil {
+		return fmt.Errorf("error querying GTID mode: %w", err)
+	}
+	defer results.Close()
+	if len(results.Values) == 0 {
+		return fmt.Errorf("no results from GTID mode query")
+	}
+	if mode := string(results.Values[0][0].AsString()); !strings.EqualFold(mode, "ON") {
+		return fmt.Errorf("the 'gtid_cursor' option requires GTID mode but the server's gtid_mode is %q: set gtid_mode=ON or disable the option", mode)
+	}
+	return nil
+}
+
+// isGTIDCursor returns true if a cursor is a GTID set rather than a binlog position in
+// `<logfile>:<position>` form. The two are told apart by the part before the first colon,
+// which is the UUID of a server in a GTID set and is never a UUID in a binlog position.
+func isGTIDCursor(cursor string) bool {
+	var _, err = uuid.Parse(strings.SplitN(cursor, ":", 2)[0])
+	return err == nil
+}
+
+// flushCursor returns the cursor of a commit at the given binlog position. When resuming
+// by GTIDs this is the set of executed GTIDs, so that replication can resume from it even
+// if the server fails over to another with different binlog coordinates. The set isn't
+// known after resuming from a binlog position until the server begins writing the next
+// file, so until then the binlog position is used instead.
+func (rs *mysqlReplicationStream) flushCursor(cursor mysql.Position) string {
+	if rs.gtidCursor && rs.executedGTIDs != nil && rs.executedGTIDs.String() != "" {
+		return rs.executedGTIDs.String()
+	}
+	return fmt.Sprintf("%s:%d", cursor.Name, cursor.Pos)
+}
+
+// queryBinlogPosition returns the current position of the server's binlog.
+func (db *mysqlDatabase) queryBinlogPosition() (mysql.Position, error) {
+	var results, err = db.conn.Execute("SHOW MASTER STATUS;")
+	if err != nil {
+		return mysql.Position{}, fmt.Errorf("error getting latest binlog position: %w", err)
+	}
+	defer results.Close()
+	if len(results.Values) == 0 {
+		return mysql.Position{}, fmt.Errorf("failed to query latest binlog position (is binary logging enabled?)")
+	}
+	var row = results.Values[0]
+	return mysql.Position{
+		Name: string(row[0].AsString()),
+		Pos:  uint32(row[1].AsInt64()),
+	}, nil
+}
+
+// binlogPosition describes the position of the replication cursor as of a checkpoint, so
+// that operators can tell from the capture state where in the binlog it is.
+type binlogPosition struct {
+	BinlogFile string `json:"binlog_file"`
+	BinlogPos  uint32 `json:"binlog_pos"`
+	// LastGTID is the GTID of the last transaction, unless the server isn't in GTID mode.
+	LastGTID string `json:"last_gtid,omitempty"`
+	// ExecutedGTIDs is the set of all transactions up to the position, in the form of the
+	// `gtid_executed` variable. It's unknown after resuming from the middle of a binlog file
+	// until the server begins writing the next one.
+	ExecutedGTIDs string `json:"gtid_executed,omitempty"`
+}
+
+// observePreviousGTIDs adds the GTIDs which precede the current binlog file to the executed
+// GTID set. The server writes them at the start of each file, so the set becomes known once
+// replication reaches the start of a file.
+func (rs *mysqlReplicationStream) observePreviousGTIDs(gtids string) error {
+	if gtids == "" {
+		return nil
+	}
+	if rs.executedGTIDs == nil {
+		var gtidSet, err = mysql.ParseMysqlGTIDSet(gtids)
+		if err != nil {
+			return fmt.Errorf("error parsing previous GTIDs %q: %w", gtids, err)
+		}
+		rs.executedGTIDs = gtidSet
+		return nil
+	}
+	if err := rs.executedGTIDs.Update(gtids); err != nil {
+		return fmt.Errorf("error updating executed GTID set: %w", err)
+	}
+	return nil
+}
+
+// position describes the given binlog position along with the GTIDs of the transactions up
+// to it, as of the current event.
+func (rs *mysqlReplicationStream) position(cursor mysql.Position) json.RawMessage {
+	var position = binlogPosition{
+		BinlogFile: cursor.Name,
+		BinlogPos:  cursor.Pos,
+		LastGTID:   rs.gtid,
+	}
+	if rs.executedGTIDs != nil {
+		position.ExecutedGTIDs = rs.executedGTIDs.String()
+	}
+	var bs, err = json.Marshal(position)
+	if err != nil {
+		// Describing the position is only for observability, so it shouldn't fail the capture.
+		logrus.WithField("err", err).Warn("error encoding binlog position")
+		return nil
+	}
+	return bs
+}
@@ -2,11 +2,47 @@ package main
 
 import (
 	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math"
 	"testing"
 
+	"github.com/estuary/connectors/sqlcapture"
 	"github.com/estuary/connectors/sqlcapture/tests"
+	"github.com/go-mysql-org/go-mysql/client"
+	"github.com/stretchr/testify/require"
 )
 
+// timestampType is the discovered schema of a nullable TIMESTAMP column. This assumes
+// the test database runs in UTC, as the docker-compose setup does.
+const timestampType = `{"type":["string","null"],"description":"TIMESTAMP value normalized to UTC (server time zone: UTC)"}`
+
+// The discovered schemas of nullable spatial columns in each format.
+const (
+	wktType     = `{"type":["string","null"],"description":"Spatial value as Well-Known Text (WKT) with an 'SRID=<n>;' prefix if the SRID is nonzero"}`
+	geoJSONType = `{"type":["string","null"],"description":"Spatial value as GeoJSON with a named 'crs' member if the SRID is nonzero","contentMediaType":"application/geo+json"}`
+)
+
+// WKB encodings of POINT(1 2) and POLYGON((0 0,10 0,10 10,0 10,0 0)).
+const (
+	pointWKB   = "0101000000000000000000f03f0000000000000040"
+	polygonWKB = "010300000001000000050000000000000000000000000000000000000000000000000024400000000000000000000000000000244000000000000024400000000000000000000000000000244000000000000000000000000000000000"
+)
+
+// spatialValue returns a spatial value in the MySQL internal format, which is the
+// little-endian SRID followed by the WKB encoding of the geometry.
+func spatialValue(srid uint32, wkbHex string) []byte {
+	var wkb, err = hex.DecodeString(wkbHex)
+	if err != nil {
+		panic(err)
+	}
+	var val = make([]byte, 4, 4+len(wkb))
+	binary.LittleEndian.PutUint32(val, srid)
+	return append(val, wkb...)
+}
+
 // TestDatatypes runs the discovery test on various datatypes.
 func TestDatatypes(t *testing.T) {
 	var ctx = context.Background()
@@ -24,7 +60,13 @@ func TestDatatypes(t *testing.T) {
 		{ColumnType: "int", ExpectType: `{"type":["integer","null"]}`, InputValue: 123, ExpectValue: `123`},
 		{ColumnType: "bigint", ExpectType: `{"type":["integer","null"]}`, InputValue: -1234567890123456789, ExpectValue: `-1234567890123456789`},
 
-		// MySQL "boolean" type is a synonym for tinyint(1)
+		// BIGINT UNSIGNED values beyond the signed 64-bit range are captured in full
+		{ColumnType: "bigint unsigned", ExpectType: `{"type":["integer","null"]}`, InputValue: uint64(math.MaxUint64), ExpectValue: `18446744073709551615`},
+		{ColumnType: "bigint unsigned", ExpectType: `{"type":["integer","null"]}`, InputValue: uint64(math.MaxInt64) + 1, ExpectValue: `9223372036854775808`},
+		{ColumnType: "bigint(20) unsigned not null", ExpectType: `{"type":"integer"}`, InputValue: 123, ExpectValue: `123`},
+		{ColumnType: "bigint unsigned", ExpectType: `{"type":["integer","null"]}`, InputValue: nil, ExpectValue: `null`},
+
+		// MySQL "boolean" type is a synonym for tinyint(1), and is captured as an integer by default
 		{ColumnType: "boolean", ExpectType: `{"type":["integer","null"]}`, InputValue: 0, ExpectValue: `0`},
 		{ColumnType: "boolean", ExpectType: `{"type":["integer","null"]}`, InputValue: 1, ExpectValue: `1`},
 		{ColumnType: "boolean", ExpectType: `{"type":["integer","null"]}`, InputValue: true, ExpectValue: `1`},
@@ -57,6 +99,12 @@ func TestDatatypes(t *testing.T) {
 		{ColumnType: "mediumtext", ExpectType: `{"type":["string","null"]}`, InputValue: "foo", ExpectValue: `"foo"`},
 		{ColumnType: "longtext", ExpectType: `{"type":["string","null"]}`, InputValue: "foo", ExpectValue: `"foo"`},
 
+		// Characters outside the Basic Multilingual Plane take four bytes in UTF-8, and so
+		// can only be stored in utf8mb4 columns. See TestDatatypesUTF8MB3 for the rest.
+		{ColumnType: "varchar(32) character set utf8mb4", ExpectType: `{"type":["string","null"]}`, InputValue: "ok 😀 ok", ExpectValue: `"ok 😀 ok"`},
+		{ColumnType: "text character set utf8mb4", ExpectType: `{"type":["string","null"]}`, InputValue: "ok 😀 ok", ExpectValue: `"ok 😀 ok"`},
+		{ColumnType: "varchar(32) character set utf8mb3", ExpectType: `{"type":["string","null"]}`, InputValue: "ok ☃ ok", ExpectValue: `"ok ☃ ok"`},
+
 		// TODO(wgd): The BINARY(n) type has a mild inconsistency in its treatment of trailing null bytes
 		// between backfill and replication.
 		{ColumnType: "binary(5)", ExpectType: `{"type":["string","null"],"contentEncoding":"base64"}`, InputValue: []byte{0x12, 0x34, 0x56, 0x78, 0x9A}, ExpectValue: `"EjRWeJo="`},
@@ -66,24 +114,154 @@ func TestDatatypes(t *testing.T) {
 		{ColumnType: "mediumblob", ExpectType: `{"type":["string","null"],"contentEncoding":"base64"}`, InputValue: []byte{0x12, 0x34, 0x56, 0x78}, ExpectValue: `"EjRWeA=="`},
 		{ColumnType: "longblob", ExpectType: `{"type":["string","null"],"contentEncoding":"base64"}`, InputValue: []byte{0x12, 0x34, 0x56, 0x78}, ExpectValue: `"EjRWeA=="`},
 
-		// TODO(wgd): Enums are reported differently in backfills vs replication. Backfill queries return
-		// the string value of the column, while replicated change events appear to hold an integer index.
-		// {ColumnType: "enum('small', 'medium', 'large')", ExpectType: `{"type":["string","null"]}`, InputValue: "medium", ExpectValue: `"medium"`},
-
-		// TODO(wgd): Sets are reported differently in backfills vs replication. Backfill queries return
-		// the string value of the column, while replicated change events appear to hold a bitfield integer.
-		// {ColumnType: "SET('one', 'two')", ExpectType: `{"type":["string","null"]}`, InputValue: "one,two", ExpectValue: `"one,two"`},
+		{ColumnType: "enum('small', 'medium', 'large')", ExpectType: `{"type":["string","null"]}`, InputValue: "medium", ExpectValue: `"medium"`},
+		{ColumnType: "enum('it''s', 'a \\'quote\\'')", ExpectType: `{"type":["string","null"]}`, InputValue: "a 'quote'", ExpectValue: `"a 'quote'"`},
+		{ColumnType: "SET('one', 'two')", ExpectType: `{"type":["string","null"]}`, InputValue: "one,two", ExpectValue: `"one,two"`},
+		{ColumnType: "SET('one', 'two')", ExpectType: `{"type":["string","null"]}`, InputValue: "two", ExpectValue: `"two"`},
+		{ColumnType: "SET('one', 'two')", ExpectType: `{"type":["string","null"]}`, InputValue: "", ExpectValue: `""`},
 
 		{ColumnType: "date", ExpectType: `{"type":["string","null"]}`, InputValue: "1991-08-31", ExpectValue: `"1991-08-31"`},
 		{ColumnType: "datetime", ExpectType: `{"type":["string","null"]}`, InputValue: "1991-08-31 12:34:56", ExpectValue: `"1991-08-31 12:34:56"`},
 		{ColumnType: "datetime", ExpectType: `{"type":["string","null"]}`, InputValue: "1991-08-31 12:34:56.987654", ExpectValue: `"1991-08-31 12:34:57"`},
-		// TODO(wgd): Timestamps are reported differently in backfills vs replication because backfill
-		// queries do time-zone conversion while the replicated events appear to be un-converted.
-		// {ColumnType: "timestamp", ExpectType: `{"type":["string","null"]}`, InputValue: "1991-08-31 12:34:56", ExpectValue: `"1991-08-31 12:34:56"`},
-		// {ColumnType: "timestamp", ExpectType: `{"type":["string","null"]}`, InputValue: "1991-08-31 12:34:56.987654", ExpectValue: `"1991-08-31 12:34:56.987654"`},
+		{ColumnType: "timestamp", ExpectType: timestampType, InputValue: "1991-08-31 12:34:56", ExpectValue: `"1991-08-31T12:34:56Z"`},
+		{ColumnType: "timestamp", ExpectType: timestampType, InputValue: "1991-08-31 12:34:56.987654", ExpectValue: `"1991-08-31T12:34:57Z"`},
+		{ColumnType: "timestamp(6)", ExpectType: timestampType, InputValue: "1991-08-31 12:34:56.987654", ExpectValue: `"1991-08-31T12:34:56.987654Z"`},
 		{ColumnType: "time", ExpectType: `{"type":["string","null"]}`, InputValue: "765:43:21", ExpectValue: `"765:43:21"`},
 		{ColumnType: "year", ExpectType: `{"type":["integer","null"]}`, InputValue: "2003", ExpectValue: `2003`},
 
 		{ColumnType: "json", ExpectType: `{}`, InputValue: `{"type": "test", "data": 123}`, ExpectValue: `{"data":123,"type":"test"}`},
+
+		{ColumnType: "point", ExpectType: wktType, InputValue: spatialValue(0, pointWKB), ExpectValue: `"POINT(1 2)"`},
+		{ColumnType: "point srid 3857", ExpectType: wktType, InputValue: spatialValue(3857, pointWKB), ExpectValue: `"SRID=3857;POINT(1 2)"`},
+		{ColumnType: "polygon", ExpectType: wktType, InputValue: spatialValue(0, polygonWKB), ExpectValue: `"POLYGON((0 0,10 0,10 10,0 10,0 0))"`},
+		{ColumnType: "geometry", ExpectType: wktType, InputValue: spatialValue(0, polygonWKB), ExpectValue: `"POLYGON((0 0,10 0,10 10,0 10,0 0))"`},
+	})
+}
+
+// TestDatatypesUTF8MB3 checks what is captured when 4-byte characters are written to a
+// utf8mb3 column. In strict mode (the default) the server rejects them, and otherwise it
+// replaces them before they're stored. Either way the capture sees what the server stored,
+// and the other columns of the row are captured intact.
+func TestDatatypesUTF8MB3(t *testing.T) {
+	var tb, ctx = TestBackend, context.Background()
+	var table = tb.CreateTable(ctx, t, "", "(id INTEGER PRIMARY KEY, narrow VARCHAR(32) CHARACTER SET utf8mb3, wide VARCHAR(32) CHARACTER SET utf8mb4)")
+	var catalog = tests.ConfiguredCatalog(ctx, t, tb, table)
+
+	var insertQuery = fmt.Sprintf("INSERT INTO %s VALUES (?, ?, ?)", table)
+	var _, err = tb.conn.Execute(insertQuery, 0, "a😀b", "c😀d")
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "Incorrect string value")
+
+	// A separate connection in non-strict mode is used to insert the rows, so that
+	// the session of the shared test connection is unaffected.
+	conn, err := client.Connect(tb.cfg.Address, tb.cfg.User, tb.cfg.Password, tb.cfg.Advanced.DBName)
+	require.NoError(t, err)
+	defer conn.Close()
+	require.NoError(t, conn.SetCharset("utf8mb4"))
+	_, err = conn.Execute("SET SESSION sql_mode = ''")
+	require.NoError(t, err)
+
+	// The value stored in place of the 4-byte character, which is reported in a warning.
+	var storedValue = func(id int) string {
+		var result, err = conn.Execute(fmt.Sprintf("SELECT narrow FROM %s WHERE id = ?", table), id)
+		require.NoError(t, err)
+		defer result.Close()
+		require.Len(t, result.Values, 1)
+		var bs, _ = json.Marshal(string(result.Values[0][0].AsString()))
+		return string(bs)
+	}
+
+	var state = sqlcapture.PersistentState{}
+	_, err = conn.Execute(insertQuery, 1, "a😀b", "c😀d")
+	require.NoError(t, err)
+	var output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.NotContains(t, storedValue(1), "😀")
+	require.Contains(t, output, `"id":1,"narrow":`+storedValue(1)+`,"wide":"c😀d"`)
+
+	_, err = conn.Execute(insertQuery, 2, "a😀b", "c😀d")
+	require.NoError(t, err)
+	output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.Contains(t, output, `"id":2,"narrow":`+storedValue(2)+`,"wide":"c😀d"`)
+}
+
+// TestDatatypesGeoJSON runs the discovery test on spatial columns with the
+// 'spatial_format' option set to capture them as GeoJSON.
+func TestDatatypesGeoJSON(t *testing.T) {
+	var tb, ctx = &mysqlTestBackend{conn: TestBackend.conn, cfg: TestBackend.cfg}, context.Background()
+	tb.cfg.Advanced.SpatialFormat = spatialFormatGeoJSON
+	tests.TestDatatypes(ctx, t, tb, []tests.DatatypeTestCase{
+		{ColumnType: "point", ExpectType: geoJSONType, InputValue: spatialValue(0, pointWKB), ExpectValue: `"{\"coordinates\":[1,2],\"type\":\"Point\"}"`},
+		{ColumnType: "point srid 3857", ExpectType: geoJSONType, InputValue: spatialValue(3857, pointWKB), ExpectValue: `"{\"coordinates\":[1,2],\"crs\":{\"properties\":{\"name\":\"EPSG:3857\"},\"type\":\"name\"},\"type\":\"Point\"}"`},
+		{ColumnType: "polygon", ExpectType: geoJSONType, InputValue: spatialValue(0, polygonWKB), ExpectValue: `"{\"coordinates\":[[[0,0],[10,0],[10,10],[0,10],[0,0]]],\"type\":\"Polygon\"}"`},
+		{ColumnType: "point", ExpectType: geoJSONType, InputValue: nil, ExpectValue: `null`},
+	})
+}
+
+// unsignedBigintStringType is the discovered schema of a nullable BIGINT UNSIGNED
+// column when the 'unsigned_bigint_format' option is 'string'.
+const unsignedBigintStringType = `{"type":["string","null"],"description":"BIGINT UNSIGNED value as a decimal string"}`
+
+// TestDatatypesUnsignedBigintString runs the discovery test on BIGINT UNSIGNED columns
+// with the 'unsigned_bigint_format' option set to capture them as strings.
+func TestDatatypesUnsignedBigintString(t *testing.T) {
+	var tb, ctx = &mysqlTestBackend{conn: TestBackend.conn, cfg: TestBackend.cfg}, context.Background()
+	tb.cfg.Advanced.UnsignedBigintFormat = unsignedBigintFormatString
+	tests.TestDatatypes(ctx, t, tb, []tests.DatatypeTestCase{
+		{ColumnType: "bigint unsigned", ExpectType: unsignedBigintStringType, InputValue: uint64(math.MaxUint64), ExpectValue: `"18446744073709551615"`},
+		{ColumnType: "bigint unsigned", ExpectType: unsignedBigintStringType, InputValue: uint64(math.MaxUint64) - 1, ExpectValue: `"18446744073709551614"`},
+		{ColumnType: "bigint unsigned", ExpectType: unsignedBigintStringType, InputValue: 0, ExpectValue: `"0"`},
+		{ColumnType: "bigint unsigned", ExpectType: unsignedBigintStringType, InputValue: nil, ExpectValue: `null`},
+		{ColumnType: "bigint unsigned not null", ExpectType: `{"type":"string","description":"BIGINT UNSIGNED value as a decimal string"}`, InputValue: 42, ExpectValue: `"42"`},
+
+		// Signed and narrower unsigned integers are unaffected
+		{ColumnType: "bigint", ExpectType: `{"type":["integer","null"]}`, InputValue: -1234567890123456789, ExpectValue: `-1234567890123456789`},
+		{ColumnType: "int unsigned", ExpectType: `{"type":["integer","null"]}`, InputValue: 4000000000, ExpectValue: `4000000000`},
+	})
+}
+
+// TestTranslateUnsignedBigint checks that BIGINT UNSIGNED values are translated the same
+// whether they're decoded as unsigned integers, as backfill queries do, or as signed
+// integers which have wrapped around, as replicated change events may.
+func TestTranslateUnsignedBigint(t *testing.T) {
+	for _, format := range []string{unsignedBigintFormatInteger, unsignedBigintFormatString} {
+		var formats = valueFormats{unsignedBigint: format}
+		for _, val := range []interface{}{uint64(math.MaxUint64), int64(-1), "18446744073709551615"} {
+			var translated, err = translateRecordField(unsignedBigintDataType, formats, val)
+			require.NoError(t, err)
+			bs, err := json.Marshal(translated)
+			require.NoError(t, err)
+			if format == unsignedBigintFormatString {
+				require.Equal(t, `"18446744073709551615"`, string(bs))
+			} else {
+				require.Equal(t, `18446744073709551615`, string(bs))
+			}
+		}
+	}
+
+	// Row keys of string values are still encoded as integers, so that they're ordered numerically.
+	var key, err = (&mysqlDatabase{}).EncodeKeyFDB(unsignedBigintString(10))
+	require.NoError(t, err)
+	require.Equal(t, uint64(10), key)
+}
+
+// TestDatatypesTinyintAsBoolean runs the discovery test on TINYINT columns with the
+// 'tinyint1_as_bool' option enabled, so that only TINYINT(1) is captured as a boolean.
+func TestDatatypesTinyintAsBoolean(t *testing.T) {
+	var tb, ctx = &mysqlTestBackend{conn: TestBackend.conn, cfg: TestBackend.cfg}, context.Background()
+	tb.cfg.Advanced.TinyintAsBoolean = true
+	tests.TestDatatypes(ctx, t, tb, []tests.DatatypeTestCase{
+		{ColumnType: "boolean", ExpectType: `{"type":["boolean","null"]}`, InputValue: 0, ExpectValue: `false`},
+		{ColumnType: "boolean", ExpectType: `{"type":["boolean","null"]}`, InputValue: 1, ExpectValue: `true`},
+		{ColumnType: "boolean", ExpectType: `{"type":["boolean","null"]}`, InputValue: true, ExpectValue: `true`},
+		{ColumnType: "boolean", ExpectType: `{"type":["boolean","null"]}`, InputValue: false, ExpectValue: `false`},
+		{ColumnType: "boolean", ExpectType: `{"type":["boolean","null"]}`, InputValue: nil, ExpectValue: `null`},
+		{ColumnType: "tinyint(1)", ExpectType: `{"type":["boolean","null"]}`, InputValue: 1, ExpectValue: `true`},
+		{ColumnType: "tinyint(1)", ExpectType: `{"type":["boolean","null"]}`, InputValue: 7, ExpectValue: `true`},
+		{ColumnType: "tinyint(1) not null", ExpectType: `{"type":"boolean"}`, InputValue: 0, ExpectValue: `false`},
+
+		// Wider tinyints are still integers
+		{ColumnType: "tinyint", ExpectType: `{"type":["integer","null"]}`, InputValue: 123, ExpectValue: `123`},
+		{ColumnType: "tinyint(4)", ExpectType: `{"type":["integer","null"]}`, InputValue: 1, ExpectValue: `1`},
+		{ColumnType: "tinyint unsigned", ExpectType: `{"type":["integer","null"]}`, InputValue: 200, ExpectValue: `200`},
 	})
 }
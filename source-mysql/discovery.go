@@ -4,6 +4,8 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/alecthomas/jsonschema"
 	"github.com/estuary/connectors/sqlcapture"
@@ -14,7 +16,7 @@ import (
 func (db *mysqlDatabase) DiscoverTables(ctx context.Context) (map[string]sqlcapture.TableInfo, error) {
 	// Enumerate every column of every table, and then aggregate into a
 	// map from StreamID to TableInfo structs.
-	var columns, err = getColumns(ctx, db.conn)
+	var columns, err = getColumns(ctx, db.conn, db.config.Advanced.TinyintAsBoolean, db.config.Advanced.DiscoverSystemSchemas)
 	if err != nil {
 		return nil, fmt.Errorf("error discovering columns: %w", err)
 	}
@@ -76,14 +78,16 @@ func (db *mysqlDatabase) DiscoverTables(ctx context.Context) (map[string]sqlcapt
 	var _, watermarksPresent = tableMap[db.WatermarksTable()]
 	if len(tableMap) == 0 || len(tableMap) == 1 && watermarksPresent {
 		logrus.Warn("no tables discovered")
-		logrus.Warn("note that source-mysql will not discover tables in the system schemas 'information_schema', 'mysql', 'performance_schema', or 'sys'")
+		if !db.config.Advanced.DiscoverSystemSchemas {
+			logrus.Warn("note that source-mysql will not discover tables in the system schemas 'information_schema', 'mysql', 'performance_schema', or 'sys' unless 'discover_system_schemas' is set")
+		}
 	}
 
 	return tableMap, nil
 }
 
 func (db *mysqlDatabase) TranslateDBToJSONType(column sqlcapture.ColumnInfo) (*jsonschema.Type, error) {
-	var colSchema, ok = mysqlTypeToJSON[column.DataType]
+	var colSchema, ok = mysqlTypeToJSON[enumBaseType(column.DataType)]
 	if !ok {
 		return nil, fmt.Errorf("unhandled MySQL type %q", column.DataType)
 	}
@@ -93,10 +97,56 @@ func (db *mysqlDatabase) TranslateDBToJSONType(column sqlcapture.ColumnInfo) (*j
 	if column.Description != nil {
 		colSchema.description = *column.Description
 	}
+
+	// TIMESTAMP values are normalized to UTC, so note that along with the server's own
+	// time zone for the benefit of anyone wondering why the values look different.
+	if column.DataType == "timestamp" {
+		var note = fmt.Sprintf("TIMESTAMP value normalized to UTC (server time zone: %s)", db.serverTimezone)
+		if colSchema.description != "" {
+			note = colSchema.description + " " + note
+		}
+		colSchema.description = note
+	}
+
+	// BIGINT UNSIGNED values may be captured as strings, which would otherwise look
+	// like any other string column.
+	if column.DataType == unsignedBigintDataType && db.config.Advanced.UnsignedBigintFormat == unsignedBigintFormatString {
+		var note = "BIGINT UNSIGNED value as a decimal string"
+		if colSchema.description != "" {
+			note = colSchema.description + " " + note
+		}
+		colSchema.type_ = "string"
+		colSchema.description = note
+	}
+
+	// Spatial values are captured as strings in the configured format, which isn't
+	// evident from the type alone, so describe it.
+	if spatialDataTypes[column.DataType] {
+		var note = "Spatial value as Well-Known Text (WKT) with an 'SRID=<n>;' prefix if the SRID is nonzero"
+		if db.config.Advanced.SpatialFormat == spatialFormatGeoJSON {
+			note = "Spatial value as GeoJSON with a named 'crs' member if the SRID is nonzero"
+			colSchema.contentMediaType = "application/geo+json"
+		}
+		if colSchema.description != "" {
+			note = colSchema.description + " " + note
+		}
+		colSchema.description = note
+	}
 	return colSchema.toType(), nil
 }
 
-func translateRecordFields(columnTypes map[string]string, f map[string]interface{}) error {
+// valueFormats holds the configured formats in which the values of some column types
+// are captured.
+type valueFormats struct {
+	spatial        string
+	unsignedBigint string
+}
+
+func newValueFormats(cfg *advancedConfig) valueFormats {
+	return valueFormats{spatial: cfg.SpatialFormat, unsignedBigint: cfg.UnsignedBigintFormat}
+}
+
+func translateRecordFields(columnTypes map[string]string, formats valueFormats, f map[string]interface{}) error {
 	if columnTypes == nil {
 		return fmt.Errorf("unknown column types")
 	}
@@ -104,7 +154,7 @@ func translateRecordFields(columnTypes map[string]string, f map[string]interface
 		return nil
 	}
 	for id, val := range f {
-		var translated, err = translateRecordField(columnTypes[id], val)
+		var translated, err = translateRecordField(columnTypes[id], formats, val)
 		if err != nil {
 			return fmt.Errorf("error translating field %q value %v: %w", id, val, err)
 		}
@@ -113,13 +163,27 @@ func translateRecordFields(columnTypes map[string]string, f map[string]interface
 	return nil
 }
 
-func translateRecordField(columnType string, val interface{}) (interface{}, error) {
+func translateRecordField(columnType string, formats valueFormats, val interface{}) (interface{}, error) {
 	if columnType == "" {
 		return nil, fmt.Errorf("unknown column type")
 	}
+	// Replicated TIMESTAMP values are decoded into a time type which formats
+	// itself like the string returned by a query.
+	if str, ok := val.(fmt.Stringer); ok && columnType == "timestamp" {
+		val = str.String()
+	}
 	if str, ok := val.(string); ok {
 		val = []byte(str)
 	}
+	if columnType == unsignedBigintDataType {
+		return translateUnsignedBigint(val, formats.unsignedBigint)
+	}
+	if base := enumBaseType(columnType); base == "enum" || base == "set" {
+		if bs, ok := val.([]byte); ok {
+			return string(bs), nil
+		}
+		return translateEnum(columnType, val)
+	}
 	switch val := val.(type) {
 	case []byte:
 		switch columnType {
@@ -135,22 +199,93 @@ func translateRecordField(columnType string, val interface{}) (interface{}, erro
 			return val, nil
 		case "json":
 			return json.RawMessage(val), nil
+		case "timestamp":
+			return normalizeTimestamp(string(val))
+		case "geometry", "point", "linestring", "polygon", "multipoint", "multilinestring", "multipolygon", "geometrycollection", "geomcollection":
+			return translateSpatial(val, formats.spatial)
 		default:
 			return string(val), nil
 		}
 	}
+	if columnType == booleanDataType {
+		return translateBoolean(val)
+	}
 	return val, nil
 }
 
+// translateBoolean converts the integer value of a TINYINT(1) column into a boolean.
+// Backfill queries and replicated change events decode the column as different integer
+// types, so all of them are accepted.
+func translateBoolean(val interface{}) (interface{}, error) {
+	switch val := val.(type) {
+	case nil:
+		return nil, nil
+	case bool:
+		return val, nil
+	case int8:
+		return val != 0, nil
+	case int16:
+		return val != 0, nil
+	case int32:
+		return val != 0, nil
+	case int64:
+		return val != 0, nil
+	case uint8:
+		return val != 0, nil
+	case uint64:
+		return val != 0, nil
+	}
+	return nil, fmt.Errorf("unexpected boolean value of type %T", val)
+}
+
+// mysqlTimestampLayout is the layout in which MySQL reports TIMESTAMP values. When
+// parsing, any fractional seconds following it are accepted as well.
+const mysqlTimestampLayout = "2006-01-02 15:04:05"
+
+// normalizeTimestamp converts a TIMESTAMP value, which must be expressed in UTC, into
+// an RFC3339 string. The "zero" value which MySQL uses for invalid timestamps has no
+// RFC3339 representation, so it's passed through unchanged.
+func normalizeTimestamp(str string) (interface{}, error) {
+	if strings.HasPrefix(str, "0000-00-00") {
+		return str, nil
+	}
+	var t, err = time.Parse(mysqlTimestampLayout, str)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing timestamp %q: %w", str, err)
+	}
+	return t.UTC().Format(time.RFC3339Nano), nil
+}
+
+// systemSchemas are the schemas which hold the tables of MySQL itself. They aren't
+// discovered unless the `discover_system_schemas` option is set.
+var systemSchemas = []string{"information_schema", "mysql", "performance_schema", "sys"}
+
 const queryDiscoverColumns = `
-  SELECT table_schema, table_name, ordinal_position, column_name, is_nullable, data_type
+  SELECT table_schema, table_name, ordinal_position, column_name, is_nullable, data_type, column_type, character_set_name
   FROM information_schema.columns
-  WHERE table_schema != 'information_schema' AND table_schema != 'performance_schema'
-    AND table_schema != 'mysql' AND table_schema != 'sys'
+  %s
   ORDER BY table_schema, table_name, ordinal_position;`
 
-func getColumns(ctx context.Context, conn *client.Conn) ([]sqlcapture.ColumnInfo, error) {
-	var results, err = conn.Execute(queryDiscoverColumns)
+// discoverColumnsQuery returns the query which lists every column of every table,
+// excluding those in system schemas unless includeSystemSchemas is set.
+func discoverColumnsQuery(includeSystemSchemas bool) string {
+	var filter string
+	if !includeSystemSchemas {
+		filter = "WHERE table_schema NOT IN ('" + strings.Join(systemSchemas, "', '") + "')"
+	}
+	return fmt.Sprintf(queryDiscoverColumns, filter)
+}
+
+// booleanDataType is the data type reported for TINYINT(1) columns when they're
+// captured as booleans. MySQL has no boolean type of its own, so this can't collide
+// with any real data type.
+const booleanDataType = "boolean"
+
+// getColumns queries the database for every column of every table. If tinyintAsBoolean
+// is set then TINYINT(1) columns are reported with the boolean data type. BIGINT UNSIGNED
+// columns are always reported with the unsigned bigint data type.
+func getColumns(ctx context.Context, conn *client.Conn, tinyintAsBoolean, includeSystemSchemas bool) ([]sqlcapture.ColumnInfo, error) {
+	var results, err = conn.Execute(discoverColumnsQuery(includeSystemSchemas))
 	if err != nil {
 		return nil, fmt.Errorf("error querying columns: %w", err)
 	}
@@ -158,18 +293,48 @@ func getColumns(ctx context.Context, conn *client.Conn) ([]sqlcapture.ColumnInfo
 
 	var columns []sqlcapture.ColumnInfo
 	for _, row := range results.Values {
+		var dataType = string(row[5].AsString())
+		if tinyintAsBoolean && isTinyintOne(string(row[6].AsString())) {
+			dataType = booleanDataType
+		} else if isUnsignedBigint(dataType, string(row[6].AsString())) {
+			dataType = unsignedBigintDataType
+		} else if dataType == "enum" || dataType == "set" {
+			// The full column type lists the members, which replication needs in order
+			// to translate the values of change events.
+			dataType = string(row[6].AsString())
+		}
+		if isUTF8MB3(string(row[7].AsString())) {
+			logrus.WithFields(logrus.Fields{
+				"table":  string(row[0].AsString()) + "." + string(row[1].AsString()),
+				"column": string(row[3].AsString()),
+			}).Warn("column uses the 3-byte 'utf8' character set which can't store characters such as emoji, consider converting it to utf8mb4")
+		}
 		columns = append(columns, sqlcapture.ColumnInfo{
 			TableSchema: string(row[0].AsString()),
 			TableName:   string(row[1].AsString()),
 			Index:       int(row[2].AsInt64()),
 			Name:        string(row[3].AsString()),
 			IsNullable:  string(row[4].AsString()) != "NO",
-			DataType:    string(row[5].AsString()),
+			DataType:    dataType,
 		})
 	}
 	return columns, err
 }
 
+// isUTF8MB3 returns true if a column character set is the 3-byte subset of UTF-8, which
+// MySQL calls 'utf8' or 'utf8mb3' depending on the version. Characters outside the Basic
+// Multilingual Plane (such as emoji) are either rejected or replaced when written to such
+// columns, so they never reach the capture.
+func isUTF8MB3(charset string) bool {
+	return charset == "utf8" || charset == "utf8mb3"
+}
+
+// isTinyintOne returns true if the full column type (such as "tinyint(1) unsigned")
+// is a TINYINT with a display width of one, which is how BOOLEAN columns are declared.
+func isTinyintOne(columnType string) bool {
+	return strings.HasPrefix(strings.ToLower(columnType), "tinyint(1)")
+}
+
 const queryDiscoverPrimaryKeys = `
 SELECT table_schema, table_name, column_name, seq_in_index
   FROM information_schema.statistics
@@ -206,11 +371,12 @@ func getPrimaryKeys(ctx context.Context, conn *client.Conn) (map[string][]string
 }
 
 type columnSchema struct {
-	contentEncoding string
-	description     string
-	format          string
-	nullable        bool
-	type_           string
+	contentEncoding  string
+	contentMediaType string
+	description      string
+	format           string
+	nullable         bool
+	type_            string
 }
 
 func (s columnSchema) toType() *jsonschema.Type {
@@ -223,6 +389,9 @@ func (s columnSchema) toType() *jsonschema.Type {
 	if s.contentEncoding != "" {
 		out.Extras["contentEncoding"] = s.contentEncoding // New in 2019-09.
 	}
+	if s.contentMediaType != "" {
+		out.Extras["contentMediaType"] = s.contentMediaType
+	}
 
 	if s.type_ == "" {
 		// No type constraint.
@@ -242,6 +411,12 @@ var mysqlTypeToJSON = map[string]columnSchema{
 	"bigint":    {type_: "integer"},
 	"bit":       {type_: "integer"},
 
+	// Not a real MySQL type, see unsignedBigintDataType.
+	unsignedBigintDataType: {type_: "integer"},
+
+	// Not a real MySQL type, see booleanDataType.
+	booleanDataType: {type_: "boolean"},
+
 	"float":   {type_: "number"},
 	"double":  {type_: "number"},
 	"decimal": {type_: "string"},
@@ -261,14 +436,26 @@ var mysqlTypeToJSON = map[string]columnSchema{
 	"mediumblob": {type_: "string", contentEncoding: "base64"},
 	"longblob":   {type_: "string", contentEncoding: "base64"},
 
-	// "enum": {type_: "string"}, // TODO(wgd): Enable after fixing translation for enum columns
-	// "set": {type_: "string"}, // TODO(wgd): Enable after fixing translation for set columns
+	// The data type of these columns is their full column type, see enumBaseType.
+	"enum": {type_: "string"},
+	"set":  {type_: "string"},
 
-	"date":     {type_: "string"},
-	"datetime": {type_: "string"},
-	// "timestamp": {type_: "string"}, // TODO(wgd): Enable after fixing timezone conversion inconsistencies
-	"time": {type_: "string"},
-	"year": {type_: "integer"},
+	"date":      {type_: "string"},
+	"datetime":  {type_: "string"},
+	"timestamp": {type_: "string"},
+	"time":      {type_: "string"},
+	"year":      {type_: "integer"},
 
 	"json": {},
+
+	// Spatial values are captured as WKT or GeoJSON strings, see translateSpatial.
+	"geometry":           {type_: "string"},
+	"point":              {type_: "string"},
+	"linestring":         {type_: "string"},
+	"polygon":            {type_: "string"},
+	"multipoint":         {type_: "string"},
+	"multilinestring":    {type_: "string"},
+	"multipolygon":       {type_: "string"},
+	"geometrycollection": {type_: "string"},
+	"geomcollection":     {type_: "string"},
 }
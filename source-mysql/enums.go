@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"vitess.io/vitess/go/vt/sqlparser"
+)
+
+// The data types of ENUM and SET columns are their full column types, such as
+// "enum('small','medium','large')", rather than just "enum" or "set". Backfill queries
+// return the string values of these columns, but replicated change events hold the index
+// of an ENUM member or a bitfield of SET members, which are translated back into strings
+// using the members listed in the type. Because the data type is persisted as part of the
+// metadata of a table, the members which replication uses are those of the table as of
+// the point in the binlog being replicated.
+
+// enumBaseType returns "enum" or "set" if the data type is the full column type of an
+// ENUM or SET column, and otherwise returns the data type unchanged.
+func enumBaseType(dataType string) string {
+	for _, base := range []string{"enum", "set"} {
+		if strings.HasPrefix(dataType, base+"(") {
+			return base
+		}
+	}
+	return dataType
+}
+
+// enumMembersCache holds the members of each ENUM and SET column type which has been
+// parsed, so that they needn't be parsed for every replicated row. A change to the members
+// of a column changes its type, so entries never need to be invalidated.
+var enumMembersCache sync.Map
+
+// enumMembers returns the members of an ENUM or SET column type, in order.
+func enumMembers(columnType string) ([]string, error) {
+	if members, ok := enumMembersCache.Load(columnType); ok {
+		return members.([]string), nil
+	}
+	var members, err = parseEnumMembers(columnType)
+	if err != nil {
+		return nil, err
+	}
+	enumMembersCache.Store(columnType, members)
+	return members, nil
+}
+
+// parseEnumMembers parses the quoted and comma-separated members of an ENUM or SET column
+// type. Quotes within a member are doubled, as they are in the column types reported by
+// `information_schema.columns`, though backslash escapes are accepted as well.
+func parseEnumMembers(columnType string) ([]string, error) {
+	var base = enumBaseType(columnType)
+	if base == columnType || !strings.HasSuffix(columnType, ")") {
+		return nil, fmt.Errorf("invalid ENUM or SET column type %q", columnType)
+	}
+	var rest = columnType[len(base)+1 : len(columnType)-1]
+
+	var members []string
+	for len(rest) > 0 {
+		if rest[0] != '\'' {
+			return nil, fmt.Errorf("invalid ENUM or SET column type %q: expected a quoted member", columnType)
+		}
+		var member strings.Builder
+		var idx = 1
+		for ; idx < len(rest); idx++ {
+			if rest[idx] == '\\' && idx+1 < len(rest) {
+				idx++
+				member.WriteByte(rest[idx])
+			} else if rest[idx] == '\'' && idx+1 < len(rest) && rest[idx+1] == '\'' {
+				idx++
+				member.WriteByte('\'')
+			} else if rest[idx] == '\'' {
+				break
+			} else {
+				member.WriteByte(rest[idx])
+			}
+		}
+		if idx == len(rest) {
+			return nil, fmt.Errorf("invalid ENUM or SET column type %q: unterminated member", columnType)
+		}
+		members = append(members, member.String())
+		rest = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(rest[idx+1:]), ","))
+	}
+	return members, nil
+}
+
+// formatEnumType returns the column type of an ENUM or SET column having the given members,
+// in the same form as `information_schema.columns` reports it.
+func formatEnumType(base string, members []string) string {
+	var quoted = make([]string, len(members))
+	for idx, member := range members {
+		quoted[idx] = "'" + strings.ReplaceAll(member, "'", "''") + "'"
+	}
+	return base + "(" + strings.Join(quoted, ",") + ")"
+}
+
+// translateEnum converts the value of an ENUM or SET column into its string value.
+// Backfill queries already return the string, while replicated change events hold the
+// 1-based index of an ENUM member or a bitfield of SET members. An index of zero is the
+// empty string which MySQL stores in place of an invalid ENUM value.
+func translateEnum(columnType string, val interface{}) (interface{}, error) {
+	var n, ok = val.(int64)
+	if !ok {
+		return val, nil
+	}
+	var members, err = enumMembers(columnType)
+	if err != nil {
+		return nil, err
+	}
+
+	if enumBaseType(columnType) == "enum" {
+		if n == 0 {
+			return "", nil
+		} else if n < 0 || n > int64(len(members)) {
+			return nil, fmt.Errorf("ENUM index %d is out of range for column type %q", n, columnType)
+		}
+		return members[n-1], nil
+	}
+
+	var selected []string
+	for idx, member := range members {
+		if n&(1<<uint(idx)) != 0 {
+			selected = append(selected, member)
+			n &^= 1 << uint(idx)
+		}
+	}
+	if n != 0 {
+		return nil, fmt.Errorf("SET bitfield has bits outside of the members of column type %q", columnType)
+	}
+	return strings.Join(selected, ","), nil
+}
+
+// alteredEnumTypes returns the new column types of the columns changed by an ALTER TABLE
+// statement, if it only changes the members of existing ENUM and SET columns without
+// renaming or moving them. Otherwise it returns false. The given column types are those
+// of the table before the statement, and the returned ones are keyed by the same names.
+func alteredEnumTypes(stmt *sqlparser.AlterTable, columnTypes map[string]string) (map[string]string, bool) {
+	if len(stmt.AlterOptions) == 0 || stmt.PartitionSpec != nil {
+		return nil, false
+	}
+	var altered = make(map[string]string)
+	for _, opt := range stmt.AlterOptions {
+		// A FIRST or AFTER clause moves the column, which changes the order of the values
+		// of replicated rows. Such a clause is detected by its absence from the rendering
+		// of the same change without it.
+		var def *sqlparser.ColumnDefinition
+		switch opt := opt.(type) {
+		case *sqlparser.ModifyColumn:
+			def = opt.NewColDefinition
+			if sqlparser.String(opt) != sqlparser.String(&sqlparser.ModifyColumn{NewColDefinition: def}) {
+				return nil, false
+			}
+		case *sqlparser.ChangeColumn:
+			def = opt.NewColDefinition
+			if !opt.OldColumn.Name.Equal(def.Name) {
+				return nil, false
+			}
+			if sqlparser.String(opt) != sqlparser.String(&sqlparser.ChangeColumn{OldColumn: opt.OldColumn, NewColDefinition: def}) {
+				return nil, false
+			}
+		default:
+			return nil, false
+		}
+
+		var base = strings.ToLower(def.Type.Type)
+		if base != "enum" && base != "set" {
+			return nil, false
+		}
+		var name, previous, ok = lookupColumnType(columnTypes, def.Name.String())
+		if !ok || enumBaseType(previous) != base {
+			return nil, false
+		}
+
+		var members = make([]string, len(def.Type.EnumValues))
+		for idx, value := range def.Type.EnumValues {
+			// The parser may or may not keep the quotes around each value.
+			if strings.HasPrefix(value, "'") {
+				var parsed, err = parseEnumMembers("enum(" + value + ")")
+				if err != nil || len(parsed) != 1 {
+					return nil, false
+				}
+				value = parsed[0]
+			}
+			members[idx] = value
+		}
+		altered[name] = formatEnumType(base, members)
+	}
+	return altered, true
+}
+
+// lookupColumnType returns the name and type of a column, whose name is matched
+// case-insensitively as MySQL does.
+func lookupColumnType(columnTypes map[string]string, name string) (string, string, bool) {
+	if columnType, ok := columnTypes[name]; ok {
+		return name, columnType, true
+	}
+	for colName, columnType := range columnTypes {
+		if strings.EqualFold(colName, name) {
+			return colName, columnType, true
+		}
+	}
+	return "", "", false
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// Policies for values of large columns which are bigger than `max_value_bytes`.
+const (
+	oversizedValueError    = "error"
+	oversizedValueSkip     = "skip"
+	oversizedValueTruncate = "truncate"
+)
+
+// largeObjectTypes are the data types of TEXT and BLOB columns, whose values can be too
+// large to capture in full. Values of other types are never limited.
+var largeObjectTypes = map[string]bool{
+	"text":       true,
+	"mediumtext": true,
+	"longtext":   true,
+	"blob":       true,
+	"mediumblob": true,
+	"longblob":   true,
+}
+
+// valueSizeLimit applies a policy to the values of TEXT and BLOB columns which are larger
+// than a maximum size in bytes. Key columns are never limited, since their values identify
+// the row.
+type valueSizeLimit struct {
+	maxBytes int
+	policy   string
+}
+
+// newValueSizeLimit returns the valueSizeLimit of the configuration, or nil if values
+// shouldn't be limited.
+func newValueSizeLimit(cfg *advancedConfig) *valueSizeLimit {
+	if cfg.MaxValueBytes == 0 {
+		return nil
+	}
+	return &valueSizeLimit{maxBytes: cfg.MaxValueBytes, policy: cfg.OversizedValuePolicy}
+}
+
+// selectList returns the list of columns selected by backfill queries of the table. Limited
+// columns are selected with `LEFT()` so that the server never sends more of a value than is
+// needed to tell whether it's oversized, which bounds the memory used by each chunk of rows.
+// For TEXT columns the length is in characters rather than bytes, so this selects at least
+// one more byte than the limit whenever the full value is oversized.
+func (l *valueSizeLimit) selectList(columnNames []string, columnTypes map[string]string, keyColumns []string) string {
+	if l == nil {
+		return "*"
+	}
+	var limited bool
+	var selects []string
+	for _, name := range columnNames {
+		if largeObjectTypes[columnTypes[name]] && !containsColumn(name, keyColumns) {
+			selects = append(selects, fmt.Sprintf("LEFT(%s, %d) AS %s", name, l.maxBytes+1, name))
+			limited = true
+		} else {
+			selects = append(selects, name)
+		}
+	}
+	if !limited {
+		return "*"
+	}
+	return strings.Join(selects, ", ")
+}
+
+// apply applies the policy to the oversized values of the row, which has already been
+// translated so that TEXT values are strings and BLOB values are byte slices. Skipped values
+// are removed from the row. The names of the columns whose values were truncated are returned.
+func (l *valueSizeLimit) apply(columnTypes map[string]string, keyColumns []string, row map[string]interface{}) ([]string, error) {
+	if l == nil {
+		return nil, nil
+	}
+	var truncated []string
+	for name, val := range row {
+		if !largeObjectTypes[columnTypes[name]] || containsColumn(name, keyColumns) {
+			continue
+		}
+		var size int
+		switch val := val.(type) {
+		case string:
+			size = len(val)
+		case []byte:
+			size = len(val)
+		}
+		if size <= l.maxBytes {
+			continue
+		}
+
+		switch l.policy {
+		case oversizedValueSkip:
+			delete(row, name)
+		case oversizedValueTruncate:
+			row[name] = truncateValue(val, l.maxBytes)
+			truncated = append(truncated, name)
+		default:
+			return nil, fmt.Errorf("value of column %q exceeds the 'max_value_bytes' limit of %d bytes", name, l.maxBytes)
+		}
+	}
+	sort.Strings(truncated)
+	return truncated, nil
+}
+
+// truncateValue returns the value truncated to at most maxBytes. Strings are truncated at
+// a character boundary, so that they remain valid UTF-8.
+func truncateValue(val interface{}, maxBytes int) interface{} {
+	switch val := val.(type) {
+	case string:
+		var n = maxBytes
+		for n > 0 && !utf8.RuneStart(val[n]) {
+			n--
+		}
+		return val[:n]
+	case []byte:
+		return val[:maxBytes]
+	}
+	return val
+}
+
+// withTruncated returns source metadata which records the truncated columns of an event.
+// Events from the same binlog rows event share their source metadata, so it's copied when
+// any columns were truncated.
+func (s *mysqlSourceInfo) withTruncated(truncated ...[]string) *mysqlSourceInfo {
+	var columns []string
+	for _, names := range truncated {
+		for _, name := range names {
+			if !containsColumn(name, columns) {
+				columns = append(columns, name)
+			}
+		}
+	}
+	if len(columns) == 0 {
+		return s
+	}
+	sort.Strings(columns)
+	var info = *s
+	info.Truncated = columns
+	return &info
+}
+
+func containsColumn(name string, columns []string) bool {
+	for _, column := range columns {
+		if column == name {
+			return true
+		}
+	}
+	return false
+}
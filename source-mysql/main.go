@@ -81,6 +81,22 @@ type advancedConfig struct {
 	SkipBinlogRetentionCheck bool   `json:"skip_binlog_retention_check,omitempty" jsonschema:"title=Skip Binlog Retention Sanity Check,default=false,description=Bypasses the 'dangerously short binlog retention' sanity check at startup. Only do this if you understand the danger and have a specific need."`
 	NodeID                   uint32 `json:"node_id,omitempty" jsonschema:"title=Node ID,description=Node ID for the capture. Each node in a replication cluster must have a unique 32-bit ID. The specific value doesn't matter so long as it is unique. If unset or zero the connector will pick a value."`
 	SkipBackfills            string `json:"skip_backfills,omitempty" jsonschema:"title=Skip Backfills,description=A comma-separated list of fully-qualified table names which should not be backfilled."`
+	SkipSnapshot             bool   `json:"skip_snapshot,omitempty" jsonschema:"title=Skip Initial Snapshot,default=false,description=Skip backfilling every table and only capture new changes. Only do this if the preexisting contents of your tables are already present downstream."`
+	StartPosition            string `json:"start_position,omitempty" jsonschema:"title=Start Binlog Position,description=The binlog position in '<logfile>:<position>' form from which a new capture should begin replication. If unset the current position is used. Has no effect once the capture has started."`
+	StartGTIDSet             string `json:"start_gtid_set,omitempty" jsonschema:"title=Start GTID Set,description=A GTID set from which a new capture should begin replication. Requires GTID mode and may not be combined with 'start_position'. Has no effect once the capture has started."`
+	GTIDCursor               bool   `json:"gtid_cursor,omitempty" jsonschema:"title=Resume Using GTIDs,default=false,description=Track the progress of replication by the set of executed GTIDs instead of the binlog file and position. This lets the capture resume after failover to a replica whose binlog coordinates differ. Requires GTID mode."`
+	TinyintAsBoolean         bool   `json:"tinyint1_as_bool,omitempty" jsonschema:"title=Capture TINYINT(1) as Boolean,default=false,description=Capture TINYINT(1) and BOOLEAN columns as JSON booleans instead of integers. Wider TINYINT columns are still captured as integers."`
+	SpatialFormat            string `json:"spatial_format,omitempty" jsonschema:"title=Spatial Data Format,default=wkt,enum=wkt,enum=geojson,description=The format in which values of spatial columns such as POINT and GEOMETRY are captured. Either 'wkt' for Well-Known Text strings or 'geojson' for GeoJSON strings."`
+	UnsignedBigintFormat     string `json:"unsigned_bigint_format,omitempty" jsonschema:"title=Unsigned BIGINT Format,default=integer,enum=integer,enum=string,description=The format in which values of BIGINT UNSIGNED columns are captured. Either 'integer' for JSON integers of the full unsigned range or 'string' for decimal strings which can be read by consumers that only handle signed 64-bit integers."`
+	DiscoverSystemSchemas    bool   `json:"discover_system_schemas,omitempty" jsonschema:"title=Discover System Schemas,default=false,description=Also discover the tables of the system schemas 'information_schema' and 'mysql' and 'performance_schema' and 'sys'. Only do this if you have a specific need to capture them."`
+	MaxValueBytes            int    `json:"max_value_bytes,omitempty" jsonschema:"title=Maximum Value Size,description=The maximum size in bytes of a TEXT or BLOB value which is captured in full. Larger values are handled according to the oversized value policy. Zero or unset means that values are never limited."`
+	OversizedValuePolicy     string `json:"oversized_value_policy,omitempty" jsonschema:"title=Oversized Value Policy,default=error,enum=error,enum=skip,enum=truncate,description=How TEXT or BLOB values larger than the maximum value size are captured. Either 'error' to fail the capture or 'skip' to omit the value or 'truncate' to capture only its first bytes."`
+	PeriodicSnapshotTables   string `json:"periodic_snapshot_tables,omitempty" jsonschema:"title=Periodic Snapshot Tables,description=A comma-separated list of fully-qualified table names which are captured by periodically snapshotting them instead of from the binlog. Tables in databases which the server excludes from the binlog are always captured this way."`
+	RefreshInterval          int    `json:"refresh_interval_seconds,omitempty" jsonschema:"title=Refresh Interval,default=86400,description=How often (in seconds) to rescan the tables of streams whose sync mode is 'full_refresh' or which are captured by periodic snapshots."`
+	PeriodicSnapshotMaxRows  int    `json:"periodic_snapshot_max_rows,omitempty" jsonschema:"title=Periodic Snapshot Row Limit,default=10000,description=The most rows that a table captured by periodic snapshots may have. A digest of each row is kept in the capture state so larger limits make for larger checkpoints. The capture fails once a table has more rows."`
+	BinlogRetentionWarning   int    `json:"binlog_retention_warning_hours,omitempty" jsonschema:"title=Binlog Retention Warning Threshold,default=720,description=A warning is logged at startup when the binlog retention period of the server is shorter than this many hours. Retention must cover the longest expected downtime of the capture or else it will need to be backfilled again."`
+	BinlogMetadata           bool   `json:"binlog_metadata,omitempty" jsonschema:"title=Include Binlog Metadata,default=false,description=Include the binlog file and position and the GTID of each change event in the 'binlog_file' and 'binlog_pos' and 'gtid' properties of its source metadata. Backfilled rows carry the binlog position as of when they were read."`
+	StringKeyOrdering        string `json:"string_key_ordering,omitempty" jsonschema:"title=String Key Ordering,default=binary,enum=binary,enum=collation,description=How backfills order the rows of tables with string key columns. Either 'binary' to order them by the bytes of their keys which is always consistent with how the connector compares keys but can't use the index of the key or 'collation' to order them by the collations of the key columns which is only correct if they sort keys the same way (such as for lowercase UUIDs)."`
 }
 
 // Validate checks that the configuration possesses all required properties.
@@ -105,6 +121,58 @@ func (c *Config) Validate() error {
 			}
 		}
 	}
+	if c.Advanced.StartPosition != "" && c.Advanced.StartGTIDSet != "" {
+		return fmt.Errorf("invalid configuration: 'start_position' and 'start_gtid_set' cannot both be set")
+	}
+	if c.Advanced.StartPosition != "" {
+		if _, _, err := splitCursor(c.Advanced.StartPosition); err != nil {
+			return fmt.Errorf("invalid 'start_position' configuration: %w", err)
+		}
+	}
+	if c.Advanced.StartGTIDSet != "" {
+		if _, err := mysql.ParseGTIDSet(mysql.MySQLFlavor, c.Advanced.StartGTIDSet); err != nil {
+			return fmt.Errorf("invalid 'start_gtid_set' configuration: %w", err)
+		}
+	}
+	switch c.Advanced.SpatialFormat {
+	case "", spatialFormatWKT, spatialFormatGeoJSON:
+	default:
+		return fmt.Errorf("invalid 'spatial_format' configuration: must be %q or %q", spatialFormatWKT, spatialFormatGeoJSON)
+	}
+	switch c.Advanced.UnsignedBigintFormat {
+	case "", unsignedBigintFormatInteger, unsignedBigintFormatString:
+	default:
+		return fmt.Errorf("invalid 'unsigned_bigint_format' configuration: must be %q or %q", unsignedBigintFormatInteger, unsignedBigintFormatString)
+	}
+	if c.Advanced.MaxValueBytes < 0 {
+		return fmt.Errorf("invalid 'max_value_bytes' configuration: %d must not be negative", c.Advanced.MaxValueBytes)
+	}
+	switch c.Advanced.OversizedValuePolicy {
+	case "", oversizedValueError, oversizedValueSkip, oversizedValueTruncate:
+	default:
+		return fmt.Errorf("invalid 'oversized_value_policy' configuration: must be %q, %q, or %q", oversizedValueError, oversizedValueSkip, oversizedValueTruncate)
+	}
+	if c.Advanced.PeriodicSnapshotTables != "" {
+		for _, snapshotStreamID := range strings.Split(c.Advanced.PeriodicSnapshotTables, ",") {
+			if !strings.Contains(snapshotStreamID, ".") {
+				return fmt.Errorf("invalid 'periodic_snapshot_tables' configuration: table name %q must be fully-qualified as \"<schema>.<table>\"", snapshotStreamID)
+			}
+		}
+	}
+	switch c.Advanced.StringKeyOrdering {
+	case "", stringKeyOrderingBinary, stringKeyOrderingCollation:
+	default:
+		return fmt.Errorf("invalid 'string_key_ordering' configuration: must be %q or %q", stringKeyOrderingBinary, stringKeyOrderingCollation)
+	}
+	if c.Advanced.RefreshInterval < 0 {
+		return fmt.Errorf("invalid 'refresh_interval_seconds' configuration: interval %d must not be negative", c.Advanced.RefreshInterval)
+	}
+	if c.Advanced.PeriodicSnapshotMaxRows < 0 {
+		return fmt.Errorf("invalid 'periodic_snapshot_max_rows' configuration: limit %d must not be negative", c.Advanced.PeriodicSnapshotMaxRows)
+	}
+	if c.Advanced.BinlogRetentionWarning < 0 {
+		return fmt.Errorf("invalid 'binlog_retention_warning_hours' configuration: threshold %d must not be negative", c.Advanced.BinlogRetentionWarning)
+	}
 	return nil
 }
 
@@ -121,6 +189,27 @@ func (c *Config) SetDefaults() {
 	if c.Advanced.NodeID == 0 {
 		c.Advanced.NodeID = 0x476C6F77 // "Flow"
 	}
+	if c.Advanced.SpatialFormat == "" {
+		c.Advanced.SpatialFormat = spatialFormatWKT
+	}
+	if c.Advanced.UnsignedBigintFormat == "" {
+		c.Advanced.UnsignedBigintFormat = unsignedBigintFormatInteger
+	}
+	if c.Advanced.OversizedValuePolicy == "" {
+		c.Advanced.OversizedValuePolicy = oversizedValueError
+	}
+	if c.Advanced.StringKeyOrdering == "" {
+		c.Advanced.StringKeyOrdering = stringKeyOrderingBinary
+	}
+	if c.Advanced.RefreshInterval == 0 {
+		c.Advanced.RefreshInterval = 86400
+	}
+	if c.Advanced.PeriodicSnapshotMaxRows == 0 {
+		c.Advanced.PeriodicSnapshotMaxRows = 10000
+	}
+	if c.Advanced.BinlogRetentionWarning == 0 {
+		c.Advanced.BinlogRetentionWarning = 720
+	}
 
 	// The address config property should accept a host or host:port
 	// value, and if the port is unspecified it should be the MySQL
@@ -131,12 +220,14 @@ func (c *Config) SetDefaults() {
 }
 
 type mysqlDatabase struct {
-	config        *Config
-	conn          *client.Conn
-	defaultSchema string
+	config         *Config
+	conn           *client.Conn
+	defaultSchema  string
+	serverTimezone string        // The server's time zone as of when we connected, for logging and metadata.
+	binlogFilter   *binlogFilter // The databases whose changes are written to the binlog, or nil if unknown.
 }
 
-func (db *mysqlDatabase) Connect(ctx context.Context) error {
+func (db *mysqlDatabase) Connect(ctx context.Context) (err error) {
 	logrus.WithFields(logrus.Fields{
 		"addr":     db.config.Address,
 		"dbName":   db.config.Advanced.DBName,
@@ -145,7 +236,7 @@ func (db *mysqlDatabase) Connect(ctx context.Context) error {
 	}).Info("initializing connector")
 
 	// Normal database connection used for table scanning
-	var conn, err = client.Connect(db.config.Address, db.config.User, db.config.Password, db.config.Advanced.DBName, func(c *client.Conn) {
+	conn, err := client.Connect(db.config.Address, db.config.User, db.config.Password, db.config.Advanced.DBName, func(c *client.Conn) {
 		// TODO(wgd): Consider adding an optional 'serverName' config parameter which
 		// if set makes this false and sets 'ServerName' so it will be verified properly.
 		c.SetTLSConfig(&tls.Config{
@@ -155,56 +246,87 @@ func (db *mysqlDatabase) Connect(ctx context.Context) error {
 	if err != nil {
 		return fmt.Errorf("unable to connect to database: %w", err)
 	}
+	sqlcapture.ConnectionOpened("database")
 	db.conn = conn
 
+	// The connection is closed if any of the following setup fails, since callers
+	// only close the database after a successful Connect.
+	defer func() {
+		if err != nil {
+			db.Close(ctx)
+		}
+	}()
+
+	// The client library defaults to the 'utf8' (utf8mb3) character set, in which case the
+	// server replaces any 4-byte characters of utf8mb4 columns with '?' in query results,
+	// while replication sees the stored bytes. Using utf8mb4 for the session means that
+	// backfills and replication agree.
+	if err = conn.SetCharset("utf8mb4"); err != nil {
+		return fmt.Errorf("error setting session character set: %w", err)
+	}
+
+	// Record the time zone the server would have used for this session, and then switch
+	// the session to UTC. TIMESTAMP values are stored as UTC and converted to the session
+	// time zone by queries, whereas replicated row events report them as UTC. Fixing the
+	// session time zone means backfills and replication agree, and that a capture which
+	// resumes after the server's time zone has changed won't silently shift its values.
+	if db.serverTimezone, err = queryServerTimezone(conn); err != nil {
+		return fmt.Errorf("error querying server time zone: %w", err)
+	}
+	logrus.WithField("timezone", db.serverTimezone).Debug("queried server time zone")
+	if _, err := conn.Execute("SET time_zone = '+00:00';"); err != nil {
+		return fmt.Errorf("error setting session time zone: %w", err)
+	}
+
+	// Tables in databases which are excluded from the binlog are captured by periodic
+	// snapshots. The filter can only be queried with the REPLICATION CLIENT privilege,
+	// which isn't needed for discovery, so failing to query it isn't fatal.
+	if db.binlogFilter, err = queryBinlogFilter(conn); err != nil {
+		logrus.WithField("err", err).Warn("unable to query binlog filter")
+		db.binlogFilter, err = nil, nil
+	}
+
+	// Resuming by GTIDs is only possible if every transaction has one.
+	if db.config.Advanced.GTIDCursor {
+		if err = checkGTIDMode(conn); err != nil {
+			return err
+		}
+	}
+
 	// Sanity-check binlog retention and error out if it's insufficiently long.
 	// By doing this during the Connect operation it will occur both during
 	// actual captures and when performing discovery/config validation, which
 	// is likely what we want.
 	if !db.config.Advanced.SkipBinlogRetentionCheck {
-		expiryTime, err := db.getBinlogExpiry()
+		expiryTime, err := getBinlogExpiry(db.queryIntegerVariable)
 		if err != nil {
 			return fmt.Errorf("error querying binlog expiry time: %w", err)
 		}
-		if expiryTime < minimumExpiryTime {
-			return fmt.Errorf("binlog retention period is too short (go.estuary.dev/PoMlNf): server reports %s but at least %s is required (and 30 days is preferred wherever possible)", expiryTime.String(), minimumExpiryTime.String())
+		var warningTime = time.Duration(db.config.Advanced.BinlogRetentionWarning) * time.Hour
+		if err := checkBinlogRetention(expiryTime, warningTime); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
 
-func (db *mysqlDatabase) getBinlogExpiry() (time.Duration, error) {
-	// When running on Amazon RDS MySQL there's an RDS-specific configuration
-	// for binlog retention, so that takes precedence if it exists.
-	rdsRetentionHours, err := db.queryIntegerVariable(`SELECT name, value FROM mysql.rds_configuration WHERE name = 'binlog retention hours';`)
-	if err == nil {
-		return time.Duration(rdsRetentionHours) * time.Hour, nil
-	}
-
-	// The new 'binlog_expire_logs_seconds' variable takes priority
-	expireLogsSeconds, err := db.queryIntegerVariable(`SHOW VARIABLES LIKE 'binlog_expire_logs_seconds';`)
+// queryServerTimezone returns the time zone which the server uses for new sessions. If that's
+// the default of "SYSTEM" then the operating system time zone of the server is returned instead.
+func queryServerTimezone(conn *client.Conn) (string, error) {
+	var results, err = conn.Execute("SELECT @@SESSION.time_zone, @@system_time_zone;")
 	if err != nil {
-		return 0, err
-	}
-	if expireLogsSeconds > 0 {
-		return time.Duration(expireLogsSeconds) * time.Second, nil
+		return "", err
 	}
-
-	// However 'expire_logs_days' will be used instead if 'seconds' was zero
-	expireLogsDays, err := db.queryIntegerVariable(`SHOW VARIABLES LIKE 'expire_logs_days';`)
-	if err != nil {
-		return 0, err
+	defer results.Close()
+	if len(results.Values) == 0 {
+		return "", fmt.Errorf("no results from time zone query")
 	}
-	if expireLogsDays > 0 {
-		return time.Duration(expireLogsDays) * 24 * time.Hour, nil
+	var zone = string(results.Values[0][0].AsString())
+	if strings.EqualFold(zone, "SYSTEM") {
+		zone = string(results.Values[0][1].AsString())
 	}
-
-	// If both 'binlog_expire_logs_seconds' and 'expire_logs_days' are set to zero
-	// MySQL will not automatically purge binlog segments. For simplicity we just
-	// represent that as a 'one year' expiry time, since all we need the value for
-	// is to make sure it's not too short.
-	return 365 * 24 * time.Hour, nil
+	return zone, nil
 }
 
 func (db *mysqlDatabase) queryIntegerVariable(query string) (int64, error) {
@@ -237,7 +359,13 @@ func (db *mysqlDatabase) queryIntegerVariable(query string) (int64, error) {
 }
 
 func (db *mysqlDatabase) Close(ctx context.Context) error {
-	if err := db.conn.Close(); err != nil {
+	if db.conn == nil {
+		return nil // Already closed, or never successfully connected.
+	}
+	var err = db.conn.Close()
+	db.conn = nil
+	sqlcapture.ConnectionClosed("database")
+	if err != nil {
 		return fmt.Errorf("error closing database connection: %w", err)
 	}
 	return nil
@@ -264,6 +392,9 @@ func (db *mysqlDatabase) EmptySourceMetadata() sqlcapture.SourceMetadata {
 }
 
 func (db *mysqlDatabase) EncodeKeyFDB(key interface{}) (tuple.TupleElement, error) {
+	if n, ok := key.(unsignedBigintString); ok {
+		return uint64(n), nil
+	}
 	return key, nil
 }
 
@@ -271,7 +402,16 @@ func (db *mysqlDatabase) DecodeKeyFDB(t tuple.TupleElement) (interface{}, error)
 	return t, nil
 }
 
+// StartPositionConfigured returns true if a new capture begins replication from the
+// configured 'start_position' or 'start_gtid_set' rather than the current position.
+func (db *mysqlDatabase) StartPositionConfigured() bool {
+	return db.config.Advanced.StartPosition != "" || db.config.Advanced.StartGTIDSet != ""
+}
+
 func (db *mysqlDatabase) ShouldBackfill(streamID string) bool {
+	if db.config.Advanced.SkipSnapshot {
+		return false
+	}
 	if db.config.Advanced.SkipBackfills != "" {
 		// This repeated splitting is a little inefficient, but this check is done at
 		// most once per table during connector startup and isn't really worth caching.
@@ -287,7 +427,13 @@ func (db *mysqlDatabase) ShouldBackfill(streamID string) bool {
 // mysqlSourceInfo is source metadata for data capture events.
 type mysqlSourceInfo struct {
 	sqlcapture.SourceCommon
-	FlushCursor string `json:"cursor,omitempty" jsonschema:"description=Cursor value representing the current position in the binlog."`
+	FlushCursor string   `json:"cursor,omitempty" jsonschema:"description=Cursor value representing the current position in the binlog."`
+	Truncated   []string `json:"truncated,omitempty" jsonschema:"description=Columns whose values were truncated to the maximum value size."`
+	BinlogFile  string   `json:"binlog_file,omitempty" jsonschema:"description=Name of the binlog file of the event. For backfilled rows it's the file which was current when the row was read."`
+	BinlogPos   uint32   `json:"binlog_pos,omitempty" jsonschema:"description=Position in the binlog file at which the event ends. For backfilled rows it's the position of the binlog when the row was read."`
+	GTID        string   `json:"gtid,omitempty" jsonschema:"description=GTID of the transaction of the event. Unset for backfilled rows and when the server isn't in GTID mode."`
+
+	position json.RawMessage // The description of the binlog position of a 'Commit' event
 }
 
 func (s *mysqlSourceInfo) Common() sqlcapture.SourceCommon {
@@ -297,3 +443,7 @@ func (s *mysqlSourceInfo) Common() sqlcapture.SourceCommon {
 func (s *mysqlSourceInfo) Cursor() string {
 	return s.FlushCursor
 }
+
+func (s *mysqlSourceInfo) Position() json.RawMessage {
+	return s.position
+}
@@ -2,16 +2,22 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"os"
+	"runtime"
+	"sort"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/estuary/connectors/sqlcapture"
 	"github.com/estuary/connectors/sqlcapture/tests"
 	"github.com/go-mysql-org/go-mysql/client"
+	"github.com/go-mysql-org/go-mysql/mysql"
 	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/require"
 )
 
@@ -58,6 +64,9 @@ func TestMain(m *testing.M) {
 	if err != nil {
 		logrus.WithField("err", err).Fatal("error connecting to database")
 	}
+	if err := conn.SetCharset("utf8mb4"); err != nil {
+		logrus.WithField("err", err).Fatal("error setting connection character set")
+	}
 
 	TestBackend = &mysqlTestBackend{conn: conn, cfg: cfg}
 
@@ -220,7 +229,9 @@ func TestBinlogExpirySanityCheck(t *testing.T) {
 			TestBackend.Query(ctx, t, "SET GLOBAL expire_logs_days = 0;")
 			TestBackend.Query(ctx, t, fmt.Sprintf("SET GLOBAL %s = %d;", tc.VarName, tc.VarValue))
 
-			// Connect to the database, which may run the sanity-check
+			// Connect to the database, which may run the sanity-check. The connection
+			// must be closed whether or not the check fails.
+			tests.GuardConnections(t)
 			db := TestBackend.GetDatabase()
 			if tc.SkipCheck {
 				db.(*mysqlDatabase).config.Advanced.SkipBinlogRetentionCheck = true
@@ -238,6 +249,56 @@ func TestBinlogExpirySanityCheck(t *testing.T) {
 	}
 }
 
+// TestBinlogRetentionCheck verifies that the binlog retention period is reported
+// from mocked server variables, and that a warning is logged when it's shorter than
+// the configured threshold.
+func TestBinlogRetentionCheck(t *testing.T) {
+	for _, tc := range []struct {
+		name      string
+		variables map[string]int64
+		retention time.Duration
+		warning   bool
+	}{
+		{"rds short", map[string]int64{"binlog retention hours": 168}, 7 * 24 * time.Hour, true},
+		{"rds adequate", map[string]int64{"binlog retention hours": 720}, 30 * 24 * time.Hour, false},
+		{"seconds short", map[string]int64{"binlog_expire_logs_seconds": 864000}, 10 * 24 * time.Hour, true},
+		{"seconds adequate", map[string]int64{"binlog_expire_logs_seconds": 2592000}, 30 * 24 * time.Hour, false},
+		{"days short", map[string]int64{"binlog_expire_logs_seconds": 0, "expire_logs_days": 14}, 14 * 24 * time.Hour, true},
+		{"days adequate", map[string]int64{"binlog_expire_logs_seconds": 0, "expire_logs_days": 60}, 60 * 24 * time.Hour, false},
+		{"never purged", map[string]int64{"binlog_expire_logs_seconds": 0, "expire_logs_days": 0}, 365 * 24 * time.Hour, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var queryVariable = func(query string) (int64, error) {
+				for name, value := range tc.variables {
+					if strings.Contains(query, "'"+name+"'") {
+						return value, nil
+					}
+				}
+				return 0, fmt.Errorf("no results from query %q", query)
+			}
+			var retention, err = getBinlogExpiry(queryVariable)
+			require.NoError(t, err)
+			require.Equal(t, tc.retention, retention)
+
+			var hook = logtest.NewGlobal()
+			defer hook.Reset()
+			require.NoError(t, checkBinlogRetention(retention, 30*24*time.Hour))
+			var entry = hook.LastEntry()
+			require.NotNil(t, entry)
+			require.Equal(t, "binlog_retention", entry.Data["event"])
+			require.Equal(t, retention.String(), entry.Data["retention"])
+			if tc.warning {
+				require.Equal(t, logrus.WarnLevel, entry.Level)
+			} else {
+				require.Equal(t, logrus.InfoLevel, entry.Level)
+			}
+		})
+	}
+
+	// Retention below the minimum is an error regardless of the warning threshold.
+	require.Error(t, checkBinlogRetention(6*24*time.Hour, 0))
+}
+
 func TestSkipBackfills(t *testing.T) {
 	// Set up three tables with some data in them, a catalog which captures all three,
 	// but a configuration which specifies that tables A and C should skip backfilling
@@ -262,3 +323,707 @@ func TestSkipBackfills(t *testing.T) {
 	tb.Insert(ctx, t, tableC, [][]interface{}{{16, "sixteen"}, {17, "seventeen"}, {18, "eighteen"}})
 	tests.VerifiedCapture(ctx, t, tb, &catalog, &state, "")
 }
+
+// TestSkipSnapshot verifies that the 'skip_snapshot' option causes a new capture
+// to emit no backfill records, and that replication begins at the configured start
+// position when one is provided.
+func TestSkipSnapshot(t *testing.T) {
+	var tb, ctx = &mysqlTestBackend{conn: TestBackend.conn, cfg: TestBackend.cfg}, context.Background()
+	var table = tb.CreateTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
+	tb.Insert(ctx, t, table, [][]interface{}{{1, "one"}, {2, "two"}, {3, "three"}})
+	tb.cfg.Advanced.SkipSnapshot = true
+	var catalog = tests.ConfiguredCatalog(ctx, t, tb, table)
+
+	t.Run("current", func(t *testing.T) {
+		var state = sqlcapture.PersistentState{}
+		var output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+		require.NotContains(t, output, `"type":"RECORD"`)
+
+		tb.Insert(ctx, t, table, [][]interface{}{{4, "four"}})
+		output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+		require.Contains(t, output, `"data":"four"`)
+		require.NotContains(t, output, `"data":"three"`)
+	})
+
+	t.Run("position", func(t *testing.T) {
+		var results, err = tb.conn.Execute("SHOW MASTER STATUS;")
+		require.NoError(t, err)
+		require.NotEmpty(t, results.Values)
+		var startPosition = fmt.Sprintf("%s:%d", results.Values[0][0].AsString(), results.Values[0][1].AsInt64())
+		results.Close()
+
+		tb.Insert(ctx, t, table, [][]interface{}{{5, "five"}})
+		tb.cfg.Advanced.StartPosition = startPosition
+		defer func() { tb.cfg.Advanced.StartPosition = "" }()
+
+		var state = sqlcapture.PersistentState{}
+		var output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+		require.Contains(t, output, `"data":"five"`)
+		require.NotContains(t, output, `"data":"four"`)
+	})
+
+	t.Run("unretained", func(t *testing.T) {
+		tb.cfg.Advanced.StartPosition = "mysql-bin.999999:4"
+		defer func() { tb.cfg.Advanced.StartPosition = "" }()
+
+		var state = sqlcapture.PersistentState{}
+		var output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+		require.Contains(t, output, "is not retained on the server")
+	})
+}
+
+// TestBackfillCompleteMarker verifies that a "backfill complete" marker is logged
+// exactly once for each stream, when its backfill completes.
+func TestBackfillCompleteMarker(t *testing.T) {
+	var tb, ctx = TestBackend, context.Background()
+	var tableA = tb.CreateTable(ctx, t, "aaa", "(id INTEGER PRIMARY KEY, data TEXT)")
+	var tableB = tb.CreateTable(ctx, t, "bbb", "(id INTEGER PRIMARY KEY, data TEXT)")
+	tb.Insert(ctx, t, tableA, [][]interface{}{{1, "one"}, {2, "two"}, {3, "three"}})
+	var catalog = tests.ConfiguredCatalog(ctx, t, tb, tableA, tableB)
+
+	var hook = logtest.NewGlobal()
+	defer hook.Reset()
+	var markers = func() map[string]*logrus.Entry {
+		var found = make(map[string]*logrus.Entry)
+		for _, entry := range hook.AllEntries() {
+			if entry.Data["event"] != "backfill_complete" {
+				continue
+			}
+			var stream = entry.Data["stream"].(string)
+			require.NotContains(t, found, stream, "duplicate marker for stream %q", stream)
+			found[stream] = entry
+		}
+		hook.Reset()
+		return found
+	}
+	var streamA, streamB = strings.ToLower("test." + tableA), strings.ToLower("test." + tableB)
+
+	var state = sqlcapture.PersistentState{}
+	tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	var found = markers()
+	require.Len(t, found, 2)
+	require.Equal(t, 3, found[streamA].Data["rows"])
+	require.Equal(t, "[3]", fmt.Sprint(found[streamA].Data["scannedKey"]))
+	require.Equal(t, 0, found[streamB].Data["rows"])
+	require.NotContains(t, found[streamB].Data, "scannedKey")
+
+	// Streams which have already been backfilled aren't announced again.
+	tb.Insert(ctx, t, tableB, [][]interface{}{{4, "four"}})
+	tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.Empty(t, markers())
+}
+
+// TestTimestampTimezoneChange verifies that TIMESTAMP values are captured the same
+// way, in UTC, even when the server's time zone changes in between capture runs.
+func TestTimestampTimezoneChange(t *testing.T) {
+	var tb, ctx = TestBackend, context.Background()
+	var table = tb.CreateTable(ctx, t, "", "(id INTEGER PRIMARY KEY, ts TIMESTAMP)")
+	var catalog = tests.ConfiguredCatalog(ctx, t, tb, table)
+
+	var results, err = tb.conn.Execute("SELECT @@GLOBAL.time_zone;")
+	require.NoError(t, err)
+	var originalZone = string(results.Values[0][0].AsString())
+	results.Close()
+	t.Cleanup(func() { tb.Query(ctx, t, fmt.Sprintf("SET GLOBAL time_zone = '%s';", originalZone)) })
+
+	// The test connection's session time zone is fixed when it connects, so these
+	// values are always interpreted as UTC regardless of the global setting.
+	tb.Query(ctx, t, "SET GLOBAL time_zone = '+00:00';")
+	tb.Insert(ctx, t, table, [][]interface{}{{1, "2022-01-02 03:04:05"}})
+	var state = sqlcapture.PersistentState{}
+	var output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.Contains(t, output, `"ts":"2022-01-02T03:04:05Z"`)
+	require.Contains(t, output, `"server_timezone":"+00:00"`)
+
+	// Change the server time zone, then resume the capture and also start over with
+	// a fresh one. Both replicated and backfilled values should be unaffected.
+	tb.Query(ctx, t, "SET GLOBAL time_zone = '+05:00';")
+	tb.Insert(ctx, t, table, [][]interface{}{{2, "2022-01-02 06:07:08"}})
+	output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.Contains(t, output, `"ts":"2022-01-02T06:07:08Z"`)
+
+	var freshState = sqlcapture.PersistentState{}
+	output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &freshState)
+	require.Contains(t, output, `"ts":"2022-01-02T03:04:05Z"`)
+	require.Contains(t, output, `"ts":"2022-01-02T06:07:08Z"`)
+	require.Contains(t, output, `"server_timezone":"+05:00"`)
+}
+
+// TestPartitionedTable verifies that the rows of a range-partitioned table are
+// backfilled and replicated as rows of the logical table, and that partition
+// maintenance which doesn't change the table's contents doesn't stop the capture.
+func TestPartitionedTable(t *testing.T) {
+	var tb, ctx = TestBackend, context.Background()
+	var table = tb.CreateTable(ctx, t, "", `(id INTEGER PRIMARY KEY, data TEXT)
+		PARTITION BY RANGE (id) (
+			PARTITION p0 VALUES LESS THAN (10),
+			PARTITION p1 VALUES LESS THAN (20),
+			PARTITION p2 VALUES LESS THAN MAXVALUE
+		)`)
+	var catalog = tests.ConfiguredCatalog(ctx, t, tb, table)
+	var state = sqlcapture.PersistentState{}
+	var expectRow = func(output string, id int, data string) {
+		t.Helper()
+		require.Contains(t, output, fmt.Sprintf(`"table":%q}},"data":%q,"id":%d}`, table, data, id))
+	}
+
+	// Rows in every partition are backfilled from the logical table.
+	tb.Insert(ctx, t, table, [][]interface{}{{1, "one"}, {12, "twelve"}, {25, "twenty-five"}})
+	var output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	expectRow(output, 1, "one")
+	expectRow(output, 12, "twelve")
+	expectRow(output, 25, "twenty-five")
+
+	// And so are replicated changes, including updates which move a row to another partition.
+	tb.Insert(ctx, t, table, [][]interface{}{{2, "two"}, {13, "thirteen"}, {26, "twenty-six"}})
+	tb.Update(ctx, t, table, "id", 12, "data", "TWELVE")
+	tb.Query(ctx, t, fmt.Sprintf("UPDATE %s SET id = 30 WHERE id = 1;", table))
+	tb.Delete(ctx, t, table, "id", 25)
+	output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	expectRow(output, 2, "two")
+	expectRow(output, 13, "thirteen")
+	expectRow(output, 26, "twenty-six")
+	expectRow(output, 12, "TWELVE")
+	expectRow(output, 30, "one")
+	expectRow(output, 25, "twenty-five")
+	require.NotContains(t, output, "Capture Terminated With Error")
+
+	// Reorganizing partitions doesn't change the table's contents.
+	tb.Query(ctx, t, fmt.Sprintf("ALTER TABLE %s REORGANIZE PARTITION p2 INTO (PARTITION p2 VALUES LESS THAN (40), PARTITION p3 VALUES LESS THAN MAXVALUE);", table))
+	tb.Insert(ctx, t, table, [][]interface{}{{45, "forty-five"}})
+	output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	expectRow(output, 45, "forty-five")
+	require.NotContains(t, output, "Capture Terminated With Error")
+
+	// But truncating a partition removes rows without any change events.
+	tb.Query(ctx, t, fmt.Sprintf("ALTER TABLE %s TRUNCATE PARTITION p0;", table))
+	output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.Contains(t, output, "unsupported partition operation")
+}
+
+func TestAlterEnumMembers(t *testing.T) {
+	var tb, ctx = TestBackend, context.Background()
+	var table = tb.CreateTable(ctx, t, "", "(id INTEGER PRIMARY KEY, size ENUM('small', 'large'), tags SET('a', 'b'))")
+	var catalog = tests.ConfiguredCatalog(ctx, t, tb, table)
+	var state = sqlcapture.PersistentState{}
+	var expectRow = func(output string, id int, size, tags string) {
+		t.Helper()
+		require.Contains(t, output, fmt.Sprintf(`"table":%q}},"id":%d,"size":%q,"tags":%q}`, table, id, size, tags))
+	}
+
+	tb.Insert(ctx, t, table, [][]interface{}{{1, "small", "a"}})
+	var output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	expectRow(output, 1, "small", "a")
+
+	// Adding members in the middle of an ENUM or SET changes the index and bits of
+	// existing ones, so values must be translated with the members as of each change.
+	tb.Insert(ctx, t, table, [][]interface{}{{2, "large", "b"}})
+	tb.Query(ctx, t, fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN size ENUM('small', 'medium', 'large'), MODIFY COLUMN tags SET('a', 'c', 'b');", table))
+	tb.Insert(ctx, t, table, [][]interface{}{{3, "medium", "b,c"}, {4, "large", "a,b"}})
+	output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	expectRow(output, 2, "large", "b")
+	expectRow(output, 3, "medium", "c,b")
+	expectRow(output, 4, "large", "a,b")
+	require.NotContains(t, output, "Capture Terminated With Error")
+
+	// Other alterations of the column remain unsupported.
+	tb.Query(ctx, t, fmt.Sprintf("ALTER TABLE %s MODIFY COLUMN size ENUM('small', 'medium', 'large') FIRST;", table))
+	output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.Contains(t, output, "unsupported operation ALTER TABLE")
+}
+
+// TestEnumDiscovery verifies the discovered schema of ENUM and SET columns, which are
+// strings like those of other textual columns.
+func TestEnumDiscovery(t *testing.T) {
+	var tb, ctx = TestBackend, context.Background()
+	var table = tb.CreateTable(ctx, t, "", "(id INTEGER PRIMARY KEY, size ENUM('small', 'medium', 'large'), tags SET('one', 'two') NOT NULL)")
+	var catalog, err = sqlcapture.DiscoverCatalog(ctx, tb.GetDatabase())
+	require.NoError(t, err)
+	tests.VerifyStream(t, "", catalog, table)
+}
+
+func TestParseEnumMembers(t *testing.T) {
+	for _, tc := range []struct {
+		columnType string
+		members    []string
+	}{
+		{"enum('small','medium','large')", []string{"small", "medium", "large"}},
+		{"set('one', 'two')", []string{"one", "two"}},
+		{"enum('it''s','a\\,b','')", []string{"it's", "a,b", ""}},
+		{"enum()", nil},
+	} {
+		var members, err = parseEnumMembers(tc.columnType)
+		require.NoError(t, err, tc.columnType)
+		require.Equal(t, tc.members, members, tc.columnType)
+	}
+	require.Equal(t, "enum('it''s','b')", formatEnumType("enum", []string{"it's", "b"}))
+	for _, columnType := range []string{"enum", "varchar(32)", "enum(small)", "set('one"} {
+		var _, err = parseEnumMembers(columnType)
+		require.Error(t, err, columnType)
+	}
+}
+
+func TestTranslateEnum(t *testing.T) {
+	for _, tc := range []struct {
+		columnType string
+		input      interface{}
+		expect     interface{}
+	}{
+		{"enum('small','medium','large')", int64(2), "medium"},
+		{"enum('small','medium','large')", int64(0), ""},
+		{"enum('small','medium','large')", "large", "large"},
+		{"set('one','two','three')", int64(5), "one,three"},
+		{"set('one','two','three')", int64(0), ""},
+		{"set('one','two','three')", "one,two", "one,two"},
+	} {
+		var result, err = translateEnum(tc.columnType, tc.input)
+		require.NoError(t, err)
+		require.Equal(t, tc.expect, result)
+	}
+	for _, tc := range []struct {
+		columnType string
+		input      int64
+	}{
+		{"enum('small','medium','large')", 4},
+		{"set('one','two','three')", 8},
+	} {
+		var _, err = translateEnum(tc.columnType, tc.input)
+		require.Error(t, err)
+	}
+}
+
+// TestSystemSchemaDiscovery checks that the tables of system schemas are only
+// discovered when the 'discover_system_schemas' option is set.
+func TestSystemSchemaDiscovery(t *testing.T) {
+	var tb, ctx = &mysqlTestBackend{conn: TestBackend.conn, cfg: TestBackend.cfg}, context.Background()
+	var table = tb.CreateTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
+
+	var discoverSchemas = func() map[string]bool {
+		var catalog, err = sqlcapture.DiscoverCatalog(ctx, tb.GetDatabase())
+		require.NoError(t, err)
+		var schemas = make(map[string]bool)
+		for _, stream := range catalog.Streams {
+			schemas[stream.Namespace] = true
+		}
+		return schemas
+	}
+
+	var schemas = discoverSchemas()
+	require.True(t, schemas["test"], "table %q wasn't discovered", table)
+	for _, schema := range systemSchemas {
+		require.False(t, schemas[schema], "system schema %q was discovered", schema)
+	}
+
+	tb.cfg.Advanced.DiscoverSystemSchemas = true
+	schemas = discoverSchemas()
+	require.True(t, schemas["test"], "table %q wasn't discovered", table)
+	require.True(t, schemas["mysql"], "system schema 'mysql' wasn't discovered")
+}
+
+// TestLargeValues verifies that TEXT values larger than 'max_value_bytes' are handled
+// according to the oversized value policy in both backfills and replication, and that
+// backfilling them doesn't read the full values into memory.
+func TestLargeValues(t *testing.T) {
+	var tb, ctx = &mysqlTestBackend{conn: TestBackend.conn, cfg: TestBackend.cfg}, context.Background()
+	var table = tb.CreateTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data LONGTEXT)")
+	var large = strings.Repeat("a", 8*1024*1024)
+	tb.Insert(ctx, t, table, [][]interface{}{{1, "small"}, {2, large}})
+	tb.cfg.Advanced.MaxValueBytes = 1024
+	var catalog = tests.ConfiguredCatalog(ctx, t, tb, table)
+	var truncatedValue = fmt.Sprintf(`"data":"%s"`, large[:1024])
+
+	t.Run("truncate", func(t *testing.T) {
+		tb.cfg.Advanced.OversizedValuePolicy = oversizedValueTruncate
+		var state = sqlcapture.PersistentState{}
+		var output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+		require.Contains(t, output, `"data":"small"`)
+		require.Contains(t, output, truncatedValue+`,"id":2}`)
+		require.Contains(t, output, `"truncated":["data"]`)
+		require.NotContains(t, output, large[:1025])
+
+		tb.Insert(ctx, t, table, [][]interface{}{{3, large}})
+		output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+		require.Contains(t, output, truncatedValue+`,"id":3}`)
+		require.Contains(t, output, `"truncated":["data"]`)
+		require.NotContains(t, output, large[:1025])
+	})
+
+	t.Run("skip", func(t *testing.T) {
+		tb.cfg.Advanced.OversizedValuePolicy = oversizedValueSkip
+		var state = sqlcapture.PersistentState{}
+		var output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+		require.Contains(t, output, `"data":"small"`)
+		require.Contains(t, output, `"id":2}`)
+		require.NotContains(t, output, truncatedValue)
+		require.NotContains(t, output, `"truncated"`)
+	})
+
+	t.Run("error", func(t *testing.T) {
+		tb.cfg.Advanced.OversizedValuePolicy = oversizedValueError
+		var state = sqlcapture.PersistentState{}
+		var output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+		require.Contains(t, output, `value of column "data" exceeds the 'max_value_bytes' limit of 1024 bytes`)
+	})
+
+	t.Run("memory", func(t *testing.T) {
+		tb.cfg.Advanced.OversizedValuePolicy = oversizedValueTruncate
+		var db = &mysqlDatabase{config: &tb.cfg}
+		require.NoError(t, db.Connect(ctx))
+		defer db.Close(ctx)
+		var discovery, err = db.DiscoverTables(ctx)
+		require.NoError(t, err)
+		var info, ok = discovery[sqlcapture.JoinStreamID("test", table)]
+		require.True(t, ok)
+
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		events, err := db.ScanTableChunk(ctx, info, []string{"id"}, nil)
+		require.NoError(t, err)
+		runtime.ReadMemStats(&after)
+		require.Len(t, events, 3)
+		require.Less(t, after.TotalAlloc-before.TotalAlloc, uint64(len(large)))
+	})
+}
+
+// TestStringKeyCollation checks that a table whose string key has a case-insensitive collation
+// is backfilled in complete and non-overlapping chunks, in the order that the capture compares
+// the keys of rows.
+func TestStringKeyCollation(t *testing.T) {
+	var tb, ctx = &mysqlTestBackend{conn: TestBackend.conn, cfg: TestBackend.cfg}, context.Background()
+	var table = tb.CreateTable(ctx, t, "", "(id VARCHAR(32) CHARACTER SET utf8mb4 COLLATE utf8mb4_general_ci PRIMARY KEY, data INTEGER)")
+	var rows [][]interface{}
+	var expected []string
+	for i := 0; i < 100; i++ {
+		// Keys alternate between upper and lower case, so the collation orders them
+		// differently than their bytes do.
+		var id = fmt.Sprintf("%c%03d", 'a'+rune(i%26), i)
+		if i%2 == 1 {
+			id = strings.ToUpper(id)
+		}
+		rows = append(rows, []interface{}{id, i})
+		expected = append(expected, id)
+	}
+	tb.Insert(ctx, t, table, rows)
+	sort.Strings(expected)
+
+	var db = &mysqlDatabase{config: &tb.cfg}
+	require.NoError(t, db.Connect(ctx))
+	defer db.Close(ctx)
+	var discovery, err = db.DiscoverTables(ctx)
+	require.NoError(t, err)
+	var info, ok = discovery[sqlcapture.JoinStreamID("test", table)]
+	require.True(t, ok)
+
+	var scanned []string
+	var resumeKey []interface{}
+	for {
+		var events, err = db.ScanTableChunk(ctx, info, []string{"id"}, resumeKey)
+		require.NoError(t, err)
+		if len(events) == 0 {
+			break
+		}
+		require.LessOrEqual(t, len(events), backfillChunkSize)
+		for _, event := range events {
+			scanned = append(scanned, event.After["id"].(string))
+		}
+		resumeKey = []interface{}{events[len(events)-1].After["id"]}
+	}
+	require.Equal(t, expected, scanned)
+
+	// The whole capture agrees with the order in which the rows were backfilled.
+	var catalog, state = tests.ConfiguredCatalog(ctx, t, tb, table), sqlcapture.PersistentState{}
+	var output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.NotContains(t, output, "primary key ordering failure")
+	for _, id := range expected {
+		require.Equal(t, 1, strings.Count(output, fmt.Sprintf(`"id":%q`, id)))
+	}
+
+	// Ordering by the collation instead is inconsistent with how keys are compared.
+	tb.cfg.Advanced.StringKeyOrdering = stringKeyOrderingCollation
+	events, err := db.ScanTableChunk(ctx, info, []string{"id"}, nil)
+	require.NoError(t, err)
+	var collated []string
+	for _, event := range events {
+		collated = append(collated, event.After["id"].(string))
+	}
+	require.False(t, sort.StringsAreSorted(collated))
+}
+
+// TestPeriodicSnapshot checks that a table whose changes aren't in the binlog is captured
+// by periodically snapshotting it, and that only the differences between successive
+// snapshots are emitted.
+func TestPeriodicSnapshot(t *testing.T) {
+	var tb, ctx = &mysqlTestBackend{conn: TestBackend.conn, cfg: TestBackend.cfg}, context.Background()
+	var table = tb.CreateTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
+	var rows [][]interface{}
+	for i := 0; i < 40; i++ {
+		rows = append(rows, []interface{}{i, fmt.Sprintf("row %d", i)})
+	}
+	tb.Insert(ctx, t, table, rows)
+
+	var streamID = sqlcapture.JoinStreamID("test", table)
+	tb.cfg.Advanced.PeriodicSnapshotTables = streamID
+	var catalog = tests.ConfiguredCatalog(ctx, t, tb, table)
+	var state = sqlcapture.PersistentState{}
+	var count = func(output string, op sqlcapture.ChangeOp) int {
+		return strings.Count(output, fmt.Sprintf(`"op":%q`, op))
+	}
+
+	// The initial snapshot captures every row of the table.
+	var output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.Equal(t, len(rows), count(output, sqlcapture.InsertOp))
+	require.Equal(t, sqlcapture.TableModePeriodicSnapshot, state.Streams[streamID].Mode)
+	require.Len(t, state.Streams[streamID].Digests, len(rows))
+
+	// The table produces no replication events, so its changes go unobserved until the
+	// next snapshot is due.
+	tb.Insert(ctx, t, table, [][]interface{}{{40, "added"}})
+	tb.Update(ctx, t, table, "id", 5, "data", "updated")
+	tb.Delete(ctx, t, table, "id", 20)
+	tb.Delete(ctx, t, table, "id", 39)
+	output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.NotContains(t, output, `"op":`)
+
+	// Once it's due, only the rows which were added, updated, or deleted are emitted,
+	// including a deletion at the end of the table which no chunk of the scan covers.
+	tb.cfg.Advanced.RefreshInterval = 1
+	time.Sleep(1100 * time.Millisecond)
+	output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.Equal(t, 1, count(output, sqlcapture.InsertOp))
+	require.Equal(t, 1, count(output, sqlcapture.UpdateOp))
+	require.Equal(t, 2, count(output, sqlcapture.DeleteOp))
+	require.Contains(t, output, `"data":"added"`)
+	require.Contains(t, output, `"data":"updated"`)
+	require.Contains(t, output, `"id":20`)
+	require.Contains(t, output, `"id":39`)
+	require.NotContains(t, output, `"data":"row 1"`)
+	require.Len(t, state.Streams[streamID].Digests, len(rows)-1)
+}
+
+// TestPeriodicSnapshotLimit checks that the state of a table captured by periodic snapshots
+// stays bounded, since the capture fails once the table has more rows than the configured limit.
+func TestPeriodicSnapshotLimit(t *testing.T) {
+	var tb, ctx = &mysqlTestBackend{conn: TestBackend.conn, cfg: TestBackend.cfg}, context.Background()
+	tb.cfg.Advanced.PeriodicSnapshotMaxRows = 50
+	var table = tb.CreateTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
+	var rows [][]interface{}
+	for i := 0; i < tb.cfg.Advanced.PeriodicSnapshotMaxRows; i++ {
+		rows = append(rows, []interface{}{i, fmt.Sprintf("row %d", i)})
+	}
+	tb.Insert(ctx, t, table, rows)
+
+	var streamID = sqlcapture.JoinStreamID("test", table)
+	tb.cfg.Advanced.PeriodicSnapshotTables = streamID
+	tb.cfg.Advanced.RefreshInterval = 1
+	var catalog = tests.ConfiguredCatalog(ctx, t, tb, table)
+	var state = sqlcapture.PersistentState{}
+	var stateSize = func() int {
+		var bs, err = json.Marshal(state.Streams[streamID])
+		require.NoError(t, err)
+		return len(bs)
+	}
+
+	// A table with as many rows as the limit is captured, and its state is bounded by it.
+	var output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.Equal(t, len(rows), strings.Count(output, `"op":"c"`))
+	require.Len(t, state.Streams[streamID].Digests, len(rows))
+	require.Less(t, stateSize(), 100*tb.cfg.Advanced.PeriodicSnapshotMaxRows)
+
+	// Once the table grows past the limit, its next snapshot fails with an error naming the
+	// table, and the state doesn't grow past the limit.
+	var more [][]interface{}
+	for i := len(rows); i < len(rows)+30; i++ {
+		more = append(more, []interface{}{i, fmt.Sprintf("row %d", i)})
+	}
+	tb.Insert(ctx, t, table, more)
+	time.Sleep(1100 * time.Millisecond)
+	output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.Contains(t, output, fmt.Sprintf("table %q has more than 50 rows", streamID))
+	require.LessOrEqual(t, len(state.Streams[streamID].Digests), tb.cfg.Advanced.PeriodicSnapshotMaxRows)
+	require.Less(t, stateSize(), 100*tb.cfg.Advanced.PeriodicSnapshotMaxRows)
+}
+
+// TestBinlogMetadata checks that the 'binlog_metadata' option adds the binlog coordinates
+// of each event to its source metadata, and that the coordinates of replicated events
+// never go backwards.
+func TestBinlogMetadata(t *testing.T) {
+	var tb, ctx = &mysqlTestBackend{conn: TestBackend.conn, cfg: TestBackend.cfg}, context.Background()
+	var table = tb.CreateTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
+	tb.Insert(ctx, t, table, [][]interface{}{{1, "one"}, {2, "two"}})
+	tb.cfg.Advanced.BinlogMetadata = true
+	var catalog = tests.ConfiguredCatalog(ctx, t, tb, table)
+
+	var sources = func(output string) []mysqlSourceInfo {
+		var sources []mysqlSourceInfo
+		for _, line := range strings.Split(output, "\n") {
+			if !strings.Contains(line, `"type":"RECORD"`) {
+				continue
+			}
+			var msg struct {
+				Record struct {
+					Data struct {
+						Meta struct {
+							Source mysqlSourceInfo `json:"source"`
+						} `json:"_meta"`
+					} `json:"data"`
+				} `json:"record"`
+			}
+			require.NoError(t, json.Unmarshal([]byte(line), &msg))
+			sources = append(sources, msg.Record.Data.Meta.Source)
+		}
+		return sources
+	}
+
+	// Backfilled rows carry the position of the binlog when they were read.
+	var state = sqlcapture.PersistentState{}
+	var output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	var backfilled = sources(output)
+	require.Len(t, backfilled, 2)
+	for _, source := range backfilled {
+		require.True(t, source.Snapshot)
+		require.NotEmpty(t, source.BinlogFile)
+		require.NotZero(t, source.BinlogPos)
+		require.Empty(t, source.GTID)
+	}
+
+	tb.Insert(ctx, t, table, [][]interface{}{{3, "three"}})
+	tb.Update(ctx, t, table, "id", 1, "data", "ONE")
+	tb.Insert(ctx, t, table, [][]interface{}{{4, "four"}, {5, "five"}})
+	tb.Delete(ctx, t, table, "id", 2)
+	output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	var replicated = sources(output)
+	require.Len(t, replicated, 5)
+	var prev = backfilled[len(backfilled)-1]
+	for _, source := range replicated {
+		require.False(t, source.Snapshot)
+		require.NotEmpty(t, source.BinlogFile)
+		require.NotZero(t, source.BinlogPos)
+		if source.BinlogFile == prev.BinlogFile {
+			require.GreaterOrEqual(t, source.BinlogPos, prev.BinlogPos)
+		} else {
+			require.Greater(t, source.BinlogFile, prev.BinlogFile)
+		}
+		prev = source
+	}
+
+	// Without the option the coordinates are omitted.
+	tb.cfg.Advanced.BinlogMetadata = false
+	tb.Insert(ctx, t, table, [][]interface{}{{6, "six"}})
+	output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.Contains(t, output, `"data":"six"`)
+	require.NotContains(t, output, `"binlog_file"`)
+	require.NotContains(t, output, `"binlog_pos"`)
+}
+
+// TestBinlogPosition checks that state checkpoints describe the binlog position of their
+// cursor, and that it advances as changes are replicated.
+func TestBinlogPosition(t *testing.T) {
+	var tb, ctx = TestBackend, context.Background()
+	var table = tb.CreateTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
+	var catalog = tests.ConfiguredCatalog(ctx, t, tb, table)
+
+	var checkPosition = func(state sqlcapture.PersistentState) binlogPosition {
+		require.NotEmpty(t, state.Position)
+		var position binlogPosition
+		require.NoError(t, json.Unmarshal(state.Position, &position))
+		require.NotEmpty(t, position.BinlogFile)
+		require.NotZero(t, position.BinlogPos)
+		require.Equal(t, state.Cursor, fmt.Sprintf("%s:%d", position.BinlogFile, position.BinlogPos))
+		return position
+	}
+
+	var state = sqlcapture.PersistentState{}
+	tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	var first = checkPosition(state)
+
+	tb.Insert(ctx, t, table, [][]interface{}{{1, "one"}, {2, "two"}})
+	tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	var second = checkPosition(state)
+	if second.BinlogFile == first.BinlogFile {
+		require.Greater(t, second.BinlogPos, first.BinlogPos)
+	} else {
+		require.Greater(t, second.BinlogFile, first.BinlogFile)
+	}
+}
+
+func TestExecutedGTIDs(t *testing.T) {
+	var rs = &mysqlReplicationStream{}
+	require.NoError(t, rs.observePreviousGTIDs(""))
+	require.Nil(t, rs.executedGTIDs)
+	require.NoError(t, rs.observePreviousGTIDs("3e11fa47-71ca-11e1-9e33-c80aa9429562:1-5"))
+	require.NoError(t, rs.observePreviousGTIDs("3e11fa47-71ca-11e1-9e33-c80aa9429562:1-7"))
+
+	rs.gtid = "3e11fa47-71ca-11e1-9e33-c80aa9429562:8"
+	require.NoError(t, rs.executedGTIDs.Update(rs.gtid))
+	var position binlogPosition
+	require.NoError(t, json.Unmarshal(rs.position(mysql.Position{Name: "binlog.000002", Pos: 1234}), &position))
+	require.Equal(t, binlogPosition{
+		BinlogFile:    "binlog.000002",
+		BinlogPos:     1234,
+		LastGTID:      "3e11fa47-71ca-11e1-9e33-c80aa9429562:8",
+		ExecutedGTIDs: "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-8",
+	}, position)
+}
+
+func TestGTIDCursorFormat(t *testing.T) {
+	require.True(t, isGTIDCursor("3e11fa47-71ca-11e1-9e33-c80aa9429562:1-8"))
+	require.True(t, isGTIDCursor("3e11fa47-71ca-11e1-9e33-c80aa9429562:1-8,4e11fa47-71ca-11e1-9e33-c80aa9429562:1-3"))
+	require.False(t, isGTIDCursor("binlog.000002:1234"))
+	require.False(t, isGTIDCursor("mysql-bin-changelog.000123:4567"))
+
+	var pos = mysql.Position{Name: "binlog.000002", Pos: 1234}
+	var rs = &mysqlReplicationStream{gtidCursor: true}
+	require.Equal(t, "binlog.000002:1234", rs.flushCursor(pos))
+	require.NoError(t, rs.observePreviousGTIDs("3e11fa47-71ca-11e1-9e33-c80aa9429562:1-7"))
+	require.NoError(t, rs.executedGTIDs.Update("3e11fa47-71ca-11e1-9e33-c80aa9429562:8"))
+	require.Equal(t, "3e11fa47-71ca-11e1-9e33-c80aa9429562:1-8", rs.flushCursor(pos))
+	rs.gtidCursor = false
+	require.Equal(t, "binlog.000002:1234", rs.flushCursor(pos))
+}
+
+// TestGTIDCursor checks that with the 'gtid_cursor' option the capture checkpoints the
+// executed GTID set as its cursor and resumes from it, or else that it fails clearly if
+// the test database isn't in GTID mode.
+func TestGTIDCursor(t *testing.T) {
+	var tb, ctx = &mysqlTestBackend{conn: TestBackend.conn, cfg: TestBackend.cfg}, context.Background()
+	tb.cfg.Advanced.GTIDCursor = true
+
+	var results, err = tb.conn.Execute("SELECT @@GLOBAL.gtid_mode;")
+	require.NoError(t, err)
+	var gtidMode = string(results.Values[0][0].AsString())
+	results.Close()
+	if gtidMode != "ON" {
+		var db = &mysqlDatabase{config: &tb.cfg}
+		err = db.Connect(ctx)
+		require.Error(t, err)
+		require.Contains(t, err.Error(), "requires GTID mode")
+		return
+	}
+
+	var table = tb.CreateTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
+	var catalog = tests.ConfiguredCatalog(ctx, t, tb, table)
+	var state = sqlcapture.PersistentState{}
+	tests.PerformCapture(ctx, t, tb, &catalog, &state)
+
+	tb.Insert(ctx, t, table, [][]interface{}{{1, "one"}, {2, "two"}})
+	var output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.Contains(t, output, `"data":"two"`)
+	require.True(t, isGTIDCursor(state.Cursor), "cursor %q should be a GTID set", state.Cursor)
+	var position binlogPosition
+	require.NoError(t, json.Unmarshal(state.Position, &position))
+	require.Equal(t, position.ExecutedGTIDs, state.Cursor)
+
+	// Resuming from the GTID set captures only the changes which come after it.
+	tb.Insert(ctx, t, table, [][]interface{}{{3, "three"}})
+	output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.Contains(t, output, `"data":"three"`)
+	require.NotContains(t, output, `"data":"two"`)
+	require.True(t, isGTIDCursor(state.Cursor))
+}
+
+func TestFormatGTID(t *testing.T) {
+	var sid = []byte{0x3e, 0x11, 0xfa, 0x47, 0x71, 0xca, 0x11, 0xe1, 0x9e, 0x33, 0xc8, 0x0a, 0xa9, 0x42, 0x95, 0x62}
+	require.Equal(t, "3e11fa47-71ca-11e1-9e33-c80aa9429562:23", formatGTID(sid, 23))
+	require.Equal(t, "", formatGTID(make([]byte, 16), 1))
+	require.Equal(t, "", formatGTID(nil, 0))
+}
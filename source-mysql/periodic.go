@@ -0,0 +1,101 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"github.com/estuary/connectors/sqlcapture"
+	"github.com/go-mysql-org/go-mysql/client"
+)
+
+// binlogFilter describes the databases whose changes are written to the binlog, according
+// to the server's `binlog-do-db` and `binlog-ignore-db` options. Since the binlog is in the
+// ROW format, they apply to the database of each changed table.
+type binlogFilter struct {
+	doDBs     map[string]bool
+	ignoreDBs map[string]bool
+}
+
+// queryBinlogFilter returns the binlog filter of the server, as reported by the
+// `Binlog_Do_DB` and `Binlog_Ignore_DB` columns of `SHOW MASTER STATUS`.
+func queryBinlogFilter(conn *client.Conn) (*binlogFilter, error) {
+	var results, err = conn.Execute("SHOW MASTER STATUS;")
+	if err != nil {
+		return nil, err
+	}
+	defer results.Close()
+
+	var filter = &binlogFilter{doDBs: make(map[string]bool), ignoreDBs: make(map[string]bool)}
+	if len(results.Values) == 0 {
+		return filter, nil
+	}
+	for idx, field := range results.Fields {
+		var dbs map[string]bool
+		switch string(field.Name) {
+		case "Binlog_Do_DB":
+			dbs = filter.doDBs
+		case "Binlog_Ignore_DB":
+			dbs = filter.ignoreDBs
+		default:
+			continue
+		}
+		for _, name := range strings.Split(string(results.Values[0][idx].AsString()), ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				dbs[name] = true
+			}
+		}
+	}
+	return filter, nil
+}
+
+// logged returns true if the changes of tables in the database are written to the binlog.
+// A nil filter logs every database.
+func (f *binlogFilter) logged(schema string) bool {
+	if f == nil {
+		return true
+	} else if len(f.doDBs) > 0 {
+		return f.doDBs[schema]
+	}
+	return !f.ignoreDBs[schema]
+}
+
+// unloggedSchemas are the system schemas whose tables are computed by the server, and so
+// are never written to the binlog.
+var unloggedSchemas = map[string]bool{
+	"information_schema": true,
+	"performance_schema": true,
+}
+
+// PeriodicSnapshot returns true for tables which are listed in `periodic_snapshot_tables`,
+// and for those in databases which are excluded from the binlog or are never written to
+// it, since no replication events will ever be received for them.
+func (db *mysqlDatabase) PeriodicSnapshot(info sqlcapture.TableInfo) bool {
+	var streamID = sqlcapture.JoinStreamID(info.Schema, info.Name)
+	if db.config.Advanced.PeriodicSnapshotTables != "" {
+		for _, snapshotStreamID := range strings.Split(db.config.Advanced.PeriodicSnapshotTables, ",") {
+			if strings.EqualFold(streamID, snapshotStreamID) {
+				return true
+			}
+		}
+	}
+	return unloggedSchemas[strings.ToLower(info.Schema)] || !db.binlogFilter.logged(info.Schema)
+}
+
+func (db *mysqlDatabase) SnapshotSource(info sqlcapture.TableInfo) sqlcapture.SourceMetadata {
+	return &mysqlSourceInfo{
+		SourceCommon: sqlcapture.SourceCommon{
+			Millis:   0, // Not known.
+			Schema:   info.Schema,
+			Snapshot: true,
+			Table:    info.Name,
+		},
+	}
+}
+
+func (db *mysqlDatabase) MaxPeriodicSnapshotRows() int {
+	return db.config.Advanced.PeriodicSnapshotMaxRows
+}
+
+func (db *mysqlDatabase) FullRefreshInterval() time.Duration {
+	return time.Duration(db.config.Advanced.RefreshInterval) * time.Second
+}
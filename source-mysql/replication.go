@@ -50,16 +50,51 @@ func (db *mysqlDatabase) StartReplication(ctx context.Context, startCursor strin
 		Password: db.config.Password,
 		// TODO(wgd): Maybe add 'serverName' checking as described over in Connect()
 		TLSConfig: &tls.Config{InsecureSkipVerify: true},
+		// Report TIMESTAMP values in UTC, to match the session time zone used for backfills.
+		TimestampStringLocation: time.UTC,
 	})
 
 	var pos mysql.Position
-	if startCursor != "" {
+	var gtidSet, executedGTIDs mysql.GTIDSet
+	if startCursor != "" && isGTIDCursor(startCursor) {
+		// Resume from the GTID set of the last commit, which doesn't depend on the binlog
+		// coordinates of the server and so still works after failover to a replica.
+		gtidSet, err = mysql.ParseGTIDSet(mysql.MySQLFlavor, startCursor)
+		if err != nil {
+			return nil, fmt.Errorf("invalid resume cursor: %w", err)
+		}
+		if err := db.checkGTIDsRetained(gtidSet); err != nil {
+			return nil, err
+		}
+	} else if startCursor != "" {
 		var binlogName, binlogPos, err = splitCursor(startCursor)
 		if err != nil {
 			return nil, fmt.Errorf("invalid resume cursor: %w", err)
 		}
 		pos.Name = binlogName
 		pos.Pos = uint32(binlogPos)
+	} else if db.config.Advanced.StartGTIDSet != "" {
+		// Start from the user-specified GTID set, after making sure that the server
+		// still has all the transactions which come after it.
+		gtidSet, err = mysql.ParseGTIDSet(mysql.MySQLFlavor, db.config.Advanced.StartGTIDSet)
+		if err != nil {
+			return nil, fmt.Errorf("invalid start GTID set: %w", err)
+		}
+		if err := db.checkGTIDsRetained(gtidSet); err != nil {
+			return nil, err
+		}
+	} else if db.config.Advanced.StartPosition != "" {
+		// Start from the user-specified binlog position, after making sure that
+		// the server still has that binlog file.
+		var binlogName, binlogPos, err = splitCursor(db.config.Advanced.StartPosition)
+		if err != nil {
+			return nil, fmt.Errorf("invalid start position: %w", err)
+		}
+		pos.Name = binlogName
+		pos.Pos = uint32(binlogPos)
+		if err := db.checkBinlogRetained(pos); err != nil {
+			return nil, err
+		}
 	} else {
 		// Get the initial binlog cursor from the source database's latest binlog position
 		var results, err = db.conn.Execute("SHOW MASTER STATUS;")
@@ -73,13 +108,35 @@ func (db *mysqlDatabase) StartReplication(ctx context.Context, startCursor strin
 		pos.Name = string(row[0].AsString())
 		pos.Pos = uint32(row[1].AsInt64())
 		logrus.WithField("pos", pos).Debug("initialized binlog position")
+
+		// When resuming by GTIDs the executed GTID set as of the initial position must be
+		// known, since otherwise it wouldn't be until the server begins the next binlog file.
+		if db.config.Advanced.GTIDCursor && len(row) > 4 {
+			if executedGTIDs, err = mysql.ParseMysqlGTIDSet(string(row[4].AsString())); err != nil {
+				return nil, fmt.Errorf("error parsing executed GTID set: %w", err)
+			}
+		}
+	}
+
+	if gtidSet != nil {
+		// The GTID set from which replication starts is the set of transactions executed
+		// before the first one it receives.
+		executedGTIDs = gtidSet.Clone()
 	}
 
-	logrus.WithFields(logrus.Fields{"pos": pos}).Info("starting replication")
-	streamer, err := syncer.StartSync(pos)
+	var streamer *replication.BinlogStreamer
+	if gtidSet != nil {
+		logrus.WithFields(logrus.Fields{"gtids": gtidSet.String()}).Info("starting replication")
+		streamer, err = syncer.StartSyncGTID(gtidSet)
+	} else {
+		logrus.WithFields(logrus.Fields{"pos": pos}).Info("starting replication")
+		streamer, err = syncer.StartSync(pos)
+	}
 	if err != nil {
+		syncer.Close()
 		return nil, fmt.Errorf("error starting binlog sync: %w", err)
 	}
+	sqlcapture.ConnectionOpened("replication")
 
 	var streamCtx, streamCancel = context.WithCancel(ctx)
 	var stream = &mysqlReplicationStream{
@@ -88,6 +145,14 @@ func (db *mysqlDatabase) StartReplication(ctx context.Context, startCursor strin
 		events:   make(chan sqlcapture.ChangeEvent, replicationBufferSize),
 		cancel:   streamCancel,
 		errCh:    make(chan error),
+
+		binlogFile:     pos.Name,
+		executedGTIDs:  executedGTIDs,
+		gtidCursor:     db.config.Advanced.GTIDCursor,
+		binlogMetadata: db.config.Advanced.BinlogMetadata,
+		serverTimezone: db.serverTimezone,
+		formats:        newValueFormats(&db.config.Advanced),
+		valueLimit:     newValueSizeLimit(&db.config.Advanced),
 	}
 	stream.tables.active = activeTables
 	stream.tables.discovery = discovery
@@ -98,6 +163,7 @@ func (db *mysqlDatabase) StartReplication(ctx context.Context, startCursor strin
 			err = nil
 		}
 		syncer.Close()
+		sqlcapture.ConnectionClosed("replication")
 		close(stream.events)
 		stream.errCh <- err
 	}()
@@ -105,6 +171,42 @@ func (db *mysqlDatabase) StartReplication(ctx context.Context, startCursor strin
 	return stream, nil
 }
 
+// checkBinlogRetained verifies that the binlog file named in pos is still present on
+// the server and that the position lies within it.
+func (db *mysqlDatabase) checkBinlogRetained(pos mysql.Position) error {
+	var results, err = db.conn.Execute("SHOW BINARY LOGS;")
+	if err != nil {
+		return fmt.Errorf("error listing binlog files: %w", err)
+	}
+	defer results.Close()
+
+	for _, row := range results.Values {
+		if string(row[0].AsString()) != pos.Name {
+			continue
+		}
+		if size := row[1].AsInt64(); int64(pos.Pos) > size {
+			return fmt.Errorf("start position %s:%d is past the end of binlog file %q (size %d)", pos.Name, pos.Pos, pos.Name, size)
+		}
+		return nil
+	}
+	return fmt.Errorf("start position %s:%d is no longer available: binlog file %q is not retained on the server", pos.Name, pos.Pos, pos.Name)
+}
+
+// checkGTIDsRetained verifies that none of the transactions which come after the
+// given GTID set have been purged from the server's binlogs.
+func (db *mysqlDatabase) checkGTIDsRetained(gtidSet mysql.GTIDSet) error {
+	var results, err = db.conn.Execute("SELECT GTID_SUBSET(@@GLOBAL.gtid_purged, ?);", gtidSet.String())
+	if err != nil {
+		return fmt.Errorf("error checking purged GTIDs: %w", err)
+	}
+	defer results.Close()
+
+	if len(results.Values) == 0 || results.Values[0][0].AsInt64() != 1 {
+		return fmt.Errorf("start GTID set %q is no longer available: the server has purged transactions which come after it", gtidSet.String())
+	}
+	return nil
+}
+
 func splitCursor(cursor string) (string, int64, error) {
 	seps := strings.Split(cursor, ":")
 	if len(seps) != 2 {
@@ -135,7 +237,16 @@ type mysqlReplicationStream struct {
 	events        chan sqlcapture.ChangeEvent
 	cancel        context.CancelFunc
 	errCh         chan error
-	gtidTimestamp time.Time // The OriginalCommitTimestamp value of the last GTID Event
+	gtidTimestamp time.Time     // The OriginalCommitTimestamp value of the last GTID Event
+	gtid          string        // The GTID of the last GTID Event, if it wasn't anonymous
+	binlogFile    string        // The name of the current binlog file, from the last Rotate Event
+	executedGTIDs mysql.GTIDSet // The GTIDs of all transactions up to the current event, if known
+
+	gtidCursor     bool            // Whether commit cursors are the executed GTID set when it's known
+	binlogMetadata bool            // Whether change events carry their binlog file, position, and GTID
+	serverTimezone string          // The server's time zone, which is recorded in table metadata
+	formats        valueFormats    // The formats in which spatial and unsigned values are captured
+	valueLimit     *valueSizeLimit // The limit on the size of TEXT and BLOB values, if any
 
 	// The active tables set and associated metadata, guarded by a
 	// mutex so it can be modified from the main goroutine while it's
@@ -151,6 +262,11 @@ type mysqlReplicationStream struct {
 
 type mysqlTableMetadata struct {
 	Schema mysqlTableSchema `json:"schema"`
+
+	// ServerTimezone is the time zone the server was using when capture of the table began.
+	// TIMESTAMP values are always captured in UTC so this doesn't affect them, but it allows
+	// us to notice and log when the server's time zone changes during a capture.
+	ServerTimezone string `json:"server_timezone,omitempty"`
 }
 
 type mysqlTableSchema struct {
@@ -221,6 +337,11 @@ func (rs *mysqlReplicationStream) run(ctx context.Context) error {
 					Table:  table,
 				},
 			}
+			if rs.binlogMetadata {
+				sourceMeta.BinlogFile = rs.binlogFile
+				sourceMeta.BinlogPos = event.Header.LogPos
+				sourceMeta.GTID = rs.gtid
+			}
 
 			// Get column names and types from persistent metadata. If available, allow
 			// override the persistent column name tracking using binlog row metadata.
@@ -229,6 +350,7 @@ func (rs *mysqlReplicationStream) run(ctx context.Context) error {
 				return fmt.Errorf("missing metadata for stream %q", streamID)
 			}
 			var columnTypes = metadata.Schema.ColumnTypes
+			var keyColumns = rs.keyColumns(streamID)
 			var columnNames = data.Table.ColumnNameString()
 			if len(columnNames) == 0 {
 				columnNames = metadata.Schema.Columns
@@ -241,12 +363,16 @@ func (rs *mysqlReplicationStream) run(ctx context.Context) error {
 					if err != nil {
 						return fmt.Errorf("error decoding row values: %w", err)
 					}
-					if err := translateRecordFields(columnTypes, after); err != nil {
+					if err := translateRecordFields(columnTypes, rs.formats, after); err != nil {
 						return fmt.Errorf("error translating 'after' of %q InsertOp: %w", streamID, err)
 					}
+					truncated, err := rs.valueLimit.apply(columnTypes, keyColumns, after)
+					if err != nil {
+						return fmt.Errorf("error limiting 'after' of %q InsertOp: %w", streamID, err)
+					}
 					rs.events <- sqlcapture.ChangeEvent{
 						Operation: sqlcapture.InsertOp,
-						Source:    sourceMeta,
+						Source:    sourceMeta.withTruncated(truncated),
 						After:     after,
 					}
 				}
@@ -262,15 +388,23 @@ func (rs *mysqlReplicationStream) run(ctx context.Context) error {
 						if err != nil {
 							return fmt.Errorf("error decoding row values: %w", err)
 						}
-						if err := translateRecordFields(columnTypes, before); err != nil {
+						if err := translateRecordFields(columnTypes, rs.formats, before); err != nil {
 							return fmt.Errorf("error translating 'before' of %q UpdateOp: %w", streamID, err)
 						}
-						if err := translateRecordFields(columnTypes, after); err != nil {
+						if err := translateRecordFields(columnTypes, rs.formats, after); err != nil {
 							return fmt.Errorf("error translating 'after' of %q UpdateOp: %w", streamID, err)
 						}
+						truncatedBefore, err := rs.valueLimit.apply(columnTypes, keyColumns, before)
+						if err != nil {
+							return fmt.Errorf("error limiting 'before' of %q UpdateOp: %w", streamID, err)
+						}
+						truncatedAfter, err := rs.valueLimit.apply(columnTypes, keyColumns, after)
+						if err != nil {
+							return fmt.Errorf("error limiting 'after' of %q UpdateOp: %w", streamID, err)
+						}
 						rs.events <- sqlcapture.ChangeEvent{
 							Operation: sqlcapture.UpdateOp,
-							Source:    sourceMeta,
+							Source:    sourceMeta.withTruncated(truncatedBefore, truncatedAfter),
 							Before:    before,
 							After:     after,
 						}
@@ -282,12 +416,16 @@ func (rs *mysqlReplicationStream) run(ctx context.Context) error {
 					if err != nil {
 						return fmt.Errorf("error decoding row values: %w", err)
 					}
-					if err := translateRecordFields(columnTypes, before); err != nil {
+					if err := translateRecordFields(columnTypes, rs.formats, before); err != nil {
 						return fmt.Errorf("error translating 'before' of %q DeleteOp: %w", streamID, err)
 					}
+					truncated, err := rs.valueLimit.apply(columnTypes, keyColumns, before)
+					if err != nil {
+						return fmt.Errorf("error limiting 'before' of %q DeleteOp: %w", streamID, err)
+					}
 					rs.events <- sqlcapture.ChangeEvent{
 						Operation: sqlcapture.DeleteOp,
-						Source:    sourceMeta,
+						Source:    sourceMeta.withTruncated(truncated),
 						Before:    before,
 					}
 				}
@@ -303,7 +441,8 @@ func (rs *mysqlReplicationStream) run(ctx context.Context) error {
 			rs.events <- sqlcapture.ChangeEvent{
 				Operation: sqlcapture.FlushOp,
 				Source: &mysqlSourceInfo{
-					FlushCursor: fmt.Sprintf("%s:%d", cursor.Name, cursor.Pos),
+					FlushCursor: rs.flushCursor(cursor),
+					position:    rs.position(cursor),
 				},
 			}
 		case *replication.TableMapEvent:
@@ -311,14 +450,24 @@ func (rs *mysqlReplicationStream) run(ctx context.Context) error {
 		case *replication.GTIDEvent:
 			logrus.WithField("data", data).Trace("GTID Event")
 			rs.gtidTimestamp = data.OriginalCommitTime()
+			rs.gtid = formatGTID(data.SID, data.GNO)
+			if rs.executedGTIDs != nil && rs.gtid != "" {
+				if err := rs.executedGTIDs.Update(rs.gtid); err != nil {
+					return fmt.Errorf("error updating executed GTID set: %w", err)
+				}
+			}
 		case *replication.PreviousGTIDsEvent:
 			logrus.WithField("gtids", data.GTIDSets).Trace("PreviousGTIDs Event")
+			if err := rs.observePreviousGTIDs(data.GTIDSets); err != nil {
+				return err
+			}
 		case *replication.QueryEvent:
 			if err := rs.handleQuery(string(data.Schema), string(data.Query)); err != nil {
 				return fmt.Errorf("error processing query event: %w", err)
 			}
 		case *replication.RotateEvent:
 			logrus.WithField("data", data).Trace("Rotate Event")
+			rs.binlogFile = string(data.NextLogName)
 		case *replication.FormatDescriptionEvent:
 			logrus.WithField("data", data).Trace("Format Description Event")
 		default:
@@ -384,6 +533,12 @@ func (rs *mysqlReplicationStream) handleQuery(schema, query string) error {
 		logrus.WithField("query", query).Trace("ignoring benign query")
 	case *sqlparser.AlterTable:
 		if streamID := resolveTableName(schema, stmt.Table); rs.tableActive(streamID) {
+			if len(stmt.AlterOptions) == 0 && stmt.PartitionSpec != nil {
+				return handlePartitionChange(streamID, stmt.PartitionSpec)
+			}
+			if rs.alterEnumColumns(streamID, stmt) {
+				return nil
+			}
 			return fmt.Errorf("unsupported operation ALTER TABLE on stream %q (go.estuary.dev/eVVwet)", streamID)
 		}
 	case *sqlparser.DropTable:
@@ -427,6 +582,46 @@ func (rs *mysqlReplicationStream) handleQuery(schema, query string) error {
 	return nil
 }
 
+// alterEnumColumns updates the persisted types of an active table's columns for an ALTER TABLE
+// statement which only changes the members of ENUM and SET columns, so that subsequent change
+// events are translated using the new members. It returns false for any other statement.
+func (rs *mysqlReplicationStream) alterEnumColumns(streamID string, stmt *sqlparser.AlterTable) bool {
+	rs.tables.Lock()
+	defer rs.tables.Unlock()
+	var metadata, ok = rs.tables.metadata[streamID]
+	if !ok || metadata == nil {
+		return false
+	}
+	altered, ok := alteredEnumTypes(stmt, metadata.Schema.ColumnTypes)
+	if !ok {
+		return false
+	}
+	for colName, colType := range altered {
+		logrus.WithFields(logrus.Fields{
+			"stream":   streamID,
+			"column":   colName,
+			"previous": metadata.Schema.ColumnTypes[colName],
+			"current":  colType,
+		}).Info("updating members of ENUM or SET column")
+		metadata.Schema.ColumnTypes[colName] = colType
+	}
+	rs.tables.dirtyMetadata = append(rs.tables.dirtyMetadata, streamID)
+	return true
+}
+
+// handlePartitionChange checks an ALTER TABLE statement which only changes the partitions of
+// an active table. Most partition maintenance just moves rows between partitions, which doesn't
+// change the contents of the logical table, but dropping, truncating, or exchanging partitions
+// adds or removes rows without any row events in the binlog.
+func handlePartitionChange(streamID string, spec *sqlparser.PartitionSpec) error {
+	switch spec.Action {
+	case sqlparser.DropAction, sqlparser.TruncateAction, sqlparser.ExchangeAction, sqlparser.DiscardAction, sqlparser.ImportAction:
+		return fmt.Errorf("unsupported partition operation on stream %q (go.estuary.dev/eVVwet)", streamID)
+	}
+	logrus.WithField("stream", streamID).Info("ignoring partition maintenance of captured table")
+	return nil
+}
+
 func resolveTableName(defaultSchema string, name sqlparser.TableName) string {
 	var schema, table = name.Qualifier.String(), name.Name.String()
 	if schema == "" {
@@ -442,6 +637,13 @@ func (rs *mysqlReplicationStream) tableMetadata(streamID string) (*mysqlTableMet
 	return meta, ok
 }
 
+// keyColumns returns the primary key columns of the table, as of when it was discovered.
+func (rs *mysqlReplicationStream) keyColumns(streamID string) []string {
+	rs.tables.RLock()
+	defer rs.tables.RUnlock()
+	return rs.tables.discovery[streamID].PrimaryKey
+}
+
 func (rs *mysqlReplicationStream) tableActive(streamID string) bool {
 	rs.tables.RLock()
 	defer rs.tables.RUnlock()
@@ -458,14 +660,26 @@ func (rs *mysqlReplicationStream) ActivateTable(streamID string) error {
 	// keep the control flow simpler.
 	rs.tables.active[streamID] = struct{}{}
 
-	// Do nothing if metadata is already initialized for this stream.
+	// Do nothing if metadata is already initialized for this stream, except to
+	// record the server time zone if it's missing and warn if it has changed.
 	if metadata := rs.tables.metadata[streamID]; metadata != nil {
+		if metadata.ServerTimezone == "" {
+			metadata.ServerTimezone = rs.serverTimezone
+			rs.tables.dirtyMetadata = append(rs.tables.dirtyMetadata, streamID)
+		} else if metadata.ServerTimezone != rs.serverTimezone {
+			logrus.WithFields(logrus.Fields{
+				"stream":   streamID,
+				"previous": metadata.ServerTimezone,
+				"current":  rs.serverTimezone,
+			}).Warn("server time zone has changed since capture began (TIMESTAMP values are captured in UTC and are unaffected)")
+		}
+		rs.reconcileColumnTypes(streamID, metadata)
 		return nil
 	}
 
 	// Otherwise construct new metadata based on discovery info.
 	logrus.WithField("stream", streamID).Debug("initializing table metadata")
-	var metadata = new(mysqlTableMetadata)
+	var metadata = &mysqlTableMetadata{ServerTimezone: rs.serverTimezone}
 	if discovery, ok := rs.tables.discovery[streamID]; ok {
 		var colTypes = make(map[string]string)
 		for colName, colInfo := range discovery.Columns {
@@ -486,6 +700,53 @@ func (rs *mysqlReplicationStream) ActivateTable(streamID string) error {
 	return nil
 }
 
+// reconcilableTypes are pairs of column data types which the persisted type of a column
+// is updated between, because they're reported by discovery for the same MySQL type.
+var reconcilableTypes = [][2]string{
+	{"tinyint", booleanDataType},
+	{"bigint", unsignedBigintDataType},
+}
+
+// reconcileColumnTypes updates the persisted type of any TINYINT(1) columns to match
+// the current discovery info, so that toggling the 'tinyint1_as_bool' option on an
+// existing capture applies to replicated changes just as it does to the schema. The
+// persisted type of BIGINT UNSIGNED columns of captures which began before they were
+// distinguished from BIGINT columns is updated in the same way, as is the bare "enum"
+// or "set" type of columns persisted before their members were. The caller must hold
+// the tables lock.
+func (rs *mysqlReplicationStream) reconcileColumnTypes(streamID string, metadata *mysqlTableMetadata) {
+	var discovery, ok = rs.tables.discovery[streamID]
+	if !ok {
+		return
+	}
+	var changed bool
+	for colName, colInfo := range discovery.Columns {
+		var persisted, ok = metadata.Schema.ColumnTypes[colName]
+		if !ok || persisted == colInfo.DataType {
+			continue
+		}
+		var reconcilable = persisted == enumBaseType(colInfo.DataType)
+		for _, pair := range reconcilableTypes {
+			if (persisted == pair[0] && colInfo.DataType == pair[1]) || (persisted == pair[1] && colInfo.DataType == pair[0]) {
+				reconcilable = true
+			}
+		}
+		if reconcilable {
+			logrus.WithFields(logrus.Fields{
+				"stream":   streamID,
+				"column":   colName,
+				"previous": persisted,
+				"current":  colInfo.DataType,
+			}).Info("updating persisted column type")
+			metadata.Schema.ColumnTypes[colName] = colInfo.DataType
+			changed = true
+		}
+	}
+	if changed {
+		rs.tables.dirtyMetadata = append(rs.tables.dirtyMetadata, streamID)
+	}
+}
+
 func (rs *mysqlReplicationStream) Events() <-chan sqlcapture.ChangeEvent {
 	return rs.events
 }
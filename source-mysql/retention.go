@@ -0,0 +1,65 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// getBinlogExpiry returns the binlog retention period of the server, according to the
+// server variables read by queryVariable, which returns the integer value of the second
+// column of the first row of a query.
+func getBinlogExpiry(queryVariable func(query string) (int64, error)) (time.Duration, error) {
+	// When running on Amazon RDS MySQL there's an RDS-specific configuration
+	// for binlog retention, so that takes precedence if it exists.
+	rdsRetentionHours, err := queryVariable(`SELECT name, value FROM mysql.rds_configuration WHERE name = 'binlog retention hours';`)
+	if err == nil {
+		return time.Duration(rdsRetentionHours) * time.Hour, nil
+	}
+
+	// The new 'binlog_expire_logs_seconds' variable takes priority
+	expireLogsSeconds, err := queryVariable(`SHOW VARIABLES LIKE 'binlog_expire_logs_seconds';`)
+	if err != nil {
+		return 0, err
+	}
+	if expireLogsSeconds > 0 {
+		return time.Duration(expireLogsSeconds) * time.Second, nil
+	}
+
+	// However 'expire_logs_days' will be used instead if 'seconds' was zero
+	expireLogsDays, err := queryVariable(`SHOW VARIABLES LIKE 'expire_logs_days';`)
+	if err != nil {
+		return 0, err
+	}
+	if expireLogsDays > 0 {
+		return time.Duration(expireLogsDays) * 24 * time.Hour, nil
+	}
+
+	// If both 'binlog_expire_logs_seconds' and 'expire_logs_days' are set to zero
+	// MySQL will not automatically purge binlog segments. For simplicity we just
+	// represent that as a 'one year' expiry time, since all we need the value for
+	// is to make sure it's not too short.
+	return 365 * 24 * time.Hour, nil
+}
+
+// checkBinlogRetention reports the binlog retention period of the server, and fails if
+// it's shorter than minimumExpiryTime. A capture which is down for longer than the
+// retention period can't resume replication, so a warning is logged if it's shorter
+// than the warning threshold, which is the downtime the operator should plan to cover.
+func checkBinlogRetention(expiryTime, warningTime time.Duration) error {
+	if expiryTime < minimumExpiryTime {
+		return fmt.Errorf("binlog retention period is too short (go.estuary.dev/PoMlNf): server reports %s but at least %s is required (and 30 days is preferred wherever possible)", expiryTime.String(), minimumExpiryTime.String())
+	}
+	var fields = logrus.Fields{
+		"event":     "binlog_retention",
+		"retention": expiryTime.String(),
+		"threshold": warningTime.String(),
+	}
+	if expiryTime < warningTime {
+		logrus.WithFields(fields).Warn("binlog retention period is shorter than the warning threshold: a capture which is down for longer than this will have to be backfilled again, so consider increasing the retention period of the server")
+	} else {
+		logrus.WithFields(fields).Info("binlog retention period")
+	}
+	return nil
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Spatial values may be captured either as WKT strings or as GeoJSON strings.
+const (
+	spatialFormatWKT     = "wkt"
+	spatialFormatGeoJSON = "geojson"
+)
+
+// spatialDataTypes are the data types of MySQL spatial columns.
+var spatialDataTypes = map[string]bool{
+	"geometry":           true,
+	"point":              true,
+	"linestring":         true,
+	"polygon":            true,
+	"multipoint":         true,
+	"multilinestring":    true,
+	"multipolygon":       true,
+	"geometrycollection": true,
+	"geomcollection":     true, // MySQL 8.0 reports GEOMETRYCOLLECTION columns under this name.
+}
+
+// WKB geometry type codes.
+const (
+	wkbPoint              = 1
+	wkbLineString         = 2
+	wkbPolygon            = 3
+	wkbMultiPoint         = 4
+	wkbMultiLineString    = 5
+	wkbMultiPolygon       = 6
+	wkbGeometryCollection = 7
+)
+
+// geometry is a decoded spatial value. Which fields are set depends on its type.
+type geometry struct {
+	kind     uint32
+	point    [2]float64     // Coordinates of a Point.
+	points   [][2]float64   // Coordinates of a LineString.
+	rings    [][][2]float64 // Coordinate rings of a Polygon.
+	children []geometry     // Members of a MultiPoint, MultiLineString, MultiPolygon, or GeometryCollection.
+}
+
+// translateSpatial converts a spatial value from the MySQL internal format, which is
+// returned by backfill queries and also appears in replicated row events, into the
+// configured format. The internal format is a little-endian 4-byte SRID followed by
+// the WKB encoding of the geometry.
+func translateSpatial(val []byte, format string) (string, error) {
+	if len(val) < 4 {
+		return "", fmt.Errorf("spatial value too short (%d bytes)", len(val))
+	}
+	var srid = binary.LittleEndian.Uint32(val[:4])
+	var r = &wkbReader{buf: val[4:]}
+	var geom, err = r.readGeometry(0)
+	if err != nil {
+		return "", fmt.Errorf("error decoding spatial value: %w", err)
+	} else if len(r.buf) != 0 {
+		return "", fmt.Errorf("error decoding spatial value: %d unexpected trailing bytes", len(r.buf))
+	}
+
+	if format == spatialFormatGeoJSON {
+		var obj = geom.geoJSON()
+		if srid != 0 {
+			obj["crs"] = map[string]interface{}{
+				"type":       "name",
+				"properties": map[string]interface{}{"name": fmt.Sprintf("EPSG:%d", srid)},
+			}
+		}
+		var bs, err = json.Marshal(obj)
+		if err != nil {
+			return "", fmt.Errorf("error encoding GeoJSON: %w", err)
+		}
+		return string(bs), nil
+	}
+
+	var wkt strings.Builder
+	if srid != 0 {
+		fmt.Fprintf(&wkt, "SRID=%d;", srid)
+	}
+	geom.writeWKT(&wkt, true)
+	return wkt.String(), nil
+}
+
+// maxGeometryDepth limits the nesting of geometry collections.
+const maxGeometryDepth = 32
+
+type wkbReader struct {
+	buf   []byte
+	order binary.ByteOrder
+}
+
+func (r *wkbReader) readGeometry(depth int) (geometry, error) {
+	if depth > maxGeometryDepth {
+		return geometry{}, fmt.Errorf("geometry collections nested too deeply")
+	}
+	if len(r.buf) < 1 {
+		return geometry{}, fmt.Errorf("unexpected end of data")
+	}
+	switch r.buf[0] {
+	case 0:
+		r.order = binary.BigEndian
+	case 1:
+		r.order = binary.LittleEndian
+	default:
+		return geometry{}, fmt.Errorf("invalid byte order %d", r.buf[0])
+	}
+	r.buf = r.buf[1:]
+
+	var kind, err = r.readUint32()
+	if err != nil {
+		return geometry{}, err
+	}
+	var geom = geometry{kind: kind}
+	switch kind {
+	case wkbPoint:
+		geom.point, err = r.readPoint()
+	case wkbLineString:
+		geom.points, err = r.readPoints()
+	case wkbPolygon:
+		var count uint32
+		if count, err = r.readCount(4); err != nil {
+			return geometry{}, err
+		}
+		for i := uint32(0); i < count; i++ {
+			var ring [][2]float64
+			if ring, err = r.readPoints(); err != nil {
+				return geometry{}, err
+			}
+			geom.rings = append(geom.rings, ring)
+		}
+	case wkbMultiPoint, wkbMultiLineString, wkbMultiPolygon, wkbGeometryCollection:
+		var count uint32
+		if count, err = r.readCount(5); err != nil {
+			return geometry{}, err
+		}
+		for i := uint32(0); i < count; i++ {
+			var child geometry
+			if child, err = r.readGeometry(depth + 1); err != nil {
+				return geometry{}, err
+			}
+			geom.children = append(geom.children, child)
+		}
+	default:
+		return geometry{}, fmt.Errorf("unsupported geometry type %d", kind)
+	}
+	return geom, err
+}
+
+func (r *wkbReader) readUint32() (uint32, error) {
+	if len(r.buf) < 4 {
+		return 0, fmt.Errorf("unexpected end of data")
+	}
+	var n = r.order.Uint32(r.buf)
+	r.buf = r.buf[4:]
+	return n, nil
+}
+
+// readCount reads the number of elements of a geometry, checking it against the amount of
+// data remaining given the minimum size of each element so that a corrupt count can't cause
+// a huge allocation.
+func (r *wkbReader) readCount(minSize int) (uint32, error) {
+	var count, err = r.readUint32()
+	if err != nil {
+		return 0, err
+	}
+	if uint64(count)*uint64(minSize) > uint64(len(r.buf)) {
+		return 0, fmt.Errorf("element count %d exceeds remaining data", count)
+	}
+	return count, nil
+}
+
+func (r *wkbReader) readPoint() ([2]float64, error) {
+	if len(r.buf) < 16 {
+		return [2]float64{}, fmt.Errorf("unexpected end of data")
+	}
+	var x = math.Float64frombits(r.order.Uint64(r.buf[0:8]))
+	var y = math.Float64frombits(r.order.Uint64(r.buf[8:16]))
+	r.buf = r.buf[16:]
+	return [2]float64{x, y}, nil
+}
+
+func (r *wkbReader) readPoints() ([][2]float64, error) {
+	var count, err = r.readCount(16)
+	if err != nil {
+		return nil, err
+	}
+	var points = make([][2]float64, 0, count)
+	for i := uint32(0); i < count; i++ {
+		var point, err = r.readPoint()
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, point)
+	}
+	return points, nil
+}
+
+var wktNames = map[uint32]string{
+	wkbPoint:              "POINT",
+	wkbLineString:         "LINESTRING",
+	wkbPolygon:            "POLYGON",
+	wkbMultiPoint:         "MULTIPOINT",
+	wkbMultiLineString:    "MULTILINESTRING",
+	wkbMultiPolygon:       "MULTIPOLYGON",
+	wkbGeometryCollection: "GEOMETRYCOLLECTION",
+}
+
+// writeWKT writes the WKT representation of the geometry, in the same form as MySQL's
+// ST_AsText() function. The type name is omitted for the members of multi-geometries.
+func (g geometry) writeWKT(w *strings.Builder, named bool) {
+	if named {
+		w.WriteString(wktNames[g.kind])
+	}
+	switch g.kind {
+	case wkbPoint:
+		w.WriteString("(")
+		writeWKTPoint(w, g.point)
+		w.WriteString(")")
+	case wkbLineString:
+		writeWKTPoints(w, g.points)
+	case wkbPolygon:
+		writeWKTRings(w, g.rings)
+	default:
+		if len(g.children) == 0 {
+			w.WriteString(" EMPTY")
+			return
+		}
+		w.WriteString("(")
+		for i, child := range g.children {
+			if i > 0 {
+				w.WriteString(",")
+			}
+			child.writeWKT(w, g.kind == wkbGeometryCollection)
+		}
+		w.WriteString(")")
+	}
+}
+
+func writeWKTPoint(w *strings.Builder, p [2]float64) {
+	w.WriteString(strconv.FormatFloat(p[0], 'f', -1, 64))
+	w.WriteString(" ")
+	w.WriteString(strconv.FormatFloat(p[1], 'f', -1, 64))
+}
+
+func writeWKTPoints(w *strings.Builder, points [][2]float64) {
+	w.WriteString("(")
+	for i, p := range points {
+		if i > 0 {
+			w.WriteString(",")
+		}
+		writeWKTPoint(w, p)
+	}
+	w.WriteString(")")
+}
+
+func writeWKTRings(w *strings.Builder, rings [][][2]float64) {
+	w.WriteString("(")
+	for i, ring := range rings {
+		if i > 0 {
+			w.WriteString(",")
+		}
+		writeWKTPoints(w, ring)
+	}
+	w.WriteString(")")
+}
+
+var geoJSONNames = map[uint32]string{
+	wkbPoint:              "Point",
+	wkbLineString:         "LineString",
+	wkbPolygon:            "Polygon",
+	wkbMultiPoint:         "MultiPoint",
+	wkbMultiLineString:    "MultiLineString",
+	wkbMultiPolygon:       "MultiPolygon",
+	wkbGeometryCollection: "GeometryCollection",
+}
+
+// geoJSON returns the GeoJSON object representing the geometry.
+func (g geometry) geoJSON() map[string]interface{} {
+	var obj = map[string]interface{}{"type": geoJSONNames[g.kind]}
+	switch g.kind {
+	case wkbGeometryCollection:
+		var geometries = make([]interface{}, 0, len(g.children))
+		for _, child := range g.children {
+			geometries = append(geometries, child.geoJSON())
+		}
+		obj["geometries"] = geometries
+	default:
+		obj["coordinates"] = g.coordinates()
+	}
+	return obj
+}
+
+// coordinates returns the GeoJSON coordinates of any geometry other than a collection.
+func (g geometry) coordinates() interface{} {
+	switch g.kind {
+	case wkbPoint:
+		return g.point
+	case wkbLineString:
+		return nonNilPoints(g.points)
+	case wkbPolygon:
+		var rings = make([]interface{}, 0, len(g.rings))
+		for _, ring := range g.rings {
+			rings = append(rings, nonNilPoints(ring))
+		}
+		return rings
+	default:
+		var coords = make([]interface{}, 0, len(g.children))
+		for _, child := range g.children {
+			coords = append(coords, child.coordinates())
+		}
+		return coords
+	}
+}
+
+// nonNilPoints ensures that an empty list of points is encoded as an empty JSON array.
+func nonNilPoints(points [][2]float64) [][2]float64 {
+	if points == nil {
+		return [][2]float64{}
+	}
+	return points
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// The formats in which BIGINT UNSIGNED values may be captured.
+const (
+	unsignedBigintFormatInteger = "integer"
+	unsignedBigintFormatString  = "string"
+)
+
+// unsignedBigintDataType is the data type reported for BIGINT UNSIGNED columns, whose
+// values may exceed the range of a signed 64-bit integer. MySQL reports the data type
+// of these columns as "bigint" and has no type of this name, so it can't collide with
+// any real data type.
+const unsignedBigintDataType = "bigint unsigned"
+
+// isUnsignedBigint returns true if the data type and full column type (such as
+// "bigint(20) unsigned zerofill") describe a BIGINT UNSIGNED column.
+func isUnsignedBigint(dataType, columnType string) bool {
+	return strings.EqualFold(dataType, "bigint") && strings.Contains(strings.ToLower(columnType), "unsigned")
+}
+
+// unsignedBigintString is a BIGINT UNSIGNED value which is captured as a decimal string.
+// It remains an integer when encoded as part of a row key, so that keys are still
+// ordered numerically.
+type unsignedBigintString uint64
+
+func (v unsignedBigintString) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + strconv.FormatUint(uint64(v), 10) + `"`), nil
+}
+
+// translateUnsignedBigint converts the value of a BIGINT UNSIGNED column into an
+// unsigned integer in the configured format. Backfill queries decode the value as an
+// unsigned integer, but replicated change events may decode it as a signed integer,
+// in which case values above the signed range wrap around to negative numbers and
+// are converted back.
+func translateUnsignedBigint(val interface{}, format string) (interface{}, error) {
+	var n uint64
+	switch val := val.(type) {
+	case nil:
+		return nil, nil
+	case uint64:
+		n = val
+	case int64:
+		n = uint64(val)
+	case []byte:
+		var parsed, err = strconv.ParseUint(string(val), 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing unsigned integer %q: %w", string(val), err)
+		}
+		n = parsed
+	default:
+		return nil, fmt.Errorf("unexpected unsigned integer value of type %T", val)
+	}
+	if format == unsignedBigintFormatString {
+		return unsignedBigintString(n), nil
+	}
+	return n, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/estuary/connectors/sqlcapture"
 	"github.com/jackc/pglogrepl"
@@ -20,10 +21,49 @@ func (db *postgresDatabase) ScanTableChunk(ctx context.Context, info sqlcapture.
 		"resumeKey":  resumeKey,
 	}).Debug("scanning table chunk")
 
+	// The key columns are named as they were when the capture began, but if any have
+	// since been renamed the query must use their current names.
+	var streamID = sqlcapture.JoinStreamID(schema, table)
+	if db.sequences[streamID] {
+		return db.scanSequence(ctx, info, resumeKey)
+	}
+	var queryKeyColumns = make([]string, len(keyColumns))
+	for idx, colName := range keyColumns {
+		queryKeyColumns[idx] = db.renames.currentName(streamID, colName)
+	}
+
+	// While there's an exported snapshot the table is read from it instead of the
+	// current contents of the database.
+	var conn = db.conn
+	if db.snapshot != nil {
+		if err := db.snapshot.validate(); err != nil {
+			return nil, err
+		}
+		conn = db.snapshot.conn
+	}
+
+	// Backfilled rows are given source sequences if they're captured, which must be
+	// determined before the rows are scanned.
+	var sequenceLSN pglogrepl.LSN
+	if db.config.Advanced.SequenceKey != "" {
+		var err error
+		if sequenceLSN, err = db.backfillSequenceLSN(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	// Only the captured columns are queried if the stream omits any, by their current names.
+	var queryColumns []string
+	if info.Projected {
+		for _, colName := range info.ColumnNames {
+			queryColumns = append(queryColumns, db.renames.currentName(streamID, db.renames.originalName(streamID, colName)))
+		}
+	}
+
 	// Build and execute a query to fetch the next `backfillChunkSize` rows from the database
-	var query = buildScanQuery(resumeKey == nil, keyColumns, schema, table)
+	var query = buildScanQuery(resumeKey == nil, queryKeyColumns, queryColumns, schema, table, db.config.Advanced.BackfillChunkSize)
 	logrus.WithFields(logrus.Fields{"query": query, "args": resumeKey}).Debug("executing query")
-	rows, err := db.conn.Query(ctx, query, resumeKey...)
+	rows, err := conn.Query(ctx, query, resumeKey...)
 	if err != nil {
 		return nil, fmt.Errorf("unable to execute query %q: %w", query, err)
 	}
@@ -45,6 +85,10 @@ func (db *postgresDatabase) ScanTableChunk(ctx context.Context, info sqlcapture.
 		if err := translateRecordFields(&info, fields); err != nil {
 			return nil, fmt.Errorf("error backfilling table %q: %w", table, err)
 		}
+		db.renames.restoreNames(streamID, fields)
+		if err := attachSequence(db.config.Advanced.SequenceKey, fields, sequenceLSN, 0); err != nil {
+			return nil, fmt.Errorf("error backfilling table %q: %w", table, err)
+		}
 
 		events = append(events, sqlcapture.ChangeEvent{
 			Operation: sqlcapture.InsertOp,
@@ -68,6 +112,13 @@ func (db *postgresDatabase) ScanTableChunk(ctx context.Context, info sqlcapture.
 func (db *postgresDatabase) WriteWatermark(ctx context.Context, watermark string) error {
 	logrus.WithField("watermark", watermark).Debug("writing watermark")
 
+	if db.config.Advanced.WatermarkMethod == watermarkMethodMessage {
+		if _, err := db.conn.Exec(ctx, `SELECT pg_logical_emit_message(true, $1::text, $2::text);`, watermarkMessagePrefix, watermark); err != nil {
+			return fmt.Errorf("error emitting watermark message: %w", err)
+		}
+		return nil
+	}
+
 	var query = fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (slot TEXT PRIMARY KEY, watermark TEXT);", db.config.Advanced.WatermarksTable)
 	rows, err := db.conn.Query(ctx, query)
 	if err != nil {
@@ -84,17 +135,35 @@ func (db *postgresDatabase) WriteWatermark(ctx context.Context, watermark string
 	return nil
 }
 
-// WatermarksTable returns the name of the table to which WriteWatermarks writes UUIDs.
+// WatermarksTable returns the name of the table to which WriteWatermarks writes UUIDs,
+// unless they're written as logical decoding messages.
 func (db *postgresDatabase) WatermarksTable() string {
 	return db.config.Advanced.WatermarksTable
 }
 
-// backfillChunkSize controls how many rows will be read from the database in a
-// single query. In normal use it acts like a constant, it's just a variable here
-// so that it can be lowered in tests to exercise chunking behavior more easily.
-var backfillChunkSize = 4096
+// HeartbeatInterval returns how long replication may be idle before a heartbeat is written
+// to the watermarks table.
+func (db *postgresDatabase) HeartbeatInterval() time.Duration {
+	return time.Duration(db.config.Advanced.HeartbeatInterval) * time.Second
+}
+
+// BackfillCheckpointRows returns how many rows of a backfill chunk are captured between
+// checkpoints, or zero if chunks are only checkpointed once they're complete.
+func (db *postgresDatabase) BackfillCheckpointRows() int {
+	return db.config.Advanced.CheckpointRows
+}
+
+// defaultBackfillChunkSize is how many rows are read from the database in a single
+// backfill query, unless the `backfillChunkSize` config property is set.
+const defaultBackfillChunkSize = 4096
+
+// largeBackfillChunkSize is the size above which a configured backfill chunk size
+// is warned about, since every row of a chunk is held in memory at once.
+const largeBackfillChunkSize = 500000
 
-func buildScanQuery(start bool, keyColumns []string, schemaName, tableName string) string {
+// buildScanQuery returns the query which scans the next chunk of a table, selecting the named
+// columns or every column if there are none.
+func buildScanQuery(start bool, keyColumns, columns []string, schemaName, tableName string, chunkSize int) string {
 	// Construct strings like `(foo, bar, baz)` and `($1, $2, $3)` for use in the query
 	var pkey, args string
 	for idx, colName := range keyColumns {
@@ -108,11 +177,11 @@ func buildScanQuery(start bool, keyColumns []string, schemaName, tableName strin
 
 	// Construct the query itself
 	var query = new(strings.Builder)
-	fmt.Fprintf(query, "SELECT * FROM %s.%s", schemaName, tableName)
+	fmt.Fprintf(query, "SELECT %s FROM %s.%s", scanSelection(keyColumns, columns), schemaName, tableName)
 	if !start {
 		fmt.Fprintf(query, " WHERE (%s) > (%s)", pkey, args)
 	}
 	fmt.Fprintf(query, " ORDER BY (%s)", pkey)
-	fmt.Fprintf(query, " LIMIT %d;", backfillChunkSize)
+	fmt.Fprintf(query, " LIMIT %d;", chunkSize)
 	return query.String()
 }
@@ -2,15 +2,22 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/estuary/connectors/sqlcapture"
 	"github.com/estuary/connectors/sqlcapture/tests"
+	"github.com/estuary/flow/go/protocols/airbyte"
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/jackc/pgconn"
 	"github.com/jackc/pglogrepl"
 	"github.com/sirupsen/logrus"
+	logtest "github.com/sirupsen/logrus/hooks/test"
 	"github.com/stretchr/testify/require"
 )
 
@@ -81,6 +88,127 @@ func TestToastColumns(t *testing.T) {
 	tests.VerifiedCapture(ctx, t, tb, &catalog, &state, "ident-full")
 }
 
+// TestUpdateColumns verifies the columns included in update events in the 'full'
+// and 'delta' modes of the 'updateColumns' option.
+func TestUpdateColumns(t *testing.T) {
+	var ctx = context.Background()
+	var data = strings.Repeat("data", 512) // Large enough to be stored as TOAST.
+
+	t.Run("full", func(t *testing.T) {
+		var tb = &postgresTestBackend{conn: TestBackend.conn, cfg: TestBackend.cfg}
+		tb.cfg.Advanced.UpdateColumns = updateColumnsFull
+		var tableName = tb.CreateTable(ctx, t, "full", "(id INTEGER PRIMARY KEY, other INTEGER, data TEXT)")
+		tb.Query(ctx, t, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN data SET STORAGE EXTERNAL;", tableName))
+		var catalog, state = tests.ConfiguredCatalog(ctx, t, tb, tableName), sqlcapture.PersistentState{}
+
+		tb.Insert(ctx, t, tableName, [][]interface{}{{1, 10, data}})
+		tests.PerformCapture(ctx, t, tb, &catalog, &state)
+
+		// The unchanged TOAST value isn't in the replication log, so it's queried.
+		tb.Update(ctx, t, tableName, "id", 1, "other", 20)
+		var result, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+		require.Contains(t, result, fmt.Sprintf(`"data":%q,"id":1,"other":20`, data))
+	})
+
+	t.Run("delta", func(t *testing.T) {
+		var tb = &postgresTestBackend{conn: TestBackend.conn, cfg: TestBackend.cfg}
+		tb.cfg.Advanced.UpdateColumns = updateColumnsDelta
+		var tableName = tb.CreateTable(ctx, t, "delta", "(id INTEGER PRIMARY KEY, other INTEGER, more TEXT, data TEXT)")
+		tb.Query(ctx, t, fmt.Sprintf("ALTER TABLE %s ALTER COLUMN data SET STORAGE EXTERNAL;", tableName))
+		var catalog, state = tests.ConfiguredCatalog(ctx, t, tb, tableName), sqlcapture.PersistentState{}
+
+		tb.Insert(ctx, t, tableName, [][]interface{}{{1, 10, "more", data}})
+		tests.PerformCapture(ctx, t, tb, &catalog, &state)
+
+		// With REPLICA IDENTITY DEFAULT only the unchanged TOAST value is omitted.
+		tb.Update(ctx, t, tableName, "id", 1, "other", 20)
+		var result, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+		require.Contains(t, result, `"id":1,"more":"more","other":20}`)
+		require.NotContains(t, result, `"data":`)
+
+		// With REPLICA IDENTITY FULL every unchanged column is omitted.
+		tb.Query(ctx, t, fmt.Sprintf("ALTER TABLE %s REPLICA IDENTITY FULL;", tableName))
+		tb.Update(ctx, t, tableName, "id", 1, "other", 30)
+		result, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+		require.Contains(t, result, `},"id":1,"other":30}`)
+	})
+}
+
+// TestStreamingTransactions verifies that a large transaction which is streamed
+// while still in progress is only captured once it commits, and that nothing is
+// captured from one which is rolled back.
+func TestStreamingTransactions(t *testing.T) {
+	var tb, ctx = &postgresTestBackend{conn: TestBackend.conn, cfg: TestBackend.cfg}, context.Background()
+	tb.cfg.Advanced.StreamLargeTxns = true
+
+	// Transactions are streamed once their changes exceed logical_decoding_work_mem,
+	// so it's lowered to its minimum of 64kB.
+	tb.Query(ctx, t, "ALTER SYSTEM SET logical_decoding_work_mem = '64kB';")
+	tb.Query(ctx, t, "SELECT pg_reload_conf();")
+	t.Cleanup(func() {
+		tb.Query(ctx, t, "ALTER SYSTEM RESET logical_decoding_work_mem;")
+		tb.Query(ctx, t, "SELECT pg_reload_conf();")
+	})
+
+	var tableName = tb.CreateTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
+	var catalog, state = tests.ConfiguredCatalog(ctx, t, tb, tableName), sqlcapture.PersistentState{}
+	tests.PerformCapture(ctx, t, tb, &catalog, &state)
+
+	// insertRows inserts many rows in a single transaction, which is committed or rolled back.
+	var insertRows = func(data string, commit bool) {
+		var tx, err = tb.conn.Begin(ctx)
+		require.NoError(t, err)
+		_, err = tx.Exec(ctx, fmt.Sprintf(`INSERT INTO %s SELECT g, $1 || repeat('x', 100) FROM generate_series(1, 5000) AS g;`, tableName), data)
+		require.NoError(t, err)
+		if commit {
+			require.NoError(t, tx.Commit(ctx))
+		} else {
+			require.NoError(t, tx.Rollback(ctx))
+		}
+	}
+
+	insertRows("aborted", false)
+	var result, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.NotContains(t, result, `"data":"aborted`)
+
+	insertRows("committed", true)
+	result, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.NotContains(t, result, `"data":"aborted`)
+	require.Equal(t, 5000, strings.Count(result, `"data":"committed`))
+}
+
+// TestColumnRename verifies that the values of a column which is renamed mid-stream
+// continue to be captured under its original name, including after a restart.
+func TestColumnRename(t *testing.T) {
+	var tb, ctx = TestBackend, context.Background()
+	var tableName = tb.CreateTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
+	var catalog, state = tests.ConfiguredCatalog(ctx, t, tb, tableName), sqlcapture.PersistentState{}
+	var streamID = sqlcapture.JoinStreamID("public", tableName)
+
+	tb.Insert(ctx, t, tableName, [][]interface{}{{0, "zero"}})
+	var result, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.Contains(t, result, `"data":"zero","id":0`)
+
+	// Rename the column in between changes, so that a single replication session
+	// observes the table both before and after the rename.
+	tb.Insert(ctx, t, tableName, [][]interface{}{{1, "one"}})
+	tb.Query(ctx, t, fmt.Sprintf("ALTER TABLE %s RENAME COLUMN data TO info;", tableName))
+	tb.Insert(ctx, t, tableName, [][]interface{}{{2, "two"}})
+	tb.Update(ctx, t, tableName, "id", 1, "info", "ONE")
+	result, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.Contains(t, result, `"data":"one","id":1`)
+	require.Contains(t, result, `"data":"two","id":2`)
+	require.Contains(t, result, `"data":"ONE","id":1`)
+	require.NotContains(t, result, `"info":"two"`)
+	require.JSONEq(t, `{"renamed_columns":{"info":"data"}}`, string(state.Streams[streamID].Metadata))
+
+	// The rename is remembered in the state, so it still applies after a restart.
+	tb.Insert(ctx, t, tableName, [][]interface{}{{3, "three"}})
+	result, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.Contains(t, result, `"data":"three","id":3`)
+	require.NotContains(t, result, `"info":"three"`)
+}
+
 // TestComplexDataset tries to throw together a bunch of different bits of complexity
 // to synthesize something vaguely "realistic". It features a multiple-column primary
 // key, a dataset large enough that the initial table scan gets divided across many
@@ -230,3 +358,890 @@ func TestSkipBackfills(t *testing.T) {
 	tb.Insert(ctx, t, tableC, [][]interface{}{{16, "sixteen"}, {17, "seventeen"}, {18, "eighteen"}})
 	tests.VerifiedCapture(ctx, t, tb, &catalog, &state, "")
 }
+
+// TestStandbyStatusInterval verifies that standby status updates are sent to the
+// database at the cadence specified by 'standbyMessageIntervalSeconds'.
+func TestStandbyStatusInterval(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+
+	var ctx = context.Background()
+	var cfg = TestDefaultConfig
+	cfg.Advanced.StandbyInterval = 1
+	var db = &postgresDatabase{config: &cfg}
+	require.NoError(t, db.Connect(ctx))
+	defer db.Close(ctx)
+
+	var rs, err = db.StartReplication(ctx, "", map[string]struct{}{}, nil, nil)
+	require.NoError(t, err)
+	var stream = rs.(*replicationStream)
+	go func() {
+		for range stream.Events() {
+		}
+	}()
+
+	// One update is sent immediately and then one more every second, but keepalive
+	// messages requesting a reply may trigger a few additional ones.
+	time.Sleep(3500 * time.Millisecond)
+	require.NoError(t, stream.Close(ctx))
+	var updates = atomic.LoadUint64(&stream.standbyStatusUpdates)
+	require.GreaterOrEqual(t, updates, uint64(4))
+	require.LessOrEqual(t, updates, uint64(8))
+}
+
+// TestReplicationHeartbeat checks that heartbeats are written to the watermarks table once
+// the tailing replication stream has been idle for the heartbeat interval.
+func TestReplicationHeartbeat(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping test in short mode.")
+	}
+
+	var tb = &postgresTestBackend{conn: TestBackend.conn, cfg: TestBackend.cfg}
+	tb.cfg.Advanced.HeartbeatInterval = 1
+	var catalog = tests.ConfiguredCatalog(context.Background(), t, tb, "flow_watermarks")
+	catalog.Tail = true
+
+	// Capturing the watermarks table itself makes the heartbeats visible as its changes.
+	// The capture tails the stream until it's cancelled.
+	var ctx, cancel = context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	var state = sqlcapture.PersistentState{}
+	var output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.Contains(t, output, `"watermark":"heartbeat-`)
+}
+
+// TestWatermarkMessages checks that a table is backfilled and replicated when watermarks are
+// written as logical decoding messages, and that the watermarks table isn't used.
+func TestWatermarkMessages(t *testing.T) {
+	var tb, ctx = &postgresTestBackend{conn: TestBackend.conn, cfg: TestBackend.cfg}, context.Background()
+	tb.cfg.Advanced.WatermarkMethod = watermarkMethodMessage
+	tb.cfg.Advanced.WatermarksTable = "public.test_watermarkmessages_unused"
+	tb.cfg.Advanced.BackfillChunkSize = 2
+
+	var tableName = tb.CreateTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
+	tb.Insert(ctx, t, tableName, [][]interface{}{{0, "zero"}, {1, "one"}, {2, "two"}, {3, "three"}, {4, "four"}})
+	var catalog, state = tests.ConfiguredCatalog(ctx, t, tb, tableName), sqlcapture.PersistentState{}
+	var result, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	for _, data := range []string{"zero", "one", "two", "three", "four"} {
+		require.Contains(t, result, fmt.Sprintf(`"data":%q`, data))
+	}
+	require.Equal(t, sqlcapture.TableModeActive, state.Streams[sqlcapture.JoinStreamID("public", tableName)].Mode)
+
+	tb.Insert(ctx, t, tableName, [][]interface{}{{5, "five"}})
+	result, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.Contains(t, result, `"data":"five"`)
+
+	var exists bool
+	require.NoError(t, tb.conn.QueryRow(ctx, `SELECT to_regclass($1) IS NOT NULL;`, tb.cfg.Advanced.WatermarksTable).Scan(&exists))
+	require.False(t, exists)
+}
+
+// TestBackfillChunkSize checks that backfill queries read chunks of the configured size.
+func TestBackfillChunkSize(t *testing.T) {
+	var tb, ctx = TestBackend, context.Background()
+	var table = tb.CreateTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
+	tb.Insert(ctx, t, table, [][]interface{}{{0, "zero"}, {1, "one"}, {2, "two"}, {3, "three"}, {4, "four"}})
+
+	var cfg = TestDefaultConfig
+	cfg.Advanced.BackfillChunkSize = 2
+	var db = &postgresDatabase{config: &cfg}
+	require.NoError(t, db.Connect(ctx))
+	defer db.Close(ctx)
+	var discovery, err = db.DiscoverTables(ctx)
+	require.NoError(t, err)
+	var info = discovery[sqlcapture.JoinStreamID("public", table)]
+
+	var chunks [][]interface{}
+	var resumeKey []interface{}
+	for {
+		var events, err = db.ScanTableChunk(ctx, info, []string{"id"}, resumeKey)
+		require.NoError(t, err)
+		if len(events) == 0 {
+			break
+		}
+		var chunk []interface{}
+		for _, event := range events {
+			chunk = append(chunk, event.After["id"])
+		}
+		chunks = append(chunks, chunk)
+		resumeKey = []interface{}{events[len(events)-1].After["id"]}
+	}
+	require.Equal(t, [][]interface{}{{int32(0), int32(1)}, {int32(2), int32(3)}, {int32(4)}}, chunks)
+
+	// The chunk size defaults to 4096 rows, and must not be negative.
+	var defaults = Config{Address: "localhost", User: "flow_capture", Password: "secret"}
+	defaults.SetDefaults()
+	require.Equal(t, 4096, defaults.Advanced.BackfillChunkSize)
+	defaults.Advanced.BackfillChunkSize = -1
+	require.Error(t, defaults.Validate())
+}
+
+// TestBackfillCheckpointRows checks that a capture which is killed partway through a
+// backfill chunk resumes after the last row checkpointed within it, and that no rows
+// are dropped or duplicated across the restart.
+func TestBackfillCheckpointRows(t *testing.T) {
+	var tb, ctx = &postgresTestBackend{conn: TestBackend.conn, cfg: TestBackend.cfg}, context.Background()
+	tb.cfg.Advanced.BackfillChunkSize = 100
+	tb.cfg.Advanced.CheckpointRows = 3
+
+	var tableName = tb.CreateTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
+	var rows [][]interface{}
+	for idx := 0; idx < 10; idx++ {
+		rows = append(rows, []interface{}{idx, fmt.Sprintf("row %d", idx)})
+	}
+	tb.Insert(ctx, t, tableName, rows)
+	var catalog = tests.ConfiguredCatalog(ctx, t, tb, tableName)
+	var streamID = sqlcapture.JoinStreamID("public", tableName)
+
+	// The whole table is a single chunk, which is checkpointed after every third row.
+	// The first capture is killed while emitting its ninth row, so only the six rows
+	// preceding its second checkpoint are committed.
+	var first = &killedCaptureOutput{limit: 8}
+	require.Error(t, sqlcapture.RunCapture(ctx, tb.GetDatabase(), &catalog, nil, &sqlcapture.PersistentState{}, first))
+	require.Equal(t, []int{0, 1, 2, 3, 4, 5}, first.committedIDs(t))
+	var state sqlcapture.PersistentState
+	require.NoError(t, json.Unmarshal(first.stateJSON, &state))
+	require.Equal(t, sqlcapture.TableModeBackfill, state.Streams[streamID].Mode)
+
+	// The restarted capture resumes the backfill after the checkpointed row.
+	var second = &killedCaptureOutput{}
+	require.NoError(t, sqlcapture.RunCapture(ctx, tb.GetDatabase(), &catalog, nil, &state, second))
+	require.Equal(t, []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, append(first.committedIDs(t), second.committedIDs(t)...))
+	require.NoError(t, json.Unmarshal(second.stateJSON, &state))
+	require.Equal(t, sqlcapture.TableModeActive, state.Streams[streamID].Mode)
+
+	// The number of rows between checkpoints must not be negative.
+	var cfg = Config{Address: "localhost", User: "flow_capture", Password: "secret"}
+	cfg.SetDefaults()
+	cfg.Advanced.CheckpointRows = -1
+	require.Error(t, cfg.Validate())
+}
+
+// killedCaptureOutput collects the records of a capture which have been committed by a
+// subsequent state update, and fails once `limit` records have been output as though the
+// capture had been killed. Records output after the last state update are discarded, just
+// as they are when a capture restarts.
+type killedCaptureOutput struct {
+	limit     int
+	records   int
+	pending   []json.RawMessage
+	committed []json.RawMessage
+	stateJSON json.RawMessage // The merged state updates of the capture.
+}
+
+func (o *killedCaptureOutput) Encode(v interface{}) error {
+	var msg = v.(airbyte.Message)
+	switch msg.Type {
+	case airbyte.MessageTypeRecord:
+		if o.records++; o.limit > 0 && o.records > o.limit {
+			return fmt.Errorf("capture killed after %d records", o.limit)
+		}
+		o.pending = append(o.pending, msg.Record.Data)
+	case airbyte.MessageTypeState:
+		if o.stateJSON == nil {
+			o.stateJSON = json.RawMessage(`{}`)
+		}
+		var merged, err = jsonpatch.MergePatch(o.stateJSON, msg.State.Data)
+		if err != nil {
+			return fmt.Errorf("error merging state update: %w", err)
+		}
+		o.stateJSON = merged
+		o.committed = append(o.committed, o.pending...)
+		o.pending = nil
+	}
+	return nil
+}
+
+// committedIDs returns the `id` properties of the committed records.
+func (o *killedCaptureOutput) committedIDs(t *testing.T) []int {
+	t.Helper()
+	var ids []int
+	for _, data := range o.committed {
+		var doc struct {
+			ID int `json:"id"`
+		}
+		require.NoError(t, json.Unmarshal(data, &doc))
+		ids = append(ids, doc.ID)
+	}
+	return ids
+}
+
+// TestExportedSnapshot checks that backfills read from the snapshot exported along
+// with a newly created replication slot observe the table exactly as it was where
+// replication begins, so that every later change is replicated instead.
+func TestExportedSnapshot(t *testing.T) {
+	var tb, ctx = TestBackend, context.Background()
+	var table = tb.CreateTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
+	tb.Insert(ctx, t, table, [][]interface{}{{0, "zero"}, {1, "one"}, {2, "two"}})
+
+	// The snapshot is only exported when the connector creates the slot, so this
+	// test uses its own slot which is dropped before and after.
+	var cfg = TestDefaultConfig
+	cfg.Advanced.SlotName = *TestReplicationSlot + "_snapshot"
+	cfg.Advanced.ExportSnapshot = true
+	var dropSlot = func() {
+		TestDatabase.Exec(ctx, `SELECT pg_drop_replication_slot(slot_name) FROM pg_catalog.pg_replication_slots WHERE slot_name = $1;`, cfg.Advanced.SlotName)
+	}
+	dropSlot()
+	t.Cleanup(dropSlot)
+
+	var db = &postgresDatabase{config: &cfg}
+	require.NoError(t, db.Connect(ctx))
+	defer db.Close(ctx)
+	var discovery, err = db.DiscoverTables(ctx)
+	require.NoError(t, err)
+	var streamID = sqlcapture.JoinStreamID("public", table)
+
+	rs, err := db.StartReplication(ctx, "", map[string]struct{}{streamID: {}}, discovery, nil)
+	require.NoError(t, err)
+	defer rs.Close(ctx)
+	cursor, ok := db.ExportedSnapshot()
+	require.True(t, ok)
+	require.NotEmpty(t, cursor)
+
+	// Modify the table after the snapshot was taken.
+	tb.Insert(ctx, t, table, [][]interface{}{{3, "three"}})
+	tb.Update(ctx, t, table, "id", 0, "data", "updated")
+	tb.Delete(ctx, t, table, "id", 1)
+
+	var scan = func() ([]string, error) {
+		var events, err = db.ScanTableChunk(ctx, discovery[streamID], []string{"id"}, nil)
+		var rows []string
+		for _, event := range events {
+			rows = append(rows, fmt.Sprintf("%v=%v", event.After["id"], event.After["data"]))
+		}
+		return rows, err
+	}
+
+	// The backfill observes none of the modifications.
+	rows, err := scan()
+	require.NoError(t, err)
+	require.Equal(t, []string{"0=zero", "1=one", "2=two"}, rows)
+
+	// While every one of them is replicated.
+	var changes []string
+	for len(changes) < 3 {
+		select {
+		case event := <-rs.Events():
+			switch event.Operation {
+			case sqlcapture.InsertOp, sqlcapture.UpdateOp, sqlcapture.DeleteOp:
+				changes = append(changes, fmt.Sprintf("%s %v", event.Operation, event.KeyFields()["id"]))
+			}
+		case <-time.After(10 * time.Second):
+			t.Fatalf("timed out waiting for replication events, got %q", changes)
+		}
+	}
+	require.Equal(t, []string{"c 3", "u 0", "d 1"}, changes)
+
+	// A snapshot whose transaction has failed can no longer be read from.
+	_, err = db.snapshot.conn.Exec(ctx, "SELECT 1/0;")
+	require.Error(t, err)
+	_, err = scan()
+	require.Error(t, err)
+	require.Contains(t, err.Error(), "is no longer valid")
+
+	// Once the snapshot is released, backfills observe the current table contents.
+	require.NoError(t, db.ReleaseSnapshot(ctx))
+	_, ok = db.ExportedSnapshot()
+	require.False(t, ok)
+	rows, err = scan()
+	require.NoError(t, err)
+	require.Equal(t, []string{"0=updated", "2=two", "3=three"}, rows)
+}
+
+// TestExportedSnapshotChunks checks that every chunk of a table which is backfilled from
+// an exported snapshot observes the same committed state of the table, even when rows of
+// chunks which haven't been read yet are modified in between.
+func TestExportedSnapshotChunks(t *testing.T) {
+	var tb, ctx = TestBackend, context.Background()
+	var table = tb.CreateTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
+	tb.Insert(ctx, t, table, [][]interface{}{{0, "zero"}, {1, "one"}, {2, "two"}, {3, "three"}, {4, "four"}})
+
+	var cfg = TestDefaultConfig
+	cfg.Advanced.SlotName = *TestReplicationSlot + "_snapshot"
+	cfg.Advanced.ExportSnapshot = true
+	cfg.Advanced.BackfillChunkSize = 2
+	var dropSlot = func() {
+		TestDatabase.Exec(ctx, `SELECT pg_drop_replication_slot(slot_name) FROM pg_catalog.pg_replication_slots WHERE slot_name = $1;`, cfg.Advanced.SlotName)
+	}
+	dropSlot()
+	t.Cleanup(dropSlot)
+
+	var db = &postgresDatabase{config: &cfg}
+	require.NoError(t, db.Connect(ctx))
+	defer db.Close(ctx)
+	var discovery, err = db.DiscoverTables(ctx)
+	require.NoError(t, err)
+	var streamID = sqlcapture.JoinStreamID("public", table)
+
+	rs, err := db.StartReplication(ctx, "", map[string]struct{}{streamID: {}}, discovery, nil)
+	require.NoError(t, err)
+	defer rs.Close(ctx)
+	_, ok := db.ExportedSnapshot()
+	require.True(t, ok)
+
+	var rows []string
+	var resumeKey []interface{}
+	for {
+		var events, err = db.ScanTableChunk(ctx, discovery[streamID], []string{"id"}, resumeKey)
+		require.NoError(t, err)
+		if len(events) == 0 {
+			break
+		}
+		for _, event := range events {
+			rows = append(rows, fmt.Sprintf("%v=%v", event.After["id"], event.After["data"]))
+		}
+		resumeKey = []interface{}{events[len(events)-1].After["id"]}
+
+		// Modify rows on either side of the scan after the first chunk has been read.
+		if len(rows) == 2 {
+			tb.Update(ctx, t, table, "id", 0, "data", "updated")
+			tb.Update(ctx, t, table, "id", 4, "data", "updated")
+			tb.Delete(ctx, t, table, "id", 3)
+			tb.Insert(ctx, t, table, [][]interface{}{{5, "five"}})
+		}
+	}
+	require.Equal(t, []string{"0=zero", "1=one", "2=two", "3=three", "4=four"}, rows)
+}
+
+// TestFullRefreshStreams checks that a catalog may capture some streams incrementally
+// via replication while others are periodically rescanned in their entirety.
+func TestFullRefreshStreams(t *testing.T) {
+	var tb, ctx = &postgresTestBackend{conn: TestDatabase, cfg: TestDefaultConfig}, context.Background()
+	var incremental = tb.CreateTable(ctx, t, "incremental", "(id INTEGER PRIMARY KEY, data TEXT)")
+	var refreshed = tb.CreateTable(ctx, t, "refreshed", "(id INTEGER PRIMARY KEY, data TEXT)")
+	var rows [][]interface{}
+	for i := 0; i < 20; i++ {
+		rows = append(rows, []interface{}{i, fmt.Sprintf("row %d", i)})
+	}
+	tb.Insert(ctx, t, incremental, rows)
+	tb.Insert(ctx, t, refreshed, rows)
+
+	var catalog = tests.ConfiguredCatalog(ctx, t, tb, incremental, refreshed)
+	catalog.Streams[1].SyncMode = airbyte.SyncModeFullRefresh
+	var state = sqlcapture.PersistentState{}
+	var incrementalID = sqlcapture.JoinStreamID("public", incremental)
+	var refreshedID = sqlcapture.JoinStreamID("public", refreshed)
+	var records = func(output, table string) int {
+		return strings.Count(output, `"stream":"`+strings.ToLower(table)+`"`)
+	}
+
+	// The initial capture backfills one table and scans the other.
+	var output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.Equal(t, len(rows), records(output, incremental))
+	require.Equal(t, len(rows), records(output, refreshed))
+	require.Equal(t, sqlcapture.TableModeActive, state.Streams[incrementalID].Mode)
+	require.Equal(t, sqlcapture.TableModeFullRefresh, state.Streams[refreshedID].Mode)
+	require.NotNil(t, state.Streams[refreshedID].RefreshedAt)
+
+	// Changes to the incremental table are replicated, while those to the other
+	// table go unobserved until its next refresh is due.
+	tb.Insert(ctx, t, incremental, [][]interface{}{{20, "added"}})
+	tb.Insert(ctx, t, refreshed, [][]interface{}{{20, "added"}})
+	tb.Update(ctx, t, refreshed, "id", 3, "data", "updated")
+	output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.Equal(t, 1, records(output, incremental))
+	require.Equal(t, 0, records(output, refreshed))
+
+	// Once it's due the table is rescanned, including the rows which were added or updated.
+	tb.cfg.Advanced.RefreshInterval = 1
+	time.Sleep(1100 * time.Millisecond)
+	tb.Insert(ctx, t, incremental, [][]interface{}{{21, "added"}})
+	output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.Equal(t, 1, records(output, incremental))
+	require.Equal(t, len(rows)+1, records(output, refreshed))
+	require.Contains(t, output, `"data":"updated"`)
+}
+
+// TestCaptureSequences checks that sequences listed in the 'captureSequences' option are
+// captured as streams of their own, which are updated as the sequences advance.
+func TestCaptureSequences(t *testing.T) {
+	var tb, ctx = &postgresTestBackend{conn: TestDatabase, cfg: TestDefaultConfig}, context.Background()
+	var table = tb.CreateTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
+	var sequence = strings.ToLower(table + "_seq")
+	tb.Query(ctx, t, fmt.Sprintf(`DROP SEQUENCE IF EXISTS %s;`, sequence))
+	tb.Query(ctx, t, fmt.Sprintf(`CREATE SEQUENCE %s;`, sequence))
+	t.Cleanup(func() { tb.Query(ctx, t, fmt.Sprintf(`DROP SEQUENCE %s;`, sequence)) })
+	tb.cfg.Advanced.CaptureSequences = "public." + sequence
+
+	var catalog = tests.ConfiguredCatalog(ctx, t, tb, table, sequence)
+	var state = sqlcapture.PersistentState{}
+	var sequenceID = sqlcapture.JoinStreamID("public", sequence)
+	var records = func(output, stream string) int {
+		return strings.Count(output, `"stream":"`+stream+`"`)
+	}
+
+	// The sequence is captured by polling, rather than by replication.
+	tb.Insert(ctx, t, table, [][]interface{}{{1, "one"}})
+	var output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.Equal(t, 1, records(output, strings.ToLower(table)))
+	require.Equal(t, 1, records(output, sequence))
+	require.Contains(t, output, `"is_called":false,"last_value":1,`)
+	require.Contains(t, output, fmt.Sprintf(`"sequence_name":"public.%s"`, sequence))
+	require.Equal(t, sqlcapture.TableModePeriodicSnapshot, state.Streams[sequenceID].Mode)
+
+	// Advancing the sequence goes unobserved until it's next polled.
+	tb.Query(ctx, t, fmt.Sprintf(`SELECT nextval('%s') FROM generate_series(1, 3);`, sequence))
+	output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.Equal(t, 0, records(output, sequence))
+
+	// Once it's due the sequence is polled again, and its new value is emitted as an update.
+	tb.cfg.Advanced.RefreshInterval = 1
+	time.Sleep(1100 * time.Millisecond)
+	output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.Equal(t, 1, records(output, sequence))
+	require.Contains(t, output, `"op":"u"`)
+	require.Contains(t, output, `"is_called":true,"last_value":3,`)
+
+	// And nothing is emitted if the sequence hasn't changed since.
+	time.Sleep(1100 * time.Millisecond)
+	output, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.Equal(t, 0, records(output, sequence))
+}
+
+// TestSystemSchemaDiscovery checks that the tables of system schemas are only
+// discovered when the 'discoverSystemSchemas' option is set.
+func TestSystemSchemaDiscovery(t *testing.T) {
+	var tb, ctx = &postgresTestBackend{conn: TestDatabase, cfg: TestDefaultConfig}, context.Background()
+	var table = tb.CreateTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
+
+	var discoverSchemas = func() map[string]bool {
+		var catalog, err = sqlcapture.DiscoverCatalog(ctx, tb.GetDatabase())
+		require.NoError(t, err)
+		var schemas = make(map[string]bool)
+		for _, stream := range catalog.Streams {
+			schemas[stream.Namespace] = true
+		}
+		return schemas
+	}
+
+	var schemas = discoverSchemas()
+	require.True(t, schemas["public"], "table %q wasn't discovered", table)
+	for _, schema := range systemSchemas {
+		require.False(t, schemas[schema], "system schema %q was discovered", schema)
+	}
+
+	tb.cfg.Advanced.SystemSchemas = true
+	schemas = discoverSchemas()
+	require.True(t, schemas["public"], "table %q wasn't discovered", table)
+	require.True(t, schemas["pg_catalog"], "system schema 'pg_catalog' wasn't discovered")
+}
+
+// TestSchemaDrift verifies that when the 'trackSchemaDrift' option is set, the discovered
+// schema of each table is persisted in the state and changes to it are reported once.
+func TestSchemaDrift(t *testing.T) {
+	var tb, ctx = &postgresTestBackend{conn: TestDatabase, cfg: TestDefaultConfig}, context.Background()
+	tb.cfg.Advanced.SchemaDrift = true
+	var table = tb.CreateTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT, extra INTEGER)")
+	var catalog = tests.ConfiguredCatalog(ctx, t, tb, table)
+	var streamID = sqlcapture.JoinStreamID("public", table)
+
+	var hook = logtest.NewGlobal()
+	defer hook.Reset()
+	var notices = func() []*logrus.Entry {
+		var found []*logrus.Entry
+		for _, entry := range hook.AllEntries() {
+			if entry.Data["event"] == "schema_drift" {
+				found = append(found, entry)
+			}
+		}
+		hook.Reset()
+		return found
+	}
+	var columns = func(state sqlcapture.PersistentState) []string {
+		var names []string
+		for _, col := range state.Streams[streamID].Schema {
+			names = append(names, col.Name)
+		}
+		return names
+	}
+
+	// The schema is persisted when the capture begins, and nothing is reported until it changes.
+	var state = sqlcapture.PersistentState{}
+	tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.Equal(t, []string{"id", "data", "extra"}, columns(state))
+	require.Empty(t, notices())
+	tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.Empty(t, notices())
+
+	tb.Query(ctx, t, fmt.Sprintf("ALTER TABLE %s ADD COLUMN added TEXT, DROP COLUMN extra, ALTER COLUMN data TYPE INTEGER USING length(data);", table))
+	tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	var found = notices()
+	require.Len(t, found, 1)
+	require.Equal(t, streamID, found[0].Data["stream"])
+	require.Equal(t, []string{"added"}, found[0].Data["added"])
+	require.Equal(t, []string{"extra"}, found[0].Data["removed"])
+	require.Equal(t, []string{"data"}, found[0].Data["changed"])
+	require.Equal(t, []string{"id", "data", "added"}, columns(state))
+
+	// The new schema is persisted, so the same change isn't reported again.
+	tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.Empty(t, notices())
+}
+
+// TestSourceSequence verifies that the source sequences of captured documents order
+// the changes of each row, across the transition from backfill to replication, and
+// that replicated changes are sequenced in the order they're captured.
+func TestSourceSequence(t *testing.T) {
+	var tb, ctx = &postgresTestBackend{conn: TestDatabase, cfg: TestDefaultConfig}, context.Background()
+	tb.cfg.Advanced.SequenceKey = "_seq"
+	var one = tb.CreateTable(ctx, t, "one", "(id INTEGER PRIMARY KEY, data TEXT)")
+	var two = tb.CreateTable(ctx, t, "two", "(id INTEGER PRIMARY KEY, data TEXT)")
+
+	// Enough rows are inserted that each table is backfilled in several chunks.
+	var rows [][]interface{}
+	for id := 0; id < 40; id++ {
+		rows = append(rows, []interface{}{id, fmt.Sprintf("v%d", id)})
+	}
+	tb.Insert(ctx, t, one, rows)
+	tb.Insert(ctx, t, two, rows)
+
+	type sequenced struct {
+		key      string
+		seq      string
+		snapshot bool
+	}
+	// capture performs a capture and returns the sequences of its documents in order.
+	var capture = func(catalog airbyte.ConfiguredCatalog, state *sqlcapture.PersistentState) []sequenced {
+		var result, _ = tests.PerformCapture(ctx, t, tb, &catalog, state)
+		var docs []sequenced
+		for _, line := range strings.Split(strings.TrimSpace(result), "\n") {
+			var msg airbyte.Message
+			require.NoError(t, json.Unmarshal([]byte(line), &msg))
+			if msg.Type != airbyte.MessageTypeRecord {
+				continue
+			}
+			var doc struct {
+				ID   int    `json:"id"`
+				Seq  string `json:"_seq"`
+				Meta struct {
+					Source struct {
+						Snapshot bool `json:"snapshot"`
+					} `json:"source"`
+				} `json:"_meta"`
+			}
+			require.NoError(t, json.Unmarshal(msg.Record.Data, &doc))
+			require.Len(t, doc.Seq, 33, "sequence of %s", msg.Record.Data)
+			docs = append(docs, sequenced{
+				key:      fmt.Sprintf("%s/%d", msg.Record.Stream, doc.ID),
+				seq:      doc.Seq,
+				snapshot: doc.Meta.Source.Snapshot,
+			})
+		}
+		return docs
+	}
+
+	// The first table is backfilled and modified, and then the second is added to the
+	// catalog and backfilled while the changes of the first are replicated. Finally,
+	// both tables are modified, including by a transaction changing several rows.
+	var state = sqlcapture.PersistentState{}
+	var docs = capture(tests.ConfiguredCatalog(ctx, t, tb, one), &state)
+	tb.Update(ctx, t, one, "id", 3, "data", "updated")
+	tb.Delete(ctx, t, one, "id", 5)
+	tb.Insert(ctx, t, one, [][]interface{}{{40, "v40"}, {41, "v41"}})
+
+	var catalog = tests.ConfiguredCatalog(ctx, t, tb, one, two)
+	docs = append(docs, capture(catalog, &state)...)
+	tb.Query(ctx, t, fmt.Sprintf("UPDATE %s SET data = 'bulk' WHERE id < 10;", one))
+	tb.Update(ctx, t, two, "id", 3, "data", "updated")
+	tb.Delete(ctx, t, two, "id", 7)
+	tb.Update(ctx, t, one, "id", 3, "data", "again")
+	docs = append(docs, capture(catalog, &state)...)
+
+	var backfilled, replicated int
+	var lastReplicated string
+	var lastByKey = make(map[string]string)
+	for _, doc := range docs {
+		// The sequences of each row increase.
+		if last, ok := lastByKey[doc.key]; ok {
+			require.Less(t, last, doc.seq, "sequences of %s", doc.key)
+		}
+		lastByKey[doc.key] = doc.seq
+
+		// Replicated changes are sequenced in the order they're captured.
+		if doc.snapshot {
+			require.True(t, strings.HasSuffix(doc.seq, ":0000000000000000"), "sequence of backfilled %s", doc.key)
+			backfilled++
+		} else {
+			require.Less(t, lastReplicated, doc.seq, "sequence of replicated %s", doc.key)
+			lastReplicated = doc.seq
+			replicated++
+		}
+	}
+	require.Equal(t, 80, backfilled)
+	require.Equal(t, 16, replicated)
+}
+
+// TestKeylessTables verifies that a table without a primary key can be captured using
+// a key specified in the catalog once its REPLICA IDENTITY is FULL.
+func TestKeylessTables(t *testing.T) {
+	var tb, ctx = TestBackend, context.Background()
+	var tableName = tb.CreateTable(ctx, t, "", "(id INTEGER, data TEXT)")
+	var rows [][]interface{}
+	for id := 0; id < 20; id++ {
+		rows = append(rows, []interface{}{id, fmt.Sprintf("v%d", id)})
+	}
+	tb.Insert(ctx, t, tableName, rows)
+	var catalog, state = tests.ConfiguredCatalog(ctx, t, tb, tableName), sqlcapture.PersistentState{}
+
+	// Without a key the capture fails, explaining how the table can be captured.
+	var result, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.Contains(t, result, "has no primary key and its REPLICA IDENTITY is DEFAULT")
+
+	// A key from the catalog can't be used until the table's REPLICA IDENTITY is FULL,
+	// since replicated deletes wouldn't include it.
+	catalog.Streams[0].PrimaryKey = [][]string{{"ctid"}}
+	result, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.Contains(t, result, `key column "ctid" isn't part of the REPLICA IDENTITY DEFAULT`)
+
+	// Backfilled rows are scanned in order of their ctid, which is captured with them.
+	tb.Query(ctx, t, fmt.Sprintf("ALTER TABLE %s REPLICA IDENTITY FULL;", tableName))
+	result, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.NotContains(t, result, "Capture Terminated With Error")
+	require.Contains(t, result, `"ctid":"(0,1)"`)
+	require.Contains(t, result, `"ctid":"(0,20)"`)
+
+	// Replicated deletes include the complete old row.
+	tb.Delete(ctx, t, tableName, "id", 3)
+	result, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.NotContains(t, result, "Capture Terminated With Error")
+	require.Contains(t, result, `"data":"v3","id":3`)
+}
+
+// TestGeneratedScanKeys verifies that a table keyed by an identity column is backfilled
+// in the order of its key, while a non-unique generated column is rejected as a key.
+func TestGeneratedScanKeys(t *testing.T) {
+	var tb, ctx = TestBackend, context.Background()
+	var tableName = tb.CreateTable(ctx, t, "", "(id INTEGER GENERATED ALWAYS AS IDENTITY PRIMARY KEY, data TEXT, bucket INTEGER NOT NULL GENERATED ALWAYS AS (id % 3) STORED)")
+	for id := 0; id < 20; id++ {
+		tb.Query(ctx, t, fmt.Sprintf("INSERT INTO %s (data) VALUES ($1);", tableName), fmt.Sprintf("v%d", id))
+	}
+	var catalog, state = tests.ConfiguredCatalog(ctx, t, tb, tableName), sqlcapture.PersistentState{}
+
+	// The identity column is the primary key, and every row is backfilled in its order.
+	var result, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.NotContains(t, result, "Capture Terminated With Error")
+	require.Equal(t, 20, strings.Count(result, `"type":"RECORD"`))
+	require.Less(t, strings.Index(result, `"id":1}`), strings.Index(result, `"id":20}`))
+
+	// The generated column repeats, so it can't be used to scan the table.
+	catalog.Streams[0].PrimaryKey = [][]string{{"bucket"}}
+	result, _ = tests.PerformCapture(ctx, t, tb, &catalog, &sqlcapture.PersistentState{})
+	require.Contains(t, result, `generated column "bucket" of table "public"."test_generatedscankeys" isn't unique`)
+
+	// Unless it's combined with columns which are unique.
+	catalog.Streams[0].PrimaryKey = [][]string{{"bucket"}, {"id"}}
+	result, _ = tests.PerformCapture(ctx, t, tb, &catalog, &sqlcapture.PersistentState{})
+	require.NotContains(t, result, "Capture Terminated With Error")
+	require.Equal(t, 20, strings.Count(result, `"type":"RECORD"`))
+}
+
+// TestCreateSlot verifies that the replication slot is created when it doesn't exist,
+// that replication begins at its consistent point, and that concurrent attempts to
+// create the same slot all use it.
+func TestCreateSlot(t *testing.T) {
+	var ctx = context.Background()
+	var cfg = TestDefaultConfig
+	cfg.Advanced.SlotName = *TestReplicationSlot + "_create"
+	cfg.Advanced.CreateSlot = true
+	var dropSlot = func() {
+		TestDatabase.Exec(ctx, `SELECT pg_drop_replication_slot(slot_name) FROM pg_catalog.pg_replication_slots WHERE slot_name = $1;`, cfg.Advanced.SlotName)
+	}
+	dropSlot()
+	t.Cleanup(dropSlot)
+
+	var replConn = func() *pgconn.PgConn {
+		var connConfig, err = cfg.ConnConfig()
+		require.NoError(t, err)
+		connConfig.RuntimeParams["replication"] = "database"
+		conn, err := pgconn.ConnectConfig(ctx, &connConfig.Config)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close(ctx) })
+		return conn
+	}
+
+	// Several instances race to create the slot, and all of them begin at its consistent point.
+	var lsns = make([]pglogrepl.LSN, 3)
+	var errs = make([]error, len(lsns))
+	var wg sync.WaitGroup
+	for i := range lsns {
+		var db = &postgresDatabase{config: &cfg}
+		require.NoError(t, db.Connect(ctx))
+		defer db.Close(ctx)
+		var conn = replConn()
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			lsns[i], errs[i] = db.ensureSlot(ctx, conn)
+		}(i)
+	}
+	wg.Wait()
+	var confirmed string
+	require.NoError(t, TestDatabase.QueryRow(ctx, `SELECT confirmed_flush_lsn::text FROM pg_catalog.pg_replication_slots WHERE slot_name = $1;`, cfg.Advanced.SlotName).Scan(&confirmed))
+	for i := range lsns {
+		require.NoError(t, errs[i])
+		require.Equal(t, confirmed, lsns[i].String())
+	}
+
+	// A capture without a cursor begins at the start of the slot, and its state
+	// has a cursor even before any changes are replicated.
+	var tb = &postgresTestBackend{conn: TestDatabase, cfg: cfg}
+	var table = tb.CreateTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT)")
+	tb.Insert(ctx, t, table, [][]interface{}{{0, "zero"}, {1, "one"}})
+	var catalog, state = tests.ConfiguredCatalog(ctx, t, tb, table), sqlcapture.PersistentState{}
+	var result, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.NotContains(t, result, "Capture Terminated With Error")
+	require.NotEmpty(t, state.Cursor)
+
+	// A slot which can't be replicated from is rejected.
+	require.Error(t, validateSlot("slot", "flow", "pgoutput", &replicationSlot{Plugin: "wal2json", SlotType: "logical", Database: "flow"}))
+	require.Error(t, validateSlot("slot", "flow", "pgoutput", &replicationSlot{SlotType: "physical"}))
+	require.Error(t, validateSlot("slot", "flow", "pgoutput", &replicationSlot{Plugin: "pgoutput", SlotType: "logical", Database: "other"}))
+	require.NoError(t, validateSlot("slot", "flow", "pgoutput", &replicationSlot{Plugin: "pgoutput", SlotType: "logical", Database: "flow"}))
+	require.Error(t, validateSlot("slot", "flow", "wal2json", &replicationSlot{Plugin: "pgoutput", SlotType: "logical", Database: "flow"}))
+	require.NoError(t, validateSlot("slot", "flow", "wal2json", &replicationSlot{Plugin: "wal2json", SlotType: "logical", Database: "flow"}))
+}
+
+func TestCreatePublication(t *testing.T) {
+	var ctx = context.Background()
+	var cfg = TestDefaultConfig
+	cfg.Advanced.PublicationName = *TestReplicationSlot + "_publication"
+	cfg.Advanced.CreatePublication = true
+	cfg.Advanced.PrunePublication = true
+	var dropPublication = func() {
+		TestDatabase.Exec(ctx, fmt.Sprintf(`DROP PUBLICATION IF EXISTS %s;`, cfg.Advanced.PublicationName))
+	}
+	dropPublication()
+	t.Cleanup(dropPublication)
+
+	var publishedTables = func() []string {
+		var tables []string
+		var rows, err = TestDatabase.Query(ctx, `SELECT schemaname || '.' || tablename FROM pg_catalog.pg_publication_tables WHERE pubname = $1 ORDER BY 1;`, cfg.Advanced.PublicationName)
+		require.NoError(t, err)
+		defer rows.Close()
+		for rows.Next() {
+			var table string
+			require.NoError(t, rows.Scan(&table))
+			tables = append(tables, table)
+		}
+		return tables
+	}
+
+	var tb = &postgresTestBackend{conn: TestDatabase, cfg: cfg}
+	var one = tb.CreateTable(ctx, t, "one", "(id INTEGER PRIMARY KEY, data TEXT)")
+	var two = tb.CreateTable(ctx, t, "two", "(id INTEGER PRIMARY KEY, data TEXT)")
+	tb.Insert(ctx, t, one, [][]interface{}{{0, "zero"}, {1, "one"}})
+	tb.Insert(ctx, t, two, [][]interface{}{{2, "two"}, {3, "three"}})
+
+	// The publication is created with the tables of both streams, whose changes
+	// are then replicated from it.
+	var catalog, state = tests.ConfiguredCatalog(ctx, t, tb, one, two), sqlcapture.PersistentState{}
+	var result, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.NotContains(t, result, "Capture Terminated With Error")
+	require.Equal(t, []string{"public.flow_watermarks", "public." + strings.ToLower(one), "public." + strings.ToLower(two)}, publishedTables())
+
+	tb.Insert(ctx, t, one, [][]interface{}{{4, "four"}})
+	result, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.Contains(t, result, `"four"`)
+
+	// The table of a stream which is removed from the catalog is dropped from it.
+	catalog = tests.ConfiguredCatalog(ctx, t, tb, one)
+	result, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.NotContains(t, result, "Capture Terminated With Error")
+	require.Equal(t, []string{"public.flow_watermarks", "public." + strings.ToLower(one)}, publishedTables())
+
+	// Pruning the publication requires that it's managed by the connector.
+	cfg.Advanced.CreatePublication = false
+	require.Error(t, cfg.Validate())
+}
+
+// TestPartitionedTable checks that a declaratively partitioned table is discovered and
+// captured as a single stream, including the changes of partitions which are attached
+// during the capture, while those of detached partitions are no longer captured.
+func TestPartitionedTable(t *testing.T) {
+	var tb, ctx = TestBackend, context.Background()
+	var table = tb.CreateTable(ctx, t, "", "(id INTEGER PRIMARY KEY, data TEXT) PARTITION BY RANGE (id)")
+	tb.Query(ctx, t, fmt.Sprintf(`CREATE TABLE %[1]s_p0 PARTITION OF %[1]s FOR VALUES FROM (0) TO (100);`, table))
+	tb.Query(ctx, t, fmt.Sprintf(`CREATE TABLE %[1]s_p1 PARTITION OF %[1]s FOR VALUES FROM (100) TO (200);`, table))
+	tb.Insert(ctx, t, table, [][]interface{}{{1, "one"}, {101, "one hundred one"}})
+
+	// Only the partitioned table is discovered.
+	var discovered, err = sqlcapture.DiscoverCatalog(ctx, tb.GetDatabase())
+	require.NoError(t, err)
+	var names []string
+	for _, stream := range discovered.Streams {
+		if strings.HasPrefix(stream.Name, table) {
+			names = append(names, stream.Name)
+		}
+	}
+	require.Equal(t, []string{table}, names)
+
+	// Backfilled rows and replicated changes of every partition are captured as rows
+	// of the partitioned table.
+	var catalog, state = tests.ConfiguredCatalog(ctx, t, tb, table), sqlcapture.PersistentState{}
+	var result, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.NotContains(t, result, "Capture Terminated With Error")
+	require.Contains(t, result, `"one hundred one"`)
+
+	tb.Insert(ctx, t, table, [][]interface{}{{2, "two"}, {102, "one hundred two"}})
+	tb.Update(ctx, t, table, "id", 1, "data", "updated")
+	result, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.NotContains(t, result, "Capture Terminated With Error")
+	for _, data := range []string{`"two"`, `"one hundred two"`, `"updated"`} {
+		require.Contains(t, result, data)
+	}
+	require.NotContains(t, result, table+"_p")
+
+	// Partitions attached during the capture are captured as part of the partitioned
+	// table, and detached ones aren't.
+	tb.Query(ctx, t, fmt.Sprintf(`CREATE TABLE %[1]s_p2 (LIKE %[1]s);`, table))
+	t.Cleanup(func() { tb.Query(ctx, t, fmt.Sprintf(`DROP TABLE IF EXISTS %s_p2;`, table)) })
+	tb.Query(ctx, t, fmt.Sprintf(`ALTER TABLE %[1]s ATTACH PARTITION %[1]s_p2 FOR VALUES FROM (200) TO (300);`, table))
+	tb.Insert(ctx, t, table, [][]interface{}{{201, "two hundred one"}})
+	result, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.NotContains(t, result, "Capture Terminated With Error")
+	require.Contains(t, result, `"two hundred one"`)
+	require.NotContains(t, result, table+"_p")
+
+	tb.Query(ctx, t, fmt.Sprintf(`ALTER TABLE %[1]s DETACH PARTITION %[1]s_p2;`, table))
+	tb.Insert(ctx, t, table+"_p2", [][]interface{}{{202, "two hundred two"}})
+	tb.Insert(ctx, t, table, [][]interface{}{{3, "three"}})
+	result, _ = tests.PerformCapture(ctx, t, tb, &catalog, &state)
+	require.NotContains(t, result, "Capture Terminated With Error")
+	require.Contains(t, result, `"three"`)
+	require.NotContains(t, result, `"two hundred two"`)
+}
+
+func TestColumnSelection(t *testing.T) {
+	var tb, ctx = TestBackend, context.Background()
+	var table = tb.CreateTable(ctx, t, "", "(id INTEGER PRIMARY KEY, name TEXT, secret TEXT)")
+	tb.Insert(ctx, t, table, [][]interface{}{{1, "one", "backfilled-secret"}})
+
+	// Excluded columns are neither backfilled nor replicated, but the key is always captured.
+	var catalog, state = tests.ConfiguredCatalog(ctx, t, tb, table), sqlcapture.PersistentState{}
+	var columns = []sqlcapture.ColumnSelection{{Exclude: []string{"id", "secret"}}}
+	var result, _ = tests.PerformCaptureWithColumns(ctx, t, tb, &catalog, columns, &state)
+	require.NotContains(t, result, "Capture Terminated With Error")
+	require.Contains(t, result, `"one"`)
+	require.Contains(t, result, `"id":1`)
+	require.NotContains(t, result, "secret")
+
+	tb.Insert(ctx, t, table, [][]interface{}{{2, "two", "replicated-secret"}})
+	tb.Update(ctx, t, table, "id", 1, "secret", "updated-secret")
+	result, _ = tests.PerformCaptureWithColumns(ctx, t, tb, &catalog, columns, &state)
+	require.NotContains(t, result, "Capture Terminated With Error")
+	require.Contains(t, result, `"two"`)
+	require.NotContains(t, result, "secret")
+
+	// Changing the selection doesn't affect resumption, and only the included columns and
+	// the key are captured.
+	columns = []sqlcapture.ColumnSelection{{Include: []string{"secret"}}}
+	tb.Insert(ctx, t, table, [][]interface{}{{3, "three", "included-secret"}})
+	result, _ = tests.PerformCaptureWithColumns(ctx, t, tb, &catalog, columns, &state)
+	require.NotContains(t, result, "Capture Terminated With Error")
+	require.Contains(t, result, `"included-secret"`)
+	require.NotContains(t, result, `"three"`)
+	require.NotContains(t, result, `"two"`)
+}
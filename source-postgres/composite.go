@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// compositeType describes a user-defined composite type.
+type compositeType struct {
+	OID      uint32
+	ArrayOID uint32
+	Name     string
+	Fields   []compositeField // Attributes of the composite type, in order.
+}
+
+// compositeField describes an attribute of a composite type.
+type compositeField struct {
+	Name     string
+	TypeOID  uint32
+	TypeName string
+}
+
+// Only standalone composite types created with `CREATE TYPE ... AS (...)` are
+// discovered, rather than the row types which every table also has.
+const queryDiscoverCompositeTypes = `
+  SELECT t.oid, t.typarray, t.typname, a.attname, a.atttypid, at.typname
+  FROM pg_catalog.pg_type t
+  JOIN pg_catalog.pg_class c ON (c.oid = t.typrelid)
+  JOIN pg_catalog.pg_attribute a ON (a.attrelid = t.typrelid)
+  JOIN pg_catalog.pg_type at ON (at.oid = a.atttypid)
+  WHERE t.typtype = 'c' AND c.relkind = 'c' AND a.attnum > 0 AND NOT a.attisdropped
+  ORDER BY t.oid, a.attnum;
+`
+
+// getCompositeTypes queries the database to produce a map from type names to
+// the composite types of those names.
+func getCompositeTypes(ctx context.Context, conn *pgx.Conn) (map[string]*compositeType, error) {
+	var types = make(map[string]*compositeType)
+	var ct compositeType
+	var field compositeField
+	var _, err = conn.QueryFunc(ctx, queryDiscoverCompositeTypes, nil,
+		[]interface{}{&ct.OID, &ct.ArrayOID, &ct.Name, &field.Name, &field.TypeOID, &field.TypeName},
+		func(r pgx.QueryFuncRow) error {
+			var t, ok = types[ct.Name]
+			if !ok {
+				t = &compositeType{OID: ct.OID, ArrayOID: ct.ArrayOID, Name: ct.Name}
+				types[t.Name] = t
+			}
+			t.Fields = append(t.Fields, field)
+			return nil
+		})
+	return types, err
+}
+
+// registerCompositeTypes adds composite types and arrays of them to a type
+// registry, so that values of these types are decoded into objects keyed by
+// attribute name. A composite type can only be registered once the types of
+// all its attributes are, so they're registered in rounds until no more can
+// be. Any which remain are left to be decoded as text.
+func registerCompositeTypes(connInfo *pgtype.ConnInfo, types map[string]*compositeType) {
+	var pending = make(map[string]*compositeType, len(types))
+	for name, ct := range types {
+		pending[name] = ct
+	}
+
+	for len(pending) > 0 {
+		var registered = 0
+		for name, ct := range pending {
+			var fields = make([]pgtype.CompositeTypeField, len(ct.Fields))
+			for idx, field := range ct.Fields {
+				fields[idx] = pgtype.CompositeTypeField{Name: field.Name, OID: field.TypeOID}
+			}
+			var value, err = pgtype.NewCompositeType(ct.Name, fields, connInfo)
+			if err != nil {
+				continue // An attribute type isn't registered yet.
+			}
+			connInfo.RegisterDataType(pgtype.DataType{
+				Value: value,
+				Name:  ct.Name,
+				OID:   ct.OID,
+			})
+			if ct.ArrayOID != 0 {
+				connInfo.RegisterDataType(pgtype.DataType{
+					Value: &compositeArray{element: value},
+					Name:  "_" + ct.Name,
+					OID:   ct.ArrayOID,
+				})
+			}
+			delete(pending, name)
+			registered++
+		}
+		if registered == 0 {
+			break
+		}
+	}
+	for name := range pending {
+		logrus.WithField("type", name).Warn("unable to register composite type")
+	}
+}
+
+// compositeArray is an array of a composite type. The driver's generic array
+// type flattens multidimensional arrays when their values are read, so arrays
+// of composites are decoded here instead in order to keep their dimensions.
+// Only the text format is implemented, so that values are always read as text.
+type compositeArray struct {
+	Elements   []interface{} // Decoded values of the elements, or nil for NULL ones.
+	Dimensions []pgtype.ArrayDimension
+	Status     pgtype.Status
+
+	element *pgtype.CompositeType
+}
+
+func (dst *compositeArray) Set(src interface{}) error {
+	return fmt.Errorf("cannot convert %v to %s array", src, dst.element.TypeName())
+}
+
+func (dst compositeArray) Get() interface{} {
+	switch dst.Status {
+	case pgtype.Present:
+		return dst
+	case pgtype.Null:
+		return nil
+	default:
+		return dst.Status
+	}
+}
+
+func (src *compositeArray) AssignTo(dst interface{}) error {
+	return fmt.Errorf("cannot assign %s array to %T", src.element.TypeName(), dst)
+}
+
+func (dst *compositeArray) DecodeText(ci *pgtype.ConnInfo, src []byte) error {
+	if src == nil {
+		*dst = compositeArray{Status: pgtype.Null, element: dst.element}
+		return nil
+	}
+
+	var uta, err = pgtype.ParseUntypedTextArray(string(src))
+	if err != nil {
+		return err
+	}
+	var elements = make([]interface{}, len(uta.Elements))
+	for idx, str := range uta.Elements {
+		var elementSrc []byte
+		if str != "NULL" || uta.Quoted[idx] {
+			elementSrc = []byte(str)
+		}
+		var element = dst.element.NewTypeValue().(*pgtype.CompositeType)
+		if err := element.DecodeText(ci, elementSrc); err != nil {
+			return fmt.Errorf("error decoding array element %d: %w", idx, err)
+		}
+		elements[idx] = element.Get()
+	}
+
+	*dst = compositeArray{
+		Elements:   elements,
+		Dimensions: uta.Dimensions,
+		Status:     pgtype.Present,
+		element:    dst.element,
+	}
+	return nil
+}
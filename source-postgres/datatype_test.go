@@ -8,11 +8,28 @@ import (
 	"github.com/estuary/connectors/sqlcapture/tests"
 )
 
-const arraySchemaPattern = `{"required":["dimensions","elements"],"type":"object","properties":{"dimensions":{"type":"array","items":{"type":"integer"}},"elements":{"type":"array","items":%s}}}`
+const arraySchemaPattern = `{"type":["array","null"],"items":{"anyOf":[%s,{"type":"array"}]}}`
+
+const compositeSchema = `{"type":["object","null"],"required":["author","stars","moods"],"properties":{` +
+	`"author":{"type":["string","null"]},` +
+	`"stars":{"type":["integer","null"]},` +
+	`"moods":{"type":["array","null"],"items":{"anyOf":[{"type":["string","null"],"enum":["sad","ok","happy",null]},{"type":"array"}]}}}}`
 
 // TestDatatypes runs the generic datatype discovery and round-tripping test on various datatypes.
 func TestDatatypes(t *testing.T) {
 	var ctx = context.Background()
+
+	// User-defined enum type for the enumeration test cases.
+	TestBackend.Query(ctx, t, `DROP TYPE IF EXISTS test_mood;`)
+	TestBackend.Query(ctx, t, `CREATE TYPE test_mood AS ENUM ('sad', 'ok', 'happy');`)
+	t.Cleanup(func() { TestBackend.Query(ctx, t, `DROP TYPE test_mood;`) })
+
+	// User-defined composite type for the composite test cases. It's dropped before
+	// the enum type, since one of its attributes is an array of that type.
+	TestBackend.Query(ctx, t, `DROP TYPE IF EXISTS test_review;`)
+	TestBackend.Query(ctx, t, `CREATE TYPE test_review AS (author text, stars integer, moods test_mood[]);`)
+	t.Cleanup(func() { TestBackend.Query(ctx, t, `DROP TYPE test_review;`) })
+
 	tests.TestDatatypes(ctx, t, TestBackend, []tests.DatatypeTestCase{
 		// Basic Boolean/Numeric/Text Types
 		{ColumnType: `boolean`, ExpectType: `{"type":["boolean","null"]}`, InputValue: `false`, ExpectValue: `false`},
@@ -95,27 +112,42 @@ func TestDatatypes(t *testing.T) {
 		//    [...] declaring the array size or number of dimensions in CREATE TABLE is
 		//    simply documentation; it does not affect run-time behavior.
 		// This set of test cases exercises that expectation.
-		{ColumnType: `integer[3][3]`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["integer","null"]}`), InputValue: `{{{{{{1}}}}}}`, ExpectValue: `{"dimensions":[1,1,1,1,1,1],"elements":[1]}`},
-		{ColumnType: `integer[3][3]`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["integer","null"]}`), InputValue: `{1,2,3,4,5,6}`, ExpectValue: `{"dimensions":[6],"elements":[1,2,3,4,5,6]}`},
-		{ColumnType: `integer[3][3]`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["integer","null"]}`), InputValue: `{{1,2,3},{4,5,6},{7,8,9}}`, ExpectValue: `{"dimensions":[3,3],"elements":[1,2,3,4,5,6,7,8,9]}`},
+		{ColumnType: `integer[3][3]`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["integer","null"]}`), InputValue: `{{{{{{1}}}}}}`, ExpectValue: `[[[[[[1]]]]]]`},
+		{ColumnType: `integer[3][3]`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["integer","null"]}`), InputValue: `{1,2,3,4,5,6}`, ExpectValue: `[1,2,3,4,5,6]`},
+		{ColumnType: `integer[3][3]`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["integer","null"]}`), InputValue: `{{1,2,3},{4,5,6},{7,8,9}}`, ExpectValue: `[[1,2,3],[4,5,6],[7,8,9]]`},
+		{ColumnType: `integer[3][3]`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["integer","null"]}`), InputValue: `{{1,NULL},{NULL,4}}`, ExpectValue: `[[1,null],[null,4]]`},
+		{ColumnType: `integer[3][3]`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["integer","null"]}`), InputValue: `{}`, ExpectValue: `[]`},
+		{ColumnType: `integer[3][3] not null`, ExpectType: `{"type":"array","items":{"anyOf":[{"type":["integer","null"]},{"type":"array"}]}}`, InputValue: `{{1},{2}}`, ExpectValue: `[[1],[2]]`},
 
 		// Sanity-check various types of array for discovery and round-tripping
-		{ColumnType: `bigint ARRAY`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["integer","null"]}`), InputValue: `{1,2,null,4}`, ExpectValue: `{"dimensions":[4],"elements":[1,2,null,4]}`},
-		{ColumnType: `boolean ARRAY`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["boolean","null"]}`), InputValue: `{true, false, null, true}`, ExpectValue: `{"dimensions":[4],"elements":[true,false,null,true]}`},
-		{ColumnType: `bytea ARRAY`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["string","null"],"contentEncoding":"base64"}`), InputValue: `{abcd, efgh}`, ExpectValue: `{"dimensions":[2],"elements":["YWJjZA==","ZWZnaA=="]}`},
-		{ColumnType: `varchar(12) ARRAY`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["string","null"]}`), InputValue: `{foo, bar}`, ExpectValue: `{"dimensions":[2],"elements":["foo","bar"]}`},
-		{ColumnType: `char(5) ARRAY`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["string","null"]}`), InputValue: `{foo, bar}`, ExpectValue: `{"dimensions":[2],"elements":["foo  ","bar  "]}`},
-		{ColumnType: `cidr ARRAY`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["string","null"]}`), InputValue: `{192.168.100.0/24, 2001:4f8:3:ba::/64}`, ExpectValue: `{"dimensions":[2],"elements":["192.168.100.0/24","2001:4f8:3:ba::/64"]}`},
-		{ColumnType: `date ARRAY`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["string","null"],"format":"date-time"}`), InputValue: []interface{}{`2022-01-09`, `2022-01-10`}, ExpectValue: `{"dimensions":[2],"elements":["2022-01-09","2022-01-10"]}`},
-		{ColumnType: `double precision ARRAY`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["number","null"]}`), InputValue: []interface{}{1.23, 4.56}, ExpectValue: `{"dimensions":[2],"elements":[1.23,4.56]}`},
-		{ColumnType: `inet ARRAY`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["string","null"]}`), InputValue: []interface{}{`192.168.100.0/24`, `2001:4f8:3:ba::/64`}, ExpectValue: `{"dimensions":[2],"elements":["192.168.100.0/24","2001:4f8:3:ba::/64"]}`},
-		{ColumnType: `integer ARRAY`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["integer","null"]}`), InputValue: []interface{}{1, 2, nil, 4}, ExpectValue: `{"dimensions":[4],"elements":[1,2,null,4]}`},
-		{ColumnType: `numeric ARRAY`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["string","null"]}`), InputValue: []interface{}{`123.456`, `-789.0123`}, ExpectValue: `{"dimensions":[2],"elements":["123456e-3","-7890123e-4"]}`},
-		{ColumnType: `real ARRAY`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["number","null"]}`), InputValue: []interface{}{123.456, 789.0123}, ExpectValue: `{"dimensions":[2],"elements":[123.456,789.0123]}`},
-		{ColumnType: `smallint ARRAY`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["integer","null"]}`), InputValue: []interface{}{123, 456, 789}, ExpectValue: `{"dimensions":[3],"elements":[123,456,789]}`},
-		{ColumnType: `text ARRAY`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["string","null"]}`), InputValue: []interface{}{`Hello, world!`, `asdf`}, ExpectValue: `{"dimensions":[2],"elements":["Hello, world!","asdf"]}`},
-		{ColumnType: `uuid ARRAY`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["string","null"],"format":"uuid"}`), InputValue: []interface{}{`a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11`}, ExpectValue: `{"dimensions":[1],"elements":["a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"]}`},
-
-		// TODO(wgd): Add enumeration test case?
+		{ColumnType: `bigint ARRAY`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["integer","null"]}`), InputValue: `{1,2,null,4}`, ExpectValue: `[1,2,null,4]`},
+		{ColumnType: `boolean ARRAY`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["boolean","null"]}`), InputValue: `{true, false, null, true}`, ExpectValue: `[true,false,null,true]`},
+		{ColumnType: `bytea ARRAY`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["string","null"],"contentEncoding":"base64"}`), InputValue: `{abcd, efgh}`, ExpectValue: `["YWJjZA==","ZWZnaA=="]`},
+		{ColumnType: `varchar(12) ARRAY`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["string","null"]}`), InputValue: `{foo, bar}`, ExpectValue: `["foo","bar"]`},
+		{ColumnType: `char(5) ARRAY`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["string","null"]}`), InputValue: `{foo, bar}`, ExpectValue: `["foo  ","bar  "]`},
+		{ColumnType: `cidr ARRAY`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["string","null"]}`), InputValue: `{192.168.100.0/24, 2001:4f8:3:ba::/64}`, ExpectValue: `["192.168.100.0/24","2001:4f8:3:ba::/64"]`},
+		{ColumnType: `date ARRAY`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["string","null"],"format":"date-time"}`), InputValue: []interface{}{`2022-01-09`, `2022-01-10`}, ExpectValue: `["2022-01-09","2022-01-10"]`},
+		{ColumnType: `double precision ARRAY`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["number","null"]}`), InputValue: []interface{}{1.23, 4.56}, ExpectValue: `[1.23,4.56]`},
+		{ColumnType: `inet ARRAY`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["string","null"]}`), InputValue: []interface{}{`192.168.100.0/24`, `2001:4f8:3:ba::/64`}, ExpectValue: `["192.168.100.0/24","2001:4f8:3:ba::/64"]`},
+		{ColumnType: `integer ARRAY`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["integer","null"]}`), InputValue: []interface{}{1, 2, nil, 4}, ExpectValue: `[1,2,null,4]`},
+		{ColumnType: `numeric ARRAY`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["string","null"]}`), InputValue: []interface{}{`123.456`, `-789.0123`}, ExpectValue: `["123456e-3","-7890123e-4"]`},
+		{ColumnType: `real ARRAY`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["number","null"]}`), InputValue: []interface{}{123.456, 789.0123}, ExpectValue: `[123.456,789.0123]`},
+		{ColumnType: `real ARRAY`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["number","null"]}`), InputValue: `{1.5,NULL}`, ExpectValue: `[1.5,null]`},
+		{ColumnType: `numeric ARRAY`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["string","null"]}`), InputValue: `{{1.5},{NULL}}`, ExpectValue: `[["15e-1"],[null]]`},
+		{ColumnType: `smallint ARRAY`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["integer","null"]}`), InputValue: []interface{}{123, 456, 789}, ExpectValue: `[123,456,789]`},
+		{ColumnType: `text ARRAY`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["string","null"]}`), InputValue: []interface{}{`Hello, world!`, `asdf`}, ExpectValue: `["Hello, world!","asdf"]`},
+		{ColumnType: `uuid ARRAY`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["string","null"],"format":"uuid"}`), InputValue: []interface{}{`a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11`}, ExpectValue: `["a0eebc99-9c0b-4ef8-bb6d-6bb9bd380a11"]`},
+
+		// User-defined enum types are captured as their label strings.
+		{ColumnType: `test_mood`, ExpectType: `{"type":["string","null"],"enum":["sad","ok","happy",null]}`, InputValue: `happy`, ExpectValue: `"happy"`},
+		{ColumnType: `test_mood`, ExpectType: `{"type":["string","null"],"enum":["sad","ok","happy",null]}`, InputValue: nil, ExpectValue: `null`},
+		{ColumnType: `test_mood not null`, ExpectType: `{"type":"string","enum":["sad","ok","happy"]}`, InputValue: `ok`, ExpectValue: `"ok"`},
+		{ColumnType: `test_mood ARRAY`, ExpectType: fmt.Sprintf(arraySchemaPattern, `{"type":["string","null"],"enum":["sad","ok","happy",null]}`), InputValue: `{sad,happy}`, ExpectValue: `["sad","happy"]`},
+
+		// User-defined composite types are captured as objects keyed by attribute name.
+		{ColumnType: `test_review`, ExpectType: compositeSchema, InputValue: `(alice,4,"{ok,happy}")`, ExpectValue: `{"author":"alice","moods":["ok","happy"],"stars":4}`},
+		{ColumnType: `test_review`, ExpectType: compositeSchema, InputValue: `(bob,,)`, ExpectValue: `{"author":"bob","moods":null,"stars":null}`},
+		{ColumnType: `test_review`, ExpectType: compositeSchema, InputValue: nil, ExpectValue: `null`},
+		{ColumnType: `test_review ARRAY`, ExpectType: fmt.Sprintf(arraySchemaPattern, compositeSchema), InputValue: `{{"(alice,4,)",NULL},{"(bob,1,{sad})","(carol,,)"}}`, ExpectValue: `[[{"author":"alice","moods":null,"stars":4},null],[{"author":"bob","moods":["sad"],"stars":1},{"author":"carol","moods":null,"stars":null}]]`},
 	})
 }
@@ -17,8 +17,28 @@ import (
 
 // DiscoverTables queries the database for information about tables available for capture.
 func (db *postgresDatabase) DiscoverTables(ctx context.Context) (map[string]sqlcapture.TableInfo, error) {
+	// User-defined enum types are registered with the connection so that their
+	// values are decoded as label strings, and remembered so that subsequent
+	// schema translation and replication can make use of them.
+	var enumTypes, err = getEnumTypes(ctx, db.conn)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list database enum types: %w", err)
+	}
+	registerEnumTypes(db.conn.ConnInfo(), enumTypes)
+	db.enumTypes = enumTypes
+
+	// Likewise user-defined composite types, whose values are decoded as objects
+	// keyed by attribute name. These are registered after enums because their
+	// attributes may be of enum types.
+	compositeTypes, err := getCompositeTypes(ctx, db.conn)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list database composite types: %w", err)
+	}
+	registerCompositeTypes(db.conn.ConnInfo(), compositeTypes)
+	db.compositeTypes = compositeTypes
+
 	// Get lists of all columns and primary keys in the database
-	var columns, err = getColumns(ctx, db.conn)
+	columns, err := getColumns(ctx, db.conn, db.config.Advanced.SystemSchemas)
 	if err != nil {
 		return nil, fmt.Errorf("unable to list database columns: %w", err)
 	}
@@ -26,6 +46,15 @@ func (db *postgresDatabase) DiscoverTables(ctx context.Context) (map[string]sqlc
 	if err != nil {
 		return nil, fmt.Errorf("unable to list database primary keys: %w", err)
 	}
+	partitions, partitioned, err := getPartitions(ctx, db.conn)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list partitioned tables: %w", err)
+	}
+	db.partitioned = partitioned
+	sequences, err := getSequences(ctx, db.conn, db.config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to list captured sequences: %w", err)
+	}
 
 	// Aggregate column and primary key information into TableInfo structs
 	// using a map from fully-qualified "<schema>.<name>" table names to
@@ -35,7 +64,7 @@ func (db *postgresDatabase) DiscoverTables(ctx context.Context) (map[string]sqlc
 		var id = column.TableSchema + "." + column.TableName
 		var info, ok = tableMap[id]
 		if !ok {
-			info = sqlcapture.TableInfo{Schema: column.TableSchema, Name: column.TableName}
+			info = sqlcapture.TableInfo{Schema: column.TableSchema, Name: column.TableName, Partition: partitions[sqlcapture.JoinStreamID(column.TableSchema, column.TableName)]}
 		}
 		if info.Columns == nil {
 			info.Columns = make(map[string]sqlcapture.ColumnInfo)
@@ -55,46 +84,21 @@ func (db *postgresDatabase) DiscoverTables(ctx context.Context) (map[string]sqlc
 		info.PrimaryKey = key
 		tableMap[id] = info
 	}
+
+	// Captured sequences are discovered alongside tables, as streams of their own.
+	db.sequences = make(map[string]bool)
+	for id, info := range sequences {
+		db.sequences[id] = true
+		tableMap[id] = info
+	}
 	return tableMap, nil
 }
 
 // TranslateDBToJSONType returns JSON schema information about the provided database column type.
 func (db *postgresDatabase) TranslateDBToJSONType(column sqlcapture.ColumnInfo) (*jsonschema.Type, error) {
-	// If the column type looks like `_foo` then it's an array of elements of type `foo`.
-	var columnType = column.DataType
-	var arrayColumn = false
-	if strings.HasPrefix(columnType, "_") {
-		columnType = strings.TrimPrefix(columnType, "_")
-		arrayColumn = true
-	}
-
-	// Translate the basic value/element type into a JSON Schema type
-	var colSchema, ok = postgresTypeToJSON[columnType]
-	if !ok {
-		return nil, fmt.Errorf("unhandled PostgreSQL type %q", columnType)
-	}
-	colSchema.nullable = column.IsNullable
-	var jsonType = colSchema.toType()
-
-	// If the column is an array, wrap the element type in a multidimensional
-	// array structure.
-	if arrayColumn {
-		jsonType = &jsonschema.Type{
-			Type: "object",
-			Extras: map[string]interface{}{
-				"properties": map[string]*jsonschema.Type{
-					"dimensions": {
-						Type:  "array",
-						Items: &jsonschema.Type{Type: "integer"},
-					},
-					"elements": {
-						Type:  "array",
-						Items: jsonType,
-					},
-				},
-			},
-			Required: []string{"dimensions", "elements"},
-		}
+	var jsonType, err = db.translateTypeToJSON(column.DataType, column.IsNullable)
+	if err != nil {
+		return nil, err
 	}
 
 	// Pass-through a postgres column description.
@@ -104,6 +108,54 @@ func (db *postgresDatabase) TranslateDBToJSONType(column sqlcapture.ColumnInfo)
 	return jsonType, nil
 }
 
+// translateTypeToJSON returns the JSON schema of values of the named type. The
+// schemas of arrays and composite types are built from those of their elements
+// and attributes.
+func (db *postgresDatabase) translateTypeToJSON(typeName string, nullable bool) (*jsonschema.Type, error) {
+	// If the type looks like `_foo` then it's an array of elements of type `foo`.
+	if strings.HasPrefix(typeName, "_") {
+		// Array elements may be null regardless of the nullability of the column.
+		var elementType, err = db.translateTypeToJSON(strings.TrimPrefix(typeName, "_"), true)
+		if err != nil {
+			return nil, err
+		}
+
+		// PostgreSQL doesn't enforce the number of dimensions of an array, so the
+		// items of an array value are either elements or further nested arrays.
+		var jsonType = columnSchema{type_: "array", nullable: nullable}.toType()
+		jsonType.Items = &jsonschema.Type{
+			AnyOf: []*jsonschema.Type{elementType, {Type: "array"}},
+		}
+		return jsonType, nil
+	}
+
+	if colSchema, ok := postgresTypeToJSON[typeName]; ok {
+		colSchema.nullable = nullable
+		return colSchema.toType(), nil
+	}
+	if enum, ok := db.enumTypes[typeName]; ok {
+		return columnSchema{type_: "string", enum: enum.Labels, nullable: nullable}.toType(), nil
+	}
+	if composite, ok := db.compositeTypes[typeName]; ok {
+		var properties = make(map[string]*jsonschema.Type)
+		var required []string
+		for _, field := range composite.Fields {
+			// Attributes of a composite value may always be null.
+			var fieldType, err = db.translateTypeToJSON(field.TypeName, true)
+			if err != nil {
+				return nil, fmt.Errorf("attribute %q of composite type %q: %w", field.Name, typeName, err)
+			}
+			properties[field.Name] = fieldType
+			required = append(required, field.Name)
+		}
+		var jsonType = columnSchema{type_: "object", nullable: nullable}.toType()
+		jsonType.Extras["properties"] = properties
+		jsonType.Required = required
+		return jsonType, nil
+	}
+	return nil, fmt.Errorf("unhandled PostgreSQL type %q", typeName)
+}
+
 func translateRecordFields(table *sqlcapture.TableInfo, f map[string]interface{}) error {
 	if f == nil {
 		return nil
@@ -147,6 +199,8 @@ func translateRecordField(column *sqlcapture.ColumnInfo, val interface{}) (inter
 		return s.String(), nil
 	case pgtype.Bytea:
 		return x.Bytes, nil
+	case pgtype.TID:
+		return rowID(x), nil
 	case pgtype.Float4:
 		return x.Float, nil
 	case pgtype.Float8:
@@ -156,11 +210,22 @@ func translateRecordField(column *sqlcapture.ColumnInfo, val interface{}) (inter
 		pgtype.HstoreArray, pgtype.InetArray, pgtype.Int2Array, pgtype.Int4Array,
 		pgtype.Int8Array, pgtype.JSONBArray, pgtype.MacaddrArray, pgtype.NumericArray,
 		pgtype.TextArray, pgtype.TimestampArray, pgtype.TimestamptzArray, pgtype.TsrangeArray,
-		pgtype.TstzrangeArray, pgtype.UUIDArray, pgtype.UntypedTextArray, pgtype.VarcharArray:
+		pgtype.TstzrangeArray, pgtype.UUIDArray, pgtype.UntypedTextArray, pgtype.VarcharArray,
+		compositeArray:
 		// TODO(wgd): If PostgreSQL value translation starts using the provided column
 		// information, this will need to be plumbed through the array translation
 		// logic so that the same behavior can apply to individual array elements.
 		return translateArray(nil, x)
+	case map[string]interface{}: // Composite types
+		var obj = make(map[string]interface{}, len(x))
+		for name, fieldVal := range x {
+			var translated, err = translateRecordField(nil, fieldVal)
+			if err != nil {
+				return nil, fmt.Errorf("error translating attribute %q: %w", name, err)
+			}
+			obj[name] = translated
+		}
+		return obj, nil
 	}
 	if _, ok := val.(json.Marshaler); ok {
 		return val, nil
@@ -172,6 +237,9 @@ func translateRecordField(column *sqlcapture.ColumnInfo, val interface{}) (inter
 	return val, nil
 }
 
+// translateArray translates the elements of an array value and nests them into
+// JSON arrays according to its dimensions, so that `{{1,2},{3,NULL}}` becomes
+// `[[1,2],[3,null]]`.
 func translateArray(column *sqlcapture.ColumnInfo, x interface{}) (interface{}, error) {
 	// Use reflection to extract the 'elements' field
 	var array = reflect.ValueOf(x)
@@ -186,6 +254,9 @@ func translateArray(column *sqlcapture.ColumnInfo, x interface{}) (interface{},
 	var vals = make([]interface{}, elements.Len())
 	for idx := 0; idx < len(vals); idx++ {
 		var element = elements.Index(idx)
+		if isNullElement(element) {
+			continue
+		}
 		var translated, err = translateRecordField(column, element.Interface())
 		if err != nil {
 			return nil, fmt.Errorf("error translating array element %d: %w", idx, err)
@@ -198,18 +269,51 @@ func translateArray(column *sqlcapture.ColumnInfo, x interface{}) (interface{},
 		return nil, fmt.Errorf("array translation error: expected Dimensions to have type []ArrayDimension")
 	}
 	var dims = make([]int, len(dimensions))
+	var count = 1
 	for idx := 0; idx < len(dims); idx++ {
 		dims[idx] = int(dimensions[idx].Length)
+		count *= dims[idx]
+	}
+	if len(dims) > 0 && count != len(vals) {
+		return nil, fmt.Errorf("array translation error: dimensions %v don't match %d elements", dims, len(vals))
 	}
+	return nestArrayElements(vals, dims), nil
+}
 
-	return map[string]interface{}{
-		"dimensions": dims,
-		"elements":   vals,
-	}, nil
+// isNullElement returns true if an array element is a NULL value. The driver
+// decodes NULL elements of most arrays into values with a Null status, which
+// would otherwise be translated into zero values like 0 or "".
+func isNullElement(element reflect.Value) bool {
+	if element.Kind() == reflect.Interface {
+		if element.IsNil() {
+			return true
+		}
+		element = element.Elem()
+	}
+	if element.Kind() != reflect.Struct {
+		return false
+	}
+	var status = element.FieldByName("Status")
+	return status.IsValid() && status.Interface() == pgtype.Null
+}
+
+// nestArrayElements arranges the elements of a multidimensional array, which
+// are listed in row-major order, into nested arrays of the given dimensions.
+func nestArrayElements(elements []interface{}, dims []int) []interface{} {
+	if len(dims) <= 1 || dims[0] == 0 {
+		return elements
+	}
+	var stride = len(elements) / dims[0]
+	var nested = make([]interface{}, dims[0])
+	for idx := range nested {
+		nested[idx] = nestArrayElements(elements[idx*stride:(idx+1)*stride], dims[1:])
+	}
+	return nested
 }
 
 type columnSchema struct {
 	contentEncoding string
+	enum            []string
 	format          string
 	nullable        bool
 	type_           string
@@ -232,6 +336,15 @@ func (s columnSchema) toType() *jsonschema.Type {
 	} else {
 		out.Type = s.type_
 	}
+
+	if s.enum != nil {
+		for _, label := range s.enum {
+			out.Enum = append(out.Enum, label)
+		}
+		if s.nullable {
+			out.Enum = append(out.Enum, nil)
+		}
+	}
 	return out
 }
 
@@ -254,6 +367,7 @@ var postgresTypeToJSON = map[string]columnSchema{
 	"bit":     {type_: "string"},
 	"varbit":  {type_: "string"},
 
+	// JSON columns aren't constrained to objects, since they may hold any JSON value.
 	"json":     {},
 	"jsonb":    {},
 	"jsonpath": {type_: "string"},
@@ -293,10 +407,7 @@ const queryDiscoverColumns = `
   FROM information_schema.columns c
   JOIN information_schema.tables t ON (c.table_schema = t.table_schema AND c.table_name = t.table_name)
   WHERE
-		c.table_schema != 'pg_catalog' AND
-		c.table_schema != 'information_schema' AND
-		c.table_schema != 'pg_internal' AND
-		c.table_schema != 'catalog_history' AND
+		%s
 		t.table_type = 'BASE TABLE'
   ORDER BY
 		c.table_schema,
@@ -306,10 +417,24 @@ const queryDiscoverColumns = `
 
 const queryColumnDescription = `SELECT pg_catalog.col_description($1::regclass::oid, $2) AS description;`
 
-func getColumns(ctx context.Context, conn *pgx.Conn) ([]sqlcapture.ColumnInfo, error) {
+// systemSchemas are the schemas which hold the system catalogs and other internal
+// tables. They aren't discovered unless the `discoverSystemSchemas` option is set.
+var systemSchemas = []string{"pg_catalog", "information_schema", "pg_internal", "catalog_history"}
+
+// discoverColumnsQuery returns the query which lists every column of every table,
+// excluding those in system schemas unless includeSystemSchemas is set.
+func discoverColumnsQuery(includeSystemSchemas bool) string {
+	var filter string
+	if !includeSystemSchemas {
+		filter = "c.table_schema NOT IN ('" + strings.Join(systemSchemas, "', '") + "') AND"
+	}
+	return fmt.Sprintf(queryDiscoverColumns, filter)
+}
+
+func getColumns(ctx context.Context, conn *pgx.Conn, includeSystemSchemas bool) ([]sqlcapture.ColumnInfo, error) {
 	var columns []sqlcapture.ColumnInfo
 	var sc sqlcapture.ColumnInfo
-	var _, err = conn.QueryFunc(ctx, queryDiscoverColumns, nil,
+	var _, err = conn.QueryFunc(ctx, discoverColumnsQuery(includeSystemSchemas), nil,
 		[]interface{}{&sc.TableSchema, &sc.TableName, &sc.Index, &sc.Name, &sc.IsNullable, &sc.DataType},
 		func(r pgx.QueryFuncRow) error {
 			columns = append(columns, sc)
@@ -326,6 +451,56 @@ func getColumns(ctx context.Context, conn *pgx.Conn) ([]sqlcapture.ColumnInfo, e
 	return columns, err
 }
 
+// enumType describes a user-defined enum type.
+type enumType struct {
+	OID      uint32
+	ArrayOID uint32
+	Name     string
+	Labels   []string // Labels of the enum, in their sort order.
+}
+
+const queryDiscoverEnumTypes = `
+  SELECT t.oid, t.typarray, t.typname, array_agg(e.enumlabel::text ORDER BY e.enumsortorder)
+  FROM pg_catalog.pg_type t
+  JOIN pg_catalog.pg_enum e ON (e.enumtypid = t.oid)
+  GROUP BY t.oid, t.typarray, t.typname;
+`
+
+// getEnumTypes queries the database to produce a map from type names to the
+// enum types of those names.
+func getEnumTypes(ctx context.Context, conn *pgx.Conn) (map[string]*enumType, error) {
+	var types = make(map[string]*enumType)
+	var et enumType
+	var _, err = conn.QueryFunc(ctx, queryDiscoverEnumTypes, nil,
+		[]interface{}{&et.OID, &et.ArrayOID, &et.Name, &et.Labels},
+		func(r pgx.QueryFuncRow) error {
+			var t = et
+			types[t.Name] = &t
+			return nil
+		})
+	return types, err
+}
+
+// registerEnumTypes adds enum types and arrays of them to a type registry, so
+// that values of these types are decoded into their label strings rather than
+// being treated as values of an unknown type.
+func registerEnumTypes(connInfo *pgtype.ConnInfo, types map[string]*enumType) {
+	for _, et := range types {
+		connInfo.RegisterDataType(pgtype.DataType{
+			Value: pgtype.NewEnumType(et.Name, et.Labels),
+			Name:  et.Name,
+			OID:   et.OID,
+		})
+		if et.ArrayOID != 0 {
+			connInfo.RegisterDataType(pgtype.DataType{
+				Value: &pgtype.EnumArray{},
+				Name:  "_" + et.Name,
+				OID:   et.ArrayOID,
+			})
+		}
+	}
+}
+
 // Query copied from pgjdbc's method PgDatabaseMetaData.getPrimaryKeys() with
 // the always-NULL `TABLE_CAT` column omitted.
 //
@@ -9,7 +9,10 @@ import (
 	"github.com/jackc/pgtype"
 )
 
-const numericKey = "N"
+const (
+	numericKey = "N"
+	rowIDKey   = "T"
+)
 
 // encodePgNumericKeyFDB encodes a pgtype.Numeric value to an FDB tuple.
 func encodePgNumericKeyFDB(key pgtype.Numeric) (tuple.Tuple, error) {
@@ -36,6 +39,27 @@ func maybeDecodePgNumericTuple(t tuple.Tuple) interface{} {
 	return nil
 }
 
+// encodeRowIDKeyFDB encodes the `ctid` of a row to an FDB tuple, which orders rows
+// by block number and then by their offset within the block.
+func encodeRowIDKeyFDB(id rowID) tuple.Tuple {
+	return tuple.Tuple{rowIDKey, int64(id.BlockNumber), int64(id.OffsetNumber)}
+}
+
+// maybeDecodeRowIDTuple tries to decode the input tuple as the `ctid` of a row, which is
+// returned as a pgtype.TID so that it can be used as a query argument. Returns nil if
+// the tuple isn't one.
+func maybeDecodeRowIDTuple(t tuple.Tuple) interface{} {
+	if len(t) != 3 || t[0] != rowIDKey {
+		return nil
+	}
+	var block, blockOK = t[1].(int64)
+	var offset, offsetOK = t[2].(int64)
+	if !blockOK || !offsetOK {
+		return nil
+	}
+	return pgtype.TID{BlockNumber: uint32(block), OffsetNumber: uint16(offset), Status: pgtype.Present}
+}
+
 func encodePgNumeric(n pgtype.Numeric) (tuple.Tuple, error) {
 	if n.Status == pgtype.Null {
 		return nil, errors.New("Null value in Numeric Key Field")
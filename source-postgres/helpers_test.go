@@ -51,7 +51,6 @@ func TestMain(m *testing.M) {
 	}
 
 	// Tweak some parameters to make things easier to test on a smaller scale
-	backfillChunkSize = 16
 	replicationBufferSize = 0
 
 	// Open a connection to the database which will be used for creating and
@@ -78,6 +77,7 @@ func TestMain(m *testing.M) {
 
 	TestDefaultConfig.Advanced.SlotName = *TestReplicationSlot
 	TestDefaultConfig.Advanced.PublicationName = *TestPublicationName
+	TestDefaultConfig.Advanced.BackfillChunkSize = 16
 
 	if err := TestDefaultConfig.Validate(); err != nil {
 		logrus.WithFields(logrus.Fields{"err": err, "config": TestDefaultConfig}).Fatal("error validating test config")
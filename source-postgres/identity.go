@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/estuary/connectors/sqlcapture"
+	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
+)
+
+// ctidColumn is the system column holding the physical location of a row, which may be
+// used as the key of a table without a primary key or any other unique columns. It isn't
+// included in replicated changes, so only backfilled rows are captured with it.
+const ctidColumn = "ctid"
+
+// Settings of a table's REPLICA IDENTITY, as they're stored in `pg_class.relreplident`.
+const (
+	replicaIdentityDefault = "d"
+	replicaIdentityNothing = "n"
+	replicaIdentityFull    = "f"
+	replicaIdentityIndex   = "i"
+)
+
+var replicaIdentityNames = map[string]string{
+	replicaIdentityDefault: "DEFAULT",
+	replicaIdentityNothing: "NOTHING",
+	replicaIdentityFull:    "FULL",
+	replicaIdentityIndex:   "USING INDEX",
+}
+
+// rowID is the `ctid` of a backfilled row. It's captured in the usual `(block,offset)`
+// text form, but keeps its numeric parts so that rows are ordered correctly by it.
+type rowID pgtype.TID
+
+func (id rowID) String() string {
+	return fmt.Sprintf("(%d,%d)", id.BlockNumber, id.OffsetNumber)
+}
+
+func (id rowID) MarshalJSON() ([]byte, error) {
+	return json.Marshal(id.String())
+}
+
+const queryReplicaIdentity = `
+SELECT c.relreplident::text,
+       COALESCE(array_agg(a.attname::text ORDER BY a.attnum) FILTER (WHERE a.attname IS NOT NULL), '{}')
+  FROM pg_catalog.pg_class c
+  JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+  LEFT JOIN pg_catalog.pg_index i ON i.indrelid = c.oid
+   AND CASE WHEN c.relreplident = 'd' THEN i.indisprimary ELSE i.indisreplident END
+  LEFT JOIN pg_catalog.pg_attribute a ON a.attrelid = c.oid AND a.attnum = ANY(i.indkey)
+  WHERE n.nspname = $1 AND c.relname = $2
+  GROUP BY c.relreplident;`
+
+// ValidateKey checks that the replicated changes of a table can be keyed by the given
+// columns, which requires them to be included in the old tuples of updates and deletes.
+func (db *postgresDatabase) ValidateKey(ctx context.Context, info sqlcapture.TableInfo, key []string) error {
+	var identity string
+	var identityColumns []string
+	if err := db.conn.QueryRow(ctx, queryReplicaIdentity, info.Schema, info.Name).Scan(&identity, &identityColumns); err != nil {
+		return fmt.Errorf("error querying replica identity of table %q: %w", info.Name, err)
+	}
+	return validateReplicaIdentity(info, key, identity, identityColumns)
+}
+
+// validateReplicaIdentity returns an error if the replicated changes of a table with the given
+// REPLICA IDENTITY setting and identity columns can't be keyed by the given columns.
+func validateReplicaIdentity(info sqlcapture.TableInfo, key []string, identity string, identityColumns []string) error {
+	var table = pgx.Identifier{info.Schema, info.Name}.Sanitize()
+	var fullIdentity = fmt.Sprintf("ALTER TABLE %s REPLICA IDENTITY FULL;", table)
+
+	if len(key) == 0 {
+		switch identity {
+		case replicaIdentityFull:
+			return fmt.Errorf("table %s has no primary key: specify the key of its stream in the catalog, using columns which uniquely identify its rows or %q", table, ctidColumn)
+		case replicaIdentityIndex:
+			return fmt.Errorf("table %s has no primary key: specify the key of its stream in the catalog, such as the columns of its replica identity index %q", table, identityColumns)
+		}
+		return fmt.Errorf("table %s has no primary key and its REPLICA IDENTITY is %s, so its replicated updates and deletes don't identify the changed rows: add a primary key to the table, or run `%s` and specify the key of its stream in the catalog", table, replicaIdentityNames[identity], fullIdentity)
+	}
+
+	for _, col := range key {
+		if _, ok := info.Columns[col]; !ok && col != ctidColumn {
+			return fmt.Errorf("key column %q doesn't exist in table %s", col, table)
+		}
+	}
+	if identity == replicaIdentityFull {
+		return nil
+	}
+	for _, col := range key {
+		if !containsColumn(identityColumns, col) {
+			return fmt.Errorf("key column %q isn't part of the REPLICA IDENTITY %s of table %s, so it's missing from replicated deletes: run `%s`", col, replicaIdentityNames[identity], table, fullIdentity)
+		}
+	}
+	return nil
+}
+
+func containsColumn(columns []string, name string) bool {
+	for _, col := range columns {
+		if col == name {
+			return true
+		}
+	}
+	return false
+}
+
+// scanSelection returns the columns selected by a backfill scan of a table with the given
+// key. The named columns are selected, or every column of the table if there are none, as
+// well as the `ctid` if it's part of the key.
+func scanSelection(keyColumns, columns []string) string {
+	var selection = "*"
+	if len(columns) > 0 {
+		var names = make([]string, len(columns))
+		for idx, col := range columns {
+			names[idx] = pgx.Identifier{col}.Sanitize()
+		}
+		selection = strings.Join(names, ", ")
+	}
+	if containsColumn(keyColumns, ctidColumn) {
+		return ctidColumn + ", " + selection
+	}
+	return selection
+}
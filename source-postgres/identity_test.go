@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/estuary/connectors/sqlcapture"
+	"github.com/estuary/flow/go/protocols/fdb/tuple"
+	"github.com/jackc/pgtype"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateReplicaIdentity(t *testing.T) {
+	var info = sqlcapture.TableInfo{
+		Schema: "public",
+		Name:   "events",
+		Columns: map[string]sqlcapture.ColumnInfo{
+			"id":   {Name: "id"},
+			"data": {Name: "data"},
+		},
+	}
+
+	for _, tc := range []struct {
+		key             []string
+		identity        string
+		identityColumns []string
+		expect          string
+	}{
+		{nil, replicaIdentityDefault, nil, `table "public"."events" has no primary key and its REPLICA IDENTITY is DEFAULT`},
+		{nil, replicaIdentityNothing, nil, `table "public"."events" has no primary key and its REPLICA IDENTITY is NOTHING`},
+		{nil, replicaIdentityFull, nil, `specify the key of its stream in the catalog, using columns which uniquely identify its rows or "ctid"`},
+		{nil, replicaIdentityIndex, []string{"id"}, `such as the columns of its replica identity index ["id"]`},
+		{[]string{"missing"}, replicaIdentityFull, nil, `key column "missing" doesn't exist in table "public"."events"`},
+		{[]string{"data"}, replicaIdentityDefault, []string{"id"}, `key column "data" isn't part of the REPLICA IDENTITY DEFAULT of table "public"."events"`},
+		{[]string{"ctid"}, replicaIdentityDefault, nil, `key column "ctid" isn't part of the REPLICA IDENTITY DEFAULT`},
+		{[]string{"id"}, replicaIdentityNothing, nil, `ALTER TABLE "public"."events" REPLICA IDENTITY FULL;`},
+		{[]string{"id"}, replicaIdentityDefault, []string{"id"}, ""},
+		{[]string{"id"}, replicaIdentityIndex, []string{"id", "data"}, ""},
+		{[]string{"ctid"}, replicaIdentityFull, nil, ""},
+		{[]string{"data", "id"}, replicaIdentityFull, nil, ""},
+	} {
+		var err = validateReplicaIdentity(info, tc.key, tc.identity, tc.identityColumns)
+		if tc.expect == "" {
+			require.NoError(t, err, "key %q", tc.key)
+		} else {
+			require.Error(t, err, "key %q", tc.key)
+			require.Contains(t, err.Error(), tc.expect)
+		}
+	}
+}
+
+func TestRowIDKeys(t *testing.T) {
+	var db = &postgresDatabase{}
+	var ids = []rowID{
+		{BlockNumber: 0, OffsetNumber: 1, Status: pgtype.Present},
+		{BlockNumber: 0, OffsetNumber: 2, Status: pgtype.Present},
+		{BlockNumber: 0, OffsetNumber: 10, Status: pgtype.Present},
+		{BlockNumber: 2, OffsetNumber: 1, Status: pgtype.Present},
+		{BlockNumber: 10, OffsetNumber: 1, Status: pgtype.Present},
+	}
+
+	var prev []byte
+	for _, id := range ids {
+		var bs, err = json.Marshal(id)
+		require.NoError(t, err)
+		require.Equal(t, `"`+id.String()+`"`, string(bs))
+
+		// Encoded keys order rows by block and then offset.
+		elem, err := db.EncodeKeyFDB(id)
+		require.NoError(t, err)
+		var encoded = elem.(tuple.Tuple).Pack()
+		require.Equal(t, -1, bytes.Compare(prev, encoded), "key of %s", id)
+		prev = encoded
+
+		// And decode to a value which can be used as a query argument.
+		decoded, err := db.DecodeKeyFDB(elem)
+		require.NoError(t, err)
+		require.Equal(t, pgtype.TID(id), decoded)
+	}
+
+	// Backfilled ctids are captured as rowIDs.
+	var translated, err = translateRecordField(nil, pgtype.TID{BlockNumber: 3, OffsetNumber: 4, Status: pgtype.Present})
+	require.NoError(t, err)
+	require.Equal(t, rowID{BlockNumber: 3, OffsetNumber: 4, Status: pgtype.Present}, translated)
+}
+
+func TestBuildScanQueryByRowID(t *testing.T) {
+	require.Equal(t,
+		"SELECT ctid, * FROM public.events ORDER BY (ctid) LIMIT 16;",
+		buildScanQuery(true, []string{"ctid"}, nil, "public", "events", 16))
+	require.Equal(t,
+		"SELECT ctid, * FROM public.events WHERE (ctid) > ($1) ORDER BY (ctid) LIMIT 16;",
+		buildScanQuery(false, []string{"ctid"}, nil, "public", "events", 16))
+	require.Equal(t,
+		"SELECT * FROM public.events WHERE (id) > ($1) ORDER BY (id) LIMIT 16;",
+		buildScanQuery(false, []string{"id"}, nil, "public", "events", 16))
+
+	// Streams which omit some columns only select the others.
+	require.Equal(t,
+		`SELECT "id", "Name" FROM public.events ORDER BY (id) LIMIT 16;`,
+		buildScanQuery(true, []string{"id"}, []string{"id", "Name"}, "public", "events", 16))
+	require.Equal(t,
+		`SELECT ctid, "data" FROM public.events ORDER BY (ctid) LIMIT 16;`,
+		buildScanQuery(true, []string{"ctid"}, []string{"data"}, "public", "events", 16))
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"net"
+	"time"
+
+	"github.com/jackc/pgconn"
+)
+
+// keepaliveDialFunc returns a function which dials database connections with TCP keepalives
+// enabled, so that a connection which has been silently dropped (for instance by a NAT gateway
+// or firewall after a period of inactivity) is detected and closed rather than waited on forever.
+// Keepalive probes are sent once the connection has been idle for the keepalive interval. If the
+// user timeout is nonzero, the connection is also closed once transmitted data (including keepalive
+// probes) has gone unacknowledged for that long.
+func keepaliveDialFunc(keepalive, userTimeout time.Duration) pgconn.DialFunc {
+	var dialer = &net.Dialer{KeepAlive: keepalive}
+	if userTimeout > 0 {
+		dialer.Control = userTimeoutControl(userTimeout)
+	}
+	return dialer.DialContext
+}
@@ -0,0 +1,26 @@
+//go:build linux
+// +build linux
+
+package main
+
+import (
+	"syscall"
+	"time"
+)
+
+// tcpUserTimeout is the TCP_USER_TIMEOUT socket option, which isn't defined by the syscall package.
+const tcpUserTimeout = 0x12
+
+// userTimeoutControl returns a dialer control function which sets the TCP_USER_TIMEOUT
+// option of each socket to the timeout.
+func userTimeoutControl(timeout time.Duration) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		if err := c.Control(func(fd uintptr) {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_TCP, tcpUserTimeout, int(timeout.Milliseconds()))
+		}); err != nil {
+			return err
+		}
+		return sockErr
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeepaliveOptions(t *testing.T) {
+	var cfg = TestDefaultConfig
+	cfg.Advanced.TCPKeepalive = 17
+	cfg.Advanced.TCPUserTimeout = 23
+	var connConfig, err = cfg.ConnConfig()
+	require.NoError(t, err)
+
+	// Every connection to the database, including the replication connection, is dialed
+	// using the connection config, so the socket options are checked on a local listener.
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+
+	conn, err := connConfig.DialFunc(context.Background(), "tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer conn.Close()
+	raw, err := conn.(*net.TCPConn).SyscallConn()
+	require.NoError(t, err)
+
+	var options = make(map[string]int)
+	require.NoError(t, raw.Control(func(fd uintptr) {
+		for name, opt := range map[string][2]int{
+			"keepalive":   {syscall.SOL_SOCKET, syscall.SO_KEEPALIVE},
+			"idle":        {syscall.IPPROTO_TCP, syscall.TCP_KEEPIDLE},
+			"userTimeout": {syscall.IPPROTO_TCP, tcpUserTimeout},
+		} {
+			var value, err = syscall.GetsockoptInt(int(fd), opt[0], opt[1])
+			require.NoError(t, err)
+			options[name] = value
+		}
+	}))
+	require.Equal(t, map[string]int{
+		"keepalive":   1,
+		"idle":        17,
+		"userTimeout": 23000,
+	}, options)
+}
@@ -0,0 +1,14 @@
+//go:build !linux
+// +build !linux
+
+package main
+
+import (
+	"syscall"
+	"time"
+)
+
+// userTimeoutControl returns nil, since the TCP_USER_TIMEOUT option is only supported on Linux.
+func userTimeoutControl(timeout time.Duration) func(network, address string, c syscall.RawConn) error {
+	return nil
+}
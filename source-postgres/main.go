@@ -7,11 +7,13 @@ import (
 	"fmt"
 	"net/url"
 	"strings"
+	"time"
 
 	schemagen "github.com/estuary/connectors/go-schema-gen"
 	"github.com/estuary/connectors/sqlcapture"
 	"github.com/estuary/flow/go/protocols/airbyte"
 	"github.com/estuary/flow/go/protocols/fdb/tuple"
+	"github.com/jackc/pglogrepl"
 	"github.com/jackc/pgtype"
 	"github.com/jackc/pgx/v4"
 	"github.com/sirupsen/logrus"
@@ -41,18 +43,44 @@ func main() {
 
 // Config tells the connector how to connect to and interact with the source database.
 type Config struct {
-	Address  string         `json:"address" jsonschema:"title=Server Address,description=The host or host:port at which the database can be reached."`
-	Database string         `json:"database" jsonschema:"default=postgres,description=Logical database name to capture from."`
-	User     string         `json:"user" jsonschema:"default=flow_capture,description=The database user to authenticate as."`
-	Password string         `json:"password" jsonschema:"description=Password for the specified database user." jsonschema_extras:"secret=true"`
-	Advanced advancedConfig `json:"advanced,omitempty" jsonschema:"title=Advanced Options,description=Options for advanced users. You should not typically need to modify these." jsonschema_extra:"advanced=true"`
+	Address       string         `json:"address" jsonschema:"title=Server Address,description=The host or host:port at which the database can be reached."`
+	Database      string         `json:"database" jsonschema:"default=postgres,description=Logical database name to capture from."`
+	User          string         `json:"user" jsonschema:"default=flow_capture,description=The database user to authenticate as."`
+	Password      string         `json:"password" jsonschema:"description=Password for the specified database user." jsonschema_extras:"secret=true"`
+	SSLMode       string         `json:"sslMode,omitempty" jsonschema:"title=SSL Mode,enum=disable,enum=require,enum=verify-ca,enum=verify-full,description=Whether connections to the database use TLS and how the server's certificate is verified. 'require' only encrypts connections unless a root certificate is given. 'verify-ca' also checks that the certificate is signed by a trusted root and 'verify-full' also checks that it's for the server's hostname. By default TLS is used if the server supports it."`
+	SSLRootCert   string         `json:"sslRootCert,omitempty" jsonschema:"title=SSL Root Certificate,description=PEM contents of the root certificates trusted to sign the server's certificate. The system's roots are trusted if this is empty." jsonschema_extras:"multiline=true"`
+	SSLClientCert string         `json:"sslClientCert,omitempty" jsonschema:"title=SSL Client Certificate,description=PEM contents of the certificate with which the connector authenticates to the server for mutual TLS." jsonschema_extras:"multiline=true"`
+	SSLClientKey  string         `json:"sslClientKey,omitempty" jsonschema:"title=SSL Client Key,description=PEM contents of the private key of the client certificate." jsonschema_extras:"secret=true,multiline=true"`
+	Advanced      advancedConfig `json:"advanced,omitempty" jsonschema:"title=Advanced Options,description=Options for advanced users. You should not typically need to modify these." jsonschema_extra:"advanced=true"`
 }
 
 type advancedConfig struct {
-	PublicationName string `json:"publicationName,omitempty" jsonschema:"default=flow_publication,description=The name of the PostgreSQL publication to replicate from."`
-	SlotName        string `json:"slotName,omitempty" jsonschema:"default=flow_slot,description=The name of the PostgreSQL replication slot to replicate from."`
-	WatermarksTable string `json:"watermarksTable,omitempty" jsonschema:"default=public.flow_watermarks,description=The name of the table used for watermark writes during backfills. Must be fully-qualified in '<schema>.<table>' form."`
-	SkipBackfills   string `json:"skip_backfills,omitempty" jsonschema:"title=Skip Backfills,description=A comma-separated list of fully-qualified table names which should not be backfilled."`
+	PublicationName   string `json:"publicationName,omitempty" jsonschema:"default=flow_publication,description=The name of the PostgreSQL publication to replicate from."`
+	SlotName          string `json:"slotName,omitempty" jsonschema:"default=flow_slot,description=The name of the PostgreSQL replication slot to replicate from."`
+	WatermarksTable   string `json:"watermarksTable,omitempty" jsonschema:"default=public.flow_watermarks,description=The name of the table used for watermark writes during backfills. Must be fully-qualified in '<schema>.<table>' form."`
+	WatermarkMethod   string `json:"watermarkMethod,omitempty" jsonschema:"title=Watermark Method,default=table,enum=table,enum=message,description=How watermarks are written during backfills. 'table' upserts them into the watermarks table. 'message' emits them as logical decoding messages which doesn't require a table or any privileges to write to one but requires PostgreSQL 14 or later when using 'pgoutput'."`
+	SkipBackfills     string `json:"skip_backfills,omitempty" jsonschema:"title=Skip Backfills,description=A comma-separated list of fully-qualified table names which should not be backfilled."`
+	StandbyInterval   int    `json:"standbyMessageIntervalSeconds,omitempty" jsonschema:"title=Standby Message Interval,default=10,description=How often (in seconds) to send status updates acknowledging the replication progress to the database."`
+	HeartbeatInterval int    `json:"heartbeatIntervalSeconds,omitempty" jsonschema:"title=Heartbeat Interval,default=300,description=How long (in seconds) replication may be idle before a heartbeat is written to the watermarks table. The commit of the heartbeat is checkpointed so the position of the capture keeps advancing even when none of the captured tables change. This lets the server recycle WAL which was written for other databases."`
+	StartupTimeout    int    `json:"replicationStartupTimeoutSeconds,omitempty" jsonschema:"title=Replication Startup Timeout,default=60,description=How long (in seconds) to wait for the database to begin logical replication before failing."`
+	ExportSnapshot    bool   `json:"exportSnapshot,omitempty" jsonschema:"title=Export Snapshot,description=Backfill tables from a snapshot exported when the connector creates the replication slot. The snapshot is exactly aligned with the start of replication so backfill queries don't need to be interleaved with watermark writes. Only applies to the initial backfill of a new capture whose slot doesn't exist yet."`
+	UpdateColumns     string `json:"updateColumns,omitempty" jsonschema:"title=Update Columns,default=available,enum=available,enum=full,enum=delta,description=Which columns to include in update events. 'available' includes every column whose value is in the replication log. 'full' also queries the table for unchanged TOAST values which aren't. 'delta' includes only the key columns and changed columns."`
+	RefreshInterval   int    `json:"fullRefreshIntervalSeconds,omitempty" jsonschema:"title=Full Refresh Interval,default=86400,description=How often (in seconds) to rescan the tables of streams whose sync mode is 'full_refresh' and to poll captured sequences."`
+	SystemSchemas     bool   `json:"discoverSystemSchemas,omitempty" jsonschema:"title=Discover System Schemas,description=Also discover the tables of the system schemas such as 'pg_catalog' and 'information_schema'. Changes to system catalogs aren't replicated so their tables should be captured with the 'full_refresh' sync mode."`
+	SchemaDrift       bool   `json:"trackSchemaDrift,omitempty" jsonschema:"title=Track Schema Drift,description=Persist the discovered schema of each captured table in the capture state and log a notice when its columns or their types have changed since the capture last started."`
+	IncludeOrigins    string `json:"includeOrigins,omitempty" jsonschema:"title=Include Origins,description=A comma-separated list of replication origins whose transactions should be captured when the database is a logical replication subscriber. Transactions from other origins are skipped. Changes made on the database itself are always captured."`
+	ExcludeOrigins    string `json:"excludeOrigins,omitempty" jsonschema:"title=Exclude Origins,description=A comma-separated list of replication origins whose transactions should not be captured when the database is a logical replication subscriber. Changes made on the database itself are always captured."`
+	StreamLargeTxns   bool   `json:"streamingTransactions,omitempty" jsonschema:"title=Stream Large Transactions,description=Have the server stream large transactions while they're still in progress instead of spilling them to disk until they commit. Their changes are buffered by the connector and only captured once they commit. Requires PostgreSQL 14 or later."`
+	TCPKeepalive      int    `json:"tcpKeepaliveSeconds,omitempty" jsonschema:"title=TCP Keepalive Interval,default=30,description=How long (in seconds) a database connection may be idle before TCP keepalive probes are sent."`
+	TCPUserTimeout    int    `json:"tcpUserTimeoutSeconds,omitempty" jsonschema:"title=TCP User Timeout,default=60,description=How long (in seconds) data sent over a database connection may go unacknowledged before the connection is closed. This includes keepalive probes so it bounds how long a dropped connection goes undetected. Only supported on Linux."`
+	SequenceKey       string `json:"sequenceKey,omitempty" jsonschema:"title=Sequence Key,description=The name of a property to add to every captured document holding its source sequence: the commit LSN of its change and the change's ordinal within its transaction. Sequences order the changes of each row even across the transition from backfill to replication. Leave empty to omit it."`
+	CreateSlot        bool   `json:"createSlot,omitempty" jsonschema:"title=Create Replication Slot,description=Create the replication slot when the capture starts without a cursor if it doesn't exist yet. Replication then begins at the consistent point of the new slot or at the confirmed position of an existing one rather than at the current WAL position. If another instance of the connector creates the slot at the same time then its slot is used."`
+	CreatePublication bool   `json:"createPublication,omitempty" jsonschema:"title=Create Publication,description=Create the publication if it doesn't exist yet and add the tables of the captured streams and the watermarks table to it when the capture starts. Has no effect on a publication which includes all tables."`
+	PrunePublication  bool   `json:"prunePublication,omitempty" jsonschema:"title=Prune Publication,description=Also drop the tables of streams which have been removed from the catalog from the publication. Requires 'createPublication'. Leave this disabled if the publication is shared with other subscribers."`
+	ReplicationPlugin string `json:"replicationPlugin,omitempty" jsonschema:"title=Replication Plugin,default=pgoutput,enum=pgoutput,enum=wal2json,description=The logical decoding output plugin with which changes are replicated. 'wal2json' may be used on databases which don't offer 'pgoutput'. It doesn't use a publication and doesn't support replication origins or streamed transactions."`
+	BackfillChunkSize int    `json:"backfillChunkSize,omitempty" jsonschema:"title=Backfill Chunk Size,default=4096,description=The number of rows which should be fetched from the database in a single backfill query. Lower it for tables with very wide rows or when the connector is short on memory."`
+	CheckpointRows    int    `json:"backfillCheckpointRows,omitempty" jsonschema:"title=Backfill Checkpoint Rows,description=The number of rows of a backfill chunk to capture between checkpoints. A capture which restarts partway through a chunk then resumes after the last checkpointed row rather than rescanning the whole chunk. Leave unset to only checkpoint whole chunks."`
+	CaptureSequences  string `json:"captureSequences,omitempty" jsonschema:"title=Capture Sequences,description=A comma-separated list of fully-qualified sequence names whose values are captured. Each sequence is discovered as a stream of its own holding a single document with the sequence's last value and owner. Sequences are polled every 'fullRefreshIntervalSeconds' and the document is updated whenever they've changed."`
 }
 
 // Validate checks that the configuration possesses all required properties.
@@ -68,9 +96,71 @@ func (c *Config) Validate() error {
 		}
 	}
 
+	if c.SSLMode != "" {
+		if _, err := c.tlsConfig(""); err != nil {
+			return err
+		}
+	} else if c.SSLRootCert != "" || c.SSLClientCert != "" || c.SSLClientKey != "" {
+		return fmt.Errorf("invalid TLS configuration: 'sslRootCert', 'sslClientCert', and 'sslClientKey' require an 'sslMode'")
+	}
 	if c.Advanced.WatermarksTable != "" && !strings.Contains(c.Advanced.WatermarksTable, ".") {
 		return fmt.Errorf("invalid 'watermarksTable' configuration: table name %q must be fully-qualified as \"<schema>.<table>\"", c.Advanced.WatermarksTable)
 	}
+	if c.Advanced.StandbyInterval < 0 {
+		return fmt.Errorf("invalid 'standbyMessageIntervalSeconds' configuration: interval %d must not be negative", c.Advanced.StandbyInterval)
+	}
+	if c.Advanced.HeartbeatInterval < 0 {
+		return fmt.Errorf("invalid 'heartbeatIntervalSeconds' configuration: interval %d must not be negative", c.Advanced.HeartbeatInterval)
+	}
+	if c.Advanced.StartupTimeout < 0 {
+		return fmt.Errorf("invalid 'replicationStartupTimeoutSeconds' configuration: timeout %d must not be negative", c.Advanced.StartupTimeout)
+	}
+	if c.Advanced.RefreshInterval < 0 {
+		return fmt.Errorf("invalid 'fullRefreshIntervalSeconds' configuration: interval %d must not be negative", c.Advanced.RefreshInterval)
+	}
+	if c.Advanced.TCPKeepalive < 0 {
+		return fmt.Errorf("invalid 'tcpKeepaliveSeconds' configuration: interval %d must not be negative", c.Advanced.TCPKeepalive)
+	}
+	if c.Advanced.TCPUserTimeout < 0 {
+		return fmt.Errorf("invalid 'tcpUserTimeoutSeconds' configuration: timeout %d must not be negative", c.Advanced.TCPUserTimeout)
+	}
+	if c.Advanced.BackfillChunkSize < 0 {
+		return fmt.Errorf("invalid 'backfillChunkSize' configuration: size %d must be positive", c.Advanced.BackfillChunkSize)
+	} else if c.Advanced.BackfillChunkSize > largeBackfillChunkSize {
+		logrus.WithField("backfillChunkSize", c.Advanced.BackfillChunkSize).Warn("backfill chunk size is very large: each chunk is held in memory while it's captured")
+	}
+	if c.Advanced.CheckpointRows < 0 {
+		return fmt.Errorf("invalid 'backfillCheckpointRows' configuration: %d rows must not be negative", c.Advanced.CheckpointRows)
+	}
+	switch c.Advanced.UpdateColumns {
+	case "", updateColumnsAvailable, updateColumnsFull, updateColumnsDelta:
+	default:
+		return fmt.Errorf("invalid 'updateColumns' configuration: must be %q, %q, or %q", updateColumnsAvailable, updateColumnsFull, updateColumnsDelta)
+	}
+	if c.Advanced.IncludeOrigins != "" && c.Advanced.ExcludeOrigins != "" {
+		return fmt.Errorf("invalid 'includeOrigins' and 'excludeOrigins' configuration: only one of them may be set")
+	}
+	if c.Advanced.PrunePublication && !c.Advanced.CreatePublication {
+		return fmt.Errorf("invalid 'prunePublication' configuration: requires 'createPublication' to be enabled")
+	}
+	switch c.Advanced.WatermarkMethod {
+	case "", watermarkMethodTable, watermarkMethodMessage:
+	default:
+		return fmt.Errorf("invalid 'watermarkMethod' configuration: must be %q or %q", watermarkMethodTable, watermarkMethodMessage)
+	}
+	switch c.Advanced.ReplicationPlugin {
+	case "", replicationPluginPgoutput:
+	case replicationPluginWal2JSON:
+		if c.Advanced.IncludeOrigins != "" || c.Advanced.ExcludeOrigins != "" {
+			return fmt.Errorf("invalid 'replicationPlugin' configuration: %q doesn't support 'includeOrigins' or 'excludeOrigins'", replicationPluginWal2JSON)
+		} else if c.Advanced.StreamLargeTxns {
+			return fmt.Errorf("invalid 'replicationPlugin' configuration: %q doesn't support 'streamingTransactions'", replicationPluginWal2JSON)
+		} else if c.Advanced.CreatePublication {
+			return fmt.Errorf("invalid 'replicationPlugin' configuration: %q doesn't use a publication so 'createPublication' must not be enabled", replicationPluginWal2JSON)
+		}
+	default:
+		return fmt.Errorf("invalid 'replicationPlugin' configuration: must be %q or %q", replicationPluginPgoutput, replicationPluginWal2JSON)
+	}
 	if c.Advanced.SkipBackfills != "" {
 		for _, skipStreamID := range strings.Split(c.Advanced.SkipBackfills, ",") {
 			if !strings.Contains(skipStreamID, ".") {
@@ -78,6 +168,13 @@ func (c *Config) Validate() error {
 			}
 		}
 	}
+	if c.Advanced.CaptureSequences != "" {
+		for _, sequenceID := range strings.Split(c.Advanced.CaptureSequences, ",") {
+			if !strings.Contains(sequenceID, ".") {
+				return fmt.Errorf("invalid 'captureSequences' configuration: sequence name %q must be fully-qualified as \"<schema>.<sequence>\"", sequenceID)
+			}
+		}
+	}
 
 	return nil
 }
@@ -95,6 +192,36 @@ func (c *Config) SetDefaults() {
 	if c.Advanced.WatermarksTable == "" {
 		c.Advanced.WatermarksTable = "public.flow_watermarks"
 	}
+	if c.Advanced.StandbyInterval == 0 {
+		c.Advanced.StandbyInterval = 10
+	}
+	if c.Advanced.HeartbeatInterval == 0 {
+		c.Advanced.HeartbeatInterval = 300
+	}
+	if c.Advanced.StartupTimeout == 0 {
+		c.Advanced.StartupTimeout = 60
+	}
+	if c.Advanced.UpdateColumns == "" {
+		c.Advanced.UpdateColumns = updateColumnsAvailable
+	}
+	if c.Advanced.RefreshInterval == 0 {
+		c.Advanced.RefreshInterval = 86400
+	}
+	if c.Advanced.TCPKeepalive == 0 {
+		c.Advanced.TCPKeepalive = 30
+	}
+	if c.Advanced.TCPUserTimeout == 0 {
+		c.Advanced.TCPUserTimeout = 60
+	}
+	if c.Advanced.BackfillChunkSize == 0 {
+		c.Advanced.BackfillChunkSize = defaultBackfillChunkSize
+	}
+	if c.Advanced.WatermarkMethod == "" {
+		c.Advanced.WatermarkMethod = watermarkMethodTable
+	}
+	if c.Advanced.ReplicationPlugin == "" {
+		c.Advanced.ReplicationPlugin = replicationPluginPgoutput
+	}
 
 	// The address config property should accept a host or host:port
 	// value, and if the port is unspecified it should be the PostgreSQL
@@ -117,9 +244,48 @@ func (c *Config) ToURI() string {
 	return uri.String()
 }
 
+// ConnConfig parses the connection configuration used for each connection to the
+// database, which dials it with TCP keepalives and the TCP user timeout applied, and
+// with the configured TLS settings.
+func (c *Config) ConnConfig() (*pgx.ConnConfig, error) {
+	var config, err = pgx.ParseConfig(c.ToURI())
+	if err != nil {
+		return nil, fmt.Errorf("error parsing connection config: %w", err)
+	}
+	// The configured SSL mode takes precedence over any TLS settings of the connection
+	// string, including its fallback to an unencrypted connection.
+	if c.SSLMode != "" {
+		if config.TLSConfig, err = c.tlsConfig(config.Host); err != nil {
+			return nil, err
+		}
+		config.Fallbacks = nil
+	}
+	config.DialFunc = keepaliveDialFunc(
+		time.Duration(c.Advanced.TCPKeepalive)*time.Second,
+		time.Duration(c.Advanced.TCPUserTimeout)*time.Second,
+	)
+	return config, nil
+}
+
+// connect opens a new connection to the database.
+func (c *Config) connect(ctx context.Context) (*pgx.Conn, error) {
+	var config, err = c.ConnConfig()
+	if err != nil {
+		return nil, err
+	}
+	return pgx.ConnectConfig(ctx, config)
+}
+
 type postgresDatabase struct {
-	config *Config
-	conn   *pgx.Conn
+	config         *Config
+	conn           *pgx.Conn
+	enumTypes      map[string]*enumType      // User-defined enum types, by name. Populated during discovery.
+	compositeTypes map[string]*compositeType // User-defined composite types, by name. Populated during discovery.
+	partitioned    map[string]bool           // Stream IDs of the partitioned tables at the roots of partition trees. Populated during discovery.
+	sequences      map[string]bool           // Stream IDs of the captured sequences. Populated during discovery.
+	renames        *columnRenames            // Renamed columns of captured tables. Populated by StartReplication.
+	snapshot       *exportedSnapshot         // Snapshot from which tables are backfilled, if any. Populated by StartReplication.
+	slotLSN        pglogrepl.LSN             // Where replication began from a slot ensured by StartReplication, if any.
 }
 
 func (db *postgresDatabase) Connect(ctx context.Context) error {
@@ -131,16 +297,22 @@ func (db *postgresDatabase) Connect(ctx context.Context) error {
 	}).Info("initializing connector")
 
 	// Normal database connection used for table scanning
-	var conn, err = pgx.Connect(ctx, db.config.ToURI())
+	var conn, err = db.config.connect(ctx)
 	if err != nil {
 		return fmt.Errorf("unable to connect to database: %w", err)
 	}
+	sqlcapture.ConnectionOpened("database")
 	db.conn = conn
 	return nil
 }
 
 func (db *postgresDatabase) Close(ctx context.Context) error {
-	if err := db.conn.Close(ctx); err != nil {
+	if err := db.ReleaseSnapshot(ctx); err != nil {
+		logrus.WithField("err", err).Warn("error releasing snapshot")
+	}
+	var err = db.conn.Close(ctx)
+	sqlcapture.ConnectionClosed("database")
+	if err != nil {
 		return fmt.Errorf("error closing database connection: %w", err)
 	}
 	return nil
@@ -158,6 +330,8 @@ func (db *postgresDatabase) EncodeKeyFDB(key interface{}) (tuple.TupleElement, e
 	switch key := key.(type) {
 	case pgtype.Numeric:
 		return encodePgNumericKeyFDB(key)
+	case rowID:
+		return encodeRowIDKeyFDB(key), nil
 	default:
 		return key, nil
 	}
@@ -169,6 +343,9 @@ func (db *postgresDatabase) DecodeKeyFDB(t tuple.TupleElement) (interface{}, err
 		if d := maybeDecodePgNumericTuple(t); d != nil {
 			return d, nil
 		}
+		if id := maybeDecodeRowIDTuple(t); id != nil {
+			return id, nil
+		}
 
 		return nil, errors.New("failed in decoding the fdb tuple")
 	default:
@@ -176,6 +353,20 @@ func (db *postgresDatabase) DecodeKeyFDB(t tuple.TupleElement) (interface{}, err
 	}
 }
 
+func (db *postgresDatabase) FullRefreshInterval() time.Duration {
+	return time.Duration(db.config.Advanced.RefreshInterval) * time.Second
+}
+
+// RequireColumns is true since inserted rows are replicated with every column, including
+// TOAST values, which are only omitted from the replicated updates which don't change them.
+func (db *postgresDatabase) RequireColumns() bool {
+	return true
+}
+
+func (db *postgresDatabase) TrackSchemaDrift() bool {
+	return db.config.Advanced.SchemaDrift
+}
+
 func (db *postgresDatabase) ShouldBackfill(streamID string) bool {
 	if db.config.Advanced.SkipBackfills != "" {
 		// This repeated splitting is a little inefficient, but this check is done at
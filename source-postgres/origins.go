@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/sirupsen/logrus"
+)
+
+// originFilter decides which transactions are captured according to their replication
+// origin. When the database is itself a logical replication subscriber, the changes it
+// applies on behalf of a subscription are tagged with the origin of that subscription,
+// and `pgoutput` announces the origin of each such transaction with an Origin message
+// following its Begin. Transactions without one originated on the database itself, and
+// are always captured.
+type originFilter struct {
+	include map[string]bool // Origins whose transactions are captured, or nil to capture all of them.
+	exclude map[string]bool // Origins whose transactions are not captured.
+}
+
+// newOriginFilter returns an originFilter for the comma-separated lists of origin names
+// of the `includeOrigins` and `excludeOrigins` options.
+func newOriginFilter(include, exclude string) *originFilter {
+	return &originFilter{
+		include: splitOrigins(include),
+		exclude: splitOrigins(exclude),
+	}
+}
+
+func splitOrigins(names string) map[string]bool {
+	var origins map[string]bool
+	for _, name := range strings.Split(names, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			if origins == nil {
+				origins = make(map[string]bool)
+			}
+			origins[name] = true
+		}
+	}
+	return origins
+}
+
+// captured returns true if the transactions of the named origin should be captured.
+func (f *originFilter) captured(origin string) bool {
+	if f == nil {
+		return true
+	} else if f.include != nil && !f.include[origin] {
+		return false
+	}
+	return !f.exclude[origin]
+}
+
+// handleOrigin records whether the transaction in progress is captured according to its
+// origin. Its changes are skipped until its commit if it isn't.
+func (s *replicationStream) handleOrigin(msg *pglogrepl.OriginMessage) error {
+	if s.nextTxnFinalLSN == 0 {
+		return fmt.Errorf("got ORIGIN message without a transaction in progress")
+	}
+	s.nextTxnSkipped = !s.origins.captured(msg.Name)
+	if s.nextTxnSkipped {
+		logrus.WithFields(logrus.Fields{
+			"origin":   msg.Name,
+			"finalLSN": s.nextTxnFinalLSN,
+		}).Debug("skipping transaction from filtered origin")
+	}
+	return nil
+}
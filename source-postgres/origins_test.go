@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/estuary/connectors/sqlcapture"
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgtype"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOriginFilter(t *testing.T) {
+	var all = newOriginFilter("", "")
+	require.True(t, all.captured("pg_16390"))
+	require.True(t, (*originFilter)(nil).captured("pg_16390"))
+
+	var included = newOriginFilter("pg_16390, pg_16391", "")
+	require.True(t, included.captured("pg_16390"))
+	require.True(t, included.captured("pg_16391"))
+	require.False(t, included.captured("pg_16392"))
+
+	var excluded = newOriginFilter("", "pg_16390")
+	require.False(t, excluded.captured("pg_16390"))
+	require.True(t, excluded.captured("pg_16391"))
+}
+
+func TestDecodeOriginTransactions(t *testing.T) {
+	var ctx = context.Background()
+	var s = &replicationStream{
+		connInfo:      pgtype.NewConnInfo(),
+		relations:     map[uint32]*pglogrepl.RelationMessage{1: testRelation("id", "data")},
+		renames:       newColumnRenames(),
+		updateColumns: updateColumnsAvailable,
+		origins:       newOriginFilter("", "upstream"),
+	}
+	s.tables.active = map[string]struct{}{"public.things": {}}
+
+	// decodeTxn decodes a transaction which inserts a single row, and returns
+	// the operations of the resulting change events.
+	var decodeTxn = func(lsn pglogrepl.LSN, origin string) []sqlcapture.ChangeOp {
+		var msgs = []pglogrepl.Message{&pglogrepl.BeginMessage{FinalLSN: lsn + 2, CommitTime: time.Now()}}
+		if origin != "" {
+			msgs = append(msgs, &pglogrepl.OriginMessage{CommitLSN: lsn + 2, Name: origin})
+		}
+		msgs = append(msgs,
+			&pglogrepl.InsertMessage{RelationID: 1, Tuple: &pglogrepl.TupleData{Columns: []*pglogrepl.TupleDataColumn{
+				{DataType: 't', Data: []byte("1")},
+				{DataType: 't', Data: []byte("value")},
+			}}},
+			&pglogrepl.CommitMessage{CommitLSN: lsn + 2, TransactionEndLSN: lsn + 3},
+		)
+		var ops []sqlcapture.ChangeOp
+		for idx, msg := range msgs {
+			var event, err = s.decodeMessage(ctx, lsn+pglogrepl.LSN(idx), msg)
+			require.NoError(t, err)
+			if event != nil {
+				ops = append(ops, event.Operation)
+			}
+		}
+		return ops
+	}
+
+	// Local transactions and those from other origins are captured, while the
+	// changes of an excluded origin are skipped. Their commits are still relayed
+	// so that the capture's position advances past them.
+	require.Equal(t, []sqlcapture.ChangeOp{sqlcapture.InsertOp, sqlcapture.FlushOp}, decodeTxn(100, ""))
+	require.Equal(t, []sqlcapture.ChangeOp{sqlcapture.InsertOp, sqlcapture.FlushOp}, decodeTxn(200, "other"))
+	require.Equal(t, []sqlcapture.ChangeOp{sqlcapture.FlushOp}, decodeTxn(300, "upstream"))
+	require.Equal(t, []sqlcapture.ChangeOp{sqlcapture.InsertOp, sqlcapture.FlushOp}, decodeTxn(400, ""))
+
+	// Only the listed origins are captured when they're included, along with local transactions.
+	s.origins = newOriginFilter("upstream", "")
+	require.Equal(t, []sqlcapture.ChangeOp{sqlcapture.FlushOp}, decodeTxn(500, "other"))
+	require.Equal(t, []sqlcapture.ChangeOp{sqlcapture.InsertOp, sqlcapture.FlushOp}, decodeTxn(600, "upstream"))
+	require.Equal(t, []sqlcapture.ChangeOp{sqlcapture.InsertOp, sqlcapture.FlushOp}, decodeTxn(700, ""))
+
+	// An origin message must be part of a transaction.
+	var _, err = s.decodeMessage(ctx, 800, &pglogrepl.OriginMessage{Name: "upstream"})
+	require.Error(t, err)
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/estuary/connectors/sqlcapture"
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// Declaratively partitioned tables hold no rows of their own. Their rows are stored in
+// partitions, which are tables in their own right, and logical replication reports each
+// change on the partition which holds the row (unless the publication is created with
+// `publish_via_partition_root`). A partitioned table is captured as a single stream:
+// backfills query the partitioned table, which scans all of its partitions, and the
+// replicated changes of its partitions are captured as changes of the partitioned table.
+// Partitions are omitted from discovered catalogs for the same reason.
+
+// partitionRoot is the partitioned table at the root of a partition tree.
+type partitionRoot struct {
+	Schema string
+	Name   string
+}
+
+const queryPartitionedTables = `
+  SELECT n.nspname::text, c.relname::text, c.relispartition
+  FROM pg_catalog.pg_class c
+  JOIN pg_catalog.pg_namespace n ON (n.oid = c.relnamespace)
+  WHERE c.relispartition OR c.relkind = 'p';`
+
+// getPartitions queries the database to produce the sets of partitions and of partitioned
+// tables at the root of their partition trees, as stream IDs. A partition which is itself
+// partitioned is only a partition.
+func getPartitions(ctx context.Context, conn *pgx.Conn) (partitions, roots map[string]bool, err error) {
+	partitions, roots = make(map[string]bool), make(map[string]bool)
+	var schema, table string
+	var isPartition bool
+	_, err = conn.QueryFunc(ctx, queryPartitionedTables, nil, []interface{}{&schema, &table, &isPartition},
+		func(r pgx.QueryFuncRow) error {
+			if isPartition {
+				partitions[sqlcapture.JoinStreamID(schema, table)] = true
+			} else {
+				roots[sqlcapture.JoinStreamID(schema, table)] = true
+			}
+			return nil
+		})
+	return partitions, roots, err
+}
+
+// queryPartitionRoot finds the table at the root of the partition tree of the relation with
+// the given OID, following its partitioned ancestors. The root of a relation which isn't a
+// partition is itself.
+const queryPartitionRoot = `
+  WITH RECURSIVE ancestors(relid, depth) AS (
+    SELECT $1::oid, 0
+    UNION ALL
+    SELECT i.inhparent, a.depth + 1
+    FROM pg_catalog.pg_inherits i
+    JOIN ancestors a ON (i.inhrelid = a.relid)
+    JOIN pg_catalog.pg_class c ON (c.oid = i.inhrelid)
+    WHERE c.relispartition
+  )
+  SELECT n.nspname::text, c.relname::text
+  FROM ancestors a
+  JOIN pg_catalog.pg_class c ON (c.oid = a.relid)
+  JOIN pg_catalog.pg_namespace n ON (n.oid = c.relnamespace)
+  ORDER BY a.depth DESC
+  LIMIT 1;`
+
+// resolvePartition updates the partition root of a relation whenever a relation message
+// describes it, which happens before its first change of the replication session and again
+// whenever it's altered, including when it's attached to or detached from a partitioned
+// table. The catalogs are queried as of now rather than as of the message, so changes made
+// shortly before a partition is attached or detached may be attributed as of afterwards.
+func (s *replicationStream) resolvePartition(ctx context.Context, msg *pglogrepl.RelationMessage) error {
+	// Nothing needs to be resolved unless partitioned tables were discovered, or if the
+	// relation is itself captured as its own stream.
+	if len(s.partitionedTables) == 0 || s.tableActive(sqlcapture.JoinStreamID(msg.Namespace, msg.RelationName)) {
+		return nil
+	}
+	var conn, err = s.queryConnection(ctx)
+	if err != nil {
+		return err
+	}
+	var root partitionRoot
+	err = conn.QueryRow(ctx, queryPartitionRoot, msg.RelationID).Scan(&root.Schema, &root.Name)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// The relation has since been dropped, so there's no way of knowing.
+		root = partitionRoot{Schema: msg.Namespace, Name: msg.RelationName}
+	} else if err != nil {
+		return fmt.Errorf("error querying partition root of table %q: %w", sqlcapture.JoinStreamID(msg.Namespace, msg.RelationName), err)
+	}
+
+	var prev, wasPartition = s.partitionRoots[msg.RelationID]
+	var isPartition = root.Schema != msg.Namespace || root.Name != msg.RelationName
+	var log = logrus.WithFields(logrus.Fields{
+		"partition": sqlcapture.JoinStreamID(msg.Namespace, msg.RelationName),
+		"root":      sqlcapture.JoinStreamID(root.Schema, root.Name),
+	})
+	switch {
+	case isPartition && wasPartition && prev != root:
+		log.WithField("prevRoot", sqlcapture.JoinStreamID(prev.Schema, prev.Name)).Info("partition has moved to another partitioned table")
+	case isPartition && !wasPartition:
+		log.Debug("capturing changes of partition as its root table")
+	case !isPartition && wasPartition:
+		log.WithField("root", sqlcapture.JoinStreamID(prev.Schema, prev.Name)).Info("partition has been detached from its root table")
+	}
+	if isPartition {
+		s.partitionRoots[msg.RelationID] = root
+	} else {
+		delete(s.partitionRoots, msg.RelationID)
+	}
+	return nil
+}
+
+// captureTable returns the schema and name of the table whose stream captures the changes of
+// a relation: the relation itself if it's captured as its own stream, or otherwise the root of
+// its partition tree if it's a partition.
+func (s *replicationStream) captureTable(rel *pglogrepl.RelationMessage) (schema, table string) {
+	if root, ok := s.partitionRoots[rel.RelationID]; ok && !s.tableActive(sqlcapture.JoinStreamID(rel.Namespace, rel.RelationName)) {
+		return root.Schema, root.Name
+	}
+	return rel.Namespace, rel.RelationName
+}
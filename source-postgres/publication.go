@@ -0,0 +1,117 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/estuary/connectors/sqlcapture"
+	"github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+)
+
+const queryPublication = `SELECT puballtables FROM pg_catalog.pg_publication WHERE pubname = $1;`
+
+const queryPublicationTables = `SELECT schemaname::text, tablename::text FROM pg_catalog.pg_publication_tables WHERE pubname = $1;`
+
+// UpdatePublication creates the publication if it doesn't exist yet and adds the tables of
+// the captured streams to it, along with the watermarks table. The tables of removed streams
+// are also dropped from it if the connector is configured to prune the publication. Nothing
+// is done unless the connector is configured to create the publication, or if it publishes
+// all tables.
+func (db *postgresDatabase) UpdatePublication(ctx context.Context, captured, removed []sqlcapture.TableInfo) error {
+	if !db.config.Advanced.CreatePublication {
+		return nil
+	}
+	var name = db.config.Advanced.PublicationName
+	var publication = pgx.Identifier{name}.Sanitize()
+
+	// The watermarks table is otherwise only created by the first watermark write, but
+	// it must be published before then for the watermark to be replicated. It isn't
+	// needed when watermarks are written as logical decoding messages.
+	var useTable = db.config.Advanced.WatermarkMethod != watermarkMethodMessage
+	if useTable {
+		var query = fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s (slot TEXT PRIMARY KEY, watermark TEXT);", db.config.Advanced.WatermarksTable)
+		if _, err := db.conn.Exec(ctx, query); err != nil {
+			return fmt.Errorf("error creating watermarks table: %w", err)
+		}
+	}
+
+	var allTables bool
+	var err = db.conn.QueryRow(ctx, queryPublication, name).Scan(&allTables)
+	if errors.Is(err, pgx.ErrNoRows) {
+		// Another instance of the connector may create the publication concurrently, in
+		// which case it's used instead.
+		if _, err := db.conn.Exec(ctx, fmt.Sprintf("CREATE PUBLICATION %s;", publication)); err != nil && !isDuplicateObject(err) {
+			return fmt.Errorf("error creating publication %q: %w", name, err)
+		}
+		logrus.WithField("publication", name).Info("created publication")
+	} else if err != nil {
+		return fmt.Errorf("error querying publication %q: %w", name, err)
+	} else if allTables {
+		logrus.WithField("publication", name).Debug("publication includes all tables")
+		return nil
+	}
+
+	var published = make(map[string]string)
+	var schema, table string
+	if _, err := db.conn.QueryFunc(ctx, queryPublicationTables, []interface{}{name}, []interface{}{&schema, &table},
+		func(r pgx.QueryFuncRow) error {
+			published[sqlcapture.JoinStreamID(schema, table)] = pgx.Identifier{schema, table}.Sanitize()
+			return nil
+		}); err != nil {
+		return fmt.Errorf("error querying tables of publication %q: %w", name, err)
+	}
+
+	var wanted = make(map[string]string)
+	if useTable {
+		wanted[strings.ToLower(db.config.Advanced.WatermarksTable)] = db.config.Advanced.WatermarksTable
+	}
+	for _, info := range captured {
+		wanted[sqlcapture.JoinStreamID(info.Schema, info.Name)] = pgx.Identifier{info.Schema, info.Name}.Sanitize()
+	}
+	var unwanted = make(map[string]string)
+	if db.config.Advanced.PrunePublication {
+		for _, info := range removed {
+			unwanted[sqlcapture.JoinStreamID(info.Schema, info.Name)] = pgx.Identifier{info.Schema, info.Name}.Sanitize()
+		}
+	}
+
+	var add, drop = publicationChanges(published, wanted, unwanted)
+	if len(add) > 0 {
+		if _, err := db.conn.Exec(ctx, fmt.Sprintf("ALTER PUBLICATION %s ADD TABLE %s;", publication, strings.Join(add, ", "))); err != nil {
+			return fmt.Errorf("error adding tables %q to publication %q: %w", add, name, err)
+		}
+		logrus.WithFields(logrus.Fields{"publication": name, "tables": add}).Info("added tables to publication")
+	}
+	if len(drop) > 0 {
+		if _, err := db.conn.Exec(ctx, fmt.Sprintf("ALTER PUBLICATION %s DROP TABLE %s;", publication, strings.Join(drop, ", "))); err != nil {
+			return fmt.Errorf("error dropping tables %q from publication %q: %w", drop, name, err)
+		}
+		logrus.WithFields(logrus.Fields{"publication": name, "tables": drop}).Info("dropped tables from publication")
+	}
+	return nil
+}
+
+// publicationChanges returns the names of the tables which must be added to a publication so
+// that it includes every wanted table, and the names of those which must be dropped from it so
+// that it excludes every unwanted table. Each map is keyed by the stream ID of a table. Tables
+// which are both wanted and unwanted are kept.
+func publicationChanges(published, wanted, unwanted map[string]string) (add, drop []string) {
+	for streamID, table := range wanted {
+		if _, ok := published[streamID]; !ok {
+			add = append(add, table)
+		}
+	}
+	for streamID, table := range published {
+		var _, isWanted = wanted[streamID]
+		if _, isUnwanted := unwanted[streamID]; isUnwanted && !isWanted {
+			drop = append(drop, table)
+		}
+	}
+	sort.Strings(add)
+	sort.Strings(drop)
+	return add, drop
+}
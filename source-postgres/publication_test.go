@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestPublicationChanges(t *testing.T) {
+	var published = map[string]string{
+		"public.flow_watermarks": `"public"."flow_watermarks"`,
+		"public.users":           `"public"."users"`,
+		"public.orders":          `"public"."orders"`,
+		"public.audit":           `"public"."audit"`,
+	}
+	var wanted = map[string]string{
+		"public.flow_watermarks": "public.flow_watermarks",
+		"public.users":           `"public"."users"`,
+		"public.items":           `"public"."items"`,
+		"sales.Regions":          `"sales"."Regions"`,
+	}
+	var unwanted = map[string]string{
+		"public.orders":  `"public"."orders"`,
+		"public.users":   `"public"."users"`,
+		"public.deleted": `"public"."deleted"`,
+	}
+
+	// Only the wanted tables which aren't published yet are added, and only the unwanted
+	// tables which are still published are dropped. Tables which aren't mentioned are
+	// left alone, since the publication may be shared.
+	var add, drop = publicationChanges(published, wanted, unwanted)
+	require.Equal(t, []string{`"public"."items"`, `"sales"."Regions"`}, add)
+	require.Equal(t, []string{`"public"."orders"`}, drop)
+
+	add, drop = publicationChanges(wanted, wanted, nil)
+	require.Empty(t, add)
+	require.Empty(t, drop)
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+
+	"github.com/estuary/connectors/sqlcapture"
+	"github.com/jackc/pglogrepl"
+	"github.com/sirupsen/logrus"
+)
+
+// postgresTableMetadata is the persistent per-table metadata of a capture.
+type postgresTableMetadata struct {
+	// RenamedColumns maps the current names of columns which have been renamed since
+	// the capture of the table began to their original names.
+	RenamedColumns map[string]string `json:"renamed_columns,omitempty"`
+}
+
+// columnRenames tracks the columns of captured tables which have been renamed. Values of
+// a renamed column continue to be captured under its original name, so that documents
+// keep matching the discovered schema and the collection key remains valid. It's shared
+// between replication and backfills, which run concurrently.
+type columnRenames struct {
+	sync.RWMutex
+	streams map[string]map[string]string // Map from stream ID to current column name to original name.
+}
+
+func newColumnRenames() *columnRenames {
+	return &columnRenames{streams: make(map[string]map[string]string)}
+}
+
+// load restores the renamed columns of each stream from its persisted metadata.
+func (r *columnRenames) load(metadata map[string]json.RawMessage) error {
+	r.Lock()
+	defer r.Unlock()
+	for streamID, metadataJSON := range metadata {
+		if metadataJSON == nil {
+			continue
+		}
+		var parsed postgresTableMetadata
+		if err := json.Unmarshal(metadataJSON, &parsed); err != nil {
+			return fmt.Errorf("error parsing metadata of stream %q: %w", streamID, err)
+		}
+		if len(parsed.RenamedColumns) > 0 {
+			r.streams[streamID] = parsed.RenamedColumns
+		}
+	}
+	return nil
+}
+
+// record notes that columns of the stream have been renamed, given a map from their
+// old names to their new ones, and returns the resulting metadata of the stream.
+func (r *columnRenames) record(streamID string, renamed map[string]string) (json.RawMessage, error) {
+	r.Lock()
+	defer r.Unlock()
+
+	var prev = r.streams[streamID]
+	var next = make(map[string]string)
+	for name, original := range prev {
+		if _, ok := renamed[name]; !ok {
+			next[name] = original
+		}
+	}
+	for oldName, newName := range renamed {
+		var original = oldName
+		if prior, ok := prev[oldName]; ok {
+			original = prior
+		}
+		if newName != original {
+			next[newName] = original
+		}
+	}
+	r.streams[streamID] = next
+	return json.Marshal(&postgresTableMetadata{RenamedColumns: next})
+}
+
+// originalName returns the original name of a column of the stream.
+func (r *columnRenames) originalName(streamID, name string) string {
+	if r == nil {
+		return name
+	}
+	r.RLock()
+	defer r.RUnlock()
+	if original, ok := r.streams[streamID][name]; ok {
+		return original
+	}
+	return name
+}
+
+// currentName returns the current name of the column of the stream with the given original name.
+func (r *columnRenames) currentName(streamID, original string) string {
+	if r == nil {
+		return original
+	}
+	r.RLock()
+	defer r.RUnlock()
+	for name, prior := range r.streams[streamID] {
+		if prior == original {
+			return name
+		}
+	}
+	return original
+}
+
+// restoreNames renames the fields of a row, keyed by current column names, to their original names.
+func (r *columnRenames) restoreNames(streamID string, fields map[string]interface{}) {
+	if r == nil || fields == nil {
+		return
+	}
+	r.RLock()
+	defer r.RUnlock()
+	var restored = make(map[string]interface{})
+	for name, original := range r.streams[streamID] {
+		if val, ok := fields[name]; ok {
+			restored[original] = val
+			delete(fields, name)
+		}
+	}
+	for name, val := range restored {
+		fields[name] = val
+	}
+}
+
+// detectRenames compares a new relation message to the previous one for the same relation,
+// and returns the old and new names of any renamed columns. PostgreSQL doesn't report renames
+// directly, so a column is considered renamed if the relation otherwise has the same shape
+// and the column at the same position has the same type and flags but a new name. If a name
+// has moved to a different position then columns were dropped or added rather than renamed.
+func detectRenames(prev, next *pglogrepl.RelationMessage) map[string]string {
+	if prev == nil || prev.Namespace != next.Namespace || prev.RelationName != next.RelationName {
+		return nil
+	}
+	if len(prev.Columns) != len(next.Columns) {
+		return nil
+	}
+	var prevNames = make(map[string]bool)
+	for _, col := range prev.Columns {
+		prevNames[col.Name] = true
+	}
+	var renames = make(map[string]string)
+	for idx, col := range next.Columns {
+		var prevCol = prev.Columns[idx]
+		if col.DataType != prevCol.DataType || col.Flags != prevCol.Flags {
+			return nil
+		}
+		if col.Name != prevCol.Name {
+			if prevNames[col.Name] {
+				return nil
+			}
+			renames[prevCol.Name] = col.Name
+		}
+	}
+	return renames
+}
+
+// handleRelation records a relation message, and returns a metadata event if any
+// columns of a captured table have been renamed since the last one. Columns renamed
+// in a partitioned table are renamed in each of its partitions as well.
+func (s *replicationStream) handleRelation(ctx context.Context, msg *pglogrepl.RelationMessage) (*sqlcapture.ChangeEvent, error) {
+	var prev = s.relations[msg.RelationID]
+	s.relations[msg.RelationID] = msg
+	if err := s.resolvePartition(ctx, msg); err != nil {
+		return nil, err
+	}
+
+	var streamID = sqlcapture.JoinStreamID(s.captureTable(msg))
+	var renames = detectRenames(prev, msg)
+	if len(renames) == 0 || !s.tableActive(streamID) {
+		return nil, nil
+	}
+
+	for oldName, newName := range renames {
+		logrus.WithFields(logrus.Fields{
+			"stream":   streamID,
+			"oldName":  oldName,
+			"newName":  newName,
+			"original": s.renames.originalName(streamID, oldName),
+		}).Warn("column renamed, its values will continue to be captured under the original name")
+	}
+	var metadata, err = s.renames.record(streamID, renames)
+	if err != nil {
+		return nil, fmt.Errorf("error serializing metadata of stream %q: %w", streamID, err)
+	}
+	return &sqlcapture.ChangeEvent{
+		Operation: sqlcapture.MetadataOp,
+		Metadata: &sqlcapture.MetadataChangeEvent{
+			StreamID: streamID,
+			Metadata: metadata,
+		},
+	}, nil
+}
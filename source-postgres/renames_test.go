@@ -0,0 +1,60 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/stretchr/testify/require"
+)
+
+func testRelation(names ...string) *pglogrepl.RelationMessage {
+	var rel = &pglogrepl.RelationMessage{RelationID: 1, Namespace: "public", RelationName: "things"}
+	for idx, name := range names {
+		var flags uint8
+		if idx == 0 {
+			flags = 1 // The first column is the key.
+		}
+		rel.Columns = append(rel.Columns, &pglogrepl.RelationMessageColumn{Flags: flags, Name: name, DataType: 25})
+	}
+	return rel
+}
+
+func TestDetectRenames(t *testing.T) {
+	var prev = testRelation("id", "a", "b")
+	require.Nil(t, detectRenames(nil, prev))
+	require.Empty(t, detectRenames(prev, testRelation("id", "a", "b")))
+	require.Equal(t, map[string]string{"a": "x"}, detectRenames(prev, testRelation("id", "x", "b")))
+	require.Equal(t, map[string]string{"id": "key", "b": "y"}, detectRenames(prev, testRelation("key", "a", "y")))
+
+	// Dropping one column and adding another shifts the remaining columns, which isn't a rename.
+	require.Nil(t, detectRenames(prev, testRelation("id", "b", "c")))
+	require.Nil(t, detectRenames(prev, testRelation("id", "a", "b", "c")))
+}
+
+func TestColumnRenames(t *testing.T) {
+	var renames = newColumnRenames()
+	var _, err = renames.record("public.things", map[string]string{"a": "x"})
+	require.NoError(t, err)
+	require.Equal(t, "a", renames.originalName("public.things", "x"))
+	require.Equal(t, "x", renames.currentName("public.things", "a"))
+	require.Equal(t, "b", renames.currentName("public.things", "b"))
+
+	// Renaming a column again still maps it to its original name, and renaming it
+	// back to the original name forgets about it.
+	metadata, err := renames.record("public.things", map[string]string{"x": "y", "b": "z"})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"renamed_columns":{"y":"a","z":"b"}}`, string(metadata))
+	metadata, err = renames.record("public.things", map[string]string{"y": "a"})
+	require.NoError(t, err)
+	require.JSONEq(t, `{"renamed_columns":{"z":"b"}}`, string(metadata))
+
+	var fields = map[string]interface{}{"id": 1, "a": "one", "z": "two"}
+	renames.restoreNames("public.things", fields)
+	require.Equal(t, map[string]interface{}{"id": 1, "a": "one", "b": "two"}, fields)
+
+	// Renames are restored from persisted metadata.
+	var restored = newColumnRenames()
+	require.NoError(t, restored.load(map[string]json.RawMessage{"public.things": metadata, "public.other": nil}))
+	require.Equal(t, "b", restored.originalName("public.things", "z"))
+}
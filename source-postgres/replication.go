@@ -14,34 +14,77 @@ import (
 	"github.com/jackc/pglogrepl"
 	"github.com/jackc/pgproto3/v2"
 	"github.com/jackc/pgtype"
+	"github.com/jackc/pgx/v4"
 	"github.com/sirupsen/logrus"
 )
 
 // StartReplication opens a connection to the database and returns a ReplicationStream
 // from which a neverending sequence of change events can be read.
 func (db *postgresDatabase) StartReplication(ctx context.Context, startCursor string, activeTables map[string]struct{}, discovery map[string]sqlcapture.TableInfo, metadata map[string]json.RawMessage) (sqlcapture.ReplicationStream, error) {
+	// Renamed columns are remembered across restarts in the per-table metadata, and
+	// are shared with backfills so that they can query the current column names.
+	db.renames = newColumnRenames()
+	if err := db.renames.load(metadata); err != nil {
+		return nil, err
+	}
+
+	// All of the setup work up through `START_REPLICATION` is bounded by the
+	// startup timeout, so that an unresponsive database produces an error
+	// rather than a capture which hangs forever without making progress.
+	var startupTimeout = time.Duration(db.config.Advanced.StartupTimeout) * time.Second
+	var startupCtx, cancelStartup = context.WithTimeout(ctx, startupTimeout)
+	defer cancelStartup()
+
 	// Replication database connection used for event streaming
-	connConfig, err := pgconn.ParseConfig(db.config.ToURI())
+	connConfig, err := db.config.ConnConfig()
 	if err != nil {
 		return nil, err
 	}
 	connConfig.RuntimeParams["replication"] = "database"
-	conn, err := pgconn.ConnectConfig(ctx, connConfig)
+	conn, err := pgconn.ConnectConfig(startupCtx, &connConfig.Config)
 	if err != nil {
 		return nil, fmt.Errorf("unable to connect to database for replication: %w", err)
 	}
+	sqlcapture.ConnectionOpened("replication")
+	var closeConn = func() {
+		conn.Close(ctx)
+		sqlcapture.ConnectionClosed("replication")
+	}
 
 	var startLSN pglogrepl.LSN
 	if startCursor != "" {
 		startLSN, err = pglogrepl.ParseLSN(startCursor)
 		if err != nil {
+			closeConn()
 			return nil, fmt.Errorf("error parsing start cursor: %w", err)
 		}
-	} else {
-		// If no start cursor is specified, initialize to the current WAL flush position
-		// obtained via the `IDENTIFY_SYSTEM` command.
-		var sysident, err = pglogrepl.IdentifySystem(ctx, conn)
+	} else if db.config.Advanced.ExportSnapshot {
+		// If no start cursor is specified and the slot doesn't exist yet, it may be
+		// created with an exported snapshot so that backfills can read the tables
+		// exactly as they were at its consistent point, where replication then begins.
+		db.snapshot, err = createSnapshotSlot(startupCtx, conn, db.config.Advanced.SlotName, db.config)
+		if err != nil {
+			closeConn()
+			return nil, err
+		} else if db.snapshot != nil {
+			startLSN = db.snapshot.lsn
+		}
+	}
+	if startCursor == "" && db.snapshot == nil && db.config.Advanced.CreateSlot {
+		// Or the slot may be created without a snapshot, in which case replication begins
+		// at its consistent point. If the slot already exists, replication resumes from
+		// the point up to which its changes were confirmed.
+		if startLSN, err = db.ensureSlot(startupCtx, conn); err != nil {
+			closeConn()
+			return nil, err
+		}
+		db.slotLSN = startLSN
+	} else if startCursor == "" && db.snapshot == nil {
+		// Otherwise, if no start cursor is specified, initialize to the current WAL flush
+		// position obtained via the `IDENTIFY_SYSTEM` command.
+		var sysident, err = pglogrepl.IdentifySystem(startupCtx, conn)
 		if err != nil {
+			closeConn()
 			return nil, fmt.Errorf("unable to read WAL flush LSN from database: %w", err)
 		}
 		startLSN = sysident.XLogPos
@@ -50,40 +93,69 @@ func (db *postgresDatabase) StartReplication(ctx context.Context, startCursor st
 	var slot, publication = db.config.Advanced.SlotName, db.config.Advanced.PublicationName
 
 	logrus.WithFields(logrus.Fields{
-		"startLSN":    startLSN,
-		"publication": publication,
-		"slot":        slot,
+		"startLSN":        startLSN,
+		"publication":     publication,
+		"slot":            slot,
+		"standbyInterval": db.config.Advanced.StandbyInterval,
 	}).Info("starting replication")
 
 	var stream = &replicationStream{
-		replSlot:        slot,
-		pubName:         publication,
-		ackLSN:          uint64(startLSN),
-		lastTxnEndLSN:   startLSN,
-		nextTxnFinalLSN: 0,
-		nextTxnMillis:   0,
-		conn:            conn,
-		connInfo:        pgtype.NewConnInfo(),
-		relations:       make(map[uint32]*pglogrepl.RelationMessage),
+		replSlot:              slot,
+		pubName:               publication,
+		plugin:                db.config.Advanced.ReplicationPlugin,
+		ackLSN:                uint64(startLSN),
+		lastTxnEndLSN:         startLSN,
+		nextTxnFinalLSN:       0,
+		nextTxnMillis:         0,
+		conn:                  conn,
+		connInfo:              pgtype.NewConnInfo(),
+		relations:             make(map[uint32]*pglogrepl.RelationMessage),
+		partitionRoots:        make(map[uint32]partitionRoot),
+		partitionedTables:     db.partitioned,
+		renames:               db.renames,
+		origins:               newOriginFilter(db.config.Advanced.IncludeOrigins, db.config.Advanced.ExcludeOrigins),
+		updateColumns:         db.config.Advanced.UpdateColumns,
+		sequenceKey:           db.config.Advanced.SequenceKey,
+		queryConfig:           db.config,
+		standbyStatusInterval: time.Duration(db.config.Advanced.StandbyInterval) * time.Second,
 		// standbyStatusDeadline is left uninitialized so an update will be sent ASAP
 		events: make(chan sqlcapture.ChangeEvent, replicationBufferSize),
 		errCh:  make(chan error),
 	}
 	stream.tables.active = activeTables
+	registerEnumTypes(stream.connInfo, db.enumTypes)
+	registerCompositeTypes(stream.connInfo, db.compositeTypes)
 
 	// Create the publication and replication slot, ignoring the inevitable errors
 	// when they already exist. We could in theory add some extra logic to check,
-	// but why bother when PostgreSQL will already do what we need?
-	_ = conn.Exec(ctx, fmt.Sprintf(`CREATE PUBLICATION %s FOR ALL TABLES;`, stream.pubName)).Close()
-	_ = conn.Exec(ctx, fmt.Sprintf(`CREATE_REPLICATION_SLOT %s LOGICAL pgoutput;`, stream.replSlot)).Close()
-
-	if err := pglogrepl.StartReplication(ctx, stream.conn, slot, startLSN, pglogrepl.StartReplicationOptions{
-		PluginArgs: []string{
-			`"proto_version" '1'`,
-			fmt.Sprintf(`"publication_names" '%s'`, stream.pubName),
-		},
+	// but why bother when PostgreSQL will already do what we need? The `wal2json`
+	// plugin doesn't use a publication.
+	if stream.plugin != replicationPluginWal2JSON {
+		_ = conn.Exec(startupCtx, fmt.Sprintf(`CREATE PUBLICATION %s FOR ALL TABLES;`, stream.pubName)).Close()
+	}
+	_ = conn.Exec(startupCtx, fmt.Sprintf(`CREATE_REPLICATION_SLOT %s LOGICAL %s;`, stream.replSlot, stream.plugin)).Close()
+
+	// Streaming of in-progress transactions requires version 2 of the protocol.
+	var pluginArgs = []string{
+		`"proto_version" '1'`,
+		fmt.Sprintf(`"publication_names" '%s'`, stream.pubName),
+	}
+	if stream.plugin == replicationPluginWal2JSON {
+		pluginArgs = wal2jsonPluginArgs
+	} else if db.config.Advanced.StreamLargeTxns {
+		pluginArgs[0] = `"proto_version" '2'`
+		pluginArgs = append(pluginArgs, `"streaming" 'on'`)
+	}
+	if stream.plugin == replicationPluginPgoutput && db.config.Advanced.WatermarkMethod == watermarkMethodMessage {
+		pluginArgs = append(pluginArgs, `"messages" 'true'`)
+	}
+	if err := pglogrepl.StartReplication(startupCtx, stream.conn, slot, startLSN, pglogrepl.StartReplicationOptions{
+		PluginArgs: pluginArgs,
 	}); err != nil {
-		conn.Close(ctx)
+		closeConn()
+		if errors.Is(startupCtx.Err(), context.DeadlineExceeded) {
+			return nil, fmt.Errorf("unable to start replication: timed out after %s: %w", startupTimeout, err)
+		}
 		return nil, fmt.Errorf("unable to start replication: %w", err)
 	}
 
@@ -94,7 +166,8 @@ func (db *postgresDatabase) StartReplication(ctx context.Context, startCursor st
 		if errors.Is(err, context.Canceled) {
 			err = nil
 		}
-		stream.conn.Close(ctx)
+		closeConn()
+		stream.closeQueryConn(ctx)
 		close(stream.events)
 		stream.errCh <- err
 	}()
@@ -153,13 +226,38 @@ type replicationStream struct {
 	lastTxnEndLSN   pglogrepl.LSN               // End LSN (record + 1) of the last completed transaction.
 	nextTxnFinalLSN pglogrepl.LSN               // Final LSN of the commit currently being processed, or zero if between transactions.
 	nextTxnMillis   int64                       // Unix timestamp (in millis) at which the change originally occurred.
+	nextTxnSkipped  bool                        // Whether the changes of the transaction currently being processed are skipped because of its origin.
+	nextTxnOrdinal  uint64                      // Ordinal of the last change event of the transaction currently being processed.
 	pubName         string                      // The name of the PostgreSQL publication to use
 	replSlot        string                      // The name of the PostgreSQL replication slot to use
+	plugin          string                      // The logical decoding output plugin of the replication slot
+
+	// streamXid is the id of the streamed transaction whose block of changes is
+	// currently being received, or zero if there's none. Each streamed transaction
+	// which hasn't yet committed or aborted has its messages buffered in streamedTxns,
+	// and those of a committed transaction are replayed from replay.
+	streamXid    uint32
+	streamedTxns map[uint32][]streamedChange
+	replay       []streamedChange
+
+	// wal2json holds the state of replication using the `wal2json` plugin: the
+	// BEGIN and changes of the transaction currently being received, which are
+	// buffered until it commits, the relations queried for the tables of its
+	// changes, and the change for which a relation was last queried.
+	wal2json struct {
+		begin     *wal2jsonMessage
+		txn       []streamedChange
+		relations map[string]*pglogrepl.RelationMessage
+		refreshed *wal2jsonMessage
+	}
 
 	// standbyStatusDeadline is the time at which we need to stop receiving
 	// replication messages and go send a Standby Status Update message to
-	// the DB.
+	// the DB. It is pushed forward by standbyStatusInterval after every
+	// update, and standbyStatusUpdates counts how many have been sent.
 	standbyStatusDeadline time.Time
+	standbyStatusInterval time.Duration
+	standbyStatusUpdates  uint64
 
 	// connInfo is a sort of type registry used when decoding values
 	// from the database.
@@ -170,6 +268,36 @@ type replicationStream struct {
 	// and other information about the table structure at a particular moment.
 	relations map[uint32]*pglogrepl.RelationMessage
 
+	// renames tracks the renamed columns of captured tables, so that their
+	// values continue to be captured under the original column names.
+	renames *columnRenames
+
+	// origins decides which transactions are captured according to their
+	// replication origin.
+	origins *originFilter
+
+	// updateColumns is the mode controlling which columns are included in the
+	// 'after' state of update events. In the 'full' mode, unchanged TOAST values
+	// are queried from the database.
+	updateColumns string
+
+	// partitionRoots maps the relation IDs of partitions to the partitioned tables
+	// at the root of their partition trees, whose streams they're captured as part of.
+	// They're only resolved if partitionedTables, the stream IDs of the partitioned
+	// tables found by discovery, isn't empty.
+	partitionRoots    map[uint32]partitionRoot
+	partitionedTables map[string]bool
+
+	// queryConn is a separate connection used to query the database while decoding
+	// messages, such as for unchanged TOAST values or the roots of partitions. It's
+	// opened once it's needed.
+	queryConfig *Config
+	queryConn   *pgx.Conn
+
+	// sequenceKey is the property of captured documents under which the source
+	// sequence of each change is added, or empty if it isn't.
+	sequenceKey string
+
 	// The 'active tables' set, guarded by a mutex so it can be modified from
 	// the main goroutine while it's read by the replication goroutine.
 	tables struct {
@@ -178,8 +306,6 @@ type replicationStream struct {
 	}
 }
 
-const standbyStatusInterval = 10 * time.Second
-
 // replicationBufferSize controls how many change events can be buffered in the
 // replicationStream before it stops receiving further events from PostgreSQL.
 // In normal use it's a constant, it's just a variable so that tests are more
@@ -204,11 +330,11 @@ func (s *replicationStream) run(ctx context.Context) error {
 			if err := s.sendStandbyStatusUpdate(ctx); err != nil {
 				return fmt.Errorf("failed to send status update: %w", err)
 			}
-			s.standbyStatusDeadline = time.Now().Add(standbyStatusInterval)
+			s.standbyStatusDeadline = time.Now().Add(s.standbyStatusInterval)
 		}
 
 		var workCtx, cancelWorkCtx = context.WithDeadline(ctx, s.standbyStatusDeadline)
-		var err = s.relayMessages(workCtx)
+		var err = s.relayMessages(ctx, workCtx)
 		cancelWorkCtx()
 		if err != nil {
 			return fmt.Errorf("failed to relay messages: %w", err)
@@ -217,16 +343,17 @@ func (s *replicationStream) run(ctx context.Context) error {
 }
 
 // relayMessages receives logical replication messages from PostgreSQL and sends
-// them on the stream's output channel until its context is cancelled. This is
-// expected to happen every 10 seconds, so that a standby status update can be
-// sent.
-func (s *replicationStream) relayMessages(ctx context.Context) error {
+// them on the stream's output channel until the work context is cancelled. This is
+// expected to happen every standbyStatusInterval, so that a standby status update
+// can be sent. Decoding a message isn't bound by the work context, since it may
+// require querying the database.
+func (s *replicationStream) relayMessages(ctx, workCtx context.Context) error {
 	for {
 		// If there's already a change event which needs to be sent to the consumer,
 		// try to do so until/unless the context expires first.
 		if s.eventBuf != nil {
 			select {
-			case <-ctx.Done():
+			case <-workCtx.Done():
 				return nil
 			case s.events <- *s.eventBuf:
 				s.eventBuf = nil
@@ -235,7 +362,7 @@ func (s *replicationStream) relayMessages(ctx context.Context) error {
 
 		// In tbe absence of a buffered message, go try to receive another from
 		// the database.
-		var lsn, msg, err = s.receiveMessage(ctx)
+		var lsn, msg, err = s.nextMessage(workCtx)
 		if pgconn.Timeout(err) {
 			return nil
 		}
@@ -245,7 +372,7 @@ func (s *replicationStream) relayMessages(ctx context.Context) error {
 
 		// Once a message arrives, decode it and buffer the result until the next
 		// time this function is invoked.
-		event, err := s.decodeMessage(lsn, msg)
+		event, err := s.decodeMessage(ctx, lsn, msg)
 		if err != nil {
 			return fmt.Errorf("error decoding message: %w", err)
 		}
@@ -253,7 +380,7 @@ func (s *replicationStream) relayMessages(ctx context.Context) error {
 	}
 }
 
-func (s *replicationStream) decodeMessage(lsn pglogrepl.LSN, msg pglogrepl.Message) (*sqlcapture.ChangeEvent, error) {
+func (s *replicationStream) decodeMessage(ctx context.Context, lsn pglogrepl.LSN, msg pglogrepl.Message) (*sqlcapture.ChangeEvent, error) {
 	// Some notes on the Logical Replication / pgoutput message stream, since
 	// as far as I can tell this isn't documented anywhere but comments in the
 	// relevant PostgreSQL sources.
@@ -274,24 +401,32 @@ func (s *replicationStream) decodeMessage(lsn pglogrepl.LSN, msg pglogrepl.Messa
 	// sequence of values along with a "Relation ID" which can be used to
 	// look it up. We will only be given a particular relation once (unless
 	// it changes on the server) in a given replication session, so entries
-	// in the relations mapping will never be removed.
+	// in the relations mapping will never be removed. When a relation does
+	// change, comparing it with the previous one lets us notice renamed
+	// columns, which are otherwise indistinguishable from new ones.
 	switch msg := msg.(type) {
 	case *pglogrepl.RelationMessage:
-		s.relations[msg.RelationID] = msg
-		return nil, nil
+		return s.handleRelation(ctx, msg)
 	case *pglogrepl.BeginMessage:
 		if s.nextTxnFinalLSN != 0 {
 			return nil, fmt.Errorf("got BEGIN message while another transaction in progress")
 		}
 		s.nextTxnFinalLSN = msg.FinalLSN
 		s.nextTxnMillis = msg.CommitTime.UnixMilli()
+		s.nextTxnOrdinal = 0
 		return nil, nil
+	case *pglogrepl.OriginMessage:
+		return nil, s.handleOrigin(msg)
+	case *wal2jsonMessage:
+		return s.decodeWal2JSONChange(ctx, lsn, msg)
+	case *logicalDecodingMessage:
+		return s.decodeLogicalMessage(lsn, msg)
 	case *pglogrepl.InsertMessage:
-		return s.decodeChangeEvent(sqlcapture.InsertOp, lsn, 0, nil, msg.Tuple, msg.RelationID)
+		return s.decodeChangeEvent(ctx, sqlcapture.InsertOp, lsn, 0, nil, msg.Tuple, msg.RelationID)
 	case *pglogrepl.UpdateMessage:
-		return s.decodeChangeEvent(sqlcapture.UpdateOp, lsn, msg.OldTupleType, msg.OldTuple, msg.NewTuple, msg.RelationID)
+		return s.decodeChangeEvent(ctx, sqlcapture.UpdateOp, lsn, msg.OldTupleType, msg.OldTuple, msg.NewTuple, msg.RelationID)
 	case *pglogrepl.DeleteMessage:
-		return s.decodeChangeEvent(sqlcapture.DeleteOp, lsn, msg.OldTupleType, msg.OldTuple, nil, msg.RelationID)
+		return s.decodeChangeEvent(ctx, sqlcapture.DeleteOp, lsn, msg.OldTupleType, msg.OldTuple, nil, msg.RelationID)
 	case *pglogrepl.CommitMessage:
 		if s.nextTxnFinalLSN == 0 {
 			return nil, fmt.Errorf("got COMMIT message without a transaction in progress")
@@ -301,6 +436,8 @@ func (s *replicationStream) decodeMessage(lsn pglogrepl.LSN, msg pglogrepl.Messa
 		}
 		s.nextTxnFinalLSN = 0
 		s.nextTxnMillis = 0
+		s.nextTxnSkipped = false
+		s.nextTxnOrdinal = 0
 		s.lastTxnEndLSN = msg.TransactionEndLSN
 
 		var event = &sqlcapture.ChangeEvent{
@@ -314,7 +451,7 @@ func (s *replicationStream) decodeMessage(lsn pglogrepl.LSN, msg pglogrepl.Messa
 
 	// Unhandled messages are considered a fatal error. There are a bunch of
 	// oddball message types that aren't currently implemented in this connector
-	// (e.g. truncate, streaming transactions, or two-phase commits) and if we
+	// (e.g. truncate or two-phase commits) and if we
 	// blithely ignored them and continued we're pretty much guaranteed to end
 	// up in an inconsistent state with the Postgres tables. Much better to die
 	// quickly and give humans a chance to fix things.
@@ -342,6 +479,7 @@ func (s *replicationStream) decodeMessage(lsn pglogrepl.LSN, msg pglogrepl.Messa
 }
 
 func (s *replicationStream) decodeChangeEvent(
+	ctx context.Context,
 	op sqlcapture.ChangeOp, // Operation of this event.
 	lsn pglogrepl.LSN, // LSN of this event.
 	beforeType uint8, // Postgres TupleType (0, 'K' for key, 'O' for old full tuple, 'N' for new).
@@ -357,36 +495,63 @@ func (s *replicationStream) decodeChangeEvent(
 		return nil, fmt.Errorf("unknown relation ID %d", relID)
 	}
 
-	// If this change event is on a table we're not capturing, skip doing any
-	// further processing on it.
-	var streamID = sqlcapture.JoinStreamID(rel.Namespace, rel.RelationName)
-	if !s.tableActive(streamID) {
+	// If this change event is on a table we're not capturing, or is part of a
+	// transaction from a filtered origin, skip doing any further processing on it.
+	// Changes of a partition are captured as changes of its partitioned table.
+	var schema, table = s.captureTable(rel)
+	var streamID = sqlcapture.JoinStreamID(schema, table)
+	if !s.tableActive(streamID) || s.nextTxnSkipped {
 		return nil, nil
 	}
 
-	bf, err := s.decodeTuple(before, beforeType, rel, nil)
+	bf, _, err := s.decodeTuple(before, beforeType, rel, nil)
 	if err != nil {
 		return nil, fmt.Errorf("'before' tuple: %w", err)
 	}
-	af, err := s.decodeTuple(after, 'N', rel, bf)
+	af, unchanged, err := s.decodeTuple(after, 'N', rel, bf)
 	if err != nil {
 		return nil, fmt.Errorf("'after' tuple: %w", err)
 	}
+	if op == sqlcapture.UpdateOp {
+		switch s.updateColumns {
+		case updateColumnsFull:
+			if len(unchanged) > 0 {
+				if err := s.fillUnchangedColumns(ctx, rel, af, unchanged); err != nil {
+					return nil, fmt.Errorf("error querying unchanged columns: %w", err)
+				}
+			}
+		case updateColumnsDelta:
+			omitUnchangedColumns(rel, bf, af)
+		}
+	}
 	if err := translateRecordFields(nil, bf); err != nil {
 		return nil, fmt.Errorf("error translating 'before' tuple: %w", err)
 	}
 	if err := translateRecordFields(nil, af); err != nil {
 		return nil, fmt.Errorf("error translating 'after' tuple: %w", err)
 	}
+	s.renames.restoreNames(streamID, bf)
+	s.renames.restoreNames(streamID, af)
+
+	// The source sequence is added to the document which is captured for the event,
+	// which is the 'before' state of a deletion and the 'after' state otherwise.
+	s.nextTxnOrdinal++
+	var document = af
+	if op == sqlcapture.DeleteOp {
+		document = bf
+	}
+	if err := attachSequence(s.sequenceKey, document, s.nextTxnFinalLSN, s.nextTxnOrdinal); err != nil {
+		return nil, err
+	}
 
 	var event = &sqlcapture.ChangeEvent{
 		Operation: op,
 		Source: &postgresSource{
 			SourceCommon: sqlcapture.SourceCommon{
 				Millis:   s.nextTxnMillis,
-				Schema:   rel.Namespace,
+				Schema:   schema,
 				Snapshot: false,
-				Table:    rel.RelationName,
+				Table:    table,
 			},
 			Location: [3]pglogrepl.LSN{s.lastTxnEndLSN, lsn, s.nextTxnFinalLSN},
 		},
@@ -401,7 +566,7 @@ func (s *replicationStream) decodeTuple(
 	tupleType uint8,
 	rel *pglogrepl.RelationMessage,
 	before map[string]interface{},
-) (map[string]interface{}, error) {
+) (fields map[string]interface{}, unchanged []string, err error) {
 
 	var keyOnly bool
 	switch tupleType {
@@ -412,14 +577,14 @@ func (s *replicationStream) decodeTuple(
 	case 'N':
 		// New tuple.
 	default:
-		return nil, fmt.Errorf("unexpected tupleType %q", tupleType)
+		return nil, nil, fmt.Errorf("unexpected tupleType %q", tupleType)
 	}
 
 	if tuple == nil {
-		return nil, nil
+		return nil, nil, nil
 	}
 
-	var fields = make(map[string]interface{})
+	fields = make(map[string]interface{})
 	for idx, col := range tuple.Columns {
 		if keyOnly && (rel.Columns[idx].Flags&1) == 0 {
 			// Skip non-key column because it may not be null-able,
@@ -435,22 +600,25 @@ func (s *replicationStream) decodeTuple(
 		case 't':
 			var val, err = s.decodeTextColumnData(col.Data, rel.Columns[idx].DataType)
 			if err != nil {
-				return nil, fmt.Errorf("error decoding column data: %w", err)
+				return nil, nil, fmt.Errorf("error decoding column data: %w", err)
 			}
 			fields[colName] = val
 		case 'u':
 			// This fields is a TOAST value which is unchanged in this event.
 			// Depending on the REPLICA IDENTITY, the value may be available
-			// in the "before" tuple of the record. If not, we simply omit it
-			// from the event output.
-			if val, ok := before[colName]; ok {
+			// in the "before" tuple of the record. If not, we omit it from
+			// the event output and report it as unchanged so that it may be
+			// queried. Unchanged values are always omitted in the 'delta' mode.
+			if val, ok := before[colName]; !ok {
+				unchanged = append(unchanged, colName)
+			} else if s.updateColumns != updateColumnsDelta {
 				fields[colName] = val
 			}
 		default:
-			return nil, fmt.Errorf("unhandled column data type %v", col.DataType)
+			return nil, nil, fmt.Errorf("unhandled column data type %v", col.DataType)
 		}
 	}
-	return fields, nil
+	return fields, unchanged, nil
 }
 
 func (s *replicationStream) decodeTextColumnData(data []byte, dataType uint32) (interface{}, error) {
@@ -495,7 +663,12 @@ func (s *replicationStream) receiveMessage(ctx context.Context) (pglogrepl.LSN,
 				if err != nil {
 					return 0, nil, fmt.Errorf("error parsing XLogData: %w", err)
 				}
-				msg, err := pglogrepl.Parse(xld.WALData)
+				var msg pglogrepl.Message
+				if s.plugin == replicationPluginWal2JSON {
+					msg, err = parseWal2JSON(xld.WALData)
+				} else {
+					msg, err = parseMessage(xld.WALData, s.streamXid != 0)
+				}
 				if err != nil {
 					return 0, nil, fmt.Errorf("error parsing logical replication message: %w", err)
 				}
@@ -509,6 +682,29 @@ func (s *replicationStream) receiveMessage(ctx context.Context) (pglogrepl.LSN,
 	}
 }
 
+// queryConnection returns the connection used to query the database while decoding
+// messages, opening it if it isn't already.
+func (s *replicationStream) queryConnection(ctx context.Context) (*pgx.Conn, error) {
+	if s.queryConn == nil {
+		var conn, err = s.queryConfig.connect(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("unable to connect to database: %w", err)
+		}
+		sqlcapture.ConnectionOpened("database")
+		s.queryConn = conn
+	}
+	return s.queryConn, nil
+}
+
+// closeQueryConn closes the connection used to query the database, if it was opened.
+func (s *replicationStream) closeQueryConn(ctx context.Context) {
+	if s.queryConn != nil {
+		s.queryConn.Close(ctx)
+		sqlcapture.ConnectionClosed("database")
+		s.queryConn = nil
+	}
+}
+
 func (s *replicationStream) tableActive(streamID string) bool {
 	s.tables.RLock()
 	defer s.tables.RUnlock()
@@ -560,9 +756,13 @@ func (s *replicationStream) Acknowledge(ctx context.Context, cursor string) erro
 func (s *replicationStream) sendStandbyStatusUpdate(ctx context.Context) error {
 	var ackLSN = pglogrepl.LSN(atomic.LoadUint64(&s.ackLSN))
 	logrus.WithField("ackLSN", ackLSN).Debug("sending Standby Status Update")
-	return pglogrepl.SendStandbyStatusUpdate(ctx, s.conn, pglogrepl.StandbyStatusUpdate{
+	if err := pglogrepl.SendStandbyStatusUpdate(ctx, s.conn, pglogrepl.StandbyStatusUpdate{
 		WALWritePosition: ackLSN,
-	})
+	}); err != nil {
+		return err
+	}
+	atomic.AddUint64(&s.standbyStatusUpdates, 1)
+	return nil
 }
 
 func (s *replicationStream) Close(ctx context.Context) error {
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/estuary/connectors/sqlcapture"
+	"github.com/jackc/pgx/v4"
+)
+
+// Query for the columns of each unique index of a table, excluding partial indexes and those
+// on expressions, which don't guarantee that the values of the indexed columns are unique.
+const queryUniqueIndexes = `
+SELECT array_agg(a.attname::text ORDER BY k.ord)
+  FROM pg_catalog.pg_index i
+  JOIN pg_catalog.pg_class c ON c.oid = i.indrelid
+  JOIN pg_catalog.pg_namespace n ON n.oid = c.relnamespace
+  CROSS JOIN LATERAL unnest(i.indkey::int2[]) WITH ORDINALITY AS k(attnum, ord)
+  JOIN pg_catalog.pg_attribute a ON a.attrelid = c.oid AND a.attnum = k.attnum
+  WHERE n.nspname = $1 AND c.relname = $2
+    AND i.indisunique AND i.indpred IS NULL AND i.indexprs IS NULL
+  GROUP BY i.indexrelid;`
+
+// Query for the generated and identity columns of a table.
+const queryGeneratedColumns = `
+SELECT column_name, is_generated = 'ALWAYS', is_identity = 'YES'
+  FROM information_schema.columns
+  WHERE table_schema = $1 AND table_name = $2
+    AND (is_generated = 'ALWAYS' OR is_identity = 'YES');`
+
+// generatedColumn describes how the values of a column are generated.
+type generatedColumn struct {
+	Generated bool // The column is computed from the other columns of its row.
+	Identity  bool // The column is given values from a sequence.
+}
+
+// ValidateScanKey checks that a table can be scanned in the order of the given key
+// columns, which requires them to be non-null and covered by a unique index.
+func (db *postgresDatabase) ValidateScanKey(ctx context.Context, info sqlcapture.TableInfo, key []string) error {
+	var uniqueIndexes [][]string
+	var columns []string
+	if _, err := db.conn.QueryFunc(ctx, queryUniqueIndexes, []interface{}{info.Schema, info.Name}, []interface{}{&columns},
+		func(r pgx.QueryFuncRow) error {
+			uniqueIndexes = append(uniqueIndexes, columns)
+			return nil
+		}); err != nil {
+		return fmt.Errorf("error querying unique indexes of table %q: %w", info.Name, err)
+	}
+
+	var generated = make(map[string]generatedColumn)
+	var name string
+	var col generatedColumn
+	if _, err := db.conn.QueryFunc(ctx, queryGeneratedColumns, []interface{}{info.Schema, info.Name}, []interface{}{&name, &col.Generated, &col.Identity},
+		func(r pgx.QueryFuncRow) error {
+			generated[name] = col
+			return nil
+		}); err != nil {
+		return fmt.Errorf("error querying generated columns of table %q: %w", info.Name, err)
+	}
+	return validateScanKey(info, key, uniqueIndexes, generated)
+}
+
+// validateScanKey returns an error if a table with the given unique indexes and generated
+// columns can't be scanned in the order of the given key columns.
+func validateScanKey(info sqlcapture.TableInfo, key []string, uniqueIndexes [][]string, generated map[string]generatedColumn) error {
+	var table = pgx.Identifier{info.Schema, info.Name}.Sanitize()
+
+	for _, name := range key {
+		if col, ok := info.Columns[name]; ok && col.IsNullable {
+			return fmt.Errorf("key column %q of table %s is nullable, and rows with a null key would be skipped by backfills: add a NOT NULL constraint to the column, or specify a different key in the catalog", name, table)
+		}
+	}
+
+	// Every row has a distinct `ctid`, so a key which includes it is always unique.
+	if containsColumn(key, ctidColumn) {
+		return nil
+	}
+
+	for _, index := range uniqueIndexes {
+		var covered = true
+		for _, name := range index {
+			if !containsColumn(key, name) {
+				covered = false
+				break
+			}
+		}
+		if covered {
+			return nil
+		}
+	}
+
+	// Explain why generated columns in particular aren't suitable, since they might
+	// otherwise look like natural keys.
+	for _, name := range key {
+		if col := generated[name]; col.Generated {
+			return fmt.Errorf("generated column %q of table %s isn't unique, since its values are computed from other columns and can repeat, so rows sharing them would be skipped by backfills: specify columns covered by a unique index in the catalog", name, table)
+		} else if col.Identity {
+			return fmt.Errorf("identity column %q of table %s isn't unique, since it has no unique constraint and its values can be given explicitly or repeat if its sequence is reset, so rows sharing them would be skipped by backfills: add a unique constraint to the column, or specify columns covered by a unique index in the catalog", name, table)
+		}
+	}
+	return fmt.Errorf("key %q of table %s isn't unique, so rows sharing it would be skipped by backfills: specify columns covered by a unique index in the catalog, or %q", key, table, ctidColumn)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/estuary/connectors/sqlcapture"
+	"github.com/stretchr/testify/require"
+)
+
+func TestValidateScanKey(t *testing.T) {
+	var info = sqlcapture.TableInfo{
+		Schema: "public",
+		Name:   "events",
+		Columns: map[string]sqlcapture.ColumnInfo{
+			"id":      {Name: "id"},
+			"seq":     {Name: "seq"},
+			"bucket":  {Name: "bucket"},
+			"data":    {Name: "data"},
+			"comment": {Name: "comment", IsNullable: true},
+		},
+	}
+	var uniqueIndexes = [][]string{{"id"}, {"data", "seq"}}
+	var generated = map[string]generatedColumn{
+		"seq":    {Identity: true},
+		"bucket": {Generated: true},
+	}
+
+	for _, tc := range []struct {
+		key    []string
+		expect string
+	}{
+		{[]string{"id"}, ""},
+		{[]string{"bucket", "id"}, ""},
+		{[]string{"seq", "data"}, ""},
+		{[]string{"ctid"}, ""},
+		{[]string{"data", "ctid"}, ""},
+		{[]string{"bucket"}, `generated column "bucket" of table "public"."events" isn't unique`},
+		{[]string{"seq"}, `identity column "seq" of table "public"."events" isn't unique`},
+		{[]string{"data"}, `key ["data"] of table "public"."events" isn't unique`},
+		{[]string{"comment", "id"}, `key column "comment" of table "public"."events" is nullable`},
+		{[]string{"comment", "ctid"}, `key column "comment" of table "public"."events" is nullable`},
+	} {
+		var err = validateScanKey(info, tc.key, uniqueIndexes, generated)
+		if tc.expect == "" {
+			require.NoError(t, err, "key %q", tc.key)
+		} else {
+			require.Error(t, err, "key %q", tc.key)
+			require.Contains(t, err.Error(), tc.expect)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pglogrepl"
+)
+
+// formatSequence returns the source sequence of a captured change, which is the LSN of the
+// commit of its transaction followed by its ordinal within the transaction. Both are written
+// as fixed-width hexadecimal so that sequences order lexicographically.
+func formatSequence(lsn pglogrepl.LSN, ordinal uint64) string {
+	return fmt.Sprintf("%016X:%016X", uint64(lsn), ordinal)
+}
+
+// attachSequence adds the source sequence of a change to the fields of its captured document,
+// under the configured sequence key. It does nothing if there's no sequence key.
+func attachSequence(key string, fields map[string]interface{}, lsn pglogrepl.LSN, ordinal uint64) error {
+	if key == "" || fields == nil {
+		return nil
+	}
+	if _, ok := fields[key]; ok {
+		return fmt.Errorf("column %q has the same name as the 'sequenceKey' property", key)
+	}
+	fields[key] = formatSequence(lsn, ordinal)
+	return nil
+}
+
+// backfillSequenceLSN returns the LSN of the sequences of rows which are backfilled by a
+// scan. Rows read from an exported snapshot are as of the snapshot's LSN. Otherwise they're
+// as of the current WAL position, which precedes the commit of any change that's replicated
+// after them, while changes committed before the scan has finished are patched into its
+// rows along with their own sequences. Backfilled rows are given an ordinal of zero, which
+// orders them before any replicated change in a transaction committed at the same LSN.
+func (db *postgresDatabase) backfillSequenceLSN(ctx context.Context) (pglogrepl.LSN, error) {
+	if db.snapshot != nil {
+		return db.snapshot.lsn, nil
+	}
+	var lsn string
+	if err := db.conn.QueryRow(ctx, "SELECT pg_current_wal_lsn()::text;").Scan(&lsn); err != nil {
+		return 0, fmt.Errorf("error querying current WAL position: %w", err)
+	}
+	return pglogrepl.ParseLSN(lsn)
+}
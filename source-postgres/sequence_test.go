@@ -0,0 +1,95 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/estuary/connectors/sqlcapture"
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgtype"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFormatSequence(t *testing.T) {
+	require.Equal(t, "00000000016B3748:0000000000000000", formatSequence(0x16B3748, 0))
+	require.Equal(t, "0000000A00000010:0000000000000003", formatSequence(0xA00000010, 3))
+
+	// Sequences order lexicographically, by LSN and then by ordinal.
+	require.Less(t, formatSequence(0xFFFF, 20), formatSequence(0x10000, 1))
+	require.Less(t, formatSequence(0x10000, 9), formatSequence(0x10000, 10))
+}
+
+func TestDecodeSequences(t *testing.T) {
+	var ctx = context.Background()
+	var s = &replicationStream{
+		connInfo:      pgtype.NewConnInfo(),
+		relations:     map[uint32]*pglogrepl.RelationMessage{1: testRelation("id", "data")},
+		renames:       newColumnRenames(),
+		updateColumns: updateColumnsAvailable,
+		origins:       newOriginFilter("", ""),
+		sequenceKey:   "_seq",
+	}
+	s.tables.active = map[string]struct{}{"public.things": {}}
+
+	var tuple = func(values ...string) *pglogrepl.TupleData {
+		var tuple = new(pglogrepl.TupleData)
+		for _, value := range values {
+			tuple.Columns = append(tuple.Columns, &pglogrepl.TupleDataColumn{DataType: 't', Data: []byte(value)})
+		}
+		return tuple
+	}
+	// decodeTxn decodes a transaction committed at the LSN, and returns the sequences
+	// of the documents captured for each of its change events.
+	var decodeTxn = func(commitLSN pglogrepl.LSN, changes ...pglogrepl.Message) []interface{} {
+		var msgs = []pglogrepl.Message{&pglogrepl.BeginMessage{FinalLSN: commitLSN, CommitTime: time.Now()}}
+		msgs = append(msgs, changes...)
+		msgs = append(msgs, &pglogrepl.CommitMessage{CommitLSN: commitLSN, TransactionEndLSN: commitLSN + 1})
+
+		var sequences []interface{}
+		for idx, msg := range msgs {
+			var event, err = s.decodeMessage(ctx, commitLSN-pglogrepl.LSN(len(msgs)-idx), msg)
+			require.NoError(t, err)
+			if event == nil {
+				continue
+			}
+			switch event.Operation {
+			case sqlcapture.InsertOp, sqlcapture.UpdateOp:
+				sequences = append(sequences, event.After["_seq"])
+			case sqlcapture.DeleteOp:
+				sequences = append(sequences, event.Before["_seq"])
+			}
+		}
+		return sequences
+	}
+
+	// Changes are sequenced by the commit LSN of their transaction and their ordinal
+	// within it, starting from one.
+	require.Equal(t, []interface{}{
+		"0000000000000100:0000000000000001",
+		"0000000000000100:0000000000000002",
+		"0000000000000100:0000000000000003",
+	}, decodeTxn(0x100,
+		&pglogrepl.InsertMessage{RelationID: 1, Tuple: tuple("1", "one")},
+		&pglogrepl.UpdateMessage{RelationID: 1, NewTuple: tuple("1", "uno")},
+		&pglogrepl.DeleteMessage{RelationID: 1, OldTupleType: 'K', OldTuple: tuple("1", "")},
+	))
+	// The ordinals of each transaction start over.
+	require.Equal(t, []interface{}{"0000000000000200:0000000000000001"}, decodeTxn(0x200,
+		&pglogrepl.InsertMessage{RelationID: 1, Tuple: tuple("2", "two")},
+	))
+
+	// The update's 'before' state isn't given a sequence, since it isn't a captured document.
+	var _, err = s.decodeMessage(ctx, 0x2F0, &pglogrepl.BeginMessage{FinalLSN: 0x300, CommitTime: time.Now()})
+	require.NoError(t, err)
+	event, err := s.decodeMessage(ctx, 0x2F1, &pglogrepl.UpdateMessage{RelationID: 1, OldTupleType: 'O', OldTuple: tuple("2", "two"), NewTuple: tuple("2", "dos")})
+	require.NoError(t, err)
+	require.Equal(t, map[string]interface{}{"id": "2", "data": "two"}, event.Before)
+	require.Equal(t, "0000000000000300:0000000000000001", event.After["_seq"])
+
+	// A column of the same name as the sequence key can't be captured.
+	s.relations[1] = testRelation("id", "_seq")
+	_, err = s.decodeMessage(ctx, 0x2F2, &pglogrepl.InsertMessage{RelationID: 1, Tuple: tuple("3", "three")})
+	require.Error(t, err)
+	require.Contains(t, err.Error(), `column "_seq" has the same name`)
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/estuary/connectors/sqlcapture"
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// Changes to sequences aren't replicated, so the sequences listed in the `captureSequences`
+// option are instead discovered as streams of their own which are captured by polling them.
+// Each such stream holds a single document describing the state of its sequence, which is
+// updated whenever the sequence has advanced or changed owner since it was last polled.
+
+const querySequences = `
+  SELECT n.nspname::text, c.relname::text
+  FROM pg_catalog.pg_class c
+  JOIN pg_catalog.pg_namespace n ON (n.oid = c.relnamespace)
+  WHERE c.relkind = 'S';`
+
+// sequenceKeyColumn is the column of a sequence stream which identifies its document.
+const sequenceKeyColumn = "sequence_name"
+
+// sequenceColumns are the columns of the documents of sequence streams, in order.
+var sequenceColumns = []struct {
+	name     string
+	dataType string
+}{
+	{sequenceKeyColumn, "text"},
+	{"last_value", "int8"},
+	{"is_called", "bool"},
+	{"owner", "text"},
+}
+
+// captureSequence returns true if the sequence is listed in the `captureSequences` option.
+func (c *Config) captureSequence(streamID string) bool {
+	for _, sequenceID := range strings.Split(c.Advanced.CaptureSequences, ",") {
+		if streamID == strings.ToLower(strings.TrimSpace(sequenceID)) {
+			return true
+		}
+	}
+	return false
+}
+
+// getSequences queries the database for the sequences which are listed in the
+// `captureSequences` option, and describes each as a table of a single row.
+func getSequences(ctx context.Context, conn *pgx.Conn, cfg *Config) (map[string]sqlcapture.TableInfo, error) {
+	var sequences = make(map[string]sqlcapture.TableInfo)
+	if cfg.Advanced.CaptureSequences == "" {
+		return sequences, nil
+	}
+
+	var schema, name string
+	var _, err = conn.QueryFunc(ctx, querySequences, nil, []interface{}{&schema, &name},
+		func(r pgx.QueryFuncRow) error {
+			var streamID = sqlcapture.JoinStreamID(schema, name)
+			if !cfg.captureSequence(streamID) {
+				return nil
+			}
+			var info = sqlcapture.TableInfo{
+				Schema:     schema,
+				Name:       name,
+				Columns:    make(map[string]sqlcapture.ColumnInfo),
+				PrimaryKey: []string{sequenceKeyColumn},
+			}
+			for idx, col := range sequenceColumns {
+				info.Columns[col.name] = sqlcapture.ColumnInfo{
+					Name:        col.name,
+					Index:       idx + 1,
+					TableName:   name,
+					TableSchema: schema,
+					DataType:    col.dataType,
+				}
+				info.ColumnNames = append(info.ColumnNames, col.name)
+			}
+			sequences[streamID] = info
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sequenceID := range strings.Split(cfg.Advanced.CaptureSequences, ",") {
+		if _, ok := sequences[strings.ToLower(strings.TrimSpace(sequenceID))]; !ok {
+			logrus.WithField("sequence", sequenceID).Warn("captured sequence doesn't exist")
+		}
+	}
+	return sequences, nil
+}
+
+// scanSequence reads the current state of a sequence as the single row of its stream.
+// Only the columns of the stream's selection are captured if it omits any.
+func (db *postgresDatabase) scanSequence(ctx context.Context, info sqlcapture.TableInfo, resumeKey []interface{}) ([]sqlcapture.ChangeEvent, error) {
+	if resumeKey != nil {
+		return nil, nil // The only row has already been scanned.
+	}
+
+	var sequence = pgx.Identifier{info.Schema, info.Name}.Sanitize()
+	var query = fmt.Sprintf(`SELECT s.last_value, s.is_called, pg_catalog.pg_get_userbyid(c.relowner)::text
+	  FROM %s s, pg_catalog.pg_class c WHERE c.oid = $1::regclass;`, sequence)
+	var lastValue int64
+	var isCalled bool
+	var owner string
+	if err := db.conn.QueryRow(ctx, query, sequence).Scan(&lastValue, &isCalled, &owner); err != nil {
+		return nil, fmt.Errorf("error querying sequence %s: %w", sequence, err)
+	}
+
+	var fields = map[string]interface{}{
+		sequenceKeyColumn: info.Schema + "." + info.Name,
+		"last_value":      lastValue,
+		"is_called":       isCalled,
+		"owner":           owner,
+	}
+	if info.Projected {
+		for colName := range fields {
+			if _, ok := info.Columns[colName]; !ok {
+				delete(fields, colName)
+			}
+		}
+	}
+	return []sqlcapture.ChangeEvent{{
+		Operation: sqlcapture.InsertOp,
+		Source:    db.SnapshotSource(info),
+		After:     fields,
+	}}, nil
+}
+
+// PeriodicSnapshot returns true for the streams of captured sequences, whose changes are
+// never replicated.
+func (db *postgresDatabase) PeriodicSnapshot(info sqlcapture.TableInfo) bool {
+	return db.sequences[sqlcapture.JoinStreamID(info.Schema, info.Name)]
+}
+
+func (db *postgresDatabase) SnapshotSource(info sqlcapture.TableInfo) sqlcapture.SourceMetadata {
+	return &postgresSource{
+		SourceCommon: sqlcapture.SourceCommon{
+			Millis:   0, // Not known.
+			Schema:   info.Schema,
+			Snapshot: true,
+			Table:    info.Name,
+		},
+		Location: [3]pglogrepl.LSN{},
+	}
+}
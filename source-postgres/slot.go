@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// How many times, and how often, the replication slot is queried after another connector
+// instance has created it concurrently, until its consistent point has been determined.
+var (
+	slotReadyRetries  = 30
+	slotReadyInterval = time.Second
+)
+
+// replicationSlot describes an existing replication slot.
+type replicationSlot struct {
+	Plugin         string
+	SlotType       string
+	Database       string
+	ConfirmedFlush *string // The slot's `confirmed_flush_lsn`, which is null until it's ready.
+}
+
+const querySlot = `SELECT COALESCE(plugin, ''), slot_type, COALESCE(database, ''), confirmed_flush_lsn::text FROM pg_catalog.pg_replication_slots WHERE slot_name = $1;`
+
+// ensureSlot creates the replication slot if it doesn't exist yet, and returns the LSN from
+// which replication should begin: the consistent point of a newly created slot, or the point
+// up to which the changes of an existing slot have been confirmed. If another connector
+// instance creates the slot concurrently, its slot is used once it's ready.
+func (db *postgresDatabase) ensureSlot(ctx context.Context, conn *pgconn.PgConn) (pglogrepl.LSN, error) {
+	var name, plugin = db.config.Advanced.SlotName, db.config.Advanced.ReplicationPlugin
+	for attempt := 0; ; attempt++ {
+		var slot replicationSlot
+		var err = db.conn.QueryRow(ctx, querySlot, name).Scan(&slot.Plugin, &slot.SlotType, &slot.Database, &slot.ConfirmedFlush)
+		if errors.Is(err, pgx.ErrNoRows) {
+			result, err := pglogrepl.CreateReplicationSlot(ctx, conn, name, plugin, pglogrepl.CreateReplicationSlotOptions{
+				SnapshotAction: "NOEXPORT_SNAPSHOT",
+				Mode:           pglogrepl.LogicalReplication,
+			})
+			if err == nil {
+				var lsn, err = pglogrepl.ParseLSN(result.ConsistentPoint)
+				if err != nil {
+					return 0, fmt.Errorf("error parsing consistent point of replication slot %q: %w", name, err)
+				}
+				logrus.WithFields(logrus.Fields{"slot": name, "lsn": lsn}).Info("created replication slot")
+				return lsn, nil
+			}
+			if !isDuplicateObject(err) {
+				return 0, fmt.Errorf("error creating replication slot %q: %w", name, err)
+			}
+			// Another instance created the slot after it was queried.
+			logrus.WithField("slot", name).Info("replication slot was created concurrently")
+		} else if err != nil {
+			return 0, fmt.Errorf("error querying replication slot %q: %w", name, err)
+		} else if err := validateSlot(name, db.config.Database, plugin, &slot); err != nil {
+			return 0, err
+		} else if slot.ConfirmedFlush != nil {
+			var lsn, err = pglogrepl.ParseLSN(*slot.ConfirmedFlush)
+			if err != nil {
+				return 0, fmt.Errorf("error parsing confirmed LSN of replication slot %q: %w", name, err)
+			}
+			logrus.WithFields(logrus.Fields{"slot": name, "lsn": lsn}).Info("replication slot already exists")
+			return lsn, nil
+		}
+
+		// A slot which is still being created has no confirmed LSN until its consistent
+		// point has been determined.
+		if attempt >= slotReadyRetries {
+			return 0, fmt.Errorf("replication slot %q is still being created by another connection", name)
+		}
+		select {
+		case <-ctx.Done():
+			return 0, ctx.Err()
+		case <-time.After(slotReadyInterval):
+		}
+	}
+}
+
+// validateSlot returns an error if an existing replication slot can't be replicated from
+// using the given output plugin.
+func validateSlot(name, database, plugin string, slot *replicationSlot) error {
+	if slot.SlotType != "logical" {
+		return fmt.Errorf("replication slot %q is a %s slot, but a logical slot is required", name, slot.SlotType)
+	} else if slot.Plugin != plugin {
+		return fmt.Errorf("replication slot %q uses the %q output plugin, but %q is required", name, slot.Plugin, plugin)
+	} else if slot.Database != database {
+		return fmt.Errorf("replication slot %q belongs to database %q rather than %q", name, slot.Database, database)
+	}
+	return nil
+}
+
+// isDuplicateObject returns true if the error is PostgreSQL's `duplicate_object`, which is
+// returned when creating a replication slot or publication that already exists.
+func isDuplicateObject(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == "42710"
+}
+
+// ReplicationStart returns the LSN at which StartReplication began replicating from a slot
+// that it created or found, when it was started without a cursor.
+func (db *postgresDatabase) ReplicationStart() (string, bool) {
+	if db.slotLSN == 0 {
+		return "", false
+	}
+	return db.slotLSN.String(), true
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/estuary/connectors/sqlcapture"
+	"github.com/jackc/pgconn"
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// exportedSnapshot is a transaction which has imported the snapshot exported by
+// `CREATE_REPLICATION_SLOT`, and so observes the database exactly as it was at the
+// slot's consistent point. Backfills which read from it need no watermarks, since
+// every replication event from the consistent point onwards occurs after it.
+type exportedSnapshot struct {
+	name string
+	lsn  pglogrepl.LSN
+	conn *pgx.Conn
+}
+
+// createSnapshotSlot creates the replication slot and imports the snapshot exported
+// along with it. It returns nil if the slot can't be created, which is usually because
+// it already exists, in which case backfills use watermarks as usual.
+func createSnapshotSlot(ctx context.Context, conn *pgconn.PgConn, slot string, config *Config) (*exportedSnapshot, error) {
+	var result, err = pglogrepl.CreateReplicationSlot(ctx, conn, slot, config.Advanced.ReplicationPlugin, pglogrepl.CreateReplicationSlotOptions{
+		SnapshotAction: "EXPORT_SNAPSHOT",
+		Mode:           pglogrepl.LogicalReplication,
+	})
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"slot": slot,
+			"err":  err,
+		}).Warn("unable to create replication slot with an exported snapshot, backfills will use watermarks")
+		return nil, nil
+	}
+	lsn, err := pglogrepl.ParseLSN(result.ConsistentPoint)
+	if err != nil {
+		return nil, fmt.Errorf("error parsing consistent point of replication slot %q: %w", slot, err)
+	}
+	return importSnapshot(ctx, config, result.SnapshotName, lsn)
+}
+
+// importSnapshot opens a new connection and imports the named snapshot into a
+// read-only transaction on it. An exported snapshot can only be imported until the
+// replication connection which exported it executes another command, but it then
+// remains valid for as long as the importing transaction is open.
+func importSnapshot(ctx context.Context, config *Config, name string, lsn pglogrepl.LSN) (*exportedSnapshot, error) {
+	var conn, err = config.connect(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to database for snapshot: %w", err)
+	}
+	sqlcapture.ConnectionOpened("snapshot")
+	var snapshot = &exportedSnapshot{name: name, lsn: lsn, conn: conn}
+
+	if _, err := conn.Exec(ctx, "BEGIN ISOLATION LEVEL REPEATABLE READ READ ONLY;"); err != nil {
+		snapshot.close(ctx)
+		return nil, fmt.Errorf("unable to begin snapshot transaction: %w", err)
+	}
+	if _, err := conn.Exec(ctx, fmt.Sprintf("SET TRANSACTION SNAPSHOT '%s';", name)); err != nil {
+		snapshot.close(ctx)
+		return nil, fmt.Errorf("unable to import snapshot %q: %w", name, err)
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"snapshot": name,
+		"lsn":      lsn,
+	}).Info("imported exported snapshot")
+	return snapshot, nil
+}
+
+// validate returns an error if the snapshot transaction is no longer open, as is
+// the case if a query within it failed.
+func (s *exportedSnapshot) validate() error {
+	if status := s.conn.PgConn().TxStatus(); status != 'T' {
+		return fmt.Errorf("exported snapshot %q is no longer valid (transaction status %q)", s.name, status)
+	}
+	return nil
+}
+
+// close ends the snapshot transaction and closes its connection.
+func (s *exportedSnapshot) close(ctx context.Context) error {
+	var err = s.conn.Close(ctx)
+	sqlcapture.ConnectionClosed("snapshot")
+	return err
+}
+
+// ExportedSnapshot returns the LSN of the snapshot imported by StartReplication, if any.
+func (db *postgresDatabase) ExportedSnapshot() (string, bool) {
+	if db.snapshot == nil {
+		return "", false
+	}
+	return db.snapshot.lsn.String(), true
+}
+
+// ReleaseSnapshot ends the snapshot imported by StartReplication, after which tables
+// are scanned using the normal database connection.
+func (db *postgresDatabase) ReleaseSnapshot(ctx context.Context) error {
+	if db.snapshot == nil {
+		return nil
+	}
+	var err = db.snapshot.close(ctx)
+	db.snapshot = nil
+	if err != nil {
+		return fmt.Errorf("error closing snapshot connection: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/sirupsen/logrus"
+)
+
+// Message types of version 2 of the logical replication protocol, with which `pgoutput`
+// streams large transactions while they're still in progress. These aren't supported by
+// pglogrepl, so they're parsed here.
+const (
+	messageTypeStreamStart  pglogrepl.MessageType = 'S'
+	messageTypeStreamStop   pglogrepl.MessageType = 'E'
+	messageTypeStreamCommit pglogrepl.MessageType = 'c'
+	messageTypeStreamAbort  pglogrepl.MessageType = 'A'
+)
+
+// streamStartMessage begins a block of changes of an in-progress transaction.
+type streamStartMessage struct {
+	Xid          uint32
+	FirstSegment bool
+}
+
+func (*streamStartMessage) Type() pglogrepl.MessageType { return messageTypeStreamStart }
+
+// streamStopMessage ends a block of changes of an in-progress transaction.
+type streamStopMessage struct{}
+
+func (*streamStopMessage) Type() pglogrepl.MessageType { return messageTypeStreamStop }
+
+// streamCommitMessage commits a streamed transaction.
+type streamCommitMessage struct {
+	Xid               uint32
+	CommitLSN         pglogrepl.LSN
+	TransactionEndLSN pglogrepl.LSN
+	CommitTime        time.Time
+}
+
+func (*streamCommitMessage) Type() pglogrepl.MessageType { return messageTypeStreamCommit }
+
+// streamAbortMessage aborts a streamed transaction, or just one of its subtransactions
+// if SubXid differs from Xid.
+type streamAbortMessage struct {
+	Xid    uint32
+	SubXid uint32
+}
+
+func (*streamAbortMessage) Type() pglogrepl.MessageType { return messageTypeStreamAbort }
+
+// streamedMessage is a message sent within a block of a streamed transaction, along
+// with the id of the (sub)transaction to which it belongs.
+type streamedMessage struct {
+	pglogrepl.Message
+	Xid uint32
+}
+
+// streamedChange is a buffered message of a streamed transaction.
+type streamedChange struct {
+	lsn pglogrepl.LSN
+	xid uint32
+	msg pglogrepl.Message
+}
+
+// postgresEpoch is the zero time of PostgreSQL timestamps.
+var postgresEpoch = time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// parseMessage parses a logical replication message. When streaming is set, the message
+// is part of a block of a streamed transaction, and it's prefixed by the id of the
+// transaction which is removed and returned as a streamedMessage.
+func parseMessage(data []byte, streaming bool) (pglogrepl.Message, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty logical replication message")
+	}
+	var msgType, body = pglogrepl.MessageType(data[0]), data[1:]
+	switch msgType {
+	case messageTypeStreamStart:
+		if len(body) < 5 {
+			return nil, fmt.Errorf("StreamStartMessage must have 5 bytes, got %d", len(body))
+		}
+		return &streamStartMessage{
+			Xid:          binary.BigEndian.Uint32(body),
+			FirstSegment: body[4] == 1,
+		}, nil
+	case messageTypeStreamStop:
+		return &streamStopMessage{}, nil
+	case messageTypeStreamCommit:
+		if len(body) < 29 {
+			return nil, fmt.Errorf("StreamCommitMessage must have 29 bytes, got %d", len(body))
+		}
+		// The transaction id is followed by a byte of (currently unused) flags.
+		return &streamCommitMessage{
+			Xid:               binary.BigEndian.Uint32(body),
+			CommitLSN:         pglogrepl.LSN(binary.BigEndian.Uint64(body[5:])),
+			TransactionEndLSN: pglogrepl.LSN(binary.BigEndian.Uint64(body[13:])),
+			CommitTime:        postgresEpoch.Add(time.Duration(int64(binary.BigEndian.Uint64(body[21:]))) * time.Microsecond),
+		}, nil
+	case messageTypeStreamAbort:
+		if len(body) < 8 {
+			return nil, fmt.Errorf("StreamAbortMessage must have 8 bytes, got %d", len(body))
+		}
+		return &streamAbortMessage{
+			Xid:    binary.BigEndian.Uint32(body),
+			SubXid: binary.BigEndian.Uint32(body[4:]),
+		}, nil
+	}
+	if !streaming {
+		return parseChangeMessage(msgType, body)
+	}
+
+	if len(body) < 4 {
+		return nil, fmt.Errorf("streamed %s message must have at least 4 bytes, got %d", msgType, len(body))
+	}
+	var msg, err = parseChangeMessage(msgType, body[4:])
+	if err != nil {
+		return nil, err
+	}
+	return &streamedMessage{Message: msg, Xid: binary.BigEndian.Uint32(body)}, nil
+}
+
+// parseChangeMessage parses a message which may be part of a transaction, which are
+// parsed by pglogrepl except for logical decoding messages.
+func parseChangeMessage(msgType pglogrepl.MessageType, body []byte) (pglogrepl.Message, error) {
+	if msgType == messageTypeLogical {
+		return parseLogicalDecodingMessage(body)
+	}
+	return pglogrepl.Parse(append([]byte{byte(msgType)}, body...))
+}
+
+// nextMessage returns the next message to be decoded. The buffered messages of a
+// committed streamed transaction are replayed before any more are received.
+func (s *replicationStream) nextMessage(ctx context.Context) (pglogrepl.LSN, pglogrepl.Message, error) {
+	for {
+		if len(s.replay) > 0 {
+			var next = s.replay[0]
+			s.replay = s.replay[1:]
+			return next.lsn, next.msg, nil
+		}
+		var lsn, msg, err = s.receiveMessage(ctx)
+		if err != nil {
+			return 0, nil, err
+		}
+		if msg, err = s.handleStreamMessage(lsn, msg); err != nil {
+			return 0, nil, err
+		} else if msg != nil {
+			return lsn, msg, nil
+		}
+	}
+}
+
+// handleStreamMessage buffers the changes of streamed transactions, which `pgoutput`
+// sends in blocks while the transaction is still in progress once it outgrows the
+// server's `logical_decoding_work_mem`. Nothing is emitted until the transaction
+// commits, and then its buffered messages are replayed between a BEGIN and COMMIT
+// as though it had been sent whole. The changes of an aborted transaction, or of an
+// aborted subtransaction, are discarded. The transactions sent by `wal2json` are
+// buffered in the same way. Any other message is returned to be decoded.
+func (s *replicationStream) handleStreamMessage(lsn pglogrepl.LSN, msg pglogrepl.Message) (pglogrepl.Message, error) {
+	switch msg := msg.(type) {
+	case *streamStartMessage:
+		if s.streamXid != 0 {
+			return nil, fmt.Errorf("got STREAM START message while another stream in progress")
+		} else if s.nextTxnFinalLSN != 0 {
+			return nil, fmt.Errorf("got STREAM START message while another transaction in progress")
+		}
+		s.streamXid = msg.Xid
+		if s.streamedTxns == nil {
+			s.streamedTxns = make(map[uint32][]streamedChange)
+		}
+	case *streamStopMessage:
+		if s.streamXid == 0 {
+			return nil, fmt.Errorf("got STREAM STOP message without a stream in progress")
+		}
+		s.streamXid = 0
+	case *streamedMessage:
+		s.streamedTxns[s.streamXid] = append(s.streamedTxns[s.streamXid], streamedChange{lsn: lsn, xid: msg.Xid, msg: msg.Message})
+	case *streamCommitMessage:
+		if s.streamXid != 0 {
+			return nil, fmt.Errorf("got STREAM COMMIT message while a stream is in progress")
+		}
+		var changes = s.streamedTxns[msg.Xid]
+		delete(s.streamedTxns, msg.Xid)
+		logrus.WithFields(logrus.Fields{
+			"xid":       msg.Xid,
+			"commitLSN": msg.CommitLSN,
+			"messages":  len(changes),
+		}).Debug("replaying committed streamed transaction")
+
+		s.replay = append(s.replay, streamedChange{lsn: lsn, xid: msg.Xid, msg: &pglogrepl.BeginMessage{
+			FinalLSN:   msg.CommitLSN,
+			CommitTime: msg.CommitTime,
+			Xid:        msg.Xid,
+		}})
+		s.replay = append(s.replay, changes...)
+		s.replay = append(s.replay, streamedChange{lsn: lsn, xid: msg.Xid, msg: &pglogrepl.CommitMessage{
+			CommitLSN:         msg.CommitLSN,
+			TransactionEndLSN: msg.TransactionEndLSN,
+			CommitTime:        msg.CommitTime,
+		}})
+	case *wal2jsonMessage:
+		return nil, s.bufferWal2JSON(lsn, msg)
+	case *streamAbortMessage:
+		if s.streamXid != 0 {
+			return nil, fmt.Errorf("got STREAM ABORT message while a stream is in progress")
+		}
+		var changes = s.streamedTxns[msg.Xid]
+		if msg.SubXid == msg.Xid {
+			delete(s.streamedTxns, msg.Xid)
+		} else {
+			// Relation messages are kept, since `pgoutput` won't send them again for the
+			// remainder of the transaction.
+			var kept = changes[:0]
+			for _, change := range changes {
+				if _, isRelation := change.msg.(*pglogrepl.RelationMessage); isRelation || change.xid != msg.SubXid {
+					kept = append(kept, change)
+				}
+			}
+			s.streamedTxns[msg.Xid] = kept
+		}
+		logrus.WithFields(logrus.Fields{
+			"xid":    msg.Xid,
+			"subXid": msg.SubXid,
+		}).Debug("discarding aborted streamed transaction")
+	default:
+		return msg, nil
+	}
+	return nil, nil
+}
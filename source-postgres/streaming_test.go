@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/estuary/connectors/sqlcapture"
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgtype"
+	"github.com/stretchr/testify/require"
+)
+
+// encodeMessage encodes a raw `pgoutput` message of the given type and fields, which
+// are either byte slices, strings (which are null-terminated), or fixed-size integers.
+func encodeMessage(msgType byte, fields ...interface{}) []byte {
+	var buf = bytes.NewBuffer([]byte{msgType})
+	for _, field := range fields {
+		switch field := field.(type) {
+		case []byte:
+			buf.Write(field)
+		case string:
+			buf.WriteString(field)
+			buf.WriteByte(0)
+		default:
+			if err := binary.Write(buf, binary.BigEndian, field); err != nil {
+				panic(err)
+			}
+		}
+	}
+	return buf.Bytes()
+}
+
+func streamStart(xid uint32, first bool) []byte {
+	var firstSegment uint8
+	if first {
+		firstSegment = 1
+	}
+	return encodeMessage('S', xid, firstSegment)
+}
+
+func streamStop() []byte { return encodeMessage('E') }
+
+func streamCommit(xid uint32, commitLSN, endLSN pglogrepl.LSN) []byte {
+	return encodeMessage('c', xid, uint8(0), uint64(commitLSN), uint64(endLSN), int64(time.Hour/time.Microsecond))
+}
+
+func streamAbort(xid, subXid uint32) []byte {
+	return encodeMessage('A', xid, subXid)
+}
+
+func streamedRelation(xid uint32, rel *pglogrepl.RelationMessage) []byte {
+	var fields = []interface{}{xid, rel.RelationID, rel.Namespace, rel.RelationName, uint8('d'), uint16(len(rel.Columns))}
+	for _, col := range rel.Columns {
+		fields = append(fields, col.Flags, col.Name, col.DataType, int32(-1))
+	}
+	return encodeMessage('R', fields...)
+}
+
+func streamedInsert(xid uint32, values ...string) []byte {
+	var fields = []interface{}{xid, uint32(1), uint8('N'), uint16(len(values))}
+	for _, value := range values {
+		fields = append(fields, uint8('t'), uint32(len(value)), []byte(value))
+	}
+	return encodeMessage('I', fields...)
+}
+
+func TestParseStreamMessages(t *testing.T) {
+	var msg, err = parseMessage(streamStart(700, true), false)
+	require.NoError(t, err)
+	require.Equal(t, &streamStartMessage{Xid: 700, FirstSegment: true}, msg)
+
+	msg, err = parseMessage(streamCommit(700, 0x1234, 0x1240), false)
+	require.NoError(t, err)
+	require.Equal(t, &streamCommitMessage{
+		Xid:               700,
+		CommitLSN:         0x1234,
+		TransactionEndLSN: 0x1240,
+		CommitTime:        time.Date(2000, 1, 1, 1, 0, 0, 0, time.UTC),
+	}, msg)
+
+	msg, err = parseMessage(streamAbort(700, 701), false)
+	require.NoError(t, err)
+	require.Equal(t, &streamAbortMessage{Xid: 700, SubXid: 701}, msg)
+
+	// Messages within a stream block are prefixed with their transaction id.
+	msg, err = parseMessage(streamedInsert(701, "1", "one"), true)
+	require.NoError(t, err)
+	require.Equal(t, uint32(701), msg.(*streamedMessage).Xid)
+	require.Equal(t, uint32(1), msg.(*streamedMessage).Message.(*pglogrepl.InsertMessage).RelationID)
+
+	_, err = parseMessage(streamStart(700, true)[:3], false)
+	require.Error(t, err)
+
+	// Logical decoding messages are parsed both on their own and within a stream block.
+	var logical = &logicalDecodingMessage{Transactional: true, LSN: 0x1234, Prefix: watermarkMessagePrefix, Content: []byte("abc")}
+	msg, err = parseMessage(encodeMessage('M', uint8(1), uint64(0x1234), watermarkMessagePrefix, uint32(3), []byte("abc")), false)
+	require.NoError(t, err)
+	require.Equal(t, logical, msg)
+	msg, err = parseMessage(encodeMessage('M', uint32(701), uint8(1), uint64(0x1234), watermarkMessagePrefix, uint32(3), []byte("abc")), true)
+	require.NoError(t, err)
+	require.Equal(t, &streamedMessage{Message: logical, Xid: 701}, msg)
+	_, err = parseMessage(encodeMessage('M', uint8(1), uint64(0x1234), watermarkMessagePrefix, uint32(4), []byte("abc")), false)
+	require.Error(t, err)
+}
+
+func TestStreamedTransactions(t *testing.T) {
+	var ctx = context.Background()
+	var rel = testRelation("id", "data")
+	var s = &replicationStream{
+		connInfo:      pgtype.NewConnInfo(),
+		relations:     make(map[uint32]*pglogrepl.RelationMessage),
+		renames:       newColumnRenames(),
+		updateColumns: updateColumnsAvailable,
+	}
+	s.tables.active = map[string]struct{}{"public.things": {}}
+
+	// receive handles raw messages as though they were received from the database,
+	// and returns the IDs of the inserted rows and the commits which are emitted.
+	var receive = func(msgs ...[]byte) []string {
+		var emitted []string
+		var emit = func(lsn pglogrepl.LSN, msg pglogrepl.Message) {
+			var event, err = s.decodeMessage(ctx, lsn, msg)
+			require.NoError(t, err)
+			switch {
+			case event == nil:
+			case event.Operation == sqlcapture.FlushOp:
+				emitted = append(emitted, "commit")
+			default:
+				emitted = append(emitted, fmt.Sprint(event.After["id"]))
+			}
+		}
+		for idx, data := range msgs {
+			var lsn = pglogrepl.LSN(1000 + idx)
+			var msg, err = parseMessage(data, s.streamXid != 0)
+			require.NoError(t, err)
+			msg, err = s.handleStreamMessage(lsn, msg)
+			require.NoError(t, err)
+			if msg != nil {
+				emit(lsn, msg)
+			}
+			for len(s.replay) > 0 {
+				var next = s.replay[0]
+				s.replay = s.replay[1:]
+				emit(next.lsn, next.msg)
+			}
+		}
+		return emitted
+	}
+
+	// A large transaction is streamed in blocks, and nothing is emitted until it commits.
+	// A subtransaction which is rolled back has its changes discarded.
+	require.Empty(t, receive(
+		streamStart(700, true),
+		streamedRelation(700, rel),
+		streamedInsert(700, "1", "one"),
+		streamedInsert(701, "2", "two"),
+		streamStop(),
+		streamAbort(700, 701),
+		streamStart(700, false),
+		streamedInsert(700, "3", "three"),
+		streamStop(),
+	))
+	require.Equal(t, []string{"1", "3", "commit"}, receive(streamCommit(700, 0x2000, 0x2010)))
+	require.Equal(t, pglogrepl.LSN(0x2010), s.lastTxnEndLSN)
+
+	// A large transaction which is rolled back emits nothing at all.
+	var msgs = [][]byte{streamStart(800, true), streamedRelation(800, rel)}
+	for id := 1000; id < 2000; id++ {
+		msgs = append(msgs, streamedInsert(800, fmt.Sprint(id), "rolled back"))
+	}
+	msgs = append(msgs, streamStop(), streamAbort(800, 800))
+	require.Empty(t, receive(msgs...))
+	require.Empty(t, s.streamedTxns)
+	require.Equal(t, pglogrepl.LSN(0x2010), s.lastTxnEndLSN)
+
+	// Stream blocks may not be nested, and must be stopped before they commit.
+	require.Empty(t, receive(streamStart(900, true)))
+	var _, err = s.handleStreamMessage(2000, &streamStartMessage{Xid: 901})
+	require.Error(t, err)
+	_, err = s.handleStreamMessage(2000, &streamCommitMessage{Xid: 900})
+	require.Error(t, err)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+)
+
+// Values of the `sslMode` config property, which have the same meanings as the corresponding
+// `sslmode` settings of libpq.
+const (
+	sslModeDisable    = "disable"
+	sslModeRequire    = "require"
+	sslModeVerifyCA   = "verify-ca"
+	sslModeVerifyFull = "verify-full"
+)
+
+// tlsConfig returns the TLS configuration of connections to the given host, or nil if they
+// shouldn't use TLS. It's only meaningful when `sslMode` is set, since otherwise the TLS
+// settings parsed from the connection string are used as they are.
+func (c *Config) tlsConfig(host string) (*tls.Config, error) {
+	if c.SSLMode == sslModeDisable {
+		return nil, nil
+	}
+
+	var config = &tls.Config{ServerName: host}
+	if (c.SSLClientCert == "") != (c.SSLClientKey == "") {
+		return nil, fmt.Errorf("'sslClientCert' and 'sslClientKey' must be specified together")
+	} else if c.SSLClientCert != "" {
+		var cert, err = tls.X509KeyPair([]byte(c.SSLClientCert), []byte(c.SSLClientKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid 'sslClientCert' or 'sslClientKey': %w", err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	// Without a root certificate the system's roots are used to verify the server.
+	var roots *x509.CertPool
+	if c.SSLRootCert != "" {
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM([]byte(c.SSLRootCert)) {
+			return nil, fmt.Errorf("invalid 'sslRootCert': no PEM certificates could be parsed")
+		}
+	}
+
+	switch c.SSLMode {
+	case sslModeVerifyFull:
+		// The standard verification checks both the certificate chain and that the
+		// certificate is for the server's hostname.
+		config.RootCAs = roots
+	case sslModeRequire, sslModeVerifyCA:
+		// As with libpq, `require` verifies the certificate chain like `verify-ca` when
+		// a root certificate is given, and otherwise just encrypts the connection.
+		config.InsecureSkipVerify = true
+		if c.SSLMode == sslModeVerifyCA || roots != nil {
+			config.VerifyPeerCertificate = verifyCertificateChain(roots)
+		}
+	default:
+		return nil, fmt.Errorf("invalid 'sslMode' configuration: must be %q, %q, %q, or %q", sslModeDisable, sslModeRequire, sslModeVerifyCA, sslModeVerifyFull)
+	}
+	return config, nil
+}
+
+// verifyCertificateChain returns a function which verifies that the certificate presented by
+// a server is signed by the given roots, without checking that it's for the server's hostname.
+func verifyCertificateChain(roots *x509.CertPool) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return fmt.Errorf("server presented no certificate")
+		}
+		var certs = make([]*x509.Certificate, len(rawCerts))
+		for i, raw := range rawCerts {
+			var cert, err = x509.ParseCertificate(raw)
+			if err != nil {
+				return fmt.Errorf("error parsing server certificate: %w", err)
+			}
+			certs[i] = cert
+		}
+		var intermediates = x509.NewCertPool()
+		for _, cert := range certs[1:] {
+			intermediates.AddCert(cert)
+		}
+		if _, err := certs[0].Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates}); err != nil {
+			return fmt.Errorf("error verifying server certificate: %w", err)
+		}
+		return nil
+	}
+}
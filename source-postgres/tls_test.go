@@ -0,0 +1,191 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+// testCertificate is a certificate and its private key, in both parsed and PEM form.
+type testCertificate struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM string
+	keyPEM  string
+}
+
+// newTestCertificate returns a certificate for the given hostname, signed by the parent
+// or self-signed as a CA if the parent is nil.
+func newTestCertificate(t *testing.T, name string, parent *testCertificate) *testCertificate {
+	t.Helper()
+	var key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	var template = &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	var signer, signerKey = template, key
+	if parent == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		template.DNSNames = []string{name}
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	require.NoError(t, err)
+	return &testCertificate{
+		cert:    cert,
+		key:     key,
+		certPEM: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		keyPEM:  string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+	}
+}
+
+// handshake performs a TLS handshake between a client with the given config and a server
+// presenting the given certificate, which requires a client certificate signed by clientCA
+// if it's non-nil.
+func handshake(t *testing.T, client *tls.Config, server *testCertificate, clientCA *testCertificate) error {
+	t.Helper()
+	var serverCert, err = tls.X509KeyPair([]byte(server.certPEM), []byte(server.keyPEM))
+	require.NoError(t, err)
+	var serverConfig = &tls.Config{Certificates: []tls.Certificate{serverCert}}
+	if clientCA != nil {
+		serverConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		serverConfig.ClientCAs = x509.NewCertPool()
+		serverConfig.ClientCAs.AddCert(clientCA.cert)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	var serverErr = make(chan error, 1)
+	go func() {
+		var serverConn, err = listener.Accept()
+		if err != nil {
+			serverErr <- err
+			return
+		}
+		var conn = tls.Server(serverConn, serverConfig)
+		serverErr <- conn.Handshake()
+		conn.Close()
+	}()
+	clientConn, err := net.Dial("tcp", listener.Addr().String())
+	require.NoError(t, err)
+	defer clientConn.Close()
+	var conn = tls.Client(clientConn, client)
+	if err := conn.Handshake(); err != nil {
+		return err
+	}
+	// A rejected client certificate is only noticed by the client once the server responds.
+	return <-serverErr
+}
+
+func TestTLSConfig(t *testing.T) {
+	var ca = newTestCertificate(t, "Test CA", nil)
+	var otherCA = newTestCertificate(t, "Other CA", nil)
+	var server = newTestCertificate(t, "db.example.com", ca)
+	var client = newTestCertificate(t, "flow_capture", ca)
+
+	for _, tc := range []struct {
+		name   string
+		config Config
+		host   string
+		valid  bool
+	}{
+		{"require", Config{SSLMode: sslModeRequire}, "db.example.com", true},
+		{"require with root", Config{SSLMode: sslModeRequire, SSLRootCert: ca.certPEM}, "db.example.com", true},
+		{"require with other root", Config{SSLMode: sslModeRequire, SSLRootCert: otherCA.certPEM}, "db.example.com", false},
+		{"verify-ca", Config{SSLMode: sslModeVerifyCA, SSLRootCert: ca.certPEM}, "db.example.com", true},
+		{"verify-ca of other host", Config{SSLMode: sslModeVerifyCA, SSLRootCert: ca.certPEM}, "10.0.0.1", true},
+		{"verify-ca with other root", Config{SSLMode: sslModeVerifyCA, SSLRootCert: otherCA.certPEM}, "db.example.com", false},
+		{"verify-full", Config{SSLMode: sslModeVerifyFull, SSLRootCert: ca.certPEM}, "db.example.com", true},
+		{"verify-full of other host", Config{SSLMode: sslModeVerifyFull, SSLRootCert: ca.certPEM}, "10.0.0.1", false},
+		{"verify-full with other root", Config{SSLMode: sslModeVerifyFull, SSLRootCert: otherCA.certPEM}, "db.example.com", false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var tlsConfig, err = tc.config.tlsConfig(tc.host)
+			require.NoError(t, err)
+			err = handshake(t, tlsConfig, server, nil)
+			if tc.valid {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+			}
+		})
+	}
+
+	// A server which requires a client certificate accepts the configured one.
+	var mutual = Config{SSLMode: sslModeVerifyFull, SSLRootCert: ca.certPEM}
+	var tlsConfig, err = mutual.tlsConfig("db.example.com")
+	require.NoError(t, err)
+	require.Error(t, handshake(t, tlsConfig, server, ca))
+	mutual.SSLClientCert, mutual.SSLClientKey = client.certPEM, client.keyPEM
+	tlsConfig, err = mutual.tlsConfig("db.example.com")
+	require.NoError(t, err)
+	require.NoError(t, handshake(t, tlsConfig, server, ca))
+
+	// Connections don't use TLS when it's disabled.
+	tlsConfig, err = (&Config{SSLMode: sslModeDisable}).tlsConfig("db.example.com")
+	require.NoError(t, err)
+	require.Nil(t, tlsConfig)
+}
+
+func TestTLSConnConfig(t *testing.T) {
+	var ca = newTestCertificate(t, "Test CA", nil)
+	var cfg = Config{Address: "db.example.com:5432", User: "flow_capture", Password: "secret"}
+	cfg.SetDefaults()
+
+	// Without an SSL mode, the connection string's default of preferring TLS applies.
+	var connConfig, err = cfg.ConnConfig()
+	require.NoError(t, err)
+	require.NotNil(t, connConfig.TLSConfig)
+	require.NotEmpty(t, connConfig.Fallbacks)
+
+	// The configured SSL mode takes precedence, and doesn't fall back to an unencrypted
+	// connection. The server's hostname is verified against its certificate.
+	cfg.SSLMode, cfg.SSLRootCert = sslModeVerifyFull, ca.certPEM
+	require.NoError(t, cfg.Validate())
+	connConfig, err = cfg.ConnConfig()
+	require.NoError(t, err)
+	require.Equal(t, "db.example.com", connConfig.TLSConfig.ServerName)
+	require.False(t, connConfig.TLSConfig.InsecureSkipVerify)
+	require.Empty(t, connConfig.Fallbacks)
+
+	cfg.SSLMode = sslModeDisable
+	connConfig, err = cfg.ConnConfig()
+	require.NoError(t, err)
+	require.Nil(t, connConfig.TLSConfig)
+	require.Empty(t, connConfig.Fallbacks)
+
+	// Invalid TLS settings are rejected.
+	for _, invalid := range []Config{
+		{SSLMode: "prefer"},
+		{SSLMode: sslModeVerifyCA, SSLRootCert: "not a certificate"},
+		{SSLMode: sslModeRequire, SSLClientCert: ca.certPEM},
+		{SSLMode: sslModeRequire, SSLClientCert: ca.certPEM, SSLClientKey: "not a key"},
+		{SSLRootCert: ca.certPEM},
+	} {
+		invalid.Address, invalid.User, invalid.Password = cfg.Address, cfg.User, cfg.Password
+		require.Error(t, invalid.Validate())
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// Modes of the 'updateColumns' option, which controls the columns included in the
+// 'after' state of update events.
+//
+// PostgreSQL includes every column in the new tuple of an update, except for TOAST
+// values which weren't changed. Those are only available if the table's REPLICA
+// IDENTITY includes them in the old tuple.
+const (
+	// Include every column whose value is available from the replication log. Unchanged
+	// TOAST values are omitted unless the old tuple includes them.
+	updateColumnsAvailable = "available"
+	// Include every column, querying the table for unchanged TOAST values which the old
+	// tuple doesn't include.
+	updateColumnsFull = "full"
+	// Include only the key columns and the columns which changed. Unless the old tuple
+	// includes every column, only unchanged TOAST values can be known to be unchanged.
+	updateColumnsDelta = "delta"
+)
+
+// omitUnchangedColumns removes the non-key columns of an update's 'after' state whose
+// values are the same in its 'before' state. Columns which are missing from the 'before'
+// state can't be known to be unchanged and are kept.
+func omitUnchangedColumns(rel *pglogrepl.RelationMessage, before, after map[string]interface{}) {
+	for _, col := range rel.Columns {
+		if col.Flags&1 != 0 {
+			continue // Key columns are always included.
+		}
+		var prev, ok = before[col.Name]
+		if !ok {
+			continue
+		}
+		if next, ok := after[col.Name]; ok && reflect.DeepEqual(prev, next) {
+			delete(after, col.Name)
+		}
+	}
+}
+
+// buildFillQuery returns the query which selects the named columns of the row with the
+// given key columns, whose values are its parameters.
+func buildFillQuery(schema, table string, columns, keyColumns []string) string {
+	var selects, wheres []string
+	for _, col := range columns {
+		selects = append(selects, pgx.Identifier{col}.Sanitize())
+	}
+	for idx, col := range keyColumns {
+		wheres = append(wheres, fmt.Sprintf("%s = $%d", pgx.Identifier{col}.Sanitize(), idx+1))
+	}
+	return fmt.Sprintf("SELECT %s FROM %s WHERE %s;",
+		strings.Join(selects, ", "),
+		pgx.Identifier{schema, table}.Sanitize(),
+		strings.Join(wheres, " AND "),
+	)
+}
+
+// fillUnchangedColumns queries the current values of the named columns of an updated row,
+// which were omitted from the replication log because they hold unchanged TOAST values,
+// and adds them to its 'after' state. This costs a query for every such update, and the
+// values are as of the query rather than the update, though they can only differ if the
+// row has since been updated again.
+func (s *replicationStream) fillUnchangedColumns(ctx context.Context, rel *pglogrepl.RelationMessage, after map[string]interface{}, columns []string) error {
+	var keyColumns []string
+	var keyValues []interface{}
+	for _, col := range rel.Columns {
+		if col.Flags&1 != 0 {
+			keyColumns = append(keyColumns, col.Name)
+			keyValues = append(keyValues, after[col.Name])
+		}
+	}
+	if len(keyColumns) == 0 {
+		// Without a replica identity there's no way to find the row.
+		logrus.WithFields(logrus.Fields{
+			"schema":  rel.Namespace,
+			"table":   rel.RelationName,
+			"columns": columns,
+		}).Debug("can't query unchanged columns of a table without a replica identity")
+		return nil
+	}
+
+	var conn, err = s.queryConnection(ctx)
+	if err != nil {
+		return err
+	}
+	var query = buildFillQuery(rel.Namespace, rel.RelationName, columns, keyColumns)
+	logrus.WithFields(logrus.Fields{"query": query, "args": keyValues}).Debug("querying unchanged columns")
+	rows, err := conn.Query(ctx, query, keyValues...)
+	if err != nil {
+		return fmt.Errorf("unable to execute query %q: %w", query, err)
+	}
+	defer rows.Close()
+	if rows.Next() {
+		var vals, err = rows.Values()
+		if err != nil {
+			return fmt.Errorf("unable to get row values: %w", err)
+		}
+		for idx, col := range columns {
+			after[col] = vals[idx]
+		}
+	}
+	// If the row has since been deleted the columns are left out, just as they would be
+	// in the 'available' mode.
+	return rows.Err()
+}
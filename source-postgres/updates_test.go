@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgtype"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDecodeUnchangedColumns(t *testing.T) {
+	var rel = testRelation("id", "data", "other")
+	var tuple = &pglogrepl.TupleData{Columns: []*pglogrepl.TupleDataColumn{
+		{DataType: 't', Data: []byte("1")},
+		{DataType: 'u'},
+		{DataType: 't', Data: []byte("changed")},
+	}}
+	var fullBefore = map[string]interface{}{"id": "1", "data": "toasted", "other": "original"}
+
+	for _, tc := range []struct {
+		mode      string
+		before    map[string]interface{}
+		expect    map[string]interface{}
+		unchanged []string
+	}{
+		// Unchanged TOAST values are only included if they're in the old tuple.
+		{updateColumnsAvailable, nil, map[string]interface{}{"id": "1", "other": "changed"}, []string{"data"}},
+		{updateColumnsAvailable, fullBefore, map[string]interface{}{"id": "1", "data": "toasted", "other": "changed"}, nil},
+		{updateColumnsFull, nil, map[string]interface{}{"id": "1", "other": "changed"}, []string{"data"}},
+		{updateColumnsFull, fullBefore, map[string]interface{}{"id": "1", "data": "toasted", "other": "changed"}, nil},
+		// And are never included in the 'delta' mode.
+		{updateColumnsDelta, nil, map[string]interface{}{"id": "1", "other": "changed"}, []string{"data"}},
+		{updateColumnsDelta, fullBefore, map[string]interface{}{"id": "1", "other": "changed"}, nil},
+	} {
+		var s = &replicationStream{connInfo: pgtype.NewConnInfo(), updateColumns: tc.mode}
+		var fields, unchanged, err = s.decodeTuple(tuple, 'N', rel, tc.before)
+		require.NoError(t, err)
+		require.Equal(t, tc.expect, fields, "mode %q", tc.mode)
+		require.Equal(t, tc.unchanged, unchanged, "mode %q", tc.mode)
+	}
+}
+
+func TestOmitUnchangedColumns(t *testing.T) {
+	var rel = testRelation("id", "a", "b", "c")
+
+	// Only the key and changed columns remain.
+	var after = map[string]interface{}{"id": 1, "a": "one", "b": "TWO", "c": nil}
+	omitUnchangedColumns(rel, map[string]interface{}{"id": 1, "a": "one", "b": "two", "c": nil}, after)
+	require.Equal(t, map[string]interface{}{"id": 1, "b": "TWO"}, after)
+
+	// Columns which aren't in the old tuple can't be known to be unchanged.
+	after = map[string]interface{}{"id": 1, "a": "one", "b": "two"}
+	omitUnchangedColumns(rel, map[string]interface{}{"id": 1}, after)
+	require.Equal(t, map[string]interface{}{"id": 1, "a": "one", "b": "two"}, after)
+	omitUnchangedColumns(rel, nil, after)
+	require.Equal(t, map[string]interface{}{"id": 1, "a": "one", "b": "two"}, after)
+}
+
+func TestBuildFillQuery(t *testing.T) {
+	require.Equal(t,
+		`SELECT "data" FROM "public"."things" WHERE "id" = $1;`,
+		buildFillQuery("public", "things", []string{"data"}, []string{"id"}))
+	require.Equal(t,
+		`SELECT "Data", "more" FROM "Other"."things" WHERE "a" = $1 AND "b" = $2;`,
+		buildFillQuery("Other", "things", []string{"Data", "more"}, []string{"a", "b"}))
+}
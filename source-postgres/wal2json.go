@@ -0,0 +1,364 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/estuary/connectors/sqlcapture"
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgx/v4"
+	"github.com/sirupsen/logrus"
+)
+
+// The `wal2json` output plugin may be used instead of `pgoutput` on databases which don't
+// offer it, such as some managed PostgreSQL services. Its messages are JSON documents in
+// version 2 of its format, which are translated into the same messages that `pgoutput` sends,
+// so that they're decoded into change events exactly as those are. There are two differences
+// which the translation must make up for:
+//
+//   - wal2json doesn't describe relations, so they're queried from the catalogs when the
+//     changes of a table are first received, and again whenever a change has columns which
+//     its relation doesn't. As with partitions, the catalogs are queried as of now rather
+//     than as of the change.
+//   - wal2json doesn't send the commit LSN of a transaction before its changes, so each
+//     transaction is buffered until it commits and then replayed like a streamed one.
+
+// Logical decoding output plugins which replication can use.
+const (
+	replicationPluginPgoutput = "pgoutput"
+	replicationPluginWal2JSON = "wal2json"
+)
+
+// wal2jsonPluginArgs are the options with which the `wal2json` plugin is started.
+var wal2jsonPluginArgs = []string{
+	`"format-version" '2'`,
+	`"include-transaction" '1'`,
+	`"include-timestamp" '1'`,
+	`"include-type-oids" '1'`,
+}
+
+// messageTypeWal2JSON is the type of the messages of `wal2json`, which don't have a type
+// byte of their own.
+const messageTypeWal2JSON pglogrepl.MessageType = '{'
+
+// wal2jsonMessage is a message of version 2 of the wal2json format, which describes a single
+// action: the beginning ("B") or commit ("C") of a transaction, an insert ("I"), update ("U"),
+// or deletion ("D") of a row, the truncation of a table ("T"), or a logical decoding message
+// ("M"). Changes have the new values of the row's columns, and the old values of its replica
+// identity, as columns and identity.
+type wal2jsonMessage struct {
+	Action    string           `json:"action"`
+	Timestamp string           `json:"timestamp,omitempty"`
+	Schema    string           `json:"schema,omitempty"`
+	Table     string           `json:"table,omitempty"`
+	Columns   []wal2jsonColumn `json:"columns,omitempty"`
+	Identity  []wal2jsonColumn `json:"identity,omitempty"`
+
+	// The prefix and content of logical decoding messages.
+	Prefix  string `json:"prefix,omitempty"`
+	Content string `json:"content,omitempty"`
+}
+
+func (*wal2jsonMessage) Type() pglogrepl.MessageType { return messageTypeWal2JSON }
+
+// wal2jsonColumn is the value of a column in a wal2jsonMessage. Numbers and booleans are JSON
+// numbers and booleans, and other values are strings in their text format.
+type wal2jsonColumn struct {
+	Name    string          `json:"name"`
+	Type    string          `json:"type"`
+	TypeOID uint32          `json:"typeoid,omitempty"`
+	Value   json.RawMessage `json:"value"`
+}
+
+// parseWal2JSON parses a message of the `wal2json` plugin.
+func parseWal2JSON(data []byte) (pglogrepl.Message, error) {
+	var msg wal2jsonMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, fmt.Errorf("error parsing wal2json message: %w", err)
+	}
+	return &msg, nil
+}
+
+// parseWal2JSONTime parses the commit timestamp of a transaction, which wal2json formats as a
+// `timestamptz` in the ISO date style such as "2022-03-04 05:06:07.123456+00".
+func parseWal2JSONTime(str string) (time.Time, error) {
+	if str == "" {
+		return time.Time{}, nil
+	}
+	var err error
+	for _, layout := range []string{
+		"2006-01-02 15:04:05.999999-07",
+		"2006-01-02 15:04:05.999999-07:00",
+		"2006-01-02 15:04:05.999999-07:00:00",
+	} {
+		var t time.Time
+		if t, err = time.Parse(layout, str); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid wal2json timestamp %q: %w", str, err)
+}
+
+// bufferWal2JSON buffers the messages of a transaction received from `wal2json` until it
+// commits, at which point they're replayed between a BEGIN and COMMIT. The LSN of the commit
+// message is the end of the transaction, which is used as its commit LSN as well since the
+// actual commit LSN isn't known. Both order transactions the same way.
+func (s *replicationStream) bufferWal2JSON(lsn pglogrepl.LSN, msg *wal2jsonMessage) error {
+	switch msg.Action {
+	case "B":
+		if s.wal2json.begin != nil {
+			return fmt.Errorf("got wal2json BEGIN message while another transaction in progress")
+		}
+		s.wal2json.begin = msg
+		return nil
+	case "C":
+		if s.wal2json.begin == nil {
+			return fmt.Errorf("got wal2json COMMIT message without a transaction in progress")
+		}
+		var commitTime, err = parseWal2JSONTime(s.wal2json.begin.Timestamp)
+		if err != nil {
+			return err
+		}
+		s.replay = append(s.replay, streamedChange{lsn: lsn, msg: &pglogrepl.BeginMessage{
+			FinalLSN:   lsn,
+			CommitTime: commitTime,
+		}})
+		s.replay = append(s.replay, s.wal2json.txn...)
+		s.replay = append(s.replay, streamedChange{lsn: lsn, msg: &pglogrepl.CommitMessage{
+			CommitLSN:         lsn,
+			TransactionEndLSN: lsn,
+			CommitTime:        commitTime,
+		}})
+		s.wal2json.begin, s.wal2json.txn = nil, nil
+		return nil
+	}
+	if s.wal2json.begin == nil && msg.Action == "M" {
+		return nil // Non-transactional logical decoding messages aren't captured.
+	} else if s.wal2json.begin == nil {
+		return fmt.Errorf("got wal2json %q message without a transaction in progress", msg.Action)
+	}
+	s.wal2json.txn = append(s.wal2json.txn, streamedChange{lsn: lsn, msg: msg})
+	return nil
+}
+
+// decodeWal2JSONChange decodes a change received from `wal2json` into a change event. If the
+// relation of its table isn't known, or doesn't match the change, the relation is queried and
+// handled first, and the change is decoded again once it has been.
+func (s *replicationStream) decodeWal2JSONChange(ctx context.Context, lsn pglogrepl.LSN, msg *wal2jsonMessage) (*sqlcapture.ChangeEvent, error) {
+	var op sqlcapture.ChangeOp
+	switch msg.Action {
+	case "I":
+		op = sqlcapture.InsertOp
+	case "U":
+		op = sqlcapture.UpdateOp
+	case "D":
+		op = sqlcapture.DeleteOp
+	case "M":
+		return s.decodeLogicalMessage(lsn, &logicalDecodingMessage{Transactional: true, Prefix: msg.Prefix, Content: []byte(msg.Content)})
+	default:
+		// Truncations are unhandled, just as they are when they're sent by `pgoutput`.
+		return nil, fmt.Errorf("unhandled wal2json action %q on table %q", msg.Action, sqlcapture.JoinStreamID(msg.Schema, msg.Table))
+	}
+
+	// The relation isn't needed for the changes of tables which aren't captured, unless they
+	// may be partitions of a captured table.
+	var streamID = sqlcapture.JoinStreamID(msg.Schema, msg.Table)
+	if !s.tableActive(streamID) && len(s.partitionedTables) == 0 {
+		return nil, nil
+	}
+
+	if s.wal2json.relations == nil {
+		s.wal2json.relations = make(map[string]*pglogrepl.RelationMessage)
+	}
+	var key = pgx.Identifier{msg.Schema, msg.Table}.Sanitize()
+	var rel = s.wal2json.relations[key]
+	if !wal2jsonRelationMatches(rel, msg) {
+		if s.wal2json.refreshed != msg {
+			var next, err = s.queryWal2JSONRelation(ctx, msg.Schema, msg.Table)
+			if err != nil {
+				return nil, err
+			} else if next == nil && s.tableActive(streamID) {
+				return nil, fmt.Errorf("table %q of wal2json change no longer exists", streamID)
+			} else if next == nil {
+				return nil, nil
+			}
+			s.wal2json.relations[key] = next
+			s.wal2json.refreshed = msg
+			s.replay = append([]streamedChange{{lsn: lsn, msg: msg}}, s.replay...)
+			return s.handleRelation(ctx, next)
+		}
+		// The table has changed again since the change was made, so the values of any columns
+		// which it no longer has can't be captured.
+		logrus.WithFields(logrus.Fields{
+			"schema": msg.Schema,
+			"table":  msg.Table,
+		}).Warn("columns of wal2json change don't match its table")
+	}
+
+	// The old values of the replica identity are included in every update, but `pgoutput`
+	// only sends them if the identity is the full row or if the update changed it.
+	var identity = msg.Identity
+	if msg.Action == "U" && !wal2jsonIdentityChanged(rel, msg) {
+		identity = nil
+	}
+	var before, err = wal2jsonTuple(rel, identity)
+	if err != nil {
+		return nil, fmt.Errorf("'before' tuple: %w", err)
+	}
+	after, err := wal2jsonTuple(rel, msg.Columns)
+	if err != nil {
+		return nil, fmt.Errorf("'after' tuple: %w", err)
+	}
+	// The 'before' tuple is decoded as an old tuple in which the columns outside of the replica
+	// identity are unchanged, which omits them just as they are from a key-only old tuple.
+	return s.decodeChangeEvent(ctx, op, lsn, 'O', before, after, rel.RelationID)
+}
+
+// wal2jsonRelationMatches returns whether each column of a change is a column of the relation
+// having the same type, and whether the relation has no other columns if it's an insert.
+// Other changes may omit unchanged TOAST values.
+func wal2jsonRelationMatches(rel *pglogrepl.RelationMessage, msg *wal2jsonMessage) bool {
+	if rel == nil {
+		return false
+	} else if msg.Action == "I" && len(msg.Columns) != len(rel.Columns) {
+		return false
+	}
+	var types = make(map[string]uint32)
+	for _, col := range rel.Columns {
+		types[col.Name] = col.DataType
+	}
+	for _, cols := range [][]wal2jsonColumn{msg.Columns, msg.Identity} {
+		for _, col := range cols {
+			if dataType, ok := types[col.Name]; !ok || (col.TypeOID != 0 && col.TypeOID != dataType) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// wal2jsonIdentityChanged returns whether the replica identity of the relation is the full
+// row, or whether the update changed the value of any of its columns.
+func wal2jsonIdentityChanged(rel *pglogrepl.RelationMessage, msg *wal2jsonMessage) bool {
+	var full = true
+	for _, col := range rel.Columns {
+		full = full && (col.Flags&1) != 0
+	}
+	if full {
+		return true
+	}
+	var values = make(map[string]json.RawMessage)
+	for _, col := range msg.Columns {
+		values[col.Name] = col.Value
+	}
+	for _, col := range msg.Identity {
+		// Columns without a new value are unchanged TOAST values.
+		if value, ok := values[col.Name]; ok && !bytes.Equal(value, col.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+// wal2jsonTuple returns the tuple of the relation's columns having the given values, or nil
+// if there are none. Columns without a value are unchanged TOAST values.
+func wal2jsonTuple(rel *pglogrepl.RelationMessage, values []wal2jsonColumn) (*pglogrepl.TupleData, error) {
+	if values == nil {
+		return nil, nil
+	}
+	var byName = make(map[string]json.RawMessage)
+	for _, col := range values {
+		byName[col.Name] = col.Value
+	}
+	var tuple = &pglogrepl.TupleData{ColumnNum: uint16(len(rel.Columns))}
+	for _, col := range rel.Columns {
+		var data = &pglogrepl.TupleDataColumn{DataType: 'u'}
+		if value, ok := byName[col.Name]; ok {
+			var err error
+			if data, err = wal2jsonValue(value); err != nil {
+				return nil, fmt.Errorf("column %q: %w", col.Name, err)
+			}
+		}
+		tuple.Columns = append(tuple.Columns, data)
+	}
+	return tuple, nil
+}
+
+// wal2jsonValue returns the text format of a column value of wal2json.
+func wal2jsonValue(value json.RawMessage) (*pglogrepl.TupleDataColumn, error) {
+	switch {
+	case len(value) == 0 || string(value) == "null":
+		return &pglogrepl.TupleDataColumn{DataType: 'n'}, nil
+	case value[0] == '"':
+		var str string
+		if err := json.Unmarshal(value, &str); err != nil {
+			return nil, err
+		}
+		return &pglogrepl.TupleDataColumn{DataType: 't', Length: uint32(len(str)), Data: []byte(str)}, nil
+	case string(value) == "true":
+		return &pglogrepl.TupleDataColumn{DataType: 't', Length: 1, Data: []byte("t")}, nil
+	case string(value) == "false":
+		return &pglogrepl.TupleDataColumn{DataType: 't', Length: 1, Data: []byte("f")}, nil
+	default:
+		// Numbers are written as they're formatted by PostgreSQL.
+		return &pglogrepl.TupleDataColumn{DataType: 't', Length: uint32(len(value)), Data: []byte(value)}, nil
+	}
+}
+
+// queryWal2JSONRelation describes the current columns of a table, and which of them are part
+// of its replica identity, as a relation message like those sent by `pgoutput`. It returns
+// nil if the table doesn't exist.
+const queryWal2JSONRelation = `
+  SELECT c.oid, a.attname::text, a.atttypid, c.relreplident = 'f' OR COALESCE(a.attnum = ANY(i.indkey), false)
+  FROM pg_catalog.pg_class c
+  JOIN pg_catalog.pg_namespace n ON (n.oid = c.relnamespace)
+  JOIN pg_catalog.pg_attribute a ON (a.attrelid = c.oid AND a.attnum > 0 AND NOT a.attisdropped)
+  LEFT JOIN pg_catalog.pg_index i ON (i.indrelid = c.oid AND CASE c.relreplident
+    WHEN 'd' THEN i.indisprimary
+    WHEN 'i' THEN i.indisreplident
+    ELSE false END)
+  WHERE n.nspname = $1 AND c.relname = $2
+  ORDER BY a.attnum;`
+
+func (s *replicationStream) queryWal2JSONRelation(ctx context.Context, schema, table string) (*pglogrepl.RelationMessage, error) {
+	var conn, err = s.queryConnection(ctx)
+	if err != nil {
+		return nil, err
+	}
+	var rel *pglogrepl.RelationMessage
+	var relID, dataType uint32
+	var name string
+	var isIdentity bool
+	_, err = conn.QueryFunc(ctx, queryWal2JSONRelation, []interface{}{schema, table}, []interface{}{&relID, &name, &dataType, &isIdentity},
+		func(r pgx.QueryFuncRow) error {
+			if rel == nil {
+				rel = &pglogrepl.RelationMessage{RelationID: relID, Namespace: schema, RelationName: table}
+			}
+			var flags uint8
+			if isIdentity {
+				flags = 1
+			}
+			rel.Columns = append(rel.Columns, &pglogrepl.RelationMessageColumn{
+				Flags:        flags,
+				Name:         name,
+				DataType:     dataType,
+				TypeModifier: -1,
+			})
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("error querying columns of table %q: %w", sqlcapture.JoinStreamID(schema, table), err)
+	}
+	if rel != nil {
+		rel.ColumnNum = uint16(len(rel.Columns))
+		logrus.WithFields(logrus.Fields{
+			"schema":  schema,
+			"table":   table,
+			"columns": len(rel.Columns),
+		}).Debug("queried relation of wal2json changes")
+	}
+	return rel, nil
+}
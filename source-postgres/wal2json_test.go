@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/estuary/connectors/sqlcapture"
+	"github.com/jackc/pglogrepl"
+	"github.com/jackc/pgtype"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseWal2JSON(t *testing.T) {
+	var msg, err = parseWal2JSON([]byte(`{"action":"I","schema":"public","table":"things","columns":[{"name":"id","type":"integer","typeoid":23,"value":1},{"name":"data","type":"text","typeoid":25,"value":null}]}`))
+	require.NoError(t, err)
+	require.Equal(t, &wal2jsonMessage{
+		Action: "I",
+		Schema: "public",
+		Table:  "things",
+		Columns: []wal2jsonColumn{
+			{Name: "id", Type: "integer", TypeOID: 23, Value: []byte("1")},
+			{Name: "data", Type: "text", TypeOID: 25, Value: []byte("null")},
+		},
+	}, msg)
+
+	_, err = parseWal2JSON([]byte(`{"action":`))
+	require.Error(t, err)
+
+	for str, expect := range map[string]time.Time{
+		"2022-03-04 05:06:07.123456+00":    time.Date(2022, 3, 4, 5, 6, 7, 123456000, time.UTC),
+		"2022-03-04 05:06:07-05":           time.Date(2022, 3, 4, 10, 6, 7, 0, time.UTC),
+		"2022-03-04 05:06:07.5+05:30":      time.Date(2022, 3, 3, 23, 36, 7, 500000000, time.UTC),
+		"1900-01-01 00:00:00.25+00:19:32":  time.Date(1899, 12, 31, 23, 40, 28, 250000000, time.UTC),
+		"2022-03-04T05:06:07.123456+00:00": {},
+	} {
+		var actual, err = parseWal2JSONTime(str)
+		if expect.IsZero() {
+			require.Error(t, err, str)
+		} else {
+			require.NoError(t, err, str)
+			require.True(t, expect.Equal(actual), "%s: expected %s, got %s", str, expect, actual)
+		}
+	}
+}
+
+// TestWal2JSONChanges verifies that changes sent by `wal2json` are captured as the same
+// events as the equivalent changes sent by `pgoutput`.
+func TestWal2JSONChanges(t *testing.T) {
+	var ctx = context.Background()
+	var rel = testRelation("id", "data", "notes")
+	var commitTime = time.Date(2022, 3, 4, 5, 6, 7, 123456000, time.UTC)
+	var newStream = func() *replicationStream {
+		var s = &replicationStream{
+			connInfo:      pgtype.NewConnInfo(),
+			relations:     map[uint32]*pglogrepl.RelationMessage{rel.RelationID: rel},
+			renames:       newColumnRenames(),
+			updateColumns: updateColumnsAvailable,
+		}
+		s.tables.active = map[string]struct{}{"public.things": {}}
+		return s
+	}
+	var tuple = func(values ...interface{}) *pglogrepl.TupleData {
+		var tuple = &pglogrepl.TupleData{ColumnNum: uint16(len(values))}
+		for _, value := range values {
+			switch value := value.(type) {
+			case nil:
+				tuple.Columns = append(tuple.Columns, &pglogrepl.TupleDataColumn{DataType: 'n'})
+			case byte:
+				tuple.Columns = append(tuple.Columns, &pglogrepl.TupleDataColumn{DataType: value})
+			case string:
+				tuple.Columns = append(tuple.Columns, &pglogrepl.TupleDataColumn{DataType: 't', Length: uint32(len(value)), Data: []byte(value)})
+			}
+		}
+		return tuple
+	}
+
+	// The events of changes sent by `pgoutput`, as they're decoded.
+	var s = newStream()
+	var expect []*sqlcapture.ChangeEvent
+	for _, change := range []struct {
+		lsn pglogrepl.LSN
+		msg pglogrepl.Message
+	}{
+		{0x1000, &pglogrepl.BeginMessage{FinalLSN: 0x2000, CommitTime: commitTime}},
+		{0x1010, &pglogrepl.InsertMessage{RelationID: 1, Tuple: tuple("1", "one", nil)}},
+		{0x1020, &pglogrepl.InsertMessage{RelationID: 1, Tuple: tuple("2", "two", "second")}},
+		{0x1030, &pglogrepl.UpdateMessage{RelationID: 1, NewTuple: tuple("1", "uno", nil)}},
+		{0x1040, &pglogrepl.UpdateMessage{RelationID: 1, NewTuple: tuple("2", "dos", byte('u'))}},
+		{0x1050, &pglogrepl.UpdateMessage{RelationID: 1, OldTupleType: 'K', OldTuple: tuple("2", nil, nil), NewTuple: tuple("3", "dos", byte('u'))}},
+		{0x1060, &pglogrepl.DeleteMessage{RelationID: 1, OldTupleType: 'K', OldTuple: tuple("1", nil, nil)}},
+		{0x2000, &pglogrepl.CommitMessage{CommitLSN: 0x2000, TransactionEndLSN: 0x2000, CommitTime: commitTime}},
+	} {
+		var event, err = s.decodeMessage(ctx, change.lsn, change.msg)
+		require.NoError(t, err)
+		if event != nil {
+			expect = append(expect, event)
+		}
+	}
+	require.Len(t, expect, 7)
+
+	// The same changes sent by `wal2json`, as they're received.
+	s = newStream()
+	s.wal2json.relations = map[string]*pglogrepl.RelationMessage{`"public"."things"`: rel}
+	var actual []*sqlcapture.ChangeEvent
+	for _, change := range []struct {
+		lsn  pglogrepl.LSN
+		data string
+	}{
+		{0x1000, `{"action":"B","timestamp":"2022-03-04 05:06:07.123456+00"}`},
+		{0x1010, `{"action":"I","schema":"public","table":"things","columns":[{"name":"id","type":"text","typeoid":25,"value":"1"},{"name":"data","type":"text","typeoid":25,"value":"one"},{"name":"notes","type":"text","typeoid":25,"value":null}]}`},
+		{0x1020, `{"action":"I","schema":"public","table":"things","columns":[{"name":"id","type":"text","typeoid":25,"value":"2"},{"name":"data","type":"text","typeoid":25,"value":"two"},{"name":"notes","type":"text","typeoid":25,"value":"second"}]}`},
+		{0x1030, `{"action":"U","schema":"public","table":"things","columns":[{"name":"id","type":"text","typeoid":25,"value":"1"},{"name":"data","type":"text","typeoid":25,"value":"uno"},{"name":"notes","type":"text","typeoid":25,"value":null}],"identity":[{"name":"id","type":"text","typeoid":25,"value":"1"}]}`},
+		{0x1040, `{"action":"U","schema":"public","table":"things","columns":[{"name":"id","type":"text","typeoid":25,"value":"2"},{"name":"data","type":"text","typeoid":25,"value":"dos"}],"identity":[{"name":"id","type":"text","typeoid":25,"value":"2"}]}`},
+		{0x1050, `{"action":"U","schema":"public","table":"things","columns":[{"name":"id","type":"text","typeoid":25,"value":"3"},{"name":"data","type":"text","typeoid":25,"value":"dos"}],"identity":[{"name":"id","type":"text","typeoid":25,"value":"2"}]}`},
+		{0x1058, `{"action":"M","transactional":true,"prefix":"test","content":"ignored"}`},
+		{0x1060, `{"action":"D","schema":"public","table":"things","identity":[{"name":"id","type":"text","typeoid":25,"value":"1"}]}`},
+		{0x2000, `{"action":"C"}`},
+	} {
+		var msg, err = parseWal2JSON([]byte(change.data))
+		require.NoError(t, err)
+		next, err := s.handleStreamMessage(change.lsn, msg)
+		require.NoError(t, err)
+		require.Nil(t, next)
+		for len(s.replay) > 0 {
+			var next = s.replay[0]
+			s.replay = s.replay[1:]
+			event, err := s.decodeMessage(ctx, next.lsn, next.msg)
+			require.NoError(t, err)
+			if event != nil {
+				actual = append(actual, event)
+			}
+		}
+		if change.data != `{"action":"C"}` {
+			require.Empty(t, actual, "nothing is captured until the transaction commits")
+		}
+	}
+	require.Equal(t, expect, actual)
+	require.Equal(t, pglogrepl.LSN(0x2000), s.lastTxnEndLSN)
+
+	// Transactions may not be nested, and changes must be part of one.
+	var _, err = s.handleStreamMessage(0x3000, &wal2jsonMessage{Action: "I"})
+	require.Error(t, err)
+	_, err = s.handleStreamMessage(0x3000, &wal2jsonMessage{Action: "B"})
+	require.NoError(t, err)
+	_, err = s.handleStreamMessage(0x3010, &wal2jsonMessage{Action: "B"})
+	require.Error(t, err)
+}
+
+func TestWal2JSONValues(t *testing.T) {
+	for value, expect := range map[string]*pglogrepl.TupleDataColumn{
+		`null`:             {DataType: 'n'},
+		`"a \"quoted\" é"`: {DataType: 't', Length: 13, Data: []byte(`a "quoted" é`)},
+		`true`:             {DataType: 't', Length: 1, Data: []byte("t")},
+		`false`:            {DataType: 't', Length: 1, Data: []byte("f")},
+		`-12.50`:           {DataType: 't', Length: 6, Data: []byte("-12.50")},
+		`1e+30`:            {DataType: 't', Length: 5, Data: []byte("1e+30")},
+	} {
+		var actual, err = wal2jsonValue([]byte(value))
+		require.NoError(t, err, value)
+		require.Equal(t, expect, actual, value)
+	}
+}
+
+func TestWal2JSONRelation(t *testing.T) {
+	var tb, ctx = TestBackend, context.Background()
+	var tableName = tb.CreateTable(ctx, t, "", "(a INTEGER, b TEXT, c BOOLEAN, PRIMARY KEY (b, a))")
+	tb.Query(ctx, t, fmt.Sprintf(`ALTER TABLE %s DROP COLUMN c;`, tableName))
+	tb.Query(ctx, t, fmt.Sprintf(`ALTER TABLE %s ADD COLUMN d JSONB;`, tableName))
+	var cfg = tb.cfg
+	var s = &replicationStream{
+		connInfo:      pgtype.NewConnInfo(),
+		relations:     make(map[uint32]*pglogrepl.RelationMessage),
+		renames:       newColumnRenames(),
+		updateColumns: updateColumnsAvailable,
+		queryConfig:   &cfg,
+	}
+	s.tables.active = map[string]struct{}{"public." + tableName: {}}
+	defer s.closeQueryConn(ctx)
+
+	var rel, err = s.queryWal2JSONRelation(ctx, "public", tableName)
+	require.NoError(t, err)
+	require.NotNil(t, rel)
+	require.Equal(t, "public", rel.Namespace)
+	require.Equal(t, tableName, rel.RelationName)
+	require.Equal(t, uint16(3), rel.ColumnNum)
+	var columns []string
+	for _, col := range rel.Columns {
+		columns = append(columns, fmt.Sprintf("%s:%d:%d", col.Name, col.DataType, col.Flags))
+	}
+	require.Equal(t, []string{"a:23:1", "b:25:1", "d:3802:0"}, columns)
+
+	// A change of a table whose relation isn't known is decoded once its relation has
+	// been queried and handled.
+	s.nextTxnFinalLSN = 0x2000
+	var msg = &wal2jsonMessage{Action: "I", Schema: "public", Table: tableName, Columns: []wal2jsonColumn{
+		{Name: "a", TypeOID: 23, Value: []byte(`1`)},
+		{Name: "b", TypeOID: 25, Value: []byte(`"one"`)},
+		{Name: "d", TypeOID: 3802, Value: []byte(`"{\"x\": 1}"`)},
+	}}
+	event, err := s.decodeMessage(ctx, 0x1000, msg)
+	require.NoError(t, err)
+	require.Nil(t, event)
+	require.Len(t, s.replay, 1)
+	require.Equal(t, rel, s.relations[rel.RelationID])
+	event, err = s.decodeMessage(ctx, s.replay[0].lsn, s.replay[0].msg)
+	require.NoError(t, err)
+	require.Equal(t, sqlcapture.InsertOp, event.Operation)
+	require.Equal(t, int32(1), event.After["a"])
+	require.Equal(t, "one", event.After["b"])
+
+	// Changes of tables which don't exist are an error if they're captured.
+	_, err = s.decodeMessage(ctx, 0x1010, &wal2jsonMessage{Action: "I", Schema: "public", Table: tableName + "_missing"})
+	require.NoError(t, err)
+	s.tables.active["public."+tableName+"_missing"] = struct{}{}
+	_, err = s.decodeMessage(ctx, 0x1010, &wal2jsonMessage{Action: "I", Schema: "public", Table: tableName + "_missing"})
+	require.Error(t, err)
+}
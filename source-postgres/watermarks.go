@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+
+	"github.com/estuary/connectors/sqlcapture"
+	"github.com/jackc/pglogrepl"
+)
+
+// Methods by which watermarks are written. The 'table' method upserts them into the
+// watermarks table, which requires privileges to create and write to it. The 'message'
+// method instead emits them as logical decoding messages, which doesn't require any table
+// or privileges on one. `pgoutput` only sends these on PostgreSQL 14 and later.
+const (
+	watermarkMethodTable   = "table"
+	watermarkMethodMessage = "message"
+)
+
+// watermarkMessagePrefix is the prefix of the logical decoding messages by which
+// watermarks are written using the 'message' method. The content of each message
+// is the watermark.
+const watermarkMessagePrefix = "flow_watermark"
+
+// messageTypeLogical is the type of the logical decoding messages which are emitted
+// by `pg_logical_emit_message()` and sent by `pgoutput` when its "messages" option is
+// enabled. These aren't supported by pglogrepl, so they're parsed here.
+const messageTypeLogical pglogrepl.MessageType = 'M'
+
+// logicalDecodingMessage is a message emitted by `pg_logical_emit_message()`. A
+// transactional message is sent as part of the transaction which emitted it, and
+// others are sent on their own as soon as they're emitted.
+type logicalDecodingMessage struct {
+	Transactional bool
+	LSN           pglogrepl.LSN
+	Prefix        string
+	Content       []byte
+}
+
+func (*logicalDecodingMessage) Type() pglogrepl.MessageType { return messageTypeLogical }
+
+// parseLogicalDecodingMessage parses the body of a logical decoding message, which is
+// a byte of flags, the LSN of the message, its null-terminated prefix, and the length
+// of its content followed by the content itself.
+func parseLogicalDecodingMessage(body []byte) (*logicalDecodingMessage, error) {
+	if len(body) < 9 {
+		return nil, fmt.Errorf("LogicalDecodingMessage must have at least 9 bytes, got %d", len(body))
+	}
+	var msg = &logicalDecodingMessage{
+		Transactional: body[0] == 1,
+		LSN:           pglogrepl.LSN(binary.BigEndian.Uint64(body[1:])),
+	}
+	var rest = body[9:]
+	var end = bytes.IndexByte(rest, 0)
+	if end < 0 {
+		return nil, fmt.Errorf("LogicalDecodingMessage prefix isn't null-terminated")
+	}
+	msg.Prefix, rest = string(rest[:end]), rest[end+1:]
+	if len(rest) < 4 {
+		return nil, fmt.Errorf("LogicalDecodingMessage content length must have 4 bytes, got %d", len(rest))
+	}
+	var length = binary.BigEndian.Uint32(rest)
+	if rest = rest[4:]; uint32(len(rest)) != length {
+		return nil, fmt.Errorf("LogicalDecodingMessage content must have %d bytes, got %d", length, len(rest))
+	}
+	msg.Content = rest
+	return msg, nil
+}
+
+// decodeLogicalMessage decodes a logical decoding message into a watermark event if it
+// holds a watermark. Other messages are ignored.
+func (s *replicationStream) decodeLogicalMessage(lsn pglogrepl.LSN, msg *logicalDecodingMessage) (*sqlcapture.ChangeEvent, error) {
+	if msg.Prefix != watermarkMessagePrefix {
+		return nil, nil
+	}
+	return &sqlcapture.ChangeEvent{
+		Operation: sqlcapture.WatermarkOp,
+		Source: &postgresSource{
+			Location: [3]pglogrepl.LSN{s.lastTxnEndLSN, lsn, s.nextTxnFinalLSN},
+		},
+		After: map[string]interface{}{"watermark": string(msg.Content)},
+	}, nil
+}
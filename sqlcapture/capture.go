@@ -16,8 +16,9 @@ import (
 // PersistentState represents the part of a connector's state which can be serialized
 // and emitted in a state checkpoint, and resumed from after a restart.
 type PersistentState struct {
-	Cursor  string                `json:"cursor"`            // The replication cursor of the most recent 'Commit' event
-	Streams map[string]TableState `json:"streams,omitempty"` // A mapping from table IDs (<namespace>.<table>) to table-specific state.
+	Cursor   string                `json:"cursor"`             // The replication cursor of the most recent 'Commit' event
+	Position json.RawMessage       `json:"position,omitempty"` // A description of the cursor's position, for observability only.
+	Streams  map[string]TableState `json:"streams,omitempty"`  // A mapping from table IDs (<namespace>.<table>) to table-specific state.
 }
 
 // Validate performs basic sanity-checking after a state has been parsed from JSON. More
@@ -52,6 +53,15 @@ type TableState struct {
 	// values of the last row which has been backfilled. Replication events will
 	// only be emitted for rows <= this value while backfilling is in progress.
 	Scanned []byte `json:"scanned"`
+	// RefreshedAt is when the most recent full refresh of a "FullRefresh" table
+	// completed, which is used to schedule the next one.
+	RefreshedAt *time.Time `json:"refreshed_at,omitempty"`
+	// Digests are the digests of each row of a "PeriodicSnapshot" table as of its
+	// most recent snapshot, in key order.
+	Digests []RowDigest `json:"digests,omitempty"`
+	// Schema is the discovered schema of each column of the table as of when the
+	// capture last started, which is only tracked by a SchemaDriftDatabase.
+	Schema []ColumnSchema `json:"schema,omitempty"`
 	// Metadata is some arbitrary amount of database-specific metadata
 	// which needs to be tracked persistently on a per-table basis. The
 	// original purpose is/was for tracking table schema information.
@@ -66,11 +76,15 @@ type TableState struct {
 //   Pending: The table is new, and will start being backfilled soon.
 //   Backfill: The table's rows are being backfilled and replication events will only be emitted for the already-backfilled portion.
 //   Active: The table finished backfilling and replication events are emitted for the entire table.
+//   FullRefresh: The table's rows are periodically rescanned in their entirety and replication events are ignored.
+//   PeriodicSnapshot: The table's changes aren't replicated, so its rows are periodically rescanned and the differences from the previous scan are emitted.
 const (
-	TableModeIgnore   = "Ignore"
-	TableModePending  = "Pending"
-	TableModeBackfill = "Backfill"
-	TableModeActive   = "Active"
+	TableModeIgnore           = "Ignore"
+	TableModePending          = "Pending"
+	TableModeBackfill         = "Backfill"
+	TableModeActive           = "Active"
+	TableModeFullRefresh      = "FullRefresh"
+	TableModePeriodicSnapshot = "PeriodicSnapshot"
 )
 
 // MessageOutput represents "the thing to which Capture writes records and state checkpoints".
@@ -88,14 +102,20 @@ type Capture struct {
 	State    *PersistentState           // State read from `state.json` and emitted as updates
 	Encoder  MessageOutput              // The encoder to which records and state updates are written
 	Database Database                   // The database-specific interface which is operated by the generic Capture logic
+	Columns  []ColumnSelection          // The column selections of the catalog's streams, in the same order, if any
 
-	discovery map[string]TableInfo // Cached result of the most recent table discovery request
+	discovery      map[string]TableInfo       // Cached result of the most recent table discovery request
+	backfilledRows map[string]int             // The number of rows backfilled for each stream since the capture started
+	selected       map[string]TableInfo       // The tables of streams which only capture some of their columns, having only those columns
+	omitted        map[string]map[string]bool // The columns of each stream which aren't captured according to its column selection
 }
 
 const (
-	nonexistentWatermark   = "nonexistent-watermark" // The watermark which will be used for the final "tailing" stream call.
-	streamIdleWarning      = 60 * time.Second        // After `streamIdleWarning` has elapsed since the last replication event, we log a warning.
-	streamProgressInterval = 60 * time.Second        // After `streamProgressInterval` the replication streaming code may log a progress report.
+	nonexistentWatermark       = "nonexistent-watermark" // The watermark which will be used for the final "tailing" stream call.
+	heartbeatPrefix            = "heartbeat-"            // The prefix of heartbeats written to the watermarks table, followed by the time of the write.
+	streamIdleWarning          = 60 * time.Second        // After `streamIdleWarning` has elapsed since the last replication event, we log a warning.
+	streamProgressInterval     = 60 * time.Second        // After `streamProgressInterval` the replication streaming code may log a progress report.
+	defaultFullRefreshInterval = 24 * time.Hour          // How often "FullRefresh" streams are rescanned, unless the Database is a FullRefreshDatabase.
 )
 
 // Run is the top level entry point of the capture process.
@@ -114,6 +134,22 @@ func (c *Capture) Run(ctx context.Context) (err error) {
 		return fmt.Errorf("error updating capture state: %w", err)
 	}
 
+	// When replication of a new capture begins from a configured position, any streams
+	// which skip their backfills are activated from its very first event. Otherwise they'd
+	// only be activated after the initial catch-up stream, discarding the changes between
+	// that position and the present which the position was configured to capture.
+	if db, ok := c.Database.(StartPositionDatabase); ok && c.State.Cursor == "" && db.StartPositionConfigured() {
+		for _, streamID := range c.State.StreamsInState(TableModePending) {
+			if !c.Database.ShouldBackfill(streamID) {
+				logrus.WithField("stream", streamID).Info("capturing stream from the configured start position")
+				var state = c.State.Streams[streamID]
+				state.Mode = TableModeActive
+				state.dirty = true
+				c.State.Streams[streamID] = state
+			}
+		}
+	}
+
 	var captureTables = make(map[string]struct{})
 	var tableMetadata = make(map[string]json.RawMessage)
 	for streamID, state := range c.State.Streams {
@@ -133,17 +169,38 @@ func (c *Capture) Run(ctx context.Context) (err error) {
 			err = streamErr
 		}
 	}()
+	if db, ok := c.Database.(ReplicationStartDatabase); ok && c.State.Cursor == "" {
+		if cursor, ok := db.ReplicationStart(); ok {
+			logrus.WithField("cursor", cursor).Info("initialized cursor to the start of replication")
+			c.State.Cursor = cursor
+		}
+	}
+
+	// If the database took a snapshot exactly where replication begins, then streams
+	// are backfilled from it in their entirety before any replication events are
+	// processed. The snapshot cursor is checkpointed along with the backfill progress,
+	// so a backfill which is interrupted resumes using watermarks from that point.
+	var snapshotDB, useSnapshot = c.Database.(SnapshotDatabase)
+	if useSnapshot {
+		var cursor string
+		if cursor, useSnapshot = snapshotDB.ExportedSnapshot(); useSnapshot {
+			logrus.WithField("cursor", cursor).Info("backfilling from exported snapshot")
+			c.State.Cursor = cursor
+		}
+	}
 
-	// Perform an initial "catch-up" stream-to-watermark before transitioning
+	// Otherwise perform an initial "catch-up" stream-to-watermark before transitioning
 	// any "Pending" streams into the "Backfill" state. This helps ensure that
 	// a given stream only ever observes replication events which occur *after*
 	// the connector was started.
-	var watermark = uuid.New().String()
-	if err := c.Database.WriteWatermark(ctx, watermark); err != nil {
-		return fmt.Errorf("error writing next watermark: %w", err)
-	}
-	if err := c.streamToWatermark(replStream, watermark, nil); err != nil {
-		return fmt.Errorf("error streaming until watermark: %w", err)
+	if !useSnapshot {
+		var watermark = uuid.New().String()
+		if err := c.Database.WriteWatermark(ctx, watermark); err != nil {
+			return fmt.Errorf("error writing next watermark: %w", err)
+		}
+		if err := c.streamToWatermark(ctx, replStream, watermark, nil); err != nil {
+			return fmt.Errorf("error streaming until watermark: %w", err)
+		}
 	}
 	for _, streamID := range c.State.StreamsInState(TableModePending) {
 		logrus.WithField("stream", streamID).Info("activating replication for stream")
@@ -183,6 +240,12 @@ func (c *Capture) Run(ctx context.Context) (err error) {
 		}
 	}
 
+	if useSnapshot {
+		if err := c.backfillFromSnapshot(ctx, snapshotDB); err != nil {
+			return err
+		}
+	}
+
 	// Backfill any tables which require it
 	var results *resultSet
 	for c.State.StreamsInState(TableModeBackfill) != nil {
@@ -190,7 +253,7 @@ func (c *Capture) Run(ctx context.Context) (err error) {
 		if err := c.Database.WriteWatermark(ctx, watermark); err != nil {
 			return fmt.Errorf("error writing next watermark: %w", err)
 		}
-		if err := c.streamToWatermark(replStream, watermark, results); err != nil {
+		if err := c.streamToWatermark(ctx, replStream, watermark, results); err != nil {
 			return fmt.Errorf("error streaming until watermark: %w", err)
 		} else if err := c.emitBuffered(results); err != nil {
 			return fmt.Errorf("error emitting buffered results: %w", err)
@@ -203,16 +266,54 @@ func (c *Capture) Run(ctx context.Context) (err error) {
 	logrus.Debug("finished backfilling tables")
 
 	// Once there is no more backfilling to do, just stream changes forever and emit
-	// state updates on every transaction commit.
-	var targetWatermark = nonexistentWatermark
-	if !c.Catalog.Tail {
-		var watermark = uuid.New().String()
-		if err = c.Database.WriteWatermark(ctx, watermark); err != nil {
-			return fmt.Errorf("error writing poll watermark: %w", err)
+	// state updates on every transaction commit. Any "FullRefresh" streams are rescanned
+	// in between, whenever they're due.
+	for {
+		if err := c.refreshStreams(ctx); err != nil {
+			return fmt.Errorf("error refreshing streams: %w", err)
 		}
-		targetWatermark = watermark
+
+		var nextRefresh, refreshing = c.nextRefresh()
+		if !c.Catalog.Tail {
+			var watermark = uuid.New().String()
+			if err = c.Database.WriteWatermark(ctx, watermark); err != nil {
+				return fmt.Errorf("error writing poll watermark: %w", err)
+			}
+			return c.streamToWatermark(ctx, replStream, watermark, nil)
+		} else if !refreshing {
+			return c.streamUntilWatermark(ctx, replStream, nonexistentWatermark, nil, nil)
+		}
+
+		// Stream changes until the next refresh is due, at which point a watermark is
+		// written so that streaming ends at a transaction boundary.
+		logrus.WithField("nextRefresh", nextRefresh).Debug("streaming until next full refresh")
+		var timer = time.NewTimer(time.Until(nextRefresh))
+		var err = c.streamToTimedWatermark(ctx, replStream, uuid.New().String(), timer.C)
+		timer.Stop()
+		if err != nil {
+			return fmt.Errorf("error streaming until next full refresh: %w", err)
+		}
+	}
+}
+
+// backfillFromSnapshot backfills all streams in the "Backfill" state from the snapshot of a
+// SnapshotDatabase. No replication events are processed until it's done, since every one of
+// them occurs after the snapshot.
+func (c *Capture) backfillFromSnapshot(ctx context.Context, db SnapshotDatabase) error {
+	for c.State.StreamsInState(TableModeBackfill) != nil {
+		var results, err = c.backfillStreams(ctx, c.State.StreamsInState(TableModeBackfill))
+		if err != nil {
+			return fmt.Errorf("error performing backfill: %w", err)
+		} else if err := c.emitBuffered(results); err != nil {
+			return fmt.Errorf("error emitting buffered results: %w", err)
+		}
+	}
+	logrus.Debug("finished backfilling tables from snapshot")
+
+	if err := db.ReleaseSnapshot(ctx); err != nil {
+		return fmt.Errorf("error releasing snapshot: %w", err)
 	}
-	return c.streamToWatermark(replStream, targetWatermark, nil)
+	return nil
 }
 
 func (c *Capture) updateState(ctx context.Context) error {
@@ -238,7 +339,9 @@ func (c *Capture) updateState(ctx context.Context) error {
 	// Streams may be added to the catalog at various times. We need to
 	// initialize new state entries for these streams, and while we're at
 	// it this is a good time to sanity-check the primary key configuration.
-	for _, catalogStream := range c.Catalog.Streams {
+	c.selected = make(map[string]TableInfo)
+	c.omitted = make(map[string]map[string]bool)
+	for idx, catalogStream := range c.Catalog.Streams {
 		var streamID = JoinStreamID(catalogStream.Stream.Namespace, catalogStream.Stream.Name)
 
 		// In the catalog a primary key is an array of arrays of strings, but in the
@@ -272,19 +375,62 @@ func (c *Capture) updateState(ctx context.Context) error {
 			}
 			primaryKey = catalogPrimaryKey
 		}
+
+		// Streams with the "full_refresh" sync mode are captured by rescanning them periodically
+		// instead of by backfilling and replication, as are the streams of tables whose changes
+		// aren't replicated.
+		var mode = c.initialMode(streamID, catalogStream)
+
+		// Backfills page through a table in the order of its key, and replicated changes
+		// might only identify rows by some of their columns, so a key other than the primary
+		// key of the table must be checked by the database.
+		if info, ok := c.discovery[streamID]; ok && strings.Join(primaryKey, ",") != strings.Join(info.PrimaryKey, ",") {
+			if db, ok := c.Database.(ScanKeyDatabase); ok && len(primaryKey) != 0 {
+				if err := db.ValidateScanKey(ctx, info, primaryKey); err != nil {
+					return fmt.Errorf("stream %q: %w", streamID, err)
+				}
+			}
+			if db, ok := c.Database.(ReplicaIdentityDatabase); ok && mode == TableModePending {
+				if err := db.ValidateKey(ctx, info, primaryKey); err != nil {
+					return fmt.Errorf("stream %q: %w", streamID, err)
+				}
+			}
+		}
 		if len(primaryKey) == 0 {
 			return fmt.Errorf("stream %q: primary key unspecified in the catalog and no primary key found in database", streamID)
 		}
 
-		// See if the stream is already initialized. If it's not, then create it.
+		// See if the stream is already initialized. If it's not, then create it. Changing
+		// how a stream is captured starts it over in the new mode.
 		var streamState, ok = c.State.Streams[streamID]
 		if !ok || streamState.Mode == TableModeIgnore {
-			c.State.Streams[streamID] = TableState{Mode: TableModePending, KeyColumns: primaryKey, dirty: true}
-			continue
+			streamState = TableState{Mode: mode, KeyColumns: primaryKey, dirty: true}
+		} else if initialModeOf(streamState.Mode) != mode {
+			logrus.WithFields(logrus.Fields{
+				"stream": streamID,
+				"from":   streamState.Mode,
+				"to":     mode,
+			}).Info("sync mode of stream changed")
+			streamState = TableState{Mode: mode, KeyColumns: primaryKey, dirty: true}
+		} else if strings.Join(streamState.KeyColumns, ",") != strings.Join(primaryKey, ",") {
+			return fmt.Errorf("stream %q: primary key %q doesn't match initialized scan key %q", streamID, primaryKey, streamState.KeyColumns)
 		}
 
-		if strings.Join(streamState.KeyColumns, ",") != strings.Join(primaryKey, ",") {
-			return fmt.Errorf("stream %q: primary key %q doesn't match initialized scan key %q", streamID, primaryKey, streamState.KeyColumns)
+		if db, ok := c.Database.(SchemaDriftDatabase); ok && db.TrackSchemaDrift() {
+			if err := c.trackSchemaDrift(streamID, &streamState); err != nil {
+				return err
+			}
+		}
+		c.State.Streams[streamID] = streamState
+
+		// Only the selected columns of the table are scanned and captured. Backfills resume
+		// from the key columns alone, which are always selected, so changing the selection
+		// doesn't affect the stream's state.
+		if info, ok := c.discovery[streamID]; ok && idx < len(c.Columns) {
+			if selected, omitted := c.Columns[idx].selectColumns(streamID, info, primaryKey); len(omitted) > 0 {
+				c.selected[streamID] = selected
+				c.omitted[streamID] = omitted
+			}
 		}
 	}
 
@@ -306,12 +452,43 @@ func (c *Capture) updateState(ctx context.Context) error {
 		}
 	}
 
+	// The changes of a table are only replicated once it's been published, which may be
+	// managed by the database according to the streams of the catalog.
+	if db, ok := c.Database.(PublicationDatabase); ok {
+		var captured, removed []TableInfo
+		for streamID, streamState := range c.State.Streams {
+			var info, ok = c.discovery[streamID]
+			if !ok {
+				continue
+			}
+			switch {
+			case streamState.Mode == TableModeIgnore:
+				removed = append(removed, info)
+			case initialModeOf(streamState.Mode) == TableModePending:
+				captured = append(captured, info)
+			}
+		}
+		if err := db.UpdatePublication(ctx, captured, removed); err != nil {
+			return fmt.Errorf("error updating publication: %w", err)
+		}
+	}
+
 	// Emit the new state to stdout. This isn't strictly necessary but it helps to make
 	// the emitted sequence of state updates a lot more readable.
 	return c.emitState()
 }
 
-func (c *Capture) streamToWatermark(replStream ReplicationStream, watermark string, results *resultSet) error {
+func (c *Capture) streamToWatermark(ctx context.Context, replStream ReplicationStream, watermark string, results *resultSet) error {
+	return c.streamUntilWatermark(ctx, replStream, watermark, results, nil)
+}
+
+// streamToTimedWatermark streams change events like streamToWatermark, except that the
+// watermark is only written once the `writeAt` channel receives.
+func (c *Capture) streamToTimedWatermark(ctx context.Context, replStream ReplicationStream, watermark string, writeAt <-chan time.Time) error {
+	return c.streamUntilWatermark(ctx, replStream, watermark, nil, writeAt)
+}
+
+func (c *Capture) streamUntilWatermark(ctx context.Context, replStream ReplicationStream, watermark string, results *resultSet, writeAt <-chan time.Time) error {
 	logrus.WithField("watermark", watermark).Info("streaming to watermark")
 	var watermarksTable = c.Database.WatermarksTable()
 	var watermarkReached = false
@@ -342,8 +519,9 @@ func (c *Capture) streamToWatermark(replStream ReplicationStream, watermark stri
 	//     far), and will report progress every `streamProgressInterval`.
 	var eventCount int
 	var nextProgress = time.Now().Add(streamProgressInterval)
+	var expectWatermark = watermark != nonexistentWatermark && writeAt == nil
 	var idleTimeout = time.AfterFunc(streamIdleWarning, func() {
-		if watermark != nonexistentWatermark {
+		if expectWatermark {
 			logrus.WithField("timeout", streamIdleWarning.String()).Warn("replication stream idle")
 		} else {
 			logrus.WithField("timeout", streamIdleWarning.String()).Info("replication stream idle")
@@ -351,19 +529,67 @@ func (c *Capture) streamToWatermark(replStream ReplicationStream, watermark stri
 	})
 	defer idleTimeout.Stop()
 
-	for event := range replStream.Events() {
+	// Unless a watermark write is expected, a heartbeat is written to the watermarks table
+	// whenever the stream has been idle for the heartbeat interval of a HeartbeatDatabase.
+	// Its commit is checkpointed like any other, so that the cursor of the capture doesn't
+	// fall behind when none of the captured tables are changing.
+	var heartbeat <-chan time.Time
+	var heartbeatInterval time.Duration
+	var lastActivity = time.Now()
+	if db, ok := c.Database.(HeartbeatDatabase); ok && !expectWatermark && db.HeartbeatInterval() > 0 {
+		heartbeatInterval = db.HeartbeatInterval()
+		var ticker = time.NewTicker(heartbeatInterval)
+		defer ticker.Stop()
+		heartbeat = ticker.C
+	}
+
+	var events = replStream.Events()
+	for {
+		var event, ok = ChangeEvent{}, false
+		select {
+		case event, ok = <-events:
+		case <-heartbeat:
+			if time.Since(lastActivity) < heartbeatInterval {
+				continue
+			}
+			var value = heartbeatPrefix + time.Now().UTC().Format(time.RFC3339Nano)
+			logrus.WithField("heartbeat", value).Debug("replication stream idle: writing heartbeat")
+			if err := c.Database.WriteWatermark(ctx, value); err != nil {
+				return fmt.Errorf("error writing heartbeat: %w", err)
+			}
+			lastActivity = time.Now()
+			continue
+		case <-writeAt:
+			writeAt = nil
+			if err := c.Database.WriteWatermark(ctx, watermark); err != nil {
+				return fmt.Errorf("error writing watermark: %w", err)
+			}
+			continue
+		}
+		if !ok {
+			break
+		}
+
 		// Progress logging concerns
 		eventCount++
 		if time.Now().After(nextProgress) {
-			logrus.WithField("count", eventCount).Info("replication stream progress")
+			logrus.WithFields(logrus.Fields{
+				"count":    eventCount,
+				"cursor":   c.State.Cursor,
+				"position": string(c.State.Position),
+			}).Info("replication stream progress")
 			nextProgress = time.Now().Add(streamProgressInterval)
 		}
 		idleTimeout.Reset(streamIdleWarning)
+		lastActivity = time.Now()
 
 		// Flush events update the checkpointed LSN and trigger a state update.
 		// If this is the commit after the target watermark, it also ends the loop.
 		if event.Operation == FlushOp {
 			c.State.Cursor = event.Source.Cursor()
+			if source, ok := event.Source.(PositionSource); ok {
+				c.State.Position = source.Position()
+			}
 			if err := c.emitState(); err != nil {
 				return fmt.Errorf("error emitting state update: %w", err)
 			}
@@ -387,6 +613,20 @@ func (c *Capture) streamToWatermark(replStream ReplicationStream, watermark stri
 			continue
 		}
 
+		// Watermark events report watermarks which weren't written to the watermarks table.
+		// They're noted just like changes of the table, but are otherwise ignored.
+		if event.Operation == WatermarkOp {
+			var actual = event.After["watermark"]
+			logrus.WithFields(logrus.Fields{
+				"expected": watermark,
+				"actual":   actual,
+			}).Debug("watermark message")
+			if actual == watermark {
+				watermarkReached = true
+			}
+			continue
+		}
+
 		// Note when the expected watermark is finally observed. The subsequent Commit will exit the loop.
 		var sourceCommon = event.Source.Common()
 		var streamID = JoinStreamID(sourceCommon.Schema, sourceCommon.Table)
@@ -403,7 +643,7 @@ func (c *Capture) streamToWatermark(replStream ReplicationStream, watermark stri
 
 		// Handle the easy cases: Events on ignored or fully-active tables.
 		var tableState = c.State.Streams[streamID]
-		if tableState.Mode == "" || tableState.Mode == TableModeIgnore {
+		if tableState.Mode == "" || tableState.Mode == TableModeIgnore || tableState.Mode == TableModeFullRefresh || tableState.Mode == TableModePeriodicSnapshot {
 			logrus.WithFields(logrus.Fields{
 				"stream": streamID,
 				"op":     event.Operation,
@@ -439,17 +679,48 @@ func (c *Capture) streamToWatermark(replStream ReplicationStream, watermark stri
 }
 
 func (c *Capture) emitBuffered(results *resultSet) error {
+	if c.backfilledRows == nil {
+		c.backfilledRows = make(map[string]int)
+	}
+
+	var checkpointRows int
+	if db, ok := c.Database.(BackfillCheckpointDatabase); ok {
+		checkpointRows = db.BackfillCheckpointRows()
+	}
+
 	// Emit any buffered results and update table states accordingly.
+	var completed = make(map[string][]byte)
 	for _, streamID := range results.Streams() {
 		var events = results.Changes(streamID)
-		for _, event := range events {
+		for idx, event := range events {
+			// The buffered results reflect the table as of the watermark which replication
+			// has just reached, so a checkpoint of the key of any row emitted so far is as
+			// consistent as the one which follows the whole chunk: replication resumes from
+			// the watermark, and the backfill from the row after that key. The final chunk
+			// of a table only holds rows patched in by replication, so it isn't checkpointed
+			// until the backfill is complete.
+			var checkpoint = checkpointRows > 0 && !results.Complete(streamID) && (idx+1)%checkpointRows == 0 && idx+1 < len(events)
+			var scanned []byte
+			if checkpoint {
+				var err error
+				if scanned, err = encodeRowKey(c.State.Streams[streamID].KeyColumns, event.After, c.Database); err != nil {
+					return fmt.Errorf("error encoding row key for %q: %w", streamID, err)
+				}
+			}
 			if err := c.handleChangeEvent(streamID, event); err != nil {
 				return fmt.Errorf("error handling backfill change: %w", err)
 			}
+			c.backfilledRows[streamID]++
+			if checkpoint {
+				if err := c.checkpointBackfill(streamID, scanned); err != nil {
+					return err
+				}
+			}
 		}
 
 		var state = c.State.Streams[streamID]
 		if results.Complete(streamID) {
+			completed[streamID] = state.Scanned
 			state.Mode = TableModeActive
 			state.Scanned = nil
 		} else {
@@ -464,9 +735,54 @@ func (c *Capture) emitBuffered(results *resultSet) error {
 	// Emit a new state update. The global `CurrentLSN` has been advanced by the
 	// watermark commit event, and the individual stream `Scanned` tracking for
 	// each stream has been advanced just above.
+	if err := c.emitState(); err != nil {
+		return err
+	}
+
+	// Only once the state update which activates them has been emitted are the
+	// completed backfills announced, since a stream is never backfilled again
+	// after that.
+	for _, streamID := range results.Streams() {
+		if scanned, ok := completed[streamID]; ok {
+			c.backfillComplete(streamID, scanned)
+		}
+	}
+	return nil
+}
+
+// checkpointBackfill emits a state update which records that a stream has been backfilled
+// up to and including the row with the given key.
+func (c *Capture) checkpointBackfill(streamID string, scanned []byte) error {
+	logrus.WithField("stream", streamID).Trace("checkpointing backfill chunk")
+	var state = c.State.Streams[streamID]
+	state.Scanned = scanned
+	state.dirty = true
+	c.State.Streams[streamID] = state
 	return c.emitState()
 }
 
+// backfillComplete logs a structured marker for a stream whose backfill has just
+// completed, which orchestration may watch for to learn that the stream's records
+// now come solely from replication. It's logged exactly once per stream, as part of
+// the transition from "Backfill" to "Active" mode. The final scanned key is that of
+// the last backfilled row, and the row count only includes the rows backfilled
+// since the capture last started.
+func (c *Capture) backfillComplete(streamID string, scanned []byte) {
+	var fields = logrus.Fields{
+		"event":  "backfill_complete",
+		"stream": streamID,
+		"rows":   c.backfilledRows[streamID],
+	}
+	if scanned != nil {
+		if key, err := unpackTuple(scanned, c.Database); err != nil {
+			logrus.WithFields(logrus.Fields{"stream": streamID, "err": err}).Warn("error unpacking final scanned key")
+		} else {
+			fields["scannedKey"] = key
+		}
+	}
+	logrus.WithFields(fields).Info("backfill complete")
+}
+
 func (c *Capture) backfillStreams(ctx context.Context, streams []string) (*resultSet, error) {
 	logrus.WithField("streams", streams).Info("backfilling streams")
 	var results = newResultSet()
@@ -481,19 +797,12 @@ func (c *Capture) backfillStreams(ctx context.Context, streams []string) (*resul
 		var streamState = c.State.Streams[streamID]
 
 		// Fetch a chunk of entries from the specified stream
-		var err error
-		var resumeKey []interface{}
-		if streamState.Scanned != nil {
-			resumeKey, err = unpackTuple(streamState.Scanned, c.Database)
-			if err != nil {
-				return nil, fmt.Errorf("error unpacking resume key for %q: %w", streamID, err)
-			}
-			if len(resumeKey) != len(streamState.KeyColumns) {
-				return nil, fmt.Errorf("expected %d resume-key values but got %d", len(streamState.KeyColumns), len(resumeKey))
-			}
+		var resumeKey, err = c.resumeKey(streamID, streamState)
+		if err != nil {
+			return nil, err
 		}
 
-		discoveryInfo, ok := c.discovery[streamID]
+		discoveryInfo, ok := c.scannedTable(streamID)
 		if !ok {
 			return nil, fmt.Errorf("unknown table %q", streamID)
 		}
@@ -510,6 +819,166 @@ func (c *Capture) backfillStreams(ctx context.Context, streams []string) (*resul
 	return results, nil
 }
 
+// resumeKey returns the key after which a scan of the stream should resume, or nil
+// if it should start from the beginning of the table.
+func (c *Capture) resumeKey(streamID string, streamState TableState) ([]interface{}, error) {
+	if streamState.Scanned == nil {
+		return nil, nil
+	}
+	var resumeKey, err = unpackTuple(streamState.Scanned, c.Database)
+	if err != nil {
+		return nil, fmt.Errorf("error unpacking resume key for %q: %w", streamID, err)
+	}
+	if len(resumeKey) != len(streamState.KeyColumns) {
+		return nil, fmt.Errorf("expected %d resume-key values but got %d", len(streamState.KeyColumns), len(resumeKey))
+	}
+	return resumeKey, nil
+}
+
+// initialMode returns the mode in which a newly added stream starts out, which depends
+// on how it's captured.
+func (c *Capture) initialMode(streamID string, stream airbyte.ConfiguredStream) string {
+	if stream.SyncMode == airbyte.SyncModeFullRefresh {
+		return TableModeFullRefresh
+	}
+	if db, ok := c.Database.(PeriodicSnapshotDatabase); ok {
+		if info, ok := c.discovery[streamID]; ok && db.PeriodicSnapshot(info) {
+			return TableModePeriodicSnapshot
+		}
+	}
+	return TableModePending
+}
+
+// initialModeOf returns the mode in which a stream in the given mode started out.
+func initialModeOf(mode string) string {
+	switch mode {
+	case TableModeBackfill, TableModeActive:
+		return TableModePending
+	}
+	return mode
+}
+
+// fullRefreshInterval returns how often "FullRefresh" and "PeriodicSnapshot" streams
+// are rescanned.
+func (c *Capture) fullRefreshInterval() time.Duration {
+	if db, ok := c.Database.(FullRefreshDatabase); ok && db.FullRefreshInterval() > 0 {
+		return db.FullRefreshInterval()
+	}
+	return defaultFullRefreshInterval
+}
+
+// refreshedStreams returns the streams which are captured by periodically rescanning
+// their tables.
+func (c *Capture) refreshedStreams() []string {
+	return append(c.State.StreamsInState(TableModeFullRefresh), c.State.StreamsInState(TableModePeriodicSnapshot)...)
+}
+
+// nextRefresh returns when the next "FullRefresh" or "PeriodicSnapshot" stream is due
+// to be rescanned, or false if there are no such streams.
+func (c *Capture) nextRefresh() (time.Time, bool) {
+	var next time.Time
+	var ok bool
+	for _, streamID := range c.refreshedStreams() {
+		var state = c.State.Streams[streamID]
+		var due = time.Now()
+		if state.Scanned == nil && state.RefreshedAt != nil {
+			due = state.RefreshedAt.Add(c.fullRefreshInterval())
+		}
+		if !ok || due.Before(next) {
+			next, ok = due, true
+		}
+	}
+	return next, ok
+}
+
+// refreshStreams rescans each "FullRefresh" or "PeriodicSnapshot" stream which is due,
+// including any whose previous refresh was interrupted.
+func (c *Capture) refreshStreams(ctx context.Context) error {
+	var now = time.Now()
+	for _, streamID := range c.refreshedStreams() {
+		var state = c.State.Streams[streamID]
+		if state.Scanned == nil && state.RefreshedAt != nil && now.Before(state.RefreshedAt.Add(c.fullRefreshInterval())) {
+			continue
+		}
+		if err := c.refreshStream(ctx, streamID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// refreshStream scans the entire table of a "FullRefresh" stream, emitting each row
+// as an insert. There are no replication events to reconcile the rows with, so they're
+// emitted as they're scanned, and the progress of the scan is checkpointed after each
+// chunk so that an interrupted refresh resumes where it left off. The table of a
+// "PeriodicSnapshot" stream is scanned the same way, except that only the differences
+// from its previous snapshot are emitted.
+func (c *Capture) refreshStream(ctx context.Context, streamID string) error {
+	logrus.WithField("stream", streamID).Info("refreshing stream")
+	var discoveryInfo, ok = c.scannedTable(streamID)
+	if !ok {
+		return fmt.Errorf("unknown table %q", streamID)
+	}
+
+	var rows, changes int
+	for {
+		var state = c.State.Streams[streamID]
+		var resumeKey, err = c.resumeKey(streamID, state)
+		if err != nil {
+			return err
+		}
+		events, err := c.Database.ScanTableChunk(ctx, discoveryInfo, state.KeyColumns, resumeKey)
+		if err != nil {
+			return fmt.Errorf("error scanning table %q: %w", streamID, err)
+		}
+
+		if state.Mode == TableModePeriodicSnapshot {
+			n, err := c.diffSnapshotChunk(streamID, &state, events)
+			if err != nil {
+				return err
+			}
+			changes += n
+		} else {
+			for _, event := range events {
+				if err := c.handleChangeEvent(streamID, event); err != nil {
+					return fmt.Errorf("error handling refreshed row for %q: %w", streamID, err)
+				}
+			}
+			changes += len(events)
+		}
+
+		if len(events) == 0 {
+			var refreshedAt = time.Now().UTC()
+			state.Scanned = nil
+			state.RefreshedAt = &refreshedAt
+		} else {
+			rows += len(events)
+			if state.Scanned, err = encodeRowKey(state.KeyColumns, events[len(events)-1].After, c.Database); err != nil {
+				return fmt.Errorf("error encoding row key for %q: %w", streamID, err)
+			}
+		}
+		state.dirty = true
+		c.State.Streams[streamID] = state
+		if err := c.emitState(); err != nil {
+			return err
+		}
+		if state.Scanned == nil {
+			logrus.WithFields(logrus.Fields{"stream": streamID, "rows": rows, "changes": changes}).Info("refreshed stream")
+			return nil
+		}
+	}
+}
+
+// scannedTable returns the discovered table of a stream, having only the columns which are
+// captured if its column selection omits any.
+func (c *Capture) scannedTable(streamID string) (TableInfo, bool) {
+	if info, ok := c.selected[streamID]; ok {
+		return info, true
+	}
+	var info, ok = c.discovery[streamID]
+	return info, ok
+}
+
 func (c *Capture) handleChangeEvent(streamID string, event ChangeEvent) error {
 	var out map[string]interface{}
 
@@ -523,6 +992,11 @@ func (c *Capture) handleChangeEvent(streamID string, event ChangeEvent) error {
 		Before:    nil,
 	}
 
+	if omitted, ok := c.omitted[streamID]; ok {
+		omitColumns(event.Before, omitted)
+		omitColumns(event.After, omitted)
+	}
+
 	switch event.Operation {
 	case InsertOp:
 		out = event.After // Before is never used.
@@ -554,8 +1028,9 @@ func (c *Capture) emitState() error {
 	// since the last state output. At the same time, clear the dirty flags on all
 	// those tables.
 	var stateUpdate = PersistentState{
-		Cursor:  c.State.Cursor,
-		Streams: make(map[string]TableState),
+		Cursor:   c.State.Cursor,
+		Position: c.State.Position,
+		Streams:  make(map[string]TableState),
 	}
 	for streamID, state := range c.State.Streams {
 		if state.dirty {
@@ -0,0 +1,99 @@
+package sqlcapture
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/estuary/flow/go/protocols/airbyte"
+	"github.com/sirupsen/logrus"
+)
+
+// ColumnSelection selects the columns of a table which are captured by its stream, as
+// configured by the `includeColumns` or `excludeColumns` properties of the stream in the
+// catalog. The key columns of the stream are always captured.
+type ColumnSelection struct {
+	Include []string `json:"includeColumns,omitempty"`
+	Exclude []string `json:"excludeColumns,omitempty"`
+}
+
+// Validate checks that at most one of the properties is set.
+func (s ColumnSelection) Validate() error {
+	if len(s.Include) != 0 && len(s.Exclude) != 0 {
+		return fmt.Errorf("'includeColumns' and 'excludeColumns' can't both be specified")
+	}
+	return nil
+}
+
+// ParseColumnSelections parses the column selection of each stream of a configured catalog,
+// in the same order as its streams. They're parsed separately from the catalog, since they
+// aren't properties of an airbyte.ConfiguredStream.
+func ParseColumnSelections(catalogFile airbyte.JSONFile) ([]ColumnSelection, error) {
+	var bs, err = ioutil.ReadFile(string(catalogFile))
+	if err != nil {
+		return nil, err
+	}
+	var catalog struct {
+		Streams []ColumnSelection `json:"streams"`
+	}
+	if err := json.Unmarshal(bs, &catalog); err != nil {
+		return nil, err
+	}
+	for idx, selection := range catalog.Streams {
+		if err := selection.Validate(); err != nil {
+			return nil, fmt.Errorf("stream %d: %w", idx, err)
+		}
+	}
+	return catalog.Streams, nil
+}
+
+// selectColumns returns the table having only the columns which the selection captures,
+// along with the names of the columns which it doesn't. Selected columns which the table
+// doesn't have are ignored, so that a column which is dropped and later added back is
+// captured again once it's been rediscovered.
+func (s ColumnSelection) selectColumns(streamID string, info TableInfo, keyColumns []string) (TableInfo, map[string]bool) {
+	if len(s.Include) == 0 && len(s.Exclude) == 0 {
+		return info, nil
+	}
+
+	var captured = make(map[string]bool)
+	for _, col := range keyColumns {
+		captured[col] = true
+	}
+	var excluded = make(map[string]bool)
+	for _, col := range s.Exclude {
+		excluded[col] = true
+	}
+	for _, col := range s.Include {
+		if _, ok := info.Columns[col]; !ok {
+			logrus.WithFields(logrus.Fields{
+				"stream": streamID,
+				"column": col,
+			}).Warn("included column doesn't exist in table")
+		}
+		captured[col] = true
+	}
+
+	var selected = info
+	selected.Columns = make(map[string]ColumnInfo)
+	selected.ColumnNames = nil
+	selected.Projected = true
+	var omitted = make(map[string]bool)
+	for _, name := range info.ColumnNames {
+		var keep = captured[name] || (len(s.Include) == 0 && !excluded[name])
+		if keep {
+			selected.Columns[name] = info.Columns[name]
+			selected.ColumnNames = append(selected.ColumnNames, name)
+		} else {
+			omitted[name] = true
+		}
+	}
+	return selected, omitted
+}
+
+// omitColumns removes the fields of a row whose columns aren't captured.
+func omitColumns(fields map[string]interface{}, omitted map[string]bool) {
+	for name := range omitted {
+		delete(fields, name)
+	}
+}
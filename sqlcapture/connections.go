@@ -0,0 +1,48 @@
+package sqlcapture
+
+import (
+	"sync"
+)
+
+// ConnectionObserver is notified whenever a connector opens or closes a database
+// connection. It exists so that tests can detect leaked connections, and no observer
+// is installed in production.
+type ConnectionObserver interface {
+	ConnectionOpened(kind string)
+	ConnectionClosed(kind string)
+}
+
+var connectionObserver struct {
+	sync.Mutex
+	current ConnectionObserver
+}
+
+// SetConnectionObserver installs a new ConnectionObserver, or removes the current one
+// if nil, and returns the previously installed observer.
+func SetConnectionObserver(obs ConnectionObserver) ConnectionObserver {
+	connectionObserver.Lock()
+	defer connectionObserver.Unlock()
+	var prev = connectionObserver.current
+	connectionObserver.current = obs
+	return prev
+}
+
+// ConnectionOpened should be called by a connector after it opens a database connection
+// of the given kind (such as "database" or "replication").
+func ConnectionOpened(kind string) {
+	connectionObserver.Lock()
+	defer connectionObserver.Unlock()
+	if connectionObserver.current != nil {
+		connectionObserver.current.ConnectionOpened(kind)
+	}
+}
+
+// ConnectionClosed should be called by a connector after it closes a database connection
+// of the given kind, regardless of whether closing it succeeded.
+func ConnectionClosed(kind string) {
+	connectionObserver.Lock()
+	defer connectionObserver.Unlock()
+	if connectionObserver.current != nil {
+		connectionObserver.current.ConnectionClosed(kind)
+	}
+}
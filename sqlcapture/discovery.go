@@ -32,6 +32,13 @@ func DiscoverCatalog(ctx context.Context, db Database) (*airbyte.Catalog, error)
 
 	var catalog = new(airbyte.Catalog)
 	for _, table := range tables {
+		if table.Partition {
+			logrus.WithFields(logrus.Fields{
+				"table":     table.Name,
+				"namespace": table.Schema,
+			}).Debug("omitting partition from catalog")
+			continue
+		}
 		logrus.WithFields(logrus.Fields{
 			"table":      table.Name,
 			"namespace":  table.Schema,
@@ -42,24 +49,7 @@ func DiscoverCatalog(ctx context.Context, db Database) (*airbyte.Catalog, error)
 		var anchor = strings.Title(table.Schema) + strings.Title(table.Name)
 
 		// Build `properties` schemas for each table column.
-		var properties = make(map[string]*jsonschema.Type)
-		for _, column := range table.Columns {
-			var jsonType, err = db.TranslateDBToJSONType(column)
-			if err != nil {
-				logrus.WithFields(logrus.Fields{
-					"error": err,
-					"type":  column.DataType,
-				}).Warn("error translating column type to JSON schema")
-
-				// Logging an error from the connector is nice, but can be swallowed by `flowctl`.
-				// Putting an error in the generated schema is ugly, but makes the failure visible.
-				properties[column.Name] = &jsonschema.Type{
-					Description: fmt.Sprintf("ERROR: could not translate column type %q to JSON schema: %v", column.DataType, err),
-				}
-			} else {
-				properties[column.Name] = jsonType
-			}
-		}
+		var properties = columnSchemas(db, table)
 
 		// Schema.Properties is a weird OrderedMap thing, which doesn't allow for inline
 		// literal construction. Instead, use the Schema.Extras mechanism with "properties"
@@ -117,6 +107,12 @@ func DiscoverCatalog(ctx context.Context, db Database) (*airbyte.Catalog, error)
 			},
 		}
 
+		if db, ok := db.(RequiredColumnsDatabase); ok && db.RequireColumns() {
+			if required := requiredColumns(table); len(required) > 0 {
+				schema.Type.AllOf = append(schema.Type.AllOf, insertedColumnsSchema(required))
+			}
+		}
+
 		var rawSchema, err = schema.MarshalJSON()
 		if err != nil {
 			return nil, fmt.Errorf("error marshalling schema JSON: %w", err)
@@ -145,3 +141,62 @@ func DiscoverCatalog(ctx context.Context, db Database) (*airbyte.Catalog, error)
 	}
 	return catalog, err
 }
+
+// columnSchemas translates the type of each column of the table into a JSON schema.
+func columnSchemas(db Database, table TableInfo) map[string]*jsonschema.Type {
+	var properties = make(map[string]*jsonschema.Type)
+	for _, column := range table.Columns {
+		var jsonType, err = db.TranslateDBToJSONType(column)
+		if err != nil {
+			logrus.WithFields(logrus.Fields{
+				"error": err,
+				"type":  column.DataType,
+			}).Warn("error translating column type to JSON schema")
+
+			// Logging an error from the connector is nice, but can be swallowed by `flowctl`.
+			// Putting an error in the generated schema is ugly, but makes the failure visible.
+			properties[column.Name] = &jsonschema.Type{
+				Description: fmt.Sprintf("ERROR: could not translate column type %q to JSON schema: %v", column.DataType, err),
+			}
+		} else {
+			properties[column.Name] = jsonType
+		}
+	}
+	return properties
+}
+
+// requiredColumns returns the names of the columns of the table which can't be null, in the
+// table's natural order.
+func requiredColumns(table TableInfo) []string {
+	var required []string
+	for _, name := range table.ColumnNames {
+		if column, ok := table.Columns[name]; ok && !column.IsNullable {
+			required = append(required, name)
+		}
+	}
+	return required
+}
+
+// insertedColumnsSchema returns a schema which requires the given columns of the documents of
+// inserted rows. Updates and deletes aren't held to it, since their documents may only include
+// some of the columns of their rows.
+func insertedColumnsSchema(required []string) *jsonschema.Type {
+	return &jsonschema.Type{
+		Extras: map[string]interface{}{
+			"if": &jsonschema.Type{
+				Extras: map[string]interface{}{
+					"properties": map[string]*jsonschema.Type{
+						"_meta": {
+							Extras: map[string]interface{}{
+								"properties": map[string]*jsonschema.Type{
+									"op": {Enum: []interface{}{"c"}},
+								},
+							},
+						},
+					},
+				},
+			},
+			"then": &jsonschema.Type{Required: required},
+		},
+	}
+}
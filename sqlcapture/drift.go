@@ -0,0 +1,117 @@
+package sqlcapture
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+)
+
+// ColumnSchema is the discovered JSON schema of a column of a table.
+type ColumnSchema struct {
+	Name   string          `json:"name"`
+	Schema json.RawMessage `json:"schema"`
+}
+
+// discoveredSchema returns the schemas of the columns of a table, in the table's natural order.
+// It's a list rather than an object so that a merge of state updates replaces it entirely, and
+// the schemas of removed columns don't linger in the merged state.
+func discoveredSchema(db Database, table TableInfo) ([]ColumnSchema, error) {
+	var properties = columnSchemas(db, table)
+	var names = table.ColumnNames
+	if len(names) != len(properties) {
+		names = nil
+		for name := range properties {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	var schema = []ColumnSchema{}
+	for _, name := range names {
+		var bs, err = json.Marshal(properties[name])
+		if err != nil {
+			return nil, fmt.Errorf("error serializing schema of column %q: %w", name, err)
+		}
+		schema = append(schema, ColumnSchema{Name: name, Schema: bs})
+	}
+	return schema, nil
+}
+
+// diffSchemas returns the names of the columns which were added, removed, or changed between
+// two schemas of a table. Column schemas are compared as parsed JSON, since a persisted schema
+// may have been reformatted when the state was merged.
+func diffSchemas(prev, next []ColumnSchema) (added, removed, changed []string, err error) {
+	var parse = func(schema []ColumnSchema) (map[string]interface{}, error) {
+		var parsed = make(map[string]interface{})
+		for _, col := range schema {
+			var doc interface{}
+			if err := json.Unmarshal(col.Schema, &doc); err != nil {
+				return nil, fmt.Errorf("error parsing schema of column %q: %w", col.Name, err)
+			}
+			parsed[col.Name] = doc
+		}
+		return parsed, nil
+	}
+	prevColumns, err := parse(prev)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	nextColumns, err := parse(next)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	for name, schema := range nextColumns {
+		if prevSchema, ok := prevColumns[name]; !ok {
+			added = append(added, name)
+		} else if !reflect.DeepEqual(prevSchema, schema) {
+			changed = append(changed, name)
+		}
+	}
+	for name := range prevColumns {
+		if _, ok := nextColumns[name]; !ok {
+			removed = append(removed, name)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	sort.Strings(changed)
+	return added, removed, changed, nil
+}
+
+// trackSchemaDrift persists the discovered schema of a stream's table in its state, and logs
+// a notice if the columns of the table have changed since the schema was last persisted.
+func (c *Capture) trackSchemaDrift(streamID string, state *TableState) error {
+	var table, ok = c.discovery[streamID]
+	if !ok {
+		return nil
+	}
+	var schema, err = discoveredSchema(c.Database, table)
+	if err != nil {
+		return fmt.Errorf("stream %q: %w", streamID, err)
+	}
+	if state.Schema == nil {
+		state.Schema, state.dirty = schema, true
+		return nil
+	}
+
+	added, removed, changed, err := diffSchemas(state.Schema, schema)
+	if err != nil {
+		return fmt.Errorf("stream %q: %w", streamID, err)
+	}
+	if len(added) == 0 && len(removed) == 0 && len(changed) == 0 {
+		return nil
+	}
+	logrus.WithFields(logrus.Fields{
+		"event":   "schema_drift",
+		"stream":  streamID,
+		"added":   added,
+		"removed": removed,
+		"changed": changed,
+	}).Warn("schema of table has changed since the capture last started")
+	state.Schema, state.dirty = schema, true
+	return nil
+}
@@ -3,6 +3,7 @@ package sqlcapture
 import (
 	"context"
 	"encoding/json"
+	"time"
 
 	"github.com/alecthomas/jsonschema"
 	"github.com/estuary/flow/go/protocols/fdb/tuple"
@@ -27,6 +28,11 @@ const (
 	// SQL capture logic about changes to the per-table metadata JSON. It
 	// is not serialized.
 	MetadataOp ChangeOp = "m"
+	// WatermarkOp is an internal-only ChangeOp which reports a watermark
+	// written to the replication stream without using the watermarks table.
+	// Its 'after' state holds the watermark under the key "watermark". It is
+	// not serialized.
+	WatermarkOp ChangeOp = "w"
 )
 
 // SourceCommon is common source metadata for data capture events.
@@ -66,6 +72,14 @@ type SourceMetadata interface {
 	Cursor() string // TODO(wgd): Maybe json.RawMessage?
 }
 
+// PositionSource is implemented by the source metadata of databases which can describe the
+// replication position of a 'Commit' event in more detail than its cursor. The position is
+// checkpointed along with the cursor so that operators can see where the capture is, but
+// it's never used to resume replication.
+type PositionSource interface {
+	Position() json.RawMessage
+}
+
 // ChangeEvent represents either an Insert/Update/Delete operation on a specific
 // row in the database, a Commit event which indicates that the database is at
 // a consistent point from which we could restart in the future, or a Metadata
@@ -129,6 +143,131 @@ type Database interface {
 	ShouldBackfill(streamID string) bool
 }
 
+// SnapshotDatabase is an optional interface of a Database which is able to backfill
+// tables from a snapshot taken exactly where replication begins. Since such a backfill
+// is already consistent with the replication stream, it doesn't need to be interleaved
+// with replication using watermarks.
+type SnapshotDatabase interface {
+	// ExportedSnapshot returns the cursor at which the snapshot was taken, or false
+	// if StartReplication didn't take one. A snapshot is only taken when replication
+	// is started without a cursor.
+	ExportedSnapshot() (cursor string, ok bool)
+	// ReleaseSnapshot releases the snapshot once backfills no longer need it.
+	ReleaseSnapshot(ctx context.Context) error
+}
+
+// ReplicationStartDatabase is an optional interface of a Database which knows the cursor at
+// which replication began when StartReplication was called without one. The cursor is used
+// as the capture's initial cursor, so that a capture which restarts before its first commit
+// resumes from the same point.
+type ReplicationStartDatabase interface {
+	// ReplicationStart returns the cursor at which replication began, or false if it
+	// isn't known.
+	ReplicationStart() (cursor string, ok bool)
+}
+
+// StartPositionDatabase is an optional interface of a Database whose replication may begin
+// from a configured position in the past rather than the current one. Streams which skip
+// their backfills are then captured from the start of replication, so that the changes
+// between that position and the present aren't discarded by the initial catch-up stream.
+type StartPositionDatabase interface {
+	// StartPositionConfigured returns true if replication started without a cursor
+	// begins from a configured position.
+	StartPositionConfigured() bool
+}
+
+// FullRefreshDatabase is an optional interface of a Database which configures how often
+// the streams captured with the "full_refresh" sync mode are rescanned. Streams of other
+// databases are rescanned every `defaultFullRefreshInterval`.
+type FullRefreshDatabase interface {
+	FullRefreshInterval() time.Duration
+}
+
+// PeriodicSnapshotDatabase is an optional interface of a Database whose replication
+// stream doesn't include the changes of some tables. Instead of backfilling them and
+// waiting for replication events which never arrive, their streams are captured in the
+// "PeriodicSnapshot" mode by rescanning the tables every FullRefreshInterval and
+// emitting the differences from the previous scan.
+type PeriodicSnapshotDatabase interface {
+	// PeriodicSnapshot returns true if the changes of the table aren't replicated.
+	PeriodicSnapshot(info TableInfo) bool
+	// SnapshotSource returns the source metadata of the deletion events of rows which
+	// have been removed from the table since its previous snapshot.
+	SnapshotSource(info TableInfo) SourceMetadata
+}
+
+// PeriodicSnapshotLimitDatabase is an optional interface of a Database which configures the
+// most rows that a "PeriodicSnapshot" table may have. The limit of other databases is
+// `defaultMaxPeriodicSnapshotRows`.
+type PeriodicSnapshotLimitDatabase interface {
+	MaxPeriodicSnapshotRows() int
+}
+
+// SchemaDriftDatabase is an optional interface of a Database which persists the discovered
+// schemas of captured tables in the capture state, so that changes to their columns since
+// the capture last started are reported.
+type SchemaDriftDatabase interface {
+	TrackSchemaDrift() bool
+}
+
+// RequiredColumnsDatabase is an optional interface of a Database whose inserted and backfilled
+// rows always include every column of their table. The columns which can't be null are then
+// required of the documents of inserts in discovered schemas. Updates and deletes may still omit
+// them, since their documents may only include the columns which the database replicated.
+type RequiredColumnsDatabase interface {
+	RequireColumns() bool
+}
+
+// ScanKeyDatabase is an optional interface of a Database which checks that a key other
+// than the primary key of a table is suitable for scanning it. Backfills page through a
+// table in key order, resuming after the last key of each chunk, so rows whose keys are
+// the same as another's or null would be skipped.
+type ScanKeyDatabase interface {
+	// ValidateScanKey returns an error if the table can't be scanned in the order of the
+	// given key columns.
+	ValidateScanKey(ctx context.Context, info TableInfo, key []string) error
+}
+
+// ReplicaIdentityDatabase is an optional interface of a Database whose replicated updates
+// and deletes may identify rows by only some of their columns. Streams which are keyed by
+// anything other than the primary key of their table are checked before they're captured.
+type ReplicaIdentityDatabase interface {
+	// ValidateKey returns an error if the replicated changes of a table can't be keyed by
+	// the given columns. The key is empty if neither the catalog nor the table specifies
+	// one, in which case the error should explain how the table can be captured.
+	ValidateKey(ctx context.Context, info TableInfo, key []string) error
+}
+
+// PublicationDatabase is an optional interface of a Database whose replication stream only
+// includes the changes of the tables which have been published. The publication is updated
+// from the catalog before replication begins, so that newly captured streams are included.
+type PublicationDatabase interface {
+	// UpdatePublication publishes the tables of the captured streams whose changes are
+	// replicated. The tables of streams which have been removed from the catalog may also
+	// be dropped from the publication.
+	UpdatePublication(ctx context.Context, captured, removed []TableInfo) error
+}
+
+// HeartbeatDatabase is implemented by databases which should have heartbeats written to
+// their watermarks table while the replication stream is idle. Each heartbeat produces a
+// commit, so the checkpointed cursor keeps advancing even when none of the captured tables
+// change.
+type HeartbeatDatabase interface {
+	// HeartbeatInterval is how long the replication stream may be idle before a heartbeat
+	// is written. Heartbeats aren't written if it's zero.
+	HeartbeatInterval() time.Duration
+}
+
+// BackfillCheckpointDatabase is an optional interface of a Database whose backfill chunks
+// are checkpointed partway through as their rows are emitted, rather than only once the
+// whole chunk has been. A capture which restarts in the middle of a large chunk then
+// resumes the backfill after the last checkpointed row instead of rescanning the chunk.
+type BackfillCheckpointDatabase interface {
+	// BackfillCheckpointRows is the number of rows of a chunk which are emitted between
+	// checkpoints. Chunks are only checkpointed once they're complete if it's zero.
+	BackfillCheckpointRows() int
+}
+
 // ReplicationStream represents the process of receiving change events
 // from a database, managing keepalives and status updates, and translating
 // these changes into a stream of ChangeEvents.
@@ -147,6 +286,14 @@ type TableInfo struct {
 	Columns     map[string]ColumnInfo // Information about each column of the table.
 	PrimaryKey  []string              // An ordered list of the column names which together form the table's primary key.
 	ColumnNames []string              // The names of all columns, in the table's natural order.
+
+	// Partition is true if the table is a partition of another table, whose changes are
+	// captured as part of the stream of that table. It's omitted from discovered catalogs.
+	Partition bool
+
+	// Projected is true if Columns and ColumnNames only hold the columns which are captured
+	// according to the ColumnSelection of the table's stream, rather than all of its columns.
+	Projected bool
 }
 
 // ColumnInfo holds metadata about a specific column of some table in the
@@ -84,15 +84,19 @@ func AirbyteMain(spec airbyte.Spec, init func(airbyte.ConfigFile) (Database, err
 			if err := args.CatalogFile.Parse(catalog); err != nil {
 				return fmt.Errorf("unable to parse catalog: %w", err)
 			}
+			columns, err := ParseColumnSelections(args.CatalogFile)
+			if err != nil {
+				return fmt.Errorf("unable to parse column selections of catalog: %w", err)
+			}
 
-			return RunCapture(ctx, db, catalog, state, json.NewEncoder(os.Stdout))
+			return RunCapture(ctx, db, catalog, columns, state, json.NewEncoder(os.Stdout))
 		})
 }
 
 // RunCapture is the top level of the database capture process. It  is responsible for opening DB
 // connections, scanning tables, and then streaming replication events until shutdown conditions
 // (if any) are met.
-func RunCapture(ctx context.Context, db Database, catalog *airbyte.ConfiguredCatalog, state *PersistentState, dest MessageOutput) error {
+func RunCapture(ctx context.Context, db Database, catalog *airbyte.ConfiguredCatalog, columns []ColumnSelection, state *PersistentState, dest MessageOutput) error {
 	if err := db.Connect(ctx); err != nil {
 		return err
 	}
@@ -103,6 +107,7 @@ func RunCapture(ctx context.Context, db Database, catalog *airbyte.ConfiguredCat
 		State:    state,
 		Encoder:  dest,
 		Database: db,
+		Columns:  columns,
 	}
 	return c.Run(ctx)
 }
@@ -0,0 +1,141 @@
+package sqlcapture
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"sort"
+)
+
+// RowDigest is the digest of a row of a "PeriodicSnapshot" table as of the most recent
+// snapshot, which is compared with the row scanned by the next snapshot in order to tell
+// whether it has changed.
+type RowDigest struct {
+	Key    []byte `json:"key"`
+	Digest string `json:"digest"`
+}
+
+// defaultMaxPeriodicSnapshotRows is the most rows that a "PeriodicSnapshot" table may have,
+// unless the Database is a PeriodicSnapshotLimitDatabase. The digest of every row is kept in
+// the table's state, which is rewritten by every checkpoint, so this bounds the size of the state.
+const defaultMaxPeriodicSnapshotRows = 10000
+
+// maxPeriodicSnapshotRows returns the most rows that a "PeriodicSnapshot" table may have.
+func (c *Capture) maxPeriodicSnapshotRows() int {
+	if db, ok := c.Database.(PeriodicSnapshotLimitDatabase); ok && db.MaxPeriodicSnapshotRows() > 0 {
+		return db.MaxPeriodicSnapshotRows()
+	}
+	return defaultMaxPeriodicSnapshotRows
+}
+
+// rowDigest returns the digest of a scanned row. It must be computed before the row is
+// emitted, since that adds the `_meta` property to it.
+func rowDigest(row map[string]interface{}) (string, error) {
+	// Object keys are sorted when encoding a map, so equal rows have equal encodings.
+	var bs, err = json.Marshal(row)
+	if err != nil {
+		return "", err
+	}
+	var h = fnv.New128a()
+	h.Write(bs)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// diffSnapshotChunk compares a chunk of rows scanned by a snapshot of a "PeriodicSnapshot"
+// table with the digests of the rows in the previous snapshot, and emits the differences.
+// The digests of rows up to the stream's scanned key have already been replaced by this
+// snapshot, while those after it are still from the previous one. Rows which are new or
+// have changed are emitted as inserts or updates, and previous rows within the range of
+// the chunk which weren't scanned again are emitted as deletes. An empty chunk means that
+// the snapshot is complete, and so every remaining previous row was deleted. The digests
+// of the stream state are updated, and the number of emitted changes is returned.
+func (c *Capture) diffSnapshotChunk(streamID string, state *TableState, events []ChangeEvent) (int, error) {
+	// The digests are kept in key order, so they're split into those which have been
+	// refreshed by this snapshot, those of the previous snapshot which fall within the
+	// chunk, and those of the previous snapshot after it.
+	var refreshed = 0
+	if state.Scanned != nil {
+		refreshed = sort.Search(len(state.Digests), func(i int) bool {
+			return compareTuples(state.Digests[i].Key, state.Scanned) > 0
+		})
+	}
+	var previous = state.Digests[refreshed:]
+	var end = len(previous)
+	if len(events) > 0 {
+		var lastKey, err = encodeRowKey(state.KeyColumns, events[len(events)-1].After, c.Database)
+		if err != nil {
+			return 0, fmt.Errorf("error encoding row key for %q: %w", streamID, err)
+		}
+		end = sort.Search(len(previous), func(i int) bool {
+			return compareTuples(previous[i].Key, lastKey) > 0
+		})
+	}
+	// The digests are checked against the limit before any changes are emitted, so that a
+	// table which has grown too large fails the capture without its state growing too.
+	if max, rows := c.maxPeriodicSnapshotRows(), refreshed+len(events)+len(previous)-end; rows > max {
+		return 0, fmt.Errorf("table %q has more than %d rows, which is the most that can be captured by periodic snapshots", streamID, max)
+	}
+
+	var prior = make(map[string]string, end)
+	for _, row := range previous[:end] {
+		prior[string(row.Key)] = row.Digest
+	}
+
+	var changes int
+	var scanned = make([]RowDigest, 0, len(events))
+	for _, event := range events {
+		var key, err = encodeRowKey(state.KeyColumns, event.After, c.Database)
+		if err != nil {
+			return 0, fmt.Errorf("error encoding row key for %q: %w", streamID, err)
+		}
+		digest, err := rowDigest(event.After)
+		if err != nil {
+			return 0, fmt.Errorf("error computing row digest for %q: %w", streamID, err)
+		}
+		scanned = append(scanned, RowDigest{Key: key, Digest: digest})
+
+		var priorDigest, existed = prior[string(key)]
+		delete(prior, string(key))
+		if existed && priorDigest == digest {
+			continue
+		} else if existed {
+			event.Operation = UpdateOp
+		}
+		if err := c.handleChangeEvent(streamID, event); err != nil {
+			return 0, fmt.Errorf("error handling snapshot change for %q: %w", streamID, err)
+		}
+		changes++
+	}
+
+	for _, row := range previous[:end] {
+		if _, ok := prior[string(row.Key)]; !ok {
+			continue // The row was scanned again.
+		}
+		var values, err = unpackTuple(row.Key, c.Database)
+		if err != nil {
+			return 0, fmt.Errorf("error unpacking row key for %q: %w", streamID, err)
+		}
+		var before = make(map[string]interface{}, len(state.KeyColumns))
+		for i, column := range state.KeyColumns {
+			before[column] = values[i]
+		}
+		var event = ChangeEvent{
+			Operation: DeleteOp,
+			Source:    c.Database.(PeriodicSnapshotDatabase).SnapshotSource(c.discovery[streamID]),
+			Before:    before,
+		}
+		if err := c.handleChangeEvent(streamID, event); err != nil {
+			return 0, fmt.Errorf("error handling snapshot change for %q: %w", streamID, err)
+		}
+		changes++
+	}
+
+	sort.Slice(scanned, func(i, j int) bool { return compareTuples(scanned[i].Key, scanned[j].Key) < 0 })
+	var digests = make([]RowDigest, 0, refreshed+len(scanned)+len(previous)-end)
+	digests = append(digests, state.Digests[:refreshed]...)
+	digests = append(digests, scanned...)
+	digests = append(digests, previous[end:]...)
+	state.Digests = digests
+	return changes, nil
+}
@@ -0,0 +1,84 @@
+package tests
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/estuary/connectors/sqlcapture"
+)
+
+// A ConnectionGuard counts the database connections which are opened and closed
+// by a connector while it's installed, so that tests can fail if any are leaked.
+// Guards may be nested, in which case connection events are also passed along to
+// the enclosing guard.
+type ConnectionGuard struct {
+	mu   sync.Mutex
+	open map[string]int // Number of currently open connections of each kind.
+	prev sqlcapture.ConnectionObserver
+}
+
+// NewConnectionGuard installs a new ConnectionGuard. It must be released by calling
+// Release, and nested guards must be released in the reverse order of installation.
+func NewConnectionGuard() *ConnectionGuard {
+	var guard = &ConnectionGuard{open: make(map[string]int)}
+	guard.prev = sqlcapture.SetConnectionObserver(guard)
+	return guard
+}
+
+// GuardConnections installs a ConnectionGuard for the remainder of the current test,
+// which will fail the test if any connections remain open when it completes.
+func GuardConnections(t *testing.T) *ConnectionGuard {
+	t.Helper()
+	var guard = NewConnectionGuard()
+	t.Cleanup(func() { guard.Release(t) })
+	return guard
+}
+
+// ConnectionOpened implements sqlcapture.ConnectionObserver.
+func (g *ConnectionGuard) ConnectionOpened(kind string) {
+	g.mu.Lock()
+	g.open[kind]++
+	g.mu.Unlock()
+	if g.prev != nil {
+		g.prev.ConnectionOpened(kind)
+	}
+}
+
+// ConnectionClosed implements sqlcapture.ConnectionObserver.
+func (g *ConnectionGuard) ConnectionClosed(kind string) {
+	g.mu.Lock()
+	g.open[kind]--
+	g.mu.Unlock()
+	if g.prev != nil {
+		g.prev.ConnectionClosed(kind)
+	}
+}
+
+// Leaked returns a description of the connections opened since the guard was installed
+// which haven't been closed, or of any excess closes, or the empty string if there are none.
+func (g *ConnectionGuard) Leaked() string {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	var leaks []string
+	for kind, count := range g.open {
+		if count > 0 {
+			leaks = append(leaks, fmt.Sprintf("%d %s connection(s) left open", count, kind))
+		} else if count < 0 {
+			leaks = append(leaks, fmt.Sprintf("%d more %s connection(s) closed than opened", -count, kind))
+		}
+	}
+	sort.Strings(leaks)
+	return strings.Join(leaks, ", ")
+}
+
+// Release uninstalls the guard and fails the test if any connections were leaked.
+func (g *ConnectionGuard) Release(t *testing.T) {
+	t.Helper()
+	sqlcapture.SetConnectionObserver(g.prev)
+	if leaks := g.Leaked(); leaks != "" {
+		t.Errorf("database connections leaked: %s", leaks)
+	}
+}
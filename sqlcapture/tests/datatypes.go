@@ -14,11 +14,32 @@ import (
 
 // A DatatypeTestCase defines the inputs and expected outputs to a single
 // instance of the TestDatatypes test.
+//
+// The ExpectBackfillValue and ExpectReplicationValue properties may be used
+// to document known divergences between backfill and replication outputs.
+// When unset, both default to ExpectValue.
 type DatatypeTestCase struct {
 	ColumnType  string
 	ExpectType  string
 	InputValue  interface{}
 	ExpectValue string
+
+	ExpectBackfillValue    string
+	ExpectReplicationValue string
+}
+
+func (tc DatatypeTestCase) expectBackfill() string {
+	if tc.ExpectBackfillValue != "" {
+		return tc.ExpectBackfillValue
+	}
+	return tc.ExpectValue
+}
+
+func (tc DatatypeTestCase) expectReplication() string {
+	if tc.ExpectReplicationValue != "" {
+		return tc.ExpectReplicationValue
+	}
+	return tc.ExpectValue
 }
 
 // TestDatatypes runs a series of tests creating tables with specific column types
@@ -77,13 +98,13 @@ func TestDatatypes(ctx context.Context, t *testing.T, tb TestBackend, cases []Da
 				t.Run("scan", func(t *testing.T) {
 					tb.Insert(ctx, t, table, [][]interface{}{{1, tc.InputValue}})
 					var output, _ = PerformCapture(ctx, t, tb, &catalog, &state)
-					verifyRoundTrip(t, tc, output)
+					verifyRoundTrip(t, tc, tc.expectBackfill(), output)
 				})
 
 				t.Run("replication", func(t *testing.T) {
 					tb.Insert(ctx, t, table, [][]interface{}{{2, tc.InputValue}})
 					var output, _ = PerformCapture(ctx, t, tb, &catalog, &state)
-					verifyRoundTrip(t, tc, output)
+					verifyRoundTrip(t, tc, tc.expectReplication(), output)
 				})
 			})
 		})
@@ -97,7 +118,7 @@ type datatypeTestRecord struct {
 	} `json:"data"`
 }
 
-func verifyRoundTrip(t *testing.T, tc DatatypeTestCase, actual string) {
+func verifyRoundTrip(t *testing.T, tc DatatypeTestCase, expected, actual string) {
 	t.Helper()
 
 	// Extract the value record from the full output
@@ -119,9 +140,9 @@ func verifyRoundTrip(t *testing.T, tc DatatypeTestCase, actual string) {
 		return
 	}
 
-	if string(record.Data.Value) != tc.ExpectValue {
+	if string(record.Data.Value) != expected {
 		t.Errorf("result mismatch for type %q: input %q, got %q, expected %q",
-			tc.ColumnType, tc.InputValue, string(record.Data.Value), tc.ExpectValue)
+			tc.ColumnType, tc.InputValue, string(record.Data.Value), expected)
 	}
 }
 
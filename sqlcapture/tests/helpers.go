@@ -11,6 +11,7 @@ import (
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/estuary/connectors/sqlcapture"
 	"github.com/estuary/flow/go/protocols/airbyte"
@@ -33,16 +34,26 @@ func VerifiedCapture(ctx context.Context, t *testing.T, tb TestBackend, catalog
 // plus a list of all state updates. The records string is sanitized of data like timestamps
 // and LSNs which will vary across test runs, and so can be fed directly into VerifySnapshot.
 //
-// As a side effect the input state is modified to the final result state.
+// As a side effect the input state is modified to the final result state. The test fails
+// if any database connections opened by the capture are still open once it finishes.
 func PerformCapture(ctx context.Context, t *testing.T, tb TestBackend, catalog *airbyte.ConfiguredCatalog, state *sqlcapture.PersistentState) (string, []sqlcapture.PersistentState) {
 	t.Helper()
+	return PerformCaptureWithColumns(ctx, t, tb, catalog, nil, state)
+}
+
+// PerformCaptureWithColumns is like PerformCapture, but only captures the columns of each
+// stream of the catalog which are selected by the corresponding column selection.
+func PerformCaptureWithColumns(ctx context.Context, t *testing.T, tb TestBackend, catalog *airbyte.ConfiguredCatalog, columns []sqlcapture.ColumnSelection, state *sqlcapture.PersistentState) (string, []sqlcapture.PersistentState) {
+	t.Helper()
 
 	var buf = new(CaptureOutputBuffer)
 	buf.MergeBase = copyState(*state)
 	var initState = copyState(*state)
-	if err := sqlcapture.RunCapture(ctx, tb.GetDatabase(), catalog, &initState, buf); err != nil {
+	var guard = NewConnectionGuard()
+	if err := sqlcapture.RunCapture(ctx, tb.GetDatabase(), catalog, columns, &initState, buf); err != nil {
 		fmt.Fprintf(&buf.Snapshot, "\n========\n\nCapture Terminated With Error:\n\n    %s\n", err.Error())
 	}
+	guard.Release(t)
 
 	var result, states = buf.Output()
 	if len(states) > 0 {
@@ -247,16 +258,22 @@ func (buf *CaptureOutputBuffer) Encode(v interface{}) error {
 func copyState(x sqlcapture.PersistentState) sqlcapture.PersistentState {
 	var streams = make(map[string]sqlcapture.TableState)
 	for streamID, state := range x.Streams {
-		streams[streamID] = sqlcapture.TableState{
+		var copied = sqlcapture.TableState{
 			Mode:       state.Mode,
 			KeyColumns: append([]string(nil), state.KeyColumns...),
 			Scanned:    append([]byte(nil), state.Scanned...),
 			Metadata:   append([]byte(nil), state.Metadata...),
 		}
+		if state.RefreshedAt != nil {
+			var refreshedAt = *state.RefreshedAt
+			copied.RefreshedAt = &refreshedAt
+		}
+		streams[streamID] = copied
 	}
 	return sqlcapture.PersistentState{
-		Cursor:  x.Cursor,
-		Streams: streams,
+		Cursor:   x.Cursor,
+		Position: append(json.RawMessage(nil), x.Position...),
+		Streams:  streams,
 	}
 }
 
@@ -270,9 +287,17 @@ func (buf *CaptureOutputBuffer) bufferState(msg airbyte.Message) error {
 		return fmt.Errorf("error unmarshaling to PersistentState: %w", err)
 	}
 
-	// Sanitize state by rewriting the LSN to a constant, then encode
-	// back into new bytes.
-	var cleanState = sqlcapture.PersistentState{Cursor: "REDACTED", Streams: inputState.Streams}
+	// Sanitize state by rewriting the LSN to a constant and the times of
+	// full refreshes to the epoch, then encode back into new bytes.
+	var cleanStreams = make(map[string]sqlcapture.TableState)
+	for streamID, state := range inputState.Streams {
+		if state.RefreshedAt != nil {
+			var epoch = time.Unix(0, 0).UTC()
+			state.RefreshedAt = &epoch
+		}
+		cleanStreams[streamID] = state
+	}
+	var cleanState = sqlcapture.PersistentState{Cursor: "REDACTED", Streams: cleanStreams}
 	var bs, err = json.Marshal(cleanState)
 	if err != nil {
 		return fmt.Errorf("error encoding cleaned state: %w", err)
@@ -291,6 +316,9 @@ func (buf *CaptureOutputBuffer) bufferState(msg airbyte.Message) error {
 	// is done *after* duplicate suppression so that any "reset to state #N" logic
 	// in tests matches up with the actual `STATE` lines in output snapshots.
 	buf.MergeBase.Cursor = inputState.Cursor
+	if inputState.Position != nil {
+		buf.MergeBase.Position = inputState.Position
+	}
 	for streamID, state := range inputState.Streams {
 		buf.MergeBase.Streams[streamID] = state
 	}